@@ -0,0 +1,98 @@
+// Package authtest serves a JWKS and signs tokens with its keys, for tests
+// of bearer token authentication.
+package authtest
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+// JWKS serves an RSA key with key ID "rsa1" for RS256 and a P-256 key with
+// key ID "ec1" for ES256.
+type JWKS struct {
+	Server *httptest.Server
+	// Hits counts fetches of the key set.
+	Hits atomic.Int64
+	// Release, when set, holds every fetch until it is closed.
+	Release chan struct{}
+	// Failing makes fetches fail with 503.
+	Failing atomic.Bool
+
+	rsaKey *rsa.PrivateKey
+	ecKey  *ecdsa.PrivateKey
+}
+
+// NewJWKS generates the keys and starts serving them until the test ends.
+func NewJWKS(t testing.TB) *JWKS {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate RSA key: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate EC key: %v", err)
+	}
+	j := &JWKS{rsaKey: rsaKey, ecKey: ecKey}
+	enc := base64.RawURLEncoding.EncodeToString
+	j.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		j.Hits.Add(1)
+		if j.Release != nil {
+			<-j.Release
+		}
+		if j.Failing.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{
+			{"kty": "RSA", "kid": "rsa1", "alg": "RS256", "n": enc(rsaKey.N.Bytes()), "e": enc(big.NewInt(int64(rsaKey.E)).Bytes())},
+			{"kty": "EC", "kid": "ec1", "crv": "P-256", "x": enc(ecKey.X.FillBytes(make([]byte, 32))), "y": enc(ecKey.Y.FillBytes(make([]byte, 32)))},
+		}})
+	}))
+	t.Cleanup(j.Server.Close)
+	return j
+}
+
+// URL returns the address of the key set.
+func (j *JWKS) URL() string {
+	return j.Server.URL
+}
+
+// Sign returns a token with claims, signed by the RSA key for RS256 and the
+// EC key for ES256, whatever kid says.
+func (j *JWKS) Sign(t testing.TB, alg, kid string, claims map[string]any) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(input))
+
+	var sig []byte
+	var err error
+	switch alg {
+	case "RS256":
+		sig, err = rsa.SignPKCS1v15(rand.Reader, j.rsaKey, crypto.SHA256, digest[:])
+	case "ES256":
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, j.ecKey, digest[:])
+		if err == nil {
+			sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+		}
+	}
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
@@ -0,0 +1,190 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// Claims are verified attributes of the caller, typically the claims of an
+// access token.
+type Claims map[string]any
+
+// Tenant returns the "tenant" claim, or "" when it is absent.
+func (c Claims) Tenant() string {
+	tenant, _ := c["tenant"].(string)
+	return tenant
+}
+
+// Identity is the authenticated caller of a request. It is stored in the
+// request context by the middleware chain so that session creators and other
+// hooks can read it without re-parsing tokens.
+type Identity struct {
+	// Subject is the "sub" claim.
+	Subject string
+	// Tenant is the "tenant" claim.
+	Tenant string
+	// Claims holds every verified claim.
+	Claims map[string]any
+}
+
+type identityContextKey struct{}
+
+// ContextWithIdentity returns a copy of ctx carrying id.
+func ContextWithIdentity(ctx context.Context, id Identity) context.Context {
+	return context.WithValue(ctx, identityContextKey{}, id)
+}
+
+// IdentityFromContext returns the identity stored in ctx, if any.
+func IdentityFromContext(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(identityContextKey{}).(Identity)
+	return id, ok
+}
+
+// TenantFromContext returns the tenant of the identity stored in ctx, or ""
+// when there is none.
+func TenantFromContext(ctx context.Context) string {
+	id, _ := IdentityFromContext(ctx)
+	return id.Tenant
+}
+
+// ContextWithClaims returns a copy of ctx carrying the identity that claims
+// describe.
+func ContextWithClaims(ctx context.Context, claims Claims) context.Context {
+	sub, _ := claims["sub"].(string)
+	return ContextWithIdentity(ctx, Identity{Subject: sub, Tenant: claims.Tenant(), Claims: claims})
+}
+
+// ClaimsFromContext returns the claims of the identity stored in ctx, if
+// any.
+func ClaimsFromContext(ctx context.Context) (Claims, bool) {
+	id, ok := IdentityFromContext(ctx)
+	return Claims(id.Claims), ok
+}
+
+type stateTemplate struct {
+	key  string
+	tmpl *template.Template
+}
+
+// Mapper derives the ChatKit user and workflow state variables from
+// identity claims using text/template expressions such as
+// "{{.tenant}}:{{.sub}}".
+type Mapper struct {
+	user  *template.Template
+	state []stateTemplate
+}
+
+// NewMapper parses the user template and a comma-separated list of
+// key=template state variable rules. Commas inside {{ }} actions belong to
+// the template, so rules such as roles={{join .roles ","}} keep theirs. It
+// returns nil when no rules are given.
+func NewMapper(userTemplate, stateTemplates string) (*Mapper, error) {
+	if userTemplate == "" && stateTemplates == "" {
+		return nil, nil
+	}
+
+	m := &Mapper{}
+	if userTemplate != "" {
+		tmpl, err := parseClaimTemplate("user", userTemplate)
+		if err != nil {
+			return nil, err
+		}
+		m.user = tmpl
+	}
+
+	for _, rule := range splitRules(stateTemplates) {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+		key, text, ok := strings.Cut(rule, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid state variable rule %q: expected key=template", rule)
+		}
+		tmpl, err := parseClaimTemplate(key, strings.TrimSpace(text))
+		if err != nil {
+			return nil, err
+		}
+		m.state = append(m.state, stateTemplate{key: key, tmpl: tmpl})
+	}
+	sort.Slice(m.state, func(i, j int) bool { return m.state[i].key < m.state[j].key })
+	return m, nil
+}
+
+// splitRules splits spec on the commas outside template actions.
+func splitRules(spec string) []string {
+	var rules []string
+	depth, start := 0, 0
+	for i := 0; i < len(spec); i++ {
+		switch {
+		case strings.HasPrefix(spec[i:], "{{"):
+			depth++
+			i++
+		case strings.HasPrefix(spec[i:], "}}") && depth > 0:
+			depth--
+			i++
+		case spec[i] == ',' && depth == 0:
+			rules = append(rules, spec[start:i])
+			start = i + 1
+		}
+	}
+	return append(rules, spec[start:])
+}
+
+func parseClaimTemplate(name, text string) (*template.Template, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid template for %s: %w", name, err)
+	}
+	return tmpl, nil
+}
+
+// Apply derives the ChatKit user and state variables from claims.
+func (m *Mapper) Apply(claims Claims) (string, map[string]string, error) {
+	user, err := m.mapUser(claims)
+	if err != nil {
+		return "", nil, err
+	}
+	state, err := m.mapState(claims)
+	if err != nil {
+		return "", nil, err
+	}
+	return user, state, nil
+}
+
+// mapUser renders the user template. It returns an empty string when no user
+// template is configured.
+func (m *Mapper) mapUser(claims Claims) (string, error) {
+	if m.user == nil {
+		return "", nil
+	}
+	return renderClaimTemplate(m.user, claims)
+}
+
+// mapState renders every state variable template.
+func (m *Mapper) mapState(claims Claims) (map[string]string, error) {
+	if len(m.state) == 0 {
+		return nil, nil
+	}
+	state := make(map[string]string, len(m.state))
+	for _, st := range m.state {
+		v, err := renderClaimTemplate(st.tmpl, claims)
+		if err != nil {
+			return nil, err
+		}
+		state[st.key] = v
+	}
+	return state, nil
+}
+
+func renderClaimTemplate(tmpl *template.Template, claims Claims) (string, error) {
+	var b strings.Builder
+	if err := tmpl.Execute(&b, map[string]any(claims)); err != nil {
+		return "", fmt.Errorf("render %s: %w", tmpl.Name(), err)
+	}
+	return b.String(), nil
+}
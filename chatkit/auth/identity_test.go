@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"context"
+	"testing"
+)
+
+func TestMapperRendersTemplates(t *testing.T) {
+	mapper, err := NewMapper("{{.tenant}}:{{.sub}}", "tenant={{.tenant}}, plan={{.plan}}")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	user, state, err := mapper.Apply(Claims{"tenant": "acme", "sub": "42", "plan": "pro"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if user != "acme:42" {
+		t.Fatalf("expected user acme:42, got %s", user)
+	}
+	if state["tenant"] != "acme" || state["plan"] != "pro" {
+		t.Fatalf("unexpected state variables: %v", state)
+	}
+}
+
+func TestMapperKeepsCommasInsideTemplates(t *testing.T) {
+	mapper, err := NewMapper("", `pair={{printf "%s,%s" .a .b}},plan={{.plan}}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, state, err := mapper.Apply(Claims{"a": "x", "b": "y", "plan": "pro"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if state["pair"] != "x,y" || state["plan"] != "pro" {
+		t.Fatalf("unexpected state variables: %v", state)
+	}
+}
+
+func TestMapperMissingClaim(t *testing.T) {
+	mapper, err := NewMapper("{{.tenant}}:{{.sub}}", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, _, err := mapper.Apply(Claims{"sub": "42"}); err == nil {
+		t.Fatalf("expected error for missing claim")
+	}
+}
+
+func TestMapperInvalidRule(t *testing.T) {
+	if _, err := NewMapper("", "tenant"); err == nil {
+		t.Fatalf("expected error for rule without template")
+	}
+	if _, err := NewMapper("{{.sub", ""); err == nil {
+		t.Fatalf("expected error for malformed template")
+	}
+}
+
+func TestIdentityContextAccessors(t *testing.T) {
+	if _, ok := IdentityFromContext(context.Background()); ok {
+		t.Fatalf("expected no identity in empty context")
+	}
+	if tenant := TenantFromContext(context.Background()); tenant != "" {
+		t.Fatalf("expected empty tenant, got %q", tenant)
+	}
+
+	ctx := ContextWithClaims(context.Background(), Claims{"tenant": "acme", "sub": "42", "role": "admin"})
+	id, ok := IdentityFromContext(ctx)
+	if !ok || id.Subject != "42" || id.Tenant != "acme" || id.Claims["role"] != "admin" {
+		t.Fatalf("unexpected identity: %+v", id)
+	}
+	if tenant := TenantFromContext(ctx); tenant != "acme" {
+		t.Fatalf("expected tenant acme, got %q", tenant)
+	}
+}
@@ -0,0 +1,146 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"openai-chatkit-backend/chatkit/clock"
+)
+
+const (
+	// DefaultIntrospectionCacheTTL is how long answers are cached unless
+	// configured otherwise.
+	DefaultIntrospectionCacheTTL = time.Minute
+	introspectionTimeout         = 5 * time.Second
+	// maxIntrospectionCacheEntries bounds the cache, so that a flood of
+	// made-up tokens cannot grow it without limit.
+	maxIntrospectionCacheEntries = 10000
+)
+
+// Introspector validates opaque access tokens with an OAuth 2.0 token
+// introspection endpoint (RFC 7662), authenticating with client
+// credentials. Answers are cached by token hash for cacheTTL, and active
+// tokens no longer than until they expire.
+type Introspector struct {
+	endpoint     string
+	clientID     string
+	clientSecret string
+	cacheTTL     time.Duration
+	client       *http.Client
+	clock        clock.Clock
+
+	mu    sync.Mutex
+	cache map[[sha256.Size]byte]introspectionResult
+}
+
+type introspectionResult struct {
+	claims  Claims
+	err     error
+	expires time.Time
+}
+
+// NewIntrospector returns an introspector for endpoint. An empty clientID
+// sends no credentials.
+func NewIntrospector(endpoint, clientID, clientSecret string, cacheTTL time.Duration) *Introspector {
+	return &Introspector{
+		endpoint:     endpoint,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		cacheTTL:     cacheTTL,
+		client:       &http.Client{Timeout: introspectionTimeout},
+		clock:        clock.System,
+		cache:        make(map[[sha256.Size]byte]introspectionResult),
+	}
+}
+
+// ErrInactiveToken is the introspection answer for unknown, expired, or
+// revoked tokens.
+var ErrInactiveToken = errors.New("token is not active")
+
+// introspect returns the claims of an active token. Inactive tokens are
+// cached like active ones, since they cannot become active again; failures
+// to reach the endpoint are not.
+func (t *Introspector) introspect(ctx context.Context, token string) (Claims, error) {
+	key := sha256.Sum256([]byte(token))
+	now := t.clock.Now()
+	t.mu.Lock()
+	cached, ok := t.cache[key]
+	t.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.claims, cached.err
+	}
+
+	claims, err := t.call(ctx, token)
+	if err != nil && !errors.Is(err, ErrInactiveToken) {
+		return nil, err
+	}
+	expires := now.Add(t.cacheTTL)
+	if exp, ok := claims["exp"].(float64); ok && time.Unix(int64(exp), 0).Before(expires) {
+		expires = time.Unix(int64(exp), 0)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.cache) >= maxIntrospectionCacheEntries {
+		t.pruneLocked(now)
+	}
+	t.cache[key] = introspectionResult{claims: claims, err: err, expires: expires}
+	return claims, err
+}
+
+// pruneLocked drops expired answers, and every answer when none has
+// expired.
+func (t *Introspector) pruneLocked(now time.Time) {
+	for key, r := range t.cache {
+		if !now.Before(r.expires) {
+			delete(t.cache, key)
+		}
+	}
+	if len(t.cache) >= maxIntrospectionCacheEntries {
+		t.cache = make(map[[sha256.Size]byte]introspectionResult)
+	}
+}
+
+func (t *Introspector) call(ctx context.Context, token string) (Claims, error) {
+	form := url.Values{"token": {token}, "token_type_hint": {"access_token"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if t.clientID != "" {
+		// RFC 6749 section 2.3.1 form-encodes the credentials first.
+		req.SetBasicAuth(url.QueryEscape(t.clientID), url.QueryEscape(t.clientSecret))
+	}
+	res, err := t.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("introspect token: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("introspect token: unexpected status %d", res.StatusCode)
+	}
+	var claims Claims
+	if err := json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&claims); err != nil {
+		return nil, fmt.Errorf("introspect token: %w", err)
+	}
+	if active, _ := claims["active"].(bool); !active {
+		return nil, ErrInactiveToken
+	}
+	// Some providers name the user only in username.
+	if sub, _ := claims["sub"].(string); sub == "" {
+		if username, _ := claims["username"].(string); username != "" {
+			claims["sub"] = username
+		}
+	}
+	return claims, nil
+}
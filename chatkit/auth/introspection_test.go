@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"openai-chatkit-backend/chatkit/auth/authtest"
+	"openai-chatkit-backend/chatkit/clock"
+)
+
+func TestVerifierIntrospectsOpaqueTokens(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	var hits atomic.Int64
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if id, secret, ok := r.BasicAuth(); !ok || id != "chatkit" || secret != "s%3Acret" {
+			http.Error(w, "bad client", http.StatusUnauthorized)
+			return
+		}
+		switch r.PostFormValue("token") {
+		case "good":
+			json.NewEncoder(w).Encode(map[string]any{"active": true, "sub": "42", "aud": "chatkit", "exp": now.Add(time.Hour).Unix()})
+		case "username":
+			json.NewEncoder(w).Encode(map[string]any{"active": true, "username": "ada", "aud": "chatkit"})
+		case "other audience":
+			json.NewEncoder(w).Encode(map[string]any{"active": true, "sub": "42", "aud": "other"})
+		case "expired":
+			json.NewEncoder(w).Encode(map[string]any{"active": true, "sub": "42", "aud": "chatkit", "exp": now.Add(-time.Hour).Unix()})
+		case "broken":
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		default:
+			json.NewEncoder(w).Encode(map[string]any{"active": false})
+		}
+	}))
+	t.Cleanup(idp.Close)
+
+	fixed := clock.Func(func() time.Time { return now })
+	v := NewVerifier("", "", "chatkit")
+	v.clock = fixed
+	v.Introspection = NewIntrospector(idp.URL, "chatkit", "s:cret", time.Minute)
+	v.Introspection.clock = fixed
+
+	claims, err := v.Verify(context.Background(), "good")
+	if err != nil || claims["sub"] != "42" {
+		t.Fatalf("expected the token to be accepted, got %v, %v", claims, err)
+	}
+	if claims, err := v.Verify(context.Background(), "username"); err != nil || claims["sub"] != "ada" {
+		t.Fatalf("expected username to stand in for sub, got %v, %v", claims, err)
+	}
+	for _, token := range []string{"revoked", "other audience", "expired", "broken"} {
+		if _, err := v.Verify(context.Background(), token); err == nil {
+			t.Fatalf("%s: expected token to be rejected", token)
+		}
+	}
+
+	hits.Store(0)
+	for _, token := range []string{"good", "revoked", "broken"} {
+		v.Verify(context.Background(), token)
+	}
+	if got := hits.Load(); got != 1 {
+		t.Fatalf("expected only the failed call to be repeated, got %d calls", got)
+	}
+	now = now.Add(2 * time.Minute)
+	if _, err := v.Verify(context.Background(), "good"); err != nil || hits.Load() != 2 {
+		t.Fatalf("expected an expired cache entry to be refreshed, got %v after %d calls", err, hits.Load())
+	}
+}
+
+func TestVerifierIntrospectsOnlyOpaqueTokensWithJWKS(t *testing.T) {
+	jwks := authtest.NewJWKS(t)
+	var hits atomic.Int64
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		json.NewEncoder(w).Encode(map[string]any{"active": true, "sub": "opaque"})
+	}))
+	t.Cleanup(idp.Close)
+
+	v := NewVerifier(jwks.URL(), "", "")
+	v.Introspection = NewIntrospector(idp.URL, "", "", time.Minute)
+
+	token := jwks.Sign(t, "RS256", "rsa1", map[string]any{"sub": "42", "exp": time.Now().Add(time.Hour).Unix()})
+	if claims, err := v.Verify(context.Background(), token); err != nil || claims["sub"] != "42" {
+		t.Fatalf("expected the JWT to be verified locally, got %v, %v", claims, err)
+	}
+	if claims, err := v.Verify(context.Background(), "opaque-token"); err != nil || claims["sub"] != "opaque" {
+		t.Fatalf("expected the opaque token to be introspected, got %v, %v", claims, err)
+	}
+	if got := hits.Load(); got != 1 {
+		t.Fatalf("expected one introspection call, got %d", got)
+	}
+}
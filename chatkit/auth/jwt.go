@@ -0,0 +1,420 @@
+// Package auth verifies bearer tokens, with a JWKS or token introspection,
+// and carries the verified caller in the request context.
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"openai-chatkit-backend/chatkit/clock"
+)
+
+const (
+	jwksCacheTTL = time.Hour
+	// jwksMinRefresh limits how often an unknown key ID triggers a refetch,
+	// so that tokens with made-up key IDs cannot hammer the JWKS endpoint.
+	jwksMinRefresh   = time.Minute
+	jwksFetchTimeout = 5 * time.Second
+	jwtLeeway        = time.Minute
+)
+
+// jwtAlgorithms are the accepted signing algorithms. Symmetric algorithms and
+// "none" are never accepted. Each ECDSA algorithm takes keys on one curve
+// only (RFC 7518 section 3.4).
+var jwtAlgorithms = map[string]struct {
+	hash  crypto.Hash
+	kty   string
+	curve string
+}{
+	"RS256": {crypto.SHA256, "RSA", ""},
+	"RS384": {crypto.SHA384, "RSA", ""},
+	"RS512": {crypto.SHA512, "RSA", ""},
+	"ES256": {crypto.SHA256, "EC", "P-256"},
+	"ES384": {crypto.SHA384, "EC", "P-384"},
+	"ES512": {crypto.SHA512, "EC", "P-521"},
+}
+
+// jsonWebKey is one entry of a JWKS document.
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type verificationKey struct {
+	alg string
+	key crypto.PublicKey
+}
+
+// Verifier validates bearer tokens signed by a key from a JWKS endpoint
+// and checks their issuer, audience, and validity period.
+type Verifier struct {
+	// Introspection, when set, validates tokens that are not JWTs, or every
+	// token when the verifier has no JWKS URL.
+	Introspection *Introspector
+
+	jwksURL  string
+	issuer   string
+	audience string
+	client   *http.Client
+	clock    clock.Clock
+
+	mu      sync.Mutex
+	keys    map[string]verificationKey
+	fetched time.Time
+	// attempted is when the JWKS was last fetched, successfully or not,
+	// and fetchErr the error of that fetch, if it failed.
+	attempted time.Time
+	fetchErr  error
+	// refreshing is closed when the fetch in flight, if any, completes.
+	refreshing chan struct{}
+}
+
+// NewVerifier returns a verifier for tokens signed by a key from jwksURL.
+// Empty issuer or audience skip that check.
+func NewVerifier(jwksURL, issuer, audience string) *Verifier {
+	return &Verifier{
+		jwksURL:  jwksURL,
+		issuer:   issuer,
+		audience: audience,
+		client:   &http.Client{Timeout: jwksFetchTimeout},
+		clock:    clock.System,
+	}
+}
+
+// Require rejects requests without a valid bearer token and stores the
+// verified claims in the request context for identity mapping.
+func (v *Verifier) Require(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "missing bearer token", http.StatusUnauthorized)
+			return
+		}
+		claims, err := v.Verify(r.Context(), token)
+		if err != nil {
+			slog.InfoContext(r.Context(), "rejected bearer token", "error", err)
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			http.Error(w, "invalid bearer token", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(ContextWithClaims(r.Context(), claims)))
+	})
+}
+
+// Verify checks token's signature and registered claims and returns its
+// claims.
+func (v *Verifier) Verify(ctx context.Context, token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if v.Introspection != nil && (len(parts) != 3 || v.jwksURL == "") {
+		claims, err := v.Introspection.introspect(ctx, token)
+		if err != nil {
+			return nil, err
+		}
+		check := v.checkClaims
+		if _, ok := claims["exp"]; !ok {
+			// Introspection answers need not carry exp.
+			check = v.checkIdentityClaims
+		}
+		if err := check(claims); err != nil {
+			return nil, err
+		}
+		return claims, nil
+	}
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("invalid header: %w", err)
+	}
+	alg, ok := jwtAlgorithms[header.Alg]
+	if !ok {
+		return nil, fmt.Errorf("unsupported algorithm %q", header.Alg)
+	}
+	key, err := v.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if key.alg != "" && key.alg != header.Alg {
+		return nil, fmt.Errorf("key %q is for %s, token uses %s", header.Kid, key.alg, header.Alg)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("invalid signature encoding")
+	}
+	h := alg.hash.New()
+	h.Write([]byte(parts[0] + "." + parts[1]))
+	if err := verifyJWTSignature(key.key, alg.kty, alg.curve, alg.hash, h.Sum(nil), sig); err != nil {
+		return nil, err
+	}
+
+	var claims Claims
+	if err := decodeJWTSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("invalid claims: %w", err)
+	}
+	if err := v.checkClaims(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (v *Verifier) checkClaims(claims Claims) error {
+	now := v.clock.Now()
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return errors.New("token has no exp claim")
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(jwtLeeway)) {
+		return errors.New("token has expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(jwtLeeway).Before(time.Unix(int64(nbf), 0)) {
+		return errors.New("token is not valid yet")
+	}
+	return v.checkIdentityClaims(claims)
+}
+
+// checkIdentityClaims checks the issuer, audience, and subject claims.
+func (v *Verifier) checkIdentityClaims(claims Claims) error {
+	if v.issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != v.issuer {
+			return fmt.Errorf("unexpected issuer %q", iss)
+		}
+	}
+	if v.audience != "" && !jwtAudienceContains(claims["aud"], v.audience) {
+		return fmt.Errorf("token is not for audience %q", v.audience)
+	}
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return errors.New("token has no sub claim")
+	}
+	return nil
+}
+
+// jwtAudienceContains reports whether aud, a string or an array of strings,
+// includes audience.
+func jwtAudienceContains(aud any, audience string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == audience
+	case []any:
+		for _, a := range aud {
+			if a == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// FetchedAt returns when the JWKS was last fetched, or zero before the first
+// token arrives.
+func (v *Verifier) FetchedAt() time.Time {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.fetched
+}
+
+// key returns the verification key for kid, refetching the JWKS when the
+// cache is stale or the key is unknown. Only one fetch runs at a time, and
+// requests arriving meanwhile wait for its result rather than fetching
+// again. Fetches, failed ones included, are at least jwksMinRefresh apart;
+// until the next one, stale keys stay in use.
+func (v *Verifier) key(ctx context.Context, kid string) (verificationKey, error) {
+	for {
+		v.mu.Lock()
+		now := v.clock.Now()
+		key, ok := v.keys[kid]
+		if ok && now.Sub(v.fetched) < jwksCacheTTL {
+			v.mu.Unlock()
+			return key, nil
+		}
+		if now.Sub(v.attempted) < jwksMinRefresh {
+			err := v.fetchErr
+			v.mu.Unlock()
+			switch {
+			case ok:
+				return key, nil
+			case err != nil:
+				return verificationKey{}, fmt.Errorf("fetch JWKS: %w", err)
+			}
+			return verificationKey{}, fmt.Errorf("unknown key %q", kid)
+		}
+		if wait := v.refreshing; wait != nil {
+			v.mu.Unlock()
+			select {
+			case <-wait:
+				continue
+			case <-ctx.Done():
+				return verificationKey{}, ctx.Err()
+			}
+		}
+		done := make(chan struct{})
+		v.refreshing = done
+		v.mu.Unlock()
+
+		// The fetch serves every waiting request, so it must not end
+		// with this one; the client timeout bounds it.
+		keys, err := v.fetch(context.WithoutCancel(ctx))
+
+		v.mu.Lock()
+		v.attempted, v.fetchErr = v.clock.Now(), err
+		if err == nil {
+			v.keys, v.fetched = keys, v.attempted
+		} else {
+			slog.Warn("failed to refresh JWKS", "error", err, "cached_keys", len(v.keys))
+		}
+		v.refreshing = nil
+		close(done)
+		v.mu.Unlock()
+	}
+}
+
+func (v *Verifier) fetch(ctx context.Context) (map[string]verificationKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.jwksURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := v.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", res.StatusCode)
+	}
+	var doc struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]verificationKey, len(doc.Keys))
+	for _, k := range doc.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		pub, err := k.publicKey()
+		if err != nil {
+			slog.Warn("skipping JWKS key", "kid", k.Kid, "error", err)
+			continue
+		}
+		keys[k.Kid] = verificationKey{alg: k.Alg, key: pub}
+	}
+	return keys, nil
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeJWKInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeJWKInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || n.BitLen() < 2048 {
+			return nil, errors.New("RSA keys must have at least 2048 bits")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeJWKInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeJWKInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+func verifyJWTSignature(key crypto.PublicKey, kty, curve string, hash crypto.Hash, digest, sig []byte) error {
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		if kty != "RSA" {
+			break
+		}
+		if err := rsa.VerifyPKCS1v15(pub, hash, digest, sig); err != nil {
+			return errors.New("invalid signature")
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		if kty != "EC" {
+			break
+		}
+		if name := pub.Curve.Params().Name; name != curve {
+			return fmt.Errorf("key curve %s does not match the token algorithm, which requires %s", name, curve)
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return errors.New("invalid signature")
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	}
+	return errors.New("key type does not match the token algorithm")
+}
+
+func decodeJWTSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func decodeJWKInt(s string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(data) == 0 {
+		return nil, errors.New("invalid key parameter")
+	}
+	return new(big.Int).SetBytes(data), nil
+}
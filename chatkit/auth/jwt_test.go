@@ -0,0 +1,156 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"openai-chatkit-backend/chatkit/auth/authtest"
+	"openai-chatkit-backend/chatkit/clock"
+)
+
+func TestVerifierChecksTokens(t *testing.T) {
+	jwks := authtest.NewJWKS(t)
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	v := NewVerifier(jwks.URL(), "https://idp.example.com", "chatkit")
+	v.clock = clock.Func(func() time.Time { return now })
+
+	valid := func() map[string]any {
+		return map[string]any{"sub": "42", "iss": "https://idp.example.com", "aud": []string{"other", "chatkit"}, "exp": now.Add(time.Hour).Unix()}
+	}
+
+	for _, alg := range []struct{ name, kid string }{{"RS256", "rsa1"}, {"ES256", "ec1"}} {
+		claims, err := v.Verify(context.Background(), jwks.Sign(t, alg.name, alg.kid, valid()))
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", alg.name, err)
+		}
+		if claims["sub"] != "42" {
+			t.Fatalf("%s: unexpected claims %v", alg.name, claims)
+		}
+	}
+
+	expired := valid()
+	expired["exp"] = now.Add(-2 * time.Minute).Unix()
+	wrongIssuer := valid()
+	wrongIssuer["iss"] = "https://evil.example.com"
+	wrongAudience := valid()
+	wrongAudience["aud"] = "other"
+	noSub := valid()
+	delete(noSub, "sub")
+	tampered := jwks.Sign(t, "RS256", "rsa1", valid())
+	parts := strings.Split(tampered, ".")
+	forged, _ := json.Marshal(map[string]any{"sub": "admin", "exp": now.Add(time.Hour).Unix()})
+	parts[1] = base64.RawURLEncoding.EncodeToString(forged)
+
+	for name, token := range map[string]string{
+		"expired":          jwks.Sign(t, "RS256", "rsa1", expired),
+		"wrong issuer":     jwks.Sign(t, "RS256", "rsa1", wrongIssuer),
+		"wrong audience":   jwks.Sign(t, "RS256", "rsa1", wrongAudience),
+		"no sub":           jwks.Sign(t, "RS256", "rsa1", noSub),
+		"tampered claims":  strings.Join(parts, "."),
+		"key/alg mismatch": jwks.Sign(t, "ES256", "rsa1", valid()),
+		"unknown key":      jwks.Sign(t, "RS256", "missing", valid()),
+		"none algorithm":   base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." + parts[1] + ".",
+	} {
+		if _, err := v.Verify(context.Background(), token); err == nil {
+			t.Fatalf("%s: expected token to be rejected", name)
+		}
+	}
+	if hits := jwks.Hits.Load(); hits != 1 {
+		t.Fatalf("expected the JWKS to be fetched once, got %d", hits)
+	}
+}
+
+func TestVerifierSharesAndBacksOffJWKSFetches(t *testing.T) {
+	jwks := authtest.NewJWKS(t)
+	jwks.Release = make(chan struct{})
+	var mu sync.Mutex
+	now := time.Now()
+	v := NewVerifier(jwks.URL(), "", "")
+	v.clock = clock.Func(func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	})
+	token := jwks.Sign(t, "RS256", "rsa1", map[string]any{"sub": "42", "exp": now.Add(3 * time.Hour).Unix()})
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := v.Verify(context.Background(), token)
+			errs <- err
+		}()
+	}
+	for jwks.Hits.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	close(jwks.Release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if hits := jwks.Hits.Load(); hits != 1 {
+		t.Fatalf("expected concurrent requests to share one fetch, got %d", hits)
+	}
+
+	jwks.Failing.Store(true)
+	mu.Lock()
+	now = now.Add(jwksCacheTTL + time.Second)
+	mu.Unlock()
+	for range 5 {
+		if _, err := v.Verify(context.Background(), token); err != nil {
+			t.Fatalf("expected the cached key to be used during the outage, got %v", err)
+		}
+	}
+	if hits := jwks.Hits.Load(); hits != 2 {
+		t.Fatalf("expected a single refresh attempt within jwksMinRefresh, got %d fetches", hits)
+	}
+	if _, err := v.Verify(context.Background(), jwks.Sign(t, "ES256", "missing", map[string]any{"sub": "42", "exp": now.Add(3 * time.Hour).Unix()})); err == nil || jwks.Hits.Load() != 2 {
+		t.Fatalf("expected an unknown key to fail without a refetch, got %v after %d fetches", err, jwks.Hits.Load())
+	}
+}
+
+func TestVerifierRejectsECKeysOnTheWrongCurve(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate EC key: %v", err)
+	}
+	enc := base64.RawURLEncoding.EncodeToString
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{
+			{"kty": "EC", "kid": "ec521", "crv": "P-521", "x": enc(key.X.FillBytes(make([]byte, 66))), "y": enc(key.Y.FillBytes(make([]byte, 66)))},
+		}})
+	}))
+	defer srv.Close()
+	v := NewVerifier(srv.URL, "", "")
+
+	header, _ := json.Marshal(map[string]string{"alg": "ES256", "kid": "ec521"})
+	payload, _ := json.Marshal(map[string]any{"sub": "42", "exp": time.Now().Add(time.Hour).Unix()})
+	input := enc(header) + "." + enc(payload)
+	digest := sha256.Sum256([]byte(input))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+	sig := append(r.FillBytes(make([]byte, 66)), s.FillBytes(make([]byte, 66))...)
+
+	if _, err := v.Verify(context.Background(), input+"."+enc(sig)); err == nil || !strings.Contains(err.Error(), "P-256") {
+		t.Fatalf("expected an ES256 token signed with a P-521 key to be rejected, got %v", err)
+	}
+}
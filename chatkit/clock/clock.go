@@ -0,0 +1,60 @@
+// Package clock holds the time and randomness sources that the chatkit
+// packages take as dependencies, so embedders and tests can substitute
+// their own.
+package clock
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// Clock tells the time for expiry calculations, caches, quotas, and retry
+// schedules. Embedders and tests can substitute their own to make
+// time-dependent behaviour deterministic.
+type Clock interface {
+	Now() time.Time
+}
+
+// Func adapts a function to a Clock.
+type Func func() time.Time
+
+func (f Func) Now() time.Time { return f() }
+
+// System is the wall clock.
+var System Clock = Func(time.Now)
+
+// Rand is the source of randomness for traffic sampling and retry jitter.
+// It need not be cryptographically secure; secrets and IDs always come from
+// crypto/rand.
+type Rand interface {
+	// Float64 returns a number in [0.0, 1.0).
+	Float64() float64
+}
+
+// RandFunc adapts a function to a Rand.
+type RandFunc func() float64
+
+func (f RandFunc) Float64() float64 { return f() }
+
+// SystemRand draws from the shared math/rand source.
+var SystemRand Rand = RandFunc(rand.Float64)
+
+// Jitter spreads d uniformly over [d/2, d) so that retries scheduled at the
+// same moment do not fire together.
+func Jitter(d time.Duration, rnd Rand) time.Duration {
+	half := d / 2
+	return half + time.Duration(rnd.Float64()*float64(d-half))
+}
+
+// Sleep waits for d and reports whether ctx was still live.
+func Sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
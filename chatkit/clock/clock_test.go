@@ -0,0 +1,33 @@
+package clock
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestJitterSpreadsOverUpperHalf(t *testing.T) {
+	for _, tc := range []struct {
+		sample float64
+		want   time.Duration
+	}{
+		{0, 4 * time.Second},
+		{0.5, 6 * time.Second},
+		{0.999, 7*time.Second + 996*time.Millisecond},
+	} {
+		if got := Jitter(8*time.Second, RandFunc(func() float64 { return tc.sample })); got != tc.want {
+			t.Fatalf("jitter with sample %v: expected %s, got %s", tc.sample, tc.want, got)
+		}
+	}
+}
+
+func TestSleepStopsWhenCancelled(t *testing.T) {
+	if !Sleep(context.Background(), time.Millisecond) {
+		t.Fatal("expected the sleep to complete")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if Sleep(ctx, time.Hour) {
+		t.Fatal("expected a cancelled sleep to report it")
+	}
+}
@@ -0,0 +1,86 @@
+// Package config reads the server's settings from the environment and an
+// optional profile-based config file, and records the values it resolves so
+// that the effective configuration can be logged at startup. The Int64,
+// Float, and Require helpers return an error for a missing or malformed
+// setting rather than exiting, so the caller decides how to fail.
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Lookup reads key from the environment, falling back to the config file
+// loaded by LoadProfile, and reports where the value came from.
+func Lookup(key string) (string, string) {
+	if v := os.Getenv(key); v != "" {
+		return v, sourceEnv
+	}
+	if v := fileValues[key]; v != "" {
+		return v, sourceFile
+	}
+	return "", ""
+}
+
+// Get returns the value of key, or fallback when it is not set.
+func Get(key, fallback string) string {
+	if v, source := Lookup(key); v != "" {
+		effectiveConfig.record(key, v, fallback, source)
+		return v
+	}
+	effectiveConfig.record(key, fallback, fallback, sourceDefault)
+	return fallback
+}
+
+// Require returns the value of key, or an error when it is not set.
+func Require(key string) (string, error) {
+	if v, source := Lookup(key); v != "" {
+		effectiveConfig.record(key, v, "", source)
+		return v, nil
+	}
+	return "", fmt.Errorf("%s is required", key)
+}
+
+// RequireInt64 returns the integer value of key, or an error when it is not
+// set or not an integer.
+func RequireInt64(key string) (int64, error) {
+	v, err := Require(key)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be an integer: %w", key, err)
+	}
+	return n, nil
+}
+
+// Int64 returns the integer value of key, or fallback when it is not set.
+// It returns an error when the value is not an integer.
+func Int64(key string, fallback int64) (int64, error) {
+	v := Get(key, strconv.FormatInt(fallback, 10))
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be an integer: %w", key, err)
+	}
+	return n, nil
+}
+
+// Float returns the numeric value of key, or fallback when it is not set.
+// It returns an error when the value is not a number.
+func Float(key string, fallback float64) (float64, error) {
+	v := Get(key, strconv.FormatFloat(fallback, 'g', -1, 64))
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be a number: %w", key, err)
+	}
+	return n, nil
+}
+
+// Bool reports whether key is set to 1, true, or yes.
+func Bool(key string) bool {
+	v := strings.ToLower(Get(key, "false"))
+	return v == "1" || v == "true" || v == "yes"
+}
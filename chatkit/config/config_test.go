@@ -0,0 +1,35 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHelpersReturnErrorsForInvalidSettings(t *testing.T) {
+	t.Setenv("CHATKIT_TEST_MISSING", "")
+	t.Setenv("CHATKIT_TEST_WORD", "ten")
+	t.Setenv("CHATKIT_TEST_NUMBER", "10")
+
+	if _, err := Require("CHATKIT_TEST_MISSING"); err == nil || !strings.Contains(err.Error(), "CHATKIT_TEST_MISSING is required") {
+		t.Fatalf("expected a missing setting error, got %v", err)
+	}
+	if _, err := RequireInt64("CHATKIT_TEST_WORD"); err == nil || !strings.Contains(err.Error(), "must be an integer") {
+		t.Fatalf("expected an integer error, got %v", err)
+	}
+	if _, err := Int64("CHATKIT_TEST_WORD", 1); err == nil {
+		t.Fatal("expected an integer error")
+	}
+	if _, err := Float("CHATKIT_TEST_WORD", 1); err == nil || !strings.Contains(err.Error(), "must be a number") {
+		t.Fatalf("expected a number error, got %v", err)
+	}
+
+	if n, err := RequireInt64("CHATKIT_TEST_NUMBER"); err != nil || n != 10 {
+		t.Fatalf("expected 10, got %d: %v", n, err)
+	}
+	if n, err := Int64("CHATKIT_TEST_MISSING", 7); err != nil || n != 7 {
+		t.Fatalf("expected the fallback 7, got %d: %v", n, err)
+	}
+	if f, err := Float("CHATKIT_TEST_NUMBER", 0); err != nil || f != 10 {
+		t.Fatalf("expected 10, got %g: %v", f, err)
+	}
+}
@@ -0,0 +1,101 @@
+package config
+
+import (
+	"encoding/json"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	sourceEnv     = "env"
+	sourceDefault = "default"
+)
+
+// redactedValue replaces masked values, as it does in the server's logs.
+const redactedValue = "[REDACTED]"
+
+// secretConfigKeyParts mark configuration keys whose values are masked in
+// the effective configuration dump. Inline JSON settings, such as tenants,
+// carry keys, OTLP headers usually carry collector credentials, and Redis
+// URLs carry the Redis password.
+var secretConfigKeyParts = []string{"API_KEY", "SECRET", "TOKEN", "PASSWORD", "_JSON", "OTLP_HEADERS", "REDIS_URL"}
+
+// configEntry is one setting as the server resolved it.
+type configEntry struct {
+	Key     string `json:"key"`
+	Value   string `json:"value"`
+	Source  string `json:"source"`
+	Default string `json:"default,omitempty"`
+}
+
+// configRecorder remembers every setting read through this package so
+// that the effective configuration can be logged at startup.
+type configRecorder struct {
+	mu      sync.Mutex
+	entries map[string]configEntry
+}
+
+// effectiveConfig records the settings read by the helpers in this package.
+var effectiveConfig = &configRecorder{entries: make(map[string]configEntry)}
+
+// record notes that key resolved to value from source. fallback is the
+// built-in default, if any.
+func (c *configRecorder) record(key, value, fallback, source string) {
+	entry := configEntry{Key: key, Value: value, Source: source, Default: fallback}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = entry
+}
+
+// snapshot returns the recorded settings sorted by key, with secrets masked.
+func (c *configRecorder) snapshot() []configEntry {
+	c.mu.Lock()
+	entries := make([]configEntry, 0, len(c.entries))
+	for _, e := range c.entries {
+		entries = append(entries, e)
+	}
+	c.mu.Unlock()
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+	for i := range entries {
+		entries[i].Value = maskConfigValue(entries[i].Key, entries[i].Value)
+		entries[i].Default = maskConfigValue(entries[i].Key, entries[i].Default)
+	}
+	return entries
+}
+
+// Dump renders every setting read so far as one JSON record, with secrets
+// masked. fingerprint identifies the configuration across replicas.
+func Dump(fingerprint string) string {
+	return effectiveConfig.dump(fingerprint)
+}
+
+// dump renders the effective configuration as one JSON record.
+func (c *configRecorder) dump(fingerprint string) string {
+	data, err := json.Marshal(struct {
+		Fingerprint string        `json:"fingerprint"`
+		Settings    []configEntry `json:"settings"`
+	}{fingerprint, c.snapshot()})
+	if err != nil {
+		return "{}"
+	}
+	return string(data)
+}
+
+// maskConfigValue hides secret values and any credentials embedded in URLs.
+func maskConfigValue(key, value string) string {
+	if value == "" {
+		return ""
+	}
+	for _, part := range secretConfigKeyParts {
+		if strings.Contains(key, part) {
+			return redactedValue
+		}
+	}
+	if u, err := url.Parse(value); err == nil && u.User != nil {
+		return u.Redacted()
+	}
+	return value
+}
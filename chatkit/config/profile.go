@@ -0,0 +1,219 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	sourceFile = "file"
+	sourceFlag = "flag"
+)
+
+// configProfile is one [section] of a config file.
+type configProfile struct {
+	parent string
+	values map[string]string
+}
+
+// configFile holds settings shared by every environment plus named profiles
+// that override them:
+//
+//	CHATKIT_WORKFLOW_ID=wf_prod
+//	CORS_ALLOWED_ORIGINS=https://app.example.com
+//
+//	[staging]
+//	CHATKIT_WORKFLOW_ID=wf_staging
+//
+//	[staging-eu : staging]
+//	CORS_ALLOWED_ORIGINS=https://eu.staging.example.com
+//
+// Lines use the env file format of parseEnvFile. A profile inherits from the
+// shared settings, or from the profile named after the colon.
+type configFile struct {
+	shared   map[string]string
+	profiles map[string]configProfile
+}
+
+func parseConfigFile(data []byte) (*configFile, error) {
+	f := &configFile{profiles: make(map[string]configProfile)}
+	var sections []string
+	var bodies [][]byte
+	var body bytes.Buffer
+	current := ""
+
+	flush := func() {
+		sections = append(sections, current)
+		bodies = append(bodies, append([]byte(nil), body.Bytes()...))
+		body.Reset()
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(text, "[") {
+			body.WriteString(scanner.Text())
+			body.WriteByte('\n')
+			continue
+		}
+		if !strings.HasSuffix(text, "]") {
+			return nil, fmt.Errorf("line %d: unterminated profile header %q", line, text)
+		}
+		flush()
+		current = strings.TrimSpace(text[1 : len(text)-1])
+		if current == "" {
+			return nil, fmt.Errorf("line %d: empty profile name", line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	flush()
+
+	for i, header := range sections {
+		values := make(map[string]string)
+		for _, kv := range ParseEnvFile(bodies[i]) {
+			key, value, _ := strings.Cut(kv, "=")
+			values[key] = value
+		}
+		if i == 0 {
+			f.shared = values
+			continue
+		}
+		name, parent, _ := strings.Cut(header, ":")
+		name, parent = strings.TrimSpace(name), strings.TrimSpace(parent)
+		if _, dup := f.profiles[name]; dup {
+			return nil, fmt.Errorf("profile %q is defined more than once", name)
+		}
+		f.profiles[name] = configProfile{parent: parent, values: values}
+	}
+	return f, nil
+}
+
+// resolve returns the settings for profile, applying each ancestor's
+// overrides on top of the shared settings. An empty profile selects the
+// shared settings alone.
+func (f *configFile) resolve(profile string) (map[string]string, error) {
+	var chain []configProfile
+	seen := make(map[string]bool)
+	for name := profile; name != ""; {
+		if seen[name] {
+			return nil, fmt.Errorf("profile %q inherits from itself", name)
+		}
+		seen[name] = true
+		p, ok := f.profiles[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown profile %q", name)
+		}
+		chain = append(chain, p)
+		name = p.parent
+	}
+
+	values := make(map[string]string, len(f.shared))
+	for k, v := range f.shared {
+		values[k] = v
+	}
+	for i := len(chain) - 1; i >= 0; i-- {
+		for k, v := range chain[i].values {
+			values[k] = v
+		}
+	}
+	return values, nil
+}
+
+// fileValues are the settings loaded from --config. Environment
+// variables take precedence over them.
+var fileValues map[string]string
+
+// LoadProfile parses the server flags and loads the selected profile
+// from the config file, if one is given. CHATKIT_CONFIG_FILE and
+// CHATKIT_PROFILE stand in for the flags.
+func LoadProfile(args []string, stderr io.Writer) error {
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	path := fs.String("config", os.Getenv("CHATKIT_CONFIG_FILE"), "config file with shared settings and [profile] sections")
+	profile := fs.String("profile", os.Getenv("CHATKIT_PROFILE"), "profile to load from the config file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+
+	flagSet := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { flagSet[f.Name] = true })
+	recordFlag := func(name, key, value string) {
+		if value == "" {
+			return
+		}
+		source := sourceEnv
+		if flagSet[name] {
+			source = sourceFlag
+		}
+		effectiveConfig.record(key, value, "", source)
+	}
+	recordFlag("config", "CHATKIT_CONFIG_FILE", *path)
+	recordFlag("profile", "CHATKIT_PROFILE", *profile)
+
+	if *path == "" {
+		if *profile != "" {
+			return fmt.Errorf("--profile %s requires --config", *profile)
+		}
+		return nil
+	}
+	data, err := os.ReadFile(*path)
+	if err != nil {
+		return err
+	}
+	file, err := parseConfigFile(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", *path, err)
+	}
+	values, err := file.resolve(*profile)
+	if err != nil {
+		return fmt.Errorf("%s: %w", *path, err)
+	}
+	fileValues = values
+	return nil
+}
+
+// FileEnviron returns the config file settings as KEY=VALUE pairs.
+func FileEnviron() []string {
+	environ := make([]string, 0, len(fileValues))
+	for k, v := range fileValues {
+		environ = append(environ, k+"="+v)
+	}
+	return environ
+}
+
+// ParseEnvFile reads a KEY=VALUE file in the format used by docker
+// --env-file and systemd EnvironmentFile. Blank lines and # comments are
+// ignored; an "export " prefix and surrounding quotes are stripped.
+func ParseEnvFile(data []byte) []string {
+	var environ []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		environ = append(environ, strings.TrimSpace(key)+"="+value)
+	}
+	return environ
+}
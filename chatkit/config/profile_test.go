@@ -0,0 +1,86 @@
+package config
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testConfigFile = `# shared
+CHATKIT_WORKFLOW_ID=wf_prod
+CORS_ALLOWED_ORIGINS=https://app.example.com
+CHATKIT_RATE_LIMIT_PER_MINUTE=10
+
+[staging]
+CHATKIT_WORKFLOW_ID=wf_staging
+
+[staging-eu : staging]
+CORS_ALLOWED_ORIGINS="https://eu.staging.example.com"
+`
+
+func TestConfigFileResolvesProfileInheritance(t *testing.T) {
+	f, err := parseConfigFile([]byte(testConfigFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	shared, err := f.resolve("")
+	if err != nil || shared["CHATKIT_WORKFLOW_ID"] != "wf_prod" {
+		t.Fatalf("unexpected shared settings %v: %v", shared, err)
+	}
+	eu, err := f.resolve("staging-eu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if eu["CHATKIT_WORKFLOW_ID"] != "wf_staging" || eu["CORS_ALLOWED_ORIGINS"] != "https://eu.staging.example.com" || eu["CHATKIT_RATE_LIMIT_PER_MINUTE"] != "10" {
+		t.Fatalf("unexpected staging-eu settings: %v", eu)
+	}
+	if _, err := f.resolve("prod"); err == nil || !strings.Contains(err.Error(), "unknown profile") {
+		t.Fatalf("expected unknown profile error, got %v", err)
+	}
+}
+
+func TestParseConfigFileRejectsBadProfiles(t *testing.T) {
+	for name, data := range map[string]string{
+		"unterminated": "[staging\n",
+		"empty":        "[]\n",
+		"duplicate":    "[a]\n[a]\n",
+	} {
+		if _, err := parseConfigFile([]byte(data)); err == nil {
+			t.Fatalf("%s: expected error", name)
+		}
+	}
+
+	f, err := parseConfigFile([]byte("[a : b]\n[b : a]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.resolve("a"); err == nil || !strings.Contains(err.Error(), "inherits from itself") {
+		t.Fatalf("expected cycle error, got %v", err)
+	}
+}
+
+func TestLoadConfigProfile(t *testing.T) {
+	defer func() { fileValues = nil }()
+	path := filepath.Join(t.TempDir(), "chatkit.conf")
+	if err := os.WriteFile(path, []byte(testConfigFile), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := LoadProfile([]string{"--profile", "staging"}, io.Discard); err == nil {
+		t.Fatalf("expected --profile without --config to fail")
+	}
+	if err := LoadProfile([]string{"--config", path, "--profile", "staging"}, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if v, source := Lookup("CHATKIT_WORKFLOW_ID"); v != "wf_staging" || source != sourceFile {
+		t.Fatalf("expected workflow from the staging profile, got %q from %q", v, source)
+	}
+
+	t.Setenv("CHATKIT_WORKFLOW_ID", "wf_env")
+	if v, source := Lookup("CHATKIT_WORKFLOW_ID"); v != "wf_env" || source != sourceEnv {
+		t.Fatalf("expected environment to override the file, got %q from %q", v, source)
+	}
+}
@@ -0,0 +1,418 @@
+// Package cors answers CORS preflights and restricts browser requests to
+// allowed origins, which may be exact origins, wildcard subdomains, loopback
+// port ranges, or anchored regular expressions.
+package cors
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+const (
+	defaultAllowHeaders = "Content-Type, Authorization"
+	// DefaultMaxAge is how long, in seconds, browsers and shared caches may
+	// keep a preflight response unless WithMaxAge says otherwise.
+	DefaultMaxAge = 600
+)
+
+// preflightVary lists the request headers a preflight response depends on,
+// so that shared caches such as CDNs key cached preflights on all of them.
+const preflightVary = "Origin, Access-Control-Request-Method, Access-Control-Request-Headers"
+
+// Policy decides which origins may call the server and which headers they
+// may send and read. Its methods return modified copies.
+type Policy struct {
+	allowAll      bool
+	origins       map[string]struct{}
+	patterns      []Pattern
+	allowHeaders  string
+	exposeHeaders string
+	maxAge        int64
+	credentials   bool
+	fetchMetadata bool
+}
+
+// NewPolicy parses a comma-separated list of allowed origins in the
+// CORS_ALLOWED_ORIGINS syntax. An empty list or "*" allows every origin.
+// Invalid patterns are skipped; CheckOrigins reports them.
+func NewPolicy(allowedOrigins string) Policy {
+	if allowedOrigins == "" || allowedOrigins == "*" {
+		return Policy{allowAll: true, allowHeaders: defaultAllowHeaders, maxAge: DefaultMaxAge}
+	}
+
+	policy := Policy{origins: make(map[string]struct{}), allowHeaders: defaultAllowHeaders, maxAge: DefaultMaxAge}
+	for _, origin := range strings.Split(allowedOrigins, ",") {
+		origin = strings.TrimSpace(origin)
+		if origin == "" {
+			continue
+		}
+		if !IsPattern(origin) {
+			policy.origins[origin] = struct{}{}
+			continue
+		}
+		// Invalid patterns are reported by CheckOrigins at startup.
+		if p, err := ParsePattern(origin); err == nil {
+			policy.patterns = append(policy.patterns, p)
+		}
+	}
+	if len(policy.origins) == 0 && len(policy.patterns) == 0 {
+		policy.allowAll = true
+	}
+	return policy
+}
+
+// WithAllowedHeaders returns a copy of the policy that also lets browsers
+// send the given request headers.
+func (p Policy) WithAllowedHeaders(names ...string) Policy {
+	if len(names) == 0 {
+		return p
+	}
+	p.allowHeaders += ", " + strings.Join(names, ", ")
+	return p
+}
+
+// WithHeaders returns a copy of the policy that also allows and exposes the
+// given request headers.
+func (p Policy) WithHeaders(names ...string) Policy {
+	if len(names) == 0 {
+		return p
+	}
+	return p.WithAllowedHeaders(names...).WithExposedHeaders(names...)
+}
+
+// WithExposedHeaders returns a copy of the policy that lets browsers read
+// the given response headers.
+func (p Policy) WithExposedHeaders(names ...string) Policy {
+	if len(names) == 0 {
+		return p
+	}
+	if p.exposeHeaders != "" {
+		p.exposeHeaders += ", "
+	}
+	p.exposeHeaders += strings.Join(names, ", ")
+	return p
+}
+
+// WithMaxAge returns a copy of the policy whose preflight responses may be
+// cached by browsers and shared caches for seconds. Zero disables caching.
+func (p Policy) WithMaxAge(seconds int64) Policy {
+	p.maxAge = seconds
+	return p
+}
+
+// setPreflightCaching sets the headers that let browsers and CDNs cache a
+// preflight response. Responses are keyed on preflightVary so that a CDN
+// never serves one origin's preflight to another.
+func (p Policy) setPreflightCaching(headers http.Header) {
+	headers.Set("Vary", preflightVary)
+	if p.maxAge <= 0 {
+		headers.Set("Access-Control-Max-Age", "0")
+		headers.Set("Cache-Control", "no-store")
+		return
+	}
+	maxAge := strconv.FormatInt(p.maxAge, 10)
+	headers.Set("Access-Control-Max-Age", maxAge)
+	headers.Set("Cache-Control", "public, max-age="+maxAge+", s-maxage="+maxAge)
+}
+
+// WithCredentials returns a copy of the policy that lets listed origins send
+// and receive cookies. It has no effect when every origin is allowed, since
+// browsers refuse credentials with a wildcard origin.
+func (p Policy) WithCredentials() Policy {
+	p.credentials = true
+	return p
+}
+
+// WithFetchMetadataCheck returns a copy of the policy that rejects requests
+// whose Origin header claims a browser but whose Sec-Fetch-Site and
+// Sec-Fetch-Mode headers are missing or do not match a fetch from that
+// page, as scripts replaying browser traffic usually send them. Requests
+// without an Origin are not checked.
+func (p Policy) WithFetchMetadataCheck() Policy {
+	p.fetchMetadata = true
+	return p
+}
+
+// fetchMetadataProblem returns why r, which carries an Origin, does not look
+// like a fetch made by a browser page, or "" when it does.
+func fetchMetadataProblem(r *http.Request) string {
+	site, mode := r.Header.Get("Sec-Fetch-Site"), r.Header.Get("Sec-Fetch-Mode")
+	switch {
+	case site == "" || mode == "":
+		return "missing Sec-Fetch-Site or Sec-Fetch-Mode"
+	case site == "none":
+		// Only requests the user started, such as typing a URL, have no
+		// initiating site, and those carry no cross-origin Origin.
+		return "Sec-Fetch-Site none with an Origin"
+	case site != "same-origin" && site != "same-site" && site != "cross-site":
+		return fmt.Sprintf("unknown Sec-Fetch-Site %q", site)
+	case r.Method == http.MethodOptions && mode != "cors":
+		return fmt.Sprintf("preflight with Sec-Fetch-Mode %q", mode)
+	case mode == "same-origin" && site != "same-origin":
+		return fmt.Sprintf("Sec-Fetch-Mode same-origin with Sec-Fetch-Site %q", site)
+	case mode != "cors" && mode != "same-origin":
+		// Navigations, form posts, and no-cors requests cannot read the
+		// response, so no page calls the API that way.
+		return fmt.Sprintf("Sec-Fetch-Mode %q is not a fetch", mode)
+	}
+	return ""
+}
+
+// Allow returns the Access-Control-Allow-Origin value for origin and
+// whether the origin is allowed.
+func (p Policy) Allow(origin string) (string, bool) {
+	if p.allowAll {
+		return "*", true
+	}
+	if _, ok := p.origins[origin]; ok {
+		return origin, true
+	}
+	if len(p.patterns) > 0 {
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" || u.User != nil {
+			return origin, false
+		}
+		for _, pattern := range p.patterns {
+			if pattern.Matches(origin, u) {
+				return origin, true
+			}
+		}
+	}
+	return origin, false
+}
+
+// regexPrefix marks an allowed origin entry that is a regular
+// expression.
+const regexPrefix = "regex:"
+
+// portRange matches a trailing port range such as :3000-3999.
+var portRange = regexp.MustCompile(`:[0-9]+-[0-9]+$`)
+
+// regexProbes are origins no allowed origin regular expression may
+// match, since they stand for sites anyone can register.
+var regexProbes = []string{"null", "http://attacker.invalid", "https://attacker.invalid", "https://attacker.invalid:8443"}
+
+// Pattern is an allowed origin entry that needs more than an exact
+// comparison: a wildcard subdomain such as https://*.example.com, an entry
+// without a scheme such as app.example.com, a loopback port range such as
+// http://localhost:3000-3999, or a regular expression.
+type Pattern struct {
+	// scheme is http or https, or empty to match both.
+	scheme string
+	// host is the exact host, or for a wildcard the domain below it.
+	host     string
+	port     string
+	wildcard bool
+	// portMin and portMax bound a port range when portMax is set.
+	portMin, portMax int
+	re               *regexp.Regexp
+}
+
+// IsPattern reports whether an allowed origin entry needs pattern
+// matching rather than an exact comparison.
+func IsPattern(entry string) bool {
+	return strings.HasPrefix(entry, regexPrefix) || strings.Contains(entry, "*") || !strings.Contains(entry, "://") || portRange.MatchString(entry)
+}
+
+// CheckOrigins reports the first invalid pattern in a
+// CORS_ALLOWED_ORIGINS value.
+func CheckOrigins(allowedOrigins string) error {
+	if allowedOrigins == "*" {
+		return nil
+	}
+	for _, origin := range strings.Split(allowedOrigins, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" && IsPattern(origin) {
+			if _, err := ParsePattern(origin); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ParsePattern parses [scheme://][*.]host[:port], where port may be a
+// range on loopback hosts, or regex:<expression>. A wildcard stands for
+// exactly one subdomain label and must sit above a registrable domain, so
+// that neither *.com nor a shared suffix anyone can register under, such as
+// *.co.uk or *.github.io, allows every site on it.
+func ParsePattern(entry string) (Pattern, error) {
+	if expr, ok := strings.CutPrefix(entry, regexPrefix); ok {
+		return parseRegex(expr)
+	}
+	var p Pattern
+	rest := entry
+	if scheme, after, ok := strings.Cut(entry, "://"); ok {
+		p.scheme, rest = strings.ToLower(scheme), after
+		if p.scheme != "http" && p.scheme != "https" {
+			return Pattern{}, fmt.Errorf("invalid origin pattern %q: scheme must be http or https", entry)
+		}
+	}
+	rest = strings.TrimPrefix(rest, "//")
+	if after, ok := strings.CutPrefix(rest, "*."); ok {
+		p.wildcard, rest = true, after
+	}
+	p.host = rest
+	if host, port, err := net.SplitHostPort(rest); err == nil {
+		p.host, p.port = host, port
+	}
+	p.host = strings.ToLower(p.host)
+	if p.host == "" || strings.ContainsAny(p.host, "*/@?#") || (strings.Contains(p.host, ":") && net.ParseIP(p.host) == nil) {
+		return Pattern{}, fmt.Errorf("invalid origin pattern %q: expected [scheme://][*.]host[:port]", entry)
+	}
+	if p.wildcard && !strings.Contains(strings.Trim(p.host, "."), ".") {
+		return Pattern{}, fmt.Errorf("invalid origin pattern %q: a wildcard must sit above a domain such as *.example.com", entry)
+	}
+	if p.wildcard {
+		if suffix, _ := publicsuffix.PublicSuffix(p.host); suffix == p.host {
+			return Pattern{}, fmt.Errorf("invalid origin pattern %q: %s is a public suffix shared by unrelated sites; name your own domain, such as *.example.%s", entry, p.host, p.host)
+		}
+	}
+	if lo, hi, ok := strings.Cut(p.port, "-"); ok {
+		if !isLoopbackHost(p.host) || p.wildcard {
+			return Pattern{}, fmt.Errorf("invalid origin pattern %q: port ranges are only allowed on localhost, 127.0.0.1, and ::1", entry)
+		}
+		var err1, err2 error
+		p.portMin, err1 = strconv.Atoi(lo)
+		p.portMax, err2 = strconv.Atoi(hi)
+		if err1 != nil || err2 != nil || p.portMin < 1 || p.portMin > p.portMax || p.portMax > 65535 {
+			return Pattern{}, fmt.Errorf("invalid origin pattern %q: expected a port range such as 3000-3999", entry)
+		}
+		p.port = ""
+	}
+	return p, nil
+}
+
+// parseRegex compiles an allowed origin regular expression. Patterns
+// must be anchored with ^ and $ and must not match any of regexProbes, so
+// that a typo cannot quietly allow every site. An unescaped . outside a
+// character class is refused too: it matches any character, so
+// ^https://app.example.com$ would also allow https://app-example.com,
+// which anyone can register.
+func parseRegex(expr string) (Pattern, error) {
+	if !strings.HasPrefix(expr, "^") || !strings.HasSuffix(expr, "$") {
+		return Pattern{}, fmt.Errorf("invalid origin regex %q: it must be anchored with ^ and $", expr)
+	}
+	if unescapedDot(expr) {
+		return Pattern{}, fmt.Errorf(`invalid origin regex %q: an unescaped . matches any character; write \. for a literal dot and a character class such as [a-z0-9-]+ for a label`, expr)
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return Pattern{}, fmt.Errorf("invalid origin regex %q: %w", expr, err)
+	}
+	for _, probe := range regexProbes {
+		if re.MatchString(probe) {
+			return Pattern{}, fmt.Errorf("invalid origin regex %q: it matches %s", expr, probe)
+		}
+	}
+	return Pattern{re: re}, nil
+}
+
+// unescapedDot reports whether expr has an unescaped . outside a character
+// class.
+func unescapedDot(expr string) bool {
+	inClass := false
+	for i := 0; i < len(expr); i++ {
+		switch c := expr[i]; {
+		case c == '\\':
+			i++
+		case c == '[':
+			inClass = true
+		case c == ']':
+			inClass = false
+		case c == '.' && !inClass:
+			return true
+		}
+	}
+	return false
+}
+
+func isLoopbackHost(host string) bool {
+	return host == "localhost" || host == "127.0.0.1" || host == "::1"
+}
+
+// Matches reports whether origin, parsed as u, matches the pattern. Hosts
+// compare case-insensitively, and a wildcard matches one non-empty label,
+// so *.example.com matches a.example.com but neither example.com,
+// a.b.example.com, nor evil-example.com. Regular expressions see the origin
+// as sent.
+func (p Pattern) Matches(origin string, u *url.URL) bool {
+	if p.re != nil {
+		return p.re.MatchString(origin)
+	}
+	if p.scheme != "" && p.scheme != u.Scheme {
+		return false
+	}
+	if p.portMax > 0 {
+		port, err := strconv.Atoi(u.Port())
+		if err != nil || port < p.portMin || port > p.portMax {
+			return false
+		}
+	} else if u.Port() != p.port {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	if !p.wildcard {
+		return host == p.host
+	}
+	label, domain, ok := strings.Cut(host, ".")
+	return ok && label != "" && domain == p.host
+}
+
+// Handler wraps next so that requests from disallowed origins get 403 and
+// preflights are answered without reaching next.
+func (policy Policy) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers := w.Header()
+		// Every response depends on Origin, including rejections and
+		// requests without one, so caches must never share them across
+		// origins.
+		headers.Add("Vary", "Origin")
+
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			if r.Method == http.MethodOptions {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		allowedOrigin, ok := policy.Allow(origin)
+		if !ok {
+			http.Error(w, "origin not allowed", http.StatusForbidden)
+			return
+		}
+		if policy.fetchMetadata {
+			if problem := fetchMetadataProblem(r); problem != "" {
+				http.Error(w, "request does not look like a browser request: "+problem, http.StatusForbidden)
+				return
+			}
+		}
+
+		headers.Set("Access-Control-Allow-Origin", allowedOrigin)
+		if policy.credentials && !policy.allowAll {
+			headers.Set("Access-Control-Allow-Credentials", "true")
+		}
+		if policy.exposeHeaders != "" {
+			headers.Set("Access-Control-Expose-Headers", policy.exposeHeaders)
+		}
+
+		if r.Method == http.MethodOptions {
+			headers.Set("Access-Control-Allow-Methods", "GET, POST, PUT, OPTIONS")
+			headers.Set("Access-Control-Allow-Headers", policy.allowHeaders)
+			policy.setPreflightCaching(headers)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,294 @@
+package cors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCORSMiddlewareAllowsOrigin(t *testing.T) {
+	policy := NewPolicy("https://app.example.com")
+	handler := policy.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/chatkit/session", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	res := rec.Result()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", res.StatusCode)
+	}
+	if res.Header.Get("Access-Control-Allow-Origin") != "https://app.example.com" {
+		t.Fatalf("missing allowed origin header")
+	}
+	if !containsHeader(res.Header["Vary"], "Origin") {
+		t.Fatalf("expected Vary: Origin header")
+	}
+}
+
+func TestCORSMiddlewareRejectsOrigin(t *testing.T) {
+	policy := NewPolicy("https://app.example.com")
+	handler := policy.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("handler should not be called for rejected origin")
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/chatkit/session", nil)
+	req.Header.Set("Origin", "https://evil.example.com")
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("expected status 403, got %d", rec.Code)
+	}
+}
+
+func TestCORSMiddlewarePreflight(t *testing.T) {
+	policy := NewPolicy("*")
+	called := false
+	handler := policy.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+
+	req := httptest.NewRequest(http.MethodOptions, "/api/chatkit/session", nil)
+	req.Header.Set("Origin", "https://example.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	req.Header.Set("Access-Control-Request-Headers", "Content-Type")
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if called {
+		t.Fatalf("handler should not be invoked for preflight")
+	}
+	res := rec.Result()
+	if res.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected status 204, got %d", res.StatusCode)
+	}
+	if res.Header.Get("Access-Control-Allow-Origin") != "*" {
+		t.Fatalf("expected wildcard allowed origin")
+	}
+	if res.Header.Get("Access-Control-Allow-Methods") != "GET, POST, PUT, OPTIONS" {
+		t.Fatalf("unexpected allowed methods: %s", res.Header.Get("Access-Control-Allow-Methods"))
+	}
+	if res.Header.Get("Access-Control-Allow-Headers") == "" {
+		t.Fatalf("expected allowed headers to be set")
+	}
+	if res.Header.Get("Access-Control-Max-Age") != "600" {
+		t.Fatalf("expected max age 600, got %s", res.Header.Get("Access-Control-Max-Age"))
+	}
+}
+
+func TestCORSPreflightAllowsPreferenceUpdates(t *testing.T) {
+	handler := NewPolicy("https://app.example.com").Handler(http.NotFoundHandler())
+
+	req := httptest.NewRequest(http.MethodOptions, "/v1/chatkit/prefs", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", "PUT")
+	req.Header.Set("Access-Control-Request-Headers", "Authorization, Content-Type")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	res := rec.Result()
+	if res.StatusCode != http.StatusNoContent || res.Header.Get("Access-Control-Allow-Origin") != "https://app.example.com" {
+		t.Fatalf("expected the preflight to be allowed, got %d", res.StatusCode)
+	}
+	if methods := res.Header.Get("Access-Control-Allow-Methods"); !strings.Contains(methods, "PUT") {
+		t.Fatalf("expected PUT to be allowed, got %q", methods)
+	}
+}
+
+func TestCORSPreflightIsEdgeCacheable(t *testing.T) {
+	handler := NewPolicy("https://app.example.com").WithMaxAge(86400).Handler(http.NotFoundHandler())
+
+	req := httptest.NewRequest(http.MethodOptions, "/api/chatkit/session", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	res := rec.Result()
+	if got := res.Header.Get("Access-Control-Max-Age"); got != "86400" {
+		t.Fatalf("expected max age 86400, got %s", got)
+	}
+	if got := res.Header.Get("Cache-Control"); got != "public, max-age=86400, s-maxage=86400" {
+		t.Fatalf("unexpected cache control: %s", got)
+	}
+	if got := res.Header.Values("Vary"); len(got) != 1 || got[0] != preflightVary {
+		t.Fatalf("unexpected vary: %v", got)
+	}
+}
+
+func TestCORSPreflightCachingDisabled(t *testing.T) {
+	handler := NewPolicy("*").WithMaxAge(0).Handler(http.NotFoundHandler())
+
+	req := httptest.NewRequest(http.MethodOptions, "/api/chatkit/session", nil)
+	req.Header.Set("Origin", "https://example.com")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if got := rec.Header().Get("Cache-Control"); got != "no-store" {
+		t.Fatalf("expected no-store, got %s", got)
+	}
+	if got := rec.Header().Get("Access-Control-Max-Age"); got != "0" {
+		t.Fatalf("expected max age 0, got %s", got)
+	}
+}
+
+func TestCORSRejectionVariesOnOrigin(t *testing.T) {
+	handler := NewPolicy("https://app.example.com").Handler(http.NotFoundHandler())
+
+	req := httptest.NewRequest(http.MethodOptions, "/api/chatkit/session", nil)
+	req.Header.Set("Origin", "https://evil.example.com")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d", rec.Code)
+	}
+	if !containsHeader(rec.Header().Values("Vary"), "Origin") {
+		t.Fatalf("expected Vary: Origin on rejection, got %v", rec.Header().Values("Vary"))
+	}
+}
+
+func containsHeader(values []string, target string) bool {
+	for _, v := range values {
+		if v == target {
+			return true
+		}
+	}
+	return false
+}
+
+func TestCORSPatternOrigins(t *testing.T) {
+	policy := NewPolicy("https://*.preview.example.com, app.example.com, http://*.example.dev:8080")
+	for origin, want := range map[string]bool{
+		"https://pr-42.preview.example.com":      true,
+		"https://PR-42.Preview.Example.com":      true,
+		"http://pr-42.preview.example.com":       false,
+		"https://preview.example.com":            false,
+		"https://a.b.preview.example.com":        false,
+		"https://evilpreview.example.com":        false,
+		"https://pr-42.preview.example.com.evil": false,
+		"https://app.example.com":                true,
+		"http://app.example.com":                 true,
+		"https://app.example.com:8443":           false,
+		"http://branch.example.dev:8080":         true,
+		"http://branch.example.dev":              false,
+		"null":                                   false,
+	} {
+		if _, got := policy.Allow(origin); got != want {
+			t.Fatalf("%s: expected allowed=%v", origin, want)
+		}
+	}
+}
+
+func TestCheckCORSOrigins(t *testing.T) {
+	for _, valid := range []string{"*", "https://app.example.com", "https://*.example.com,localhost:3000", "https://*.example.co.uk", "https://*.myapp.github.io"} {
+		if err := CheckOrigins(valid); err != nil {
+			t.Fatalf("%q: unexpected error: %v", valid, err)
+		}
+	}
+	for _, invalid := range []string{"https://*.com", "https://*.co.uk", "*.github.io", "https://*.vercel.app", "*.localhost", "https://*", "https://app.*.example.com", "ftp://*.example.com"} {
+		if err := CheckOrigins(invalid); err == nil {
+			t.Fatalf("%q: expected an error", invalid)
+		}
+	}
+}
+
+func TestCORSRegexAndPortRangeOrigins(t *testing.T) {
+	policy := NewPolicy(`http://localhost:3000-3999, regex:^https://pr-[0-9]+\.preview\.example\.com$`)
+	for origin, want := range map[string]bool{
+		"http://localhost:3000":                       true,
+		"http://localhost:3999":                       true,
+		"http://localhost:4000":                       false,
+		"http://localhost":                            false,
+		"https://localhost:3000":                      false,
+		"https://pr-17.preview.example.com":           true,
+		"https://pr-x.preview.example.com":            false,
+		"https://pr-17.preview.example.com.evil.test": false,
+	} {
+		if _, got := policy.Allow(origin); got != want {
+			t.Fatalf("%s: expected allowed=%v", origin, want)
+		}
+	}
+}
+
+func TestCheckCORSOriginsRejectsDangerousRules(t *testing.T) {
+	for _, invalid := range []string{
+		`regex:https://app\.example\.com`,
+		`regex:^https://.*\.example\.com$`,
+		`regex:^https://app.example\.com$`,
+		`regex:^https://[a-z]+\.example.com$`,
+		`regex:^https?://[a-z.]+$`,
+		`regex:^(null|https://app\.example\.com)$`,
+		`regex:^https://[$`,
+		"https://example.com:3000-3999",
+		"http://localhost:3999-3000",
+		"http://localhost:0-10",
+	} {
+		if err := CheckOrigins(invalid); err == nil {
+			t.Fatalf("%q: expected an error", invalid)
+		}
+	}
+	if err := CheckOrigins(`http://[::1]:5173-5199,regex:^https://[a-z0-9-]+\.preview\.example\.com$`); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := CheckOrigins(`regex:^https://[a-z.]+\.example\.com$`); err != nil {
+		t.Fatalf("expected a dot inside a character class to be allowed, got %v", err)
+	}
+}
+
+func TestFetchMetadataCheck(t *testing.T) {
+	handler := NewPolicy("https://app.example.com").WithFetchMetadataCheck().Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	tests := []struct {
+		name   string
+		method string
+		origin string
+		site   string
+		mode   string
+		want   int
+	}{
+		{"cross-site fetch", http.MethodPost, "https://app.example.com", "cross-site", "cors", http.StatusOK},
+		{"same-origin fetch", http.MethodPost, "https://app.example.com", "same-origin", "same-origin", http.StatusOK},
+		{"preflight", http.MethodOptions, "https://app.example.com", "same-site", "cors", http.StatusNoContent},
+		{"no origin", http.MethodPost, "", "", "", http.StatusOK},
+		{"script without fetch metadata", http.MethodPost, "https://app.example.com", "", "", http.StatusForbidden},
+		{"user-initiated", http.MethodPost, "https://app.example.com", "none", "cors", http.StatusForbidden},
+		{"form post", http.MethodPost, "https://app.example.com", "cross-site", "navigate", http.StatusForbidden},
+		{"no-cors", http.MethodPost, "https://app.example.com", "cross-site", "no-cors", http.StatusForbidden},
+		{"same-origin mode across sites", http.MethodPost, "https://app.example.com", "cross-site", "same-origin", http.StatusForbidden},
+		{"preflight without cors mode", http.MethodOptions, "https://app.example.com", "cross-site", "no-cors", http.StatusForbidden},
+		{"unknown site", http.MethodPost, "https://app.example.com", "elsewhere", "cors", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/api/chatkit/session", nil)
+			for name, value := range map[string]string{"Origin": tt.origin, "Sec-Fetch-Site": tt.site, "Sec-Fetch-Mode": tt.mode} {
+				if value != "" {
+					req.Header.Set(name, value)
+				}
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("expected %d, got %d: %s", tt.want, rec.Code, rec.Body)
+			}
+		})
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/chatkit/session", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	rec := httptest.NewRecorder()
+	NewPolicy("https://app.example.com").Handler(http.NotFoundHandler()).ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected the check to be off by default, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,114 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"openai-chatkit-backend/chatkit/redis"
+)
+
+// defaultLockLease bounds how long a replica that dies or stops keeps the
+// shared lock of a job occurrence. It must exceed a job's jitter plus its
+// run time and the clock skew between replicas.
+const defaultLockLease = 10 * time.Minute
+
+// Locker grants exclusive ownership of a named background job. In a
+// multi-replica deployment it should be backed by shared infrastructure
+// (Redis, Postgres advisory locks, a Kubernetes lease) so that only one
+// replica runs each job at a time.
+type Locker interface {
+	// TryLock attempts to acquire the lock for job without blocking. When ok
+	// is true the caller owns the job until it calls release.
+	TryLock(ctx context.Context, job string) (release func(), ok bool, err error)
+}
+
+// LocalLocker is the single-replica Locker. It only prevents overlapping
+// runs of the same job within this process.
+type LocalLocker struct {
+	mu   sync.Mutex
+	held map[string]struct{}
+}
+
+// NewLocalLocker returns a LocalLocker holding no locks.
+func NewLocalLocker() *LocalLocker {
+	return &LocalLocker{held: make(map[string]struct{})}
+}
+
+func (l *LocalLocker) TryLock(ctx context.Context, job string) (func(), bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.held[job]; ok {
+		return nil, false, nil
+	}
+	l.held[job] = struct{}{}
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		delete(l.held, job)
+	}, true, nil
+}
+
+// RunAsLeader runs fn only if this replica acquires the lock for job. It
+// reports whether fn ran.
+func RunAsLeader(ctx context.Context, locker Locker, job string, fn func(context.Context) error) (bool, error) {
+	release, ok, err := locker.TryLock(ctx, job)
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		slog.Debug("skipping job: lock held by another runner", "job", job)
+		return false, nil
+	}
+	defer release()
+
+	return true, fn(ctx)
+}
+
+// redisUnlock deletes KEYS[1] if it still holds this holder's token, so a
+// holder whose lease expired cannot release a lock another replica now owns.
+const redisUnlock = `
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`
+
+// RedisLocker is the multi-replica Locker. A lock is a key set with NX,
+// holding a random token, that expires after lease.
+type RedisLocker struct {
+	client *redis.Client
+	lease  time.Duration
+}
+
+// NewRedisLocker returns a RedisLocker that keeps its locks in client.
+func NewRedisLocker(client *redis.Client) *RedisLocker {
+	return &RedisLocker{client: client, lease: defaultLockLease}
+}
+
+func (l *RedisLocker) TryLock(ctx context.Context, job string) (func(), bool, error) {
+	buf := make([]byte, 12)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, false, err
+	}
+	token := hex.EncodeToString(buf)
+	key := l.client.Key("jobs", job)
+	reply, err := l.client.Do(ctx, "SET", key, token, "NX", "PX", strconv.FormatInt(l.lease.Milliseconds(), 10))
+	if err != nil {
+		return nil, false, err
+	}
+	if reply == nil {
+		return nil, false, nil
+	}
+	return func() {
+		// The job's context may be cancelled by now; the release must still
+		// go out so the next occurrence is not blocked until the lease ends.
+		if _, err := l.client.Eval(context.Background(), redisUnlock, []string{key}, token); err != nil {
+			slog.Warn("failed to release job lock", "job", job, "error", err)
+		}
+	}, true, nil
+}
@@ -0,0 +1,130 @@
+package jobs
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"openai-chatkit-backend/chatkit/redis"
+	"openai-chatkit-backend/chatkit/redis/redistest"
+)
+
+func TestRunAsLeaderSkipsWhenLockHeld(t *testing.T) {
+	locker := NewLocalLocker()
+	ctx := context.Background()
+
+	ran, err := RunAsLeader(ctx, locker, "cleanup", func(ctx context.Context) error {
+		nested, err := RunAsLeader(ctx, locker, "cleanup", func(ctx context.Context) error {
+			t.Fatal("nested run should not execute while lock is held")
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("unexpected nested error: %v", err)
+		}
+		if nested {
+			t.Fatalf("expected nested run to be skipped")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !ran {
+		t.Fatalf("expected job to run")
+	}
+
+	ran, _ = RunAsLeader(ctx, locker, "cleanup", func(ctx context.Context) error { return nil })
+	if !ran {
+		t.Fatalf("expected lock to be released after first run")
+	}
+}
+
+func TestSchedulersSharingALockerRunLeaderJobsOnce(t *testing.T) {
+	server := redistest.NewServer(t, "")
+	server.HandleUnlock(redisUnlock)
+	client, _ := redis.NewClient(server.URL(), redis.DefaultKeyPrefix)
+	locker := NewRedisLocker(client)
+
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	var runs atomic.Int64
+	newReplica := func() *Scheduler {
+		s := NewScheduler()
+		s.Locker = locker
+		err := s.Configure([]Definition{{Name: "retention", Schedule: "@hourly", Enabled: true, Leader: true, Run: func(context.Context) error {
+			runs.Add(1)
+			close(started)
+			<-finish
+			return nil
+		}}}, func(string) string { return "" })
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return s
+	}
+	a, b := newReplica(), newReplica()
+	at := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	done := make(chan struct{})
+	go func() {
+		a.runJob(context.Background(), a.jobs[0], at, 0)
+		close(done)
+	}()
+	<-started
+	b.runJob(context.Background(), b.jobs[0], at, 0)
+	close(finish)
+	<-done
+
+	if runs.Load() != 1 {
+		t.Fatalf("expected the job to run once, ran %d times", runs.Load())
+	}
+	if status := a.Snapshot()[0]; status.Runs != 1 || status.LastResult != "ok" {
+		t.Fatalf("unexpected leader status: %+v", status)
+	}
+	if status := b.Snapshot()[0]; status.Runs != 0 || status.Skips != 1 || status.LastResult != "skipped: not leader" {
+		t.Fatalf("unexpected follower status: %+v", status)
+	}
+}
+
+func TestLeaderJobOccurrenceRunsOnceAcrossLateReplicas(t *testing.T) {
+	server := redistest.NewServer(t, "")
+	server.HandleUnlock(redisUnlock)
+	var runs atomic.Int64
+	newReplica := func() *Scheduler {
+		client, _ := redis.NewClient(server.URL(), redis.DefaultKeyPrefix)
+		s := NewScheduler()
+		s.Locker = NewRedisLocker(client)
+		err := s.Configure([]Definition{{Name: "retention", Schedule: "@hourly", Enabled: true, Leader: true, Run: func(context.Context) error {
+			runs.Add(1)
+			return nil
+		}}}, func(string) string { return "" })
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return s
+	}
+	a, b := newReplica(), newReplica()
+	at := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	release := a.runJob(context.Background(), a.jobs[0], at, 0)
+	if release == nil || runs.Load() != 1 {
+		t.Fatalf("expected the first replica to run the occurrence")
+	}
+	// The second replica wakes after the first finished, as with clock skew
+	// or no jitter.
+	if b.runJob(context.Background(), b.jobs[0], at, 0); runs.Load() != 1 {
+		t.Fatalf("expected a late replica to skip an occurrence that already ran, ran %d times", runs.Load())
+	}
+	if _, held := server.Value("chatkit:jobs:retention:1704067200"); !held {
+		t.Fatal("expected the occurrence lock to outlive the run")
+	}
+
+	if b.runJob(context.Background(), b.jobs[0], at.Add(time.Hour), 0); runs.Load() != 2 {
+		t.Fatalf("expected the next occurrence to run, ran %d times", runs.Load())
+	}
+	release()
+	if _, held := server.Value("chatkit:jobs:retention:1704067200"); held {
+		t.Fatal("expected the lock to be released once the next occurrence is due")
+	}
+}
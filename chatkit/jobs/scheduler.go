@@ -0,0 +1,414 @@
+// Package jobs runs background jobs on cron schedules, on one replica at a
+// time when they act on shared state.
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"openai-chatkit-backend/chatkit/clock"
+)
+
+const defaultJitter = 30 * time.Second
+
+var cronShortcuts = map[string]string{
+	"@hourly": "0 * * * *",
+	"@daily":  "0 0 * * *",
+	"@weekly": "0 0 * * 0",
+}
+
+// cronSchedule is a five-field cron expression (minute, hour, day of month,
+// month, day of week) evaluated in UTC. Each field holds the allowed values
+// as a bitset.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// domAny and dowAny record an unrestricted "*" day field. As in cron,
+	// when both day fields are restricted a day matching either one fires.
+	domAny, dowAny bool
+}
+
+// parseCronSchedule parses fields separated by spaces. Each field is a
+// comma-separated list of "*", a value, or a range "a-b", optionally with a
+// step "/n". Day of week 0 and 7 are both Sunday. @hourly, @daily, and
+// @weekly are accepted as shortcuts.
+func parseCronSchedule(spec string) (cronSchedule, error) {
+	spec = strings.TrimSpace(spec)
+	if expanded, ok := cronShortcuts[spec]; ok {
+		spec = expanded
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return cronSchedule{}, fmt.Errorf("invalid cron schedule %q: expected 5 fields", spec)
+	}
+	var s cronSchedule
+	for i, f := range []struct {
+		name     string
+		min, max int
+		bits     *uint64
+	}{
+		{"minute", 0, 59, &s.minute},
+		{"hour", 0, 23, &s.hour},
+		{"day of month", 1, 31, &s.dom},
+		{"month", 1, 12, &s.month},
+		{"day of week", 0, 7, &s.dow},
+	} {
+		bits, err := parseCronField(fields[i], f.min, f.max)
+		if err != nil {
+			return cronSchedule{}, fmt.Errorf("invalid cron schedule %q: %s: %w", spec, f.name, err)
+		}
+		*f.bits = bits
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.domAny = fields[2] == "*"
+	s.dowAny = fields[4] == "*"
+	if s.next(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)).IsZero() {
+		return cronSchedule{}, fmt.Errorf("invalid cron schedule %q: never fires", spec)
+	}
+	return s, nil
+}
+
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, stepSpec, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepSpec)
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("invalid step %q", stepSpec)
+			}
+			step = n
+		}
+		lo, hi := min, max
+		if rng != "*" {
+			a, b, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = strconv.Atoi(a); err != nil {
+				return 0, fmt.Errorf("invalid value %q", a)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(b); err != nil {
+					return 0, fmt.Errorf("invalid value %q", b)
+				}
+			} else if hasStep {
+				hi = max
+			}
+			if lo < min || hi > max || lo > hi {
+				return 0, fmt.Errorf("%q is outside %d-%d", rng, min, max)
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+// next returns the first minute after t that the schedule fires at, or the
+// zero time when it does not fire within five years.
+func (s cronSchedule) next(t time.Time) time.Time {
+	t = t.UTC().Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		case !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			t = t.Truncate(time.Hour).Add(time.Hour)
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (s cronSchedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	switch {
+	case s.domAny:
+		return dow
+	case s.dowAny:
+		return dom
+	default:
+		return dom || dow
+	}
+}
+
+// Definition describes a job and its default configuration.
+type Definition struct {
+	Name     string
+	Schedule string
+	Enabled  bool
+	// Run is nil when the job's prerequisites are not configured; Requires
+	// names them for the error when the job is enabled explicitly.
+	Run      func(context.Context) error
+	Requires string
+	// Leader jobs send webhooks or act on state shared by every replica, so
+	// each occurrence runs on only one replica, chosen by the Locker.
+	Leader bool
+}
+
+// Status is the admin view of a scheduled job.
+type Status struct {
+	Name           string `json:"name"`
+	Schedule       string `json:"schedule"`
+	Jitter         string `json:"jitter"`
+	Running        bool   `json:"running"`
+	Runs           int64  `json:"runs"`
+	Failures       int64  `json:"failures"`
+	LastRun        string `json:"last_run,omitempty"`
+	LastDurationMS int64  `json:"last_duration_ms"`
+	LastError      string `json:"last_error,omitempty"`
+	// Skips counts occurrences another replica ran; LastResult is "ok",
+	// "failed", or "skipped: not leader".
+	Skips      int64  `json:"skips"`
+	LastResult string `json:"last_result,omitempty"`
+	NextRun    string `json:"next_run,omitempty"`
+}
+
+type scheduledJob struct {
+	name     string
+	spec     string
+	schedule cronSchedule
+	jitter   time.Duration
+	run      func(context.Context) error
+	leader   bool
+
+	mu     sync.Mutex
+	status Status
+}
+
+// Scheduler runs background jobs on cron schedules. Each job runs in its own
+// goroutine, so a slow job delays only its own next run, and a random delay
+// of up to its jitter spreads runs of replicas sharing a schedule. Leader
+// jobs take their lock from Locker before that delay, so replicas whose
+// jitter differs still contend for the same occurrence.
+type Scheduler struct {
+	// Locker grants leader jobs to one replica. It defaults to a LocalLocker,
+	// which suits a single replica.
+	Locker Locker
+
+	clock clock.Clock
+	rand  clock.Rand
+	jobs  []*scheduledJob
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewScheduler returns a scheduler without jobs.
+func NewScheduler() *Scheduler {
+	return &Scheduler{Locker: NewLocalLocker(), clock: clock.System, rand: clock.SystemRand}
+}
+
+// Configure adds every enabled job in defs. Each job's defaults can be
+// overridden with CHATKIT_JOB_<NAME>_ENABLED, CHATKIT_JOB_<NAME>_SCHEDULE,
+// and CHATKIT_JOB_<NAME>_JITTER. Jobs that are enabled by default but whose
+// prerequisites are missing are skipped.
+func (s *Scheduler) Configure(defs []Definition, getenv func(string) string) error {
+	for _, def := range defs {
+		prefix := "CHATKIT_JOB_" + strings.ToUpper(def.Name) + "_"
+		enabled := def.Enabled
+		if v := getenv(prefix + "ENABLED"); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return fmt.Errorf("invalid %sENABLED %q: expected true or false", prefix, v)
+			}
+			if b && def.Run == nil {
+				return fmt.Errorf("job %s requires %s", def.Name, def.Requires)
+			}
+			enabled = b
+		}
+		if !enabled || def.Run == nil {
+			continue
+		}
+		spec := def.Schedule
+		if v := getenv(prefix + "SCHEDULE"); v != "" {
+			spec = v
+		}
+		schedule, err := parseCronSchedule(spec)
+		if err != nil {
+			return fmt.Errorf("%sSCHEDULE: %w", prefix, err)
+		}
+		jitter := defaultJitter
+		if v := getenv(prefix + "JITTER"); v != "" {
+			if jitter, err = time.ParseDuration(v); err != nil || jitter < 0 {
+				return fmt.Errorf("invalid %sJITTER %q: expected a non-negative duration such as 30s", prefix, v)
+			}
+		}
+		s.jobs = append(s.jobs, &scheduledJob{
+			name:     def.Name,
+			spec:     spec,
+			schedule: schedule,
+			jitter:   jitter,
+			run:      def.Run,
+			leader:   def.Leader,
+			status:   Status{Name: def.Name, Schedule: spec, Jitter: jitter.String()},
+		})
+	}
+	return nil
+}
+
+// Start runs each job on its schedule until Stop.
+func (s *Scheduler) Start(context.Context) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	for _, j := range s.jobs {
+		s.wg.Add(1)
+		go func(j *scheduledJob) {
+			defer s.wg.Done()
+			s.loop(ctx, j)
+		}(j)
+	}
+	return nil
+}
+
+// Stop cancels the jobs and waits for running ones to return, or for ctx to
+// be done.
+func (s *Scheduler) Stop(ctx context.Context) error {
+	if s.cancel == nil {
+		return nil
+	}
+	s.cancel()
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Scheduler) loop(ctx context.Context, j *scheduledJob) {
+	// held releases the lock of the occurrence that last ran here. It is
+	// kept until the next occurrence, so a replica that wakes late for the
+	// same occurrence finds it taken rather than free.
+	var held func()
+	for {
+		now := s.clock.Now()
+		at := j.schedule.next(now)
+		var delay time.Duration
+		if j.jitter > 0 {
+			delay = time.Duration(s.rand.Float64() * float64(j.jitter))
+		}
+		j.mu.Lock()
+		j.status.NextRun = at.Add(delay).UTC().Format(time.RFC3339)
+		j.mu.Unlock()
+
+		if !clock.Sleep(ctx, at.Sub(now)) {
+			return
+		}
+		if held != nil {
+			held()
+		}
+		held = s.runJob(ctx, j, at, delay)
+	}
+}
+
+// occurrenceLock names the lock of the occurrence of job scheduled at at.
+func occurrenceLock(job string, at time.Time) string {
+	return job + ":" + strconv.FormatInt(at.Unix(), 10)
+}
+
+// runJob runs the occurrence of j scheduled at at, after delay. A leader job
+// first takes the lock of that occurrence and records a skip when another
+// replica holds it. When the job ran, runJob returns the release of its lock
+// rather than releasing it: the lock must outlive the run, or a replica
+// whose clock or jitter puts it behind could take it and run the same
+// occurrence again.
+func (s *Scheduler) runJob(ctx context.Context, j *scheduledJob, at time.Time, delay time.Duration) (release func()) {
+	if !j.leader {
+		if delay <= 0 || clock.Sleep(ctx, delay) {
+			s.execute(ctx, j)
+		}
+		return nil
+	}
+	release, ok, err := s.Locker.TryLock(ctx, occurrenceLock(j.name, at))
+	if err == nil && ok {
+		if delay > 0 && !clock.Sleep(ctx, delay) {
+			release()
+			return nil
+		}
+		s.execute(ctx, j)
+		return release
+	}
+	if err == nil {
+		slog.Debug("skipping job: lock held by another runner", "job", j.name)
+	}
+	j.mu.Lock()
+	if err != nil {
+		j.status.Failures++
+		j.status.LastError = "acquire lock: " + err.Error()
+		j.status.LastResult = "failed"
+	} else {
+		j.status.Skips++
+		j.status.LastResult = "skipped: not leader"
+	}
+	j.mu.Unlock()
+	if err != nil {
+		// Without the lock no replica can tell whether another ran the job,
+		// so it is skipped rather than risk running it twice.
+		slog.Error("scheduled job skipped: failed to acquire lock", "job", j.name, "error", err)
+	}
+	return nil
+}
+
+// execute runs j and records the outcome.
+func (s *Scheduler) execute(ctx context.Context, j *scheduledJob) error {
+	j.mu.Lock()
+	j.status.Running = true
+	j.mu.Unlock()
+
+	start := s.clock.Now()
+	err := j.run(ctx)
+	elapsed := s.clock.Now().Sub(start)
+
+	j.mu.Lock()
+	j.status.Running = false
+	j.status.Runs++
+	j.status.LastRun = start.UTC().Format(time.RFC3339)
+	j.status.LastDurationMS = elapsed.Milliseconds()
+	j.status.LastError = ""
+	j.status.LastResult = "ok"
+	if err != nil {
+		j.status.Failures++
+		j.status.LastError = err.Error()
+		j.status.LastResult = "failed"
+	}
+	j.mu.Unlock()
+
+	if err != nil {
+		slog.Error("scheduled job failed", "job", j.name, "duration_ms", elapsed.Milliseconds(), "error", err)
+		return err
+	}
+	slog.Debug("scheduled job finished", "job", j.name, "duration_ms", elapsed.Milliseconds())
+	return nil
+}
+
+// Snapshot returns the status of every configured job.
+func (s *Scheduler) Snapshot() []Status {
+	out := make([]Status, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		j.mu.Lock()
+		out = append(out, j.status)
+		j.mu.Unlock()
+	}
+	return out
+}
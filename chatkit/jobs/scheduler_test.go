@@ -0,0 +1,98 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"openai-chatkit-backend/chatkit/clock"
+)
+
+func TestCronScheduleNext(t *testing.T) {
+	from := time.Date(2024, 1, 31, 23, 59, 30, 0, time.UTC) // a Wednesday
+	for spec, want := range map[string]time.Time{
+		"*/5 * * * *":     time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+		"17 * * * *":      time.Date(2024, 2, 1, 0, 17, 0, 0, time.UTC),
+		"@daily":          time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+		"30 9 * * 1-5":    time.Date(2024, 2, 1, 9, 30, 0, 0, time.UTC),
+		"0 0 29 2 *":      time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC),
+		"0 12 * * 7":      time.Date(2024, 2, 4, 12, 0, 0, 0, time.UTC),
+		"0 0 15 * 6":      time.Date(2024, 2, 3, 0, 0, 0, 0, time.UTC),
+		"0 6,18 1 3 *":    time.Date(2024, 3, 1, 6, 0, 0, 0, time.UTC),
+		"10-20/5 3 * * *": time.Date(2024, 2, 1, 3, 10, 0, 0, time.UTC),
+	} {
+		schedule, err := parseCronSchedule(spec)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", spec, err)
+		}
+		if got := schedule.next(from); !got.Equal(want) {
+			t.Fatalf("%s: expected %s, got %s", spec, want, got)
+		}
+	}
+
+	for _, spec := range []string{"* * * *", "60 * * * *", "*/0 * * * *", "5-1 * * * *", "0 0 31 2 *", "@yearly"} {
+		if _, err := parseCronSchedule(spec); err == nil {
+			t.Fatalf("%s: expected an error", spec)
+		}
+	}
+}
+
+func TestSchedulerConfigure(t *testing.T) {
+	run := func(context.Context) error { return nil }
+	defs := []Definition{
+		{Name: "cleanup", Schedule: "*/5 * * * *", Enabled: true, Run: run},
+		{Name: "retention", Schedule: "17 * * * *", Enabled: true, Requires: "CHATKIT_WEBHOOK_URL"},
+		{Name: "key_validation", Schedule: "*/15 * * * *", Run: run},
+	}
+	env := map[string]string{
+		"CHATKIT_JOB_CLEANUP_JITTER":          "0s",
+		"CHATKIT_JOB_KEY_VALIDATION_ENABLED":  "true",
+		"CHATKIT_JOB_KEY_VALIDATION_SCHEDULE": "@hourly",
+	}
+	s := NewScheduler()
+	if err := s.Configure(defs, func(key string) string { return env[key] }); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	jobs := s.Snapshot()
+	if len(jobs) != 2 || jobs[0].Name != "cleanup" || jobs[0].Jitter != "0s" || jobs[1].Name != "key_validation" || jobs[1].Schedule != "@hourly" || jobs[1].Jitter != "30s" {
+		t.Fatalf("unexpected jobs: %+v", jobs)
+	}
+
+	for name, env := range map[string]map[string]string{
+		"missing prerequisite": {"CHATKIT_JOB_RETENTION_ENABLED": "true"},
+		"bad schedule":         {"CHATKIT_JOB_CLEANUP_SCHEDULE": "every minute"},
+		"bad jitter":           {"CHATKIT_JOB_CLEANUP_JITTER": "-1s"},
+		"bad switch":           {"CHATKIT_JOB_CLEANUP_ENABLED": "maybe"},
+	} {
+		if err := NewScheduler().Configure(defs, func(key string) string { return env[key] }); err == nil {
+			t.Fatalf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestSchedulerRecordsRuns(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	fail := true
+	s := NewScheduler()
+	s.clock = clock.Func(func() time.Time { return now })
+	err := s.Configure([]Definition{{Name: "cleanup", Schedule: "@hourly", Enabled: true, Run: func(context.Context) error {
+		now = now.Add(250 * time.Millisecond)
+		if fail {
+			return errors.New("boom")
+		}
+		return nil
+	}}}, func(string) string { return "" })
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	s.runJob(context.Background(), s.jobs[0], now, 0)
+	fail = false
+	s.runJob(context.Background(), s.jobs[0], now, 0)
+
+	status := s.Snapshot()[0]
+	if status.Runs != 2 || status.Failures != 1 || status.LastError != "" || status.LastDurationMS != 250 || status.Running {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+}
@@ -0,0 +1,357 @@
+// Package redis is a minimal Redis client for the state that replicas share:
+// rate limits, session caps, idempotency keys, preferences, and job locks.
+package redis
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"openai-chatkit-backend/chatkit/clock"
+)
+
+const (
+	// DefaultKeyPrefix namespaces keys unless configured otherwise.
+	DefaultKeyPrefix = "chatkit:"
+	defaultPoolSize  = 16
+	// commandTimeout bounds each command, dial included. Shared state sits on
+	// the session path, so a slow Redis must fail fast rather than hold the
+	// request.
+	commandTimeout = 500 * time.Millisecond
+	// After Redis fails to answer, commands fail at once for a backoff that
+	// starts at minBackoff and doubles up to maxBackoff while it stays down,
+	// so an outage does not cost every request a timeout.
+	minBackoff = time.Second
+	maxBackoff = 30 * time.Second
+	// warnInterval is the most often each fallback warning is logged.
+	warnInterval = time.Minute
+)
+
+// ErrUnavailable is returned without contacting Redis while the client
+// backs off after a failure.
+var ErrUnavailable = errors.New("redis: unavailable after a recent failure")
+
+// Error is an error reply from the server.
+type Error string
+
+func (e Error) Error() string { return "redis: " + string(e) }
+
+// Client is a minimal RESP2 client for the few commands the shared
+// state backends need. Connections are pooled; a connection that fails is
+// dropped rather than returned to the pool.
+type Client struct {
+	clock    clock.Clock
+	addr     string
+	username string
+	password string
+	db       int
+	tls      *tls.Config
+	// prefix namespaces every key, so deployments can share a Redis.
+	prefix string
+
+	pool chan *redisConn
+
+	mu         sync.Mutex
+	downUntil  time.Time
+	backoff    time.Duration
+	warned     map[string]time.Time
+	suppressed map[string]int
+}
+
+type redisConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// NewClient parses a redis:// or rediss:// (TLS) URL of the form
+// redis://[user:password@]host[:port][/db]. It does not connect.
+func NewClient(rawURL, prefix string) (*Client, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{
+		clock:      clock.System,
+		addr:       u.Host,
+		prefix:     prefix,
+		pool:       make(chan *redisConn, defaultPoolSize),
+		warned:     make(map[string]time.Time),
+		suppressed: make(map[string]int),
+	}
+	switch u.Scheme {
+	case "redis":
+	case "rediss":
+		c.tls = &tls.Config{ServerName: u.Hostname()}
+	default:
+		return nil, fmt.Errorf("unsupported scheme %q: expected redis or rediss", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, errors.New("missing host")
+	}
+	if u.Port() == "" {
+		c.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		c.username = u.User.Username()
+		c.password, _ = u.User.Password()
+		if _, hasPassword := u.User.Password(); !hasPassword {
+			// redis://secret@host uses the user part as the password.
+			c.username, c.password = "", c.username
+		}
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if c.db, err = strconv.Atoi(db); err != nil || c.db < 0 {
+			return nil, fmt.Errorf("invalid database %q", db)
+		}
+	}
+	return c, nil
+}
+
+// Key returns name under the client's prefix.
+func (c *Client) Key(parts ...string) string {
+	return c.prefix + strings.Join(parts, ":")
+}
+
+// Do sends one command and returns its reply: a string, an int64, nil, a
+// []any of replies, or an Error. While the client backs off after a
+// failure it returns ErrUnavailable without sending anything.
+func (c *Client) Do(ctx context.Context, args ...string) (any, error) {
+	c.mu.Lock()
+	down := c.clock.Now().Before(c.downUntil)
+	c.mu.Unlock()
+	if down {
+		return nil, ErrUnavailable
+	}
+
+	parent := ctx
+	ctx, cancel := context.WithTimeout(ctx, commandTimeout)
+	defer cancel()
+	rc, err := c.get(ctx)
+	if err != nil {
+		c.recordFailure(parent)
+		return nil, err
+	}
+	deadline, _ := ctx.Deadline()
+	_ = rc.conn.SetDeadline(deadline)
+	reply, err := rc.roundTrip(args)
+	var replyErr Error
+	if err != nil && !errors.As(err, &replyErr) {
+		rc.conn.Close()
+		c.recordFailure(parent)
+		return nil, err
+	}
+	c.put(rc)
+	c.mu.Lock()
+	c.backoff = 0
+	c.mu.Unlock()
+	return reply, err
+}
+
+// recordFailure starts or extends the backoff, unless the failure came from
+// the caller giving up.
+func (c *Client) recordFailure(parent context.Context) {
+	if parent.Err() != nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.backoff = min(max(2*c.backoff, minBackoff), maxBackoff)
+	c.downUntil = c.clock.Now().Add(c.backoff)
+}
+
+// Warn logs that a backend fell back to local state. Each message is logged
+// at most once per warnInterval, with a count of the repeats it
+// dropped, so an outage does not log a line per request.
+func (c *Client) Warn(msg string, args ...any) {
+	c.mu.Lock()
+	now := c.clock.Now()
+	if last, ok := c.warned[msg]; ok && now.Sub(last) < warnInterval {
+		c.suppressed[msg]++
+		c.mu.Unlock()
+		return
+	}
+	c.warned[msg] = now
+	suppressed := c.suppressed[msg]
+	delete(c.suppressed, msg)
+	c.mu.Unlock()
+	if suppressed > 0 {
+		args = append(args, "suppressed", suppressed)
+	}
+	slog.Warn(msg, args...)
+}
+
+func (c *Client) get(ctx context.Context) (*redisConn, error) {
+	select {
+	case rc := <-c.pool:
+		return rc, nil
+	default:
+	}
+	dialer := &net.Dialer{}
+	var conn net.Conn
+	var err error
+	if c.tls != nil {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: c.tls}).DialContext(ctx, "tcp", c.addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", c.addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	deadline, _ := ctx.Deadline()
+	_ = conn.SetDeadline(deadline)
+	rc := &redisConn{conn: conn, r: bufio.NewReader(conn)}
+	var setup [][]string
+	switch {
+	case c.password != "" && c.username != "":
+		setup = append(setup, []string{"AUTH", c.username, c.password})
+	case c.password != "":
+		setup = append(setup, []string{"AUTH", c.password})
+	}
+	if c.db != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(c.db)})
+	}
+	for _, args := range setup {
+		if _, err := rc.roundTrip(args); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s: %w", args[0], err)
+		}
+	}
+	return rc, nil
+}
+
+func (c *Client) put(rc *redisConn) {
+	select {
+	case c.pool <- rc:
+	default:
+		rc.conn.Close()
+	}
+}
+
+// Close closes every pooled connection.
+func (c *Client) Close(context.Context) error {
+	for {
+		select {
+		case rc := <-c.pool:
+			rc.conn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+// Ping checks that Redis answers, for the readiness probe.
+func (c *Client) Ping(ctx context.Context) error {
+	_, err := c.Do(ctx, "PING")
+	return err
+}
+
+// Eval runs a Lua script with keys and args.
+func (c *Client) Eval(ctx context.Context, script string, keys []string, args ...string) (any, error) {
+	cmd := append([]string{"EVAL", script, strconv.Itoa(len(keys))}, keys...)
+	return c.Do(ctx, append(cmd, args...)...)
+}
+
+func (rc *redisConn) roundTrip(args []string) (any, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if _, err := io.WriteString(rc.conn, b.String()); err != nil {
+		return nil, err
+	}
+	return ReadReply(rc.r)
+}
+
+// ReadReply reads one RESP2 reply. An error reply is returned as an Error
+// alongside a nil value; errors nested in arrays are returned as elements.
+func ReadReply(r *bufio.Reader) (any, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, fmt.Errorf("redis: malformed reply %q", line)
+	}
+	kind, body := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return nil, Error(body)
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		out := make([]any, n)
+		for i := range out {
+			v, err := ReadReply(r)
+			var replyErr Error
+			if errors.As(err, &replyErr) {
+				out[i] = replyErr
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			out[i] = v
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("redis: unknown reply type %q", kind)
+}
+
+// Int converts an integer reply.
+func Int(v any) (int64, error) {
+	n, ok := v.(int64)
+	if !ok {
+		return 0, fmt.Errorf("redis: expected an integer reply, got %T", v)
+	}
+	return n, nil
+}
+
+// Ints converts an array reply of integers.
+func Ints(v any, want int) ([]int64, error) {
+	arr, ok := v.([]any)
+	if !ok || len(arr) != want {
+		return nil, fmt.Errorf("redis: expected %d integers, got %v", want, v)
+	}
+	out := make([]int64, want)
+	for i, e := range arr {
+		n, err := Int(e)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = n
+	}
+	return out, nil
+}
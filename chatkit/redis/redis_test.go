@@ -0,0 +1,72 @@
+package redis
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"openai-chatkit-backend/chatkit/clock"
+)
+
+func TestNewRedisClient(t *testing.T) {
+	c, err := NewClient("rediss://default:pw@cache.internal/3", "app:")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c.addr != "cache.internal:6379" || c.username != "default" || c.password != "pw" || c.db != 3 || c.tls == nil || c.Key("a", "b") != "app:a:b" {
+		t.Fatalf("unexpected client: %+v", c)
+	}
+	if c, err := NewClient("redis://secret@localhost:6380", ""); err != nil || c.password != "secret" || c.username != "" {
+		t.Fatalf("expected the user part to be the password, got %+v, %v", c, err)
+	}
+	for _, raw := range []string{"http://localhost", "redis://", "redis://localhost/x"} {
+		if _, err := NewClient(raw, ""); err == nil {
+			t.Fatalf("%s: expected an error", raw)
+		}
+	}
+}
+
+func TestRedisClientBacksOffAndThrottlesWarnings(t *testing.T) {
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	addr := l.Addr().String()
+	l.Close()
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	c, _ := NewClient("redis://"+addr, "")
+	c.clock = clock.Func(func() time.Time { return now })
+	ctx := context.Background()
+
+	if err := c.Ping(ctx); err == nil || errors.Is(err, ErrUnavailable) {
+		t.Fatalf("expected the first command to try Redis, got %v", err)
+	}
+	if err := c.Ping(ctx); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("expected a fast failure while backing off, got %v", err)
+	}
+	now = now.Add(minBackoff)
+	if err := c.Ping(ctx); err == nil || errors.Is(err, ErrUnavailable) {
+		t.Fatalf("expected Redis to be tried again after the backoff, got %v", err)
+	}
+	now = now.Add(minBackoff)
+	if err := c.Ping(ctx); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("expected the backoff to double, got %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		c.Warn("shared state unavailable")
+	}
+	now = now.Add(warnInterval)
+	c.Warn("shared state unavailable")
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[1], `"suppressed":2`) {
+		t.Fatalf("expected one warning per interval with a count of the rest, got %q", lines)
+	}
+}
@@ -0,0 +1,148 @@
+// Package redistest serves the Redis commands the chatkit packages use, for
+// tests that need shared state without a Redis server.
+package redistest
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"openai-chatkit-backend/chatkit/redis"
+)
+
+// Server serves the string commands the shared state backends use over
+// RESP. Scripts are not run; EVAL only understands the compare-and-delete
+// scripts registered with HandleUnlock.
+type Server struct {
+	listener net.Listener
+	password string
+
+	mu       sync.Mutex
+	values   map[string]string
+	commands []string
+	unlock   map[string]bool
+}
+
+// NewServer starts a server that requires password, unless it is empty, and
+// stops it when the test ends.
+func NewServer(t testing.TB, password string) *Server {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected listen error: %v", err)
+	}
+	s := &Server{listener: l, password: password, values: make(map[string]string), unlock: make(map[string]bool)}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { l.Close() })
+	return s
+}
+
+// Addr returns the address the server listens on.
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// URL returns a URL for redis.NewClient, naming database 2 when the server
+// requires a password.
+func (s *Server) URL() string {
+	if s.password != "" {
+		return "redis://:" + s.password + "@" + s.Addr() + "/2"
+	}
+	return "redis://" + s.Addr()
+}
+
+// HandleUnlock makes EVAL of script delete KEYS[1] when it holds ARGV[1],
+// returning 1, and return 0 otherwise.
+func (s *Server) HandleUnlock(script string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.unlock[script] = true
+}
+
+// Value returns the value stored at key.
+func (s *Server) Value(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.values[key]
+	return v, ok
+}
+
+// Commands returns every command received so far, with its arguments
+// separated by spaces.
+func (s *Server) Commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.commands...)
+}
+
+func (s *Server) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	authed := s.password == ""
+	for {
+		v, err := redis.ReadReply(r)
+		if err != nil {
+			return
+		}
+		parts, _ := v.([]any)
+		args := make([]string, len(parts))
+		for i, p := range parts {
+			args[i], _ = p.(string)
+		}
+		s.mu.Lock()
+		s.commands = append(s.commands, strings.Join(args, " "))
+		reply := s.exec(args, &authed)
+		s.mu.Unlock()
+		fmt.Fprint(conn, reply)
+	}
+}
+
+func (s *Server) exec(args []string, authed *bool) string {
+	cmd := strings.ToUpper(args[0])
+	if cmd == "AUTH" {
+		if args[len(args)-1] != s.password {
+			return "-WRONGPASS invalid password\r\n"
+		}
+		*authed = true
+		return "+OK\r\n"
+	}
+	if !*authed {
+		return "-NOAUTH Authentication required.\r\n"
+	}
+	bulk := func(v string) string { return fmt.Sprintf("$%d\r\n%s\r\n", len(v), v) }
+	switch cmd {
+	case "PING":
+		return "+PONG\r\n"
+	case "SELECT":
+		return "+OK\r\n"
+	case "GET":
+		if v, ok := s.values[args[1]]; ok {
+			return bulk(v)
+		}
+		return "$-1\r\n"
+	case "SET":
+		if _, exists := s.values[args[1]]; exists && len(args) > 3 && strings.EqualFold(args[3], "NX") {
+			return "$-1\r\n"
+		}
+		s.values[args[1]] = args[2]
+		return "+OK\r\n"
+	case "EVAL":
+		if s.unlock[args[1]] && s.values[args[3]] == args[4] {
+			delete(s.values, args[3])
+			return ":1\r\n"
+		}
+		return ":0\r\n"
+	}
+	return "-ERR unknown command '" + args[0] + "'\r\n"
+}
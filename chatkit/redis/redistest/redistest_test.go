@@ -0,0 +1,36 @@
+package redistest
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"openai-chatkit-backend/chatkit/redis"
+)
+
+func TestClientAuthAndErrors(t *testing.T) {
+	s := NewServer(t, "pw")
+	c, err := redis.NewClient(s.URL(), redis.DefaultKeyPrefix)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ctx := context.Background()
+	if err := c.Ping(ctx); err != nil {
+		t.Fatalf("unexpected ping error: %v", err)
+	}
+	if _, err := c.Do(ctx, "NOPE"); err == nil || !strings.Contains(err.Error(), "unknown command") {
+		t.Fatalf("expected an error reply, got %v", err)
+	}
+	// The error reply must not have cost the pooled connection.
+	if err := c.Ping(ctx); err != nil {
+		t.Fatalf("unexpected ping error: %v", err)
+	}
+	if commands := strings.Join(s.Commands(), "|"); commands != "AUTH pw|SELECT 2|PING|NOPE|PING" {
+		t.Fatalf("unexpected commands: %s", commands)
+	}
+
+	bad, _ := redis.NewClient("redis://:wrong@"+s.Addr(), "")
+	if err := bad.Ping(ctx); err == nil {
+		t.Fatalf("expected a wrong password to fail")
+	}
+}
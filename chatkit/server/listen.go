@@ -0,0 +1,103 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// listenFDsStart is the first file descriptor systemd passes to an
+// activated service.
+const listenFDsStart = 3
+
+// IsUnixSocketAddr reports whether addr names a Unix domain socket: a
+// "unix:" prefix or a path, which TCP addresses never contain.
+func IsUnixSocketAddr(addr string) bool {
+	return strings.HasPrefix(addr, "unix:") || strings.Contains(addr, "/")
+}
+
+// Listen opens the server's listening socket. A socket passed by systemd
+// socket activation takes precedence over addr. Otherwise addr is a TCP
+// address or a Unix socket path, created with mode when it is not zero.
+func Listen(addr string, mode os.FileMode) (net.Listener, error) {
+	if ln, err := systemdListener(); ln != nil || err != nil {
+		if err == nil {
+			slog.Info("using the socket passed by systemd", "addr", ln.Addr().String())
+		}
+		return ln, err
+	}
+	if !IsUnixSocketAddr(addr) {
+		return net.Listen("tcp", addr)
+	}
+	path := strings.TrimPrefix(addr, "unix:")
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	// Closing the listener on shutdown removes the socket file.
+	ln.(*net.UnixListener).SetUnlinkOnClose(true)
+	if mode != 0 {
+		if err := os.Chmod(path, mode); err != nil {
+			ln.Close()
+			return nil, err
+		}
+	}
+	return ln, nil
+}
+
+// removeStaleSocket removes a socket file left behind by a process that
+// exited without shutting down, refusing to take over one that is still
+// being served.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is in use by another process", path)
+	}
+	slog.Info("removing stale socket", "path", path)
+	return os.Remove(path)
+}
+
+// systemdListener returns the first socket passed through LISTEN_FDS, or nil
+// when the process was not socket activated. The variables are cleared so
+// that child processes do not inherit them.
+func systemdListener() (net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	fds := os.Getenv("LISTEN_FDS")
+	for _, name := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		os.Unsetenv(name)
+	}
+	count, err := strconv.Atoi(fds)
+	if err != nil || count < 1 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", fds)
+	}
+	if count > 1 {
+		slog.Warn("systemd passed several sockets; serving the first", "count", count)
+	}
+	f := os.NewFile(uintptr(listenFDsStart), "systemd-socket")
+	defer f.Close()
+	ln, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("systemd socket: %w", err)
+	}
+	return ln, nil
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestListenOnUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chatkit.sock")
+	ln, err := Listen("unix:"+path, 0o660)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o660 {
+		t.Fatalf("expected mode 0660, got %v", info.Mode().Perm())
+	}
+	if _, err := Listen(path, 0); err == nil {
+		t.Fatal("expected a socket in use to be refused")
+	}
+	ln.Close()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected the socket file to be removed on close, got %v", err)
+	}
+}
+
+func TestListenRemovesStaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chatkit.sock")
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	ln, err := Listen(path, 0)
+	if err != nil {
+		t.Fatalf("expected the stale socket to be replaced, got %v", err)
+	}
+	ln.Close()
+
+	regular := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(regular, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Listen(regular, 0); err == nil {
+		t.Fatal("expected a regular file not to be replaced")
+	}
+}
+
+func TestSystemdListenerIgnoresOtherProcesses(t *testing.T) {
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	t.Setenv("LISTEN_FDS", "1")
+	if ln, err := systemdListener(); ln != nil || err != nil {
+		t.Fatalf("expected sockets for another process to be ignored, got %v %v", ln, err)
+	}
+
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "0")
+	if _, err := systemdListener(); err == nil {
+		t.Fatal("expected an invalid LISTEN_FDS to fail")
+	}
+	if os.Getenv("LISTEN_PID") != "" {
+		t.Fatal("expected the activation variables to be cleared")
+	}
+}
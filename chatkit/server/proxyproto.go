@@ -0,0 +1,187 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultProxyHeaderTimeout = 5 * time.Second
+
+// proxyV2Signature starts every PROXY protocol v2 header.
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyProtoListener reads the PROXY protocol header that load balancers in
+// TCP mode, such as HAProxy or an AWS NLB, send ahead of each connection,
+// and reports the client address it carries as the connection's remote
+// address.
+type proxyProtoListener struct {
+	net.Listener
+	trusts  func(network, remote string) bool
+	timeout time.Duration
+}
+
+// NewProxyProtoListener wraps ln to read PROXY protocol headers.
+// Connections from peers that trusts accepts must start with a header;
+// others are served as they are. With a nil trusts, every connection must.
+func NewProxyProtoListener(ln net.Listener, trusts func(network, remote string) bool) net.Listener {
+	return &proxyProtoListener{Listener: ln, trusts: trusts, timeout: defaultProxyHeaderTimeout}
+}
+
+// Accept does not read the header, so that a slow peer does not hold up
+// other connections; it is read on the connection's first use.
+func (l *proxyProtoListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if l.trusts != nil && !l.trusts(conn.LocalAddr().Network(), conn.RemoteAddr().String()) {
+		return conn, nil
+	}
+	return &proxyProtoConn{Conn: conn, r: bufio.NewReader(conn), timeout: l.timeout}, nil
+}
+
+type proxyProtoConn struct {
+	net.Conn
+	r       *bufio.Reader
+	timeout time.Duration
+
+	once   sync.Once
+	remote net.Addr
+	err    error
+}
+
+// init reads the header. A connection without a valid one fails every read.
+func (c *proxyProtoConn) init() {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+		c.remote, c.err = readProxyHeader(c.r)
+		c.Conn.SetReadDeadline(time.Time{})
+		if c.err != nil {
+			slog.Warn("rejected connection without a valid PROXY protocol header", "peer", c.Conn.RemoteAddr().String(), "error", c.err)
+			c.Conn.Close()
+		}
+	})
+}
+
+func (c *proxyProtoConn) Read(b []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(b)
+}
+
+// RemoteAddr returns the client address from the header, or the peer's for
+// health checks the proxy sends on its own behalf.
+func (c *proxyProtoConn) RemoteAddr() net.Addr {
+	c.init()
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// readProxyHeader reads a v1 or v2 header and returns the source address it
+// carries, or nil for LOCAL and UNKNOWN connections.
+func readProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	start, err := r.Peek(len(proxyV2Signature))
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case bytes.Equal(start, proxyV2Signature):
+		return readProxyV2(r)
+	case bytes.HasPrefix(start, []byte("PROXY ")):
+		return readProxyV1(r)
+	default:
+		return nil, errors.New("missing PROXY protocol header")
+	}
+}
+
+// readProxyV1 parses a text header such as
+// "PROXY TCP4 203.0.113.7 10.0.0.1 56324 443\r\n".
+func readProxyV1(r *bufio.Reader) (net.Addr, error) {
+	const maxLen = 107
+	var line []byte
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) == maxLen {
+			return nil, errors.New("PROXY v1 header too long")
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+	}
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("malformed PROXY v1 header %q", strings.TrimSpace(string(line)))
+	}
+	ip, err := netip.ParseAddr(fields[2])
+	if err != nil || ip.Is4() != (fields[1] == "TCP4") {
+		return nil, fmt.Errorf("malformed PROXY v1 source address %q", fields[2])
+	}
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("malformed PROXY v1 source port %q", fields[4])
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, uint16(port))), nil
+}
+
+// readProxyV2 parses a binary header. TLVs after the addresses are skipped.
+func readProxyV2(r *bufio.Reader) (net.Addr, error) {
+	var header [16]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	verCmd, family := header[12], header[13]
+	if verCmd>>4 != 2 {
+		return nil, fmt.Errorf("unsupported PROXY protocol version %d", verCmd>>4)
+	}
+	body := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	switch verCmd & 0x0f {
+	case 0x0:
+		// LOCAL: the proxy's own connection, such as a health check.
+		return nil, nil
+	case 0x1:
+	default:
+		return nil, fmt.Errorf("unsupported PROXY v2 command %d", verCmd&0x0f)
+	}
+	var ip netip.Addr
+	var port uint16
+	switch family >> 4 {
+	case 0x1:
+		if len(body) < 12 {
+			return nil, errors.New("truncated PROXY v2 IPv4 addresses")
+		}
+		ip = netip.AddrFrom4([4]byte(body[0:4]))
+		port = binary.BigEndian.Uint16(body[8:10])
+	case 0x2:
+		if len(body) < 36 {
+			return nil, errors.New("truncated PROXY v2 IPv6 addresses")
+		}
+		ip = netip.AddrFrom16([16]byte(body[0:16])).Unmap()
+		port = binary.BigEndian.Uint16(body[32:34])
+	default:
+		// AF_UNSPEC or AF_UNIX: no usable source address.
+		return nil, nil
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, port)), nil
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// proxyV2Header builds a v2 PROXY header for an IPv4 TCP connection.
+func proxyV2Header(command byte, src, dst [4]byte, srcPort, dstPort uint16) []byte {
+	header := append([]byte(nil), proxyV2Signature...)
+	header = append(header, 0x20|command, 0x11, 0, 12)
+	header = append(header, src[:]...)
+	header = append(header, dst[:]...)
+	header = binary.BigEndian.AppendUint16(header, srcPort)
+	return binary.BigEndian.AppendUint16(header, dstPort)
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   string
+	}{
+		{"v1 tcp4", "PROXY TCP4 203.0.113.7 10.0.0.1 56324 443\r\n", "203.0.113.7:56324"},
+		{"v1 tcp6", "PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n", "[2001:db8::1]:56324"},
+		{"v1 unknown", "PROXY UNKNOWN\r\n", ""},
+		{"v2 proxy", string(proxyV2Header(0x1, [4]byte{198, 51, 100, 4}, [4]byte{10, 0, 0, 1}, 40000, 443)), "198.51.100.4:40000"},
+		{"v2 local", string(proxyV2Header(0x0, [4]byte{}, [4]byte{}, 0, 0)), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := bufio.NewReader(strings.NewReader(tt.header + "GET / HTTP/1.1\r\n"))
+			addr, err := readProxyHeader(r)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got := ""
+			if addr != nil {
+				got = addr.String()
+			}
+			if got != tt.want {
+				t.Fatalf("expected %q, got %q", tt.want, got)
+			}
+			if rest, _ := io.ReadAll(r); string(rest) != "GET / HTTP/1.1\r\n" {
+				t.Fatalf("expected the header to be consumed, left %q", rest)
+			}
+		})
+	}
+
+	for _, header := range []string{
+		"GET / HTTP/1.1\r\n\r\n",
+		"PROXY TCP4 203.0.113.7 10.0.0.1 56324\r\n",
+		"PROXY TCP4 2001:db8::1 10.0.0.1 56324 443\r\n",
+		"PROXY TCP4 203.0.113.7 10.0.0.1 99999 443\r\n",
+		"PROXY TCP4 " + strings.Repeat("1", 120) + "\r\n",
+	} {
+		if _, err := readProxyHeader(bufio.NewReader(strings.NewReader(header))); err == nil {
+			t.Fatalf("expected %q to be rejected", header)
+		}
+	}
+}
+
+func TestProxyProtoListenerReportsClientAddress(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote := make(chan string, 1)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, _ := net.SplitHostPort(r.RemoteAddr)
+		remote <- host
+	})}
+	go srv.Serve(NewProxyProtoListener(ln, nil))
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := io.WriteString(conn, "PROXY TCP4 203.0.113.7 10.0.0.1 56324 80\r\nGET / HTTP/1.1\r\nHost: x\r\n\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	if got := <-remote; got != "203.0.113.7" {
+		t.Fatalf("expected the PROXY source address, got %q", got)
+	}
+
+	bare, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bare.Close()
+	io.WriteString(bare, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+	if _, err := http.ReadResponse(bufio.NewReader(bare), nil); err == nil {
+		t.Fatal("expected a connection without a header to be closed")
+	}
+}
+
+func TestProxyProtoListenerOnlyRequiresHeaderFromTrustedPeers(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	pl := NewProxyProtoListener(ln, func(network, remote string) bool { return false })
+	go func() {
+		if conn, err := net.Dial("tcp", ln.Addr().String()); err == nil {
+			conn.Close()
+		}
+	}()
+	conn, err := pl.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, wrapped := conn.(*proxyProtoConn); wrapped {
+		t.Fatal("expected an untrusted peer's connection to be served as is")
+	}
+}
@@ -0,0 +1,313 @@
+// Package server runs an HTTP server with a middleware chain, start hooks,
+// and staged, time-bounded shutdown, on TCP, Unix, or systemd-activated sockets, with
+// optional PROXY protocol support and reloading TLS certificates.
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Hook is a start or shutdown hook.
+type Hook func(context.Context) error
+
+// Stage orders shutdown work. Stages run one after another, each within its
+// own timeout, so that slow work in one stage cannot eat into the time
+// another needs.
+type Stage int
+
+const (
+	// StageDrain turns away new requests, stops the listeners, and
+	// waits for in-flight requests to finish. Requests still running when
+	// it times out are aborted.
+	StageDrain Stage = iota
+	// StageBackground stops background loops and waits for work
+	// they started, such as shadow requests.
+	StageBackground
+	// StageOutboxes flushes webhook and audit outboxes.
+	StageOutboxes
+	// StageStores closes stores.
+	StageStores
+	// StageMetrics flushes metrics.
+	StageMetrics
+)
+
+var stageOrder = []Stage{StageDrain, StageBackground, StageOutboxes, StageStores, StageMetrics}
+
+var stageNames = map[Stage]string{
+	StageDrain:      "drain",
+	StageBackground: "background",
+	StageOutboxes:   "outboxes",
+	StageStores:     "stores",
+	StageMetrics:    "metrics",
+}
+
+func (s Stage) String() string {
+	return stageNames[s]
+}
+
+// ParseStage returns the stage called name, such as "drain".
+func ParseStage(name string) (Stage, bool) {
+	for stage, stageName := range stageNames {
+		if stageName == name {
+			return stage, true
+		}
+	}
+	return 0, false
+}
+
+// DefaultTimeouts bounds each shutdown stage unless Server.Timeouts says
+// otherwise.
+var DefaultTimeouts = map[Stage]time.Duration{
+	StageDrain:      15 * time.Second,
+	StageBackground: 2 * time.Second,
+	StageOutboxes:   5 * time.Second,
+	StageStores:     2 * time.Second,
+	StageMetrics:    2 * time.Second,
+}
+
+// Middleware wraps a handler with additional behaviour, such as
+// authentication or logging.
+type Middleware func(http.Handler) http.Handler
+
+// Server wraps the HTTP server with start and shutdown hooks so that host
+// applications can tie buffered components (metrics, webhooks, stores) into
+// their own lifecycle management.
+type Server struct {
+	httpServer *http.Server
+	// WrapListener, when set, wraps the listening socket, for example with
+	// NewProxyProtoListener.
+	WrapListener func(net.Listener) net.Listener
+	// SocketMode sets the permissions of a Unix socket listener.
+	SocketMode os.FileMode
+	// Timeouts bounds each shutdown stage. It defaults to DefaultTimeouts.
+	Timeouts map[Stage]time.Duration
+
+	// draining is set when shutdown starts; requests that arrive after it
+	// get 503. inFlight counts requests being handled.
+	draining atomic.Bool
+	inFlight atomic.Int64
+
+	// handler serves requests once the middleware chain is built.
+	handler    http.Handler
+	middleware []Middleware
+
+	// serveErr receives the error that stopped serving, if any.
+	serveErr chan error
+
+	mu         sync.Mutex
+	onStart    []Hook
+	onShutdown map[Stage][]Hook
+	closed     bool
+}
+
+// New wraps httpServer's handler to track in-flight requests and to run
+// the middleware added with Use.
+func New(httpServer *http.Server) *Server {
+	s := &Server{
+		httpServer: httpServer,
+		onShutdown: make(map[Stage][]Hook),
+		Timeouts:   DefaultTimeouts,
+		handler:    httpServer.Handler,
+		serveErr:   make(chan error, 1),
+	}
+	if s.handler == nil {
+		s.handler = http.DefaultServeMux
+	}
+	httpServer.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.draining.Load() {
+			w.Header().Set("Connection", "close")
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "server is shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		s.inFlight.Add(1)
+		defer s.inFlight.Add(-1)
+		s.handler.ServeHTTP(w, r)
+	})
+	return s
+}
+
+// Use appends middleware to the chain around the server's handler. The
+// first middleware added is the outermost, so it sees each request first.
+// Every middleware runs inside the drain check, and the chain is built by
+// Start, so middleware added after Start has no effect.
+func (s *Server) Use(middleware ...Middleware) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.middleware = append(s.middleware, middleware...)
+}
+
+// Chain returns h wrapped in middleware, the first outermost.
+func Chain(h http.Handler, middleware ...Middleware) http.Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h = middleware[i](h)
+	}
+	return h
+}
+
+// OnStart registers fn to run once the listener is open, before the server
+// begins accepting connections.
+func (s *Server) OnStart(fn Hook) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onStart = append(s.onStart, fn)
+}
+
+// OnShutdown registers fn to run in the background stage, once the HTTP
+// server has drained.
+func (s *Server) OnShutdown(fn Hook) {
+	s.OnShutdownStage(StageBackground, fn)
+}
+
+// OnShutdownStage registers fn to run in stage. Hooks within a stage run in
+// reverse registration order.
+func (s *Server) OnShutdownStage(stage Stage, fn Hook) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onShutdown[stage] = append(s.onShutdown[stage], fn)
+}
+
+// Start builds the middleware chain, opens the listener, runs the start
+// hooks, and then serves in the background. If the listener cannot be opened
+// or a start hook fails, Start closes the listener and runs the shutdown
+// stages, so that components whose start hooks already ran are stopped;
+// shutdown hooks must therefore tolerate components that never started. If
+// serving fails later, the error is sent on Err; the server is not shut
+// down.
+func (s *Server) Start(ctx context.Context) error {
+	s.mu.Lock()
+	hooks := append([]Hook(nil), s.onStart...)
+	s.handler = Chain(s.handler, s.middleware...)
+	s.middleware = nil
+	s.mu.Unlock()
+
+	useTLS := s.httpServer.TLSConfig != nil
+	addr := s.httpServer.Addr
+	if addr == "" {
+		addr = ":http"
+		if useTLS {
+			addr = ":https"
+		}
+	}
+	ln, err := Listen(addr, s.SocketMode)
+	if err != nil {
+		return s.abortStart(ctx, err)
+	}
+	if s.WrapListener != nil {
+		ln = s.WrapListener(ln)
+	}
+
+	for _, fn := range hooks {
+		if err := fn(ctx); err != nil {
+			ln.Close()
+			return s.abortStart(ctx, err)
+		}
+	}
+
+	go func() {
+		slog.Info("listening", "addr", ln.Addr().String(), "tls", useTLS)
+		serve := func() error { return s.httpServer.Serve(ln) }
+		if useTLS {
+			// Certificates come from TLSConfig.GetCertificate.
+			serve = func() error { return s.httpServer.ServeTLS(ln, "", "") }
+		}
+		if err := serve(); err != nil && err != http.ErrServerClosed {
+			s.serveErr <- err
+		}
+	}()
+	return nil
+}
+
+// abortStart runs the shutdown stages after Start failed with err, and
+// returns err along with any shutdown error.
+func (s *Server) abortStart(ctx context.Context, err error) error {
+	if shutdownErr := s.Shutdown(ctx); shutdownErr != nil {
+		return errors.Join(err, fmt.Errorf("shutdown after failed start: %w", shutdownErr))
+	}
+	return err
+}
+
+// Err returns a channel that receives the error that stopped the server
+// from serving, other than a shutdown. Callers should watch it alongside
+// their shutdown trigger and call Shutdown or Close when it fires.
+func (s *Server) Err() <-chan error {
+	return s.serveErr
+}
+
+// Shutdown runs every shutdown stage in order, each bounded by its timeout
+// and by ctx. A failing or slow stage does not stop later stages from
+// running. Only the first call has any effect.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	stages := make(map[Stage][]Hook, len(s.onShutdown)+1)
+	for stage, hooks := range s.onShutdown {
+		stages[stage] = append([]Hook(nil), hooks...)
+	}
+	s.mu.Unlock()
+	stages[StageDrain] = append([]Hook{s.drain}, stages[StageDrain]...)
+
+	var errs []error
+	for _, stage := range stageOrder {
+		hooks := stages[stage]
+		if len(hooks) == 0 {
+			continue
+		}
+		if err := s.runShutdownStage(ctx, stage, hooks); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", stage, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// drain turns away new requests and waits for in-flight ones. When ctx ends
+// first, it closes every connection and logs how many requests it aborted.
+func (s *Server) drain(ctx context.Context) error {
+	s.draining.Store(true)
+	slog.Info("draining", "in_flight_requests", s.inFlight.Load())
+	err := s.httpServer.Shutdown(ctx)
+	if err != nil {
+		slog.Warn("drain timed out; aborting in-flight requests", "aborted_requests", s.inFlight.Load())
+		s.httpServer.Close()
+	}
+	return err
+}
+
+func (s *Server) runShutdownStage(ctx context.Context, stage Stage, hooks []Hook) error {
+	ctx, cancel := context.WithTimeout(ctx, s.Timeouts[stage])
+	defer cancel()
+
+	start := time.Now()
+	var errs []error
+	for i := len(hooks) - 1; i >= 0; i-- {
+		if err := hooks[i](ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	err := errors.Join(errs...)
+	if err != nil {
+		slog.Error("shutdown stage failed", "stage", stage.String(), "elapsed_ms", time.Since(start).Milliseconds(), "error", err)
+	} else {
+		slog.Info("shutdown stage finished", "stage", stage.String(), "elapsed_ms", time.Since(start).Milliseconds())
+	}
+	return err
+}
+
+// Close shuts the server down, flushing every component registered through
+// OnShutdown and OnShutdownStage.
+func (s *Server) Close() error {
+	return s.Shutdown(context.Background())
+}
@@ -0,0 +1,231 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestServerStartRunsHooksInOrder(t *testing.T) {
+	srv := New(&http.Server{Addr: "127.0.0.1:0"})
+	var order []string
+	srv.OnStart(func(ctx context.Context) error {
+		order = append(order, "first")
+		return nil
+	})
+	srv.OnStart(func(ctx context.Context) error {
+		order = append(order, "second")
+		return nil
+	})
+
+	if err := srv.Start(context.Background()); err != nil {
+		t.Fatalf("unexpected start error: %v", err)
+	}
+	defer srv.Close()
+
+	if !reflect.DeepEqual(order, []string{"first", "second"}) {
+		t.Fatalf("unexpected start hook order: %v", order)
+	}
+}
+
+func TestServerUseWrapsHandlerInOrder(t *testing.T) {
+	var order []string
+	srv := New(&http.Server{Addr: "127.0.0.1:0", Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		order = append(order, "handler")
+	})})
+	named := func(name string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	srv.Use(named("outer"), named("middle"))
+	srv.Use(named("inner"))
+
+	if err := srv.Start(context.Background()); err != nil {
+		t.Fatalf("unexpected start error: %v", err)
+	}
+	defer srv.Close()
+
+	srv.httpServer.Handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if !reflect.DeepEqual(order, []string{"outer", "middle", "inner", "handler"}) {
+		t.Fatalf("unexpected middleware order: %v", order)
+	}
+}
+
+func TestServerStartHookErrorAborts(t *testing.T) {
+	srv := New(&http.Server{Addr: "127.0.0.1:0"})
+	wantErr := errors.New("boom")
+	var stopped bool
+	srv.OnStart(func(ctx context.Context) error { return nil })
+	srv.OnShutdown(func(ctx context.Context) error {
+		stopped = true
+		return nil
+	})
+	srv.OnStart(func(ctx context.Context) error { return wantErr })
+
+	if err := srv.Start(context.Background()); !errors.Is(err, wantErr) {
+		t.Fatalf("expected start error %v, got %v", wantErr, err)
+	}
+	if !stopped {
+		t.Fatal("expected the shutdown stages to stop what already started")
+	}
+}
+
+func TestServerStartListenErrorRunsNoStartHooks(t *testing.T) {
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+
+	srv := New(&http.Server{Addr: taken.Addr().String()})
+	var started, stopped bool
+	srv.OnStart(func(ctx context.Context) error {
+		started = true
+		return nil
+	})
+	srv.OnShutdownStage(StageOutboxes, func(ctx context.Context) error {
+		stopped = true
+		return nil
+	})
+
+	if err := srv.Start(context.Background()); err == nil {
+		t.Fatal("expected an address in use error")
+	}
+	if started || !stopped {
+		t.Fatalf("expected no start hooks and a shutdown, got started=%v stopped=%v", started, stopped)
+	}
+}
+
+// failingListener fails every Accept, as a listener whose socket broke
+// would.
+type failingListener struct {
+	net.Listener
+	err error
+}
+
+func (l failingListener) Accept() (net.Conn, error) {
+	return nil, l.err
+}
+
+func TestServerReportsServeErrors(t *testing.T) {
+	srv := New(&http.Server{Addr: "127.0.0.1:0"})
+	wantErr := errors.New("listener broke")
+	srv.WrapListener = func(ln net.Listener) net.Listener { return failingListener{Listener: ln, err: wantErr} }
+
+	if err := srv.Start(context.Background()); err != nil {
+		t.Fatalf("unexpected start error: %v", err)
+	}
+	defer srv.Close()
+
+	select {
+	case err := <-srv.Err():
+		if !errors.Is(err, wantErr) {
+			t.Fatalf("expected serve error %v, got %v", wantErr, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the serve error to be reported")
+	}
+}
+
+func TestServerCloseRunsShutdownHooksOnce(t *testing.T) {
+	srv := New(&http.Server{})
+	var order []string
+	srv.OnShutdown(func(ctx context.Context) error {
+		order = append(order, "store")
+		return nil
+	})
+	srv.OnShutdown(func(ctx context.Context) error {
+		order = append(order, "webhooks")
+		return errors.New("flush failed")
+	})
+
+	if err := srv.Close(); err == nil {
+		t.Fatalf("expected hook error to be returned")
+	}
+	if err := srv.Close(); err != nil {
+		t.Fatalf("expected second close to be a no-op, got %v", err)
+	}
+	if !reflect.DeepEqual(order, []string{"webhooks", "store"}) {
+		t.Fatalf("unexpected shutdown hook order: %v", order)
+	}
+}
+
+func TestServerShutdownRunsStagesInOrder(t *testing.T) {
+	srv := New(&http.Server{})
+	srv.Timeouts = map[Stage]time.Duration{
+		StageDrain:      time.Second,
+		StageBackground: 10 * time.Millisecond,
+		StageOutboxes:   time.Second,
+		StageStores:     time.Second,
+		StageMetrics:    time.Second,
+	}
+	var order []string
+	record := func(name string) Hook {
+		return func(ctx context.Context) error {
+			order = append(order, name)
+			return nil
+		}
+	}
+	srv.OnShutdownStage(StageMetrics, record("metrics"))
+	srv.OnShutdownStage(StageOutboxes, record("webhooks"))
+	srv.OnShutdownStage(StageStores, record("store"))
+	srv.OnShutdown(func(ctx context.Context) error {
+		<-ctx.Done()
+		order = append(order, "slow background")
+		return ctx.Err()
+	})
+
+	err := srv.Close()
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the slow stage's timeout to be reported, got %v", err)
+	}
+	if !reflect.DeepEqual(order, []string{"slow background", "webhooks", "store", "metrics"}) {
+		t.Fatalf("unexpected shutdown order: %v", order)
+	}
+}
+
+func TestServerDrainRejectsNewRequests(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	srv := New(&http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})})
+	handler := srv.httpServer.Handler
+
+	done := make(chan int)
+	go func() {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/chatkit/session", nil))
+		done <- rec.Code
+	}()
+	<-started
+	if n := srv.inFlight.Load(); n != 1 {
+		t.Fatalf("expected 1 in-flight request, got %d", n)
+	}
+
+	if err := srv.drain(context.Background()); err != nil {
+		t.Fatalf("unexpected drain error: %v", err)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/chatkit/session", nil))
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected 503 with Retry-After while draining, got %d %v", rec.Code, rec.Header())
+	}
+
+	close(release)
+	if code := <-done; code != http.StatusOK {
+		t.Fatalf("expected the in-flight request to finish, got %d", code)
+	}
+	if n := srv.inFlight.Load(); n != 0 {
+		t.Fatalf("expected no in-flight requests, got %d", n)
+	}
+}
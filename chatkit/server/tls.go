@@ -0,0 +1,247 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+const (
+	// tlsReloadInterval is how often the certificate files are checked for
+	// renewal.
+	tlsReloadInterval = time.Minute
+	// DefaultACMEHTTPAddr is where ACME HTTP-01 challenges are answered.
+	DefaultACMEHTTPAddr   = ":80"
+	acmeReadHeaderTimeout = 5 * time.Second
+)
+
+// CertReloader serves a certificate from files that are replaced on
+// renewal, such as by certbot or cert-manager, reloading them when they
+// change so that renewals take effect without a restart.
+type CertReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime [2]time.Time
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewCertReloader loads the certificate, failing when it is missing or
+// invalid.
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	r := &CertReloader{certFile: certFile, keyFile: keyFile}
+	if _, err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// reload loads the certificate when either file has changed since the last
+// load, and reports whether it did.
+func (r *CertReloader) reload() (bool, error) {
+	var modTime [2]time.Time
+	for i, path := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return false, err
+		}
+		modTime[i] = info.ModTime()
+	}
+	r.mu.RLock()
+	unchanged := r.cert != nil && modTime == r.modTime
+	r.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return false, fmt.Errorf("load TLS certificate: %w", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return false, fmt.Errorf("parse TLS certificate: %w", err)
+	}
+	cert.Leaf = leaf
+
+	r.mu.Lock()
+	r.cert, r.modTime = &cert, modTime
+	r.mu.Unlock()
+	slog.Info("loaded TLS certificate", "subject", leaf.Subject.String(), "not_after", leaf.NotAfter.UTC())
+	return true, nil
+}
+
+// GetCertificate returns the current certificate, for
+// tls.Config.GetCertificate.
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// ExpiresAt returns when the served certificate expires.
+func (r *CertReloader) ExpiresAt() time.Time {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert.Leaf.NotAfter
+}
+
+// Start begins periodic reload checks. A renewal that fails to load is
+// logged and the previous certificate kept.
+func (r *CertReloader) Start(context.Context) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+	r.done = make(chan struct{})
+	go func() {
+		defer close(r.done)
+		ticker := time.NewTicker(tlsReloadInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := r.reload(); err != nil {
+					slog.Error("TLS certificate reload failed; keeping the current certificate", "error", err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// Stop ends periodic reload checks.
+func (r *CertReloader) Stop(ctx context.Context) error {
+	if r.cancel == nil {
+		return nil
+	}
+	r.cancel()
+	select {
+	case <-r.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// clientCertAuthModes maps TLS_CLIENT_AUTH values to how client
+// certificates are checked.
+var clientCertAuthModes = map[string]tls.ClientAuthType{
+	"require":  tls.RequireAndVerifyClientCert,
+	"optional": tls.VerifyClientCertIfGiven,
+}
+
+// ConfigureClientAuth makes cfg verify client certificates against the PEM
+// CA bundle at caFile, so that internal callers authenticate at the
+// transport layer. mode is require or optional; optional still rejects
+// certificates the bundle does not vouch for. It returns when the first CA
+// in the bundle expires.
+func ConfigureClientAuth(cfg *tls.Config, caFile, mode string) (time.Time, error) {
+	authType, ok := clientCertAuthModes[mode]
+	if !ok {
+		return time.Time{}, fmt.Errorf("invalid TLS_CLIENT_AUTH %q: expected require or optional", mode)
+	}
+	data, err := os.ReadFile(caFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+	pool := x509.NewCertPool()
+	var expiresAt time.Time
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		ca, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("%s: %w", caFile, err)
+		}
+		pool.AddCert(ca)
+		if expiresAt.IsZero() || ca.NotAfter.Before(expiresAt) {
+			expiresAt = ca.NotAfter
+		}
+	}
+	if expiresAt.IsZero() {
+		return time.Time{}, fmt.Errorf("no PEM certificates found in %s", caFile)
+	}
+	cfg.ClientCAs = pool
+	cfg.ClientAuth = authType
+	return expiresAt, nil
+}
+
+// NewACMEManager obtains and renews certificates for domains from an ACME
+// CA, Let's Encrypt unless directoryURL is set, caching them in cacheDir so
+// that restarts do not request new ones.
+func NewACMEManager(domains, cacheDir, email, directoryURL string) (*autocert.Manager, error) {
+	var hosts []string
+	for _, d := range strings.Split(domains, ",") {
+		if d = strings.TrimSpace(d); d != "" {
+			hosts = append(hosts, d)
+		}
+	}
+	if len(hosts) == 0 {
+		return nil, errors.New("no ACME domains given")
+	}
+	if cacheDir == "" {
+		return nil, errors.New("ACME_CACHE_DIR is required with ACME_DOMAINS")
+	}
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(cacheDir),
+		HostPolicy: autocert.HostWhitelist(hosts...),
+		Email:      email,
+	}
+	if directoryURL != "" {
+		m.Client = &acme.Client{DirectoryURL: directoryURL}
+	}
+	return m, nil
+}
+
+// ACMEHTTPServer answers ACME HTTP-01 challenges and redirects every other
+// request to HTTPS.
+type ACMEHTTPServer struct {
+	httpServer *http.Server
+}
+
+// NewACMEHTTPServer returns a server for m's challenges on addr.
+func NewACMEHTTPServer(addr string, m *autocert.Manager) *ACMEHTTPServer {
+	return &ACMEHTTPServer{httpServer: &http.Server{
+		Addr:              addr,
+		Handler:           m.HTTPHandler(nil),
+		ReadHeaderTimeout: acmeReadHeaderTimeout,
+	}}
+}
+
+// Start serves challenges in the background.
+func (s *ACMEHTTPServer) Start(context.Context) error {
+	go func() {
+		slog.Info("serving ACME challenges", "addr", s.httpServer.Addr)
+		if err := s.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			slog.Error("ACME challenge server error", "error", err)
+		}
+	}()
+	return nil
+}
+
+// Stop shuts the challenge server down.
+func (s *ACMEHTTPServer) Stop(ctx context.Context) error {
+	return s.httpServer.Shutdown(ctx)
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCertificate writes a self-signed certificate for name and its key
+// to certFile and keyFile.
+func writeTestCertificate(t *testing.T, certFile, keyFile, name string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCertReloaderReloadsRenewedCertificates(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	writeTestCertificate(t, certFile, keyFile, "old.example.com")
+
+	r, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	served := func() string {
+		cert, err := r.GetCertificate(nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return cert.Leaf.Subject.CommonName
+	}
+	if got := served(); got != "old.example.com" {
+		t.Fatalf("expected the initial certificate, got %q", got)
+	}
+	if reloaded, err := r.reload(); err != nil || reloaded {
+		t.Fatalf("expected unchanged files not to reload, got %v %v", reloaded, err)
+	}
+
+	writeTestCertificate(t, certFile, keyFile, "new.example.com")
+	later := time.Now().Add(time.Minute)
+	for _, path := range []string{certFile, keyFile} {
+		if err := os.Chtimes(path, later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if reloaded, err := r.reload(); err != nil || !reloaded {
+		t.Fatalf("expected renewed files to reload, got %v %v", reloaded, err)
+	}
+	if got := served(); got != "new.example.com" {
+		t.Fatalf("expected the renewed certificate, got %q", got)
+	}
+
+	if err := os.WriteFile(keyFile, []byte("not a key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(keyFile, later.Add(time.Minute), later.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.reload(); err == nil {
+		t.Fatal("expected a broken renewal to fail")
+	}
+	if got := served(); got != "new.example.com" {
+		t.Fatalf("expected a broken renewal to keep the previous certificate, got %q", got)
+	}
+}
+
+func TestNewCertReloaderRequiresBothFiles(t *testing.T) {
+	if _, err := NewCertReloader("tls.crt", ""); err == nil {
+		t.Fatal("expected an error without a key file")
+	}
+	if _, err := NewCertReloader(filepath.Join(t.TempDir(), "missing.crt"), "missing.key"); err == nil {
+		t.Fatal("expected an error for missing files")
+	}
+}
+
+func TestNewACMEManager(t *testing.T) {
+	if _, err := NewACMEManager(" , ", t.TempDir(), "", ""); err == nil {
+		t.Fatal("expected an error without domains")
+	}
+	if _, err := NewACMEManager("chat.example.com", "", "", ""); err == nil {
+		t.Fatal("expected an error without a cache directory")
+	}
+	m, err := NewACMEManager("chat.example.com, api.example.com", t.TempDir(), "ops@example.com", "https://acme-staging-v02.api.letsencrypt.org/directory")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := m.HostPolicy(context.Background(), "api.example.com"); err != nil {
+		t.Fatalf("expected a configured domain to be allowed, got %v", err)
+	}
+	if err := m.HostPolicy(context.Background(), "other.example.com"); err == nil {
+		t.Fatal("expected other domains to be refused")
+	}
+	if m.Client.DirectoryURL != "https://acme-staging-v02.api.letsencrypt.org/directory" {
+		t.Fatalf("unexpected directory URL %q", m.Client.DirectoryURL)
+	}
+}
+
+func TestConfigureClientAuthRejectsBadInput(t *testing.T) {
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ConfigureClientAuth(&tls.Config{}, caFile, "require"); err == nil {
+		t.Fatal("expected an error for a bundle without certificates")
+	}
+	if _, err := ConfigureClientAuth(&tls.Config{}, caFile, "sometimes"); err == nil {
+		t.Fatal("expected an error for an unknown mode")
+	}
+}
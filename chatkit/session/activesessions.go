@@ -0,0 +1,243 @@
+package session
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"openai-chatkit-backend/chatkit/redis"
+)
+
+// activeSession is one unexpired session counted against a user's cap.
+type activeSession struct {
+	expiresAt time.Time
+	// id is the session's member in user's shared set; it is empty for
+	// sessions tracked in memory.
+	id   string
+	user string
+}
+
+// activeSessionCap limits how many unexpired sessions each user may hold at
+// once, so that one user opening many tabs cannot exhaust the workflow's
+// OpenAI quota. Sessions are tracked from their expiry alone; they free
+// their slot when they expire. They are tracked in memory unless shared is
+// set, in which case every replica counts them in one Redis sorted set per
+// user, under scope. The in-memory sets then only serve while Redis fails.
+type activeSessionCap struct {
+	limit  int
+	clock  Clock
+	shared *redis.Client
+	scope  string
+
+	mu     sync.Mutex
+	active map[string][]*activeSession
+}
+
+// newActiveSessionCap returns nil when limit is zero, disabling the cap.
+func newActiveSessionCap(limit int64) *activeSessionCap {
+	if limit <= 0 {
+		return nil
+	}
+	return &activeSessionCap{limit: int(limit), clock: SystemClock, active: make(map[string][]*activeSession)}
+}
+
+// clone returns an empty cap with c's limit that counts sessions under
+// scope, for callers that need separate counts, such as each tenant.
+func (c *activeSessionCap) clone(scope string) *activeSessionCap {
+	return &activeSessionCap{limit: c.limit, clock: c.clock, shared: c.shared, scope: scope, active: make(map[string][]*activeSession)}
+}
+
+// reserve takes a slot for a session of user that will last expiresAfter.
+// When the user is at the cap, it reports false with the time the first of
+// their sessions expires.
+func (c *activeSessionCap) reserve(user string, expiresAfter time.Duration) (*activeSession, time.Time, bool) {
+	if c.shared != nil {
+		s, first, ok, err := c.reserveShared(user, expiresAfter)
+		if err == nil {
+			return s, first, ok
+		}
+		c.shared.Warn("shared session cap unavailable; counting this replica's sessions", "error", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.clock.Now()
+	sessions := c.liveLocked(user, now)
+	if len(sessions) >= c.limit {
+		first := sessions[0].expiresAt
+		for _, s := range sessions[1:] {
+			if s.expiresAt.Before(first) {
+				first = s.expiresAt
+			}
+		}
+		return nil, first, false
+	}
+	s := &activeSession{expiresAt: now.Add(expiresAfter)}
+	c.active[user] = append(sessions, s)
+	return s, time.Time{}, true
+}
+
+// peek returns how many unexpired sessions user holds, without reserving
+// anything.
+func (c *activeSessionCap) peek(user string) int {
+	if c.shared != nil {
+		reply, err := c.shared.Do(context.Background(), "ZCOUNT", c.sharedKey(user), "("+strconv.FormatInt(c.clock.Now().UnixMilli(), 10), "+inf")
+		if n, convErr := redis.Int(reply); err == nil && convErr == nil {
+			return int(n)
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.liveLocked(user, c.clock.Now()))
+}
+
+// confirm records the expiry OpenAI reported for a reserved session, in
+// Unix seconds. Zero keeps the estimate made by reserve.
+func (c *activeSessionCap) confirm(s *activeSession, expiresAt int64) {
+	if expiresAt <= 0 {
+		return
+	}
+	if s.id != "" {
+		expiry := strconv.FormatInt(time.Unix(expiresAt, 0).UnixMilli(), 10)
+		if _, err := c.shared.Eval(context.Background(), redisConfirmSession, []string{c.sharedKey(s.user)}, expiry, s.id); err != nil {
+			c.shared.Warn("failed to record session expiry in the shared session cap", "error", err)
+		}
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s.expiresAt = time.Unix(expiresAt, 0)
+}
+
+// release gives back a reservation whose session could not be created.
+func (c *activeSessionCap) release(user string, s *activeSession) {
+	if s.id != "" {
+		if _, err := c.shared.Do(context.Background(), "ZREM", c.sharedKey(user), s.id); err != nil {
+			c.shared.Warn("failed to release a slot in the shared session cap", "error", err)
+		}
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	sessions := c.active[user]
+	for i, other := range sessions {
+		if other == s {
+			sessions = append(sessions[:i], sessions[i+1:]...)
+			break
+		}
+	}
+	if len(sessions) == 0 {
+		delete(c.active, user)
+		return
+	}
+	c.active[user] = sessions
+}
+
+// redisReserveSession drops expired members of the sorted set at KEYS[1]
+// and, unless ARGV[2] remain, adds ARGV[4] expiring at ARGV[3]. Scores are
+// expiries in Unix milliseconds and ARGV[1] is the time now. It returns
+// whether the member was added and, if not, the first expiry.
+const redisReserveSession = `
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', ARGV[1])
+if redis.call('ZCARD', KEYS[1]) >= tonumber(ARGV[2]) then
+	local first = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+	return {0, tonumber(first[2])}
+end
+redis.call('ZADD', KEYS[1], ARGV[3], ARGV[4])
+local last = redis.call('ZRANGE', KEYS[1], -1, -1, 'WITHSCORES')
+redis.call('PEXPIREAT', KEYS[1], last[2])
+return {1, 0}
+`
+
+// redisConfirmSession moves member ARGV[2] of the sorted set at KEYS[1] to
+// expire at ARGV[1], if it is still there.
+const redisConfirmSession = `
+if redis.call('ZADD', KEYS[1], 'XX', 'CH', ARGV[1], ARGV[2]) == 1 then
+	local last = redis.call('ZRANGE', KEYS[1], -1, -1, 'WITHSCORES')
+	redis.call('PEXPIREAT', KEYS[1], last[2])
+end
+return 1
+`
+
+func (c *activeSessionCap) sharedKey(user string) string {
+	return c.shared.Key("sessions", c.scope, user)
+}
+
+func (c *activeSessionCap) reserveShared(user string, expiresAfter time.Duration) (*activeSession, time.Time, bool, error) {
+	id, err := randomID("slot_")
+	if err != nil {
+		return nil, time.Time{}, false, err
+	}
+	now := c.clock.Now()
+	s := &activeSession{expiresAt: now.Add(expiresAfter), id: id, user: user}
+	reply, err := c.shared.Eval(context.Background(), redisReserveSession, []string{c.sharedKey(user)},
+		strconv.FormatInt(now.UnixMilli(), 10),
+		strconv.Itoa(c.limit),
+		strconv.FormatInt(s.expiresAt.UnixMilli(), 10),
+		id,
+	)
+	if err != nil {
+		return nil, time.Time{}, false, err
+	}
+	v, err := redis.Ints(reply, 2)
+	if err != nil {
+		return nil, time.Time{}, false, err
+	}
+	if v[0] == 0 {
+		return nil, time.UnixMilli(v[1]), false, nil
+	}
+	return s, time.Time{}, true, nil
+}
+
+// liveLocked drops user's expired sessions and returns the rest.
+func (c *activeSessionCap) liveLocked(user string, now time.Time) []*activeSession {
+	sessions := c.active[user]
+	live := sessions[:0]
+	for _, s := range sessions {
+		if s.expiresAt.After(now) {
+			live = append(live, s)
+		}
+	}
+	if len(live) == 0 {
+		delete(c.active, user)
+		return nil
+	}
+	c.active[user] = live
+	return live
+}
+
+// prune drops every expired session. It is a no-op on a nil cap.
+func (c *activeSessionCap) prune() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.clock.Now()
+	for user := range c.active {
+		c.liveLocked(user, now)
+	}
+}
+
+// activeSessionLimitError is the 409 body returned when a user already holds
+// as many sessions as the cap allows.
+type activeSessionLimitError struct {
+	Error   string `json:"error"`
+	Message string `json:"message"`
+	Limit   int    `json:"limit"`
+	// ExpiresAt is when the user's first session expires and frees a slot.
+	ExpiresAt string `json:"expires_at"`
+}
+
+func writeActiveSessionLimit(w http.ResponseWriter, now time.Time, limit int, expiresAt time.Time) {
+	retryAfter := int64(expiresAt.Sub(now)/time.Second) + 1
+	w.Header().Set("Retry-After", strconv.FormatInt(retryAfter, 10))
+	writeJSON(w, http.StatusConflict, activeSessionLimitError{
+		Error:     "active_session_limit",
+		Message:   "too many active sessions; close another tab or wait for a session to expire",
+		Limit:     limit,
+		ExpiresAt: expiresAt.UTC().Format(time.RFC3339),
+	})
+}
@@ -0,0 +1,63 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	openai "github.com/openai/openai-go/v3"
+)
+
+func TestHandleSessionActiveSessionCap(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	var fail bool
+	handler := newSessionHandler(func(ctx context.Context, params openai.BetaChatKitSessionNewParams) (*openai.ChatSession, error) {
+		if fail {
+			return nil, errors.New("upstream down")
+		}
+		return &openai.ChatSession{ClientSecret: "s", ExpiresAt: now.Add(10 * time.Minute).Unix()}, nil
+	}, "w", 1200, 10)
+	handler.clock = ClockFunc(func() time.Time { return now })
+	handler.activeSessions = newActiveSessionCap(2)
+	handler.activeSessions.clock = handler.clock
+
+	send := func(user string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/chatkit/session", strings.NewReader(`{"user":"`+user+`"}`))
+		rec := httptest.NewRecorder()
+		handler.handleSession(rec, req)
+		return rec
+	}
+
+	fail = true
+	if rec := send("u"); rec.Code != http.StatusInternalServerError {
+		t.Fatalf("expected the upstream failure, got %d", rec.Code)
+	}
+	fail = false
+	for i := 0; i < 2; i++ {
+		if rec := send("u"); rec.Code != http.StatusOK {
+			t.Fatalf("session %d: expected 200, got %d", i, rec.Code)
+		}
+	}
+
+	rec := send("u")
+	var body activeSessionLimitError
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || rec.Code != http.StatusConflict {
+		t.Fatalf("expected 409, got %d %s", rec.Code, rec.Body)
+	}
+	if body.Limit != 2 || body.ExpiresAt != "2026-03-01T12:10:00Z" || rec.Header().Get("Retry-After") != "601" {
+		t.Fatalf("unexpected limit response: %+v, Retry-After %s", body, rec.Header().Get("Retry-After"))
+	}
+	if rec := send("v"); rec.Code != http.StatusOK {
+		t.Fatalf("expected other users to be unaffected, got %d", rec.Code)
+	}
+
+	now = now.Add(10 * time.Minute)
+	if rec := send("u"); rec.Code != http.StatusOK {
+		t.Fatalf("expected expired sessions to free their slots, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,370 @@
+package session
+
+import (
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"openai-chatkit-backend/chatkit/cors"
+	"openai-chatkit-backend/chatkit/jobs"
+	"openai-chatkit-backend/chatkit/webhooks"
+)
+
+type maintenanceRequest struct {
+	Message string `json:"message"`
+	Until   string `json:"until"`
+}
+
+type suspensionRequest struct {
+	Kind    string `json:"kind"`
+	Value   string `json:"value"`
+	Message string `json:"message"`
+	Until   string `json:"until"`
+}
+
+type banRequest struct {
+	Kind  string `json:"kind"`
+	Value string `json:"value"`
+}
+
+// adminHandler serves the operator-facing API. Every route requires the
+// configured bearer token.
+type adminHandler struct {
+	token         string
+	bans          *banList
+	config        *configDriftDetector
+	webhooks      *webhooks.Dispatcher
+	workflows     *workflowHealth
+	runtime       *runtimeMonitor
+	platforms     *platformCounts
+	maintenance   *maintenanceMode
+	sessions      *sessionHandler
+	cors          *cors.Policy
+	admission     *admissionQueue
+	upstreamQuota *upstreamQuotaMonitor
+	routes        *routeRegistry
+	slo           *latencySLO
+	anomalies     *anomalyDetector
+	journal       *requestJournal
+	lookups       *lookupGuard
+	jobs          *jobs.Scheduler
+	projects      *projectRouter
+	streams       *statusStreams
+	suspensions   *suspensionList
+	transactions  *configTransactions
+}
+
+func newAdminHandler(token string, bans *banList, config *configDriftDetector) *adminHandler {
+	return &adminHandler{token: token, bans: bans, config: config, streams: newStatusStreams()}
+}
+
+func (a *adminHandler) authorized(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) == 1
+}
+
+func (a *adminHandler) requireToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.authorized(r) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// now reads the session handler's clock, so tests can control admin
+// timestamps.
+func (a *adminHandler) now() time.Time {
+	if a.sessions != nil {
+		return a.sessions.clock.Now()
+	}
+	return SystemClock.Now()
+}
+
+func (a *adminHandler) register(routes *routeRegistry) {
+	a.routes = routes
+	routes.handle(http.MethodGet, "/admin/routes", a.routeStats, a.requireToken)
+	routes.handle(http.MethodGet, "/admin/bans", a.listBans, a.requireToken)
+	routes.handle(http.MethodPost, "/admin/bans", a.addBan, a.requireToken)
+	routes.handle(http.MethodDelete, "/admin/bans", a.removeBan, a.requireToken)
+	routes.handle(http.MethodGet, "/admin/config", a.handleConfig, a.requireToken)
+	routes.handle(http.MethodGet, "/admin/status/stream", a.streamStatus, a.requireToken)
+	if a.webhooks != nil {
+		routes.handle(http.MethodGet, "/admin/webhooks", a.webhookStatus, a.requireToken)
+		routes.handle(http.MethodGet, "/admin/webhooks/dead-letters", a.listDeadLetters, a.requireToken)
+	}
+	if a.workflows != nil {
+		routes.handle(http.MethodGet, "/admin/workflows/health", a.workflowHealthReport, a.requireToken)
+	}
+	if a.runtime != nil {
+		routes.handle(http.MethodGet, "/admin/runtime", a.runtimeStats, a.requireToken)
+	}
+	if a.platforms != nil {
+		routes.handle(http.MethodGet, "/admin/platforms", a.platformStats, a.requireToken)
+	}
+	if a.admission != nil {
+		routes.handle(http.MethodGet, "/admin/admission", a.admissionStats, a.requireToken)
+	}
+	if a.journal != nil {
+		routes.handle(http.MethodGet, "/admin/journal", a.listJournal, a.requireToken)
+	}
+	if a.slo != nil {
+		routes.handle(http.MethodGet, "/admin/slo", a.sloStatus, a.requireToken)
+	}
+	if a.anomalies != nil {
+		routes.handle(http.MethodGet, "/admin/anomalies", a.anomalyStatus, a.requireToken)
+	}
+	if a.upstreamQuota != nil {
+		routes.handle(http.MethodGet, "/admin/upstream-quota", a.upstreamQuotaStatus, a.requireToken)
+	}
+	if a.sessions != nil && a.sessions.breaker != nil {
+		routes.handle(http.MethodGet, "/admin/circuit-breaker", a.breakerStatus, a.requireToken)
+	}
+	// Lookups that reveal which users have state on the server go through
+	// the lookup guard.
+	lookupMiddleware := []middleware{a.requireToken}
+	if a.lookups != nil {
+		lookupMiddleware = append(lookupMiddleware, a.lookups.guard)
+	}
+	if a.sessions != nil {
+		routes.handle(http.MethodGet, "/admin/policy", a.simulatePolicy, lookupMiddleware...)
+	}
+	if a.sessions != nil && a.sessions.usage != nil {
+		routes.handle(http.MethodGet, "/admin/usage", a.usageStats, a.requireToken)
+		routes.handle(http.MethodGet, "/admin/usage/sessions", a.usageSessions, lookupMiddleware...)
+	}
+	if a.projects != nil {
+		routes.handle(http.MethodGet, "/admin/openai-projects", a.projectStats, a.requireToken)
+	}
+	if a.jobs != nil {
+		routes.handle(http.MethodGet, "/admin/jobs", a.jobStats, a.requireToken)
+	}
+	if a.suspensions != nil {
+		routes.handle(http.MethodGet, "/admin/suspensions", a.listSuspensions, a.requireToken)
+		routes.handle(http.MethodPost, "/admin/suspensions", a.addSuspension, a.requireToken)
+		routes.handle(http.MethodDelete, "/admin/suspensions", a.removeSuspension, a.requireToken)
+	}
+	if a.transactions != nil {
+		routes.handle(http.MethodGet, "/admin/config/runtime", a.getRuntimeConfig, a.requireToken)
+		routes.handle(http.MethodPost, "/admin/config/transactions", a.applyConfigTransaction, a.requireToken)
+		routes.handle(http.MethodPost, "/admin/config/rollback", a.rollbackConfigTransaction, a.requireToken)
+	}
+	if a.maintenance != nil {
+		routes.handle(http.MethodGet, "/admin/maintenance", a.getMaintenance, a.requireToken)
+		routes.handle(http.MethodPost, "/admin/maintenance", a.enableMaintenance, a.requireToken)
+		routes.handle(http.MethodDelete, "/admin/maintenance", a.disableMaintenance, a.requireToken)
+	}
+}
+
+func (a *adminHandler) listBans(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.bans.snapshot())
+}
+
+func (a *adminHandler) addBan(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodyBytes)
+
+	var req banRequest
+	problems, err := decodeJSONObject(r.Body, &req)
+	if err != nil {
+		http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if len(problems) > 0 {
+		writeValidationErrors(w, problems)
+		return
+	}
+	a.updateBans(w, "add", req, a.bans.add)
+}
+
+func (a *adminHandler) removeBan(w http.ResponseWriter, r *http.Request) {
+	req := banRequest{Kind: r.URL.Query().Get("kind"), Value: r.URL.Query().Get("value")}
+	a.updateBans(w, "remove", req, a.bans.remove)
+}
+
+func (a *adminHandler) updateBans(w http.ResponseWriter, action string, req banRequest, apply func(kind, value string) error) {
+	if !validBanKind(req.Kind) {
+		http.Error(w, "kind must be one of user, ip, device", http.StatusBadRequest)
+		return
+	}
+	if req.Value == "" {
+		http.Error(w, "value is required", http.StatusBadRequest)
+		return
+	}
+	if err := apply(req.Kind, req.Value); err != nil {
+		slog.Error("failed to persist ban list", "error", err)
+		http.Error(w, "failed to persist ban list", http.StatusInternalServerError)
+		return
+	}
+	slog.Info("admin updated ban list", "action", action, "kind", req.Kind, "value", req.Value)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *adminHandler) handleConfig(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.config.snapshot())
+}
+
+func (a *adminHandler) listDeadLetters(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"dead_letters": a.webhooks.DeadLetters()})
+}
+
+func (a *adminHandler) webhookStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.webhooks.Status())
+}
+
+func (a *adminHandler) workflowHealthReport(w http.ResponseWriter, r *http.Request) {
+	report := a.workflows.check(r.Context())
+	status := http.StatusOK
+	if !report.Healthy {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, report)
+}
+
+func (a *adminHandler) runtimeStats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.runtime.snapshot())
+}
+
+func (a *adminHandler) platformStats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"platforms": a.platforms.snapshot()})
+}
+
+func (a *adminHandler) admissionStats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.admission.snapshot())
+}
+
+func (a *adminHandler) upstreamQuotaStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.upstreamQuota.snapshot())
+}
+
+func (a *adminHandler) breakerStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.sessions.breaker.snapshot())
+}
+
+func (a *adminHandler) projectStats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"projects": a.projects.snapshot()})
+}
+
+func (a *adminHandler) jobStats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"jobs": a.jobs.Snapshot()})
+}
+
+func (a *adminHandler) routeStats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"routes": a.routes.stats()})
+}
+
+func (a *adminHandler) sloStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.slo.snapshot())
+}
+
+func (a *adminHandler) listJournal(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"entries": a.journal.snapshot()})
+}
+
+func (a *adminHandler) getMaintenance(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.maintenance.snapshot())
+}
+
+func (a *adminHandler) enableMaintenance(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodyBytes)
+
+	var req maintenanceRequest
+	problems, err := decodeJSONObject(r.Body, &req)
+	if err != nil {
+		http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	status := maintenanceStatus{Enabled: true, Message: req.Message}
+	if req.Until != "" && !hasFieldError(problems, "until") {
+		until, err := time.Parse(time.RFC3339, req.Until)
+		if err != nil {
+			problems = append(problems, fieldError{Field: "until", Code: validationCodeInvalidValue, Message: "until must be an RFC 3339 timestamp"})
+		}
+		status.Until = &until
+	}
+	if len(problems) > 0 {
+		writeValidationErrors(w, problems)
+		return
+	}
+	a.maintenance.set(status)
+	slog.Info("admin enabled maintenance mode")
+	writeJSON(w, http.StatusOK, status)
+}
+
+func (a *adminHandler) disableMaintenance(w http.ResponseWriter, r *http.Request) {
+	a.maintenance.set(maintenanceStatus{})
+	slog.Info("admin disabled maintenance mode")
+	writeJSON(w, http.StatusOK, maintenanceStatus{})
+}
+
+func (a *adminHandler) listSuspensions(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"suspensions": a.suspensions.snapshot()})
+}
+
+func (a *adminHandler) addSuspension(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodyBytes)
+
+	var req suspensionRequest
+	problems, err := decodeJSONObject(r.Body, &req)
+	if err != nil {
+		http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if !validSuspensionKind(req.Kind) && !hasFieldError(problems, "kind") {
+		problems = append(problems, fieldError{Field: "kind", Code: validationCodeInvalidValue, Message: "kind must be one of tenant, workflow"})
+	}
+	if req.Value == "" && !hasFieldError(problems, "value") {
+		problems = append(problems, fieldError{Field: "value", Code: validationCodeRequired, Message: "value is required"})
+	}
+	s := suspension{Kind: req.Kind, Value: req.Value, Message: req.Message, Since: a.now().UTC()}
+	if req.Until != "" && !hasFieldError(problems, "until") {
+		until, err := time.Parse(time.RFC3339, req.Until)
+		if err != nil {
+			problems = append(problems, fieldError{Field: "until", Code: validationCodeInvalidValue, Message: "until must be an RFC 3339 timestamp"})
+		}
+		s.Until = &until
+	}
+	if len(problems) > 0 {
+		writeValidationErrors(w, problems)
+		return
+	}
+	if err := a.suspensions.set(s); err != nil {
+		slog.Error("failed to persist suspensions", "error", err)
+		http.Error(w, "failed to persist suspensions", http.StatusInternalServerError)
+		return
+	}
+	slog.Info("admin suspended session creation", "kind", s.Kind, "value", s.Value)
+	writeJSON(w, http.StatusOK, s)
+}
+
+func (a *adminHandler) removeSuspension(w http.ResponseWriter, r *http.Request) {
+	kind, value := r.URL.Query().Get("kind"), r.URL.Query().Get("value")
+	if !validSuspensionKind(kind) || value == "" {
+		http.Error(w, "kind (tenant or workflow) and value are required", http.StatusBadRequest)
+		return
+	}
+	if err := a.suspensions.remove(kind, value); err != nil {
+		slog.Error("failed to persist suspensions", "error", err)
+		http.Error(w, "failed to persist suspensions", http.StatusInternalServerError)
+		return
+	}
+	slog.Info("admin lifted suspension", "kind", kind, "value", value)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *adminHandler) simulatePolicy(w http.ResponseWriter, r *http.Request) {
+	q := policyQueryFromRequest(r)
+	if q.User == "" {
+		http.Error(w, "user is required", http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, http.StatusOK, a.sessions.simulatePolicy(q, a.cors, a.sessions.clock.Now()))
+}
@@ -0,0 +1,135 @@
+package session
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAdminBansRequiresToken(t *testing.T) {
+	bans, _ := newBanList("")
+	router, err := newRouter(newSessionHandler(nil, "w", 1200, 10), newAdminHandler("s3cret", bans, newConfigDriftDetector(nil, "")), nil, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected router error: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/bans", nil)
+	req.Header.Set("Authorization", "Bearer wrong")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status 401, got %d", rec.Code)
+	}
+}
+
+func TestAdminBansLifecycle(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bans.json")
+	bans, err := newBanList(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sessions := newSessionHandler(nil, "w", 1200, 10)
+	sessions.bans = bans
+	router, err := newRouter(sessions, newAdminHandler("s3cret", bans, newConfigDriftDetector(nil, "")), nil, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected router error: %v", err)
+	}
+
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer s3cret")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do(http.MethodPost, "/admin/bans", `{"kind":"user","value":"mallory"}`); rec.Code != http.StatusNoContent {
+		t.Fatalf("expected status 204, got %d", rec.Code)
+	}
+	if rec := do(http.MethodPost, "/admin/bans", `{"kind":"planet","value":"x"}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400 for invalid kind, got %d", rec.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/chatkit/session", strings.NewReader(`{"user":"mallory"}`))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("expected banned user to get 403, got %d", rec.Code)
+	}
+
+	reloaded, err := newBanList(path)
+	if err != nil {
+		t.Fatalf("unexpected error reloading: %v", err)
+	}
+	if !reloaded.banned("mallory", "", "") {
+		t.Fatalf("expected ban to be persisted")
+	}
+
+	if rec := do(http.MethodDelete, "/admin/bans?kind=user&value=mallory", ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("expected status 204, got %d", rec.Code)
+	}
+	if rec := do(http.MethodGet, "/admin/bans", ""); !strings.Contains(rec.Body.String(), `"users":[]`) {
+		t.Fatalf("expected empty user bans, got %s", rec.Body.String())
+	}
+}
+
+func TestAdminStatusStream(t *testing.T) {
+	bans, _ := newBanList("")
+	sessions := newSessionHandler(nil, "w", 1200, 10)
+	sessions.breaker = newCircuitBreaker(3, time.Minute)
+	admin := newAdminHandler("s3cret", bans, newConfigDriftDetector(nil, ""))
+	admin.sessions = sessions
+	router, err := newRouter(sessions, admin, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected router error: %v", err)
+	}
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	get := func(query string) *http.Response {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+"/admin/status/stream"+query, nil)
+		req.Header.Set("Authorization", "Bearer s3cret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return resp
+	}
+	if resp := get("?interval=0"); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a bad interval, got %d", resp.StatusCode)
+	}
+
+	resp := get("?interval=1")
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+	events := bufio.NewScanner(resp.Body)
+	var data string
+	for events.Scan() {
+		if d, ok := strings.CutPrefix(events.Text(), "data: "); ok {
+			data = d
+			break
+		}
+	}
+	var snap statusSnapshot
+	if err := json.Unmarshal([]byte(data), &snap); err != nil {
+		t.Fatalf("unexpected event %q: %v", data, err)
+	}
+	if snap.Breaker == nil || snap.Breaker.State != "closed" || snap.Admission != nil {
+		t.Fatalf("unexpected snapshot: %+v", snap)
+	}
+
+	admin.streams.close()
+	for events.Scan() {
+	}
+	if err := events.Err(); err != nil {
+		t.Fatalf("expected the stream to end cleanly, got %v", err)
+	}
+}
@@ -0,0 +1,333 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	degradedReasonCapacity = "high_demand"
+	capacityMessage        = "We're seeing high demand right now. Please retry in a few seconds."
+
+	defaultAdmissionMaxWait = 5 * time.Second
+	// admissionServiceSmoothing weights the latest upstream call in the
+	// moving average used for wait estimates.
+	admissionServiceSmoothing = 0.2
+	initialAdmissionService   = time.Second
+)
+
+// admissionQueue bounds concurrent upstream session calls. Requests beyond
+// the limit wait in a bounded queue; once the queue is full, or a request
+// has waited maxWait, it is turned away with its queue position and an
+// estimated wait.
+//
+// Waiting requests are queued per tenant, and freed slots go to the tenants
+// in weighted round-robin order, so one tenant's burst cannot starve the
+// others. Each tenant gets as many slots in a row as its weight before the
+// next tenant's turn; requests within a tenant are admitted in order. When
+// the queue is full, a request from a tenant holding less than its weighted
+// share of the queue takes the place of the newest waiter of the tenant
+// holding the most, so a flooding tenant cannot keep everyone else out.
+type admissionQueue struct {
+	clock    Clock
+	capacity int
+	maxQueue int
+	maxWait  time.Duration
+	// weights maps tenant names to their weight. Tenants not listed, and
+	// requests without a tenant, weigh 1.
+	weights map[string]int
+
+	mu         sync.Mutex
+	inFlight   int
+	waiting    int
+	queues     map[string]*tenantAdmissionQueue
+	ring       []string
+	turn       int
+	avgService time.Duration
+	admitted   int64
+	rejected   int64
+}
+
+// tenantAdmissionQueue holds one tenant's waiting requests. credit counts
+// the slots left in the tenant's current turn.
+type tenantAdmissionQueue struct {
+	waiters []*admissionWaiter
+	credit  int
+}
+
+type admissionWaiter struct {
+	tenant string
+	// ready is closed once the waiter holds a slot or was shed to make room
+	// for another tenant; granted or shed is set at the same time, under the
+	// queue's lock.
+	ready   chan struct{}
+	granted bool
+	shed    bool
+}
+
+// admissionRejection tells a turned-away caller where it stood.
+type admissionRejection struct {
+	position      int
+	estimatedWait time.Duration
+}
+
+type admissionStats struct {
+	Capacity             int   `json:"capacity"`
+	InFlight             int   `json:"in_flight"`
+	Queued               int   `json:"queued"`
+	QueueLimit           int   `json:"queue_limit"`
+	Admitted             int64 `json:"admitted"`
+	Rejected             int64 `json:"rejected"`
+	EstimatedWaitMS      int64 `json:"estimated_wait_ms"`
+	AverageServiceTimeMS int64 `json:"average_service_time_ms"`
+}
+
+func newAdmissionQueue(concurrency, maxQueue int, maxWait time.Duration) *admissionQueue {
+	return &admissionQueue{
+		clock:      SystemClock,
+		capacity:   concurrency,
+		maxQueue:   maxQueue,
+		maxWait:    maxWait,
+		queues:     make(map[string]*tenantAdmissionQueue),
+		avgService: initialAdmissionService,
+	}
+}
+
+// parseTenantWeights parses comma-separated tenant=weight entries, e.g.
+// "acme=3,globex=1".
+func parseTenantWeights(spec string) (map[string]int, error) {
+	weights := make(map[string]int)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid tenant weight %q: expected tenant=weight", entry)
+		}
+		weight, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || weight < 1 {
+			return nil, fmt.Errorf("invalid tenant weight %q: weight must be a positive integer", entry)
+		}
+		weights[strings.TrimSpace(name)] = weight
+	}
+	return weights, nil
+}
+
+func (q *admissionQueue) weight(tenant string) int {
+	if w := q.weights[tenant]; w > 0 {
+		return w
+	}
+	return 1
+}
+
+// acquire waits for an upstream slot for a request of tenant, which is ""
+// for requests without one. On success it returns a release func that must
+// be called once the upstream call finishes.
+func (q *admissionQueue) acquire(ctx context.Context, tenant string) (func(), *admissionRejection) {
+	q.mu.Lock()
+	if q.inFlight < q.capacity && q.waiting == 0 {
+		q.inFlight++
+		q.mu.Unlock()
+		return q.admit(), nil
+	}
+	if q.waiting >= q.maxQueue && !q.shedForLocked(tenant) {
+		rej := &admissionRejection{position: q.waiting + 1, estimatedWait: q.estimateLocked(q.waiting + 1)}
+		q.rejected++
+		q.mu.Unlock()
+		return nil, rej
+	}
+	w := &admissionWaiter{tenant: tenant, ready: make(chan struct{})}
+	tq := q.queues[tenant]
+	if tq == nil {
+		tq = &tenantAdmissionQueue{}
+		q.queues[tenant] = tq
+		q.ring = append(q.ring, tenant)
+	}
+	tq.waiters = append(tq.waiters, w)
+	q.waiting++
+	position := q.waiting
+	q.mu.Unlock()
+
+	timer := time.NewTimer(q.maxWait)
+	defer timer.Stop()
+	select {
+	case <-w.ready:
+		if w.granted {
+			return q.admit(), nil
+		}
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	switch {
+	case w.granted:
+		// The slot arrived as the wait ended; pass it on.
+		q.inFlight--
+		q.dispatchLocked()
+	case !w.shed:
+		q.removeLocked(w)
+	}
+	q.rejected++
+	if position > q.waiting+1 {
+		position = q.waiting + 1
+	}
+	return nil, &admissionRejection{position: position, estimatedWait: q.estimateLocked(position)}
+}
+
+// dispatchLocked hands free slots to waiting requests, taking turns across
+// tenants.
+func (q *admissionQueue) dispatchLocked() {
+	for q.inFlight < q.capacity && q.waiting > 0 {
+		if q.turn >= len(q.ring) {
+			q.turn = 0
+		}
+		tenant := q.ring[q.turn]
+		tq := q.queues[tenant]
+		if tq.credit <= 0 {
+			tq.credit = q.weight(tenant)
+		}
+		w := tq.waiters[0]
+		tq.waiters = tq.waiters[1:]
+		tq.credit--
+		q.waiting--
+		q.inFlight++
+		w.granted = true
+		close(w.ready)
+
+		switch {
+		case len(tq.waiters) == 0:
+			// Dropping the tenant from the ring moves the turn on.
+			q.dropTenantLocked(q.turn)
+		case tq.credit == 0:
+			q.turn++
+		}
+	}
+}
+
+// shedForLocked makes room in a full queue for a request of tenant by
+// turning away the newest waiter of the tenant with the largest share of
+// the queue relative to its weight. It refuses when tenant's share, counting
+// the new request, would not stay below that tenant's.
+func (q *admissionQueue) shedForLocked(tenant string) bool {
+	var victim string
+	var most *tenantAdmissionQueue
+	for _, name := range q.ring {
+		tq := q.queues[name]
+		if most == nil || len(tq.waiters)*q.weight(victim) > len(most.waiters)*q.weight(name) {
+			victim, most = name, tq
+		}
+	}
+	if most == nil || victim == tenant {
+		return false
+	}
+	own := 0
+	if tq := q.queues[tenant]; tq != nil {
+		own = len(tq.waiters)
+	}
+	if (own+1)*q.weight(victim) >= len(most.waiters)*q.weight(tenant) {
+		return false
+	}
+	w := most.waiters[len(most.waiters)-1]
+	q.removeLocked(w)
+	w.shed = true
+	close(w.ready)
+	return true
+}
+
+// removeLocked takes a waiter that gave up out of its tenant's queue.
+func (q *admissionQueue) removeLocked(w *admissionWaiter) {
+	tq := q.queues[w.tenant]
+	for i, other := range tq.waiters {
+		if other == w {
+			tq.waiters = append(tq.waiters[:i], tq.waiters[i+1:]...)
+			q.waiting--
+			break
+		}
+	}
+	if len(tq.waiters) > 0 {
+		return
+	}
+	for i, tenant := range q.ring {
+		if tenant == w.tenant {
+			q.dropTenantLocked(i)
+			if i < q.turn {
+				q.turn--
+			}
+			return
+		}
+	}
+}
+
+func (q *admissionQueue) dropTenantLocked(i int) {
+	delete(q.queues, q.ring[i])
+	q.ring = append(q.ring[:i], q.ring[i+1:]...)
+}
+
+func (q *admissionQueue) admit() func() {
+	start := q.clock.Now()
+	q.mu.Lock()
+	q.admitted++
+	q.mu.Unlock()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			elapsed := q.clock.Now().Sub(start)
+			q.mu.Lock()
+			defer q.mu.Unlock()
+			q.avgService = time.Duration(float64(q.avgService)*(1-admissionServiceSmoothing) + float64(elapsed)*admissionServiceSmoothing)
+			q.inFlight--
+			q.dispatchLocked()
+		})
+	}
+}
+
+// estimateLocked estimates how long the request at position would wait for
+// a slot.
+func (q *admissionQueue) estimateLocked(position int) time.Duration {
+	rounds := (position + q.capacity - 1) / q.capacity
+	return time.Duration(rounds) * q.avgService
+}
+
+func (q *admissionQueue) snapshot() admissionStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return admissionStats{
+		Capacity:             q.capacity,
+		InFlight:             q.inFlight,
+		Queued:               q.waiting,
+		QueueLimit:           q.maxQueue,
+		Admitted:             q.admitted,
+		Rejected:             q.rejected,
+		EstimatedWaitMS:      q.estimateLocked(q.waiting + 1).Milliseconds(),
+		AverageServiceTimeMS: q.avgService.Milliseconds(),
+	}
+}
+
+// writeCapacityExceeded writes the degraded response for a request turned
+// away by the admission queue.
+func writeCapacityExceeded(w http.ResponseWriter, rej *admissionRejection, links degradedLinks) {
+	wait := int64((rej.estimatedWait + time.Second - 1) / time.Second)
+	if wait < 1 {
+		wait = 1
+	}
+	w.Header().Set("Retry-After", strconv.FormatInt(wait, 10))
+	resp := degradedResponse{
+		Error:                "degraded",
+		Reason:               degradedReasonCapacity,
+		Message:              capacityMessage,
+		RetryAfterSeconds:    wait,
+		QueuePosition:        rej.position,
+		EstimatedWaitSeconds: wait,
+	}
+	links.apply(&resp)
+	writeJSON(w, http.StatusServiceUnavailable, resp)
+}
@@ -0,0 +1,227 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openai/openai-go/v3"
+)
+
+func TestAdmissionQueueRejectsWhenFull(t *testing.T) {
+	q := newAdmissionQueue(1, 0, time.Second)
+	release, rejected := q.acquire(context.Background(), "")
+	if rejected != nil {
+		t.Fatalf("expected first request to be admitted")
+	}
+	if _, rejected := q.acquire(context.Background(), ""); rejected == nil || rejected.position != 1 || rejected.estimatedWait <= 0 {
+		t.Fatalf("expected rejection at position 1 with a wait estimate, got %+v", rejected)
+	}
+	release()
+	release()
+	if _, rejected := q.acquire(context.Background(), ""); rejected != nil {
+		t.Fatalf("expected slot to be free after release")
+	}
+	if stats := q.snapshot(); stats.Admitted != 2 || stats.Rejected != 1 || stats.InFlight != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestAdmissionQueueWaitsForSlot(t *testing.T) {
+	q := newAdmissionQueue(1, 1, time.Second)
+	release, _ := q.acquire(context.Background(), "")
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		release()
+	}()
+	if _, rejected := q.acquire(context.Background(), ""); rejected != nil {
+		t.Fatalf("expected queued request to be admitted once the slot freed up")
+	}
+}
+
+func TestAdmissionQueueMaxWait(t *testing.T) {
+	q := newAdmissionQueue(1, 1, 10*time.Millisecond)
+	q.acquire(context.Background(), "")
+	_, rejected := q.acquire(context.Background(), "")
+	if rejected == nil || rejected.position != 1 {
+		t.Fatalf("expected queued request to time out at position 1, got %+v", rejected)
+	}
+	if stats := q.snapshot(); stats.Queued != 0 {
+		t.Fatalf("expected empty queue after timeout, got %+v", stats)
+	}
+}
+
+func TestAdmissionQueueIsFairAcrossTenants(t *testing.T) {
+	type admission struct {
+		tenant  string
+		release func()
+	}
+	for name, tc := range map[string]struct {
+		weights map[string]int
+		want    string
+	}{
+		"round robin": {nil, "abcaa"},
+		"weighted":    {map[string]int{"a": 2}, "aabca"},
+	} {
+		q := newAdmissionQueue(1, 10, time.Second)
+		q.weights = tc.weights
+		hold, _ := q.acquire(context.Background(), "")
+		admitted := make(chan admission)
+		for i, tenant := range []string{"a", "a", "a", "b", "c"} {
+			go func() {
+				release, rejected := q.acquire(context.Background(), tenant)
+				if rejected != nil {
+					t.Errorf("%s: unexpected rejection of %s", name, tenant)
+					return
+				}
+				admitted <- admission{tenant, release}
+			}()
+			// Queue the requests in order.
+			for q.snapshot().Queued != i+1 {
+				time.Sleep(time.Millisecond)
+			}
+		}
+
+		hold()
+		var got string
+		for range tc.want {
+			a := <-admitted
+			got += a.tenant
+			a.release()
+		}
+		if got != tc.want {
+			t.Fatalf("%s: expected admission order %s, got %s", name, tc.want, got)
+		}
+		if stats := q.snapshot(); stats.InFlight != 0 || stats.Queued != 0 {
+			t.Fatalf("%s: unexpected stats: %+v", name, stats)
+		}
+	}
+}
+
+func TestParseTenantWeights(t *testing.T) {
+	weights, err := parseTenantWeights(" acme=3, globex = 1 ")
+	if err != nil || weights["acme"] != 3 || weights["globex"] != 1 {
+		t.Fatalf("unexpected weights %v, %v", weights, err)
+	}
+	for _, spec := range []string{"acme", "acme=0", "acme=x"} {
+		if _, err := parseTenantWeights(spec); err == nil {
+			t.Fatalf("expected %q to be rejected", spec)
+		}
+	}
+}
+
+func TestHandleSessionHighDemand(t *testing.T) {
+	fake := &fakeSessionCreator{clientSecret: "secret"}
+	handler := newSessionHandler(fake.Create, "w", 1200, 10)
+	handler.admission = newAdmissionQueue(1, 0, time.Second)
+	handler.quota = newQuotaTracker(1, 0, time.Hour)
+	hold, _ := handler.admission.acquire(context.Background(), "")
+	defer hold()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/chatkit/session", strings.NewReader(`{"user":"u"}`))
+	rec := httptest.NewRecorder()
+	handler.handleSession(rec, req)
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503, got %d", rec.Code)
+	}
+	var resp degradedResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Reason != degradedReasonCapacity || resp.QueuePosition != 1 || resp.EstimatedWaitSeconds < 1 {
+		t.Fatalf("unexpected degraded response: %+v", resp)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected Retry-After header")
+	}
+	if fake.called {
+		t.Fatalf("session creator should not be called when the queue is full")
+	}
+	if _, ok := handler.quota.reserve("u"); !ok {
+		t.Fatalf("expected quota to be refunded")
+	}
+}
+
+func TestHandleSessionReleasesAdmissionSlot(t *testing.T) {
+	handler := newSessionHandler(func(ctx context.Context, params openai.BetaChatKitSessionNewParams) (*openai.ChatSession, error) {
+		return nil, upstreamStatusError(http.StatusBadGateway)
+	}, "w", 1200, 10)
+	handler.admission = newAdmissionQueue(1, 0, time.Second)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/chatkit/session", strings.NewReader(`{"user":"u"}`))
+	handler.handleSession(httptest.NewRecorder(), req)
+
+	if stats := handler.admission.snapshot(); stats.InFlight != 0 {
+		t.Fatalf("expected slot to be released, got %+v", stats)
+	}
+}
+
+func TestAdmissionQueueMeasuresServiceTimeWithItsClock(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	q := newAdmissionQueue(1, 0, time.Second)
+	q.clock = ClockFunc(func() time.Time { return now })
+	before := q.snapshot().AverageServiceTimeMS
+
+	release, _ := q.acquire(context.Background(), "")
+	now = now.Add(time.Hour)
+	release()
+	if got := q.snapshot().AverageServiceTimeMS; got <= before {
+		t.Fatalf("expected the hour-long request to raise the average service time from %dms, got %dms", before, got)
+	}
+}
+
+func TestAdmissionQueueShedsTheLongestTenantQueue(t *testing.T) {
+	q := newAdmissionQueue(1, 3, time.Minute)
+	hold, _ := q.acquire(context.Background(), "")
+	type result struct {
+		tenant   string
+		release  func()
+		rejected *admissionRejection
+	}
+	results := make(chan result)
+	enqueue := func(tenant string) {
+		go func() {
+			release, rejected := q.acquire(context.Background(), tenant)
+			results <- result{tenant, release, rejected}
+		}()
+	}
+	for i := 1; i <= 3; i++ {
+		enqueue("flood")
+		for q.snapshot().Queued != i {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// The flooding tenant already holds the whole queue.
+	if _, rejected := q.acquire(context.Background(), "flood"); rejected == nil {
+		t.Fatal("expected the flooding tenant to be turned away from a full queue")
+	}
+
+	// Another tenant takes the place of the flooding tenant's newest waiter.
+	enqueue("quiet")
+	if r := <-results; r.tenant != "flood" || r.rejected == nil {
+		t.Fatalf("expected a flooding waiter to be shed, got %s rejected=%v", r.tenant, r.rejected)
+	}
+	if stats := q.snapshot(); stats.Queued != 3 {
+		t.Fatalf("expected the queue to stay full, got %+v", stats)
+	}
+
+	hold()
+	var order string
+	for range 3 {
+		r := <-results
+		if r.rejected != nil {
+			t.Fatalf("unexpected rejection of %s", r.tenant)
+		}
+		order += r.tenant[:1]
+		r.release()
+	}
+	if order != "fqf" {
+		t.Fatalf("expected the quiet tenant to be admitted on its turn, got order %s", order)
+	}
+}
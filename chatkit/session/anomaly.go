@@ -0,0 +1,280 @@
+package session
+
+import (
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultAnomalyMinSessions       = 20
+	defaultAnomalyThrottleFor       = 15 * time.Minute
+	anomalyBaselineMinutes          = 60
+	anomalyWarmupMinutes            = 10
+	anomalyZScore                   = 4
+	anomalySurgeFactor              = 3
+	anomalyIdleExpiry               = 24 * time.Hour
+	maxAnomalyKeys                  = 10000
+	maxAnomalyAlerts                = 50
+	anomalyDimensionTenant          = "tenant"
+	anomalyDimensionOrigin          = "origin"
+	anomalyKindSurge                = "surge"
+	anomalyKindNewOrigin            = "new_origin"
+	anomalyDefaultTenant            = "default"
+	anomalyBaselineAlpha            = 2.0 / (anomalyBaselineMinutes + 1)
+	anomalyMaxFoldedMinutes   int64 = 3 * anomalyBaselineMinutes
+)
+
+// anomalyAlert describes an unusual burst of session mints.
+type anomalyAlert struct {
+	Kind      string `json:"kind"`
+	Dimension string `json:"dimension"`
+	Value     string `json:"value"`
+	// Count is how many sessions were minted in the current minute, against
+	// a baseline of Baseline per minute.
+	Count          int64      `json:"count"`
+	Baseline       float64    `json:"baseline"`
+	Threshold      float64    `json:"threshold"`
+	DetectedAt     time.Time  `json:"detected_at"`
+	ThrottledUntil *time.Time `json:"throttled_until,omitempty"`
+}
+
+// anomalyBaseline is one tenant's or origin's minted sessions per minute,
+// as an exponentially weighted mean and variance over about an hour.
+type anomalyBaseline struct {
+	mean     float64
+	variance float64
+	minutes  int64
+	minute   int64
+	count    int64
+	alerted  bool
+}
+
+// fold closes out minutes up to minute, counting the minutes without
+// sessions as zeros.
+func (b *anomalyBaseline) fold(minute int64) {
+	if b.minute == minute {
+		return
+	}
+	steps := min(minute-b.minute, anomalyMaxFoldedMinutes)
+	for i := int64(0); i < steps; i++ {
+		x := 0.0
+		if i == 0 {
+			x = float64(b.count)
+		}
+		if b.minutes == 0 {
+			b.mean = x
+		} else {
+			diff := x - b.mean
+			incr := anomalyBaselineAlpha * diff
+			b.mean += incr
+			b.variance = (1 - anomalyBaselineAlpha) * (b.variance + diff*incr)
+		}
+		b.minutes++
+	}
+	b.minute, b.count, b.alerted = minute, 0, false
+}
+
+// threshold is the per-minute count above which a surge is flagged.
+func (b *anomalyBaseline) threshold(minSessions int64) float64 {
+	return max(b.mean+anomalyZScore*math.Sqrt(b.variance), anomalySurgeFactor*b.mean, float64(minSessions))
+}
+
+// anomalyDetector flags session mints that break from their rolling
+// baseline: a tenant or origin minting several times its usual rate, or an
+// origin first seen after warm-up arriving with a burst. Alerts are logged
+// and passed to onAlert. When throttle is set, the flagged tenant or origin
+// is held to it for throttleFor.
+type anomalyDetector struct {
+	minSessions int64
+	throttle    *rateLimiter
+	throttleFor time.Duration
+	onAlert     func(anomalyAlert)
+	clock       Clock
+
+	mu        sync.Mutex
+	started   time.Time
+	baselines map[string]*anomalyBaseline
+	throttled map[string]time.Time
+	alerts    []anomalyAlert
+}
+
+func newAnomalyDetector(minSessions int64) *anomalyDetector {
+	return &anomalyDetector{
+		minSessions: minSessions,
+		clock:       SystemClock,
+		baselines:   make(map[string]*anomalyBaseline),
+		throttled:   make(map[string]time.Time),
+	}
+}
+
+// anomalyKey names the baseline of value within dimension.
+func anomalyKey(dimension, value string) string {
+	return dimension + "\x00" + value
+}
+
+// anomalyDimensions returns the baselines a session counts toward. Sessions
+// without a tenant count toward the default tenant.
+func anomalyDimensions(tenant, origin string) [][2]string {
+	if tenant == "" {
+		tenant = anomalyDefaultTenant
+	}
+	dims := [][2]string{{anomalyDimensionTenant, tenant}}
+	if origin != "" {
+		dims = append(dims, [2]string{anomalyDimensionOrigin, origin})
+	}
+	return dims
+}
+
+// record counts one minted session. It is a no-op on a nil detector.
+func (d *anomalyDetector) record(tenant, origin string) {
+	if d == nil {
+		return
+	}
+	now := d.clock.Now()
+	minute := now.Unix() / 60
+
+	var fired []anomalyAlert
+	d.mu.Lock()
+	if d.started.IsZero() {
+		d.started = now
+	}
+	for _, dim := range anomalyDimensions(tenant, origin) {
+		if alert, ok := d.countLocked(dim[0], dim[1], now, minute); ok {
+			fired = append(fired, alert)
+		}
+	}
+	d.mu.Unlock()
+
+	for _, alert := range fired {
+		slog.Warn("unusual session minting", "kind", alert.Kind, alert.Dimension, alert.Value, "count", alert.Count, "baseline", alert.Baseline, "threshold", alert.Threshold)
+		if d.onAlert != nil {
+			d.onAlert(alert)
+		}
+	}
+}
+
+func (d *anomalyDetector) countLocked(dimension, value string, now time.Time, minute int64) (anomalyAlert, bool) {
+	key := anomalyKey(dimension, value)
+	b, ok := d.baselines[key]
+	if !ok {
+		if len(d.baselines) >= maxAnomalyKeys {
+			d.pruneLocked(minute)
+			if len(d.baselines) >= maxAnomalyKeys {
+				return anomalyAlert{}, false
+			}
+		}
+		b = &anomalyBaseline{minute: minute}
+		d.baselines[key] = b
+	}
+	b.fold(minute)
+	b.count++
+	if b.alerted {
+		return anomalyAlert{}, false
+	}
+
+	alert := anomalyAlert{Dimension: dimension, Value: value, Count: b.count, Baseline: b.mean, DetectedAt: now.UTC()}
+	switch {
+	case b.minutes >= anomalyWarmupMinutes:
+		alert.Kind, alert.Threshold = anomalyKindSurge, b.threshold(d.minSessions)
+	case dimension == anomalyDimensionOrigin && b.minutes == 0 && now.Sub(d.started) >= anomalyWarmupMinutes*time.Minute:
+		alert.Kind, alert.Threshold = anomalyKindNewOrigin, float64(d.minSessions)
+	default:
+		return anomalyAlert{}, false
+	}
+	if float64(b.count) < alert.Threshold {
+		return anomalyAlert{}, false
+	}
+	b.alerted = true
+
+	if d.throttle != nil {
+		until := now.Add(d.throttleFor).UTC()
+		d.throttled[key] = until
+		alert.ThrottledUntil = &until
+	}
+	d.alerts = append(d.alerts, alert)
+	if len(d.alerts) > maxAnomalyAlerts {
+		d.alerts = d.alerts[len(d.alerts)-maxAnomalyAlerts:]
+	}
+	return alert, true
+}
+
+// pruneLocked drops baselines that have seen no sessions for a day.
+func (d *anomalyDetector) pruneLocked(minute int64) {
+	idle := int64(anomalyIdleExpiry / time.Minute)
+	for key, b := range d.baselines {
+		if minute-b.minute >= idle {
+			delete(d.baselines, key)
+		}
+	}
+}
+
+// allow applies the stricter rate limit to a tenant or origin flagged in
+// the last throttleFor. It is a no-op on a nil detector or without a
+// throttle.
+func (d *anomalyDetector) allow(tenant, origin string) (bool, time.Duration) {
+	if d == nil || d.throttle == nil {
+		return true, 0
+	}
+	now := d.clock.Now()
+	for _, dim := range anomalyDimensions(tenant, origin) {
+		key := anomalyKey(dim[0], dim[1])
+		d.mu.Lock()
+		until, ok := d.throttled[key]
+		if ok && !now.Before(until) {
+			delete(d.throttled, key)
+			ok = false
+		}
+		d.mu.Unlock()
+		if !ok {
+			continue
+		}
+		if allowed, retryAfter := d.throttle.allow(key); !allowed {
+			return false, retryAfter
+		}
+	}
+	return true, 0
+}
+
+type anomalyThrottle struct {
+	Dimension string    `json:"dimension"`
+	Value     string    `json:"value"`
+	Until     time.Time `json:"until"`
+}
+
+type anomalyStatus struct {
+	Alerts    []anomalyAlert    `json:"alerts"`
+	Throttled []anomalyThrottle `json:"throttled"`
+}
+
+// snapshot returns the most recent alerts, newest last, and the tenants and
+// origins currently throttled.
+func (d *anomalyDetector) snapshot() anomalyStatus {
+	now := d.clock.Now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	status := anomalyStatus{Alerts: append([]anomalyAlert{}, d.alerts...), Throttled: []anomalyThrottle{}}
+	for key, until := range d.throttled {
+		if now.Before(until) {
+			dimension, value := splitAnomalyKey(key)
+			status.Throttled = append(status.Throttled, anomalyThrottle{Dimension: dimension, Value: value, Until: until})
+		}
+	}
+	sort.Slice(status.Throttled, func(i, j int) bool {
+		return anomalyKey(status.Throttled[i].Dimension, status.Throttled[i].Value) < anomalyKey(status.Throttled[j].Dimension, status.Throttled[j].Value)
+	})
+	return status
+}
+
+func splitAnomalyKey(key string) (string, string) {
+	dimension, value, _ := strings.Cut(key, "\x00")
+	return dimension, value
+}
+
+func (a *adminHandler) anomalyStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.anomalies.snapshot())
+}
@@ -0,0 +1,109 @@
+package session
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAnomalyDetectorFlagsSurges(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	d := newAnomalyDetector(20)
+	d.clock = ClockFunc(func() time.Time { return now })
+	var alerts []anomalyAlert
+	d.onAlert = func(alert anomalyAlert) { alerts = append(alerts, alert) }
+
+	// A new origin during warm-up is only learned.
+	for i := 0; i < 25; i++ {
+		d.record("other", "https://app.example.com")
+	}
+	for minute := 1; minute <= 15; minute++ {
+		now = now.Add(time.Minute)
+		for i := 0; i < 2; i++ {
+			d.record("acme", "")
+		}
+	}
+	if len(alerts) != 0 {
+		t.Fatalf("expected no alerts while learning, got %+v", alerts)
+	}
+
+	now = now.Add(time.Minute)
+	for i := 0; i < 30; i++ {
+		d.record("acme", "")
+	}
+	if len(alerts) != 1 {
+		t.Fatalf("expected one alert for the surge, got %+v", alerts)
+	}
+	if a := alerts[0]; a.Kind != anomalyKindSurge || a.Dimension != anomalyDimensionTenant || a.Value != "acme" || a.Count != 20 || a.Threshold != 20 {
+		t.Fatalf("unexpected alert: %+v", a)
+	}
+
+	for i := 0; i < 19; i++ {
+		d.record("", "https://new.example.com")
+	}
+	if len(alerts) != 1 {
+		t.Fatalf("expected a new origin below the threshold not to alert, got %+v", alerts)
+	}
+	d.record("", "https://new.example.com")
+	if len(alerts) != 2 || alerts[1].Kind != anomalyKindNewOrigin || alerts[1].Value != "https://new.example.com" {
+		t.Fatalf("expected a new origin alert, got %+v", alerts)
+	}
+
+	if status := d.snapshot(); len(status.Alerts) != 2 || len(status.Throttled) != 0 {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+}
+
+func TestHandleSessionThrottlesAnomalies(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	clock := ClockFunc(func() time.Time { return now })
+	handler := newSessionHandler((&fakeSessionCreator{clientSecret: "secret"}).Create, "w", 1200, 10)
+	handler.clock = clock
+	handler.anomalies = newAnomalyDetector(3)
+	handler.anomalies.clock = clock
+	handler.anomalies.throttle = newRateLimiter(1, 1)
+	handler.anomalies.throttle.clock = clock
+	handler.anomalies.throttleFor = 10 * time.Minute
+
+	mint := func(origin string) int {
+		req := httptest.NewRequest(http.MethodPost, defaultSessionPath, strings.NewReader(`{"user":"u"}`))
+		req.Header.Set("Origin", origin)
+		rec := httptest.NewRecorder()
+		handler.handleSession(rec, req)
+		return rec.Code
+	}
+	// Learn a steady baseline for the default tenant before the new origin
+	// arrives.
+	for minute := 0; minute < anomalyWarmupMinutes; minute++ {
+		for i := 0; i < 5; i++ {
+			handler.anomalies.record("", "")
+		}
+		now = now.Add(time.Minute)
+	}
+
+	for i := 0; i < 3; i++ {
+		if code := mint("https://bot.example.com"); code != http.StatusOK {
+			t.Fatalf("expected status 200 before the alert, got %d", code)
+		}
+	}
+	if code := mint("https://bot.example.com"); code != http.StatusOK {
+		t.Fatalf("expected the throttle to allow its burst, got %d", code)
+	}
+	if code := mint("https://bot.example.com"); code != http.StatusTooManyRequests {
+		t.Fatalf("expected the flagged origin to be throttled, got %d", code)
+	}
+	if code := mint("https://other.example.com"); code != http.StatusOK {
+		t.Fatalf("expected other origins to be unaffected, got %d", code)
+	}
+	status := handler.anomalies.snapshot()
+	if len(status.Throttled) != 1 || status.Throttled[0].Value != "https://bot.example.com" || status.Alerts[0].ThrottledUntil == nil {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+
+	now = now.Add(10 * time.Minute)
+	if code := mint("https://bot.example.com"); code != http.StatusOK {
+		t.Fatalf("expected the throttle to lapse, got %d", code)
+	}
+}
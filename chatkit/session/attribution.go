@@ -0,0 +1,83 @@
+package session
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const maxAttributionValueLen = 256
+
+var attributionHeaderPrefixes = []string{"X-Experiment-", "X-Attribution-"}
+
+type attributionHeader struct {
+	name     string
+	stateKey string
+}
+
+// attributionPolicy is the allowlist of experiment and attribution headers
+// accepted from clients. Headers with a state key are also forwarded to the
+// workflow as state variables.
+type attributionPolicy []attributionHeader
+
+// newAttributionPolicy parses a comma-separated list of header names, each
+// optionally followed by =state_key, e.g.
+// "X-Experiment-Checkout=experiment_checkout,X-Attribution-Campaign".
+func newAttributionPolicy(spec string) (attributionPolicy, error) {
+	var policy attributionPolicy
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, stateKey, _ := strings.Cut(entry, "=")
+		name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+		if !hasAttributionPrefix(name) {
+			return nil, fmt.Errorf("header %q must start with X-Experiment- or X-Attribution-", name)
+		}
+		policy = append(policy, attributionHeader{name: name, stateKey: strings.TrimSpace(stateKey)})
+	}
+	return policy, nil
+}
+
+func hasAttributionPrefix(name string) bool {
+	for _, prefix := range attributionHeaderPrefixes {
+		if strings.HasPrefix(name, prefix) && len(name) > len(prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// headerNames returns the allowlisted header names.
+func (p attributionPolicy) headerNames() []string {
+	names := make([]string, 0, len(p))
+	for _, h := range p {
+		names = append(names, h.name)
+	}
+	return names
+}
+
+// extract returns the allowlisted headers present on the request, keyed by
+// canonical header name, along with the state variables they map to.
+// Oversized values are dropped.
+func (p attributionPolicy) extract(header http.Header) (map[string]string, map[string]string) {
+	var values, state map[string]string
+	for _, h := range p {
+		v := strings.TrimSpace(header.Get(h.name))
+		if v == "" || len(v) > maxAttributionValueLen {
+			continue
+		}
+		if values == nil {
+			values = make(map[string]string)
+		}
+		values[h.name] = v
+		if h.stateKey != "" {
+			if state == nil {
+				state = make(map[string]string)
+			}
+			state[h.stateKey] = v
+		}
+	}
+	return values, state
+}
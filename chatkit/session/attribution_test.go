@@ -0,0 +1,52 @@
+package session
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNewAttributionPolicyRejectsUnprefixedHeaders(t *testing.T) {
+	if _, err := newAttributionPolicy("X-Campaign"); err == nil {
+		t.Fatalf("expected error for header without allowed prefix")
+	}
+}
+
+func TestHandleSessionForwardsAttributionHeaders(t *testing.T) {
+	fake := &fakeSessionCreator{clientSecret: "secret"}
+	handler := newSessionHandler(fake.Create, "w", 1200, 10)
+	policy, err := newAttributionPolicy("x-experiment-checkout=experiment_checkout, X-Attribution-Campaign")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	handler.attribution = policy
+
+	req := httptest.NewRequest(http.MethodPost, "/api/chatkit/session", strings.NewReader(`{"user":"u"}`))
+	req.Header.Set("X-Experiment-Checkout", "variant-b")
+	req.Header.Set("X-Attribution-Campaign", "spring")
+	req.Header.Set("X-Attribution-Other", "ignored")
+	rec := httptest.NewRecorder()
+
+	handler.handleSession(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("X-Experiment-Checkout"); got != "variant-b" {
+		t.Fatalf("expected experiment header echoed, got %q", got)
+	}
+	if got := rec.Header().Get("X-Attribution-Campaign"); got != "spring" {
+		t.Fatalf("expected attribution header echoed, got %q", got)
+	}
+	if rec.Header().Get("X-Attribution-Other") != "" {
+		t.Fatalf("expected non-allowlisted header to be dropped")
+	}
+	vars := fake.params.Workflow.StateVariables
+	if v := vars["experiment_checkout"].OfString; !v.Valid() || v.Value != "variant-b" {
+		t.Fatalf("expected experiment_checkout state variable")
+	}
+	if len(vars) != 1 {
+		t.Fatalf("expected only mapped headers as state variables, got %v", vars)
+	}
+}
@@ -0,0 +1,89 @@
+package session
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+)
+
+const (
+	environmentHeader = "X-Environment"
+	servedByHeader    = "X-Served-By"
+)
+
+var dnsLabelUnsafe = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// responseBanner is a fixed set of headers added to every response so that
+// clients can tell which environment and instance answered.
+type responseBanner struct {
+	headers [][2]string
+}
+
+// newResponseBanner builds the banner from the environment name, the
+// instance ID (included only when set), and comma-separated Name:Value
+// extra headers.
+func newResponseBanner(environment, instanceID, extra string) (responseBanner, error) {
+	var b responseBanner
+	if environment != "" {
+		b.headers = append(b.headers, [2]string{environmentHeader, environment})
+	}
+	if instanceID != "" {
+		b.headers = append(b.headers, [2]string{servedByHeader, instanceID})
+	}
+	for _, entry := range strings.Split(extra, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(entry, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return b, fmt.Errorf("invalid response header %q: expected Name:Value", entry)
+		}
+		b.headers = append(b.headers, [2]string{http.CanonicalHeaderKey(name), strings.TrimSpace(value)})
+	}
+	return b, nil
+}
+
+// headerNames returns the names of the banner headers.
+func (b responseBanner) headerNames() []string {
+	names := make([]string, 0, len(b.headers))
+	for _, h := range b.headers {
+		names = append(names, h[0])
+	}
+	return names
+}
+
+func withResponseBanner(b responseBanner, next http.Handler) http.Handler {
+	if len(b.headers) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, h := range b.headers {
+			w.Header().Set(h[0], h[1])
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// defaultInstanceID derives a DNS-safe instance ID from the hostname, which
+// is the pod or container name on most platforms.
+func defaultInstanceID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		return ""
+	}
+	return dnsSafeLabel(host)
+}
+
+// dnsSafeLabel lowercases s and reduces it to a valid DNS label.
+func dnsSafeLabel(s string) string {
+	s = dnsLabelUnsafe.ReplaceAllString(strings.ToLower(s), "-")
+	s = strings.Trim(s, "-")
+	if len(s) > 63 {
+		s = strings.TrimRight(s[:63], "-")
+	}
+	return s
+}
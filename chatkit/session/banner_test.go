@@ -0,0 +1,44 @@
+package session
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWithResponseBannerSetsHeaders(t *testing.T) {
+	banner, err := newResponseBanner("staging", dnsSafeLabel("Web_Pod.7"), "x-team: chat, X-Region:eu")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	handler := withResponseBanner(banner, http.HandlerFunc(healthHandler))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	want := map[string]string{
+		environmentHeader: "staging",
+		servedByHeader:    "web-pod-7",
+		"X-Team":          "chat",
+		"X-Region":        "eu",
+	}
+	for name, value := range want {
+		if got := rec.Header().Get(name); got != value {
+			t.Fatalf("expected %s: %s, got %q", name, value, got)
+		}
+	}
+}
+
+func TestNewResponseBannerRejectsMalformedHeader(t *testing.T) {
+	if _, err := newResponseBanner("", "", "X-Team"); err == nil {
+		t.Fatalf("expected error for header without value separator")
+	}
+}
+
+func TestDNSSafeLabelTruncates(t *testing.T) {
+	long := "ABCDEFGHIJKLMNOPQRSTUVWXYZ-abcdefghijklmnopqrstuvwxyz-0123456789-extra"
+	got := dnsSafeLabel(long)
+	if len(got) > 63 || got != "abcdefghijklmnopqrstuvwxyz-abcdefghijklmnopqrstuvwxyz-012345678" {
+		t.Fatalf("unexpected label %q", got)
+	}
+}
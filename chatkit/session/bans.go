@@ -0,0 +1,143 @@
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+)
+
+const (
+	banKindUser   = "user"
+	banKindIP     = "ip"
+	banKindDevice = "device"
+)
+
+var banKinds = []string{banKindUser, banKindIP, banKindDevice}
+
+// banSnapshot is the persisted and API representation of the ban list.
+type banSnapshot struct {
+	Users   []string `json:"users"`
+	IPs     []string `json:"ips"`
+	Devices []string `json:"devices"`
+}
+
+// banList holds banned users, IPs, and devices in memory, optionally
+// persisting every change to a JSON file.
+type banList struct {
+	path string
+
+	mu      sync.RWMutex
+	entries map[string]map[string]struct{}
+}
+
+// newBanList loads the ban list from path when it exists. An empty path
+// keeps the list in memory only.
+func newBanList(path string) (*banList, error) {
+	b := &banList{path: path, entries: make(map[string]map[string]struct{})}
+	for _, kind := range banKinds {
+		b.entries[kind] = make(map[string]struct{})
+	}
+	if path == "" {
+		return b, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return b, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var snap banSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	for kind, values := range map[string][]string{banKindUser: snap.Users, banKindIP: snap.IPs, banKindDevice: snap.Devices} {
+		for _, v := range values {
+			b.entries[kind][v] = struct{}{}
+		}
+	}
+	return b, nil
+}
+
+func validBanKind(kind string) bool {
+	for _, k := range banKinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// banned reports whether any of the non-empty user, IP, or device values is
+// banned.
+func (b *banList) banned(user, ip, device string) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for kind, v := range map[string]string{banKindUser: user, banKindIP: ip, banKindDevice: device} {
+		if v == "" {
+			continue
+		}
+		if _, ok := b.entries[kind][v]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *banList) add(kind, value string) error {
+	return b.update(func() { b.entries[kind][value] = struct{}{} })
+}
+
+func (b *banList) remove(kind, value string) error {
+	return b.update(func() { delete(b.entries[kind], value) })
+}
+
+func (b *banList) update(change func()) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	change()
+	if b.path == "" {
+		return nil
+	}
+	return writeJSONFile(b.path, b.snapshotLocked())
+}
+
+func (b *banList) snapshot() banSnapshot {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.snapshotLocked()
+}
+
+func (b *banList) snapshotLocked() banSnapshot {
+	return banSnapshot{
+		Users:   sortedKeys(b.entries[banKindUser]),
+		IPs:     sortedKeys(b.entries[banKindIP]),
+		Devices: sortedKeys(b.entries[banKindDevice]),
+	}
+}
+
+// writeJSONFile atomically replaces path with the JSON encoding of v.
+func writeJSONFile(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func sortedKeys(m map[string]struct{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
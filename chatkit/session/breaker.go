@@ -0,0 +1,138 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/openai/openai-go/v3"
+)
+
+const (
+	defaultBreakerThreshold = 5
+	defaultBreakerCooldown  = 30 * time.Second
+)
+
+// circuitBreaker stops calling OpenAI after threshold consecutive upstream
+// failures. Once cooldown has passed it lets a single trial request through;
+// success closes the circuit and failure reopens it for another cooldown.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	clock     Clock
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	trial     bool
+	// opened and rejected count how often the circuit opened and how many
+	// requests it failed fast.
+	opened   int64
+	rejected int64
+}
+
+// Circuit states reported by snapshot.
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half_open"
+)
+
+// breakerStatus is the admin and readiness view of the circuit.
+type breakerStatus struct {
+	State               string `json:"state"`
+	ConsecutiveFailures int    `json:"consecutive_failures"`
+	OpenUntil           string `json:"open_until,omitempty"`
+	Opened              int64  `json:"opened_total"`
+	Rejected            int64  `json:"rejected_total"`
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown, clock: SystemClock}
+}
+
+// allow reports whether a request may call upstream. When it may not, it
+// returns the time the next trial request will be allowed.
+func (b *circuitBreaker) allow() (bool, time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.openUntil.IsZero() {
+		return true, time.Time{}
+	}
+	if b.trial || b.clock.Now().Before(b.openUntil) {
+		b.rejected++
+		return false, b.openUntil
+	}
+	b.trial = true
+	return true, time.Time{}
+}
+
+// state reports whether the circuit is open without claiming a trial
+// request.
+func (b *circuitBreaker) state() (bool, time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.openUntil.IsZero() || (!b.trial && !b.clock.Now().Before(b.openUntil)) {
+		return false, time.Time{}
+	}
+	return true, b.openUntil
+}
+
+// record reports the outcome of an upstream call. Errors that are not the
+// upstream's fault, such as client cancellations and 4xx responses, are
+// treated as successes.
+func (b *circuitBreaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !isUpstreamFailure(err) {
+		b.failures = 0
+		b.openUntil = time.Time{}
+		b.trial = false
+		return
+	}
+	b.failures++
+	if b.trial || b.failures >= b.threshold {
+		b.openUntil = b.clock.Now().Add(b.cooldown)
+		b.trial = false
+		b.opened++
+	}
+}
+
+// snapshot reports the circuit state. An open circuit whose cooldown has
+// passed is half-open: the next request is let through as a trial.
+func (b *circuitBreaker) snapshot() breakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	status := breakerStatus{State: breakerClosed, ConsecutiveFailures: b.failures, Opened: b.opened, Rejected: b.rejected}
+	switch {
+	case b.openUntil.IsZero():
+	case b.trial || !b.clock.Now().Before(b.openUntil):
+		status.State = breakerHalfOpen
+	default:
+		status.State = breakerOpen
+		status.OpenUntil = b.openUntil.UTC().Format(time.RFC3339)
+	}
+	return status
+}
+
+// skip releases a trial request whose outcome says nothing about upstream
+// health, such as one cut short by the client's own deadline.
+func (b *circuitBreaker) skip() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+}
+
+// isUpstreamFailure reports whether err indicates that OpenAI is unhealthy.
+func isUpstreamFailure(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var apiErr *openai.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode >= http.StatusInternalServerError || apiErr.StatusCode == http.StatusTooManyRequests
+	}
+	return true
+}
@@ -0,0 +1,31 @@
+package session
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+)
+
+// clientSessionIDHeader carries the frontend's own session ID, so that a bug
+// report quoting it can be matched to backend logs and events.
+const clientSessionIDHeader = "X-Client-Session-ID"
+
+// clientSessionIDPattern accepts UUIDs and similar opaque IDs.
+var clientSessionIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{8,128}$`)
+
+// clientSessionID returns the X-Client-Session-ID of the request, or a
+// problem when the header is present but malformed.
+func clientSessionID(header http.Header) (string, []fieldError) {
+	id := header.Get(clientSessionIDHeader)
+	if id == "" {
+		return "", nil
+	}
+	if !clientSessionIDPattern.MatchString(id) {
+		return "", []fieldError{{
+			Field:   clientSessionIDHeader,
+			Code:    validationCodeInvalidValue,
+			Message: fmt.Sprintf("%s must be 8 to 128 letters, digits, '-', or '_'", clientSessionIDHeader),
+		}}
+	}
+	return id, nil
+}
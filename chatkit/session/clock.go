@@ -0,0 +1,23 @@
+package session
+
+import "openai-chatkit-backend/chatkit/clock"
+
+// Clock tells the time for expiry calculations, caches, quotas, and retry
+// schedules. Embedders and tests can substitute their own to make
+// time-dependent behaviour deterministic.
+type Clock = clock.Clock
+
+// ClockFunc adapts a function to a Clock.
+type ClockFunc = clock.Func
+
+// SystemClock is the wall clock.
+var SystemClock Clock = clock.System
+
+// Rand is the source of randomness for traffic sampling and retry jitter.
+type Rand = clock.Rand
+
+// RandFunc adapts a function to a Rand.
+type RandFunc = clock.RandFunc
+
+// SystemRand draws from the shared math/rand source.
+var SystemRand Rand = clock.SystemRand
@@ -0,0 +1,184 @@
+package session
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"openai-chatkit-backend/chatkit/config"
+)
+
+const configDriftCheckInterval = time.Minute
+
+var configKeyPrefixes = []string{"CHATKIT_", "OPENAI_", "CORS_", "ADMIN_", "TLS_", "ACME_"}
+
+var configKeyNames = map[string]struct{}{"ADDR": {}, "DEBUG": {}, "REDIS_URL": {}, "REGION": {}, "LOG_LEVEL": {}, "LOG_FORMAT": {}, "UNIX_SOCKET_MODE": {}}
+
+func isConfigKey(key string) bool {
+	if _, ok := configKeyNames[key]; ok {
+		return true
+	}
+	for _, prefix := range configKeyPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// configFromEnviron extracts the server's configuration variables from a
+// KEY=VALUE environment list.
+func configFromEnviron(environ []string) map[string]string {
+	cfg := make(map[string]string)
+	for _, kv := range environ {
+		key, value, ok := strings.Cut(kv, "=")
+		if ok && isConfigKey(key) && value != "" {
+			cfg[key] = value
+		}
+	}
+	return cfg
+}
+
+// configFingerprint hashes the configuration so that replicas can be
+// compared without exposing any values.
+func configFingerprint(cfg map[string]string) string {
+	keys := make([]string, 0, len(cfg))
+	for k := range cfg {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	h := sha256.New()
+	for _, k := range keys {
+		fmt.Fprintf(h, "%s=%s\n", k, cfg[k])
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// configStatus is the admin view of the running configuration.
+type configStatus struct {
+	Fingerprint     string `json:"fingerprint"`
+	DriftFile       string `json:"drift_file,omitempty"`
+	FileFingerprint string `json:"file_fingerprint,omitempty"`
+	DriftDetected   bool   `json:"drift_detected"`
+	CheckedAt       string `json:"checked_at,omitempty"`
+}
+
+// configDriftDetector compares the running configuration with an env file on
+// disk and warns when the file holds changes that have not been loaded.
+type configDriftDetector struct {
+	running     map[string]string
+	fingerprint string
+	path        string
+	events      *lifecycleLogger
+
+	mu     sync.Mutex
+	status configStatus
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func newConfigDriftDetector(environ []string, path string) *configDriftDetector {
+	running := configFromEnviron(environ)
+	fingerprint := configFingerprint(running)
+	return &configDriftDetector{
+		running:     running,
+		fingerprint: fingerprint,
+		path:        path,
+		status:      configStatus{Fingerprint: fingerprint, DriftFile: path},
+	}
+}
+
+// check reloads the env file and records whether it differs from the
+// running configuration. Keys absent from the file keep their running value.
+func (d *configDriftDetector) check() error {
+	if d.path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(d.path)
+	if err != nil {
+		return err
+	}
+
+	onDisk := make(map[string]string, len(d.running))
+	for k, v := range d.running {
+		onDisk[k] = v
+	}
+	for k, v := range configFromEnviron(config.ParseEnvFile(data)) {
+		onDisk[k] = v
+	}
+	fileFingerprint := configFingerprint(onDisk)
+	drifted := fileFingerprint != d.fingerprint
+
+	d.mu.Lock()
+	wasDrifted := d.status.DriftDetected
+	d.status.FileFingerprint = fileFingerprint
+	d.status.DriftDetected = drifted
+	d.status.CheckedAt = time.Now().UTC().Format(time.RFC3339)
+	d.mu.Unlock()
+
+	if drifted && !wasDrifted {
+		slog.Warn("configuration file differs from the running configuration; restart to apply", "path", d.path, "running_fingerprint", d.fingerprint, "file_fingerprint", fileFingerprint)
+	}
+	if drifted != wasDrifted {
+		d.events.emit(lifecycleEvent{Event: lifecycleEventConfigChanged, FileFingerprint: fileFingerprint})
+	}
+	return nil
+}
+
+func (d *configDriftDetector) snapshot() configStatus {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.status
+}
+
+// start begins periodic drift checks. It is a no-op without a drift file.
+func (d *configDriftDetector) start(context.Context) error {
+	if d.path == "" {
+		return nil
+	}
+	if err := d.check(); err != nil {
+		slog.Error("config drift check failed", "error", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	d.cancel = cancel
+	d.done = make(chan struct{})
+	go func() {
+		defer close(d.done)
+		ticker := time.NewTicker(configDriftCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := d.check(); err != nil {
+					slog.Error("config drift check failed", "error", err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// stop ends periodic drift checks.
+func (d *configDriftDetector) stop(ctx context.Context) error {
+	if d.cancel == nil {
+		return nil
+	}
+	d.cancel()
+	select {
+	case <-d.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,55 @@
+package session
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConfigFingerprintIgnoresUnrelatedVariables(t *testing.T) {
+	a := configFingerprint(configFromEnviron([]string{"CHATKIT_WORKFLOW_ID=w", "HOME=/root"}))
+	b := configFingerprint(configFromEnviron([]string{"PATH=/bin", "CHATKIT_WORKFLOW_ID=w"}))
+	if a != b {
+		t.Fatalf("expected fingerprints to match, got %s and %s", a, b)
+	}
+	c := configFingerprint(configFromEnviron([]string{"CHATKIT_WORKFLOW_ID=other"}))
+	if a == c {
+		t.Fatalf("expected fingerprint to change with configuration")
+	}
+}
+
+func TestConfigFingerprintCoversUnprefixedSettings(t *testing.T) {
+	for _, key := range []string{"ADDR", "DEBUG", "REDIS_URL", "REGION", "LOG_LEVEL", "LOG_FORMAT", "UNIX_SOCKET_MODE"} {
+		a := configFingerprint(configFromEnviron([]string{key + "=a"}))
+		b := configFingerprint(configFromEnviron([]string{key + "=b"}))
+		if a == b {
+			t.Fatalf("expected a change to %s to change the fingerprint", key)
+		}
+	}
+}
+
+func TestConfigDriftDetectorCheck(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.env")
+	environ := []string{"CHATKIT_WORKFLOW_ID=w", "CORS_ALLOWED_ORIGINS=*"}
+	if err := os.WriteFile(path, []byte("# deployed config\nexport CHATKIT_WORKFLOW_ID=\"w\"\n"), 0o600); err != nil {
+		t.Fatalf("failed to write env file: %v", err)
+	}
+
+	d := newConfigDriftDetector(environ, path)
+	if err := d.check(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if d.snapshot().DriftDetected {
+		t.Fatalf("expected no drift for matching file")
+	}
+
+	if err := os.WriteFile(path, []byte("CHATKIT_WORKFLOW_ID=w2\n"), 0o600); err != nil {
+		t.Fatalf("failed to write env file: %v", err)
+	}
+	if err := d.check(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !d.snapshot().DriftDetected {
+		t.Fatalf("expected drift after file change")
+	}
+}
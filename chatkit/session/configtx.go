@@ -0,0 +1,281 @@
+package session
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxConfigTransactions is how many applied transactions are kept for
+// rollback.
+const maxConfigTransactions = 20
+
+// runtimeConfig is the part of the session configuration the admin API can
+// change while the server runs.
+type runtimeConfig struct {
+	WorkflowID          string            `json:"workflow_id"`
+	AllowedWorkflowIDs  []string          `json:"allowed_workflow_ids"`
+	ExpiresAfterSeconds int64             `json:"expires_after_seconds"`
+	RateLimitPerMinute  int64             `json:"rate_limit_per_minute"`
+	Maintenance         maintenanceStatus `json:"maintenance"`
+}
+
+// configTransactionRequest lists the settings to change. Omitted settings
+// keep their current values.
+type configTransactionRequest struct {
+	WorkflowID          *string            `json:"workflow_id"`
+	AllowedWorkflowIDs  *[]string          `json:"allowed_workflow_ids"`
+	ExpiresAfterSeconds *int64             `json:"expires_after_seconds"`
+	RateLimitPerMinute  *int64             `json:"rate_limit_per_minute"`
+	Maintenance         *maintenanceChange `json:"maintenance"`
+}
+
+type maintenanceChange struct {
+	Enabled bool   `json:"enabled"`
+	Message string `json:"message"`
+	Until   string `json:"until"`
+}
+
+type configRollbackRequest struct {
+	RollbackToken string `json:"rollback_token"`
+}
+
+type configTransactionResponse struct {
+	Config        runtimeConfig `json:"config"`
+	RollbackToken string        `json:"rollback_token"`
+}
+
+// configTransaction is an applied change. It can be rolled back while the
+// settings it wrote are still in effect.
+type configTransaction struct {
+	token  string
+	before runtimeConfig
+	after  runtimeConfig
+}
+
+// configTransactions applies admin config changes to the session handler as
+// one unit and remembers the recent ones so that they can be rolled back.
+type configTransactions struct {
+	sessions *sessionHandler
+
+	// mu serializes transactions, so that each is validated against the
+	// configuration it replaces.
+	mu      sync.Mutex
+	applied []configTransaction
+}
+
+func newConfigTransactions(sessions *sessionHandler) *configTransactions {
+	return &configTransactions{sessions: sessions}
+}
+
+// runtimeConfig returns the settings the admin API can change.
+func (h *sessionHandler) runtimeConfig() runtimeConfig {
+	h.runtimeMu.RLock()
+	defer h.runtimeMu.RUnlock()
+	allowed := make([]string, 0, len(h.allowedWorkflows))
+	for id := range h.allowedWorkflows {
+		allowed = append(allowed, id)
+	}
+	sort.Strings(allowed)
+	c := runtimeConfig{
+		WorkflowID:          h.workflowID,
+		AllowedWorkflowIDs:  allowed,
+		ExpiresAfterSeconds: h.expiresAfterSeconds,
+		RateLimitPerMinute:  h.rateLimitPerMinute,
+	}
+	if h.maintenance != nil {
+		c.Maintenance = h.maintenance.snapshot()
+	}
+	return c
+}
+
+// setRuntimeConfig switches every setting in c under one lock, so that no
+// request sees some of them changed and others not.
+func (h *sessionHandler) setRuntimeConfig(c runtimeConfig) {
+	allowed := make(map[string]bool, len(c.AllowedWorkflowIDs))
+	for _, id := range c.AllowedWorkflowIDs {
+		allowed[id] = true
+	}
+	h.runtimeMu.Lock()
+	defer h.runtimeMu.Unlock()
+	h.workflowID = c.WorkflowID
+	h.allowedWorkflows = allowed
+	h.expiresAfterSeconds = c.ExpiresAfterSeconds
+	h.rateLimitPerMinute = c.RateLimitPerMinute
+	if h.maintenance != nil {
+		h.maintenance.set(c.Maintenance)
+	}
+}
+
+// merge returns current with the changes in req applied, or every problem
+// with req. Nothing is applied unless every change is valid.
+func (req configTransactionRequest) merge(current runtimeConfig, maintenanceAvailable bool) (runtimeConfig, []fieldError) {
+	var problems []fieldError
+	next := current
+	if req.WorkflowID != nil {
+		if *req.WorkflowID == "" {
+			problems = append(problems, fieldError{Field: "workflow_id", Code: validationCodeInvalidValue, Message: "workflow_id must not be empty"})
+		}
+		next.WorkflowID = *req.WorkflowID
+	}
+	if req.AllowedWorkflowIDs != nil {
+		next.AllowedWorkflowIDs = nil
+		for _, id := range *req.AllowedWorkflowIDs {
+			if id == "" {
+				problems = append(problems, fieldError{Field: "allowed_workflow_ids", Code: validationCodeInvalidValue, Message: "allowed_workflow_ids must not contain empty IDs"})
+				break
+			}
+			next.AllowedWorkflowIDs = append(next.AllowedWorkflowIDs, id)
+		}
+		sort.Strings(next.AllowedWorkflowIDs)
+	}
+	if req.ExpiresAfterSeconds != nil {
+		if *req.ExpiresAfterSeconds < 0 {
+			problems = append(problems, fieldError{Field: "expires_after_seconds", Code: validationCodeInvalidValue, Message: "expires_after_seconds must be non-negative"})
+		}
+		next.ExpiresAfterSeconds = *req.ExpiresAfterSeconds
+	}
+	if req.RateLimitPerMinute != nil {
+		if *req.RateLimitPerMinute < 0 {
+			problems = append(problems, fieldError{Field: "rate_limit_per_minute", Code: validationCodeInvalidValue, Message: "rate_limit_per_minute must be non-negative"})
+		}
+		next.RateLimitPerMinute = *req.RateLimitPerMinute
+	}
+	if m := req.Maintenance; m != nil {
+		if !maintenanceAvailable {
+			problems = append(problems, fieldError{Field: "maintenance", Code: validationCodeInvalidValue, Message: "maintenance mode is not available"})
+		}
+		next.Maintenance = maintenanceStatus{Enabled: m.Enabled}
+		if m.Enabled {
+			next.Maintenance.Message = m.Message
+			if m.Until != "" {
+				until, err := time.Parse(time.RFC3339, m.Until)
+				if err != nil {
+					problems = append(problems, fieldError{Field: "maintenance.until", Code: validationCodeInvalidValue, Message: "maintenance.until must be an RFC 3339 timestamp"})
+				}
+				next.Maintenance.Until = &until
+			}
+		}
+	}
+	return next, problems
+}
+
+func newRollbackToken() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return "rb_" + hex.EncodeToString(buf), nil
+}
+
+// apply switches from before to next and records the change under token.
+// The caller holds t.mu.
+func (t *configTransactions) apply(token string, before, next runtimeConfig) runtimeConfig {
+	t.sessions.setRuntimeConfig(next)
+	// Read back what was applied, so that rollback compares like with like.
+	after := t.sessions.runtimeConfig()
+	t.applied = append(t.applied, configTransaction{token: token, before: before, after: after})
+	if len(t.applied) > maxConfigTransactions {
+		t.applied = t.applied[len(t.applied)-maxConfigTransactions:]
+	}
+	return after
+}
+
+func (a *adminHandler) getRuntimeConfig(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.sessions.runtimeConfig())
+}
+
+// applyConfigTransaction validates every change in the request and applies
+// them together, or none of them.
+func (a *adminHandler) applyConfigTransaction(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodyBytes)
+
+	var req configTransactionRequest
+	problems, err := decodeJSONObject(r.Body, &req)
+	if err != nil {
+		http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if len(problems) > 0 {
+		writeValidationErrors(w, problems)
+		return
+	}
+
+	t := a.transactions
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	before := a.sessions.runtimeConfig()
+	next, problems := req.merge(before, a.sessions.maintenance != nil)
+	if len(problems) > 0 {
+		writeValidationErrors(w, problems)
+		return
+	}
+	token, err := newRollbackToken()
+	if err != nil {
+		slog.Error("failed to create rollback token", "error", err)
+		http.Error(w, "failed to create rollback token", http.StatusInternalServerError)
+		return
+	}
+	after := t.apply(token, before, next)
+	slog.Info("admin applied config transaction", "rollback_token", token, "workflow_id", after.WorkflowID, "expires_after_seconds", after.ExpiresAfterSeconds, "rate_limit_per_minute", after.RateLimitPerMinute, "maintenance", after.Maintenance.Enabled)
+	writeJSON(w, http.StatusOK, configTransactionResponse{Config: after, RollbackToken: token})
+}
+
+// rollbackConfigTransaction restores the settings a transaction replaced.
+// It refuses when the settings have changed since, so that rolling back
+// an old transaction cannot undo later ones. The rollback is itself a
+// transaction with its own token.
+func (a *adminHandler) rollbackConfigTransaction(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodyBytes)
+
+	var req configRollbackRequest
+	problems, err := decodeJSONObject(r.Body, &req)
+	if err != nil {
+		http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if req.RollbackToken == "" && !hasFieldError(problems, "rollback_token") {
+		problems = append(problems, fieldError{Field: "rollback_token", Code: validationCodeRequired, Message: "rollback_token is required"})
+	}
+	if len(problems) > 0 {
+		writeValidationErrors(w, problems)
+		return
+	}
+
+	t := a.transactions
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	i := len(t.applied) - 1
+	for ; i >= 0 && t.applied[i].token != req.RollbackToken; i-- {
+	}
+	if i < 0 {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "unknown_rollback_token", "message": "the rollback token is unknown, already used, or too old"})
+		return
+	}
+	tx := t.applied[i]
+	current := a.sessions.runtimeConfig()
+	if !reflect.DeepEqual(current, tx.after) {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "config_changed", "message": "the configuration changed after this transaction; roll back the later changes first"})
+		return
+	}
+	token, err := newRollbackToken()
+	if err != nil {
+		slog.Error("failed to create rollback token", "error", err)
+		http.Error(w, "failed to create rollback token", http.StatusInternalServerError)
+		return
+	}
+	// A transaction is rolled back at most once.
+	t.applied = append(t.applied[:i], t.applied[i+1:]...)
+	after := t.apply(token, current, tx.before)
+	slog.Info("admin rolled back config transaction", "rolled_back", tx.token, "rollback_token", token)
+	writeJSON(w, http.StatusOK, configTransactionResponse{Config: after, RollbackToken: token})
+}
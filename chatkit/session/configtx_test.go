@@ -0,0 +1,71 @@
+package session
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestConfigTransactionsApplyAtomicallyAndRollBack(t *testing.T) {
+	sessions := newSessionHandler(nil, "wf_old", 1200, 10)
+	sessions.maintenance = newMaintenanceMode(false, "")
+	admin := newAdminHandler("s3cret", nil, newConfigDriftDetector(nil, ""))
+	admin.sessions = sessions
+	admin.maintenance = sessions.maintenance
+	admin.transactions = newConfigTransactions(sessions)
+	router, err := newRouter(sessions, admin, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected router error: %v", err)
+	}
+
+	do := func(target, body string) (*httptest.ResponseRecorder, configTransactionResponse) {
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer s3cret")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		var resp configTransactionResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec, resp
+	}
+	original := sessions.runtimeConfig()
+
+	rec, _ := do("/admin/config/transactions", `{"workflow_id":"wf_new","rate_limit_per_minute":-1,"maintenance":{"enabled":true,"until":"soon"}}`)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "rate_limit_per_minute") || !strings.Contains(rec.Body.String(), "maintenance.until") {
+		t.Fatalf("expected every problem to be reported, got %d %s", rec.Code, rec.Body)
+	}
+	if got := sessions.runtimeConfig(); got.WorkflowID != "wf_old" {
+		t.Fatalf("expected nothing to be applied from an invalid transaction, got %+v", got)
+	}
+
+	rec, first := do("/admin/config/transactions", `{"workflow_id":"wf_new","allowed_workflow_ids":["wf_beta"],"rate_limit_per_minute":20,"maintenance":{"enabled":true,"message":"upgrading"}}`)
+	if rec.Code != http.StatusOK || !strings.HasPrefix(first.RollbackToken, "rb_") {
+		t.Fatalf("expected the transaction to apply, got %d %s", rec.Code, rec.Body)
+	}
+	got := sessions.runtimeConfig()
+	if got.WorkflowID != "wf_new" || got.RateLimitPerMinute != 20 || got.ExpiresAfterSeconds != 1200 || !got.Maintenance.Enabled || !sessions.workflowAllowed("wf_beta") {
+		t.Fatalf("unexpected config after the transaction: %+v", got)
+	}
+
+	_, second := do("/admin/config/transactions", `{"expires_after_seconds":600}`)
+	if rec, _ := do("/admin/config/rollback", `{"rollback_token":"`+first.RollbackToken+`"}`); rec.Code != http.StatusConflict {
+		t.Fatalf("expected rolling back past a later change to conflict, got %d %s", rec.Code, rec.Body)
+	}
+	if rec, _ := do("/admin/config/rollback", `{"rollback_token":"`+second.RollbackToken+`"}`); rec.Code != http.StatusOK {
+		t.Fatalf("expected the latest transaction to roll back, got %d %s", rec.Code, rec.Body)
+	}
+	rec, undo := do("/admin/config/rollback", `{"rollback_token":"`+first.RollbackToken+`"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected the first transaction to roll back, got %d %s", rec.Code, rec.Body)
+	}
+	if got := sessions.runtimeConfig(); got.WorkflowID != "wf_old" || got.RateLimitPerMinute != 10 || got.Maintenance.Enabled || len(got.AllowedWorkflowIDs) != 0 {
+		t.Fatalf("expected the original config back, got %+v (was %+v)", got, original)
+	}
+	if undo.RollbackToken == "" {
+		t.Fatal("expected a rollback to return its own rollback token")
+	}
+	if rec, _ := do("/admin/config/rollback", `{"rollback_token":"`+first.RollbackToken+`"}`); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected a used token to be unknown, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,12 @@
+package session
+
+import "openai-chatkit-backend/chatkit/cors"
+
+// newCORSPolicy returns the CORS policy for allowedOrigins that also lets
+// browsers send the request headers the session endpoint reads.
+func newCORSPolicy(allowedOrigins string) cors.Policy {
+	return cors.NewPolicy(allowedOrigins).WithAllowedHeaders(
+		deviceIDHeader, apiKeyHeader, requestTimeoutHeader, clientSessionIDHeader,
+		overrideWorkflowVersionHeader, overrideExpiresAfterHeader, overrideTracingHeader,
+	)
+}
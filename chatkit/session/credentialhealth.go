@@ -0,0 +1,182 @@
+package session
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	defaultCredentialWarnDays = 14
+	credentialCheckInterval   = time.Hour
+	// jwksStaleAfter is how old cached JWKS keys may get, while requests
+	// keep arriving, before refreshes are presumed to be failing.
+	jwksStaleAfter = 24 * time.Hour
+
+	credentialOK         = "ok"
+	credentialExpiring   = "expiring"
+	credentialExpired    = "expired"
+	credentialStale      = "stale"
+	credentialNotFetched = "not_fetched"
+)
+
+// credentialStatus is the /readyz view of one piece of auth material.
+type credentialStatus struct {
+	Name             string     `json:"name"`
+	Status           string     `json:"status"`
+	ExpiresAt        *time.Time `json:"expires_at,omitempty"`
+	ExpiresInSeconds *int64     `json:"expires_in_seconds,omitempty"`
+	AgeSeconds       *int64     `json:"age_seconds,omitempty"`
+}
+
+// credentialSource reports either when material expires or when it was
+// last fetched.
+type credentialSource struct {
+	name       string
+	expiresAt  func() time.Time
+	fetchedAt  func() time.Time
+	staleAfter time.Duration
+}
+
+// credentialHealth tracks the certificates and keys that authentication
+// depends on, so that expiring material shows up in /readyz and the logs
+// well before TLS handshakes or token checks start failing. It never
+// affects readiness.
+type credentialHealth struct {
+	warnBefore time.Duration
+	clock      Clock
+
+	mu      sync.Mutex
+	sources []credentialSource
+	// warned holds the last status logged per source, so that each change
+	// is logged once.
+	warned map[string]string
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func newCredentialHealth(warnBefore time.Duration) *credentialHealth {
+	return &credentialHealth{warnBefore: warnBefore, clock: SystemClock, warned: make(map[string]string)}
+}
+
+// watchExpiry tracks material that stops working at expiresAt.
+func (c *credentialHealth) watchExpiry(name string, expiresAt func() time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sources = append(c.sources, credentialSource{name: name, expiresAt: expiresAt})
+}
+
+// watchAge tracks cached material that is refetched, reporting it stale
+// once its last fetch is older than staleAfter.
+func (c *credentialHealth) watchAge(name string, fetchedAt func() time.Time, staleAfter time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sources = append(c.sources, credentialSource{name: name, fetchedAt: fetchedAt, staleAfter: staleAfter})
+}
+
+// report returns the status of every source, in registration order. It is
+// nil on a nil credentialHealth.
+func (c *credentialHealth) report() []credentialStatus {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	sources := append([]credentialSource(nil), c.sources...)
+	c.mu.Unlock()
+
+	now := c.clock.Now()
+	statuses := make([]credentialStatus, 0, len(sources))
+	for _, s := range sources {
+		status := credentialStatus{Name: s.name, Status: credentialOK}
+		if s.expiresAt != nil {
+			expiresAt := s.expiresAt().UTC()
+			left := int64(expiresAt.Sub(now).Seconds())
+			status.ExpiresAt, status.ExpiresInSeconds = &expiresAt, &left
+			switch {
+			case !now.Before(expiresAt):
+				status.Status = credentialExpired
+			case expiresAt.Sub(now) < c.warnBefore:
+				status.Status = credentialExpiring
+			}
+		} else {
+			fetched := s.fetchedAt()
+			if fetched.IsZero() {
+				status.Status = credentialNotFetched
+			} else {
+				age := int64(now.Sub(fetched).Seconds())
+				status.AgeSeconds = &age
+				if now.Sub(fetched) > s.staleAfter {
+					status.Status = credentialStale
+				}
+			}
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// check logs a warning when material starts expiring, expires, or goes
+// stale, and an info line when it recovers.
+func (c *credentialHealth) check() {
+	statuses := c.report()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, s := range statuses {
+		previous, seen := c.warned[s.Name]
+		c.warned[s.Name] = s.Status
+		if seen && previous == s.Status {
+			continue
+		}
+		attrs := []any{"credential", s.Name, "status", s.Status}
+		if s.ExpiresAt != nil {
+			attrs = append(attrs, "expires_at", s.ExpiresAt.Format(time.RFC3339))
+		}
+		if s.AgeSeconds != nil {
+			attrs = append(attrs, "age_seconds", *s.AgeSeconds)
+		}
+		switch s.Status {
+		case credentialExpiring, credentialExpired, credentialStale:
+			slog.Warn("credential needs attention", attrs...)
+		case credentialOK:
+			if seen && previous != credentialNotFetched {
+				slog.Info("credential recovered", attrs...)
+			}
+		}
+	}
+}
+
+func (c *credentialHealth) start(context.Context) error {
+	c.check()
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+	c.done = make(chan struct{})
+	go func() {
+		defer close(c.done)
+		ticker := time.NewTicker(credentialCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				c.check()
+			}
+		}
+	}()
+	return nil
+}
+
+func (c *credentialHealth) stop(ctx context.Context) error {
+	if c.cancel == nil {
+		return nil
+	}
+	c.cancel()
+	select {
+	case <-c.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,72 @@
+package session
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCredentialHealthReport(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	c := newCredentialHealth(14 * 24 * time.Hour)
+	c.clock = ClockFunc(func() time.Time { return now })
+
+	certExpiry := now.Add(30 * 24 * time.Hour)
+	var fetched time.Time
+	c.watchExpiry("tls_certificate", func() time.Time { return certExpiry })
+	c.watchAge("jwks", func() time.Time { return fetched }, jwksStaleAfter)
+
+	statuses := func() map[string]credentialStatus {
+		byName := map[string]credentialStatus{}
+		for _, s := range c.report() {
+			byName[s.Name] = s
+		}
+		return byName
+	}
+
+	got := statuses()
+	if s := got["tls_certificate"]; s.Status != credentialOK || *s.ExpiresInSeconds != int64(30*24*time.Hour/time.Second) {
+		t.Fatalf("expected a healthy certificate, got %+v", s)
+	}
+	if s := got["jwks"]; s.Status != credentialNotFetched || s.AgeSeconds != nil {
+		t.Fatalf("expected unfetched keys, got %+v", s)
+	}
+
+	certExpiry = now.Add(24 * time.Hour)
+	fetched = now.Add(-time.Hour)
+	got = statuses()
+	if s := got["tls_certificate"]; s.Status != credentialExpiring {
+		t.Fatalf("expected an expiring certificate, got %+v", s)
+	}
+	if s := got["jwks"]; s.Status != credentialOK || *s.AgeSeconds != 3600 {
+		t.Fatalf("expected fresh keys, got %+v", s)
+	}
+
+	certExpiry = now
+	fetched = now.Add(-2 * jwksStaleAfter)
+	got = statuses()
+	if got["tls_certificate"].Status != credentialExpired || got["jwks"].Status != credentialStale {
+		t.Fatalf("expected expired and stale material, got %+v", got)
+	}
+}
+
+func TestReadinessReportsCredentials(t *testing.T) {
+	probe := newReadinessProbe()
+	probe.credentials = newCredentialHealth(time.Hour)
+	probe.credentials.watchExpiry("tls_certificate", func() time.Time { return time.Now().Add(-time.Minute) })
+
+	rec := httptest.NewRecorder()
+	probe.handle(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected expired material not to affect readiness, got %d", rec.Code)
+	}
+	var report readinessReport
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Credentials) != 1 || report.Credentials[0].Status != credentialExpired {
+		t.Fatalf("expected the expired certificate to be reported, got %+v", report.Credentials)
+	}
+}
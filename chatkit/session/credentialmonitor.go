@@ -0,0 +1,77 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// Values of CHATKIT_READY_OPENAI_CHECK. recent is the default: ping spends
+// an API call on every probe, and since every instance shares the upstream,
+// one OpenAI outage fails them all at once and drains the fleet.
+const (
+	readyOpenAIPing   = "ping"
+	readyOpenAIRecent = "recent"
+	readyOpenAIOff    = "off"
+)
+
+const defaultReadyRecentCalls = 5
+
+// credentialMonitor remembers whether OpenAI accepted the API key on the
+// most recent upstream responses. It lets /readyz notice broken credentials
+// from real traffic instead of spending an API call on every probe. Only
+// 401 and 403 count as failures: other upstream errors hit every instance
+// alike and are left to the circuit breaker.
+type credentialMonitor struct {
+	mu       sync.Mutex
+	rejected []bool
+	next     int
+	seen     int
+}
+
+func newCredentialMonitor(window int) *credentialMonitor {
+	return &credentialMonitor{rejected: make([]bool, window)}
+}
+
+// client returns a copy of base whose responses are recorded by the
+// monitor. base must not be nil.
+func (m *credentialMonitor) client(base *http.Client) *http.Client {
+	c := *base
+	next := c.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	c.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		resp, err := next.RoundTrip(req)
+		if err == nil {
+			m.record(resp.StatusCode)
+		}
+		return resp, err
+	})
+	return &c
+}
+
+func (m *credentialMonitor) record(status int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rejected[m.next] = status == http.StatusUnauthorized || status == http.StatusForbidden
+	m.next = (m.next + 1) % len(m.rejected)
+	m.seen++
+}
+
+// check fails when OpenAI rejected the credentials on each of the last
+// window responses.
+func (m *credentialMonitor) check(context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.seen < len(m.rejected) {
+		return nil
+	}
+	for _, rejected := range m.rejected {
+		if !rejected {
+			return nil
+		}
+	}
+	return fmt.Errorf("OpenAI rejected the API key on the last %d calls", len(m.rejected))
+}
@@ -0,0 +1,42 @@
+package session
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCredentialMonitorFailsAfterRejectedWindow(t *testing.T) {
+	status := http.StatusUnauthorized
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer upstream.Close()
+
+	m := newCredentialMonitor(3)
+	client := m.client(&http.Client{})
+	call := func() {
+		resp, err := client.Get(upstream.URL)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		resp.Body.Close()
+	}
+
+	call()
+	call()
+	if err := m.check(context.Background()); err != nil {
+		t.Fatalf("expected a partial window to pass, got %v", err)
+	}
+	call()
+	if err := m.check(context.Background()); err == nil {
+		t.Fatal("expected three rejected calls to fail the check")
+	}
+
+	status = http.StatusInternalServerError
+	call()
+	if err := m.check(context.Background()); err != nil {
+		t.Fatalf("expected a non-credential failure to restore readiness, got %v", err)
+	}
+}
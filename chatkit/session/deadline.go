@@ -0,0 +1,51 @@
+package session
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	requestTimeoutHeader = "X-Request-Timeout-Ms"
+
+	// upstreamDeadlineMargin is reserved out of every budget for writing the
+	// response after the upstream call returns.
+	upstreamDeadlineMargin = 250 * time.Millisecond
+)
+
+// withRequestDeadline gives every request context a deadline matching how
+// long the response can still be delivered: the server's write timeout, or
+// the client's own X-Request-Timeout-Ms hint when that is shorter.
+func withRequestDeadline(writeTimeout time.Duration, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timeout := writeTimeout
+		if hint, err := strconv.ParseInt(r.Header.Get(requestTimeoutHeader), 10, 64); err == nil && hint > 0 {
+			if d := time.Duration(hint) * time.Millisecond; timeout <= 0 || d < timeout {
+				timeout = d
+			}
+		}
+		if timeout <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// upstreamBudget returns how long an upstream call may take: limit, reduced
+// to what remains of ctx's deadline minus upstreamDeadlineMargin. A result
+// of zero or less means the budget is already spent.
+func upstreamBudget(ctx context.Context, now time.Time, limit time.Duration) time.Duration {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return limit
+	}
+	if remaining := deadline.Sub(now) - upstreamDeadlineMargin; remaining < limit {
+		return remaining
+	}
+	return limit
+}
@@ -0,0 +1,82 @@
+package session
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	openai "github.com/openai/openai-go/v3"
+)
+
+func TestUpstreamBudget(t *testing.T) {
+	now := time.Now()
+	if got := upstreamBudget(context.Background(), now, openaiRequestTimeout); got != openaiRequestTimeout {
+		t.Fatalf("expected full budget without deadline, got %s", got)
+	}
+
+	ctx, cancel := context.WithDeadline(context.Background(), now.Add(2*time.Second))
+	defer cancel()
+	if got := upstreamBudget(ctx, now, openaiRequestTimeout); got != 2*time.Second-upstreamDeadlineMargin {
+		t.Fatalf("expected budget trimmed to client deadline, got %s", got)
+	}
+
+	ctx, cancel = context.WithDeadline(context.Background(), now.Add(time.Minute))
+	defer cancel()
+	if got := upstreamBudget(ctx, now, openaiRequestTimeout); got != openaiRequestTimeout {
+		t.Fatalf("expected budget capped at limit, got %s", got)
+	}
+}
+
+func TestWithRequestDeadlineHonoursClientHint(t *testing.T) {
+	var remaining time.Duration
+	handler := withRequestDeadline(writeTimeout, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deadline, ok := r.Context().Deadline()
+		if !ok {
+			t.Fatal("expected request deadline")
+		}
+		remaining = time.Until(deadline)
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/chatkit/session", nil)
+	req.Header.Set(requestTimeoutHeader, "3000")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if remaining <= 0 || remaining > 3*time.Second {
+		t.Fatalf("expected deadline within client hint, got %s", remaining)
+	}
+}
+
+func TestHandleSessionBudgetExceeded(t *testing.T) {
+	handler := newSessionHandler(func(ctx context.Context, params openai.BetaChatKitSessionNewParams) (*openai.ChatSession, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}, "w", 1200, 10)
+	router := withRequestDeadline(writeTimeout, http.HandlerFunc(handler.handleSession))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/chatkit/session", strings.NewReader(`{"user":"u"}`))
+	req.Header.Set(requestTimeoutHeader, "300")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Fatalf("expected status 504, got %d", rec.Code)
+	}
+	if handler.budgetExceeded.Load() != 1 {
+		t.Fatalf("expected budget exceeded to be recorded")
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/api/chatkit/session", strings.NewReader(`{"user":"u"}`))
+	req.Header.Set(requestTimeoutHeader, "100")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Fatalf("expected status 504 for spent budget, got %d", rec.Code)
+	}
+	if handler.budgetExceeded.Load() != 2 {
+		t.Fatalf("expected spent budget to be recorded")
+	}
+}
@@ -0,0 +1,83 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"sort"
+)
+
+const warningDecoratorFailed = "response_decorator_failed"
+
+// SessionInfo describes a session that was just created, for hooks that run
+// after session creation.
+type SessionInfo struct {
+	SessionID  string
+	User       string
+	WorkflowID string
+	Profile    string
+	ExpiresAt  int64
+	// OpenAIRequestID is OpenAI's ID for the call that created the session,
+	// for billing reconciliation.
+	OpenAIRequestID string
+}
+
+// ResponseDecorator returns extra fields to add to a successful session
+// response, such as an app-specific chat token or a feature flag snapshot,
+// so that frontends can boot the chat UI in one round trip. It cannot
+// replace the fields the server sets itself. The request identity, if any,
+// is available from ctx via IdentityFromContext.
+type ResponseDecorator func(ctx context.Context, session SessionInfo) (map[string]any, error)
+
+// decorate merges extra into resp. Extra fields may not collide with the
+// response's own fields, whether or not resp sets them.
+func decorate(resp sessionResponse, extra map[string]any) (map[string]any, error) {
+	reserved := jsonFieldIndex(reflect.TypeOf(resp))
+	var collisions []string
+	for k := range extra {
+		if _, ok := reserved[k]; ok {
+			collisions = append(collisions, k)
+		}
+	}
+	if collisions != nil {
+		sort.Strings(collisions)
+		return nil, fmt.Errorf("fields %q are reserved", collisions)
+	}
+
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return nil, err
+	}
+	fields := make(map[string]any, len(extra)+len(reserved))
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	for k, v := range extra {
+		fields[k] = v
+	}
+	return fields, nil
+}
+
+// writeSessionResponse writes resp, decorated when a ResponseDecorator is
+// configured. A failing decorator does not fail the request, since the
+// session already exists; the response carries a warning instead.
+func (h *sessionHandler) writeSessionResponse(w http.ResponseWriter, r *http.Request, resp sessionResponse, session SessionInfo) {
+	if h.decorator == nil {
+		writeJSON(w, http.StatusOK, resp)
+		return
+	}
+	extra, err := h.decorator(r.Context(), session)
+	if err == nil {
+		var fields map[string]any
+		if fields, err = decorate(resp, extra); err == nil {
+			writeJSON(w, http.StatusOK, fields)
+			return
+		}
+	}
+	slog.WarnContext(r.Context(), "response decorator failed", "user", h.redact.value("user", session.User), "error", err)
+	resp.Warnings = append(resp.Warnings, responseWarning{Code: warningDecoratorFailed, Message: "additional session fields are unavailable"})
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,70 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandleSessionDecoratesResponse(t *testing.T) {
+	fake := &fakeSessionCreator{clientSecret: "secret"}
+	handler := newSessionHandler(fake.Create, "w", 1200, 10)
+	var got SessionInfo
+	handler.decorator = func(ctx context.Context, session SessionInfo) (map[string]any, error) {
+		got = session
+		return map[string]any{"chat_token": "tok_" + session.User, "flags": map[string]bool{"beta": true}}, nil
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/chatkit/session", strings.NewReader(`{"user":"u"}`))
+	rec := httptest.NewRecorder()
+	handler.handleSession(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rec.Code)
+	}
+	var resp map[string]any
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if resp["client_secret"] != "secret" || resp["chat_token"] != "tok_u" || resp["flags"] == nil {
+		t.Fatalf("unexpected response: %v", resp)
+	}
+	if got.User != "u" || got.WorkflowID != "w" || got.Profile != "default" {
+		t.Fatalf("unexpected session info: %+v", got)
+	}
+}
+
+func TestHandleSessionDecoratorFailureKeepsSession(t *testing.T) {
+	for name, decorator := range map[string]ResponseDecorator{
+		"error": func(context.Context, SessionInfo) (map[string]any, error) {
+			return nil, errors.New("token service down")
+		},
+		"reserved field": func(context.Context, SessionInfo) (map[string]any, error) {
+			return map[string]any{"client_secret": "spoofed"}, nil
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			handler := newSessionHandler((&fakeSessionCreator{clientSecret: "secret"}).Create, "w", 1200, 10)
+			handler.decorator = decorator
+
+			req := httptest.NewRequest(http.MethodPost, "/api/chatkit/session", strings.NewReader(`{"user":"u"}`))
+			rec := httptest.NewRecorder()
+			handler.handleSession(rec, req)
+
+			var resp sessionResponse
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if rec.Code != http.StatusOK || resp.ClientSecret != "secret" {
+				t.Fatalf("expected the session to be returned, got %d %+v", rec.Code, resp)
+			}
+			if len(resp.Warnings) != 1 || resp.Warnings[0].Code != warningDecoratorFailed {
+				t.Fatalf("expected a decorator warning, got %+v", resp.Warnings)
+			}
+		})
+	}
+}
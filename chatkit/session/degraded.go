@@ -0,0 +1,125 @@
+package session
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	degradedReasonMaintenance = "maintenance"
+	degradedReasonUpstream    = "upstream_unavailable"
+	degradedReasonSuspended   = "suspended"
+
+	defaultMaintenanceMessage = "Chat is down for scheduled maintenance. Please try again shortly."
+	upstreamDegradedMessage   = "Chat is temporarily unavailable. Please try again in a moment."
+
+	// defaultDegradedRetryAfter is the retry hint when no better estimate
+	// is known.
+	defaultDegradedRetryAfter = 60 * time.Second
+)
+
+// degradedResponse is the 503 payload returned while session creation is
+// intentionally unavailable. Its shape is stable so that frontends can
+// render it as a banner.
+type degradedResponse struct {
+	Error             string `json:"error"`
+	Reason            string `json:"reason"`
+	Message           string `json:"message"`
+	RetryAfterSeconds int64  `json:"retry_after_seconds"`
+	RetryAt           string `json:"retry_at,omitempty"`
+	StatusPageURL     string `json:"status_page_url,omitempty"`
+	// QueuePosition and EstimatedWaitSeconds are set when the admission
+	// queue turns a request away.
+	QueuePosition        int   `json:"queue_position,omitempty"`
+	EstimatedWaitSeconds int64 `json:"estimated_wait_seconds,omitempty"`
+	// Region is the region that answered. FailoverURL and FailoverRegion
+	// name another region's session endpoint that clients may retry
+	// against instead of waiting.
+	Region         string `json:"region,omitempty"`
+	FailoverURL    string `json:"failover_url,omitempty"`
+	FailoverRegion string `json:"failover_region,omitempty"`
+}
+
+// degradedLinks are the pointers added to every degraded response.
+type degradedLinks struct {
+	statusPageURL string
+	region        string
+	failover      *regionFailover
+}
+
+// apply adds the links to resp. The failover endpoint is left out of
+// suspensions, which apply in every region.
+func (l degradedLinks) apply(resp *degradedResponse) {
+	resp.StatusPageURL = l.statusPageURL
+	resp.Region = l.region
+	if l.failover != nil && resp.Reason != degradedReasonSuspended {
+		resp.FailoverURL = l.failover.url
+		resp.FailoverRegion = l.failover.region
+	}
+}
+
+// writeDegraded writes a degraded response with a Retry-After header. A
+// zero retryAt falls back to defaultDegradedRetryAfter.
+func writeDegraded(w http.ResponseWriter, now time.Time, reason, message string, retryAt time.Time, links degradedLinks) {
+	resp := degradedResponse{Error: "degraded", Reason: reason, Message: message}
+	links.apply(&resp)
+	retryAfter := defaultDegradedRetryAfter
+	if !retryAt.IsZero() {
+		retryAfter = retryAt.Sub(now)
+		resp.RetryAt = retryAt.UTC().Format(time.RFC3339)
+	}
+	resp.RetryAfterSeconds = int64(retryAfter.Seconds())
+	if resp.RetryAfterSeconds < 1 {
+		resp.RetryAfterSeconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.FormatInt(resp.RetryAfterSeconds, 10))
+	writeJSON(w, http.StatusServiceUnavailable, resp)
+}
+
+// maintenanceMode is the operator switch that turns session creation off.
+type maintenanceMode struct {
+	mu     sync.Mutex
+	status maintenanceStatus
+}
+
+type maintenanceStatus struct {
+	Enabled bool       `json:"enabled"`
+	Message string     `json:"message,omitempty"`
+	Until   *time.Time `json:"until,omitempty"`
+}
+
+func newMaintenanceMode(enabled bool, message string) *maintenanceMode {
+	return &maintenanceMode{status: maintenanceStatus{Enabled: enabled, Message: message}}
+}
+
+func (m *maintenanceMode) snapshot() maintenanceStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.status
+}
+
+func (m *maintenanceMode) set(status maintenanceStatus) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.status = status
+}
+
+// active reports whether maintenance mode is on at now, returning the message
+// and expected end time to show users.
+func (m *maintenanceMode) active(now time.Time) (bool, string, time.Time) {
+	status := m.snapshot()
+	if !status.Enabled {
+		return false, "", time.Time{}
+	}
+	message := status.Message
+	if message == "" {
+		message = defaultMaintenanceMessage
+	}
+	var until time.Time
+	if status.Until != nil && status.Until.After(now) {
+		until = *status.Until
+	}
+	return true, message, until
+}
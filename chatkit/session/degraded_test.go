@@ -0,0 +1,216 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openai/openai-go/v3"
+)
+
+func upstreamStatusError(status int) error {
+	return &openai.Error{
+		StatusCode: status,
+		Request:    httptest.NewRequest(http.MethodPost, "https://api.openai.com/v1/chatkit/sessions", nil),
+		Response:   &http.Response{StatusCode: status},
+	}
+}
+
+func TestCircuitBreakerOpensAndRecovers(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	b := newCircuitBreaker(2, 30*time.Second)
+	b.clock = ClockFunc(func() time.Time { return now })
+
+	b.record(upstreamStatusError(http.StatusBadRequest))
+	b.record(upstreamStatusError(http.StatusBadGateway))
+	if ok, _ := b.allow(); !ok {
+		t.Fatalf("expected circuit to stay closed after one upstream failure")
+	}
+	b.record(errors.New("connection reset"))
+	ok, retryAt := b.allow()
+	if ok || !retryAt.Equal(now.Add(30*time.Second)) {
+		t.Fatalf("expected open circuit until %s, got ok=%v retryAt=%s", now.Add(30*time.Second), ok, retryAt)
+	}
+
+	now = now.Add(31 * time.Second)
+	if ok, _ := b.allow(); !ok {
+		t.Fatalf("expected a trial request after cooldown")
+	}
+	if ok, _ := b.allow(); ok {
+		t.Fatalf("expected only one trial request")
+	}
+	b.record(upstreamStatusError(http.StatusServiceUnavailable))
+	if ok, _ := b.allow(); ok {
+		t.Fatalf("expected failed trial to reopen the circuit")
+	}
+
+	if s := b.snapshot(); s.State != breakerOpen || s.Opened != 2 || s.Rejected != 3 {
+		t.Fatalf("unexpected open status %+v", s)
+	}
+
+	now = now.Add(31 * time.Second)
+	if s := b.snapshot(); s.State != breakerHalfOpen || s.OpenUntil != "" {
+		t.Fatalf("expected half-open status after cooldown, got %+v", s)
+	}
+	b.allow()
+	b.record(nil)
+	if ok, _ := b.allow(); !ok {
+		t.Fatalf("expected successful trial to close the circuit")
+	}
+	if s := b.snapshot(); s.State != breakerClosed || s.ConsecutiveFailures != 0 {
+		t.Fatalf("unexpected closed status %+v", s)
+	}
+}
+
+func TestHandleSessionDegradedWhenCircuitOpen(t *testing.T) {
+	fake := &fakeSessionCreator{err: upstreamStatusError(http.StatusServiceUnavailable)}
+	handler := newSessionHandler(fake.Create, "w", 1200, 10)
+	handler.breaker = newCircuitBreaker(1, time.Minute)
+	handler.statusPageURL = "https://status.example.com"
+
+	post := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/chatkit/session", strings.NewReader(`{"user":"u"}`))
+		rec := httptest.NewRecorder()
+		handler.handleSession(rec, req)
+		return rec
+	}
+
+	if rec := post(); rec.Code != http.StatusInternalServerError {
+		t.Fatalf("expected first failure to return 500, got %d", rec.Code)
+	}
+	fake.called = false
+	rec := post()
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 while circuit is open, got %d", rec.Code)
+	}
+	if fake.called {
+		t.Fatalf("session creator should not be called while circuit is open")
+	}
+	var resp degradedResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Error != "degraded" || resp.Reason != degradedReasonUpstream || resp.StatusPageURL != "https://status.example.com" || resp.RetryAt == "" {
+		t.Fatalf("unexpected degraded response: %+v", resp)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected Retry-After header")
+	}
+}
+
+func TestHandleSessionClientDeadlineDoesNotTripBreaker(t *testing.T) {
+	handler := newSessionHandler(func(ctx context.Context, params openai.BetaChatKitSessionNewParams) (*openai.ChatSession, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}, "w", 1200, 10)
+	handler.breaker = newCircuitBreaker(1, time.Minute)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/chatkit/session", strings.NewReader(`{"user":"u"}`))
+	req.Header.Set(requestTimeoutHeader, "300")
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	rec := httptest.NewRecorder()
+	handler.handleSession(rec, req.WithContext(ctx))
+
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Fatalf("expected 504, got %d", rec.Code)
+	}
+	if ok, _ := handler.breaker.allow(); !ok {
+		t.Fatalf("client deadline should not open the circuit")
+	}
+}
+
+func TestHandleSessionMaintenanceMode(t *testing.T) {
+	fake := &fakeSessionCreator{clientSecret: "secret"}
+	handler := newSessionHandler(fake.Create, "w", 1200, 10)
+	handler.maintenance = newMaintenanceMode(true, "")
+	until := time.Now().Add(10 * time.Minute)
+	handler.maintenance.set(maintenanceStatus{Enabled: true, Message: "Upgrading", Until: &until})
+
+	req := httptest.NewRequest(http.MethodPost, "/api/chatkit/session", strings.NewReader(`{"user":"u"}`))
+	rec := httptest.NewRecorder()
+	handler.handleSession(rec, req)
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503, got %d", rec.Code)
+	}
+	var resp degradedResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Reason != degradedReasonMaintenance || resp.Message != "Upgrading" || resp.RetryAfterSeconds < 590 {
+		t.Fatalf("unexpected degraded response: %+v", resp)
+	}
+	if fake.called {
+		t.Fatalf("session creator should not be called in maintenance mode")
+	}
+}
+
+func TestAdminToggleMaintenance(t *testing.T) {
+	bans, _ := newBanList("")
+	admin := newAdminHandler("s3cret", bans, newConfigDriftDetector(nil, ""))
+	admin.maintenance = newMaintenanceMode(false, "")
+	router, err := newRouter(newSessionHandler(nil, "w", 1200, 10), admin, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	do := func(method, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/admin/maintenance", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer s3cret")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do(http.MethodPost, `{"until":"soon"}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for bad until, got %d", rec.Code)
+	}
+	if rec := do(http.MethodPost, `{"message":"Upgrading","until":"2030-01-01T00:00:00Z"}`); rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	if status := admin.maintenance.snapshot(); !status.Enabled || status.Message != "Upgrading" || status.Until == nil {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+	if rec := do(http.MethodDelete, ""); rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	if admin.maintenance.snapshot().Enabled {
+		t.Fatalf("expected maintenance mode to be off")
+	}
+}
+
+func TestTenantKeyFailuresDoNotOpenTheSharedCircuit(t *testing.T) {
+	fake := &fakeSessionCreator{clientSecret: "secret"}
+	handler := newSessionHandler(fake.Create, "w", 1200, 10)
+	handler.breaker = newCircuitBreaker(1, time.Minute)
+	acme := &tenant{name: "acme", workflowID: "wf_acme", ownKey: true, createSession: (&fakeSessionCreator{err: upstreamStatusError(http.StatusTooManyRequests)}).Create}
+
+	post := func(tn *tenant) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/chatkit/session", strings.NewReader(`{"user":"u"}`))
+		if tn != nil {
+			req = req.WithContext(context.WithValue(req.Context(), tenantContextKey{}, tn))
+		}
+		rec := httptest.NewRecorder()
+		handler.handleSession(rec, req)
+		return rec.Code
+	}
+
+	if code := post(acme); code != http.StatusInternalServerError {
+		t.Fatalf("expected the tenant's 429 to fail the request, got %d", code)
+	}
+	if code := post(acme); code != http.StatusServiceUnavailable {
+		t.Fatalf("expected the tenant's circuit to open, got %d", code)
+	}
+	if code := post(nil); code != http.StatusOK {
+		t.Fatalf("expected sessions on the server's key to be unaffected, got %d", code)
+	}
+	if s := handler.breaker.snapshot(); s.State != breakerClosed {
+		t.Fatalf("expected the shared circuit to stay closed, got %+v", s)
+	}
+}
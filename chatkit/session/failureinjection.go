@@ -0,0 +1,98 @@
+package session
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const debugFailHeader = "X-Debug-Fail"
+
+// Failure modes accepted in X-Debug-Fail.
+const (
+	injectRateLimit   = "ratelimit"
+	injectTimeout     = "timeout"
+	injectUpstream500 = "upstream-500"
+)
+
+// injectedRetryAfter is the Retry-After sent with an injected rate limit.
+const injectedRetryAfter = 30 * time.Second
+
+// nonProductionEnvironments are the CHATKIT_ENVIRONMENT values in which
+// failure injection may be enabled. Any other value, including none, is
+// treated as production.
+var nonProductionEnvironments = map[string]bool{"dev": true, "development": true, "staging": true, "test": true}
+
+// failureInjector lets frontend developers trigger each error state of the
+// session endpoint on demand. Only testers, identified by an API key or
+// claim, may inject failures; when origins are listed, their requests must
+// also come from one of them. Everyone else's X-Debug-Fail header is
+// ignored. The Origin header alone authorizes nothing, since any client
+// outside a browser can set it.
+type failureInjector struct {
+	origins map[string]bool
+	testers *testerPolicy
+}
+
+// newFailureInjector parses comma-separated allowed origins, which may be
+// empty. environment is CHATKIT_ENVIRONMENT, which must name a
+// non-production environment.
+func newFailureInjector(environment, origins string, testers *testerPolicy) (*failureInjector, error) {
+	if !nonProductionEnvironments[strings.ToLower(strings.TrimSpace(environment))] {
+		return nil, fmt.Errorf("failure injection needs CHATKIT_ENVIRONMENT set to dev, development, staging, or test, got %q", environment)
+	}
+	if testers == nil {
+		return nil, fmt.Errorf("failure injection needs tester API keys or a tester claim")
+	}
+	f := &failureInjector{origins: make(map[string]bool), testers: testers}
+	for _, origin := range strings.Split(origins, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			f.origins[origin] = true
+		}
+	}
+	return f, nil
+}
+
+// requested returns the failure mode r asks for, or "" when it asks for
+// none or is not allowed to.
+func (f *failureInjector) requested(r *http.Request) string {
+	mode := strings.TrimSpace(r.Header.Get(debugFailHeader))
+	if mode == "" {
+		return ""
+	}
+	if !f.testers.allowed(r) {
+		return ""
+	}
+	if len(f.origins) > 0 && !f.origins[r.Header.Get("Origin")] {
+		return ""
+	}
+	return mode
+}
+
+// writeInjectedFailure answers exactly as the session endpoint does when the
+// failure really happens.
+func (h *sessionHandler) writeInjectedFailure(w http.ResponseWriter, mode string) {
+	switch mode {
+	case injectRateLimit:
+		writeRateLimited(w, injectedRetryAfter)
+	case injectTimeout:
+		http.Error(w, "upstream latency budget exceeded", http.StatusGatewayTimeout)
+	case injectUpstream500:
+		if h.exposeUpstreamErrors {
+			writeJSON(w, http.StatusInternalServerError, sessionErrorResponse{
+				Error:     "failed to create session",
+				Upstream:  &upstreamErrorDetail{Status: http.StatusInternalServerError, Type: "server_error", Message: "injected failure"},
+				RequestID: responseRequestID(w),
+			})
+			return
+		}
+		http.Error(w, "failed to create session", http.StatusInternalServerError)
+	default:
+		writeValidationErrors(w, []fieldError{{
+			Field:   debugFailHeader,
+			Code:    validationCodeInvalidValue,
+			Message: fmt.Sprintf("%s must be one of %s, %s, or %s", debugFailHeader, injectRateLimit, injectTimeout, injectUpstream500),
+		}})
+	}
+}
@@ -0,0 +1,78 @@
+package session
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNewFailureInjectorRefusesProduction(t *testing.T) {
+	if _, err := newFailureInjector("Production", "https://app.example.com", nil); err == nil {
+		t.Fatal("expected failure injection to be refused in production")
+	}
+	testers, _ := newTesterPolicy("tester-key", "")
+	if _, err := newFailureInjector("", "", testers); err == nil {
+		t.Fatal("expected failure injection to be refused without an environment")
+	}
+	if _, err := newFailureInjector("staging", "https://app.example.com", nil); err == nil {
+		t.Fatal("expected an error without testers")
+	}
+	if _, err := newFailureInjector("Staging", "", testers); err != nil {
+		t.Fatalf("expected failure injection in staging, got %v", err)
+	}
+}
+
+func TestHandleSessionInjectsFailures(t *testing.T) {
+	fake := &fakeSessionCreator{clientSecret: "secret"}
+	handler := newSessionHandler(fake.Create, "w", 1200, 10)
+	handler.exposeUpstreamErrors = true
+	testers, _ := newTesterPolicy("tester-key", "")
+	failures, err := newFailureInjector("staging", "https://dev.example.com", testers)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	handler.failures = failures
+
+	send := func(mode, origin, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/chatkit/session", strings.NewReader(`{"user":"u"}`))
+		req.Header.Set(debugFailHeader, mode)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		if key != "" {
+			req.Header.Set(apiKeyHeader, key)
+		}
+		rec := httptest.NewRecorder()
+		handler.handleSession(rec, req)
+		return rec
+	}
+
+	if rec := send(injectRateLimit, "https://dev.example.com", "tester-key"); rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "30" {
+		t.Fatalf("expected an injected 429, got %d", rec.Code)
+	}
+	if rec := send(injectTimeout, "https://dev.example.com", "tester-key"); rec.Code != http.StatusGatewayTimeout {
+		t.Fatalf("expected an injected 504, got %d", rec.Code)
+	}
+	rec := send(injectUpstream500, "https://dev.example.com", "tester-key")
+	if rec.Code != http.StatusInternalServerError || !strings.Contains(rec.Body.String(), `"upstream":{"status":500`) {
+		t.Fatalf("expected an injected upstream 500, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := send("explode", "https://dev.example.com", "tester-key"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected an unknown mode to be rejected, got %d", rec.Code)
+	}
+	if fake.called {
+		t.Fatal("injected failures must not reach OpenAI")
+	}
+
+	for _, c := range []struct{ origin, key string }{
+		{"https://dev.example.com", ""},
+		{"https://dev.example.com", "wrong-key"},
+		{"https://app.example.com", "tester-key"},
+	} {
+		fake.called = false
+		if rec := send(injectTimeout, c.origin, c.key); rec.Code != http.StatusOK || !fake.called {
+			t.Fatalf("origin %s, key %q: expected the header to be ignored, got %d", c.origin, c.key, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,22 @@
+//go:build linux
+
+package session
+
+import (
+	"os"
+	"syscall"
+)
+
+// openFileDescriptors returns the number of open file descriptors and the
+// soft limit for the process.
+func openFileDescriptors() (int, uint64, error) {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return 0, 0, err
+	}
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil {
+		return len(entries), 0, err
+	}
+	return len(entries), limit.Cur, nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package session
+
+import "errors"
+
+// openFileDescriptors is only implemented on Linux.
+func openFileDescriptors() (int, uint64, error) {
+	return 0, 0, errors.New("file descriptor stats are not supported on this platform")
+}
@@ -0,0 +1,219 @@
+package session
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Values of OPENAI_GATEWAY_SIGNING.
+const (
+	gatewaySigningHMAC  = "hmac"
+	gatewaySigningSigV4 = "sigv4"
+)
+
+const (
+	defaultGatewayHMACHeader   = "X-Gateway-Signature"
+	defaultGatewaySigV4Service = "execute-api"
+	sigV4Algorithm             = "AWS4-HMAC-SHA256"
+	sigV4TimeFormat            = "20060102T150405Z"
+)
+
+// gatewaySigner signs outbound OpenAI requests for an internal gateway that
+// fronts OpenAI and only accepts authenticated callers.
+type gatewaySigner interface {
+	sign(req *http.Request, body []byte, now time.Time)
+}
+
+// gatewaySignerFromEnv builds the signer selected by OPENAI_GATEWAY_SIGNING,
+// or returns nil when signing is off.
+func gatewaySignerFromEnv(getenv func(string) string) (gatewaySigner, error) {
+	switch scheme := getenv("OPENAI_GATEWAY_SIGNING"); scheme {
+	case "":
+		return nil, nil
+	case gatewaySigningHMAC:
+		secret := getenv("OPENAI_GATEWAY_HMAC_SECRET")
+		if secret == "" {
+			return nil, fmt.Errorf("OPENAI_GATEWAY_HMAC_SECRET is required for hmac gateway signing")
+		}
+		header := getenv("OPENAI_GATEWAY_HMAC_HEADER")
+		if header == "" {
+			header = defaultGatewayHMACHeader
+		}
+		return hmacGatewaySigner{header: http.CanonicalHeaderKey(header), secret: []byte(secret)}, nil
+	case gatewaySigningSigV4:
+		s := sigV4GatewaySigner{
+			accessKeyID:  getenv("AWS_ACCESS_KEY_ID"),
+			secretKey:    getenv("AWS_SECRET_ACCESS_KEY"),
+			sessionToken: getenv("AWS_SESSION_TOKEN"),
+			region:       getenv("OPENAI_GATEWAY_SIGV4_REGION"),
+			service:      getenv("OPENAI_GATEWAY_SIGV4_SERVICE"),
+		}
+		if s.service == "" {
+			s.service = defaultGatewaySigV4Service
+		}
+		if s.accessKeyID == "" || s.secretKey == "" || s.region == "" {
+			return nil, fmt.Errorf("sigv4 gateway signing needs AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, and OPENAI_GATEWAY_SIGV4_REGION")
+		}
+		return s, nil
+	default:
+		return nil, fmt.Errorf("invalid OPENAI_GATEWAY_SIGNING %q: expected hmac or sigv4", scheme)
+	}
+}
+
+// gatewaySigningClient returns a copy of base, or of a default client when
+// base is nil, that signs every request with signer just before it is sent.
+func gatewaySigningClient(base *http.Client, signer gatewaySigner, clock Clock) *http.Client {
+	c := &http.Client{}
+	if base != nil {
+		*c = *base
+	}
+	next := c.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	c.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		var body []byte
+		if req.Body != nil {
+			var err error
+			body, err = io.ReadAll(req.Body)
+			req.Body.Close()
+			if err != nil {
+				return nil, err
+			}
+		}
+		signed := req.Clone(req.Context())
+		signed.Body = io.NopCloser(bytes.NewReader(body))
+		signer.sign(signed, body, clock.Now())
+		return next.RoundTrip(signed)
+	})
+	return c
+}
+
+// hmacGatewaySigner sets header to t=<unix seconds>,v1=<hex digest>, where
+// the digest is HMAC-SHA256 over "<t>.<method>.<request URI>.<body>".
+type hmacGatewaySigner struct {
+	header string
+	secret []byte
+}
+
+func (s hmacGatewaySigner) sign(req *http.Request, body []byte, now time.Time) {
+	ts := strconv.FormatInt(now.Unix(), 10)
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(ts + "." + req.Method + "." + req.URL.RequestURI() + "."))
+	mac.Write(body)
+	req.Header.Set(s.header, "t="+ts+",v1="+hex.EncodeToString(mac.Sum(nil)))
+}
+
+// sigV4GatewaySigner signs requests with AWS Signature Version 4, as
+// expected by gateways behind API Gateway or IAM-authenticated load
+// balancers. The signature replaces the Authorization header, so the
+// gateway has to supply the OpenAI API key itself.
+type sigV4GatewaySigner struct {
+	accessKeyID  string
+	secretKey    string
+	sessionToken string
+	region       string
+	service      string
+}
+
+func (s sigV4GatewaySigner) sign(req *http.Request, body []byte, now time.Time) {
+	amzDate := now.UTC().Format(sigV4TimeFormat)
+	date := amzDate[:8]
+	req.Header.Del("Authorization")
+	req.Header.Set("X-Amz-Date", amzDate)
+	if s.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host, "x-amz-date": amzDate}
+	if s.sessionToken != "" {
+		headers["x-amz-security-token"] = s.sessionToken
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(headers[name]) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	payloadHash := sha256.Sum256(body)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		sigV4CanonicalPath(req.URL),
+		sigV4CanonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := date + "/" + s.region + "/" + s.service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := sigV4Algorithm + "\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, s.service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", sigV4Algorithm+" Credential="+s.accessKeyID+"/"+scope+", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// sigV4CanonicalPath encodes each segment of the already escaped path once
+// more, as SigV4 requires for every service but S3.
+func sigV4CanonicalPath(u *url.URL) string {
+	path := u.EscapedPath()
+	if path == "" {
+		return "/"
+	}
+	segments := strings.Split(path, "/")
+	for i, seg := range segments {
+		segments[i] = sigV4Escape(seg)
+	}
+	return strings.Join(segments, "/")
+}
+
+func sigV4CanonicalQuery(query url.Values) string {
+	var pairs []string
+	for key, values := range query {
+		for _, v := range values {
+			pairs = append(pairs, sigV4Escape(key)+"="+sigV4Escape(v))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// sigV4Escape percent-encodes everything except unreserved characters.
+func sigV4Escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,77 @@
+package session
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSigV4GatewaySignerMatchesAWSVector(t *testing.T) {
+	// The get-vanilla case from the AWS Signature Version 4 test suite.
+	signer := sigV4GatewaySigner{
+		accessKeyID: "AKIDEXAMPLE",
+		secretKey:   "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+		region:      "us-east-1",
+		service:     "service",
+	}
+	req := httptest.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	req.Header.Set("Authorization", "Bearer sk-test")
+	signer.sign(req, nil, time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Fatalf("expected Authorization %q, got %q", want, got)
+	}
+	if got := req.Header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+		t.Fatalf("expected X-Amz-Date 20150830T123600Z, got %q", got)
+	}
+}
+
+func TestGatewaySigningClientSignsBody(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	var header, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Get("X-Gateway-Signature")
+		b, _ := io.ReadAll(r.Body)
+		body = string(b)
+	}))
+	defer server.Close()
+
+	signer, err := gatewaySignerFromEnv(func(key string) string {
+		return map[string]string{"OPENAI_GATEWAY_SIGNING": "hmac", "OPENAI_GATEWAY_HMAC_SECRET": "secret"}[key]
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	client := gatewaySigningClient(nil, signer, ClockFunc(func() time.Time { return now }))
+	resp, err := client.Post(server.URL+"/v1/chatkit/sessions?x=1", "application/json", strings.NewReader(`{"user":"u"}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+
+	if body != `{"user":"u"}` {
+		t.Fatalf("expected the body to reach the gateway unchanged, got %q", body)
+	}
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(`1700000000.POST./v1/chatkit/sessions?x=1.{"user":"u"}`))
+	if want := "t=1700000000,v1=" + hex.EncodeToString(mac.Sum(nil)); header != want {
+		t.Fatalf("expected signature %q, got %q", want, header)
+	}
+
+	for _, env := range []map[string]string{
+		{"OPENAI_GATEWAY_SIGNING": "hmac"},
+		{"OPENAI_GATEWAY_SIGNING": "sigv4", "AWS_ACCESS_KEY_ID": "id", "AWS_SECRET_ACCESS_KEY": "key"},
+		{"OPENAI_GATEWAY_SIGNING": "mtls"},
+	} {
+		if _, err := gatewaySignerFromEnv(func(key string) string { return env[key] }); err == nil {
+			t.Fatalf("expected an error for %v", env)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package session
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/openai/openai-go/v3"
+)
+
+// Config configures a session handler embedded in another service's router.
+type Config struct {
+	// Client makes the ChatKit calls; see NewOpenAIClient.
+	Client openai.Client
+	// WorkflowID is the workflow sessions are minted for.
+	WorkflowID string
+	// ExpiresAfterSeconds and RateLimitPerMinute are passed to OpenAI for
+	// every session.
+	ExpiresAfterSeconds int64
+	RateLimitPerMinute  int64
+	// Decorator, if set, adds fields to session responses.
+	Decorator ResponseDecorator
+	// Hooks run around every session creation, in order.
+	Hooks []SessionHook
+	// Clock defaults to SystemClock.
+	Clock Clock
+}
+
+// NewHandler returns a handler that mints a session for each POST, with the
+// same request and response bodies as the server's session endpoint. It
+// does not include the middleware the server configures from the
+// environment: wrap it with a cors.Policy and the host's own
+// authentication, and pass the authenticated caller with
+// ContextWithIdentity so that its subject becomes the session user.
+func NewHandler(cfg Config) (http.Handler, error) {
+	if cfg.WorkflowID == "" {
+		return nil, errors.New("a workflow ID is required")
+	}
+	if cfg.ExpiresAfterSeconds < 0 || cfg.RateLimitPerMinute < 0 {
+		return nil, errors.New("session expiry and rate limit must be non-negative")
+	}
+	h := newSessionHandler(sdkChatKitAPI{client: cfg.Client}.CreateSession, cfg.WorkflowID, cfg.ExpiresAfterSeconds, cfg.RateLimitPerMinute)
+	h.decorator = cfg.Decorator
+	h.hooks = cfg.Hooks
+	if cfg.Clock != nil {
+		h.clock = cfg.Clock
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		h.handleSession(w, r)
+	}), nil
+}
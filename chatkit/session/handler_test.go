@@ -0,0 +1,62 @@
+package session
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"context"
+)
+
+func TestNewHandlerMintsSessionsForTheContextIdentity(t *testing.T) {
+	transport := &recordingTransport{}
+	var info SessionInfo
+	handler, err := NewHandler(Config{
+		Client:              NewOpenAIClient(OpenAIClientConfig{APIKey: "k", BaseURL: "https://openai.example.com/v1", HTTPClient: &http.Client{Transport: transport}}),
+		WorkflowID:          "wf_embedded",
+		ExpiresAfterSeconds: 600,
+		RateLimitPerMinute:  10,
+		Decorator: func(ctx context.Context, session SessionInfo) (map[string]any, error) {
+			info = session
+			return nil, nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/chat/session", strings.NewReader(`{"user":"ignored"}`))
+	req = req.WithContext(ContextWithIdentity(req.Context(), Identity{Subject: "alice"}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var resp sessionResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil || resp.ClientSecret != "ek_1" {
+		t.Fatalf("unexpected response %+v (%v)", resp, err)
+	}
+	if len(transport.requests) != 1 {
+		t.Fatalf("expected one upstream call, got %d", len(transport.requests))
+	}
+	body, _ := io.ReadAll(transport.requests[0].Body)
+	if !strings.Contains(string(body), `"user":"alice"`) || !strings.Contains(string(body), `"wf_embedded"`) {
+		t.Fatalf("expected the identity's subject and the workflow upstream, got %s", body)
+	}
+	if info.SessionID != "cksess_1" || info.OpenAIRequestID != "req_upstream_1" {
+		t.Fatalf("expected OpenAI's session and request IDs, got %+v", info)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/chat/session", nil))
+	if rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") != http.MethodPost {
+		t.Fatalf("expected 405 with Allow: POST, got %d %v", rec.Code, rec.Header())
+	}
+
+	if _, err := NewHandler(Config{}); err == nil {
+		t.Fatal("expected a missing workflow ID to be rejected")
+	}
+}
@@ -0,0 +1,933 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/openai/openai-go/v3"
+	"github.com/openai/openai-go/v3/shared/constant"
+
+	"openai-chatkit-backend/chatkit/auth"
+	"openai-chatkit-backend/chatkit/webhooks"
+)
+
+type sessionCreator func(context.Context, openai.BetaChatKitSessionNewParams) (*openai.ChatSession, error)
+
+type sessionRequest struct {
+	User       string `json:"user"`
+	Platform   string `json:"platform"`
+	AppVersion string `json:"app_version"`
+	WorkflowID string `json:"workflow_id"`
+}
+
+type sessionResponse struct {
+	ClientSecret string `json:"client_secret,omitempty"`
+	// ClientSecretDelivery is "cookie" when the secret was set as an
+	// HttpOnly cookie instead of being returned in the body.
+	ClientSecretDelivery string `json:"client_secret_delivery,omitempty"`
+	// ResumeToken lets the client mint a follow-up session through the
+	// refresh endpoint.
+	ResumeToken string            `json:"resume_token,omitempty"`
+	Warnings    []responseWarning `json:"warnings,omitempty"`
+}
+
+type responseWarning struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type serviceHoursError struct {
+	Error      string `json:"error"`
+	Message    string `json:"message"`
+	NextOpenAt string `json:"next_open_at,omitempty"`
+}
+
+// sessionCreatedEvent is the payload of the session.created webhook.
+type sessionCreatedEvent struct {
+	SessionID   string            `json:"session_id"`
+	User        string            `json:"user"`
+	WorkflowID  string            `json:"workflow_id"`
+	Profile     string            `json:"profile"`
+	Platform    string            `json:"platform"`
+	AppVersion  string            `json:"app_version,omitempty"`
+	ExpiresAt   int64             `json:"expires_at"`
+	Attribution map[string]string `json:"attribution,omitempty"`
+	// ClientSessionID is the frontend's X-Client-Session-ID.
+	ClientSessionID string `json:"client_session_id,omitempty"`
+	// Tenant is the tenant resolved from X-Tenant-Key.
+	Tenant string `json:"tenant,omitempty"`
+	// Region is the region that minted the session.
+	Region string `json:"region,omitempty"`
+	// OpenAIRequestID is OpenAI's ID for the call that created the session.
+	OpenAIRequestID string `json:"openai_request_id,omitempty"`
+}
+
+type sessionHandler struct {
+	createSession sessionCreator
+	// runtimeMu guards the settings the admin API can change at runtime:
+	// workflowID, expiresAfterSeconds, rateLimitPerMinute, and
+	// allowedWorkflows.
+	runtimeMu           sync.RWMutex
+	workflowID          string
+	expiresAfterSeconds int64
+	rateLimitPerMinute  int64
+	identity            *auth.Mapper
+	attribution         attributionPolicy
+	quota               *quotaTracker
+	activeSessions      *activeSessionCap
+	onQuotaWarning      func(user string, status quotaStatus)
+	serviceHours        *serviceHoursPolicy
+	bans                *banList
+	sandbox             *sandboxProfile
+	webhooks            *webhooks.Dispatcher
+	shadow              *shadowMirror
+	platformLimits      []platformLimit
+	platforms           *platformCounts
+	maintenance         *maintenanceMode
+	suspensions         *suspensionList
+	breaker             *circuitBreaker
+	// credentialBreakers hold the circuits of upstream credentials other
+	// than the server's own key, by credential.
+	breakersMu         sync.Mutex
+	credentialBreakers map[string]*circuitBreaker
+	admission          *admissionQueue
+	statusPageURL      string
+	// region names the region this deployment serves, for active-active
+	// multi-region setups; failover is another region's endpoint.
+	region           string
+	failover         *regionFailover
+	redact           *redactor
+	testers          *testerPolicy
+	secretCookie     *secretCookie
+	slo              *latencySLO
+	anomalies        *anomalyDetector
+	schemas          workflowSchemas
+	allowedWorkflows map[string]bool
+	ipLimit          *rateLimiter
+	userLimit        *rateLimiter
+	userLocks        userLocker
+	idempotency      idempotencyStore
+	usage            *usageCounter
+	// prefs holds users' saved chat preferences; workflowAliases resolves
+	// their workflow preference.
+	prefs           prefsStore
+	workflowAliases map[string]workflowRef
+	// originPolicy restricts the features of sessions minted for some
+	// origins.
+	originPolicy originPolicy
+	// resumeTokens issues the tokens the refresh endpoint accepts.
+	resumeTokens   *resumeTokens
+	idempotencyTTL time.Duration
+	decorator      ResponseDecorator
+	hooks          []SessionHook
+	auth           *auth.Verifier
+	failures       *failureInjector
+	signer         *requestSigner
+	tenants        *tenantRegistry
+	projects       *projectRouter
+	// lookups guards the limits and preferences endpoints, which answer
+	// for the user the caller names.
+	lookups *lookupGuard
+	clock   Clock
+
+	// exposeUpstreamErrors includes sanitized OpenAI error details in
+	// failure responses. Intended for non-production debugging only.
+	exposeUpstreamErrors bool
+
+	// budgetExceeded counts requests whose upstream call was cut short by
+	// the client's deadline rather than openaiRequestTimeout.
+	budgetExceeded atomic.Int64
+}
+
+func newSessionHandler(create sessionCreator, workflowID string, expiresAfterSeconds, rateLimitPerMinute int64) *sessionHandler {
+	return &sessionHandler{
+		createSession:       create,
+		workflowID:          workflowID,
+		expiresAfterSeconds: expiresAfterSeconds,
+		rateLimitPerMinute:  rateLimitPerMinute,
+		platforms:           newPlatformCounts(),
+		usage:               newUsageCounter(),
+		clock:               SystemClock,
+	}
+}
+
+// workflowAllowed reports whether clients may ask for workflow id instead of
+// the default one.
+func (h *sessionHandler) workflowAllowed(id string) bool {
+	h.runtimeMu.RLock()
+	defer h.runtimeMu.RUnlock()
+	return id == h.workflowID || h.allowedWorkflows[id]
+}
+
+// resumableWorkflow reports whether a resume token's workflow id may still
+// be started under settings: the workflow the request gets anyway, such as a
+// tenant's pinned workflow, or, outside tenants and profiles, one allowed by
+// CHATKIT_ALLOWED_WORKFLOW_IDS or a workflow alias.
+func (h *sessionHandler) resumableWorkflow(settings sessionSettings, id string) bool {
+	if id == settings.workflowID {
+		return true
+	}
+	if settings.tenant != "" || settings.profile != "default" {
+		return false
+	}
+	if h.workflowAllowed(id) {
+		return true
+	}
+	for _, ref := range h.workflowAliases {
+		if ref.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// sessionSettings are the upstream parameters and dependencies used to mint
+// a single session.
+type sessionSettings struct {
+	profile             string
+	workflowID          string
+	expiresAfterSeconds int64
+	rateLimitPerMinute  int64
+	createSession       sessionCreator
+	quota               *quotaTracker
+	activeSessions      *activeSessionCap
+	// tenant names the tenant whose settings replaced the defaults.
+	tenant string
+	// project is the OpenAI project the session is routed to, if any.
+	project *openAIProject
+	// breaker is the circuit of the credential the session is minted with,
+	// or nil when none applies.
+	breaker *circuitBreaker
+}
+
+// settingsFor selects the session settings for r, switching to the sandbox
+// profile when the request carries a sandbox API key, and otherwise to the
+// settings of the request's tenant, if any.
+func (h *sessionHandler) settingsFor(r *http.Request, platform clientPlatform) sessionSettings {
+	sandbox := h.sandbox != nil && h.sandbox.matches(r.Header.Get(apiKeyHeader))
+	settings := h.profileSettings(sandbox, platform)
+	if settings.profile == "default" {
+		settings.breaker = h.breaker
+	}
+	if t := tenantFromContext(r.Context()); t != nil && settings.profile == "default" {
+		settings.tenant = t.name
+		if t.ownKey {
+			settings.breaker = h.credentialBreaker("tenant:" + t.name)
+		}
+		settings.workflowID = t.workflowID
+		settings.createSession = t.createSession
+		if t.expiresAfterSeconds > 0 {
+			settings.expiresAfterSeconds = t.expiresAfterSeconds
+		}
+		if t.rateLimitPerMinute > 0 {
+			settings.rateLimitPerMinute = t.rateLimitPerMinute
+		}
+		if t.quota != nil {
+			settings.quota = t.quota
+		}
+		if t.activeSessions != nil {
+			settings.activeSessions = t.activeSessions
+		}
+	}
+	if h.projects != nil && settings.profile == "default" {
+		t := tenantFromContext(r.Context())
+		tenant := TenantFromContext(r.Context())
+		if t != nil {
+			tenant = t.name
+		}
+		claims, _ := auth.ClaimsFromContext(r.Context())
+		if p := h.projects.route(tenant, claims); p != nil && (t == nil || !t.ownKey) {
+			settings.project = p
+			settings.createSession = p.createSession
+			settings.breaker = h.credentialBreaker("project:" + p.name)
+		}
+	}
+	return settings
+}
+
+// credentialBreaker returns the circuit for an upstream credential other than
+// the server's own key, such as a tenant's key or an OpenAI project, created
+// with the settings of the server's circuit. Each credential trips its own
+// circuit, so a tenant that exhausts its own quota does not fail sessions
+// fast for everyone else. It returns nil when the breaker is off.
+func (h *sessionHandler) credentialBreaker(credential string) *circuitBreaker {
+	if h.breaker == nil {
+		return nil
+	}
+	h.breakersMu.Lock()
+	defer h.breakersMu.Unlock()
+	b := h.credentialBreakers[credential]
+	if b == nil {
+		b = newCircuitBreaker(h.breaker.threshold, h.breaker.cooldown)
+		b.clock = h.breaker.clock
+		if h.credentialBreakers == nil {
+			h.credentialBreakers = make(map[string]*circuitBreaker)
+		}
+		h.credentialBreakers[credential] = b
+	}
+	return b
+}
+
+// profileSettings returns the default or sandbox settings. Platform rate
+// limit overrides apply to the default profile only.
+func (h *sessionHandler) profileSettings(sandbox bool, platform clientPlatform) sessionSettings {
+	h.runtimeMu.RLock()
+	settings := sessionSettings{
+		profile:             "default",
+		workflowID:          h.workflowID,
+		expiresAfterSeconds: h.expiresAfterSeconds,
+		rateLimitPerMinute:  h.rateLimitPerMinute,
+		createSession:       h.createSession,
+		quota:               h.quota,
+		activeSessions:      h.activeSessions,
+	}
+	h.runtimeMu.RUnlock()
+	if sandbox && h.sandbox != nil {
+		settings.profile = "sandbox"
+		if h.sandbox.workflowID != "" {
+			settings.workflowID = h.sandbox.workflowID
+		}
+		settings.expiresAfterSeconds = h.sandbox.expiresAfterSeconds
+		settings.rateLimitPerMinute = h.sandbox.rateLimitPerMinute
+		settings.createSession = h.sandbox.createSession
+		settings.quota = nil
+		settings.activeSessions = nil
+		return settings
+	}
+	if rate, ok := platformRateLimit(h.platformLimits, platform); ok {
+		settings.rateLimitPerMinute = rate
+	}
+	return settings
+}
+
+// resolveUser returns the user a request acts as, with any ChatKit state
+// added by identity mapping. A verified identity always decides the user;
+// the client-supplied requested user only counts for unauthenticated
+// requests.
+func (h *sessionHandler) resolveUser(ctx context.Context, requested string) (string, map[string]string, error) {
+	if resumed := resumeClaimsFromContext(ctx); resumed != nil {
+		return resumed.User, nil, nil
+	}
+	user := requested
+	id, _ := IdentityFromContext(ctx)
+	if id.Subject != "" {
+		user = id.Subject
+	}
+	claims := auth.Claims(id.Claims)
+	if claims == nil || h.identity == nil {
+		return user, nil, nil
+	}
+	mapped, state, err := h.identity.Apply(claims)
+	if err != nil {
+		return "", nil, err
+	}
+	if mapped != "" {
+		user = mapped
+	}
+	return user, state, nil
+}
+
+func newRouter(sessionHandler *sessionHandler, admin *adminHandler, warmup *warmupGate, readiness *readinessProbe, opts *routerOptions) (http.Handler, error) {
+	if opts == nil {
+		opts = &routerOptions{}
+	}
+	openAPI, err := opts.paths.openAPIDocument()
+	if err != nil {
+		return nil, fmt.Errorf("remap OpenAPI document: %w", err)
+	}
+
+	var sessionMiddleware []middleware
+	if warmup != nil {
+		sessionMiddleware = append(sessionMiddleware, warmup.require)
+	}
+	if sessionHandler.signer != nil {
+		sessionMiddleware = append(sessionMiddleware, sessionHandler.signer.require)
+	}
+	if sessionHandler.tenants != nil {
+		sessionMiddleware = append(sessionMiddleware, sessionHandler.tenants.require)
+	}
+
+	routes := newRouteRegistry()
+	routes.remap = opts.paths
+	routes.handle(http.MethodGet, "/healthz", healthHandler)
+	routes.handle(http.MethodGet, "/livez", healthHandler)
+	routes.handle(http.MethodGet, "/openapi.json", openAPIHandler(openAPI))
+	if readiness != nil {
+		routes.handle(http.MethodGet, "/readyz", readiness.handle)
+	}
+	routes.handle(http.MethodPost, defaultSessionPath, sessionHandler.handleSession, sessionMiddleware...)
+	if sessionHandler.resumeTokens != nil {
+		routes.handle(http.MethodPost, refreshPath, sessionHandler.handleRefresh, sessionMiddleware...)
+	}
+	// The limits and preferences endpoints sit outside the session route
+	// group so that they never spend the per-IP session rate limit, so they
+	// check credentials themselves.
+	var limitsMiddleware []middleware
+	if sessionHandler.auth != nil {
+		limitsMiddleware = append(limitsMiddleware, sessionHandler.auth.Require)
+	}
+	if sessionHandler.tenants != nil {
+		limitsMiddleware = append(limitsMiddleware, sessionHandler.tenants.require)
+	}
+	if sessionHandler.lookups != nil {
+		limitsMiddleware = append(limitsMiddleware, sessionHandler.lookups.guard)
+	}
+	routes.handle(http.MethodGet, limitsPath, sessionHandler.handleLimits, limitsMiddleware...)
+	if sessionHandler.prefs != nil {
+		routes.handle(http.MethodGet, prefsPath, sessionHandler.getPrefs, limitsMiddleware...)
+		routes.handle(http.MethodPut, prefsPath, sessionHandler.putPrefs, limitsMiddleware...)
+	}
+	if admin != nil {
+		admin.register(routes)
+	}
+	mux, err := routes.build()
+	if err != nil {
+		return nil, err
+	}
+	if opts.validateRequests {
+		validator, err := newOpenAPIValidator(openAPI)
+		if err != nil {
+			return nil, fmt.Errorf("parse OpenAPI document: %w", err)
+		}
+		mux = validator.wrap(mux)
+	}
+	return opts.pipeline.wrap(sessionHandler, opts.paths, mux), nil
+}
+
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+func (h *sessionHandler) handleSession(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if h.failures != nil {
+		if mode := h.failures.requested(r); mode != "" {
+			h.writeInjectedFailure(w, mode)
+			return
+		}
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodyBytes)
+
+	var payload sessionRequest
+	problems, err := decodeJSONObject(r.Body, &payload)
+	if err != nil {
+		http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if len(problems) == 1 && problems[0].Code == validationCodeInvalidJSON {
+		writeValidationErrors(w, problems)
+		return
+	}
+
+	platform, platformProblems := resolveClientPlatform(payload, r.Header.Get("User-Agent"))
+	for _, p := range platformProblems {
+		if !hasFieldError(problems, p.Field) {
+			problems = append(problems, p)
+		}
+	}
+
+	// Tenants are pinned to their own workflow; CHATKIT_ALLOWED_WORKFLOW_IDS
+	// only applies to the server's default workflow.
+	tenant := tenantFromContext(r.Context())
+	if tenant != nil && payload.WorkflowID != "" && payload.WorkflowID != tenant.workflowID {
+		problems = append(problems, fieldError{Field: "workflow_id", Code: validationCodeInvalidValue, Message: fmt.Sprintf("workflow_id %q is not allowed", payload.WorkflowID)})
+	} else if tenant == nil && payload.WorkflowID != "" && !h.workflowAllowed(payload.WorkflowID) {
+		problems = append(problems, fieldError{Field: "workflow_id", Code: validationCodeInvalidValue, Message: fmt.Sprintf("workflow_id %q is not allowed", payload.WorkflowID)})
+	}
+
+	var overrides sessionOverrides
+	if h.testers != nil && h.testers.allowed(r) {
+		var overrideProblems []fieldError
+		overrides, overrideProblems = parseSessionOverrides(r.Header)
+		problems = append(problems, overrideProblems...)
+	}
+
+	attribution, state := h.attribution.extract(r.Header)
+	for name, value := range attribution {
+		w.Header().Set(name, value)
+	}
+	clientSession, clientSessionProblems := clientSessionID(r.Header)
+	problems = append(problems, clientSessionProblems...)
+	idemKey, idemProblems := idempotencyKey(r.Header)
+	problems = append(problems, idemProblems...)
+	if clientSession != "" {
+		addLogFields(r.Context(), slog.String("client_session_id", clientSession))
+	}
+
+	user, mappedState, err := h.resolveUser(r.Context(), payload.User)
+	if err != nil {
+		slog.WarnContext(r.Context(), "identity mapping failed", "error", err)
+		http.Error(w, "identity mapping failed", http.StatusForbidden)
+		return
+	}
+	for key, value := range mappedState {
+		if state == nil {
+			state = make(map[string]string, len(mappedState))
+		}
+		state[key] = value
+	}
+	if user == "" && !hasFieldError(problems, "user") {
+		problems = append(problems, fieldError{Field: "user", Code: validationCodeRequired, Message: "user is required"})
+	}
+	if len(problems) > 0 {
+		writeValidationErrors(w, problems)
+		return
+	}
+	addLogFields(r.Context(), slog.String("user", h.redact.value("user", user)))
+
+	if h.bans != nil && h.bans.banned(user, clientIP(r), r.Header.Get(deviceIDHeader)) {
+		slog.InfoContext(r.Context(), "rejected banned caller", "user", h.redact.value("user", user))
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	// A retry with a known Idempotency-Key gets the original session back
+	// before it can spend rate limit, quota, or another upstream call.
+	var idemStoreKey, idemHash string
+	if idemKey != "" && h.idempotency != nil {
+		sandbox := h.sandbox != nil && h.sandbox.matches(r.Header.Get(apiKeyHeader))
+		storeKey := idempotencyStoreKey([]string{user, requestTenant(r.Context()), strconv.FormatBool(sandbox)}, idemKey)
+		idemHash = idempotencyRequestHash(payload)
+		state, stored, err := h.idempotency.Reserve(r.Context(), storeKey, idempotencyPendingTTL)
+		switch {
+		case err != nil:
+			slog.WarnContext(r.Context(), "idempotency store unavailable; handling the request without it", "error", err)
+		case state == idempotencyInFlight:
+			writeIdempotencyConflict(w, http.StatusConflict, "a request with this key is still in progress")
+			return
+		case state == idempotencyCompleted:
+			h.replayIdempotentSession(w, r, stored, idemHash)
+			return
+		default:
+			idemStoreKey = storeKey
+			defer func() {
+				if idemStoreKey == "" {
+					return
+				}
+				if err := h.idempotency.Abandon(context.WithoutCancel(r.Context()), idemStoreKey); err != nil {
+					slog.WarnContext(r.Context(), "failed to release idempotency key", "error", err)
+				}
+			}()
+		}
+	}
+
+	if h.userLimit != nil {
+		if ok, retryAfter := h.userLimit.allow(user); !ok {
+			slog.InfoContext(r.Context(), "rate limited user", "user", h.redact.value("user", user))
+			writeRateLimited(w, retryAfter)
+			return
+		}
+	}
+
+	if h.serviceHours != nil {
+		if open, next := h.serviceHours.check(TenantFromContext(r.Context())); !open {
+			resp := serviceHoursError{Error: "outside_service_hours", Message: "session creation is unavailable outside service hours"}
+			if !next.IsZero() {
+				resp.NextOpenAt = next.Format(time.RFC3339)
+				w.Header().Set("Retry-After", strconv.FormatInt(int64(next.Sub(h.serviceHours.clock.Now()).Seconds())+1, 10))
+			}
+			writeJSON(w, http.StatusServiceUnavailable, resp)
+			return
+		}
+	}
+
+	if h.maintenance != nil {
+		if on, message, until := h.maintenance.active(h.clock.Now()); on {
+			writeDegraded(w, h.clock.Now(), degradedReasonMaintenance, message, until, h.degradedLinks())
+			return
+		}
+	}
+
+	// Saved preferences fill in state variables the request did not set,
+	// and their workflow alias stands in for an omitted workflow_id.
+	prefs := h.sessionPrefs(r, user)
+	for key, value := range prefs {
+		if _, set := state[key]; key == prefWorkflow || set {
+			continue
+		}
+		if state == nil {
+			state = make(map[string]string, len(prefs))
+		}
+		state[key] = value
+	}
+
+	settings := h.settingsFor(r, platform)
+	if resumed := resumeClaimsFromContext(r.Context()); resumed != nil && resumed.WorkflowID != "" {
+		// The token's workflow is checked again, since it may have been
+		// removed from the allowed workflows since the token was issued.
+		if h.resumableWorkflow(settings, resumed.WorkflowID) {
+			settings.workflowID = resumed.WorkflowID
+		} else {
+			slog.InfoContext(r.Context(), "resumed workflow is no longer allowed; using the default workflow", "workflow_id", resumed.WorkflowID)
+		}
+	} else if payload.WorkflowID != "" {
+		settings.workflowID = payload.WorkflowID
+	} else if ref := h.workflowAliases[prefs[prefWorkflow]]; ref.ID != "" && settings.profile == "default" && settings.tenant == "" {
+		settings.workflowID = ref.ID
+	}
+	// The origin's feature policy limits which workflows it may start,
+	// falling back to its first workflow when the request names none.
+	features := h.originPolicy.match(r.Header.Get("Origin"))
+	if features != nil {
+		if payload.WorkflowID != "" && !features.allowsWorkflow(payload.WorkflowID) {
+			writeValidationErrors(w, []fieldError{{Field: "workflow_id", Code: validationCodeInvalidValue, Message: fmt.Sprintf("workflow_id %q is not allowed for this origin", payload.WorkflowID)}})
+			return
+		}
+		features.restrict(&settings)
+	}
+	addLogFields(r.Context(), slog.String("workflow_id", settings.workflowID), slog.String("profile", settings.profile))
+	if settings.tenant != "" {
+		addLogFields(r.Context(), slog.String("tenant", settings.tenant))
+	}
+	if settings.project != nil {
+		addLogFields(r.Context(), slog.String("openai_project", settings.project.name))
+	}
+	sp := spanFromContext(r.Context())
+	sp.setAttr("chatkit.workflow_id", settings.workflowID)
+	sp.setAttr("chatkit.profile", settings.profile)
+	if settings.tenant != "" {
+		sp.setAttr("chatkit.tenant", settings.tenant)
+	}
+	if settings.project != nil {
+		sp.setAttr("openai.project", settings.project.name)
+	}
+	// tenantName is the registry tenant, or the tenant an embedder's auth put in
+	// the context.
+	tenantName := settings.tenant
+	if tenantName == "" {
+		tenantName = TenantFromContext(r.Context())
+	}
+	if h.suspensions != nil {
+		if s := h.suspensions.check(tenantName, settings.workflowID, h.clock.Now()); s != nil {
+			slog.InfoContext(r.Context(), "rejected session for suspended "+s.Kind, s.Kind, s.Value)
+			var until time.Time
+			if s.Until != nil {
+				until = *s.Until
+			}
+			writeDegraded(w, h.clock.Now(), degradedReasonSuspended, s.message(), until, h.degradedLinks())
+			return
+		}
+	}
+	if ok, retryAfter := h.anomalies.allow(tenantName, r.Header.Get("Origin")); !ok {
+		slog.InfoContext(r.Context(), "rate limited session after unusual minting", "tenant", tenantName)
+		writeRateLimited(w, retryAfter)
+		return
+	}
+	if problems := h.schemas.validate(settings.workflowID, state); len(problems) > 0 {
+		writeValidationErrors(w, problems)
+		return
+	}
+	breaker := settings.breaker
+
+	if h.userLocks != nil {
+		lockCtx, cancelLock := context.WithTimeout(r.Context(), openaiRequestTimeout)
+		release, err := h.userLocks.Lock(lockCtx, user)
+		cancelLock()
+		if err != nil {
+			slog.WarnContext(r.Context(), "timed out waiting for another session request", "user", h.redact.value("user", user))
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "another session request for this user is in progress", http.StatusServiceUnavailable)
+			return
+		}
+		defer release()
+	}
+
+	budget := upstreamBudget(r.Context(), h.clock.Now(), openaiRequestTimeout)
+	if budget <= 0 {
+		h.budgetExceeded.Add(1)
+		slog.WarnContext(r.Context(), "upstream latency budget exceeded before calling OpenAI", "user", h.redact.value("user", user))
+		http.Error(w, "upstream latency budget exceeded", http.StatusGatewayTimeout)
+		return
+	}
+
+	var slot *activeSession
+	if settings.activeSessions != nil {
+		var firstExpiry time.Time
+		var ok bool
+		slot, firstExpiry, ok = settings.activeSessions.reserve(user, time.Duration(settings.expiresAfterSeconds)*time.Second)
+		if !ok {
+			slog.InfoContext(r.Context(), "active session limit reached", "user", h.redact.value("user", user), "limit", settings.activeSessions.limit)
+			writeActiveSessionLimit(w, h.clock.Now(), settings.activeSessions.limit, firstExpiry)
+			return
+		}
+	}
+
+	var warnings []responseWarning
+	if settings.quota != nil {
+		status, ok := settings.quota.reserve(user)
+		if !ok {
+			if slot != nil {
+				settings.activeSessions.release(user, slot)
+			}
+			w.Header().Set("Retry-After", strconv.FormatInt(settings.quota.retryAfter(status), 10))
+			http.Error(w, "session quota exceeded", http.StatusTooManyRequests)
+			return
+		}
+		if status.warn {
+			slog.InfoContext(r.Context(), "quota warning", "user", h.redact.value("user", user), "used", status.used, "limit", status.limit)
+			if h.onQuotaWarning != nil {
+				h.onQuotaWarning(user, status)
+			}
+			warnings = append(warnings, responseWarning{
+				Code:    "quota_nearly_exhausted",
+				Message: fmt.Sprintf("%d of %d sessions used; %d remaining until %s", status.used, status.limit, status.remaining(), status.resetAt.UTC().Format(time.RFC3339)),
+			})
+		}
+	}
+
+	if h.admission != nil && settings.profile == "default" {
+		tenant := settings.tenant
+		if tenant == "" {
+			tenant = TenantFromContext(r.Context())
+		}
+		release, rejected := h.admission.acquire(r.Context(), tenant)
+		if rejected != nil {
+			if settings.quota != nil {
+				settings.quota.refund(user)
+			}
+			if slot != nil {
+				settings.activeSessions.release(user, slot)
+			}
+			slog.WarnContext(r.Context(), "admission queue full", "user", h.redact.value("user", user), "position", rejected.position, "estimated_wait", rejected.estimatedWait)
+			writeCapacityExceeded(w, rejected, h.degradedLinks())
+			return
+		}
+		defer release()
+	}
+
+	breakerRecorded := false
+	if breaker != nil {
+		if ok, retryAt := breaker.allow(); !ok {
+			if settings.quota != nil {
+				settings.quota.refund(user)
+			}
+			if slot != nil {
+				settings.activeSessions.release(user, slot)
+			}
+			writeDegraded(w, h.clock.Now(), degradedReasonUpstream, upstreamDegradedMessage, retryAt, h.degradedLinks())
+			return
+		}
+		// A request that leaves before reaching OpenAI, such as one a hook
+		// rejects or one that panics, must hand back the trial slot it may
+		// hold, or the circuit stays half-open forever.
+		defer func() {
+			if !breakerRecorded {
+				breaker.skip()
+			}
+		}()
+	}
+
+	slog.DebugContext(r.Context(), "creating session", "user", h.redact.value("user", user), "profile", settings.profile, "platform", platform.Name, "app_version", platform.AppVersion, "workflow_id", settings.workflowID, "expires_after_seconds", settings.expiresAfterSeconds, "rate_limit_per_minute", settings.rateLimitPerMinute)
+
+	ctx, cancel := context.WithTimeout(r.Context(), budget)
+	defer cancel()
+
+	params := openai.BetaChatKitSessionNewParams{
+		User: user,
+		Workflow: openai.ChatSessionWorkflowParam{
+			ID: settings.workflowID,
+		},
+		ExpiresAfter: openai.ChatSessionExpiresAfterParam{
+			Seconds: settings.expiresAfterSeconds,
+			Anchor:  constant.CreatedAt("").Default(),
+		},
+		RateLimits: openai.ChatSessionRateLimitsParam{
+			MaxRequestsPer1Minute: openai.Int(settings.rateLimitPerMinute),
+		},
+	}
+	for key, value := range state {
+		if params.Workflow.StateVariables == nil {
+			params.Workflow.StateVariables = make(map[string]openai.ChatSessionWorkflowParamStateVariableUnion, len(state))
+		}
+		params.Workflow.StateVariables[key] = openai.ChatSessionWorkflowParamStateVariableUnion{OfString: openai.String(value)}
+	}
+
+	if !overrides.empty() {
+		overrides.apply(&params)
+		slog.InfoContext(r.Context(), "tester overrides applied", "user", h.redact.value("user", user), "overrides", overrides.String())
+	}
+	if features != nil {
+		features.apply(&params)
+	}
+	hookReq := Request{Params: params, Profile: settings.profile, Tenant: tenantName, Platform: platform.Name, AppVersion: platform.AppVersion}
+	if len(h.hooks) > 0 {
+		if err := h.beforeCreate(r.Context(), &hookReq); err != nil {
+			if settings.quota != nil {
+				settings.quota.refund(user)
+			}
+			if slot != nil {
+				settings.activeSessions.release(user, slot)
+			}
+			writeHookRejection(w, r, err)
+			return
+		}
+		params = hookReq.Params
+	}
+
+	if h.shadow != nil && settings.profile == "default" {
+		h.shadow.mirror(params)
+	}
+
+	ctx, upstream := withOpenAICallRecord(ctx)
+	mintStart := h.clock.Now()
+	session, err := settings.createSession(ctx, params)
+	if settings.project != nil {
+		settings.project.record(h.clock.Now().Sub(mintStart), err)
+	}
+	clientDeadline := err != nil && budget < openaiRequestTimeout && errors.Is(err, context.DeadlineExceeded)
+	if h.slo != nil && settings.profile == "default" && !clientDeadline {
+		h.slo.record(h.clock.Now().Sub(mintStart), err != nil)
+	}
+	if breaker != nil && !clientDeadline {
+		breaker.record(err)
+		breakerRecorded = true
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to create session", "error", err)
+		if settings.quota != nil {
+			settings.quota.refund(user)
+		}
+		if slot != nil {
+			settings.activeSessions.release(user, slot)
+		}
+		if clientDeadline {
+			h.budgetExceeded.Add(1)
+			slog.WarnContext(r.Context(), "upstream latency budget exceeded", "budget", budget, "user", h.redact.value("user", user))
+			http.Error(w, "upstream latency budget exceeded", http.StatusGatewayTimeout)
+			return
+		}
+		if h.exposeUpstreamErrors {
+			writeJSON(w, http.StatusInternalServerError, sessionErrorResponse{Error: "failed to create session", Upstream: describeUpstreamError(err), RequestID: responseRequestID(w)})
+			return
+		}
+		http.Error(w, "failed to create session", http.StatusInternalServerError)
+		return
+	}
+	if slot != nil {
+		settings.activeSessions.confirm(slot, session.ExpiresAt)
+	}
+	h.afterCreate(r.Context(), hookReq, session)
+	openAIRequestID := upstream.requestID()
+	usage := usageSession{SessionID: session.ID, OpenAIRequestID: openAIRequestID, Tenant: tenantName, WorkflowID: settings.workflowID}
+	if settings.project != nil {
+		usage.OpenAIProject = settings.project.name
+	}
+	h.usage.recordSession(usage)
+	h.anomalies.record(tenantName, r.Header.Get("Origin"))
+	slog.DebugContext(r.Context(), "session created", "user", h.redact.value("user", user), "workflow_id", settings.workflowID, "attribution", h.redact.values("attribution", attribution))
+	if h.platforms != nil {
+		h.platforms.record(platform)
+	}
+	if h.webhooks != nil {
+		h.webhooks.Enqueue("session.created", sessionCreatedEvent{
+			SessionID:       session.ID,
+			User:            user,
+			WorkflowID:      settings.workflowID,
+			Profile:         settings.profile,
+			Platform:        platform.Name,
+			AppVersion:      platform.AppVersion,
+			ExpiresAt:       session.ExpiresAt,
+			Attribution:     attribution,
+			ClientSessionID: clientSession,
+			Tenant:          settings.tenant,
+			Region:          h.region,
+			OpenAIRequestID: openAIRequestID,
+		})
+	}
+
+	info := SessionInfo{
+		SessionID:       session.ID,
+		User:            user,
+		WorkflowID:      settings.workflowID,
+		Profile:         settings.profile,
+		ExpiresAt:       session.ExpiresAt,
+		OpenAIRequestID: openAIRequestID,
+	}
+	if idemStoreKey != "" {
+		stored, _ := json.Marshal(idempotentSession{
+			RequestHash:         idemHash,
+			ClientSecret:        session.ClientSecret,
+			ExpiresAfterSeconds: settings.expiresAfterSeconds,
+			Warnings:            warnings,
+			Session:             info,
+		})
+		ttl := idempotentSessionTTL(h.idempotencyTTL, h.clock.Now(), session.ExpiresAt)
+		if err := h.idempotency.Complete(context.WithoutCancel(r.Context()), idemStoreKey, stored, ttl); err != nil {
+			slog.WarnContext(r.Context(), "failed to store idempotent session", "error", err)
+		} else {
+			idemStoreKey = ""
+		}
+	}
+	h.respondWithSession(w, r, session.ClientSecret, settings.expiresAfterSeconds, warnings, info)
+}
+
+// respondWithSession writes a successful session response, delivering the
+// client secret in the body or as a cookie.
+func (h *sessionHandler) respondWithSession(w http.ResponseWriter, r *http.Request, clientSecret string, expiresAfterSeconds int64, warnings []responseWarning, info SessionInfo) {
+	resp := sessionResponse{ClientSecret: clientSecret, Warnings: warnings}
+	if h.secretCookie != nil {
+		h.secretCookie.set(w, h.clock.Now(), clientSecret, info.ExpiresAt, expiresAfterSeconds)
+		resp = sessionResponse{ClientSecretDelivery: clientSecretDeliveryCookie, Warnings: warnings}
+	}
+	if h.resumeTokens != nil {
+		var issuedAt time.Time
+		if resumed := resumeClaimsFromContext(r.Context()); resumed != nil {
+			issuedAt = time.Unix(resumed.IssuedAt, 0)
+		}
+		id, _ := IdentityFromContext(r.Context())
+		token, err := h.resumeTokens.issue(info.User, info.WorkflowID, requestTenant(r.Context()), id.Subject, issuedAt)
+		if err != nil {
+			slog.WarnContext(r.Context(), "failed to issue resume token", "error", err)
+		}
+		resp.ResumeToken = token
+	}
+	h.writeSessionResponse(w, r, resp, info)
+}
+
+// replayIdempotentSession answers a retried request with the session its
+// Idempotency-Key already created.
+func (h *sessionHandler) replayIdempotentSession(w http.ResponseWriter, r *http.Request, stored []byte, requestHash string) {
+	var s idempotentSession
+	if err := json.Unmarshal(stored, &s); err != nil {
+		slog.ErrorContext(r.Context(), "invalid idempotent session in store", "error", err)
+		http.Error(w, "failed to create session", http.StatusInternalServerError)
+		return
+	}
+	if s.RequestHash != requestHash {
+		writeIdempotencyConflict(w, http.StatusUnprocessableEntity, "the key was already used for a different request")
+		return
+	}
+	addLogFields(r.Context(), slog.Bool("idempotent_replay", true))
+	w.Header().Set(idempotentReplayedHeader, "true")
+	h.respondWithSession(w, r, s.ClientSecret, s.ExpiresAfterSeconds, s.Warnings, s.Session)
+}
+
+// clientIP returns the host part of the request's remote address.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("failed to write response", "error", err)
+	}
+}
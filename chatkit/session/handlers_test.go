@@ -0,0 +1,212 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	openai "github.com/openai/openai-go/v3"
+)
+
+type fakeSessionCreator struct {
+	clientSecret string
+	err          error
+
+	called bool
+	params openai.BetaChatKitSessionNewParams
+}
+
+func (f *fakeSessionCreator) Create(ctx context.Context, params openai.BetaChatKitSessionNewParams) (*openai.ChatSession, error) {
+	f.called = true
+	f.params = params
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &openai.ChatSession{ClientSecret: f.clientSecret}, nil
+}
+
+func TestHandleSessionDefaults(t *testing.T) {
+	fake := &fakeSessionCreator{clientSecret: "secret"}
+	const expiresAfter = int64(1200)
+	const rateLimit = int64(10)
+	handler := newSessionHandler(fake.Create, "w", expiresAfter, rateLimit)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/chatkit/session", strings.NewReader(`{"user":"u"}`))
+	rec := httptest.NewRecorder()
+
+	handler.handleSession(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rec.Code)
+	}
+	if !fake.called {
+		t.Fatalf("expected createSession to be called")
+	}
+	if fake.params.Workflow.ID != "w" {
+		t.Fatalf("expected workflow_id w, got %s", fake.params.Workflow.ID)
+	}
+	if fake.params.ExpiresAfter.Seconds != expiresAfter {
+		t.Fatalf("expected expires_after_seconds %d, got %d", expiresAfter, fake.params.ExpiresAfter.Seconds)
+	}
+	if !fake.params.RateLimits.MaxRequestsPer1Minute.Valid() || fake.params.RateLimits.MaxRequestsPer1Minute.Value != rateLimit {
+		t.Fatalf("expected rate_limit_per_minute %d", rateLimit)
+	}
+
+	var resp map[string]string
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if resp["client_secret"] != "secret" {
+		t.Fatalf("unexpected client_secret: %s", resp["client_secret"])
+	}
+}
+
+func TestHandleSessionWithValues(t *testing.T) {
+	fake := &fakeSessionCreator{clientSecret: "secret2"}
+	handler := newSessionHandler(fake.Create, "workflow-from-env", 30, 5)
+
+	body := `{"user":"u"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/chatkit/session", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+
+	handler.handleSession(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rec.Code)
+	}
+	if fake.params.Workflow.ID != "workflow-from-env" {
+		t.Fatalf("expected workflow_id workflow-from-env, got %s", fake.params.Workflow.ID)
+	}
+	if fake.params.ExpiresAfter.Seconds != 30 {
+		t.Fatalf("expected expires_after_seconds 30, got %d", fake.params.ExpiresAfter.Seconds)
+	}
+	if !fake.params.RateLimits.MaxRequestsPer1Minute.Valid() || fake.params.RateLimits.MaxRequestsPer1Minute.Value != 5 {
+		t.Fatalf("expected rate_limit_per_minute 5")
+	}
+}
+
+func TestHandleSessionSelectsAllowedWorkflow(t *testing.T) {
+	fake := &fakeSessionCreator{clientSecret: "secret"}
+	handler := newSessionHandler(fake.Create, "wf_default", 1200, 10)
+	handler.allowedWorkflows = parseAllowedWorkflowIDs("wf_support, wf_sales")
+
+	req := httptest.NewRequest(http.MethodPost, "/api/chatkit/session", strings.NewReader(`{"user":"u","workflow_id":"wf_sales"}`))
+	rec := httptest.NewRecorder()
+	handler.handleSession(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rec.Code)
+	}
+	if fake.params.Workflow.ID != "wf_sales" {
+		t.Fatalf("expected workflow wf_sales, got %s", fake.params.Workflow.ID)
+	}
+
+	fake.called = false
+	req = httptest.NewRequest(http.MethodPost, "/api/chatkit/session", strings.NewReader(`{"user":"u","workflow_id":"wf_other"}`))
+	rec = httptest.NewRecorder()
+	handler.handleSession(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d", rec.Code)
+	}
+	if fake.called || !strings.Contains(rec.Body.String(), `"field":"workflow_id"`) {
+		t.Fatalf("expected workflow_id to be rejected before OpenAI is called, got %s", rec.Body.String())
+	}
+}
+
+func TestHandleSessionValidationErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+	}{
+		{"missing user", `{}`, http.StatusBadRequest},
+		{"unknown field", `{"user":"u","workflow_id":"w","foo":1}`, http.StatusBadRequest},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			handler := newSessionHandler(func(ctx context.Context, params openai.BetaChatKitSessionNewParams) (*openai.ChatSession, error) {
+				t.Fatalf("createSession should not be called")
+				return nil, nil
+			}, "w", 1200, 10)
+			req := httptest.NewRequest(http.MethodPost, "/api/chatkit/session", strings.NewReader(tc.body))
+			rec := httptest.NewRecorder()
+
+			handler.handleSession(rec, req)
+
+			if rec.Code != tc.wantStatus {
+				t.Fatalf("expected status %d, got %d", tc.wantStatus, rec.Code)
+			}
+		})
+	}
+}
+
+func TestHandleSessionValidatesClientSessionID(t *testing.T) {
+	fake := &fakeSessionCreator{clientSecret: "secret"}
+	handler := newSessionHandler(fake.Create, "w", 1200, 10)
+
+	for id, want := range map[string]int{
+		"3f2c9a1e-7b4d-4c8e-9f10-2a6b5c4d3e21": http.StatusOK,
+		"short":                                http.StatusBadRequest,
+		"has spaces in it":                     http.StatusBadRequest,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/api/chatkit/session", strings.NewReader(`{"user":"u"}`))
+		req.Header.Set(clientSessionIDHeader, id)
+		rec := httptest.NewRecorder()
+		handler.handleSession(rec, req)
+		if rec.Code != want {
+			t.Fatalf("%q: expected status %d, got %d: %s", id, want, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestHandleSessionIdempotencyKey(t *testing.T) {
+	fake := &fakeSessionCreator{clientSecret: "secret"}
+	calls := 0
+	handler := newSessionHandler(func(ctx context.Context, params openai.BetaChatKitSessionNewParams) (*openai.ChatSession, error) {
+		calls++
+		return fake.Create(ctx, params)
+	}, "w", 1200, 10)
+	handler.idempotency = newLocalIdempotencyStore()
+	handler.idempotencyTTL = defaultIdempotencyTTL
+
+	post := func(key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/chatkit/session", strings.NewReader(body))
+		req.Header.Set(idempotencyKeyHeader, key)
+		rec := httptest.NewRecorder()
+		handler.handleSession(rec, req)
+		return rec
+	}
+
+	fake.err = errors.New("upstream down")
+	if rec := post("k1", `{"user":"u"}`); rec.Code == http.StatusOK {
+		t.Fatalf("expected the upstream failure to fail the request")
+	}
+	fake.err = nil
+	first := post("k1", `{"user":"u"}`)
+	if first.Code != http.StatusOK || first.Header().Get(idempotentReplayedHeader) != "" {
+		t.Fatalf("expected a failed attempt to release the key, got %d: %s", first.Code, first.Body.String())
+	}
+
+	fake.clientSecret = "other"
+	retry := post("k1", `{"user":"u"}`)
+	if retry.Code != http.StatusOK || retry.Header().Get(idempotentReplayedHeader) != "true" || retry.Body.String() != first.Body.String() {
+		t.Fatalf("expected the original response to be replayed, got %d: %s", retry.Code, retry.Body.String())
+	}
+	if calls != 2 {
+		t.Fatalf("expected the replay not to create a session, got %d calls", calls)
+	}
+
+	if rec := post("k1", `{"user":"u","workflow_id":"w"}`); rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected a different body to be rejected, got %d", rec.Code)
+	}
+	if rec := post("bad key", `{"user":"u"}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected a malformed key to be rejected, got %d", rec.Code)
+	}
+	if rec := post("k1", `{"user":"v"}`); rec.Code != http.StatusOK || rec.Header().Get(idempotentReplayedHeader) != "" {
+		t.Fatalf("expected keys to be scoped per user, got %d", rec.Code)
+	}
+}
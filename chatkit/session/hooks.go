@@ -0,0 +1,87 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/openai/openai-go/v3"
+)
+
+// Request is a session about to be created, as passed to SessionHooks.
+type Request struct {
+	// Params is sent to OpenAI. BeforeCreate hooks may change it, for
+	// example to add state variables or map the user to another ID.
+	Params openai.BetaChatKitSessionNewParams
+	// Profile is "default", or "sandbox" for sandbox API key requests.
+	Profile string
+	// Tenant is the tenant from X-Tenant-Key or the identity, if any.
+	Tenant     string
+	Platform   string
+	AppVersion string
+}
+
+// SessionHook runs around every session creation, so that code embedding
+// the server can enrich the request, enforce its own rules, or record
+// billing events. The request identity, if any, is available from ctx via
+// IdentityFromContext.
+type SessionHook interface {
+	// BeforeCreate runs once the request has passed the server's own
+	// checks and limits, just before OpenAI is called. An error rejects
+	// the request; see HookError.
+	BeforeCreate(ctx context.Context, req *Request) error
+	// AfterCreate runs once OpenAI has created the session, before the
+	// response is written. It should return quickly.
+	AfterCreate(ctx context.Context, req Request, session *openai.ChatSession)
+}
+
+// HookError is a BeforeCreate error that chooses the response to the
+// rejected request. Other errors answer 403 with a generic message.
+type HookError struct {
+	// Status defaults to 403 and Code to "session_rejected".
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *HookError) Error() string {
+	return e.Message
+}
+
+// beforeCreate runs every hook's BeforeCreate in order, stopping at the
+// first error.
+func (h *sessionHandler) beforeCreate(ctx context.Context, req *Request) error {
+	for _, hook := range h.hooks {
+		if err := hook.BeforeCreate(ctx, req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *sessionHandler) afterCreate(ctx context.Context, req Request, session *openai.ChatSession) {
+	for _, hook := range h.hooks {
+		hook.AfterCreate(ctx, req, session)
+	}
+}
+
+// writeHookRejection answers a request a BeforeCreate hook rejected.
+func writeHookRejection(w http.ResponseWriter, r *http.Request, err error) {
+	resp := map[string]string{"error": "session_rejected", "message": "session creation was rejected"}
+	status := http.StatusForbidden
+	var hookErr *HookError
+	if errors.As(err, &hookErr) {
+		if hookErr.Status != 0 {
+			status = hookErr.Status
+		}
+		if hookErr.Code != "" {
+			resp["error"] = hookErr.Code
+		}
+		if hookErr.Message != "" {
+			resp["message"] = hookErr.Message
+		}
+	}
+	slog.InfoContext(r.Context(), "session hook rejected request", "status", status, "error", err)
+	writeJSON(w, status, resp)
+}
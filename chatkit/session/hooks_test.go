@@ -0,0 +1,125 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openai/openai-go/v3"
+)
+
+type recordingHook struct {
+	err     error
+	before  []string
+	created []string
+}
+
+func (h *recordingHook) BeforeCreate(ctx context.Context, req *Request) error {
+	h.before = append(h.before, req.Params.User)
+	if h.err != nil {
+		return h.err
+	}
+	req.Params.User = "corp:" + req.Params.User
+	req.Params.Workflow.StateVariables = map[string]openai.ChatSessionWorkflowParamStateVariableUnion{
+		"plan": {OfString: openai.String("gold")},
+	}
+	return nil
+}
+
+func (h *recordingHook) AfterCreate(ctx context.Context, req Request, session *openai.ChatSession) {
+	h.created = append(h.created, req.Params.User+" "+session.ClientSecret)
+}
+
+func TestSessionHooksEnrichAndRecordSessions(t *testing.T) {
+	fake := &fakeSessionCreator{clientSecret: "secret"}
+	handler := newSessionHandler(fake.Create, "w", 1200, 10)
+	hook := &recordingHook{}
+	handler.hooks = []SessionHook{hook}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/chatkit/session", strings.NewReader(`{"user":"u"}`))
+	rec := httptest.NewRecorder()
+	handler.handleSession(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	if fake.params.User != "corp:u" || fake.params.Workflow.StateVariables["plan"].OfString.Value != "gold" {
+		t.Fatalf("expected the hook's params upstream, got %+v", fake.params)
+	}
+	if len(hook.created) != 1 || hook.created[0] != "corp:u secret" {
+		t.Fatalf("unexpected AfterCreate calls: %v", hook.created)
+	}
+}
+
+func TestSessionHookRejectsRequest(t *testing.T) {
+	for name, tc := range map[string]struct {
+		err    error
+		status int
+		body   string
+	}{
+		"generic":    {errors.New("billing lookup failed"), http.StatusForbidden, `"session_rejected"`},
+		"hook error": {&HookError{Status: http.StatusPaymentRequired, Code: "plan_required", Message: "upgrade your plan"}, http.StatusPaymentRequired, `"upgrade your plan"`},
+	} {
+		t.Run(name, func(t *testing.T) {
+			fake := &fakeSessionCreator{clientSecret: "secret"}
+			handler := newSessionHandler(fake.Create, "w", 1200, 10)
+			handler.quota = newQuotaTracker(1, 0, time.Hour)
+			hook := &recordingHook{err: tc.err}
+			handler.hooks = []SessionHook{hook}
+
+			req := httptest.NewRequest(http.MethodPost, "/api/chatkit/session", strings.NewReader(`{"user":"u"}`))
+			rec := httptest.NewRecorder()
+			handler.handleSession(rec, req)
+
+			if rec.Code != tc.status || !strings.Contains(rec.Body.String(), tc.body) {
+				t.Fatalf("expected %d with %s, got %d %s", tc.status, tc.body, rec.Code, rec.Body)
+			}
+			if strings.Contains(rec.Body.String(), "billing") {
+				t.Fatalf("expected a generic error not to reach the client, got %s", rec.Body)
+			}
+			if fake.called || len(hook.created) != 0 {
+				t.Fatal("expected no session to be created")
+			}
+			if _, ok := handler.quota.reserve("u"); !ok {
+				t.Fatal("expected the quota to be refunded")
+			}
+		})
+	}
+}
+
+func TestSessionHookRejectionReleasesBreakerTrial(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	fake := &fakeSessionCreator{clientSecret: "secret"}
+	handler := newSessionHandler(fake.Create, "w", 1200, 10)
+	handler.breaker = newCircuitBreaker(1, time.Minute)
+	handler.breaker.clock = ClockFunc(func() time.Time { return now })
+	handler.breaker.record(upstreamStatusError(http.StatusServiceUnavailable))
+	now = now.Add(2 * time.Minute)
+	hook := &recordingHook{err: errors.New("billing lookup failed")}
+	handler.hooks = []SessionHook{hook}
+
+	post := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/chatkit/session", strings.NewReader(`{"user":"u"}`))
+		rec := httptest.NewRecorder()
+		handler.handleSession(rec, req)
+		return rec
+	}
+
+	if rec := post(); rec.Code != http.StatusForbidden {
+		t.Fatalf("expected the hook to reject the half-open trial, got %d", rec.Code)
+	}
+	if s := handler.breaker.snapshot(); s.State != breakerHalfOpen {
+		t.Fatalf("expected the circuit to stay half-open, got %+v", s)
+	}
+	hook.err = nil
+	if rec := post(); rec.Code != http.StatusOK || !fake.called {
+		t.Fatalf("expected the next request to take the trial, got %d", rec.Code)
+	}
+	if s := handler.breaker.snapshot(); s.State != breakerClosed {
+		t.Fatalf("expected the successful trial to close the circuit, got %+v", s)
+	}
+}
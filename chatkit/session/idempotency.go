@@ -0,0 +1,238 @@
+package session
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"openai-chatkit-backend/chatkit/redis"
+)
+
+const (
+	idempotencyKeyHeader     = "Idempotency-Key"
+	idempotentReplayedHeader = "Idempotent-Replayed"
+	defaultIdempotencyTTL    = 5 * time.Minute
+	// idempotencyPendingTTL bounds how long a key stays in flight when its
+	// request never finishes, such as when the replica dies mid-call.
+	idempotencyPendingTTL = 2 * openaiRequestTimeout
+)
+
+// idempotencyKeyPattern accepts UUIDs and similar opaque keys.
+var idempotencyKeyPattern = regexp.MustCompile(`^[!-~]{1,255}$`)
+
+// idempotencyKey returns the Idempotency-Key of the request, or a problem
+// when the header is present but malformed.
+func idempotencyKey(header http.Header) (string, []fieldError) {
+	key := header.Get(idempotencyKeyHeader)
+	if key == "" {
+		return "", nil
+	}
+	if !idempotencyKeyPattern.MatchString(key) {
+		return "", []fieldError{{
+			Field:   idempotencyKeyHeader,
+			Code:    validationCodeInvalidValue,
+			Message: idempotencyKeyHeader + " must be 1 to 255 printable ASCII characters without spaces",
+		}}
+	}
+	return key, nil
+}
+
+// idempotentSession is what a completed session request stores under its
+// idempotency key, so that a retry gets the same session back.
+type idempotentSession struct {
+	// RequestHash tells a retry from a different request reusing the key.
+	RequestHash         string            `json:"request_hash"`
+	ClientSecret        string            `json:"client_secret"`
+	ExpiresAfterSeconds int64             `json:"expires_after_seconds"`
+	Warnings            []responseWarning `json:"warnings,omitempty"`
+	Session             SessionInfo       `json:"session"`
+}
+
+// idempotencyStoreKey scopes key to the caller, so that two users, tenants,
+// or profiles sending the same key never see each other's sessions.
+func idempotencyStoreKey(scope []string, key string) string {
+	sum := sha256.Sum256([]byte(strings.Join(append(scope, key), "\x00")))
+	return "session:" + hex.EncodeToString(sum[:])
+}
+
+// idempotencyRequestHash fingerprints the decoded request body.
+func idempotencyRequestHash(payload sessionRequest) string {
+	data, _ := json.Marshal(payload)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// idempotentSessionTTL is how long to keep a completed session: the configured TTL,
+// but never past the session's own expiry.
+func idempotentSessionTTL(ttl time.Duration, now time.Time, expiresAt int64) time.Duration {
+	if expiresAt > 0 {
+		if left := time.Unix(expiresAt, 0).Sub(now); left < ttl {
+			return left
+		}
+	}
+	return ttl
+}
+
+func writeIdempotencyConflict(w http.ResponseWriter, status int, message string) {
+	if status == http.StatusConflict {
+		w.Header().Set("Retry-After", "1")
+	}
+	writeJSON(w, status, map[string]string{"error": "idempotency_conflict", "message": fmt.Sprintf("%s: %s", idempotencyKeyHeader, message)})
+}
+
+// idempotencyState is the state of a key in an idempotencyStore.
+type idempotencyState int
+
+const (
+	// idempotencyReserved means the caller now owns the key and must call
+	// Complete or Abandon.
+	idempotencyReserved idempotencyState = iota
+	// idempotencyInFlight means another request, possibly on another
+	// replica, owns the key and has not finished.
+	idempotencyInFlight
+	// idempotencyCompleted means the key already has a stored result.
+	idempotencyCompleted
+)
+
+// idempotencyStore records the outcome of requests by idempotency or dedup
+// key so that a retry returns the original result instead of repeating the
+// work. Behind a round-robin load balancer retries land on different
+// replicas, so production deployments should back it with shared
+// infrastructure (Redis SET NX with expiry, or a Postgres table with a
+// unique key) just like jobs.Locker.
+type idempotencyStore interface {
+	// Reserve claims key for ttl. When the key is already completed it
+	// returns the stored result.
+	Reserve(ctx context.Context, key string, ttl time.Duration) (idempotencyState, []byte, error)
+	// Complete stores result for a reserved key and keeps it for ttl.
+	Complete(ctx context.Context, key string, result []byte, ttl time.Duration) error
+	// Abandon releases a reserved key without a result so that a retry can
+	// try again.
+	Abandon(ctx context.Context, key string) error
+}
+
+type idempotencyRecord struct {
+	result    []byte
+	completed bool
+	expires   time.Time
+}
+
+// localIdempotencyStore is the single-replica idempotencyStore. Keys are
+// only deduplicated within this process.
+type localIdempotencyStore struct {
+	clock Clock
+
+	mu      sync.Mutex
+	records map[string]idempotencyRecord
+}
+
+func newLocalIdempotencyStore() *localIdempotencyStore {
+	return &localIdempotencyStore{clock: SystemClock, records: make(map[string]idempotencyRecord)}
+}
+
+func (s *localIdempotencyStore) Reserve(ctx context.Context, key string, ttl time.Duration) (idempotencyState, []byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.clock.Now()
+	s.pruneLocked(now)
+	if rec, ok := s.records[key]; ok {
+		if rec.completed {
+			return idempotencyCompleted, rec.result, nil
+		}
+		return idempotencyInFlight, nil, nil
+	}
+	s.records[key] = idempotencyRecord{expires: now.Add(ttl)}
+	return idempotencyReserved, nil, nil
+}
+
+func (s *localIdempotencyStore) Complete(ctx context.Context, key string, result []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records[key] = idempotencyRecord{result: result, completed: true, expires: s.clock.Now().Add(ttl)}
+	return nil
+}
+
+func (s *localIdempotencyStore) Abandon(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if rec, ok := s.records[key]; ok && !rec.completed {
+		delete(s.records, key)
+	}
+	return nil
+}
+
+func (s *localIdempotencyStore) pruneLocked(now time.Time) {
+	for key, rec := range s.records {
+		if !now.Before(rec.expires) {
+			delete(s.records, key)
+		}
+	}
+}
+
+// redisIdempotencyPending marks a reserved key that has no result yet.
+// Stored results are prefixed with redisIdempotencyResult, so no result can
+// be mistaken for it.
+const (
+	redisIdempotencyPending = "p"
+	redisIdempotencyResult  = "r"
+)
+
+// redisIdempotencyAbandon deletes KEYS[1] if it is still pending.
+const redisIdempotencyAbandon = `
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`
+
+// redisIdempotencyStore is the multi-replica idempotencyStore, so that a
+// retry landing on another replica still finds the original result.
+type redisIdempotencyStore struct {
+	client *redis.Client
+}
+
+func newRedisIdempotencyStore(client *redis.Client) *redisIdempotencyStore {
+	return &redisIdempotencyStore{client: client}
+}
+
+func (s *redisIdempotencyStore) Reserve(ctx context.Context, key string, ttl time.Duration) (idempotencyState, []byte, error) {
+	k := s.client.Key("idempotency", key)
+	ms := strconv.FormatInt(max(ttl.Milliseconds(), 1), 10)
+	reply, err := s.client.Do(ctx, "SET", k, redisIdempotencyPending, "NX", "PX", ms)
+	if err != nil {
+		return 0, nil, err
+	}
+	if reply != nil {
+		return idempotencyReserved, nil, nil
+	}
+	reply, err = s.client.Do(ctx, "GET", k)
+	if err != nil {
+		return 0, nil, err
+	}
+	value, _ := reply.(string)
+	if result, ok := strings.CutPrefix(value, redisIdempotencyResult); ok {
+		return idempotencyCompleted, []byte(result), nil
+	}
+	// The key is pending or expired between the two commands; either way
+	// the caller must not start the work again right now.
+	return idempotencyInFlight, nil, nil
+}
+
+func (s *redisIdempotencyStore) Complete(ctx context.Context, key string, result []byte, ttl time.Duration) error {
+	ms := strconv.FormatInt(max(ttl.Milliseconds(), 1), 10)
+	_, err := s.client.Do(ctx, "SET", s.client.Key("idempotency", key), redisIdempotencyResult+string(result), "PX", ms)
+	return err
+}
+
+func (s *redisIdempotencyStore) Abandon(ctx context.Context, key string) error {
+	_, err := s.client.Eval(ctx, redisIdempotencyAbandon, []string{s.client.Key("idempotency", key)}, redisIdempotencyPending)
+	return err
+}
@@ -0,0 +1,37 @@
+package session
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLocalIdempotencyStore(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	s := newLocalIdempotencyStore()
+	s.clock = ClockFunc(func() time.Time { return now })
+
+	if state, _, _ := s.Reserve(ctx, "k", time.Minute); state != idempotencyReserved {
+		t.Fatalf("expected first reserve to succeed, got %v", state)
+	}
+	if state, _, _ := s.Reserve(ctx, "k", time.Minute); state != idempotencyInFlight {
+		t.Fatalf("expected concurrent reserve to see in-flight, got %v", state)
+	}
+	if err := s.Complete(ctx, "k", []byte("result"), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	state, result, _ := s.Reserve(ctx, "k", time.Minute)
+	if state != idempotencyCompleted || string(result) != "result" {
+		t.Fatalf("expected completed result, got %v %q", state, result)
+	}
+
+	now = now.Add(2 * time.Hour)
+	if state, _, _ := s.Reserve(ctx, "k", time.Minute); state != idempotencyReserved {
+		t.Fatalf("expected expired key to be reservable, got %v", state)
+	}
+	_ = s.Abandon(ctx, "k")
+	if state, _, _ := s.Reserve(ctx, "k", time.Minute); state != idempotencyReserved {
+		t.Fatalf("expected abandoned key to be reservable, got %v", state)
+	}
+}
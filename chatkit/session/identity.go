@@ -0,0 +1,28 @@
+package session
+
+import (
+	"context"
+
+	"openai-chatkit-backend/chatkit/auth"
+)
+
+// Identity is the authenticated caller of a request. It is stored in the
+// request context by the middleware chain so that session creators and other
+// hooks can read it without re-parsing tokens.
+type Identity = auth.Identity
+
+// ContextWithIdentity returns a copy of ctx carrying id.
+func ContextWithIdentity(ctx context.Context, id Identity) context.Context {
+	return auth.ContextWithIdentity(ctx, id)
+}
+
+// IdentityFromContext returns the identity stored in ctx, if any.
+func IdentityFromContext(ctx context.Context) (Identity, bool) {
+	return auth.IdentityFromContext(ctx)
+}
+
+// TenantFromContext returns the tenant of the identity stored in ctx, or ""
+// when there is none.
+func TenantFromContext(ctx context.Context) string {
+	return auth.TenantFromContext(ctx)
+}
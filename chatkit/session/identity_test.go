@@ -0,0 +1,91 @@
+package session
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openai/openai-go/v3"
+
+	"openai-chatkit-backend/chatkit/auth"
+	"openai-chatkit-backend/chatkit/auth/authtest"
+)
+
+func TestHandleSessionUsesMappedIdentity(t *testing.T) {
+	fake := &fakeSessionCreator{clientSecret: "secret"}
+	handler := newSessionHandler(fake.Create, "w", 1200, 10)
+	mapper, err := auth.NewMapper("{{.tenant}}:{{.sub}}", "tenant={{.tenant}}")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	handler.identity = mapper
+
+	req := httptest.NewRequest(http.MethodPost, "/api/chatkit/session", strings.NewReader(`{}`))
+	req = req.WithContext(auth.ContextWithClaims(context.Background(), auth.Claims{"tenant": "acme", "sub": "42"}))
+	rec := httptest.NewRecorder()
+
+	handler.handleSession(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rec.Code)
+	}
+	if fake.params.User != "acme:42" {
+		t.Fatalf("expected mapped user acme:42, got %s", fake.params.User)
+	}
+	if v := fake.params.Workflow.StateVariables["tenant"].OfString; !v.Valid() || v.Value != "acme" {
+		t.Fatalf("expected tenant state variable acme")
+	}
+}
+
+func TestSessionCreatorSeesIdentity(t *testing.T) {
+	var tenant string
+	handler := newSessionHandler(func(ctx context.Context, params openai.BetaChatKitSessionNewParams) (*openai.ChatSession, error) {
+		tenant = TenantFromContext(ctx)
+		return &openai.ChatSession{ClientSecret: "secret"}, nil
+	}, "w", 1200, 10)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/chatkit/session", strings.NewReader(`{"user":"u"}`))
+	req = req.WithContext(ContextWithIdentity(context.Background(), Identity{Subject: "42", Tenant: "acme"}))
+	rec := httptest.NewRecorder()
+	handler.handleSession(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rec.Code)
+	}
+	if tenant != "acme" {
+		t.Fatalf("expected session creator to see tenant acme, got %q", tenant)
+	}
+}
+
+func TestSessionRequiresBearerTokenWhenJWTEnabled(t *testing.T) {
+	jwks := authtest.NewJWKS(t)
+	fake := &fakeSessionCreator{clientSecret: "secret"}
+	handler := newSessionHandler(fake.Create, "w", 1200, 10)
+	handler.auth = auth.NewVerifier(jwks.URL(), "", "")
+	router, err := newRouter(handler, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected router error: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/chatkit/session", strings.NewReader(`{"user":"victim"}`))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized || fake.called {
+		t.Fatalf("expected 401 without a token, got %d", rec.Code)
+	}
+
+	token := jwks.Sign(t, "RS256", "rsa1", map[string]any{"sub": "42", "exp": time.Now().Add(time.Hour).Unix()})
+	req = httptest.NewRequest(http.MethodPost, "/api/chatkit/session", strings.NewReader(`{"user":"victim"}`))
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if fake.params.User != "42" {
+		t.Fatalf("expected the user to come from the token, got %q", fake.params.User)
+	}
+}
@@ -0,0 +1,237 @@
+package session
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultJournalSize = 500
+	// journalQueueSize bounds the entries waiting to be written to the
+	// journal file. Entries beyond it are dropped rather than slowing down
+	// requests.
+	journalQueueSize = 1024
+)
+
+// journalSensitiveHeaders are never stored, whatever the redaction config.
+var journalSensitiveHeaders = map[string]bool{
+	"authorization": true,
+	"cookie":        true,
+	"x-api-key":     true,
+	"x-tenant-key":  true,
+}
+
+// JournalEntry is one sanitized request and the status it got, as written
+// to CHATKIT_JOURNAL_FILE and served by /admin/journal.
+type JournalEntry struct {
+	ID         string              `json:"id"`
+	Time       time.Time           `json:"time"`
+	Method     string              `json:"method"`
+	Path       string              `json:"path"`
+	Query      string              `json:"query,omitempty"`
+	Header     map[string][]string `json:"header,omitempty"`
+	Body       json.RawMessage     `json:"body,omitempty"`
+	Status     int                 `json:"status"`
+	DurationMS int64               `json:"duration_ms"`
+}
+
+// requestJournal keeps the most recent sanitized requests in a ring buffer
+// and optionally appends them to a JSON-lines file, so that a failing
+// request can later be replayed against a mock or staging server. File
+// writes happen in the background, in batches, so a slow disk never delays
+// requests.
+type requestJournal struct {
+	redact *redactor
+	clock  Clock
+	// paths tells session routes, the ones worth replaying, from admin and
+	// probe traffic, which is never journaled.
+	paths routePaths
+
+	mu      sync.Mutex
+	entries []JournalEntry
+	next    int
+	full    bool
+	file    *os.File
+	lines   chan []byte
+	done    chan struct{}
+	dropped int64
+}
+
+func newRequestJournal(size int, path string, redact *redactor) (*requestJournal, error) {
+	j := &requestJournal{redact: redact, clock: SystemClock, entries: make([]JournalEntry, size)}
+	if path != "" {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+		if err != nil {
+			return nil, err
+		}
+		j.file = f
+		j.lines = make(chan []byte, journalQueueSize)
+		j.done = make(chan struct{})
+		go j.writeLines(j.lines)
+	}
+	return j, nil
+}
+
+// writeLines appends queued lines to the file, flushing whenever the queue
+// runs empty.
+func (j *requestJournal) writeLines(lines <-chan []byte) {
+	defer close(j.done)
+	w := bufio.NewWriter(j.file)
+	for line := range lines {
+		_, err := w.Write(line)
+	drain:
+		for err == nil {
+			select {
+			case line, ok := <-lines:
+				if !ok {
+					break drain
+				}
+				_, err = w.Write(line)
+			default:
+				break drain
+			}
+		}
+		if err == nil {
+			err = w.Flush()
+		}
+		if err != nil {
+			slog.Error("failed to write request journal", "error", err)
+			w.Reset(j.file)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		slog.Error("failed to write request journal", "error", err)
+	}
+}
+
+// add stores e, overwriting the oldest entry once the buffer is full.
+func (j *requestJournal) add(e JournalEntry) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if len(j.entries) > 0 {
+		j.entries[j.next] = e
+		j.next = (j.next + 1) % len(j.entries)
+		if j.next == 0 {
+			j.full = true
+		}
+	}
+	if j.lines != nil {
+		line, err := json.Marshal(e)
+		if err != nil {
+			slog.Error("failed to encode request journal entry", "error", err)
+			return
+		}
+		select {
+		case j.lines <- append(line, '\n'):
+		default:
+			j.dropped++
+			if j.dropped&(j.dropped-1) == 0 {
+				slog.Warn("request journal file is falling behind, dropping entries", "dropped", j.dropped)
+			}
+		}
+	}
+}
+
+// snapshot returns the buffered entries, oldest first.
+func (j *requestJournal) snapshot() []JournalEntry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if !j.full {
+		return append([]JournalEntry(nil), j.entries[:j.next]...)
+	}
+	return append(append([]JournalEntry(nil), j.entries[j.next:]...), j.entries[:j.next]...)
+}
+
+// close writes the queued entries and closes the file. Entries added
+// afterwards are only kept in memory.
+func (j *requestJournal) close() error {
+	if j.file == nil {
+		return nil
+	}
+	j.mu.Lock()
+	lines := j.lines
+	j.lines = nil
+	j.mu.Unlock()
+	if lines != nil {
+		close(lines)
+		<-j.done
+	}
+	return j.file.Close()
+}
+
+// sanitizeHeader drops credentials and masks headers the redactor
+// considers sensitive.
+func (j *requestJournal) sanitizeHeader(h http.Header) map[string][]string {
+	out := make(map[string][]string, len(h))
+	for name, values := range h {
+		lower := strings.ToLower(name)
+		if journalSensitiveHeaders[lower] {
+			continue
+		}
+		if j.redact.redacted([]string{"header", lower}) {
+			values = []string{RedactedValue}
+		}
+		out[name] = append([]string(nil), values...)
+	}
+	return out
+}
+
+// withJournal records every session route request. Bodies that are
+// not JSON are left out, since they cannot be redacted.
+func withJournal(j *requestJournal, next http.Handler) http.Handler {
+	if j == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if j.paths.group(r.URL.Path) != routeGroupSession {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var body []byte
+		if r.Body != nil {
+			raw, err := io.ReadAll(io.LimitReader(r.Body, maxRequestBodyBytes+1))
+			if err != nil {
+				http.Error(w, "failed to read request body", http.StatusBadRequest)
+				return
+			}
+			r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(raw), r.Body))
+			if len(raw) <= maxRequestBodyBytes {
+				body, _ = j.redact.payload(raw)
+			}
+		}
+
+		var id string
+		if l := requestLogFromContext(r.Context()); l != nil {
+			id = l.id
+		} else {
+			id, _ = randomID("req_")
+		}
+		start := j.clock.Now()
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+
+		j.add(JournalEntry{
+			ID:         id,
+			Time:       start.UTC(),
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			Query:      r.URL.RawQuery,
+			Header:     j.sanitizeHeader(r.Header),
+			Body:       body,
+			Status:     sw.status,
+			DurationMS: j.clock.Now().Sub(start).Milliseconds(),
+		})
+	})
+}
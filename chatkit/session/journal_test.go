@@ -0,0 +1,107 @@
+package session
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRequestJournalRingBuffer(t *testing.T) {
+	j, err := newRequestJournal(2, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"a", "b", "c"} {
+		j.add(JournalEntry{ID: id})
+	}
+	entries := j.snapshot()
+	if len(entries) != 2 || entries[0].ID != "b" || entries[1].ID != "c" {
+		t.Fatalf("expected the two newest entries oldest first, got %+v", entries)
+	}
+}
+
+func TestWithJournalSanitizesRequests(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+	j, err := newRequestJournal(10, path, newRedactor("user,x-device-id"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.close()
+
+	var gotBody string
+	handler := withJournal(j, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		gotBody = string(body)
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/chatkit/session?debug=1", strings.NewReader(`{"user":"alice","platform":"ios"}`))
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set(apiKeyHeader, "sk_sandbox")
+	req.Header.Set(deviceIDHeader, "device-1")
+	req.Header.Set("User-Agent", "MyApp/1.0")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if gotBody != `{"user":"alice","platform":"ios"}` {
+		t.Fatalf("handler should see the original body, got %q", gotBody)
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/admin/bans", nil))
+
+	entries := j.snapshot()
+	if len(entries) != 1 {
+		t.Fatalf("expected only the API request to be journaled, got %d", len(entries))
+	}
+	e := entries[0]
+	if e.Status != http.StatusTeapot || e.Query != "debug=1" || e.ID == "" {
+		t.Fatalf("unexpected entry: %+v", e)
+	}
+	if _, ok := e.Header["Authorization"]; ok {
+		t.Fatalf("authorization header should be dropped")
+	}
+	if http.Header(e.Header).Get(apiKeyHeader) != "" {
+		t.Fatalf("API key header should be dropped")
+	}
+	if h := http.Header(e.Header); h.Get(deviceIDHeader) != RedactedValue || h.Get("User-Agent") != "MyApp/1.0" {
+		t.Fatalf("unexpected headers: %v", e.Header)
+	}
+	var body map[string]string
+	if err := json.Unmarshal(e.Body, &body); err != nil || body["user"] != RedactedValue || body["platform"] != "ios" {
+		t.Fatalf("unexpected body %s: %v", e.Body, err)
+	}
+
+	// File writes are asynchronous; close flushes them.
+	if err := j.close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fromFile JournalEntry
+	if err := json.Unmarshal(data, &fromFile); err != nil || fromFile.ID != e.ID {
+		t.Fatalf("expected the entry in the journal file, got %s: %v", data, err)
+	}
+}
+
+func TestWithJournalRedactsResumeTokens(t *testing.T) {
+	j, err := newRequestJournal(10, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := withJournal(j, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	req := httptest.NewRequest(http.MethodPost, refreshPath, strings.NewReader(`{"resume_token":"rt_secret"}`))
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	entries := j.snapshot()
+	if len(entries) != 1 {
+		t.Fatalf("expected the refresh request to be journaled, got %d entries", len(entries))
+	}
+	if strings.Contains(string(entries[0].Body), "rt_secret") {
+		t.Fatalf("expected the resume token to be redacted, got %s", entries[0].Body)
+	}
+}
@@ -0,0 +1,93 @@
+package session
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// lifecycleSchemaVersion is bumped whenever a field of lifecycleEvent changes
+// meaning or is removed. Adding fields does not bump it.
+const lifecycleSchemaVersion = 1
+
+const (
+	lifecycleEventStarted       = "server.started"
+	lifecycleEventStartFailed   = "server.start_failed"
+	lifecycleEventStopping      = "server.stopping"
+	lifecycleEventStopped       = "server.stopped"
+	lifecycleEventConfigChanged = "config.changed"
+)
+
+// lifecycleEvent is one JSON line on stdout. Log pipelines can alert on
+// restarts and configuration changes by matching the event field instead of
+// parsing free-form log messages.
+type lifecycleEvent struct {
+	SchemaVersion     int    `json:"schema_version"`
+	Event             string `json:"event"`
+	Time              string `json:"time"`
+	PID               int    `json:"pid"`
+	Host              string `json:"host,omitempty"`
+	Addr              string `json:"addr,omitempty"`
+	Region            string `json:"region,omitempty"`
+	ConfigFingerprint string `json:"config_fingerprint,omitempty"`
+	FileFingerprint   string `json:"file_fingerprint,omitempty"`
+	Signal            string `json:"signal,omitempty"`
+	UptimeSeconds     int64  `json:"uptime_seconds,omitempty"`
+	Error             string `json:"error,omitempty"`
+}
+
+// lifecycleLogger writes lifecycleEvents as JSON lines. A nil logger drops
+// every event.
+type lifecycleLogger struct {
+	clock       Clock
+	host        string
+	addr        string
+	region      string
+	fingerprint string
+
+	mu      sync.Mutex
+	w       io.Writer
+	started time.Time
+}
+
+func newLifecycleLogger(w io.Writer, addr, fingerprint string) *lifecycleLogger {
+	host, _ := os.Hostname()
+	return &lifecycleLogger{clock: SystemClock, host: host, addr: addr, fingerprint: fingerprint, w: w}
+}
+
+// emit fills in the common fields of e and writes it.
+func (l *lifecycleLogger) emit(e lifecycleEvent) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock.Now()
+	if e.Event == lifecycleEventStarted {
+		l.started = now
+	} else if !l.started.IsZero() {
+		e.UptimeSeconds = int64(now.Sub(l.started).Seconds())
+	}
+	e.SchemaVersion = lifecycleSchemaVersion
+	e.Time = now.UTC().Format(time.RFC3339Nano)
+	e.PID = os.Getpid()
+	e.Host = l.host
+	e.Addr = l.addr
+	e.Region = l.region
+	if e.ConfigFingerprint == "" {
+		e.ConfigFingerprint = l.fingerprint
+	}
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		slog.Error("failed to encode lifecycle event", "event", e.Event, "error", err)
+		return
+	}
+	if _, err := l.w.Write(append(line, '\n')); err != nil {
+		slog.Error("failed to write lifecycle event", "event", e.Event, "error", err)
+	}
+}
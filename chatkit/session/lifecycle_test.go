@@ -0,0 +1,74 @@
+package session
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func decodeLifecycleEvents(t *testing.T, out string) []lifecycleEvent {
+	t.Helper()
+	var events []lifecycleEvent
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		var e lifecycleEvent
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("invalid lifecycle event %q: %v", line, err)
+		}
+		events = append(events, e)
+	}
+	return events
+}
+
+func TestLifecycleLoggerEmitsJSONLines(t *testing.T) {
+	var out bytes.Buffer
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	l := newLifecycleLogger(&out, ":8000", "sha256:abc")
+	l.clock = ClockFunc(func() time.Time { return now })
+
+	l.emit(lifecycleEvent{Event: lifecycleEventStarted})
+	now = now.Add(90 * time.Second)
+	l.emit(lifecycleEvent{Event: lifecycleEventStopping, Signal: "terminated"})
+
+	events := decodeLifecycleEvents(t, out.String())
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(events))
+	}
+	started, stopping := events[0], events[1]
+	if started.SchemaVersion != lifecycleSchemaVersion || started.Event != lifecycleEventStarted || started.Addr != ":8000" || started.ConfigFingerprint != "sha256:abc" || started.PID == 0 {
+		t.Fatalf("unexpected started event: %+v", started)
+	}
+	if stopping.Signal != "terminated" || stopping.UptimeSeconds != 90 || stopping.Time != "2025-03-01T12:01:30Z" {
+		t.Fatalf("unexpected stopping event: %+v", stopping)
+	}
+}
+
+func TestLifecycleLoggerNilIsNoop(t *testing.T) {
+	var l *lifecycleLogger
+	l.emit(lifecycleEvent{Event: lifecycleEventStarted})
+}
+
+func TestConfigDriftEmitsConfigChanged(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.env")
+	if err := os.WriteFile(path, []byte("CHATKIT_WORKFLOW_ID=w2\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	d := newConfigDriftDetector([]string{"CHATKIT_WORKFLOW_ID=w"}, path)
+	d.events = newLifecycleLogger(&out, "", d.fingerprint)
+
+	if err := d.check(); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.check(); err != nil {
+		t.Fatal(err)
+	}
+
+	events := decodeLifecycleEvents(t, out.String())
+	if len(events) != 1 || events[0].Event != lifecycleEventConfigChanged || events[0].FileFingerprint == "" || events[0].FileFingerprint == events[0].ConfigFingerprint {
+		t.Fatalf("expected a single config.changed event, got %+v", events)
+	}
+}
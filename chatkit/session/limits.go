@@ -0,0 +1,138 @@
+package session
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	limitsPath = "/v1/chatkit/limits"
+	// limitsRefreshInterval is the longest clients are told to keep their
+	// limits before asking again.
+	limitsRefreshInterval = time.Minute
+)
+
+// rateLimitHint describes one of the caller's rate limits.
+type rateLimitHint struct {
+	PerMinute         int64 `json:"per_minute"`
+	Burst             int64 `json:"burst"`
+	Remaining         int64 `json:"remaining"`
+	RetryAfterSeconds int64 `json:"retry_after_seconds,omitempty"`
+}
+
+type quotaHint struct {
+	Limit     int64     `json:"limit"`
+	Used      int64     `json:"used"`
+	Remaining int64     `json:"remaining"`
+	ResetAt   time.Time `json:"reset_at"`
+}
+
+type activeSessionsHint struct {
+	Limit  int `json:"limit"`
+	Active int `json:"active"`
+}
+
+// limitsResponse is the body of GET /v1/chatkit/limits. Limits that are not
+// configured are omitted.
+type limitsResponse struct {
+	User    string `json:"user"`
+	Profile string `json:"profile"`
+	Tenant  string `json:"tenant,omitempty"`
+	// SessionRateLimitPerMinute is the per-session limit OpenAI enforces on
+	// sessions minted for the caller.
+	SessionRateLimitPerMinute int64               `json:"session_rate_limit_per_minute"`
+	IPRateLimit               *rateLimitHint      `json:"ip_rate_limit,omitempty"`
+	UserRateLimit             *rateLimitHint      `json:"user_rate_limit,omitempty"`
+	Quota                     *quotaHint          `json:"quota,omitempty"`
+	ActiveSessions            *activeSessionsHint `json:"active_sessions,omitempty"`
+	// RefreshAfterSeconds is how long the answer stays accurate enough to
+	// rely on: a minute, or sooner when a spent limit recovers before that.
+	RefreshAfterSeconds int64 `json:"refresh_after_seconds"`
+}
+
+// handleLimits reports the caller's effective session limits without
+// spending any of them, so well-behaved clients can pace themselves instead
+// of discovering limits through 429s. The user is resolved as for session
+// creation, with tenant-authenticated callers naming it in the user query
+// parameter. Callers without credentials get 401.
+func (h *sessionHandler) handleLimits(w http.ResponseWriter, r *http.Request) {
+	if !authenticatedCaller(r) {
+		writeUnauthenticated(w)
+		return
+	}
+	user, _, err := h.resolveUser(r.Context(), r.URL.Query().Get("user"))
+	if err != nil {
+		slog.WarnContext(r.Context(), "identity mapping failed", "error", err)
+		http.Error(w, "identity mapping failed", http.StatusForbidden)
+		return
+	}
+	if user == "" {
+		writeValidationErrors(w, []fieldError{{Field: "user", Code: validationCodeRequired, Message: "user is required"}})
+		return
+	}
+
+	platform, _ := resolveClientPlatform(sessionRequest{}, r.Header.Get("User-Agent"))
+	settings := h.settingsFor(r, platform)
+	resp := limitsResponse{
+		User:                      user,
+		Profile:                   settings.profile,
+		Tenant:                    settings.tenant,
+		SessionRateLimitPerMinute: settings.rateLimitPerMinute,
+	}
+	refresh := limitsRefreshInterval
+	soonest := func(d time.Duration) {
+		if d > 0 && d < refresh {
+			refresh = d
+		}
+	}
+	if h.ipLimit != nil {
+		resp.IPRateLimit = h.ipLimit.hint(clientIP(r))
+		soonest(time.Duration(resp.IPRateLimit.RetryAfterSeconds) * time.Second)
+	}
+	if h.userLimit != nil {
+		resp.UserRateLimit = h.userLimit.hint(user)
+		soonest(time.Duration(resp.UserRateLimit.RetryAfterSeconds) * time.Second)
+	}
+	if settings.quota != nil {
+		status := settings.quota.peek(user)
+		resp.Quota = &quotaHint{Limit: status.limit, Used: status.used, Remaining: status.remaining(), ResetAt: status.resetAt.UTC()}
+		if status.remaining() == 0 {
+			soonest(status.resetAt.Sub(h.clock.Now()))
+		}
+	}
+	if settings.activeSessions != nil {
+		resp.ActiveSessions = &activeSessionsHint{Limit: settings.activeSessions.limit, Active: settings.activeSessions.peek(user)}
+	}
+
+	resp.RefreshAfterSeconds = ceilSeconds(refresh)
+	w.Header().Set("Cache-Control", "private, max-age="+strconv.FormatInt(resp.RefreshAfterSeconds, 10))
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// authenticatedCaller reports whether r carries credentials the server
+// checked: a verified bearer token or a tenant key. Endpoints that answer
+// for the user named in the request serve only such callers, or anyone
+// could look up anyone.
+func authenticatedCaller(r *http.Request) bool {
+	if id, ok := IdentityFromContext(r.Context()); ok && id.Subject != "" {
+		return true
+	}
+	return tenantFromContext(r.Context()) != nil
+}
+
+func writeUnauthenticated(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	http.Error(w, "authentication required", http.StatusUnauthorized)
+}
+
+// hint describes key's bucket without spending a token.
+func (l *rateLimiter) hint(key string) *rateLimitHint {
+	remaining, wait := l.peek(key)
+	h := &rateLimitHint{PerMinute: int64(l.refill*60 + 0.5), Burst: int64(l.burst), Remaining: remaining}
+	if wait > 0 {
+		h.RetryAfterSeconds = ceilSeconds(wait)
+	}
+	return h
+}
@@ -0,0 +1,89 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandleLimits(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	clock := ClockFunc(func() time.Time { return now })
+	fake := &fakeSessionCreator{clientSecret: "secret"}
+	handler := newSessionHandler(fake.Create, "w", 1200, 10)
+	handler.clock = clock
+	handler.userLimit = newRateLimiter(6, 2)
+	handler.userLimit.clock = clock
+	handler.quota = newQuotaTracker(2, 80, time.Hour)
+	handler.quota.clock = clock
+
+	limits := func() limitsResponse {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, limitsPath, nil)
+		handler.handleLimits(rec, req.WithContext(ContextWithIdentity(req.Context(), Identity{Subject: "u"})))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		var resp limitsResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if want := "private, max-age=" + strconv.FormatInt(resp.RefreshAfterSeconds, 10); rec.Header().Get("Cache-Control") != want {
+			t.Fatalf("expected Cache-Control %q, got %q", want, rec.Header().Get("Cache-Control"))
+		}
+		return resp
+	}
+
+	resp := limits()
+	if resp.User != "u" || resp.Profile != "default" || resp.SessionRateLimitPerMinute != 10 || resp.RefreshAfterSeconds != 60 {
+		t.Fatalf("unexpected response: %+v", resp)
+	}
+	if resp.UserRateLimit == nil || *resp.UserRateLimit != (rateLimitHint{PerMinute: 6, Burst: 2, Remaining: 2}) {
+		t.Fatalf("unexpected user rate limit: %+v", resp.UserRateLimit)
+	}
+	if resp.Quota == nil || resp.Quota.Remaining != 2 || resp.IPRateLimit != nil || resp.ActiveSessions != nil {
+		t.Fatalf("unexpected limits: %+v", resp)
+	}
+
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		handler.handleSession(rec, httptest.NewRequest(http.MethodPost, defaultSessionPath, strings.NewReader(`{"user":"u"}`)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", rec.Code)
+		}
+	}
+	resp = limits()
+	if resp.UserRateLimit.Remaining != 0 || resp.UserRateLimit.RetryAfterSeconds != 10 || resp.Quota.Remaining != 0 || resp.Quota.Used != 2 {
+		t.Fatalf("expected spent limits, got %+v %+v", resp.UserRateLimit, resp.Quota)
+	}
+	if resp.RefreshAfterSeconds != 10 {
+		t.Fatalf("expected to refresh when the rate limit recovers, got %d", resp.RefreshAfterSeconds)
+	}
+	if again := limits(); again.UserRateLimit.Remaining != 0 || again.Quota.Used != 2 {
+		t.Fatalf("expected reading limits not to spend them, got %+v", again)
+	}
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, limitsPath, nil)
+	handler.handleLimits(rec, req.WithContext(context.WithValue(req.Context(), tenantContextKey{}, &tenant{name: "acme"})))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected a missing user to be rejected, got %d", rec.Code)
+	}
+}
+
+func TestHandleLimitsRequiresCredentials(t *testing.T) {
+	handler := newSessionHandler((&fakeSessionCreator{}).Create, "w", 1200, 10)
+	handler.quota = newQuotaTracker(2, 80, time.Hour)
+
+	rec := httptest.NewRecorder()
+	handler.handleLimits(rec, httptest.NewRequest(http.MethodGet, limitsPath+"?user=victim", nil))
+	if rec.Code != http.StatusUnauthorized || strings.Contains(rec.Body.String(), "victim") {
+		t.Fatalf("expected 401 without credentials, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,174 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// probePaths are logged at debug level so that health checks do not drown
+// out real traffic.
+var probePaths = map[string]bool{"/healthz": true, "/livez": true, "/readyz": true}
+
+// newLogger builds the process logger. format is "text" or "json"; level is
+// one of debug, info, warn, or error.
+func newLogger(w io.Writer, format, level string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q: expected debug, info, warn, or error", level)
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+	var h slog.Handler
+	switch strings.ToLower(format) {
+	case logFormatText:
+		h = slog.NewTextHandler(w, opts)
+	case logFormatJSON:
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("invalid log format %q: expected text or json", format)
+	}
+	return slog.New(requestContextHandler{h}), nil
+}
+
+// rawJSON logs pre-encoded JSON as a nested value in JSON output and as a
+// string in text output.
+type rawJSON []byte
+
+func (j rawJSON) MarshalJSON() ([]byte, error) { return j, nil }
+
+func (j rawJSON) MarshalText() ([]byte, error) { return j, nil }
+
+// requestLog collects the per-request fields that the access log line
+// reports. Handlers add fields such as the user once they know them.
+type requestLog struct {
+	id string
+
+	mu    sync.Mutex
+	attrs []slog.Attr
+}
+
+type requestLogKey struct{}
+
+func requestLogFromContext(ctx context.Context) *requestLog {
+	l, _ := ctx.Value(requestLogKey{}).(*requestLog)
+	return l
+}
+
+// addLogFields attaches attrs to the access log line of the request in ctx.
+func addLogFields(ctx context.Context, attrs ...slog.Attr) {
+	l := requestLogFromContext(ctx)
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.attrs = append(l.attrs, attrs...)
+}
+
+// setLogFields is addLogFields for fields that may be set more than once,
+// such as by each attempt of a retried call: attrs replace earlier fields
+// with the same key.
+func setLogFields(ctx context.Context, attrs ...slog.Attr) {
+	l := requestLogFromContext(ctx)
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, a := range attrs {
+		l.attrs = slices.DeleteFunc(l.attrs, func(b slog.Attr) bool { return b.Key == a.Key })
+		l.attrs = append(l.attrs, a)
+	}
+}
+
+// requestContextHandler adds the request ID to every record logged with a
+// request context.
+type requestContextHandler struct {
+	slog.Handler
+}
+
+func (h requestContextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if l := requestLogFromContext(ctx); l != nil {
+		r.AddAttrs(slog.String("request_id", l.id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h requestContextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestContextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestContextHandler) WithGroup(name string) slog.Handler {
+	return requestContextHandler{h.Handler.WithGroup(name)}
+}
+
+// statusWriter records the status code written through it.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, so
+// streaming handlers can flush through it.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// withAccessLog logs one line per request with its method, path, status,
+// latency, and any fields handlers added through addLogFields.
+func withAccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		l := requestLogFromContext(r.Context())
+		if l == nil {
+			id, _ := randomID("req_")
+			l = &requestLog{id: id}
+			r = r.WithContext(context.WithValue(r.Context(), requestLogKey{}, l))
+		}
+
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+
+		level := slog.LevelInfo
+		if probePaths[r.URL.Path] {
+			level = slog.LevelDebug
+		}
+		l.mu.Lock()
+		attrs := append([]slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", sw.status),
+			slog.Int64("latency_ms", time.Since(start).Milliseconds()),
+		}, l.attrs...)
+		l.mu.Unlock()
+		slog.LogAttrs(r.Context(), level, "request", attrs...)
+	})
+}
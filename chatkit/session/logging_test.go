@@ -0,0 +1,62 @@
+package session
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNewLoggerRejectsInvalidSettings(t *testing.T) {
+	if _, err := newLogger(io.Discard, "xml", "info"); err == nil {
+		t.Fatal("expected an error for an unknown format")
+	}
+	if _, err := newLogger(io.Discard, "json", "loud"); err == nil {
+		t.Fatal("expected an error for an unknown level")
+	}
+}
+
+func TestAccessLogIncludesRequestFields(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := newLogger(&buf, "json", "info")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	previous := slog.Default()
+	slog.SetDefault(logger)
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	handler := withAccessLog(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" {
+			return
+		}
+		addLogFields(r.Context(), slog.String("user", "u1"))
+		slog.InfoContext(r.Context(), "handling")
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/chatkit/session", nil))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	var lines []map[string]any
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var line map[string]any
+		if err := dec.Decode(&line); err != nil {
+			t.Fatalf("invalid log line: %v", err)
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines (probe logged at debug), got %d: %v", len(lines), lines)
+	}
+	handling, access := lines[0], lines[1]
+	if access["msg"] != "request" || access["status"] != float64(http.StatusTeapot) || access["user"] != "u1" || access["path"] != "/api/chatkit/session" {
+		t.Fatalf("unexpected access log line: %v", access)
+	}
+	if id, _ := access["request_id"].(string); id == "" || handling["request_id"] != id {
+		t.Fatalf("expected both lines to share a request ID, got %v and %v", handling, access)
+	}
+}
@@ -0,0 +1,69 @@
+package session
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"openai-chatkit-backend/chatkit/clock"
+)
+
+const (
+	defaultLookupRateLimitPerMinute = 60
+	defaultLookupMinLatency         = 250 * time.Millisecond
+)
+
+// lookupGuard protects endpoints that answer questions about a given user
+// against user-ID enumeration. Each caller may make a limited number of
+// lookups per route, and every answer takes at least minLatency, so that
+// response timing does not reveal whether the user has any state on the
+// server. Handlers behind the guard must also answer known and unknown users
+// with the same status and shape.
+type lookupGuard struct {
+	limit      *rateLimiter
+	minLatency time.Duration
+	clock      Clock
+	// sleep waits out the latency pad; it returns early when the request is
+	// cancelled, so abandoned lookups do not hold goroutines.
+	sleep func(context.Context, time.Duration) bool
+}
+
+func newLookupGuard(perMinute int64, minLatency time.Duration) *lookupGuard {
+	return &lookupGuard{
+		limit:      newRateLimiter(perMinute, perMinute),
+		minLatency: minLatency,
+		clock:      SystemClock,
+		sleep:      clock.Sleep,
+	}
+}
+
+func (g *lookupGuard) guard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := g.clock.Now()
+		defer func() {
+			if wait := g.minLatency - g.clock.Now().Sub(start); wait > 0 {
+				g.sleep(r.Context(), wait)
+			}
+		}()
+		if g.limit != nil {
+			// Each route has its own budget, so that heavy use of one lookup
+			// does not lock the caller out of the others.
+			if ok, retryAfter := g.limit.allow(r.URL.Path + " " + lookupCaller(r)); !ok {
+				writeRateLimited(w, retryAfter)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// lookupCaller names the budget a lookup is charged to: the verified
+// subject when there is one, so that callers behind one NAT or proxy do not
+// share a budget and a caller cannot gain budget by switching addresses.
+// Anonymous callers, such as admin token holders, are counted by IP.
+func lookupCaller(r *http.Request) string {
+	if id, ok := IdentityFromContext(r.Context()); ok && id.Subject != "" {
+		return "sub:" + id.Tenant + ":" + id.Subject
+	}
+	return "ip:" + clientIP(r)
+}
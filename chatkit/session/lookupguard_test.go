@@ -0,0 +1,172 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLookupGuardPadsLatencyAndLimitsCallers(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	g := newLookupGuard(2, 250*time.Millisecond)
+	g.clock = ClockFunc(func() time.Time { return now })
+	g.limit.clock = g.clock
+	var slept []time.Duration
+	g.sleep = func(_ context.Context, d time.Duration) bool {
+		slept = append(slept, d)
+		return true
+	}
+
+	handler := g.guard(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		now = now.Add(40 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	}))
+	send := func(ip string) int {
+		req := httptest.NewRequest(http.MethodGet, "/admin/policy?user=u", nil)
+		req.RemoteAddr = ip + ":1234"
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := send("198.51.100.1"); code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", code)
+	}
+	if len(slept) != 1 || slept[0] != 210*time.Millisecond {
+		t.Fatalf("expected the response to be padded to 250ms, slept %v", slept)
+	}
+	send("198.51.100.1")
+	if code := send("198.51.100.1"); code != http.StatusTooManyRequests {
+		t.Fatalf("expected the third lookup to be rate limited, got %d", code)
+	}
+	if slept[2] != 250*time.Millisecond {
+		t.Fatalf("expected rate limited responses to be padded too, slept %v", slept)
+	}
+	if code := send("198.51.100.2"); code != http.StatusOK {
+		t.Fatalf("expected other callers to be unaffected, got %d", code)
+	}
+}
+
+func TestLookupGuardBudgetsBySubjectAndRoute(t *testing.T) {
+	g := newLookupGuard(1, 0)
+	handler := g.guard(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	send := func(path, subject, ip string) int {
+		req := httptest.NewRequest(http.MethodGet, path+"?user=u", nil)
+		req.RemoteAddr = ip + ":1234"
+		if subject != "" {
+			req = req.WithContext(ContextWithIdentity(req.Context(), Identity{Subject: subject}))
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if send(limitsPath, "alice", "203.0.113.1") != http.StatusOK || send(limitsPath, "bob", "203.0.113.1") != http.StatusOK {
+		t.Fatalf("expected subjects behind one address to have their own budgets")
+	}
+	if code := send(limitsPath, "alice", "203.0.113.2"); code != http.StatusTooManyRequests {
+		t.Fatalf("expected a subject's budget to follow it across addresses, got %d", code)
+	}
+	if code := send(prefsPath, "alice", "203.0.113.1"); code != http.StatusOK {
+		t.Fatalf("expected each route to have its own budget, got %d", code)
+	}
+}
+
+func TestLookupGuardStopsPaddingCancelledRequests(t *testing.T) {
+	g := newLookupGuard(10, time.Hour)
+	handler := g.guard(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	done := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, limitsPath, nil).WithContext(ctx))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected a cancelled request to skip the latency pad")
+	}
+}
+
+func TestUserLookupsAnswerKnownAndUnknownUsersAlike(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	clock := ClockFunc(func() time.Time { return now })
+	fake := &fakeSessionCreator{clientSecret: "secret"}
+	handler := newSessionHandler(fake.Create, "w", 1200, 10)
+	handler.clock = clock
+	handler.tenants = &tenantRegistry{tenants: []*tenant{{name: "acme", key: []byte("acme-key"), workflowID: "w", createSession: fake.Create}}}
+	handler.quota = newQuotaTracker(5, 80, time.Hour)
+	handler.quota.clock = clock
+	handler.prefs, _ = newLocalPrefsStore("")
+	handler.lookups = newLookupGuard(100, 250*time.Millisecond)
+	handler.lookups.clock = clock
+	handler.lookups.limit.clock = clock
+	var slept []time.Duration
+	handler.lookups.sleep = func(_ context.Context, d time.Duration) bool {
+		slept = append(slept, d)
+		return true
+	}
+	router, err := newRouter(handler, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected router error: %v", err)
+	}
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set(tenantKeyHeader, "acme-key")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	if rec := send(http.MethodPost, defaultSessionPath, `{"user":"known"}`); rec.Code != http.StatusOK {
+		t.Fatalf("expected a session for the known user, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	for _, path := range []string{limitsPath, prefsPath} {
+		slept = nil
+		known := send(http.MethodGet, path+"?user=known", "")
+		unknown := send(http.MethodGet, path+"?user=unknown", "")
+		if known.Code != http.StatusOK || unknown.Code != known.Code {
+			t.Fatalf("%s: expected status 200 for both users, got %d and %d", path, known.Code, unknown.Code)
+		}
+		if !reflect.DeepEqual(known.Header(), unknown.Header()) {
+			t.Fatalf("%s: headers differ: %v and %v", path, known.Header(), unknown.Header())
+		}
+		if k, u := jsonShape(t, known.Body.Bytes()), jsonShape(t, unknown.Body.Bytes()); !reflect.DeepEqual(k, u) {
+			t.Fatalf("%s: bodies differ in shape: %v and %v", path, k, u)
+		}
+		if len(slept) != 2 || slept[0] != 250*time.Millisecond || slept[1] != slept[0] {
+			t.Fatalf("%s: expected both answers to be padded alike, slept %v", path, slept)
+		}
+	}
+}
+
+// jsonShape replaces every value in a JSON document with its type, keeping
+// object keys, so that documents can be compared by structure alone.
+func jsonShape(t *testing.T, data []byte) any {
+	t.Helper()
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		t.Fatalf("invalid JSON %s: %v", data, err)
+	}
+	var shape func(v any) any
+	shape = func(v any) any {
+		if m, ok := v.(map[string]any); ok {
+			out := make(map[string]any, len(m))
+			for k, e := range m {
+				out[k] = shape(e)
+			}
+			return out
+		}
+		return fmt.Sprintf("%T", v)
+	}
+	return shape(v)
+}
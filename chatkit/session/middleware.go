@@ -0,0 +1,31 @@
+package session
+
+import (
+	"net/http"
+
+	"openai-chatkit-backend/chatkit/auth"
+)
+
+// AccessLog logs one line per request with its method, path, status, and
+// latency, like the server's metrics stage.
+func AccessLog(next http.Handler) http.Handler {
+	return withAccessLog(next)
+}
+
+// RequireJWT rejects requests without a bearer token signed by a key from
+// jwksURL, like the server's auth stage. Empty issuer or audience skip that
+// check. The verified claims become the request's Identity.
+func RequireJWT(jwksURL, issuer, audience string) func(http.Handler) http.Handler {
+	return auth.NewVerifier(jwksURL, issuer, audience).Require
+}
+
+// RateLimitByIP answers 429 to client IPs that send more than perMinute
+// requests, allowing bursts of up to burst, like the server's ratelimit
+// stage. A perMinute of zero disables the limit.
+func RateLimitByIP(perMinute, burst int64) func(http.Handler) http.Handler {
+	l := newRateLimiter(perMinute, burst)
+	if l == nil {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	return l.limitByIP
+}
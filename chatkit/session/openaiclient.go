@@ -0,0 +1,104 @@
+package session
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/openai/openai-go/v3"
+	"github.com/openai/openai-go/v3/option"
+)
+
+// OpenAIClientConfig configures the OpenAI SDK client used for ChatKit
+// calls.
+type OpenAIClientConfig struct {
+	APIKey  string
+	BaseURL string
+	// Project and Organization, when set, send the OpenAI-Project and
+	// OpenAI-Organization headers, so usage is billed to that project.
+	Project      string
+	Organization string
+	// HTTPClient, when set, carries every OpenAI request. Use it to add
+	// instrumentation, route through a proxy, or stub upstream responses in
+	// tests.
+	HTTPClient *http.Client
+}
+
+// NewOpenAIClient builds an OpenAI client from cfg.
+func NewOpenAIClient(cfg OpenAIClientConfig) openai.Client {
+	opts := []option.RequestOption{option.WithAPIKey(cfg.APIKey), openAIInstrumentation}
+	if cfg.BaseURL != "" {
+		opts = append(opts, option.WithBaseURL(cfg.BaseURL))
+	}
+	if cfg.Project != "" {
+		opts = append(opts, option.WithProject(cfg.Project))
+	}
+	if cfg.Organization != "" {
+		opts = append(opts, option.WithOrganization(cfg.Organization))
+	}
+	if cfg.HTTPClient != nil {
+		opts = append(opts, option.WithHTTPClient(cfg.HTTPClient))
+	}
+	return openai.NewClient(opts...)
+}
+
+// openAITransportTimeouts are the per-phase limits of an OpenAI request.
+// They fail fast on connection problems, while the overall
+// openaiRequestTimeout still bounds slow but healthy responses.
+var openAITransportTimeouts = []struct {
+	key string
+	set func(*http.Transport, time.Duration)
+}{
+	{"OPENAI_DIAL_TIMEOUT", func(t *http.Transport, d time.Duration) {
+		t.DialContext = (&net.Dialer{Timeout: d, KeepAlive: 30 * time.Second}).DialContext
+	}},
+	{"OPENAI_TLS_HANDSHAKE_TIMEOUT", func(t *http.Transport, d time.Duration) { t.TLSHandshakeTimeout = d }},
+	{"OPENAI_RESPONSE_HEADER_TIMEOUT", func(t *http.Transport, d time.Duration) { t.ResponseHeaderTimeout = d }},
+}
+
+// openAIHTTPClientFromEnv builds the HTTP client for OpenAI requests from
+// OPENAI_PROXY_URL, OPENAI_MAX_IDLE_CONNS_PER_HOST, and the transport
+// timeouts. It returns nil when none is set, leaving the SDK's default
+// client in place.
+func openAIHTTPClientFromEnv(getenv func(string) string) (*http.Client, error) {
+	proxy := getenv("OPENAI_PROXY_URL")
+	idle := getenv("OPENAI_MAX_IDLE_CONNS_PER_HOST")
+	configured := proxy != "" || idle != ""
+	for _, t := range openAITransportTimeouts {
+		configured = configured || getenv(t.key) != ""
+	}
+	if !configured {
+		return nil, nil
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if proxy != "" {
+		proxyURL, err := url.Parse(proxy)
+		if err != nil || proxyURL.Scheme == "" || proxyURL.Host == "" {
+			return nil, fmt.Errorf("invalid OPENAI_PROXY_URL %q", proxy)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	if idle != "" {
+		n, err := strconv.Atoi(idle)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid OPENAI_MAX_IDLE_CONNS_PER_HOST %q", idle)
+		}
+		transport.MaxIdleConnsPerHost = n
+	}
+	for _, t := range openAITransportTimeouts {
+		value := getenv(t.key)
+		if value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 || d > openaiRequestTimeout {
+			return nil, fmt.Errorf("invalid %s %q: expected a duration up to %s, such as 2s", t.key, value, openaiRequestTimeout)
+		}
+		t.set(transport, d)
+	}
+	return &http.Client{Transport: transport}, nil
+}
@@ -0,0 +1,98 @@
+package session
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/openai/openai-go/v3"
+)
+
+type recordingTransport struct {
+	requests []*http.Request
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.requests = append(t.requests, req)
+	rec := httptest.NewRecorder()
+	rec.Header().Set("Content-Type", contentTypeJSON)
+	rec.Header().Set(openAIResponseIDHeader, "req_upstream_1")
+	rec.WriteString(`{"id":"cksess_1","client_secret":"ek_1"}`)
+	return rec.Result(), nil
+}
+
+func TestNewOpenAIClientUsesInjectedHTTPClient(t *testing.T) {
+	transport := &recordingTransport{}
+	client := NewOpenAIClient(OpenAIClientConfig{
+		APIKey:     "k",
+		BaseURL:    "https://openai.example.com/v1",
+		HTTPClient: &http.Client{Transport: transport},
+	})
+
+	session, err := sdkChatKitAPI{client: client}.CreateSession(context.Background(), openai.BetaChatKitSessionNewParams{
+		User:     "u",
+		Workflow: openai.ChatSessionWorkflowParam{ID: "wf"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if session.ClientSecret != "ek_1" {
+		t.Fatalf("unexpected session: %+v", session)
+	}
+	if len(transport.requests) != 1 || transport.requests[0].URL.String() != "https://openai.example.com/v1/chatkit/sessions" {
+		t.Fatalf("expected one request through the injected transport, got %v", transport.requests)
+	}
+}
+
+func TestOpenAIHTTPClientFromEnv(t *testing.T) {
+	env := map[string]string{}
+	getenv := func(key string) string { return env[key] }
+
+	if client, err := openAIHTTPClientFromEnv(getenv); err != nil || client != nil {
+		t.Fatalf("expected no client without configuration, got %v, %v", client, err)
+	}
+
+	env["OPENAI_PROXY_URL"] = "http://proxy.internal:3128"
+	env["OPENAI_MAX_IDLE_CONNS_PER_HOST"] = "64"
+	client, err := openAIHTTPClientFromEnv(getenv)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	transport := client.Transport.(*http.Transport)
+	if transport.MaxIdleConnsPerHost != 64 {
+		t.Fatalf("expected 64 idle conns per host, got %d", transport.MaxIdleConnsPerHost)
+	}
+	proxy, _ := transport.Proxy(&http.Request{URL: &url.URL{Scheme: "https", Host: "api.openai.com"}})
+	if proxy == nil || proxy.Host != "proxy.internal:3128" {
+		t.Fatalf("unexpected proxy: %v", proxy)
+	}
+
+	env = map[string]string{"OPENAI_DIAL_TIMEOUT": "2s", "OPENAI_RESPONSE_HEADER_TIMEOUT": "12s"}
+	client, err = openAIHTTPClientFromEnv(getenv)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	transport = client.Transport.(*http.Transport)
+	if transport.ResponseHeaderTimeout != 12*time.Second || transport.DialContext == nil {
+		t.Fatalf("expected transport timeouts to be set, got header timeout %s", transport.ResponseHeaderTimeout)
+	}
+	if transport.TLSHandshakeTimeout != http.DefaultTransport.(*http.Transport).TLSHandshakeTimeout {
+		t.Fatalf("expected the default TLS handshake timeout to be kept, got %s", transport.TLSHandshakeTimeout)
+	}
+
+	for _, bad := range []map[string]string{
+		{"OPENAI_PROXY_URL": "proxy.internal"},
+		{"OPENAI_MAX_IDLE_CONNS_PER_HOST": "many"},
+		{"OPENAI_DIAL_TIMEOUT": "fast"},
+		{"OPENAI_TLS_HANDSHAKE_TIMEOUT": "0s"},
+		{"OPENAI_RESPONSE_HEADER_TIMEOUT": "1m"},
+	} {
+		env = bad
+		if _, err := openAIHTTPClientFromEnv(getenv); err == nil {
+			t.Fatalf("expected error for %v", bad)
+		}
+	}
+}
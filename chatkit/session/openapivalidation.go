@@ -0,0 +1,322 @@
+package session
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// openAPIValidator checks requests against the operations of the served
+// OpenAPI document, so that the document and the server cannot drift
+// apart. It understands the subset of JSON Schema the document uses:
+// $ref, type, required, properties, additionalProperties, items, enum, and
+// the date-time format. Requests for paths or methods the document does
+// not describe pass through unchecked.
+type openAPIValidator struct {
+	// operations maps paths to methods to operations.
+	operations map[string]map[string]*openAPIOperation
+	schemas    map[string]*jsonSchema
+}
+
+type openAPIOperation struct {
+	Parameters  []openAPIParameter  `json:"parameters"`
+	RequestBody *openAPIRequestBody `json:"requestBody"`
+}
+
+type openAPIParameter struct {
+	Name     string      `json:"name"`
+	In       string      `json:"in"`
+	Required bool        `json:"required"`
+	Schema   *jsonSchema `json:"schema"`
+}
+
+type openAPIRequestBody struct {
+	Required bool `json:"required"`
+	Content  map[string]struct {
+		Schema *jsonSchema `json:"schema"`
+	} `json:"content"`
+}
+
+type jsonSchema struct {
+	Ref                  string                 `json:"$ref"`
+	Type                 string                 `json:"type"`
+	Format               string                 `json:"format"`
+	Required             []string               `json:"required"`
+	Properties           map[string]*jsonSchema `json:"properties"`
+	AdditionalProperties *additionalProperties  `json:"additionalProperties"`
+	Items                *jsonSchema            `json:"items"`
+	Enum                 []any                  `json:"enum"`
+}
+
+// additionalProperties is either a boolean or a schema.
+type additionalProperties struct {
+	allowed bool
+	schema  *jsonSchema
+}
+
+func (a *additionalProperties) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &a.allowed); err == nil {
+		return nil
+	}
+	a.allowed = true
+	return json.Unmarshal(data, &a.schema)
+}
+
+// newOpenAPIValidator reads the operations and schemas of doc.
+func newOpenAPIValidator(doc []byte) (*openAPIValidator, error) {
+	var parsed struct {
+		Paths      map[string]map[string]json.RawMessage `json:"paths"`
+		Components struct {
+			Schemas map[string]*jsonSchema `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(doc, &parsed); err != nil {
+		return nil, err
+	}
+	v := &openAPIValidator{operations: make(map[string]map[string]*openAPIOperation), schemas: parsed.Components.Schemas}
+	for path, item := range parsed.Paths {
+		v.operations[path] = make(map[string]*openAPIOperation)
+		for method, raw := range item {
+			var op openAPIOperation
+			if err := json.Unmarshal(raw, &op); err != nil {
+				return nil, fmt.Errorf("%s %s: %w", strings.ToUpper(method), path, err)
+			}
+			v.operations[path][strings.ToUpper(method)] = &op
+		}
+	}
+	return v, nil
+}
+
+// wrap rejects requests whose query parameters or JSON body do not match
+// their operation with a 400 listing every problem, each with the JSON
+// pointer of the offending body value.
+func (v *openAPIValidator) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		op := v.operations[r.URL.Path][r.Method]
+		if op == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		problems := v.checkParameters(op, r)
+		if body := op.RequestBody; body != nil {
+			if media, ok := body.Content[contentTypeJSON]; ok {
+				data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBodyBytes))
+				r.Body.Close()
+				var tooLarge *http.MaxBytesError
+				if errors.As(err, &tooLarge) {
+					http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+					return
+				}
+				r.Body = io.NopCloser(bytes.NewReader(data))
+				problems = append(problems, v.checkBody(data, body.Required, media.Schema)...)
+			}
+		}
+		if len(problems) > 0 {
+			writeValidationErrors(w, problems)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (v *openAPIValidator) checkParameters(op *openAPIOperation, r *http.Request) []fieldError {
+	var problems []fieldError
+	query := r.URL.Query()
+	for _, p := range op.Parameters {
+		if p.In != "query" {
+			continue
+		}
+		if !query.Has(p.Name) {
+			if p.Required {
+				problems = append(problems, fieldError{Field: p.Name, Code: validationCodeRequired, Message: fmt.Sprintf("query parameter %s is required", p.Name)})
+			}
+			continue
+		}
+		value := query.Get(p.Name)
+		var err error
+		switch v.resolve(p.Schema).Type {
+		case "integer":
+			_, err = strconv.ParseInt(value, 10, 64)
+		case "number":
+			_, err = strconv.ParseFloat(value, 64)
+		case "boolean":
+			_, err = strconv.ParseBool(value)
+		}
+		if err != nil {
+			problems = append(problems, fieldError{Field: p.Name, Code: validationCodeInvalidType, Message: fmt.Sprintf("query parameter %s must be %s", p.Name, schemaTypeName(p.Schema.Type))})
+		}
+	}
+	return problems
+}
+
+func (v *openAPIValidator) checkBody(data []byte, required bool, schema *jsonSchema) []fieldError {
+	if len(bytes.TrimSpace(data)) == 0 {
+		if required {
+			return []fieldError{{Code: validationCodeRequired, Message: "request body is required"}}
+		}
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var value any
+	if err := dec.Decode(&value); err != nil || dec.More() {
+		return []fieldError{{Code: validationCodeInvalidJSON, Message: "request body must be valid JSON"}}
+	}
+	var problems []fieldError
+	v.check(value, schema, nil, &problems)
+	return problems
+}
+
+// resolve follows a schema's $ref, if any.
+func (v *openAPIValidator) resolve(s *jsonSchema) *jsonSchema {
+	for s != nil && s.Ref != "" {
+		s = v.schemas[strings.TrimPrefix(s.Ref, "#/components/schemas/")]
+	}
+	if s == nil {
+		return &jsonSchema{}
+	}
+	return s
+}
+
+// check appends every way value fails to match schema. path holds the
+// property names and array indexes leading to value.
+func (v *openAPIValidator) check(value any, schema *jsonSchema, path []string, problems *[]fieldError) {
+	s := v.resolve(schema)
+	problem := func(code, message string) {
+		*problems = append(*problems, fieldError{Field: fieldName(path), Pointer: jsonPointer(path), Code: code, Message: message})
+	}
+	if s.Type != "" && !matchesSchemaType(value, s.Type) {
+		name := fieldName(path)
+		if name == "" {
+			name = "request body"
+		}
+		problem(validationCodeInvalidType, fmt.Sprintf("%s must be %s", name, schemaTypeName(s.Type)))
+		return
+	}
+	if len(s.Enum) > 0 && !enumContains(s.Enum, value) {
+		problem(validationCodeInvalidValue, fmt.Sprintf("%s must be one of %v", fieldName(path), s.Enum))
+	}
+	switch value := value.(type) {
+	case string:
+		if s.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339, value); err != nil {
+				problem(validationCodeInvalidValue, fmt.Sprintf("%s must be an RFC 3339 timestamp", fieldName(path)))
+			}
+		}
+	case []any:
+		for i, item := range value {
+			v.check(item, s.Items, append(path[:len(path):len(path)], strconv.Itoa(i)), problems)
+		}
+	case map[string]any:
+		for _, name := range s.Required {
+			if _, ok := value[name]; !ok {
+				child := append(path[:len(path):len(path)], name)
+				*problems = append(*problems, fieldError{Field: fieldName(child), Pointer: jsonPointer(child), Code: validationCodeRequired, Message: fieldName(child) + " is required"})
+			}
+		}
+		names := make([]string, 0, len(value))
+		for name := range value {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			item := value[name]
+			child := append(path[:len(path):len(path)], name)
+			switch prop, ok := s.Properties[name]; {
+			case ok:
+				v.check(item, prop, child, problems)
+			case s.AdditionalProperties == nil:
+			case !s.AdditionalProperties.allowed:
+				*problems = append(*problems, fieldError{Field: fieldName(child), Pointer: jsonPointer(child), Code: validationCodeUnknownField, Message: fmt.Sprintf("unknown field %q", fieldName(child))})
+			case s.AdditionalProperties.schema != nil:
+				v.check(item, s.AdditionalProperties.schema, child, problems)
+			}
+		}
+	}
+}
+
+func matchesSchemaType(value any, typ string) bool {
+	switch typ {
+	case "object":
+		_, ok := value.(map[string]any)
+		return ok
+	case "array":
+		_, ok := value.([]any)
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "number":
+		_, ok := value.(json.Number)
+		return ok
+	case "integer":
+		n, ok := value.(json.Number)
+		if !ok {
+			return false
+		}
+		_, err := n.Int64()
+		return err == nil
+	}
+	return true
+}
+
+func schemaTypeName(typ string) string {
+	switch typ {
+	case "object", "array", "integer":
+		return "an " + typ
+	case "":
+		return "a value"
+	}
+	return "a " + typ
+}
+
+func enumContains(enum []any, value any) bool {
+	if n, ok := value.(json.Number); ok {
+		f, _ := n.Float64()
+		value = f
+	}
+	for _, e := range enum {
+		if reflect.DeepEqual(e, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldName names the value at path the way other validation errors do,
+// such as "prefs.theme" or "warnings[0].code".
+func fieldName(path []string) string {
+	var b strings.Builder
+	for _, p := range path {
+		if _, err := strconv.Atoi(p); err == nil {
+			b.WriteString("[" + p + "]")
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteByte('.')
+		}
+		b.WriteString(p)
+	}
+	return b.String()
+}
+
+// jsonPointer returns the RFC 6901 pointer to the value at path.
+func jsonPointer(path []string) string {
+	var b strings.Builder
+	for _, p := range path {
+		b.WriteByte('/')
+		b.WriteString(strings.NewReplacer("~", "~0", "/", "~1").Replace(p))
+	}
+	return b.String()
+}
@@ -0,0 +1,70 @@
+package session
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOpenAPIValidationRejectsRequestsThatDoNotMatchTheDocument(t *testing.T) {
+	fake := &fakeSessionCreator{clientSecret: "secret"}
+	sessions := newSessionHandler(fake.Create, "w", 1200, 10)
+	prefs, err := newLocalPrefsStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions.prefs = prefs
+	sessions.tenants = &tenantRegistry{tenants: []*tenant{{name: "acme", key: []byte("acme-key"), workflowID: "w", createSession: fake.Create}}}
+	router, err := newRouter(sessions, nil, nil, nil, &routerOptions{validateRequests: true})
+	if err != nil {
+		t.Fatalf("unexpected router error: %v", err)
+	}
+	do := func(method, target, body string) (int, []fieldError) {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set(tenantKeyHeader, "acme-key")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		var resp validationErrorResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec.Code, resp.Fields
+	}
+
+	for name, tc := range map[string]struct {
+		method, target, body string
+		want                 []fieldError
+	}{
+		"wrong type": {http.MethodPost, "/api/chatkit/session", `{"user":5,"platform":"web"}`, []fieldError{
+			{Field: "user", Pointer: "/user", Code: validationCodeInvalidType, Message: "user must be a string"},
+		}},
+		"missing field": {http.MethodPost, "/api/chatkit/session", `{}`, []fieldError{
+			{Field: "user", Pointer: "/user", Code: validationCodeRequired, Message: "user is required"},
+		}},
+		"nested value": {http.MethodPut, "/v1/chatkit/prefs?user=u", `{"prefs":{"theme":"dark","a/b":1}}`, []fieldError{
+			{Field: "prefs.a/b", Pointer: "/prefs/a~1b", Code: validationCodeInvalidType, Message: "prefs.a/b must be a string"},
+		}},
+		"not an object": {http.MethodPost, "/api/chatkit/session", `[]`, []fieldError{
+			{Code: validationCodeInvalidType, Message: "request body must be an object"},
+		}},
+		"missing body": {http.MethodPost, "/api/chatkit/session", ``, []fieldError{
+			{Code: validationCodeRequired, Message: "request body is required"},
+		}},
+	} {
+		code, fields := do(tc.method, tc.target, tc.body)
+		if code != http.StatusBadRequest || !reflect.DeepEqual(fields, tc.want) {
+			t.Fatalf("%s: expected 400 with %+v, got %d %+v", name, tc.want, code, fields)
+		}
+	}
+	if fake.called {
+		t.Fatal("expected invalid requests not to reach the handler")
+	}
+
+	if code, fields := do(http.MethodPost, "/api/chatkit/session", `{"user":"u"}`); code != http.StatusOK {
+		t.Fatalf("expected a valid request to reach the handler with its body, got %d %+v", code, fields)
+	}
+	if code, _ := do(http.MethodPut, "/v1/chatkit/prefs?user=u", `{"prefs":{"theme":"dark"}}`); code != http.StatusOK {
+		t.Fatalf("expected valid preferences to be saved, got %d", code)
+	}
+}
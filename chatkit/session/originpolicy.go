@@ -0,0 +1,137 @@
+package session
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+
+	"github.com/openai/openai-go/v3"
+
+	"openai-chatkit-backend/chatkit/cors"
+)
+
+// originFeatures restricts the sessions minted for one origin, such as an
+// embedded widget on partner sites that must offer less than the first-party
+// app.
+type originFeatures struct {
+	Origin string `json:"origin"`
+	// Attachments turns file uploads on or off; nil leaves ChatKit's
+	// default.
+	Attachments            *bool    `json:"attachments,omitempty"`
+	MaxExpiresAfterSeconds int64    `json:"max_expires_after_seconds,omitempty"`
+	Workflows              []string `json:"workflows,omitempty"`
+
+	// workflowIDs resolves Workflows, in order.
+	workflowIDs []string
+	pattern     *cors.Pattern
+}
+
+// allowsWorkflow reports whether the origin may start workflowID.
+func (f *originFeatures) allowsWorkflow(workflowID string) bool {
+	if len(f.workflowIDs) == 0 {
+		return true
+	}
+	for _, id := range f.workflowIDs {
+		if id == workflowID {
+			return true
+		}
+	}
+	return false
+}
+
+// restrict falls back to the origin's first workflow when settings select
+// one it may not start, and caps the session lifetime.
+func (f *originFeatures) restrict(settings *sessionSettings) {
+	if !f.allowsWorkflow(settings.workflowID) {
+		settings.workflowID = f.workflowIDs[0]
+	}
+	if limit := f.MaxExpiresAfterSeconds; limit > 0 && settings.expiresAfterSeconds > limit {
+		settings.expiresAfterSeconds = limit
+	}
+}
+
+// apply enforces the restrictions on session parameters, after any tester
+// overrides.
+func (f *originFeatures) apply(params *openai.BetaChatKitSessionNewParams) {
+	if f.Attachments != nil {
+		params.ChatKitConfiguration.FileUpload.Enabled = openai.Bool(*f.Attachments)
+	}
+	if f.MaxExpiresAfterSeconds > 0 && params.ExpiresAfter.Seconds > f.MaxExpiresAfterSeconds {
+		params.ExpiresAfter.Seconds = f.MaxExpiresAfterSeconds
+	}
+}
+
+// originPolicy is an ordered list of per-origin restrictions. The first
+// entry whose origin matches applies; origins no entry matches are not
+// restricted.
+type originPolicy []*originFeatures
+
+// loadOriginPolicy reads a JSON array of originFeatures. Origins use the
+// CORS_ALLOWED_ORIGINS syntax, and workflows are aliases from workflows. An
+// empty path disables the policy.
+func loadOriginPolicy(path string, workflows map[string]workflowRef) (originPolicy, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var policy originPolicy
+	if err := dec.Decode(&policy); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	for i, f := range policy {
+		if f == nil || f.Origin == "" {
+			return nil, fmt.Errorf("%s: entry %d: origin is required", path, i)
+		}
+		if cors.IsPattern(f.Origin) {
+			p, err := cors.ParsePattern(f.Origin)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			f.pattern = &p
+		}
+		if f.MaxExpiresAfterSeconds < 0 {
+			return nil, fmt.Errorf("%s: origin %q: max_expires_after_seconds must be positive", path, f.Origin)
+		}
+		for _, alias := range f.Workflows {
+			ref, ok := workflows[alias]
+			if !ok {
+				return nil, fmt.Errorf("%s: origin %q: unknown workflow alias %q", path, f.Origin, alias)
+			}
+			f.workflowIDs = append(f.workflowIDs, ref.ID)
+		}
+	}
+	return policy, nil
+}
+
+// match returns the restrictions for origin, or nil when there are none.
+func (p originPolicy) match(origin string) *originFeatures {
+	if origin == "" {
+		return nil
+	}
+	var u *url.URL
+	for _, f := range p {
+		if f.pattern == nil {
+			if f.Origin == origin {
+				return f
+			}
+			continue
+		}
+		if u == nil {
+			var err error
+			if u, err = url.Parse(origin); err != nil || u.Host == "" {
+				return nil
+			}
+		}
+		if f.pattern.Matches(origin, u) {
+			return f
+		}
+	}
+	return nil
+}
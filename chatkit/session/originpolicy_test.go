@@ -0,0 +1,92 @@
+package session
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeOriginPolicy(t *testing.T, policy string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "origins.json")
+	if err := os.WriteFile(path, []byte(policy), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadOriginPolicy(t *testing.T) {
+	workflows := map[string]workflowRef{"default": {ID: "w"}, "support": {ID: "wf_support"}}
+	for _, policy := range []string{
+		`[{"attachments": false}]`,
+		`[{"origin": "https://a.example.com", "workflows": ["unknown"]}]`,
+		`[{"origin": "https://*.com"}]`,
+		`[{"origin": "https://a.example.com", "max_expires_after_seconds": -1}]`,
+		`[{"origin": "https://a.example.com", "attachment": true}]`,
+	} {
+		if _, err := loadOriginPolicy(writeOriginPolicy(t, policy), workflows); err == nil {
+			t.Fatalf("expected %s to be rejected", policy)
+		}
+	}
+
+	policy, err := loadOriginPolicy(writeOriginPolicy(t, `[
+		{"origin": "https://app.example.com"},
+		{"origin": "https://*.partners.example.com", "workflows": ["support", "default"]}
+	]`), workflows)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if f := policy.match("https://app.example.com"); f == nil || f.Origin != "https://app.example.com" {
+		t.Fatalf("expected the exact entry, got %+v", f)
+	}
+	if f := policy.match("https://acme.partners.example.com"); f == nil || !f.allowsWorkflow("w") || f.allowsWorkflow("wf_other") {
+		t.Fatalf("expected the wildcard entry, got %+v", f)
+	}
+	if policy.match("https://other.example.com") != nil || policy.match("") != nil {
+		t.Fatal("expected unmatched origins to be unrestricted")
+	}
+}
+
+func TestHandleSessionAppliesOriginPolicy(t *testing.T) {
+	fake := &fakeSessionCreator{clientSecret: "secret"}
+	handler := newSessionHandler(fake.Create, "w", 1200, 10)
+	handler.allowedWorkflows = map[string]bool{"wf_support": true, "wf_other": true}
+	workflows := map[string]workflowRef{"default": {ID: "w"}, "support": {ID: "wf_support"}}
+	policy, err := loadOriginPolicy(writeOriginPolicy(t, `[{"origin": "https://partner.example.com", "attachments": false, "max_expires_after_seconds": 600, "workflows": ["support"]}]`), workflows)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	handler.originPolicy = policy
+
+	mint := func(origin, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, defaultSessionPath, strings.NewReader(body))
+		req.Header.Set("Origin", origin)
+		rec := httptest.NewRecorder()
+		handler.handleSession(rec, req)
+		return rec
+	}
+
+	if rec := mint("https://partner.example.com", `{"user":"u"}`); rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if fake.params.Workflow.ID != "wf_support" || fake.params.ExpiresAfter.Seconds != 600 {
+		t.Fatalf("expected the partner workflow and capped expiry, got %q %d", fake.params.Workflow.ID, fake.params.ExpiresAfter.Seconds)
+	}
+	if upload := fake.params.ChatKitConfiguration.FileUpload.Enabled; !upload.Valid() || upload.Value {
+		t.Fatalf("expected uploads to be turned off, got %+v", upload)
+	}
+
+	if rec := mint("https://partner.example.com", `{"user":"u","workflow_id":"wf_other"}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected a disallowed workflow to be rejected, got %d", rec.Code)
+	}
+
+	if rec := mint("https://app.example.com", `{"user":"u","workflow_id":"wf_other"}`); rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rec.Code)
+	}
+	if fake.params.Workflow.ID != "wf_other" || fake.params.ExpiresAfter.Seconds != 1200 || fake.params.ChatKitConfiguration.FileUpload.Enabled.Valid() {
+		t.Fatalf("expected other origins to be unrestricted, got %+v", fake.params)
+	}
+}
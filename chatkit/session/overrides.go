@@ -0,0 +1,139 @@
+package session
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/openai/openai-go/v3"
+
+	"openai-chatkit-backend/chatkit/auth"
+)
+
+const (
+	overrideWorkflowVersionHeader = "X-Override-Workflow-Version"
+	overrideExpiresAfterHeader    = "X-Override-Expires-After-Seconds"
+	overrideTracingHeader         = "X-Override-Tracing"
+)
+
+// testerPolicy identifies internal testers, who may override session
+// parameters per request. A caller qualifies by presenting one of the tester
+// API keys in X-API-Key or by carrying the configured claim value.
+type testerPolicy struct {
+	keys       [][]byte
+	claim      string
+	claimValue string
+}
+
+// newTesterPolicy parses comma-separated tester API keys and a claim rule of
+// the form name=value. It returns nil when neither is configured.
+func newTesterPolicy(keys, claimRule string) (*testerPolicy, error) {
+	p := &testerPolicy{}
+	for _, k := range strings.Split(keys, ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			p.keys = append(p.keys, []byte(k))
+		}
+	}
+	if claimRule = strings.TrimSpace(claimRule); claimRule != "" {
+		name, value, ok := strings.Cut(claimRule, "=")
+		if !ok || strings.TrimSpace(name) == "" || strings.TrimSpace(value) == "" {
+			return nil, fmt.Errorf("invalid tester claim %q: expected name=value", claimRule)
+		}
+		p.claim, p.claimValue = strings.TrimSpace(name), strings.TrimSpace(value)
+	}
+	if len(p.keys) == 0 && p.claim == "" {
+		return nil, nil
+	}
+	return p, nil
+}
+
+// allowed reports whether r comes from an internal tester.
+func (p *testerPolicy) allowed(r *http.Request) bool {
+	if key := r.Header.Get(apiKeyHeader); key != "" {
+		matched := 0
+		for _, k := range p.keys {
+			matched |= subtle.ConstantTimeCompare([]byte(key), k)
+		}
+		if matched == 1 {
+			return true
+		}
+	}
+	if p.claim == "" {
+		return false
+	}
+	claims, _ := auth.ClaimsFromContext(r.Context())
+	switch v := claims[p.claim].(type) {
+	case string:
+		return v == p.claimValue
+	case []any:
+		for _, item := range v {
+			if s, ok := item.(string); ok && s == p.claimValue {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sessionOverrides are per-request changes to the upstream session
+// parameters requested by an internal tester.
+type sessionOverrides struct {
+	workflowVersion     string
+	expiresAfterSeconds int64
+	tracing             *bool
+}
+
+func (o sessionOverrides) empty() bool {
+	return o.workflowVersion == "" && o.expiresAfterSeconds == 0 && o.tracing == nil
+}
+
+func (o sessionOverrides) String() string {
+	var parts []string
+	if o.workflowVersion != "" {
+		parts = append(parts, "workflow_version="+o.workflowVersion)
+	}
+	if o.expiresAfterSeconds != 0 {
+		parts = append(parts, "expires_after_seconds="+strconv.FormatInt(o.expiresAfterSeconds, 10))
+	}
+	if o.tracing != nil {
+		parts = append(parts, "tracing="+strconv.FormatBool(*o.tracing))
+	}
+	return strings.Join(parts, " ")
+}
+
+// parseSessionOverrides reads the override headers, reporting a problem for
+// every malformed one.
+func parseSessionOverrides(h http.Header) (sessionOverrides, []fieldError) {
+	var o sessionOverrides
+	var problems []fieldError
+	o.workflowVersion = strings.TrimSpace(h.Get(overrideWorkflowVersionHeader))
+	if v := strings.TrimSpace(h.Get(overrideExpiresAfterHeader)); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			problems = append(problems, fieldError{Field: overrideExpiresAfterHeader, Code: validationCodeInvalidValue, Message: overrideExpiresAfterHeader + " must be a positive integer"})
+		}
+		o.expiresAfterSeconds = n
+	}
+	if v := strings.TrimSpace(h.Get(overrideTracingHeader)); v != "" {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			problems = append(problems, fieldError{Field: overrideTracingHeader, Code: validationCodeInvalidValue, Message: overrideTracingHeader + " must be true or false"})
+		}
+		o.tracing = &enabled
+	}
+	return o, problems
+}
+
+func (o sessionOverrides) apply(params *openai.BetaChatKitSessionNewParams) {
+	if o.workflowVersion != "" {
+		params.Workflow.Version = openai.String(o.workflowVersion)
+	}
+	if o.expiresAfterSeconds > 0 {
+		params.ExpiresAfter.Seconds = o.expiresAfterSeconds
+	}
+	if o.tracing != nil {
+		params.Workflow.Tracing.Enabled = openai.Bool(*o.tracing)
+	}
+}
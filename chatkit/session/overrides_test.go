@@ -0,0 +1,94 @@
+package session
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"openai-chatkit-backend/chatkit/auth"
+)
+
+func TestTesterPolicyAllowed(t *testing.T) {
+	p, err := newTesterPolicy("qa-key-1, qa-key-2", "groups=qa")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/chatkit/session", nil)
+	if p.allowed(req) {
+		t.Fatalf("expected anonymous request to be rejected")
+	}
+	req.Header.Set(apiKeyHeader, "qa-key-2")
+	if !p.allowed(req) {
+		t.Fatalf("expected tester API key to be allowed")
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/api/chatkit/session", nil)
+	req = req.WithContext(auth.ContextWithClaims(context.Background(), auth.Claims{"groups": []any{"eng", "qa"}}))
+	if !p.allowed(req) {
+		t.Fatalf("expected tester claim to be allowed")
+	}
+
+	if p, _ := newTesterPolicy("", ""); p != nil {
+		t.Fatalf("expected nil policy when unconfigured")
+	}
+	if _, err := newTesterPolicy("", "groups"); err == nil {
+		t.Fatalf("expected error for claim rule without value")
+	}
+}
+
+func TestHandleSessionAppliesTesterOverrides(t *testing.T) {
+	fake := &fakeSessionCreator{clientSecret: "secret"}
+	handler := newSessionHandler(fake.Create, "w", 1200, 10)
+	handler.testers, _ = newTesterPolicy("qa-key", "")
+
+	newRequest := func(key string) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/api/chatkit/session", strings.NewReader(`{"user":"u"}`))
+		req.Header.Set(apiKeyHeader, key)
+		req.Header.Set(overrideWorkflowVersionHeader, "7-beta")
+		req.Header.Set(overrideExpiresAfterHeader, "60")
+		req.Header.Set(overrideTracingHeader, "false")
+		return req
+	}
+
+	rec := httptest.NewRecorder()
+	handler.handleSession(rec, newRequest("qa-key"))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rec.Code)
+	}
+	wf := fake.params.Workflow
+	if wf.Version.Value != "7-beta" || fake.params.ExpiresAfter.Seconds != 60 || !wf.Tracing.Enabled.Valid() || wf.Tracing.Enabled.Value {
+		t.Fatalf("expected overrides to be applied: %+v", fake.params)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.handleSession(rec, newRequest("not-a-tester"))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rec.Code)
+	}
+	if fake.params.Workflow.Version.Valid() || fake.params.ExpiresAfter.Seconds != 1200 {
+		t.Fatalf("expected overrides from non-testers to be ignored: %+v", fake.params)
+	}
+}
+
+func TestHandleSessionRejectsMalformedOverrides(t *testing.T) {
+	fake := &fakeSessionCreator{clientSecret: "secret"}
+	handler := newSessionHandler(fake.Create, "w", 1200, 10)
+	handler.testers, _ = newTesterPolicy("qa-key", "")
+
+	req := httptest.NewRequest(http.MethodPost, "/api/chatkit/session", strings.NewReader(`{"user":"u"}`))
+	req.Header.Set(apiKeyHeader, "qa-key")
+	req.Header.Set(overrideExpiresAfterHeader, "-5")
+	req.Header.Set(overrideTracingHeader, "maybe")
+	rec := httptest.NewRecorder()
+	handler.handleSession(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), overrideExpiresAfterHeader) || !strings.Contains(rec.Body.String(), overrideTracingHeader) {
+		t.Fatalf("expected both header problems to be reported: %s", rec.Body.String())
+	}
+}
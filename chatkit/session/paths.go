@@ -0,0 +1,93 @@
+package session
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"openai-chatkit-backend/openapi"
+)
+
+const defaultSessionPath = "/api/chatkit/session"
+
+// routePaths maps endpoints to the paths they are served on, so the service
+// fits an existing gateway routing scheme without a rewrite layer. The zero
+// value serves every endpoint on its default path.
+type routePaths struct {
+	// prefix is prepended to every path, e.g. "/chat".
+	prefix string
+	// session replaces defaultSessionPath, and the prefix of the endpoints
+	// under it such as refresh, when set.
+	session string
+}
+
+// newRoutePaths validates a global prefix and a session endpoint path.
+// Either may be empty to keep the default.
+func newRoutePaths(prefix, session string) (routePaths, error) {
+	prefix = strings.TrimSuffix(strings.TrimSpace(prefix), "/")
+	session = strings.TrimSpace(session)
+	if prefix != "" && !validRoutePath(prefix) {
+		return routePaths{}, fmt.Errorf("invalid path prefix %q: expected a path such as /chat", prefix)
+	}
+	if session != "" && !validRoutePath(session) {
+		return routePaths{}, fmt.Errorf("invalid session path %q: expected a path such as /chatkit/token", session)
+	}
+	if strings.HasPrefix(session, "/admin/") {
+		return routePaths{}, fmt.Errorf("invalid session path %q: /admin/ is reserved for admin routes", session)
+	}
+	return routePaths{prefix: prefix, session: session}, nil
+}
+
+func validRoutePath(p string) bool {
+	return strings.HasPrefix(p, "/") && !strings.HasSuffix(p, "/") && !strings.ContainsAny(p, " ?#{}")
+}
+
+// resolve returns the path an endpoint registered on its default path is
+// served on.
+func (p routePaths) resolve(path string) string {
+	if p.session != "" {
+		if path == defaultSessionPath {
+			path = p.session
+		} else if rest, ok := strings.CutPrefix(path, defaultSessionPath+"/"); ok {
+			path = p.session + "/" + rest
+		}
+	}
+	return p.prefix + path
+}
+
+// group returns the route group a request path belongs to.
+func (p routePaths) group(path string) string {
+	switch {
+	case path == p.resolve(defaultSessionPath) || strings.HasPrefix(path, p.resolve(defaultSessionPath)+"/") || strings.HasPrefix(path, p.prefix+"/api/"):
+		return routeGroupSession
+	case strings.HasPrefix(path, p.prefix+"/admin/"):
+		return routeGroupAdmin
+	default:
+		return routeGroupProbes
+	}
+}
+
+// openAPIDocument returns the embedded OpenAPI document with its paths
+// remapped.
+func (p routePaths) openAPIDocument() ([]byte, error) {
+	if p == (routePaths{}) {
+		return openapi.Document, nil
+	}
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(openapi.Document, &doc); err != nil {
+		return nil, err
+	}
+	var paths map[string]json.RawMessage
+	if err := json.Unmarshal(doc["paths"], &paths); err != nil {
+		return nil, err
+	}
+	remapped := make(map[string]json.RawMessage, len(paths))
+	for path, item := range paths {
+		remapped[p.resolve(path)] = item
+	}
+	var err error
+	if doc["paths"], err = json.Marshal(remapped); err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(doc, "", "  ")
+}
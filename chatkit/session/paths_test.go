@@ -0,0 +1,79 @@
+package session
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewRoutePathsValidates(t *testing.T) {
+	for _, tc := range []struct{ prefix, session string }{
+		{"chat", ""},
+		{"/chat?x", ""},
+		{"", "chatkit/token"},
+		{"", "/chatkit/token/"},
+		{"", "/admin/token"},
+	} {
+		if _, err := newRoutePaths(tc.prefix, tc.session); err == nil {
+			t.Fatalf("expected an error for prefix %q and session path %q", tc.prefix, tc.session)
+		}
+	}
+}
+
+func TestRouterServesRemappedPaths(t *testing.T) {
+	paths, err := newRoutePaths("/chat/", "/chatkit/token")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	fake := &fakeSessionCreator{clientSecret: "secret"}
+	handler := newSessionHandler(fake.Create, "w", 1200, 10)
+	handler.resumeTokens, err = newResumeTokens(testResumeTokenKey, time.Hour, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router, err := newRouter(handler, nil, nil, nil, &routerOptions{paths: paths})
+	if err != nil {
+		t.Fatalf("unexpected router error: %v", err)
+	}
+
+	send := func(method, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(`{"user":"u"}`)))
+		return rec
+	}
+	if rec := send(http.MethodPost, "/chat/chatkit/token"); rec.Code != http.StatusOK {
+		t.Fatalf("expected the remapped session path to be served, got %d", rec.Code)
+	}
+	if rec := send(http.MethodPost, "/api/chatkit/session"); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected the default session path to be gone, got %d", rec.Code)
+	}
+	if rec := send(http.MethodPost, "/chat/chatkit/token/refresh"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected the refresh endpoint to follow the session path, got %d", rec.Code)
+	}
+	if rec := send(http.MethodPost, "/api/chatkit/session/refresh"); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected the default refresh path to be gone, got %d", rec.Code)
+	}
+	if rec := send(http.MethodGet, "/chat/healthz"); rec.Code != http.StatusOK {
+		t.Fatalf("expected probes under the prefix, got %d", rec.Code)
+	}
+
+	rec := send(http.MethodGet, "/chat/openapi.json")
+	var doc struct {
+		Paths map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("invalid OpenAPI document: %v", err)
+	}
+	if _, ok := doc.Paths["/chat/chatkit/token/refresh"]; !ok {
+		t.Fatalf("expected the OpenAPI document to list the remapped refresh path, got %v", doc.Paths)
+	}
+	if _, ok := doc.Paths["/chat/chatkit/token"]; !ok {
+		t.Fatalf("expected the OpenAPI document to list the remapped path, got %v", doc.Paths)
+	}
+	if paths.group("/chat/chatkit/token") != routeGroupSession || paths.group("/chat/chatkit/token/refresh") != routeGroupSession || paths.group("/chat/admin/bans") != routeGroupAdmin {
+		t.Fatal("expected remapped paths to keep their route groups")
+	}
+}
@@ -0,0 +1,185 @@
+package session
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Route groups whose middleware pipeline can be configured. routePaths.group
+// assigns each request to one; everything that is neither a session nor an
+// admin route, such as health and readiness probes, is in routeGroupProbes.
+const (
+	routeGroupSession = "session"
+	routeGroupAdmin   = "admin"
+	routeGroupProbes  = "probes"
+)
+
+// Pipeline stages.
+const (
+	stageCORS      = "cors"
+	stageAuth      = "auth"
+	stageRateLimit = "ratelimit"
+	stageAudit     = "audit"
+	stageMetrics   = "metrics"
+)
+
+var routeGroups = []struct {
+	name string
+	// stages lists the stages the group supports. Admin routes always check
+	// the admin token after the pipeline, so auth cannot be reordered or
+	// disabled there.
+	stages []string
+	order  []string
+}{
+	{routeGroupSession, []string{stageCORS, stageAuth, stageRateLimit, stageAudit, stageMetrics}, []string{stageMetrics, stageCORS, stageAudit, stageRateLimit, stageAuth}},
+	{routeGroupAdmin, []string{stageCORS, stageAudit, stageMetrics}, []string{stageMetrics, stageCORS, stageAudit}},
+	{routeGroupProbes, []string{stageCORS, stageAudit, stageMetrics}, []string{stageMetrics, stageCORS, stageAudit}},
+}
+
+// pipelineOrder maps each route group to its stages, outermost first.
+type pipelineOrder map[string][]string
+
+// parsePipelineOrder reads the stage order of every route group from
+// specs, keyed by group name. A spec is a comma-separated list of stages,
+// outermost first; stages left out are disabled, "none" disables them all,
+// and an empty spec keeps the default order. custom names the stages
+// embedders added to the session group with Serve; the default order runs
+// them, in turn, just outside auth.
+func parsePipelineOrder(specs map[string]string, custom ...string) (pipelineOrder, error) {
+	order := make(pipelineOrder, len(routeGroups))
+	for _, g := range routeGroups {
+		if g.name == routeGroupSession {
+			g.stages = append(append([]string(nil), g.stages...), custom...)
+			g.order = insertBefore(g.order, stageAuth, custom)
+		}
+		spec := strings.TrimSpace(specs[g.name])
+		if spec == "" {
+			order[g.name] = g.order
+			continue
+		}
+		stages := []string{}
+		if spec != "none" {
+			seen := make(map[string]bool)
+			for _, stage := range strings.Split(spec, ",") {
+				stage = strings.ToLower(strings.TrimSpace(stage))
+				if !containsString(g.stages, stage) {
+					return nil, fmt.Errorf("%s: unknown stage %q, expected one of %s", g.name, stage, strings.Join(g.stages, ", "))
+				}
+				if seen[stage] {
+					return nil, fmt.Errorf("%s: duplicate stage %q", g.name, stage)
+				}
+				seen[stage] = true
+				stages = append(stages, stage)
+			}
+		}
+		order[g.name] = stages
+	}
+	return order, nil
+}
+
+// insertBefore returns order with stages inserted just before stage.
+func insertBefore(order []string, stage string, stages []string) []string {
+	out := make([]string, 0, len(order)+len(stages))
+	for _, s := range order {
+		if s == stage {
+			out = append(out, stages...)
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+// Middleware is a named stage that code embedding the server adds to the
+// session route pipeline, such as corporate authentication. Its name can be
+// placed in CHATKIT_PIPELINE_SESSION like the built-in stages.
+type Middleware struct {
+	Name string
+	Wrap func(http.Handler) http.Handler
+}
+
+// customStageNames checks that every middleware has a distinct name that
+// does not clash with a built-in stage, and returns the names in order.
+func customStageNames(custom []Middleware) ([]string, error) {
+	builtin := []string{stageCORS, stageAuth, stageRateLimit, stageAudit, stageMetrics}
+	names := make([]string, 0, len(custom))
+	for _, mw := range custom {
+		name := strings.ToLower(strings.TrimSpace(mw.Name))
+		switch {
+		case name == "" || name == "none" || strings.Contains(name, ","):
+			return nil, fmt.Errorf("invalid middleware name %q", mw.Name)
+		case mw.Wrap == nil:
+			return nil, fmt.Errorf("middleware %q has no Wrap function", name)
+		case containsString(builtin, name):
+			return nil, fmt.Errorf("middleware %q clashes with a built-in stage", name)
+		case containsString(names, name):
+			return nil, fmt.Errorf("duplicate middleware %q", name)
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// pipeline wraps every route in the stages configured for its group. The
+// shared stages are set by main; auth and rate limiting come from the
+// session handler, and custom stages from the embedder.
+type pipeline struct {
+	order   pipelineOrder
+	cors    middleware
+	audit   middleware
+	metrics middleware
+	custom  map[string]middleware
+}
+
+// wrap returns next wrapped in the pipeline of the group its request path
+// belongs to. Stages in the order that are not configured are skipped.
+func (p *pipeline) wrap(sessions *sessionHandler, paths routePaths, next http.Handler) http.Handler {
+	if p == nil {
+		p = &pipeline{}
+	}
+	order := p.order
+	if order == nil {
+		order, _ = parsePipelineOrder(nil)
+	}
+
+	chains := make(map[string]http.Handler, len(routeGroups))
+	for _, g := range routeGroups {
+		available := map[string]middleware{
+			stageCORS:    p.cors,
+			stageAudit:   p.audit,
+			stageMetrics: p.metrics,
+		}
+		if g.name == routeGroupSession {
+			for name, mw := range p.custom {
+				available[name] = mw
+			}
+			if sessions.auth != nil {
+				available[stageAuth] = sessions.auth.Require
+			}
+			if sessions.ipLimit != nil {
+				available[stageRateLimit] = sessions.ipLimit.limitByIP
+			}
+		}
+		stages := order[g.name]
+		handler := next
+		for i := len(stages) - 1; i >= 0; i-- {
+			if mw := available[stages[i]]; mw != nil {
+				handler = mw(handler)
+			}
+		}
+		chains[g.name] = handler
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		chains[paths.group(r.URL.Path)].ServeHTTP(w, r)
+	})
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
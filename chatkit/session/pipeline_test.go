@@ -0,0 +1,129 @@
+package session
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"openai-chatkit-backend/chatkit/auth"
+	"openai-chatkit-backend/chatkit/auth/authtest"
+)
+
+func TestParsePipelineOrder(t *testing.T) {
+	order, err := parsePipelineOrder(map[string]string{
+		routeGroupSession: "auth, CORS,metrics",
+		routeGroupProbes:  "none",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := pipelineOrder{
+		routeGroupSession: {stageAuth, stageCORS, stageMetrics},
+		routeGroupAdmin:   {stageMetrics, stageCORS, stageAudit},
+		routeGroupProbes:  {},
+	}
+	if !reflect.DeepEqual(order, want) {
+		t.Fatalf("expected %v, got %v", want, order)
+	}
+
+	for name, specs := range map[string]map[string]string{
+		"unknown stage":   {routeGroupSession: "cors,tracing"},
+		"duplicate stage": {routeGroupSession: "cors,auth,cors"},
+		"admin auth":      {routeGroupAdmin: "auth,cors"},
+		"probe ratelimit": {routeGroupProbes: "ratelimit"},
+	} {
+		if _, err := parsePipelineOrder(specs); err == nil {
+			t.Fatalf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestPipelineCustomStages(t *testing.T) {
+	if _, err := customStageNames([]Middleware{{Name: "auth", Wrap: withAccessLog}}); err == nil {
+		t.Fatal("expected a clash with a built-in stage to fail")
+	}
+	if _, err := customStageNames([]Middleware{{Name: "corp", Wrap: withAccessLog}, {Name: "Corp", Wrap: withAccessLog}}); err == nil {
+		t.Fatal("expected duplicate names to fail")
+	}
+	names, err := customStageNames([]Middleware{{Name: "Corp", Wrap: withAccessLog}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	order, err := parsePipelineOrder(nil, names...)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{stageMetrics, stageCORS, stageAudit, stageRateLimit, "corp", stageAuth}; !reflect.DeepEqual(order[routeGroupSession], want) {
+		t.Fatalf("expected custom stages just outside auth, got %v", order[routeGroupSession])
+	}
+	if _, err := parsePipelineOrder(map[string]string{routeGroupAdmin: "corp"}, names...); err == nil {
+		t.Fatal("expected custom stages to be limited to the session group")
+	}
+
+	order, err = parsePipelineOrder(map[string]string{routeGroupSession: "corp,cors"}, names...)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var seen []string
+	handler := newSessionHandler((&fakeSessionCreator{clientSecret: "secret"}).Create, "w", 1200, 10)
+	router, err := newRouter(handler, nil, nil, nil, &routerOptions{pipeline: &pipeline{
+		order: order,
+		custom: map[string]middleware{"corp": func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen = append(seen, r.URL.Path)
+				http.Error(w, "corporate login required", http.StatusUnauthorized)
+			})
+		}},
+	}})
+	if err != nil {
+		t.Fatalf("unexpected router error: %v", err)
+	}
+	for _, path := range []string{"/api/chatkit/session", "/healthz"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, path, nil))
+	}
+	if !reflect.DeepEqual(seen, []string{"/api/chatkit/session"}) {
+		t.Fatalf("expected the custom stage to run on session routes only, got %v", seen)
+	}
+}
+
+func TestPipelineOrderAppliesPerRouteGroup(t *testing.T) {
+	jwks := authtest.NewJWKS(t)
+	handler := newSessionHandler((&fakeSessionCreator{clientSecret: "secret"}).Create, "w", 1200, 10)
+	handler.auth = auth.NewVerifier(jwks.URL(), "", "")
+	policy := newCORSPolicy("https://app.example.com")
+	newPipeline := func(session string) *pipeline {
+		order, err := parsePipelineOrder(map[string]string{routeGroupSession: session})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return &pipeline{order: order, cors: func(next http.Handler) http.Handler { return policy.Handler(next) }}
+	}
+	preflight := func(router http.Handler, path string) int {
+		req := httptest.NewRequest(http.MethodOptions, path, nil)
+		req.Header.Set("Origin", "https://app.example.com")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	router, err := newRouter(handler, nil, nil, nil, &routerOptions{pipeline: newPipeline("")})
+	if err != nil {
+		t.Fatalf("unexpected router error: %v", err)
+	}
+	if code := preflight(router, "/api/chatkit/session"); code != http.StatusNoContent {
+		t.Fatalf("expected CORS to answer the preflight before auth, got %d", code)
+	}
+
+	router, err = newRouter(handler, nil, nil, nil, &routerOptions{pipeline: newPipeline("auth,cors")})
+	if err != nil {
+		t.Fatalf("unexpected router error: %v", err)
+	}
+	if code := preflight(router, "/api/chatkit/session"); code != http.StatusUnauthorized {
+		t.Fatalf("expected auth to reject the preflight first, got %d", code)
+	}
+	if code := preflight(router, "/healthz"); code != http.StatusNoContent {
+		t.Fatalf("expected the probes pipeline to keep its default order, got %d", code)
+	}
+}
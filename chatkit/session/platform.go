@@ -0,0 +1,209 @@
+package session
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	platformWeb     = "web"
+	platformIOS     = "ios"
+	platformAndroid = "android"
+	platformUnknown = "unknown"
+
+	// maxPlatformVersions caps the distinct app versions counted per
+	// platform; further versions are counted as "other".
+	maxPlatformVersions = 50
+)
+
+var (
+	knownPlatforms    = map[string]bool{platformWeb: true, platformIOS: true, platformAndroid: true}
+	appVersionPattern = regexp.MustCompile(`^[0-9]+(\.[0-9]+){0,3}([-+][0-9A-Za-z.-]+)?$`)
+)
+
+// clientPlatform is the platform and app version a session was requested
+// from.
+type clientPlatform struct {
+	Name       string
+	AppVersion string
+}
+
+// resolveClientPlatform validates the platform fields of a session request.
+// When the request does not name a platform it is inferred from the
+// User-Agent header.
+func resolveClientPlatform(payload sessionRequest, userAgent string) (clientPlatform, []fieldError) {
+	p := clientPlatform{Name: strings.ToLower(strings.TrimSpace(payload.Platform)), AppVersion: strings.TrimSpace(payload.AppVersion)}
+	var problems []fieldError
+	if p.Name == "" {
+		p.Name = platformFromUserAgent(userAgent)
+	} else if !knownPlatforms[p.Name] {
+		problems = append(problems, fieldError{Field: "platform", Code: validationCodeInvalidValue, Message: "platform must be one of web, ios, android"})
+	}
+	if p.AppVersion != "" && !appVersionPattern.MatchString(p.AppVersion) {
+		problems = append(problems, fieldError{Field: "app_version", Code: validationCodeInvalidValue, Message: "app_version must be a dotted version such as 2.3.1"})
+	}
+	return p, problems
+}
+
+func platformFromUserAgent(ua string) string {
+	ua = strings.ToLower(ua)
+	switch {
+	case strings.Contains(ua, "android"):
+		return platformAndroid
+	case strings.Contains(ua, "iphone"), strings.Contains(ua, "ipad"), strings.Contains(ua, "cfnetwork"):
+		return platformIOS
+	case strings.Contains(ua, "mozilla"):
+		return platformWeb
+	default:
+		return platformUnknown
+	}
+}
+
+// compareVersions compares dotted numeric versions, ignoring any pre-release
+// or build suffix. Missing components count as zero.
+func compareVersions(a, b string) int {
+	as := strings.Split(strings.FieldsFunc(a, isVersionSuffix)[0], ".")
+	bs := strings.Split(strings.FieldsFunc(b, isVersionSuffix)[0], ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+func isVersionSuffix(r rune) bool { return r == '-' || r == '+' }
+
+// platformLimit overrides the per-minute rate limit for one platform,
+// optionally restricted to app versions below (<) or equal to (@) version.
+type platformLimit struct {
+	platform  string
+	op        string
+	version   string
+	rateLimit int64
+}
+
+func (l platformLimit) matches(p clientPlatform) bool {
+	if l.platform != p.Name {
+		return false
+	}
+	switch l.op {
+	case "<":
+		return p.AppVersion != "" && compareVersions(p.AppVersion, l.version) < 0
+	case "@":
+		return p.AppVersion == l.version
+	default:
+		return true
+	}
+}
+
+// parsePlatformLimits parses a comma-separated list of
+// platform[<version|@version]=rate_limit_per_minute rules, e.g.
+// "android<2.3.0=1,ios@4.0.1=2,web=20". The first matching rule wins.
+func parsePlatformLimits(spec string) ([]platformLimit, error) {
+	var limits []platformLimit
+	for _, rule := range strings.Split(spec, ",") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+		target, value, ok := strings.Cut(rule, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid platform limit %q: expected platform[<version|@version]=rate_limit", rule)
+		}
+		rate, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil || rate < 0 {
+			return nil, fmt.Errorf("invalid platform limit %q: rate limit must be a non-negative integer", rule)
+		}
+
+		l := platformLimit{platform: strings.ToLower(strings.TrimSpace(target)), rateLimit: rate}
+		if i := strings.IndexAny(l.platform, "<@"); i >= 0 {
+			l.platform, l.op, l.version = l.platform[:i], l.platform[i:i+1], strings.TrimSpace(l.platform[i+1:])
+			if !appVersionPattern.MatchString(l.version) {
+				return nil, fmt.Errorf("invalid platform limit %q: bad version %q", rule, l.version)
+			}
+		}
+		l.platform = strings.TrimSpace(l.platform)
+		if !knownPlatforms[l.platform] && l.platform != platformUnknown {
+			return nil, fmt.Errorf("invalid platform limit %q: unknown platform %q", rule, l.platform)
+		}
+		limits = append(limits, l)
+	}
+	return limits, nil
+}
+
+// platformRateLimit returns the rate limit override for p, if any.
+func platformRateLimit(limits []platformLimit, p clientPlatform) (int64, bool) {
+	for _, l := range limits {
+		if l.matches(p) {
+			return l.rateLimit, true
+		}
+	}
+	return 0, false
+}
+
+// platformCounts counts created sessions by platform and app version.
+type platformCounts struct {
+	mu     sync.Mutex
+	counts map[string]map[string]int64
+}
+
+func newPlatformCounts() *platformCounts {
+	return &platformCounts{counts: make(map[string]map[string]int64)}
+}
+
+func (c *platformCounts) record(p clientPlatform) {
+	version := p.AppVersion
+	if version == "" {
+		version = platformUnknown
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	versions := c.counts[p.Name]
+	if versions == nil {
+		versions = make(map[string]int64)
+		c.counts[p.Name] = versions
+	}
+	if _, ok := versions[version]; !ok && len(versions) >= maxPlatformVersions {
+		version = "other"
+	}
+	versions[version]++
+}
+
+type platformCount struct {
+	Platform   string `json:"platform"`
+	AppVersion string `json:"app_version"`
+	Sessions   int64  `json:"sessions"`
+}
+
+func (c *platformCounts) snapshot() []platformCount {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := []platformCount{}
+	for platform, versions := range c.counts {
+		for version, n := range versions {
+			out = append(out, platformCount{Platform: platform, AppVersion: version, Sessions: n})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Platform != out[j].Platform {
+			return out[i].Platform < out[j].Platform
+		}
+		return out[i].AppVersion < out[j].AppVersion
+	})
+	return out
+}
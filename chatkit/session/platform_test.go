@@ -0,0 +1,114 @@
+package session
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestResolveClientPlatform(t *testing.T) {
+	p, problems := resolveClientPlatform(sessionRequest{Platform: "Android", AppVersion: "2.2.9"}, "")
+	if len(problems) != 0 || p != (clientPlatform{Name: platformAndroid, AppVersion: "2.2.9"}) {
+		t.Fatalf("unexpected result: %+v %v", p, problems)
+	}
+
+	p, _ = resolveClientPlatform(sessionRequest{}, "MyApp/1.0 CFNetwork/1410 Darwin/22.6.0")
+	if p.Name != platformIOS {
+		t.Fatalf("expected ios from user agent, got %s", p.Name)
+	}
+	p, _ = resolveClientPlatform(sessionRequest{}, "curl/8.0")
+	if p.Name != platformUnknown {
+		t.Fatalf("expected unknown platform, got %s", p.Name)
+	}
+
+	_, problems = resolveClientPlatform(sessionRequest{Platform: "symbian", AppVersion: "latest"}, "")
+	if !hasFieldError(problems, "platform") || !hasFieldError(problems, "app_version") {
+		t.Fatalf("expected platform and app_version errors, got %v", problems)
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want int
+	}{
+		{"2.2.9", "2.3.0", -1},
+		{"2.10", "2.9", 1},
+		{"2.3", "2.3.0", 0},
+		{"2.3.0-beta.1", "2.3.0", 0},
+	}
+	for _, c := range cases {
+		if got := compareVersions(c.a, c.b); got != c.want {
+			t.Fatalf("compareVersions(%q, %q) = %d, want %d", c.a, c.b, got, c.want)
+		}
+	}
+}
+
+func TestParsePlatformLimits(t *testing.T) {
+	limits, err := parsePlatformLimits("android<2.3.0=1, ios@4.0.1=2, web=20")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cases := []struct {
+		platform clientPlatform
+		want     int64
+		ok       bool
+	}{
+		{clientPlatform{Name: platformAndroid, AppVersion: "2.2.9"}, 1, true},
+		{clientPlatform{Name: platformAndroid, AppVersion: "2.3.0"}, 0, false},
+		{clientPlatform{Name: platformAndroid}, 0, false},
+		{clientPlatform{Name: platformIOS, AppVersion: "4.0.1"}, 2, true},
+		{clientPlatform{Name: platformWeb}, 20, true},
+	}
+	for _, c := range cases {
+		got, ok := platformRateLimit(limits, c.platform)
+		if got != c.want || ok != c.ok {
+			t.Fatalf("platformRateLimit(%+v) = %d, %v; want %d, %v", c.platform, got, ok, c.want, c.ok)
+		}
+	}
+
+	for _, spec := range []string{"android", "android=x", "symbian=1", "ios<latest=1"} {
+		if _, err := parsePlatformLimits(spec); err == nil {
+			t.Fatalf("expected error for %q", spec)
+		}
+	}
+}
+
+func TestHandleSessionAppliesPlatformLimit(t *testing.T) {
+	fake := &fakeSessionCreator{clientSecret: "secret"}
+	handler := newSessionHandler(fake.Create, "w", 1200, 10)
+	handler.platformLimits, _ = parsePlatformLimits("android<2.3.0=1")
+
+	body := `{"user":"u","platform":"android","app_version":"2.2.9"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/chatkit/session", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	handler.handleSession(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := fake.params.RateLimits.MaxRequestsPer1Minute.Value; got != 1 {
+		t.Fatalf("expected platform rate limit 1, got %d", got)
+	}
+	counts := handler.platforms.snapshot()
+	if len(counts) != 1 || counts[0] != (platformCount{Platform: platformAndroid, AppVersion: "2.2.9", Sessions: 1}) {
+		t.Fatalf("unexpected platform counts: %+v", counts)
+	}
+}
+
+func TestHandleSessionRejectsInvalidPlatform(t *testing.T) {
+	fake := &fakeSessionCreator{clientSecret: "secret"}
+	handler := newSessionHandler(fake.Create, "w", 1200, 10)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/chatkit/session", strings.NewReader(`{"user":"u","platform":"symbian"}`))
+	rec := httptest.NewRecorder()
+	handler.handleSession(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d", rec.Code)
+	}
+	if fake.called {
+		t.Fatalf("session creator should not be called")
+	}
+}
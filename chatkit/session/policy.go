@@ -0,0 +1,184 @@
+package session
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"openai-chatkit-backend/chatkit/cors"
+)
+
+const (
+	policyPass = "pass"
+	policyDeny = "deny"
+	policySkip = "skip"
+)
+
+// policyQuery describes a hypothetical session request.
+type policyQuery struct {
+	User       string
+	Origin     string
+	IP         string
+	Device     string
+	Tenant     string
+	Platform   string
+	AppVersion string
+	Sandbox    bool
+}
+
+func policyQueryFromRequest(r *http.Request) policyQuery {
+	q := r.URL.Query()
+	return policyQuery{
+		User:       q.Get("user"),
+		Origin:     q.Get("origin"),
+		IP:         q.Get("ip"),
+		Device:     q.Get("device"),
+		Tenant:     q.Get("tenant"),
+		Platform:   q.Get("platform"),
+		AppVersion: q.Get("app_version"),
+		Sandbox:    q.Get("profile") == "sandbox",
+	}
+}
+
+// policyStep is one check in a policy decision trace.
+type policyStep struct {
+	Step    string `json:"step"`
+	Outcome string `json:"outcome"`
+	Detail  string `json:"detail,omitempty"`
+}
+
+// policyDecision is the outcome of evaluating every session policy for a
+// hypothetical request, in the order handleSession applies them. Unlike a
+// real request, evaluation continues past the first denial so that the
+// trace shows every reason a caller would be blocked.
+type policyDecision struct {
+	Allowed             bool         `json:"allowed"`
+	User                string       `json:"user"`
+	Profile             string       `json:"profile"`
+	WorkflowID          string       `json:"workflow_id"`
+	ExpiresAfterSeconds int64        `json:"expires_after_seconds"`
+	RateLimitPerMinute  int64        `json:"rate_limit_per_minute"`
+	Trace               []policyStep `json:"trace"`
+}
+
+func (d *policyDecision) add(step, outcome, format string, args ...any) {
+	d.Trace = append(d.Trace, policyStep{Step: step, Outcome: outcome, Detail: fmt.Sprintf(format, args...)})
+	if outcome == policyDeny {
+		d.Allowed = false
+	}
+}
+
+// simulatePolicy evaluates q without side effects: nothing is reserved,
+// recorded, or sent upstream.
+func (h *sessionHandler) simulatePolicy(q policyQuery, cors *cors.Policy, now time.Time) policyDecision {
+	d := policyDecision{Allowed: true, User: q.User}
+
+	switch {
+	case q.Origin == "":
+		d.add("cors", policySkip, "no origin given")
+	case cors == nil:
+		d.add("cors", policySkip, "CORS policy not available")
+	default:
+		if _, ok := cors.Allow(q.Origin); ok {
+			d.add("cors", policyPass, "origin %s is allowed", q.Origin)
+		} else {
+			d.add("cors", policyDeny, "origin %s is not in CORS_ALLOWED_ORIGINS", q.Origin)
+		}
+	}
+
+	if q.User == "" {
+		d.add("validation", policyDeny, "user is required")
+	}
+	platform, problems := resolveClientPlatform(sessionRequest{Platform: q.Platform, AppVersion: q.AppVersion}, "")
+	for _, p := range problems {
+		d.add("validation", policyDeny, "%s", p.Message)
+	}
+
+	switch {
+	case h.bans == nil:
+		d.add("bans", policySkip, "ban list not configured")
+	case h.bans.banned(q.User, q.IP, q.Device):
+		d.add("bans", policyDeny, "user, IP, or device is banned")
+	default:
+		d.add("bans", policyPass, "not banned")
+	}
+
+	if h.serviceHours == nil {
+		d.add("service_hours", policySkip, "service hours not configured")
+	} else if open, next := h.serviceHours.check(q.Tenant); open {
+		d.add("service_hours", policyPass, "open for tenant %q", q.Tenant)
+	} else if next.IsZero() {
+		d.add("service_hours", policyDeny, "closed for tenant %q", q.Tenant)
+	} else {
+		d.add("service_hours", policyDeny, "closed for tenant %q until %s", q.Tenant, next.Format(time.RFC3339))
+	}
+
+	if h.maintenance == nil {
+		d.add("maintenance", policySkip, "maintenance mode not configured")
+	} else if on, message, _ := h.maintenance.active(now); on {
+		d.add("maintenance", policyDeny, "maintenance mode is on: %s", message)
+	} else {
+		d.add("maintenance", policyPass, "maintenance mode is off")
+	}
+
+	if q.Sandbox && h.sandbox == nil {
+		d.add("profile", policyDeny, "sandbox profile requested but CHATKIT_SANDBOX_API_KEYS is not set")
+	}
+	settings := h.profileSettings(q.Sandbox, platform)
+	if features := h.originPolicy.match(q.Origin); features != nil {
+		features.restrict(&settings)
+		d.add("origin_policy", policyPass, "feature policy for %s applies", features.Origin)
+	} else {
+		d.add("origin_policy", policySkip, "no feature policy for the origin")
+	}
+	d.Profile = settings.profile
+	d.WorkflowID = settings.workflowID
+	d.ExpiresAfterSeconds = settings.expiresAfterSeconds
+	d.RateLimitPerMinute = settings.rateLimitPerMinute
+	d.add("profile", policyPass, "%s profile", settings.profile)
+
+	if h.suspensions == nil {
+		d.add("suspension", policySkip, "suspensions not configured")
+	} else if s := h.suspensions.check(q.Tenant, settings.workflowID, now); s != nil {
+		d.add("suspension", policyDeny, "%s %q is suspended: %s", s.Kind, s.Value, s.message())
+	} else {
+		d.add("suspension", policyPass, "tenant and workflow are not suspended")
+	}
+
+	if settings.profile == "default" {
+		if rate, ok := platformRateLimit(h.platformLimits, platform); ok {
+			d.add("rate_limit", policyPass, "platform override for %s %s: %d per minute", platform.Name, platform.AppVersion, rate)
+		} else {
+			d.add("rate_limit", policyPass, "%d per minute", settings.rateLimitPerMinute)
+		}
+	} else {
+		d.add("rate_limit", policyPass, "%s profile: %d per minute", settings.profile, settings.rateLimitPerMinute)
+	}
+
+	if settings.quota == nil {
+		d.add("quota", policySkip, "no quota for the %s profile", settings.profile)
+	} else if status := settings.quota.peek(q.User); status.remaining() == 0 {
+		d.add("quota", policyDeny, "%d of %d sessions used; resets at %s", status.used, status.limit, status.resetAt.UTC().Format(time.RFC3339))
+	} else {
+		d.add("quota", policyPass, "%d of %d sessions used", status.used, status.limit)
+	}
+
+	if settings.activeSessions == nil {
+		d.add("active_sessions", policySkip, "no active session cap for the %s profile", settings.profile)
+	} else if n := settings.activeSessions.peek(q.User); n >= settings.activeSessions.limit {
+		d.add("active_sessions", policyDeny, "%d of %d active sessions", n, settings.activeSessions.limit)
+	} else {
+		d.add("active_sessions", policyPass, "%d of %d active sessions", n, settings.activeSessions.limit)
+	}
+
+	if settings.breaker == nil {
+		d.add("circuit_breaker", policySkip, "not applied")
+	} else if open, retryAt := settings.breaker.state(); open {
+		d.add("circuit_breaker", policyDeny, "circuit open until %s", retryAt.UTC().Format(time.RFC3339))
+	} else {
+		d.add("circuit_breaker", policyPass, "circuit closed")
+	}
+
+	d.add("workflow", policyPass, "routes to %s", settings.workflowID)
+	return d
+}
@@ -0,0 +1,89 @@
+package session
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSimulatePolicyTracesEveryDenial(t *testing.T) {
+	handler := newSessionHandler(nil, "wf_default", 1200, 10)
+	handler.bans, _ = newBanList("")
+	_ = handler.bans.add(banKindDevice, "dev-1")
+	handler.quota = newQuotaTracker(1, 80, time.Hour)
+	handler.quota.reserve("u")
+	handler.platformLimits, _ = parsePlatformLimits("android<2.3.0=1")
+	cors := newCORSPolicy("https://app.example.com")
+
+	d := handler.simulatePolicy(policyQuery{
+		User:       "u",
+		Origin:     "https://evil.example.com",
+		Device:     "dev-1",
+		Platform:   "android",
+		AppVersion: "2.2.0",
+	}, &cors, time.Now())
+
+	if d.Allowed {
+		t.Fatalf("expected request to be denied")
+	}
+	outcomes := map[string]string{}
+	for _, step := range d.Trace {
+		outcomes[step.Step] = step.Outcome
+	}
+	for step, want := range map[string]string{
+		"cors":            policyDeny,
+		"bans":            policyDeny,
+		"quota":           policyDeny,
+		"service_hours":   policySkip,
+		"rate_limit":      policyPass,
+		"circuit_breaker": policySkip,
+		"workflow":        policyPass,
+	} {
+		if outcomes[step] != want {
+			t.Fatalf("step %s: expected %s, got %s (trace %+v)", step, want, outcomes[step], d.Trace)
+		}
+	}
+	if d.RateLimitPerMinute != 1 || d.WorkflowID != "wf_default" || d.Profile != "default" {
+		t.Fatalf("unexpected settings: %+v", d)
+	}
+	if status := handler.quota.peek("u"); status.used != 1 {
+		t.Fatalf("simulation must not reserve quota, used=%d", status.used)
+	}
+}
+
+func TestAdminPolicyEndpoint(t *testing.T) {
+	bans, _ := newBanList("")
+	admin := newAdminHandler("s3cret", bans, newConfigDriftDetector(nil, ""))
+	sessions := newSessionHandler(nil, "wf_default", 1200, 10)
+	sessions.sandbox = newSandboxProfile("sk_sandbox", "wf_sandbox", 300, 2, mockSessionCreator)
+	admin.sessions = sessions
+	router, err := newRouter(sessions, admin, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set("Authorization", "Bearer s3cret")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := get("/admin/policy"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 without user, got %d", rec.Code)
+	}
+	rec := get("/admin/policy?user=u&profile=sandbox")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	var d policyDecision
+	if err := json.Unmarshal(rec.Body.Bytes(), &d); err != nil {
+		t.Fatal(err)
+	}
+	if !d.Allowed || d.Profile != "sandbox" || d.WorkflowID != "wf_sandbox" || d.RateLimitPerMinute != 2 {
+		t.Fatalf("unexpected decision: %+v", d)
+	}
+}
@@ -0,0 +1,256 @@
+package session
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"openai-chatkit-backend/chatkit/redis"
+)
+
+const (
+	prefsPath = "/v1/chatkit/prefs"
+	// prefWorkflow is the preference that selects a workflow alias. It is
+	// not passed to the workflow as a state variable.
+	prefWorkflow = "workflow"
+
+	maxPrefs          = 20
+	maxPrefValueBytes = 256
+)
+
+// prefKeyPattern keeps preference names usable as state variable names.
+var prefKeyPattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,39}$`)
+
+// userPrefs are a user's saved chat preferences, such as a language or an
+// opt-out, as string key-value pairs.
+type userPrefs struct {
+	Prefs     map[string]string `json:"prefs"`
+	UpdatedAt *time.Time        `json:"updated_at,omitempty"`
+}
+
+// prefsStore persists userPrefs by scoped user key.
+type prefsStore interface {
+	// Get returns the saved preferences, or zero userPrefs when there are
+	// none.
+	Get(ctx context.Context, key string) (userPrefs, error)
+	Put(ctx context.Context, key string, prefs userPrefs) error
+}
+
+// prefsKey scopes a user's preferences to their tenant, so that users of
+// different tenants with the same name never share them.
+func prefsKey(tenant, user string) string {
+	sum := sha256.Sum256([]byte(tenant + "\x00" + user))
+	return hex.EncodeToString(sum[:])
+}
+
+// localPrefsStore keeps preferences in memory, optionally persisting every
+// change to a JSON file like the ban list.
+type localPrefsStore struct {
+	path string
+
+	mu      sync.RWMutex
+	entries map[string]userPrefs
+}
+
+// newLocalPrefsStore loads preferences from path when it exists. An empty
+// path keeps them in memory only.
+func newLocalPrefsStore(path string) (*localPrefsStore, error) {
+	s := &localPrefsStore{path: path, entries: make(map[string]userPrefs)}
+	if path == "" {
+		return s, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.entries); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return s, nil
+}
+
+func (s *localPrefsStore) Get(ctx context.Context, key string) (userPrefs, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.entries[key], nil
+}
+
+func (s *localPrefsStore) Put(ctx context.Context, key string, prefs userPrefs) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(prefs.Prefs) == 0 {
+		delete(s.entries, key)
+	} else {
+		s.entries[key] = prefs
+	}
+	if s.path == "" {
+		return nil
+	}
+	return writeJSONFile(s.path, s.entries)
+}
+
+// redisPrefsStore shares preferences between replicas.
+type redisPrefsStore struct {
+	client *redis.Client
+}
+
+func (s *redisPrefsStore) Get(ctx context.Context, key string) (userPrefs, error) {
+	reply, err := s.client.Do(ctx, "GET", s.client.Key("prefs", key))
+	if err != nil || reply == nil {
+		return userPrefs{}, err
+	}
+	data, _ := reply.(string)
+	var prefs userPrefs
+	if err := json.Unmarshal([]byte(data), &prefs); err != nil {
+		return userPrefs{}, fmt.Errorf("invalid stored preferences: %w", err)
+	}
+	return prefs, nil
+}
+
+func (s *redisPrefsStore) Put(ctx context.Context, key string, prefs userPrefs) error {
+	if len(prefs.Prefs) == 0 {
+		_, err := s.client.Do(ctx, "DEL", s.client.Key("prefs", key))
+		return err
+	}
+	data, err := json.Marshal(prefs)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.Do(ctx, "SET", s.client.Key("prefs", key), string(data))
+	return err
+}
+
+// prefsRequest is the body of PUT /v1/chatkit/prefs.
+type prefsRequest struct {
+	Prefs map[string]string `json:"prefs"`
+}
+
+// validatePrefs checks preference names and sizes, and that a workflow
+// preference names a known workflow alias.
+func (h *sessionHandler) validatePrefs(prefs map[string]string) []fieldError {
+	var problems []fieldError
+	if len(prefs) > maxPrefs {
+		problems = append(problems, fieldError{Field: "prefs", Code: validationCodeInvalidValue, Message: fmt.Sprintf("at most %d preferences are allowed", maxPrefs)})
+	}
+	keys := make([]string, 0, len(prefs))
+	for key := range prefs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		field := "prefs." + key
+		switch {
+		case !prefKeyPattern.MatchString(key):
+			problems = append(problems, fieldError{Field: field, Code: validationCodeInvalidValue, Message: "preference names must be lowercase letters, digits, and _, starting with a letter"})
+		case len(prefs[key]) > maxPrefValueBytes:
+			problems = append(problems, fieldError{Field: field, Code: validationCodeInvalidValue, Message: fmt.Sprintf("%s must be at most %d bytes", key, maxPrefValueBytes)})
+		case key == prefWorkflow && h.workflowAliases[prefs[key]].ID == "":
+			problems = append(problems, fieldError{Field: field, Code: validationCodeInvalidValue, Message: fmt.Sprintf("workflow %q is not a known workflow alias", prefs[key])})
+		}
+	}
+	return problems
+}
+
+// prefsUser resolves the user for a preferences request and its store key.
+// It writes the error response and reports false when there is none,
+// including for callers without credentials.
+func (h *sessionHandler) prefsUser(w http.ResponseWriter, r *http.Request) (string, string, bool) {
+	if !authenticatedCaller(r) {
+		writeUnauthenticated(w)
+		return "", "", false
+	}
+	user, _, err := h.resolveUser(r.Context(), r.URL.Query().Get("user"))
+	if err != nil {
+		slog.WarnContext(r.Context(), "identity mapping failed", "error", err)
+		http.Error(w, "identity mapping failed", http.StatusForbidden)
+		return "", "", false
+	}
+	if user == "" {
+		writeValidationErrors(w, []fieldError{{Field: "user", Code: validationCodeRequired, Message: "user is required"}})
+		return "", "", false
+	}
+	return user, prefsKey(requestTenant(r.Context()), user), true
+}
+
+func (h *sessionHandler) getPrefs(w http.ResponseWriter, r *http.Request) {
+	_, key, ok := h.prefsUser(w, r)
+	if !ok {
+		return
+	}
+	prefs, err := h.prefs.Get(r.Context(), key)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to read preferences", "error", err)
+		http.Error(w, "failed to read preferences", http.StatusServiceUnavailable)
+		return
+	}
+	if prefs.Prefs == nil {
+		prefs.Prefs = map[string]string{}
+	}
+	w.Header().Set("Cache-Control", "private, no-store")
+	writeJSON(w, http.StatusOK, prefs)
+}
+
+// putPrefs replaces the user's preferences. An empty prefs object deletes
+// them.
+func (h *sessionHandler) putPrefs(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	_, key, ok := h.prefsUser(w, r)
+	if !ok {
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodyBytes*2)
+	var req prefsRequest
+	problems, err := decodeJSONObject(r.Body, &req)
+	if err != nil {
+		http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	problems = append(problems, h.validatePrefs(req.Prefs)...)
+	if len(problems) > 0 {
+		writeValidationErrors(w, problems)
+		return
+	}
+	prefs := userPrefs{Prefs: req.Prefs}
+	if len(req.Prefs) > 0 {
+		now := h.clock.Now().UTC()
+		prefs.UpdatedAt = &now
+	}
+	if err := h.prefs.Put(r.Context(), key, prefs); err != nil {
+		slog.ErrorContext(r.Context(), "failed to save preferences", "error", err)
+		http.Error(w, "failed to save preferences", http.StatusServiceUnavailable)
+		return
+	}
+	if prefs.Prefs == nil {
+		prefs.Prefs = map[string]string{}
+	}
+	w.Header().Set("Cache-Control", "private, no-store")
+	writeJSON(w, http.StatusOK, prefs)
+}
+
+// sessionPrefs returns the saved preferences to apply to a new session.
+// Preferences only shape sessions, so a store failure is logged and the
+// session is created without them.
+func (h *sessionHandler) sessionPrefs(r *http.Request, user string) map[string]string {
+	if h.prefs == nil {
+		return nil
+	}
+	prefs, err := h.prefs.Get(r.Context(), prefsKey(requestTenant(r.Context()), user))
+	if err != nil {
+		slog.WarnContext(r.Context(), "failed to read preferences; creating the session without them", "error", err)
+		return nil
+	}
+	return prefs.Prefs
+}
@@ -0,0 +1,163 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPrefsRoundTrip(t *testing.T) {
+	handler := newSessionHandler((&fakeSessionCreator{}).Create, "w", 1200, 10)
+	handler.prefs, _ = newLocalPrefsStore("")
+	handler.workflowAliases = map[string]workflowRef{"support": {ID: "wf_support"}}
+
+	put := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.putPrefs(rec, asPrefsUser(httptest.NewRequest(http.MethodPut, prefsPath, strings.NewReader(body))))
+		return rec
+	}
+	get := func() userPrefs {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		handler.getPrefs(rec, asPrefsUser(httptest.NewRequest(http.MethodGet, prefsPath, nil)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		var prefs userPrefs
+		if err := json.NewDecoder(rec.Body).Decode(&prefs); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		return prefs
+	}
+
+	if prefs := get(); len(prefs.Prefs) != 0 || prefs.UpdatedAt != nil {
+		t.Fatalf("expected no preferences, got %+v", prefs)
+	}
+	if rec := put(`{"prefs":{"language":"fr","workflow":"support"}}`); rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if prefs := get(); prefs.Prefs["language"] != "fr" || prefs.Prefs["workflow"] != "support" || prefs.UpdatedAt == nil {
+		t.Fatalf("unexpected preferences: %+v", prefs)
+	}
+
+	for _, body := range []string{
+		`{"prefs":{"Language":"fr"}}`,
+		`{"prefs":{"workflow":"unknown"}}`,
+		`{"prefs":{"language":"` + strings.Repeat("x", maxPrefValueBytes+1) + `"}}`,
+		`{"prefs":{"language":"fr"},"extra":true}`,
+	} {
+		if rec := put(body); rec.Code != http.StatusBadRequest {
+			t.Fatalf("expected %s to be rejected, got %d", body, rec.Code)
+		}
+	}
+	if prefs := get(); prefs.Prefs["language"] != "fr" {
+		t.Fatalf("expected rejected updates to keep the preferences, got %+v", prefs)
+	}
+
+	if rec := put(`{"prefs":{}}`); rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rec.Code)
+	}
+	if prefs := get(); len(prefs.Prefs) != 0 {
+		t.Fatalf("expected an empty object to delete the preferences, got %+v", prefs)
+	}
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, prefsPath, nil)
+	handler.getPrefs(rec, req.WithContext(context.WithValue(req.Context(), tenantContextKey{}, &tenant{name: "acme"})))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected a missing user to be rejected, got %d", rec.Code)
+	}
+}
+
+// asPrefsUser authenticates r as the user u.
+func asPrefsUser(r *http.Request) *http.Request {
+	return r.WithContext(ContextWithIdentity(r.Context(), Identity{Subject: "u"}))
+}
+
+func TestPrefsRequireCredentials(t *testing.T) {
+	handler := newSessionHandler((&fakeSessionCreator{}).Create, "w", 1200, 10)
+	handler.prefs, _ = newLocalPrefsStore("")
+	if err := handler.prefs.Put(context.Background(), prefsKey("", "victim"), userPrefs{Prefs: map[string]string{"language": "fr"}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	handler.getPrefs(rec, httptest.NewRequest(http.MethodGet, prefsPath+"?user=victim", nil))
+	if rec.Code != http.StatusUnauthorized || strings.Contains(rec.Body.String(), "fr") {
+		t.Fatalf("expected 401 without credentials, got %d: %s", rec.Code, rec.Body.String())
+	}
+	rec = httptest.NewRecorder()
+	handler.putPrefs(rec, httptest.NewRequest(http.MethodPut, prefsPath+"?user=victim", strings.NewReader(`{"prefs":{"language":"de"}}`)))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without credentials, got %d", rec.Code)
+	}
+	if prefs, _ := handler.prefs.Get(context.Background(), prefsKey("", "victim")); prefs.Prefs["language"] != "fr" {
+		t.Fatalf("expected the preferences to be unchanged, got %+v", prefs)
+	}
+}
+
+func TestLocalPrefsStorePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "prefs.json")
+	store, err := newLocalPrefsStore(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	key := prefsKey("acme", "u")
+	if err := store.Put(context.Background(), key, userPrefs{Prefs: map[string]string{"language": "de"}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	reloaded, err := newLocalPrefsStore(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if prefs, _ := reloaded.Get(context.Background(), key); prefs.Prefs["language"] != "de" {
+		t.Fatalf("expected persisted preferences, got %+v", prefs)
+	}
+	if prefs, _ := reloaded.Get(context.Background(), prefsKey("", "u")); prefs.Prefs != nil {
+		t.Fatalf("expected preferences to be scoped to the tenant, got %+v", prefs)
+	}
+}
+
+func TestHandleSessionAppliesPrefs(t *testing.T) {
+	fake := &fakeSessionCreator{clientSecret: "secret"}
+	handler := newSessionHandler(fake.Create, "w", 1200, 10)
+	handler.prefs, _ = newLocalPrefsStore("")
+	handler.workflowAliases = map[string]workflowRef{"support": {ID: "wf_support"}}
+	handler.allowedWorkflows = map[string]bool{"wf_other": true}
+	prefs := map[string]string{"language": "fr", "workflow": "support"}
+	if err := handler.prefs.Put(context.Background(), prefsKey("", "u"), userPrefs{Prefs: prefs}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	handler.handleSession(rec, httptest.NewRequest(http.MethodPost, defaultSessionPath, strings.NewReader(`{"user":"u"}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if fake.params.Workflow.ID != "wf_support" {
+		t.Fatalf("expected the preferred workflow, got %q", fake.params.Workflow.ID)
+	}
+	vars := fake.params.Workflow.StateVariables
+	if v := vars["language"].OfString; !v.Valid() || v.Value != "fr" {
+		t.Fatalf("expected a language state variable, got %v", vars)
+	}
+	if _, ok := vars[prefWorkflow]; ok || len(vars) != 1 {
+		t.Fatalf("expected only language as a state variable, got %v", vars)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.handleSession(rec, httptest.NewRequest(http.MethodPost, defaultSessionPath, strings.NewReader(`{"user":"u","workflow_id":"wf_other"}`)))
+	if rec.Code != http.StatusOK || fake.params.Workflow.ID != "wf_other" {
+		t.Fatalf("expected an explicit workflow_id to win, got %d %q", rec.Code, fake.params.Workflow.ID)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.handleSession(rec, httptest.NewRequest(http.MethodPost, defaultSessionPath, strings.NewReader(`{"user":"other"}`)))
+	if rec.Code != http.StatusOK || fake.params.Workflow.ID != "w" || len(fake.params.Workflow.StateVariables) != 0 {
+		t.Fatalf("expected other users to be unaffected, got %q %v", fake.params.Workflow.ID, fake.params.Workflow.StateVariables)
+	}
+}
@@ -0,0 +1,170 @@
+package session
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"openai-chatkit-backend/chatkit/auth"
+)
+
+const defaultProjectTierClaim = "tier"
+
+// openAIProjectConfig is an OpenAI project sessions can be routed to. An
+// empty api_key uses OPENAI_API_KEY.
+type openAIProjectConfig struct {
+	APIKey       string `json:"api_key"`
+	Project      string `json:"project"`
+	Organization string `json:"organization"`
+}
+
+// projectRouteConfig sends sessions matching every set field to project.
+// Environment matches CHATKIT_ENVIRONMENT, so one file can serve every
+// deployment.
+type projectRouteConfig struct {
+	Environment string `json:"environment"`
+	Tier        string `json:"tier"`
+	Tenant      string `json:"tenant"`
+	Project     string `json:"project"`
+}
+
+// projectRoutingConfig is the file format of CHATKIT_OPENAI_PROJECTS_FILE.
+type projectRoutingConfig struct {
+	Projects map[string]openAIProjectConfig `json:"projects"`
+	Routes   []projectRouteConfig           `json:"routes"`
+	// Default names the project for sessions no route matches. When empty,
+	// they use the server's own OpenAI client.
+	Default string `json:"default"`
+}
+
+// openAIProject is a routing target with its session counters.
+type openAIProject struct {
+	name          string
+	createSession sessionCreator
+
+	created     atomic.Int64
+	failed      atomic.Int64
+	totalMillis atomic.Int64
+}
+
+// record counts one session mint that took elapsed.
+func (p *openAIProject) record(elapsed time.Duration, err error) {
+	if err != nil {
+		p.failed.Add(1)
+		return
+	}
+	p.created.Add(1)
+	p.totalMillis.Add(elapsed.Milliseconds())
+}
+
+// projectStats is the admin view of one project.
+type projectStats struct {
+	Name             string `json:"name"`
+	SessionsCreated  int64  `json:"sessions_created"`
+	SessionsFailed   int64  `json:"sessions_failed"`
+	AverageLatencyMS int64  `json:"average_latency_ms"`
+	Default          bool   `json:"default"`
+}
+
+// projectRouter picks the OpenAI project a session is created in from the
+// caller's tenant and tier, so that each class of traffic is billed and
+// rate limited by OpenAI separately. The tier is read from the tierClaim
+// claim of the verified identity.
+type projectRouter struct {
+	environment string
+	tierClaim   string
+	projects    map[string]*openAIProject
+	routes      []projectRouteConfig
+	fallback    *openAIProject
+}
+
+// loadProjectRouter reads the routing config from the JSON file at path, or
+// from inline JSON when path is empty. It returns nil when neither is set.
+// environment is the deployment's CHATKIT_ENVIRONMENT. newCreator returns
+// the session creator for a project.
+func loadProjectRouter(path, inline, environment, tierClaim string, newCreator func(openAIProjectConfig) (sessionCreator, error)) (*projectRouter, error) {
+	source, data := path, []byte(inline)
+	switch {
+	case path != "" && inline != "":
+		return nil, fmt.Errorf("set either a projects file or inline projects, not both")
+	case path != "":
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, err
+		}
+	case inline != "":
+		source = "inline projects"
+	default:
+		return nil, nil
+	}
+
+	var cfg projectRoutingConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", source, err)
+	}
+	if len(cfg.Projects) == 0 {
+		return nil, fmt.Errorf("%s: no projects", source)
+	}
+	if tierClaim == "" {
+		tierClaim = defaultProjectTierClaim
+	}
+	r := &projectRouter{environment: environment, tierClaim: tierClaim, projects: make(map[string]*openAIProject, len(cfg.Projects)), routes: cfg.Routes}
+	for name, pc := range cfg.Projects {
+		create, err := newCreator(pc)
+		if err != nil {
+			return nil, fmt.Errorf("%s: project %q: %w", source, name, err)
+		}
+		r.projects[name] = &openAIProject{name: name, createSession: create}
+	}
+	for i, route := range cfg.Routes {
+		if route.Environment == "" && route.Tier == "" && route.Tenant == "" {
+			return nil, fmt.Errorf("%s: route %d matches every session; use default instead", source, i)
+		}
+		if r.projects[route.Project] == nil {
+			return nil, fmt.Errorf("%s: route %d: unknown project %q", source, i, route.Project)
+		}
+	}
+	if cfg.Default != "" {
+		if r.fallback = r.projects[cfg.Default]; r.fallback == nil {
+			return nil, fmt.Errorf("%s: unknown default project %q", source, cfg.Default)
+		}
+	}
+	return r, nil
+}
+
+// route returns the project for a session of tenant with claims, in route
+// order, falling back to the default project. It returns nil when nothing
+// matches and there is no default.
+func (r *projectRouter) route(tenant string, claims auth.Claims) *openAIProject {
+	tier, _ := claims[r.tierClaim].(string)
+	for _, route := range r.routes {
+		if (route.Environment == "" || route.Environment == r.environment) &&
+			(route.Tier == "" || route.Tier == tier) &&
+			(route.Tenant == "" || route.Tenant == tenant) {
+			return r.projects[route.Project]
+		}
+	}
+	return r.fallback
+}
+
+// snapshot reports every project, sorted by name.
+func (r *projectRouter) snapshot() []projectStats {
+	out := make([]projectStats, 0, len(r.projects))
+	for _, p := range r.projects {
+		s := projectStats{
+			Name:            p.name,
+			SessionsCreated: p.created.Load(),
+			SessionsFailed:  p.failed.Load(),
+			Default:         p == r.fallback,
+		}
+		if s.SessionsCreated > 0 {
+			s.AverageLatencyMS = p.totalMillis.Load() / s.SessionsCreated
+		}
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
@@ -0,0 +1,113 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	openai "github.com/openai/openai-go/v3"
+
+	"openai-chatkit-backend/chatkit/auth"
+)
+
+const testProjects = `{
+	"projects": {"free": {"project": "proj_free"}, "enterprise": {"api_key": "sk-ent", "project": "proj_ent"}, "staging": {}},
+	"routes": [
+		{"environment": "staging", "project": "staging"},
+		{"tier": "enterprise", "project": "enterprise"},
+		{"tenant": "acme", "project": "enterprise"}
+	],
+	"default": "free"
+}`
+
+func TestProjectRouterRoute(t *testing.T) {
+	newCreator := func(openAIProjectConfig) (sessionCreator, error) { return mockSessionCreator, nil }
+	r, err := loadProjectRouter("", testProjects, "production", "", newCreator)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for name, tc := range map[string]struct {
+		tenant string
+		claims auth.Claims
+		want   string
+	}{
+		"tier claim":  {claims: auth.Claims{"tier": "enterprise"}, want: "enterprise"},
+		"tenant":      {tenant: "acme", want: "enterprise"},
+		"no match":    {tenant: "globex", claims: auth.Claims{"tier": "free"}, want: "free"},
+		"no identity": {want: "free"},
+	} {
+		if got := r.route(tc.tenant, tc.claims); got == nil || got.name != tc.want {
+			t.Fatalf("%s: expected %s, got %+v", name, tc.want, got)
+		}
+	}
+
+	staging, err := loadProjectRouter("", testProjects, "staging", "", newCreator)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := staging.route("acme", auth.Claims{"tier": "enterprise"}); got.name != "staging" {
+		t.Fatalf("expected the environment route to win, got %s", got.name)
+	}
+
+	for name, inline := range map[string]string{
+		"no projects":      `{"projects": {}}`,
+		"unknown project":  `{"projects": {"a": {}}, "routes": [{"tier": "x", "project": "b"}]}`,
+		"unknown default":  `{"projects": {"a": {}}, "default": "b"}`,
+		"catch-all route":  `{"projects": {"a": {}}, "routes": [{"project": "a"}]}`,
+		"creator rejected": `{"projects": {"bad": {}}}`,
+	} {
+		_, err := loadProjectRouter("", inline, "", "", func(openAIProjectConfig) (sessionCreator, error) {
+			if name == "creator rejected" {
+				return nil, errors.New("bad project")
+			}
+			return mockSessionCreator, nil
+		})
+		if err == nil {
+			t.Fatalf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestHandleSessionRoutesToProject(t *testing.T) {
+	defaults := &fakeSessionCreator{clientSecret: "default"}
+	handler := newSessionHandler(defaults.Create, "w", 1200, 10)
+	calls := map[string]int{}
+	var err error
+	handler.projects, err = loadProjectRouter("", testProjects, "", "plan", func(p openAIProjectConfig) (sessionCreator, error) {
+		return func(ctx context.Context, params openai.BetaChatKitSessionNewParams) (*openai.ChatSession, error) {
+			calls[p.Project]++
+			if p.Project == "proj_ent" {
+				return nil, errors.New("upstream down")
+			}
+			return &openai.ChatSession{ClientSecret: "s"}, nil
+		}, nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	post := func(claims auth.Claims) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/chatkit/session", strings.NewReader(`{"user":"u"}`))
+		req = req.WithContext(auth.ContextWithClaims(req.Context(), claims))
+		rec := httptest.NewRecorder()
+		handler.handleSession(rec, req)
+		return rec.Code
+	}
+	if code := post(auth.Claims{"sub": "u", "plan": "enterprise"}); code != http.StatusInternalServerError {
+		t.Fatalf("expected the enterprise project's failure, got %d", code)
+	}
+	if code := post(auth.Claims{"sub": "u", "tier": "enterprise"}); code != http.StatusOK {
+		t.Fatalf("expected the default project, got %d", code)
+	}
+	if defaults.called || calls["proj_ent"] != 1 || calls["proj_free"] != 1 {
+		t.Fatalf("unexpected routing: %v, default called %v", calls, defaults.called)
+	}
+
+	stats := handler.projects.snapshot()
+	if len(stats) != 3 || stats[0].Name != "enterprise" || stats[0].SessionsFailed != 1 || stats[1].Name != "free" || stats[1].SessionsCreated != 1 || !stats[1].Default {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
@@ -0,0 +1,186 @@
+package session
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// trustedProxies lists the reverse proxies, such as a load balancer, whose
+// forwarding headers are believed.
+type trustedProxies struct {
+	prefixes []netip.Prefix
+	// unix trusts every peer on a Unix domain socket, which only a local
+	// reverse proxy with access to the socket file can be.
+	unix bool
+}
+
+// parseTrustedProxies parses a comma-separated list of CIDRs, single IPs,
+// and "unix" for Unix domain socket peers.
+func parseTrustedProxies(value string) (trustedProxies, error) {
+	var proxies trustedProxies
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		switch {
+		case entry == "":
+			continue
+		case entry == "unix":
+			proxies.unix = true
+			continue
+		case strings.Contains(entry, "/"):
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return trustedProxies{}, fmt.Errorf("invalid CIDR %q: %w", entry, err)
+			}
+			proxies.prefixes = append(proxies.prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return trustedProxies{}, fmt.Errorf("invalid IP %q: %w", entry, err)
+		}
+		addr = addr.Unmap()
+		proxies.prefixes = append(proxies.prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return proxies, nil
+}
+
+func (p trustedProxies) empty() bool {
+	return len(p.prefixes) == 0 && !p.unix
+}
+
+// trustsPeer reports whether a connection from remote on network, as
+// reported by net.Addr, comes from a trusted proxy.
+func (p trustedProxies) trustsPeer(network, remote string) bool {
+	if network == "unix" {
+		return p.unix
+	}
+	if host, _, err := net.SplitHostPort(remote); err == nil {
+		remote = host
+	}
+	addr, err := netip.ParseAddr(remote)
+	return err == nil && p.contains(addr)
+}
+
+// trustFunc returns trustsPeer for server.NewProxyProtoListener, or nil
+// when no proxy is trusted so that every connection must carry a header.
+func (p trustedProxies) trustFunc() func(network, remote string) bool {
+	if p.empty() {
+		return nil
+	}
+	return p.trustsPeer
+}
+
+func (p trustedProxies) contains(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range p.prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolve returns the client IP of r. Forwarding headers are only read when
+// the connection comes from a trusted proxy, and their hops are walked from
+// the nearest one, skipping trusted proxies, so that a client cannot spoof its
+// address by sending the headers itself. Forwarded is preferred, then
+// X-Forwarded-For, then X-Real-IP.
+func (p trustedProxies) resolve(r *http.Request) string {
+	remote := clientIP(r)
+	network := "tcp"
+	if local, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		network = local.Network()
+	}
+	if !p.trustsPeer(network, remote) {
+		return remote
+	}
+	if hops := forwardedFor(r.Header.Values("Forwarded")); len(hops) > 0 {
+		return p.firstUntrusted(hops, remote)
+	}
+	if hops := splitHeaderList(r.Header.Values("X-Forwarded-For")); len(hops) > 0 {
+		return p.firstUntrusted(hops, remote)
+	}
+	if ip, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+		return ip.Unmap().String()
+	}
+	return remote
+}
+
+// firstUntrusted walks hops from the last one and returns the first address
+// that is not a trusted proxy. A malformed hop ends the walk, since nothing
+// before it can be trusted either; remote is returned when every hop is
+// trusted.
+func (p trustedProxies) firstUntrusted(hops []string, remote string) string {
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := parseHop(hops[i])
+		if err != nil {
+			return remote
+		}
+		remote = addr.String()
+		if !p.contains(addr) {
+			break
+		}
+	}
+	return remote
+}
+
+// parseHop parses one forwarding hop, which may carry a port and, for IPv6,
+// brackets.
+func parseHop(hop string) (netip.Addr, error) {
+	hop = strings.TrimSpace(hop)
+	if host, _, err := net.SplitHostPort(hop); err == nil {
+		hop = host
+	}
+	addr, err := netip.ParseAddr(strings.Trim(hop, "[]"))
+	return addr.Unmap(), err
+}
+
+// forwardedFor returns the for= parameters of RFC 7239 Forwarded headers, in
+// order.
+func forwardedFor(values []string) []string {
+	var hops []string
+	for _, element := range splitHeaderList(values) {
+		for _, pair := range strings.Split(element, ";") {
+			key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if ok && strings.EqualFold(key, "for") {
+				hops = append(hops, strings.Trim(value, `"`))
+			}
+		}
+	}
+	return hops
+}
+
+func splitHeaderList(values []string) []string {
+	var items []string
+	for _, v := range values {
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+	}
+	return items
+}
+
+// withTrustedProxies replaces the remote address of requests relayed by a
+// trusted proxy with the client's, so that rate limits, bans, and logs see
+// the real caller, and adds it to the access log line as client_ip. It is a
+// no-op without proxies.
+func withTrustedProxies(proxies trustedProxies, next http.Handler) http.Handler {
+	if proxies.empty() {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := proxies.resolve(r)
+		if ip != clientIP(r) {
+			r = r.Clone(r.Context())
+			r.RemoteAddr = net.JoinHostPort(ip, "0")
+		}
+		addLogFields(r.Context(), slog.String("client_ip", ip))
+		next.ServeHTTP(w, r)
+	})
+}
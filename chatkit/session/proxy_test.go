@@ -0,0 +1,100 @@
+package session
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+	for _, value := range []string{"10.0.0.0/33", "not-an-ip", "10.0.0.1/"} {
+		if _, err := parseTrustedProxies(value); err == nil {
+			t.Fatalf("expected %q to be rejected", value)
+		}
+	}
+	proxies, err := parseTrustedProxies(" 10.0.0.0/16, 192.0.2.1 ,, fd00::/8")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(proxies.prefixes) != 3 || proxies.unix {
+		t.Fatalf("expected 3 networks, got %+v", proxies)
+	}
+	if proxies, _ := parseTrustedProxies("unix"); !proxies.unix || proxies.empty() {
+		t.Fatalf("expected Unix socket peers to be trusted, got %+v", proxies)
+	}
+}
+
+func TestTrustedProxiesResolve(t *testing.T) {
+	proxies, err := parseTrustedProxies("10.0.0.0/16,192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		remote  string
+		headers map[string]string
+		want    string
+	}{
+		{"untrusted peer ignores headers", "203.0.113.9:4000", map[string]string{"X-Forwarded-For": "198.51.100.1"}, "203.0.113.9"},
+		{"trusted peer without headers", "10.0.1.2:4000", nil, "10.0.1.2"},
+		{"x-forwarded-for", "10.0.1.2:4000", map[string]string{"X-Forwarded-For": "198.51.100.1"}, "198.51.100.1"},
+		{"spoofed hops are skipped", "10.0.1.2:4000", map[string]string{"X-Forwarded-For": "1.1.1.1, 198.51.100.1, 192.0.2.1"}, "198.51.100.1"},
+		{"every hop trusted", "10.0.1.2:4000", map[string]string{"X-Forwarded-For": "10.0.3.4, 192.0.2.1"}, "10.0.3.4"},
+		{"malformed hop", "10.0.1.2:4000", map[string]string{"X-Forwarded-For": "198.51.100.1, garbage"}, "10.0.1.2"},
+		{"forwarded", "10.0.1.2:4000", map[string]string{"Forwarded": `for=198.51.100.1;proto=https, for="[2001:db8::1]:443"`}, "2001:db8::1"},
+		{"forwarded preferred", "10.0.1.2:4000", map[string]string{"Forwarded": "for=198.51.100.1", "X-Forwarded-For": "198.51.100.2"}, "198.51.100.1"},
+		{"x-real-ip", "10.0.1.2:4000", map[string]string{"X-Real-IP": "198.51.100.3"}, "198.51.100.3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remote
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			if got := proxies.resolve(req); got != tt.want {
+				t.Fatalf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestWithTrustedProxiesRewritesClientIP(t *testing.T) {
+	proxies, err := parseTrustedProxies("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ip, logged string
+	handler := withRequestID(withTrustedProxies(proxies, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip = clientIP(r)
+		for _, a := range requestLogFromContext(r.Context()).attrs {
+			if a.Key == "client_ip" {
+				logged = a.Value.String()
+			}
+		}
+	})))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "10.1.2.3:4000"
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if ip != "198.51.100.1" || logged != "198.51.100.1" {
+		t.Fatalf("expected the forwarded client IP, got %q (logged %q)", ip, logged)
+	}
+}
+
+func TestTrustedProxiesTrustUnixSocketPeers(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "@"
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+	req = req.WithContext(context.WithValue(req.Context(), http.LocalAddrContextKey, &net.UnixAddr{Name: "/run/chatkit.sock", Net: "unix"}))
+
+	if got := (trustedProxies{}).resolve(req); got == "198.51.100.1" {
+		t.Fatal("expected Unix socket peers not to be trusted by default")
+	}
+	proxies, _ := parseTrustedProxies("unix")
+	if got := proxies.resolve(req); got != "198.51.100.1" {
+		t.Fatalf("expected the forwarded client IP, got %q", got)
+	}
+}
@@ -0,0 +1,199 @@
+package session
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultQuotaWindow      = 24 * time.Hour
+	defaultQuotaWarnPercent = 80
+)
+
+type quotaUsage struct {
+	count       int64
+	windowStart time.Time
+}
+
+// quotaStatus describes a user's quota after a reservation attempt.
+type quotaStatus struct {
+	used    int64
+	limit   int64
+	resetAt time.Time
+	warn    bool
+}
+
+func (s quotaStatus) remaining() int64 {
+	if s.used >= s.limit {
+		return 0
+	}
+	return s.limit - s.used
+}
+
+// quotaTracker enforces an in-memory, fixed-window limit on sessions per
+// user and flags users approaching it.
+type quotaTracker struct {
+	limit  int64
+	warnAt int64
+	window time.Duration
+	clock  Clock
+
+	mu        sync.Mutex
+	usage     map[string]*quotaUsage
+	lastPrune time.Time
+}
+
+// newQuotaTracker returns nil when limit is zero, disabling quota checks.
+func newQuotaTracker(limit int64, warnPercent int64, window time.Duration) *quotaTracker {
+	if limit <= 0 {
+		return nil
+	}
+	warnAt := limit * warnPercent / 100
+	if warnAt <= 0 || warnPercent >= 100 {
+		warnAt = limit
+	}
+	return &quotaTracker{
+		limit:  limit,
+		warnAt: warnAt,
+		window: window,
+		clock:  SystemClock,
+		usage:  make(map[string]*quotaUsage),
+	}
+}
+
+// clone returns an empty tracker with q's limits, for callers that need
+// separate usage, such as each tenant.
+func (q *quotaTracker) clone() *quotaTracker {
+	return &quotaTracker{limit: q.limit, warnAt: q.warnAt, window: q.window, clock: q.clock, usage: make(map[string]*quotaUsage)}
+}
+
+// reserve records one session for user. It reports false without recording
+// anything when the user has exhausted the quota.
+func (q *quotaTracker) reserve(user string) (quotaStatus, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := q.clock.Now()
+	q.pruneLocked(now)
+
+	u, ok := q.usage[user]
+	if !ok || now.Sub(u.windowStart) >= q.window {
+		u = &quotaUsage{windowStart: now}
+		q.usage[user] = u
+	}
+
+	status := quotaStatus{used: u.count, limit: q.limit, resetAt: u.windowStart.Add(q.window)}
+	if u.count >= q.limit {
+		return status, false
+	}
+	u.count++
+	status.used = u.count
+	status.warn = u.count >= q.warnAt
+	return status, true
+}
+
+// peek returns user's current quota status without reserving anything.
+func (q *quotaTracker) peek(user string) quotaStatus {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := q.clock.Now()
+	u, ok := q.usage[user]
+	if !ok || now.Sub(u.windowStart) >= q.window {
+		return quotaStatus{limit: q.limit, resetAt: now.Add(q.window)}
+	}
+	return quotaStatus{used: u.count, limit: q.limit, resetAt: u.windowStart.Add(q.window), warn: u.count >= q.warnAt}
+}
+
+// quotaUsageReport is one user's usage in the current window.
+type quotaUsageReport struct {
+	User    string    `json:"user"`
+	Used    int64     `json:"used"`
+	Limit   int64     `json:"limit"`
+	ResetAt time.Time `json:"reset_at"`
+}
+
+// report returns every user with sessions in their current window, sorted
+// by user.
+func (q *quotaTracker) report() []quotaUsageReport {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := q.clock.Now()
+	out := []quotaUsageReport{}
+	for user, u := range q.usage {
+		if u.count > 0 && now.Sub(u.windowStart) < q.window {
+			out = append(out, quotaUsageReport{User: user, Used: u.count, Limit: q.limit, ResetAt: u.windowStart.Add(q.window).UTC()})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].User < out[j].User })
+	return out
+}
+
+// restore reinstates usage from a report, such as a checkpoint written
+// before a restart. Windows that have ended are skipped, and a user's
+// current usage is never lowered.
+func (q *quotaTracker) restore(reports []quotaUsageReport) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := q.clock.Now()
+	for _, r := range reports {
+		if !now.Before(r.ResetAt) {
+			continue
+		}
+		if u, ok := q.usage[r.User]; ok && now.Sub(u.windowStart) < q.window && u.count >= r.Used {
+			continue
+		}
+		q.usage[r.User] = &quotaUsage{count: r.Used, windowStart: r.ResetAt.Add(-q.window)}
+	}
+}
+
+// retryAfter returns how long until status's window resets, rounded up to
+// whole seconds.
+func (q *quotaTracker) retryAfter(status quotaStatus) int64 {
+	d := status.resetAt.Sub(q.clock.Now())
+	secs := int64(d / time.Second)
+	if d%time.Second != 0 {
+		secs++
+	}
+	if secs < 1 {
+		secs = 1
+	}
+	return secs
+}
+
+// refund gives back a reservation whose session could not be created.
+func (q *quotaTracker) refund(user string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if u, ok := q.usage[user]; ok && u.count > 0 {
+		u.count--
+	}
+}
+
+func (q *quotaTracker) pruneLocked(now time.Time) {
+	if now.Sub(q.lastPrune) < q.window {
+		return
+	}
+	q.dropExpiredLocked(now)
+}
+
+// prune drops every user whose window has ended, without waiting for the
+// next reservation. It is a no-op on a nil tracker.
+func (q *quotaTracker) prune() {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.dropExpiredLocked(q.clock.Now())
+}
+
+func (q *quotaTracker) dropExpiredLocked(now time.Time) {
+	q.lastPrune = now
+	for user, u := range q.usage {
+		if now.Sub(u.windowStart) >= q.window {
+			delete(q.usage, user)
+		}
+	}
+}
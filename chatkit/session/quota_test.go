@@ -0,0 +1,119 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	openai "github.com/openai/openai-go/v3"
+)
+
+func TestQuotaTrackerWarnsAndEnforces(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	q := newQuotaTracker(5, 80, time.Hour)
+	q.clock = ClockFunc(func() time.Time { return now })
+
+	for i := 1; i <= 5; i++ {
+		status, ok := q.reserve("u")
+		if !ok {
+			t.Fatalf("reservation %d unexpectedly rejected", i)
+		}
+		if wantWarn := i >= 4; status.warn != wantWarn {
+			t.Fatalf("reservation %d: expected warn=%v", i, wantWarn)
+		}
+	}
+	status, ok := q.reserve("u")
+	if ok {
+		t.Fatalf("expected quota to be exhausted")
+	}
+	if got := q.retryAfter(status); got != 3600 {
+		t.Fatalf("expected retry after 3600s, got %d", got)
+	}
+
+	now = now.Add(time.Hour)
+	if _, ok := q.reserve("u"); !ok {
+		t.Fatalf("expected quota to reset after window")
+	}
+}
+
+func TestHandleSessionQuotaWarningAndLimit(t *testing.T) {
+	fake := &fakeSessionCreator{clientSecret: "secret"}
+	handler := newSessionHandler(fake.Create, "w", 1200, 10)
+	handler.quota = newQuotaTracker(2, 50, time.Hour)
+	var notified int
+	handler.onQuotaWarning = func(user string, status quotaStatus) { notified++ }
+
+	send := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/chatkit/session", strings.NewReader(`{"user":"u"}`))
+		rec := httptest.NewRecorder()
+		handler.handleSession(rec, req)
+		return rec
+	}
+
+	rec := send()
+	var resp sessionResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(resp.Warnings) != 1 || resp.Warnings[0].Code != "quota_nearly_exhausted" {
+		t.Fatalf("expected quota warning, got %+v", resp.Warnings)
+	}
+	if notified != 1 {
+		t.Fatalf("expected quota warning notification")
+	}
+
+	send()
+	rec = send()
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected status 429, got %d", rec.Code)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected Retry-After header")
+	}
+}
+
+func TestHandleSessionRefundsQuotaOnFailure(t *testing.T) {
+	handler := newSessionHandler(func(ctx context.Context, params openai.BetaChatKitSessionNewParams) (*openai.ChatSession, error) {
+		return nil, errors.New("upstream down")
+	}, "w", 1200, 10)
+	handler.quota = newQuotaTracker(1, 80, time.Hour)
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/api/chatkit/session", strings.NewReader(`{"user":"u"}`))
+		rec := httptest.NewRecorder()
+		handler.handleSession(rec, req)
+		if rec.Code != http.StatusInternalServerError {
+			t.Fatalf("attempt %d: expected status 500, got %d", i, rec.Code)
+		}
+	}
+}
+
+func TestQuotaTrackerRestore(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	q := newQuotaTracker(3, 80, time.Hour)
+	q.clock = ClockFunc(func() time.Time { return now })
+	q.reserve("c")
+	q.reserve("c")
+	q.restore([]quotaUsageReport{
+		{User: "a", Used: 3, ResetAt: now.Add(30 * time.Minute)},
+		{User: "b", Used: 2, ResetAt: now},
+		{User: "c", Used: 1, ResetAt: now.Add(30 * time.Minute)},
+	})
+	if _, ok := q.reserve("a"); ok {
+		t.Fatalf("expected restored usage to exhaust a's quota")
+	}
+	if status := q.peek("a"); !status.resetAt.Equal(now.Add(30 * time.Minute)) {
+		t.Fatalf("expected the restored window to be kept, got %v", status.resetAt)
+	}
+	if status := q.peek("b"); status.used != 0 {
+		t.Fatalf("expected an ended window to be skipped, got %d", status.used)
+	}
+	if status := q.peek("c"); status.used != 2 {
+		t.Fatalf("expected current usage not to be lowered, got %d", status.used)
+	}
+}
@@ -0,0 +1,240 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"openai-chatkit-backend/chatkit/redis"
+)
+
+// rateLimitPruneInterval is how often buckets that have refilled completely
+// are dropped.
+const rateLimitPruneInterval = time.Minute
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// rateLimiter is a token bucket per key. Each bucket holds up to burst
+// tokens and refills at perMinute tokens a minute; every request spends one
+// token. Buckets live in memory unless shared is set, in which case they
+// live in Redis under name so that every replica draws from the same
+// bucket; the in-memory buckets then only serve while Redis fails.
+type rateLimiter struct {
+	burst  float64
+	refill float64 // tokens per second
+	clock  Clock
+	shared *redis.Client
+	name   string
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastPrune time.Time
+}
+
+// newRateLimiter returns nil when perMinute is zero, disabling the limit. A
+// burst below one defaults to perMinute.
+func newRateLimiter(perMinute, burst int64) *rateLimiter {
+	if perMinute <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = perMinute
+	}
+	return &rateLimiter{
+		burst:   float64(burst),
+		refill:  float64(perMinute) / 60,
+		clock:   SystemClock,
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// allow spends a token from key's bucket. When the bucket is empty it
+// reports false and how long until the next token arrives.
+func (l *rateLimiter) allow(key string) (bool, time.Duration) {
+	if l.shared != nil {
+		ok, retryAfter, err := l.allowShared(key)
+		if err == nil {
+			return ok, retryAfter
+		}
+		l.shared.Warn("shared rate limit unavailable; using this replica's buckets", "limit", l.name, "error", err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock.Now()
+	l.pruneLocked(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, updated: now}
+		l.buckets[key] = b
+	}
+	b.tokens = l.level(b, now)
+	b.updated = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.refill * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// peek returns how many whole tokens key's bucket holds and, when it is
+// empty, how long until the next one, without spending anything.
+func (l *rateLimiter) peek(key string) (int64, time.Duration) {
+	if l.shared != nil {
+		tokens, err := l.peekShared(key)
+		if err == nil {
+			return l.remaining(tokens)
+		}
+		l.shared.Warn("shared rate limit unavailable; using this replica's buckets", "limit", l.name, "error", err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	tokens := l.burst
+	if b, ok := l.buckets[key]; ok {
+		tokens = l.level(b, l.clock.Now())
+	}
+	return l.remaining(tokens)
+}
+
+func (l *rateLimiter) remaining(tokens float64) (int64, time.Duration) {
+	if tokens < 1 {
+		return 0, time.Duration((1 - tokens) / l.refill * float64(time.Second))
+	}
+	return int64(tokens), 0
+}
+
+// redisNowMillis sets now to the Redis server's time in Unix milliseconds.
+// Buckets shared by replicas are timed by Redis alone, so clock skew
+// between replicas cannot add or take away tokens.
+const redisNowMillis = `
+local clock = redis.call('TIME')
+local now = tonumber(clock[1]) * 1000 + math.floor(tonumber(clock[2]) / 1000)
+`
+
+// redisTokenBucketPeek returns, as a string, how many tokens the bucket at
+// KEYS[1] holds. ARGV holds the burst and the refill rate in tokens per
+// millisecond.
+const redisTokenBucketPeek = redisNowMillis + `
+local burst, refill = tonumber(ARGV[1]), tonumber(ARGV[2])
+local b = redis.call('HMGET', KEYS[1], 't', 'u')
+local tokens, updated = tonumber(b[1]) or burst, tonumber(b[2]) or now
+return tostring(math.min(burst, tokens + math.max(0, now - updated) * refill))
+`
+
+// peekShared reads the bucket redisTokenBucket keeps for key.
+func (l *rateLimiter) peekShared(key string) (float64, error) {
+	reply, err := l.shared.Eval(context.Background(), redisTokenBucketPeek, []string{l.shared.Key("ratelimit", l.name, key)},
+		strconv.FormatFloat(l.burst, 'f', -1, 64),
+		strconv.FormatFloat(l.refill/1000, 'f', -1, 64),
+	)
+	if err != nil {
+		return 0, err
+	}
+	tokens, ok := reply.(string)
+	if !ok {
+		return 0, fmt.Errorf("redis: expected a token count, got %v", reply)
+	}
+	return strconv.ParseFloat(tokens, 64)
+}
+
+// redisTokenBucket spends a token from the bucket at KEYS[1]. ARGV holds
+// the burst and the refill rate in tokens per millisecond. It returns
+// whether a token was spent and, if not, the milliseconds until the next
+// one.
+const redisTokenBucket = redisNowMillis + `
+local burst, refill = tonumber(ARGV[1]), tonumber(ARGV[2])
+local b = redis.call('HMGET', KEYS[1], 't', 'u')
+local tokens, updated = tonumber(b[1]) or burst, tonumber(b[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - updated) * refill)
+local allowed, wait = 0, 0
+if tokens >= 1 then
+	tokens, allowed = tokens - 1, 1
+else
+	wait = math.ceil((1 - tokens) / refill)
+end
+redis.call('HSET', KEYS[1], 't', tostring(tokens), 'u', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil((burst - tokens) / refill) + 1000)
+return {allowed, wait}
+`
+
+func (l *rateLimiter) allowShared(key string) (bool, time.Duration, error) {
+	reply, err := l.shared.Eval(context.Background(), redisTokenBucket, []string{l.shared.Key("ratelimit", l.name, key)},
+		strconv.FormatFloat(l.burst, 'f', -1, 64),
+		strconv.FormatFloat(l.refill/1000, 'f', -1, 64),
+	)
+	if err != nil {
+		return false, 0, err
+	}
+	v, err := redis.Ints(reply, 2)
+	if err != nil {
+		return false, 0, err
+	}
+	return v[0] == 1, time.Duration(v[1]) * time.Millisecond, nil
+}
+
+// level returns how many tokens b holds at now.
+func (l *rateLimiter) level(b *tokenBucket, now time.Time) float64 {
+	return min(l.burst, b.tokens+now.Sub(b.updated).Seconds()*l.refill)
+}
+
+func (l *rateLimiter) pruneLocked(now time.Time) {
+	if now.Sub(l.lastPrune) < rateLimitPruneInterval {
+		return
+	}
+	l.dropFullLocked(now)
+}
+
+// prune drops every bucket that has refilled completely, without waiting
+// for the next request. It is a no-op on a nil limiter.
+func (l *rateLimiter) prune() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.dropFullLocked(l.clock.Now())
+}
+
+func (l *rateLimiter) dropFullLocked(now time.Time) {
+	l.lastPrune = now
+	for key, b := range l.buckets {
+		if l.level(b, now) >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// limitByIP rejects requests from client IPs that have run out of tokens.
+func (l *rateLimiter) limitByIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ok, retryAfter := l.allow(clientIP(r)); !ok {
+			writeRateLimited(w, retryAfter)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeRateLimited answers 429 with Retry-After rounded up to whole seconds.
+func writeRateLimited(w http.ResponseWriter, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.FormatInt(ceilSeconds(retryAfter), 10))
+	http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+}
+
+// ceilSeconds rounds d up to whole seconds, and to at least one.
+func ceilSeconds(d time.Duration) int64 {
+	secs := int64(d / time.Second)
+	if d%time.Second != 0 || secs < 1 {
+		secs++
+	}
+	return secs
+}
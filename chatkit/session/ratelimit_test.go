@@ -0,0 +1,70 @@
+package session
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterRefillsTokens(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	l := newRateLimiter(6, 2)
+	l.clock = ClockFunc(func() time.Time { return now })
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.allow("a"); !ok {
+			t.Fatalf("expected request %d within burst to pass", i+1)
+		}
+	}
+	ok, retryAfter := l.allow("a")
+	if ok || retryAfter != 10*time.Second {
+		t.Fatalf("expected rejection with 10s retry, got %v %s", ok, retryAfter)
+	}
+	if ok, _ := l.allow("b"); !ok {
+		t.Fatalf("expected other keys to have their own bucket")
+	}
+
+	now = now.Add(10 * time.Second)
+	if ok, _ := l.allow("a"); !ok {
+		t.Fatalf("expected a token after refill")
+	}
+	if ok, _ := l.allow("a"); ok {
+		t.Fatalf("expected only one token to have refilled")
+	}
+
+	if newRateLimiter(0, 5) != nil {
+		t.Fatalf("expected a zero rate to disable the limiter")
+	}
+}
+
+func TestHandleSessionRateLimits(t *testing.T) {
+	fake := &fakeSessionCreator{clientSecret: "secret"}
+	handler := newSessionHandler(fake.Create, "w", 1200, 10)
+	handler.ipLimit = newRateLimiter(60, 2)
+	handler.userLimit = newRateLimiter(60, 1)
+	router, err := newRouter(handler, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected router error: %v", err)
+	}
+
+	send := func(user string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/chatkit/session", strings.NewReader(`{"user":"`+user+`"}`))
+		req.RemoteAddr = "203.0.113.7:1234"
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := send("alice"); rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rec.Code)
+	}
+	rec := send("alice")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "1" {
+		t.Fatalf("expected user limit 429 with Retry-After 1, got %d %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if rec := send("bob"); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected IP limit to reject a third request, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,140 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultReadinessTimeout = 2 * time.Second
+
+// readinessCheck is one dependency probed by /readyz.
+type readinessCheck struct {
+	name    string
+	timeout time.Duration
+	run     func(context.Context) error
+}
+
+type readinessResult struct {
+	Name       string `json:"name"`
+	Status     string `json:"status"`
+	DurationMS int64  `json:"duration_ms"`
+	Error      string `json:"error,omitempty"`
+}
+
+type readinessReport struct {
+	Ready          bool              `json:"ready"`
+	Checks         []readinessResult `json:"checks"`
+	CircuitBreaker *breakerStatus    `json:"circuit_breaker,omitempty"`
+	// Credentials reports the expiry of certificates and the age of cached
+	// keys; like the circuit breaker, it does not affect readiness.
+	Credentials []credentialStatus `json:"credentials,omitempty"`
+}
+
+// readinessProbe runs every dependency check concurrently, each bounded by
+// its own timeout, so that one slow dependency shows up as a named timeout
+// instead of stalling the whole probe. The circuit breaker state is
+// reported but does not affect readiness: every instance shares the same
+// upstream, so taking them out of rotation would not help.
+type readinessProbe struct {
+	checks      []readinessCheck
+	breaker     *circuitBreaker
+	credentials *credentialHealth
+}
+
+func newReadinessProbe(checks ...readinessCheck) *readinessProbe {
+	return &readinessProbe{checks: checks}
+}
+
+func (p *readinessProbe) check(ctx context.Context) readinessReport {
+	results := make([]readinessResult, len(p.checks))
+	var wg sync.WaitGroup
+	for i, c := range p.checks {
+		wg.Add(1)
+		go func(i int, c readinessCheck) {
+			defer wg.Done()
+			timeout := c.timeout
+			if timeout <= 0 {
+				timeout = defaultReadinessTimeout
+			}
+			checkCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			start := time.Now()
+			errc := make(chan error, 1)
+			go func() { errc <- c.run(checkCtx) }()
+			var err error
+			select {
+			case err = <-errc:
+			case <-checkCtx.Done():
+				err = checkCtx.Err()
+			}
+
+			result := readinessResult{Name: c.name, Status: "ok", DurationMS: time.Since(start).Milliseconds()}
+			switch {
+			case errors.Is(err, context.DeadlineExceeded):
+				result.Status = "timeout"
+				result.Error = fmt.Sprintf("no answer within %s", timeout)
+			case err != nil:
+				result.Status = "failing"
+				result.Error = sanitizeUpstreamMessage(err.Error())
+			}
+			results[i] = result
+		}(i, c)
+	}
+	wg.Wait()
+
+	report := readinessReport{Ready: true, Checks: results}
+	for _, r := range results {
+		if r.Status != "ok" {
+			report.Ready = false
+		}
+	}
+	return report
+}
+
+func (p *readinessProbe) handle(w http.ResponseWriter, r *http.Request) {
+	report := p.check(r.Context())
+	if p.breaker != nil {
+		s := p.breaker.snapshot()
+		report.CircuitBreaker = &s
+	}
+	report.Credentials = p.credentials.report()
+	status := http.StatusOK
+	if !report.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, report)
+}
+
+// parseReadinessTimeouts parses comma-separated name=duration entries, e.g.
+// "openai=3s,redis=500ms".
+func parseReadinessTimeouts(spec string) (map[string]time.Duration, error) {
+	return parseNamedDurations(spec, "readiness timeout")
+}
+
+// parseNamedDurations parses comma-separated name=duration entries. kind
+// names the setting in error messages.
+func parseNamedDurations(spec, kind string) (map[string]time.Duration, error) {
+	durations := make(map[string]time.Duration)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid %s %q: expected name=duration", kind, entry)
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid %s %q: duration must be positive, such as 500ms or 2s", kind, entry)
+		}
+		durations[strings.TrimSpace(name)] = d
+	}
+	return durations, nil
+}
@@ -0,0 +1,90 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReadinessProbeRunsChecksConcurrently(t *testing.T) {
+	probe := newReadinessProbe(
+		readinessCheck{name: "fast", run: func(context.Context) error { return nil }},
+		readinessCheck{name: "broken", run: func(context.Context) error { return errors.New("connection refused") }},
+		readinessCheck{name: "slow", timeout: 50 * time.Millisecond, run: func(ctx context.Context) error {
+			time.Sleep(time.Second)
+			return nil
+		}},
+		readinessCheck{name: "slow2", timeout: 50 * time.Millisecond, run: func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}},
+	)
+
+	start := time.Now()
+	report := probe.check(context.Background())
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("expected the probe to finish near the slowest timeout, took %s", elapsed)
+	}
+	if report.Ready {
+		t.Fatalf("expected probe to be not ready")
+	}
+	want := map[string]string{"fast": "ok", "broken": "failing", "slow": "timeout", "slow2": "timeout"}
+	for _, r := range report.Checks {
+		if r.Status != want[r.Name] {
+			t.Fatalf("check %s: expected %s, got %s (%s)", r.Name, want[r.Name], r.Status, r.Error)
+		}
+	}
+}
+
+func TestReadyzEndpoint(t *testing.T) {
+	healthy := true
+	probe := newReadinessProbe(readinessCheck{name: "openai", run: func(context.Context) error {
+		if !healthy {
+			return errors.New("unreachable")
+		}
+		return nil
+	}})
+	router, err := newRouter(newSessionHandler(nil, "w", 1200, 10), nil, nil, probe, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+
+	healthy = false
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503, got %d", rec.Code)
+	}
+	var report readinessReport
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Checks) != 1 || report.Checks[0].Error != "unreachable" {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+}
+
+func TestParseReadinessTimeouts(t *testing.T) {
+	timeouts, err := parseReadinessTimeouts("openai=3s, webhook_sink=500ms")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if timeouts["openai"] != 3*time.Second || timeouts["webhook_sink"] != 500*time.Millisecond {
+		t.Fatalf("unexpected timeouts: %v", timeouts)
+	}
+	for _, spec := range []string{"openai", "openai=fast", "openai=-1s"} {
+		if _, err := parseReadinessTimeouts(spec); err == nil {
+			t.Fatalf("expected error for %q", spec)
+		}
+	}
+}
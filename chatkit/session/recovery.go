@@ -0,0 +1,58 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"sync/atomic"
+)
+
+// headerTrackingWriter records whether the response has started, so that a
+// recovered panic knows whether it can still send its own response.
+type headerTrackingWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *headerTrackingWriter) WriteHeader(status int) {
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *headerTrackingWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *headerTrackingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// withPanicRecovery turns a panicking handler into a 500 with the usual
+// JSON error body, logs the panic with its stack trace, and counts it in
+// panics. A response that has already started cannot be replaced, so its
+// connection is aborted instead. http.ErrAbortHandler passes through.
+func withPanicRecovery(panics *atomic.Int64, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tw := &headerTrackingWriter{ResponseWriter: w}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if err, ok := v.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+				panic(v)
+			}
+			panics.Add(1)
+			slog.ErrorContext(r.Context(), "handler panicked", "method", r.Method, "path", r.URL.Path, "panic", fmt.Sprint(v), "stack", string(debug.Stack()))
+			if tw.wroteHeader {
+				panic(http.ErrAbortHandler)
+			}
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "internal_error", "message": "internal server error", "request_id": responseRequestID(w)})
+		}()
+		next.ServeHTTP(tw, r)
+	})
+}
@@ -0,0 +1,57 @@
+package session
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestPanicRecoveryAnswersWithStructured500(t *testing.T) {
+	var panics atomic.Int64
+	handler := withRequestID(withPanicRecovery(&panics, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/chatkit/session", nil)
+	req.Header.Set(requestIDHeader, "req_panic")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	var body map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || rec.Code != http.StatusInternalServerError {
+		t.Fatalf("expected a JSON 500, got %d %s", rec.Code, rec.Body)
+	}
+	if body["error"] != "internal_error" || body["request_id"] != "req_panic" {
+		t.Fatalf("unexpected body: %v", body)
+	}
+	if panics.Load() != 1 {
+		t.Fatalf("expected the panic to be counted, got %d", panics.Load())
+	}
+}
+
+func TestPanicRecoveryAbortsStartedResponses(t *testing.T) {
+	var panics atomic.Int64
+	for name, handler := range map[string]http.HandlerFunc{
+		"started response": func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+			panic("boom")
+		},
+		"abort handler": func(w http.ResponseWriter, r *http.Request) {
+			panic(http.ErrAbortHandler)
+		},
+	} {
+		func() {
+			defer func() {
+				if v := recover(); v != http.ErrAbortHandler {
+					t.Fatalf("%s: expected http.ErrAbortHandler, got %v", name, v)
+				}
+			}()
+			withPanicRecovery(&panics, handler).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		}()
+	}
+	if panics.Load() != 1 {
+		t.Fatalf("expected only the handler's own panic to be counted, got %d", panics.Load())
+	}
+}
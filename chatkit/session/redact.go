@@ -0,0 +1,96 @@
+package session
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// RedactedValue replaces redacted header and body values, in logs and in
+// the request journal.
+const RedactedValue = "[REDACTED]"
+
+// builtinRedactedFields are redacted in every deployment.
+var builtinRedactedFields = []string{"client_secret", "api_key", "authorization", "resume_token"}
+
+// redactor masks fields that a deployment considers sensitive in log lines
+// and webhook payloads. A field name without dots, such as "user", matches
+// that key at any depth; a dotted path, such as
+// "attribution.x-experiment-variant", matches only that location. Matching
+// is case-insensitive. A nil redactor applies only the built-in fields.
+type redactor struct {
+	fields map[string]bool
+}
+
+// newRedactor parses a comma-separated list of field names and paths.
+func newRedactor(spec string) *redactor {
+	r := &redactor{fields: make(map[string]bool)}
+	for _, f := range append(strings.Split(spec, ","), builtinRedactedFields...) {
+		if f = strings.ToLower(strings.TrimSpace(f)); f != "" {
+			r.fields[f] = true
+		}
+	}
+	return r
+}
+
+func (r *redactor) redacted(path []string) bool {
+	if r == nil {
+		r = defaultRedactor
+	}
+	leaf := strings.ToLower(path[len(path)-1])
+	return r.fields[leaf] || r.fields[strings.ToLower(strings.Join(path, "."))]
+}
+
+// value returns v, or RedactedValue when field is sensitive. Use it for
+// values written to logs.
+func (r *redactor) value(field, v string) string {
+	if v != "" && r.redacted([]string{field}) {
+		return RedactedValue
+	}
+	return v
+}
+
+// values returns a copy of m with sensitive entries masked; field is the
+// path of m itself.
+func (r *redactor) values(field string, m map[string]string) map[string]string {
+	if len(m) == 0 {
+		return m
+	}
+	out := make(map[string]string, len(m))
+	for k, v := range m {
+		if r.redacted([]string{field, k}) {
+			v = RedactedValue
+		}
+		out[k] = v
+	}
+	return out
+}
+
+// payload returns the JSON document data with sensitive fields masked.
+func (r *redactor) payload(data []byte) ([]byte, error) {
+	var doc any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	return json.Marshal(r.walk(nil, doc))
+}
+
+func (r *redactor) walk(path []string, v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, child := range v {
+			childPath := append(path[:len(path):len(path)], k)
+			if r.redacted(childPath) {
+				v[k] = RedactedValue
+				continue
+			}
+			v[k] = r.walk(childPath, child)
+		}
+	case []any:
+		for i, child := range v {
+			v[i] = r.walk(path, child)
+		}
+	}
+	return v
+}
+
+var defaultRedactor = newRedactor("")
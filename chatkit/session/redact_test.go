@@ -0,0 +1,71 @@
+package session
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestRedactorPayload(t *testing.T) {
+	r := newRedactor("user, attribution.X-Experiment-Variant")
+	in := `{"user":"alice","client_secret":"ek_1","attribution":{"X-Experiment-Variant":"b","X-Attribution-Campaign":"spring"},"items":[{"user":"bob"}]}`
+
+	out, err := r.payload([]byte(in))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var doc struct {
+		User         string            `json:"user"`
+		ClientSecret string            `json:"client_secret"`
+		Attribution  map[string]string `json:"attribution"`
+		Items        []struct {
+			User string `json:"user"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(out, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.User != RedactedValue || doc.ClientSecret != RedactedValue || doc.Items[0].User != RedactedValue {
+		t.Fatalf("expected user and client_secret to be redacted everywhere: %s", out)
+	}
+	if doc.Attribution["X-Experiment-Variant"] != RedactedValue || doc.Attribution["X-Attribution-Campaign"] != "spring" {
+		t.Fatalf("expected only the configured attribution path to be redacted: %s", out)
+	}
+}
+
+func TestRedactorValues(t *testing.T) {
+	var none *redactor
+	if got := none.value("user", "alice"); got != "alice" {
+		t.Fatalf("expected nil redactor to leave user alone, got %s", got)
+	}
+	if got := none.value("client_secret", "ek_1"); got != RedactedValue {
+		t.Fatalf("expected built-in field to be redacted, got %s", got)
+	}
+
+	r := newRedactor("USER")
+	if got := r.value("user", "alice"); got != RedactedValue {
+		t.Fatalf("expected case-insensitive match, got %s", got)
+	}
+	if got := r.value("user", ""); got != "" {
+		t.Fatalf("expected empty value to stay empty, got %s", got)
+	}
+	got := newRedactor("attribution.x-experiment-variant").values("attribution", map[string]string{"X-Experiment-Variant": "b", "X-Attribution-Campaign": "spring"})
+	if got["X-Experiment-Variant"] != RedactedValue || got["X-Attribution-Campaign"] != "spring" {
+		t.Fatalf("unexpected values: %v", got)
+	}
+}
+
+func TestWebhookPayloadIsRedacted(t *testing.T) {
+	data, _ := json.Marshal(sessionCreatedEvent{SessionID: "cksess_1", User: "alice"})
+	redacted, err := newRedactor("user").payload(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var event sessionCreatedEvent
+	if err := json.Unmarshal(redacted, &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.User != RedactedValue || event.SessionID != "cksess_1" {
+		t.Fatalf("unexpected webhook payload: %+v", event)
+	}
+}
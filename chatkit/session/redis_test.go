@@ -0,0 +1,75 @@
+package session
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"openai-chatkit-backend/chatkit/redis"
+	"openai-chatkit-backend/chatkit/redis/redistest"
+)
+
+func TestRedisIdempotencyStore(t *testing.T) {
+	server := redistest.NewServer(t, "")
+	server.HandleUnlock(redisIdempotencyAbandon)
+	c, _ := redis.NewClient(server.URL(), redis.DefaultKeyPrefix)
+	store := newRedisIdempotencyStore(c)
+	ctx := context.Background()
+
+	if state, _, err := store.Reserve(ctx, "k", time.Minute); err != nil || state != idempotencyReserved {
+		t.Fatalf("expected to reserve, got %v, %v", state, err)
+	}
+	if state, _, _ := store.Reserve(ctx, "k", time.Minute); state != idempotencyInFlight {
+		t.Fatalf("expected the key to be in flight, got %v", state)
+	}
+	if err := store.Abandon(ctx, "k"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if state, _, _ := store.Reserve(ctx, "k", time.Minute); state != idempotencyReserved {
+		t.Fatalf("expected an abandoned key to be reservable, got %v", state)
+	}
+	if err := store.Complete(ctx, "k", []byte("p"), time.Minute); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	state, result, _ := store.Reserve(ctx, "k", time.Minute)
+	if state != idempotencyCompleted || string(result) != "p" {
+		t.Fatalf("expected the stored result, got %v %q", state, result)
+	}
+	if err := store.Abandon(ctx, "k"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if v, _ := server.Value("chatkit:idempotency:k"); v != "rp" {
+		t.Fatalf("expected Abandon to keep a completed key, got %q", v)
+	}
+}
+
+func TestSharedStateFallsBackWhenRedisIsDown(t *testing.T) {
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	addr := l.Addr().String()
+	l.Close()
+	c, _ := redis.NewClient("redis://"+addr, "")
+
+	limiter := newRateLimiter(60, 1)
+	limiter.shared, limiter.name = c, "ip"
+	if ok, _ := limiter.allow("1.2.3.4"); !ok {
+		t.Fatalf("expected the first request to pass")
+	}
+	if ok, _ := limiter.allow("1.2.3.4"); ok {
+		t.Fatalf("expected the local bucket to enforce the limit while Redis is down")
+	}
+
+	sessions := newActiveSessionCap(1)
+	sessions.shared = c
+	slot, _, ok := sessions.reserve("u", time.Minute)
+	if !ok || slot.id != "" {
+		t.Fatalf("expected a local reservation, got %+v, %v", slot, ok)
+	}
+	if _, _, ok := sessions.reserve("u", time.Minute); ok {
+		t.Fatalf("expected the local cap to be enforced while Redis is down")
+	}
+	sessions.release("u", slot)
+	if sessions.peek("u") != 0 {
+		t.Fatalf("expected the local slot to be released")
+	}
+}
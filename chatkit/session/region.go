@@ -0,0 +1,61 @@
+package session
+
+import (
+	"fmt"
+	"net/url"
+)
+
+const regionHeader = "X-Region"
+
+// regionFailover is another region's session endpoint, advertised in
+// degraded responses so that clients of an active-active deployment can
+// retry there instead of waiting for this region to recover.
+type regionFailover struct {
+	url    string
+	region string
+}
+
+// parseRegion validates a region name such as us-east-1. Regions label
+// logs, traces, and session records, so they must already be DNS-safe
+// rather than silently rewritten.
+func parseRegion(region string) (string, error) {
+	if region != "" && dnsSafeLabel(region) != region {
+		return "", fmt.Errorf("invalid region %q: expected lowercase letters, digits, and dashes such as us-east-1", region)
+	}
+	return region, nil
+}
+
+// newRegionFailover returns nil when rawURL is empty. rawURL must be an
+// absolute http or https URL, normally the other region's session endpoint.
+func newRegionFailover(rawURL, region string) (*regionFailover, error) {
+	if rawURL == "" {
+		if region != "" {
+			return nil, fmt.Errorf("failover region %q is set without a failover URL", region)
+		}
+		return nil, nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid failover URL %q: expected an absolute http or https URL", rawURL)
+	}
+	region, err = parseRegion(region)
+	if err != nil {
+		return nil, err
+	}
+	return &regionFailover{url: rawURL, region: region}, nil
+}
+
+// withRegion returns a copy of the banner that also names the region.
+func (b responseBanner) withRegion(region string) responseBanner {
+	if region == "" {
+		return b
+	}
+	b.headers = append(append([][2]string(nil), b.headers...), [2]string{regionHeader, region})
+	return b
+}
+
+// degradedLinks returns the pointers added to the handler's degraded
+// responses.
+func (h *sessionHandler) degradedLinks() degradedLinks {
+	return degradedLinks{statusPageURL: h.statusPageURL, region: h.region, failover: h.failover}
+}
@@ -0,0 +1,71 @@
+package session
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseRegionConfig(t *testing.T) {
+	if region, err := parseRegion("us-east-1"); err != nil || region != "us-east-1" {
+		t.Fatalf("unexpected result %q, %v", region, err)
+	}
+	if _, err := parseRegion("US East"); err == nil {
+		t.Fatalf("expected a region that is not DNS-safe to be rejected")
+	}
+	if f, err := newRegionFailover("", ""); err != nil || f != nil {
+		t.Fatalf("expected no failover, got %+v, %v", f, err)
+	}
+	for _, tc := range [][2]string{{"", "eu-west-1"}, {"/api/chatkit/session", ""}, {"https://eu.example.com/api/chatkit/session", "EU"}} {
+		if _, err := newRegionFailover(tc[0], tc[1]); err == nil {
+			t.Fatalf("%q, %q: expected an error", tc[0], tc[1])
+		}
+	}
+}
+
+func TestDegradedResponseAdvertisesFailover(t *testing.T) {
+	handler := newSessionHandler((&fakeSessionCreator{clientSecret: "secret"}).Create, "w", 1200, 10)
+	handler.region = "us-east-1"
+	handler.failover, _ = newRegionFailover("https://eu.example.com/api/chatkit/session", "eu-west-1")
+	handler.maintenance = newMaintenanceMode(true, "")
+	handler.suspensions, _ = newSuspensionList("", nil)
+
+	post := func() degradedResponse {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		handler.handleSession(rec, httptest.NewRequest(http.MethodPost, defaultSessionPath, strings.NewReader(`{"user":"u"}`)))
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Fatalf("expected status 503, got %d", rec.Code)
+		}
+		var resp degradedResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	resp := post()
+	if resp.Region != "us-east-1" || resp.FailoverURL != "https://eu.example.com/api/chatkit/session" || resp.FailoverRegion != "eu-west-1" {
+		t.Fatalf("expected failover hints during maintenance, got %+v", resp)
+	}
+
+	handler.maintenance = nil
+	_ = handler.suspensions.set(suspension{Kind: suspensionKindWorkflow, Value: "w"})
+	if resp := post(); resp.Reason != degradedReasonSuspended || resp.FailoverURL != "" || resp.Region != "us-east-1" {
+		t.Fatalf("expected suspensions not to advertise failover, got %+v", resp)
+	}
+}
+
+func TestResponseBannerWithRegion(t *testing.T) {
+	banner, _ := newResponseBanner("staging", "", "")
+	if got := banner.withRegion("").headerNames(); len(got) != 1 {
+		t.Fatalf("expected an empty region to add nothing, got %v", got)
+	}
+	rec := httptest.NewRecorder()
+	withResponseBanner(banner.withRegion("eu-west-1"), http.NotFoundHandler()).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Header().Get(regionHeader) != "eu-west-1" {
+		t.Fatalf("expected %s header, got %q", regionHeader, rec.Header().Get(regionHeader))
+	}
+}
@@ -0,0 +1,69 @@
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+
+	"github.com/openai/openai-go/v3/option"
+)
+
+const (
+	requestIDHeader = "X-Request-ID"
+	// upstreamRequestIDHeader is the header OpenAI records with each API
+	// request, so failures can be found in its dashboard by our request ID.
+	upstreamRequestIDHeader = "X-Client-Request-Id"
+)
+
+// requestIDPattern limits incoming request IDs to what is safe to echo in
+// headers and logs.
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// withRequestID gives every request an ID, reusing a well-formed incoming
+// X-Request-ID, and echoes it in the response. The ID is attached to the
+// request context, where logs, the journal, and OpenAI calls pick it up.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !requestIDPattern.MatchString(id) {
+			id, _ = randomID("req_")
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestLogKey{}, &requestLog{id: id})))
+	})
+}
+
+// requestIDFromContext returns the ID of the request in ctx, or "" outside a
+// request.
+func requestIDFromContext(ctx context.Context) string {
+	if l := requestLogFromContext(ctx); l != nil {
+		return l.id
+	}
+	return ""
+}
+
+// responseRequestID returns the request ID withRequestID set on w, for
+// inclusion in JSON error bodies.
+func responseRequestID(w http.ResponseWriter) string {
+	return w.Header().Get(requestIDHeader)
+}
+
+// forwardRequestID sends the ID of the request that triggered an OpenAI call
+// along with it.
+func forwardRequestID(req *http.Request, next option.MiddlewareNext) (*http.Response, error) {
+	if id := requestIDFromContext(req.Context()); id != "" {
+		req.Header.Set(upstreamRequestIDHeader, id)
+	}
+	return next(req)
+}
+
+// randomID returns prefix followed by 24 random hex digits.
+func randomID(prefix string) (string, error) {
+	buf := make([]byte, 12)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return prefix + hex.EncodeToString(buf), nil
+}
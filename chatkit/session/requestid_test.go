@@ -0,0 +1,54 @@
+package session
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/openai/openai-go/v3"
+)
+
+func TestWithRequestIDHonorsWellFormedIDs(t *testing.T) {
+	var seen string
+	handler := withRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = requestIDFromContext(r.Context())
+		writeValidationErrors(w, []fieldError{{Field: "user", Code: validationCodeRequired, Message: "user is required"}})
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/chatkit/session", nil)
+	req.Header.Set(requestIDHeader, "gw-7f3a:1")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if seen != "gw-7f3a:1" || rec.Header().Get(requestIDHeader) != "gw-7f3a:1" {
+		t.Fatalf("expected the incoming ID to be kept, got %q and header %q", seen, rec.Header().Get(requestIDHeader))
+	}
+	if !strings.Contains(rec.Body.String(), `"request_id":"gw-7f3a:1"`) {
+		t.Fatalf("expected the error body to carry the request ID, got %s", rec.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/api/chatkit/session", nil)
+	req.Header.Set(requestIDHeader, "bad id\r\nX-Injected: 1")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if !strings.HasPrefix(seen, "req_") || rec.Header().Get(requestIDHeader) != seen {
+		t.Fatalf("expected a generated ID for a malformed header, got %q", seen)
+	}
+}
+
+func TestOpenAIClientForwardsRequestID(t *testing.T) {
+	var got string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get(upstreamRequestIDHeader)
+		http.Error(w, `{"error":{"message":"boom"}}`, http.StatusBadRequest)
+	}))
+	defer upstream.Close()
+
+	client := NewOpenAIClient(OpenAIClientConfig{APIKey: "sk-test", BaseURL: upstream.URL})
+	ctx := context.WithValue(context.Background(), requestLogKey{}, &requestLog{id: "req_abc"})
+	_, _ = client.Beta.ChatKit.Sessions.New(ctx, openai.BetaChatKitSessionNewParams{User: "u"})
+	if got != "req_abc" {
+		t.Fatalf("expected %s to be req_abc, got %q", upstreamRequestIDHeader, got)
+	}
+}
@@ -0,0 +1,187 @@
+package session
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// refreshPath follows the session endpoint when CHATKIT_SESSION_PATH
+	// moves it.
+	refreshPath = defaultSessionPath + "/refresh"
+
+	resumeTokenPrefix     = "rt_"
+	defaultResumeTokenTTL = 24 * time.Hour
+	// defaultResumeTokenMaxLifetime caps how long a chain of refreshes can
+	// extend the first token, so a leaked token does not live forever.
+	defaultResumeTokenMaxLifetime = 7 * 24 * time.Hour
+	minResumeTokenKeyLen          = 32
+)
+
+var errInvalidResumeToken = errors.New("invalid or expired resume token")
+
+// resumeClaims is what a resume token carries: enough to mint a follow-up
+// session for the same user and workflow without a server-side record.
+type resumeClaims struct {
+	User       string `json:"u"`
+	WorkflowID string `json:"w"`
+	Tenant     string `json:"t,omitempty"`
+	// Subject is the verified identity the token was issued to, if any.
+	// Only the same identity can refresh with it.
+	Subject string `json:"s,omitempty"`
+	// IssuedAt is when the first token of the refresh chain was issued.
+	IssuedAt  int64 `json:"iat"`
+	ExpiresAt int64 `json:"exp"`
+}
+
+// resumeTokens seals resumeClaims with AES-256-GCM, so that tokens are both
+// opaque to clients and rejected when tampered with. Every replica sharing
+// the key accepts every other replica's tokens.
+type resumeTokens struct {
+	aead        cipher.AEAD
+	ttl         time.Duration
+	maxLifetime time.Duration
+	clock       Clock
+}
+
+// newResumeTokens derives the encryption key from secret, which must be at
+// least 32 bytes. Tokens are valid for ttl, and refreshes never extend a
+// chain of tokens past maxLifetime from the first.
+func newResumeTokens(secret string, ttl, maxLifetime time.Duration) (*resumeTokens, error) {
+	if len(secret) < minResumeTokenKeyLen {
+		return nil, fmt.Errorf("key must be at least %d bytes", minResumeTokenKeyLen)
+	}
+	if ttl <= 0 {
+		return nil, errors.New("TTL must be positive")
+	}
+	if maxLifetime < ttl {
+		return nil, errors.New("maximum lifetime must be at least the TTL")
+	}
+	key := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &resumeTokens{aead: aead, ttl: ttl, maxLifetime: maxLifetime, clock: SystemClock}, nil
+}
+
+// issue returns a token for user's workflow in tenant, bound to the
+// verified subject, if any. It is valid for the TTL, but no longer than
+// the maximum lifetime after issuedAt, the issue time of the token being
+// refreshed, or zero for a new chain.
+func (t *resumeTokens) issue(user, workflowID, tenant, subject string, issuedAt time.Time) (string, error) {
+	now := t.clock.Now()
+	if issuedAt.IsZero() {
+		issuedAt = now
+	}
+	expiresAt := now.Add(t.ttl)
+	if limit := issuedAt.Add(t.maxLifetime); limit.Before(expiresAt) {
+		expiresAt = limit
+	}
+	plaintext, err := json.Marshal(resumeClaims{User: user, WorkflowID: workflowID, Tenant: tenant, Subject: subject, IssuedAt: issuedAt.Unix(), ExpiresAt: expiresAt.Unix()})
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, t.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := t.aead.Seal(nonce, nonce, plaintext, []byte(resumeTokenPrefix))
+	return resumeTokenPrefix + base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// open returns the claims of a valid, unexpired token.
+func (t *resumeTokens) open(token string) (*resumeClaims, error) {
+	encoded, ok := strings.CutPrefix(token, resumeTokenPrefix)
+	if !ok {
+		return nil, errInvalidResumeToken
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < t.aead.NonceSize() {
+		return nil, errInvalidResumeToken
+	}
+	nonce, ciphertext := sealed[:t.aead.NonceSize()], sealed[t.aead.NonceSize():]
+	plaintext, err := t.aead.Open(nil, nonce, ciphertext, []byte(resumeTokenPrefix))
+	if err != nil {
+		return nil, errInvalidResumeToken
+	}
+	var claims resumeClaims
+	if err := json.Unmarshal(plaintext, &claims); err != nil || claims.User == "" || claims.IssuedAt == 0 {
+		return nil, errInvalidResumeToken
+	}
+	now := t.clock.Now()
+	if now.Unix() >= claims.ExpiresAt || !now.Before(time.Unix(claims.IssuedAt, 0).Add(t.maxLifetime)) {
+		return nil, errInvalidResumeToken
+	}
+	return &claims, nil
+}
+
+type resumeClaimsKey struct{}
+
+// resumeClaimsFromContext returns the claims of the resume token a refresh
+// request presented, or nil.
+func resumeClaimsFromContext(ctx context.Context) *resumeClaims {
+	claims, _ := ctx.Value(resumeClaimsKey{}).(*resumeClaims)
+	return claims
+}
+
+type refreshRequest struct {
+	ResumeToken string `json:"resume_token"`
+}
+
+// handleRefresh mints a new session from a resume token. The token stands in
+// for the user and workflow of the original request, so a client whose
+// session expired can pick up where it left off; everything else, including
+// rate limits, quotas, and bans, applies as for a new session. A token issued
+// to a verified identity is only accepted from that identity, and one
+// issued without is not accepted from a verified identity.
+func (h *sessionHandler) handleRefresh(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodyBytes)
+	var req refreshRequest
+	problems, err := decodeJSONObject(r.Body, &req)
+	if err != nil {
+		http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if req.ResumeToken == "" && len(problems) == 0 {
+		problems = append(problems, fieldError{Field: "resume_token", Code: validationCodeRequired, Message: "resume_token is required"})
+	}
+	if len(problems) > 0 {
+		writeValidationErrors(w, problems)
+		return
+	}
+	claims, err := h.resumeTokens.open(req.ResumeToken)
+	id, _ := IdentityFromContext(r.Context())
+	if err == nil && (claims.Tenant != requestTenant(r.Context()) || claims.Subject != id.Subject) {
+		err = errInvalidResumeToken
+	}
+	if err != nil {
+		slog.InfoContext(r.Context(), "rejected resume token", "error", err)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	addLogFields(r.Context(), slog.Bool("resumed", true))
+
+	body, _ := json.Marshal(sessionRequest{User: claims.User})
+	r = r.WithContext(context.WithValue(r.Context(), resumeClaimsKey{}, claims))
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	h.handleSession(w, r)
+}
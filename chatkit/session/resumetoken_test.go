@@ -0,0 +1,188 @@
+package session
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testResumeTokenKey = "0123456789abcdef0123456789abcdef"
+
+func TestResumeTokensRoundTrip(t *testing.T) {
+	if _, err := newResumeTokens("short", time.Hour, 24*time.Hour); err == nil {
+		t.Fatal("expected a short key to be rejected")
+	}
+	if _, err := newResumeTokens(testResumeTokenKey, time.Hour, time.Minute); err == nil {
+		t.Fatal("expected a maximum lifetime below the TTL to be rejected")
+	}
+	tokens, err := newResumeTokens(testResumeTokenKey, time.Hour, 24*time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	now := time.Unix(1_700_000_000, 0)
+	tokens.clock = ClockFunc(func() time.Time { return now })
+
+	token, err := tokens.issue("u", "wf_support", "acme", "", time.Time{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(token, resumeTokenPrefix) || strings.Contains(token, "wf_support") {
+		t.Fatalf("expected an opaque token, got %q", token)
+	}
+	claims, err := tokens.open(token)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if claims.User != "u" || claims.WorkflowID != "wf_support" || claims.Tenant != "acme" || claims.IssuedAt != now.Unix() || claims.ExpiresAt != now.Add(time.Hour).Unix() {
+		t.Fatalf("unexpected claims %+v", claims)
+	}
+
+	tampered := []byte(token)
+	tampered[len(tampered)-2] ^= 1
+	other, _ := newResumeTokens(strings.Repeat("x", minResumeTokenKeyLen), time.Hour, 24*time.Hour)
+	for _, bad := range []string{"", "rt_", "rt_!!", string(tampered), token[len(resumeTokenPrefix):]} {
+		if _, err := tokens.open(bad); err == nil {
+			t.Fatalf("expected %q to be rejected", bad)
+		}
+	}
+	if _, err := other.open(token); err == nil {
+		t.Fatal("expected a token sealed with another key to be rejected")
+	}
+
+	now = now.Add(time.Hour)
+	if _, err := tokens.open(token); err == nil {
+		t.Fatal("expected an expired token to be rejected")
+	}
+
+	chained, err := tokens.issue("u", "wf_support", "acme", "", now.Add(-23*time.Hour-30*time.Minute))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if claims, err := tokens.open(chained); err != nil || claims.ExpiresAt != now.Add(30*time.Minute).Unix() {
+		t.Fatalf("expected a refreshed token to expire with its chain, got %+v, %v", claims, err)
+	}
+}
+
+func TestHandleRefreshMintsFromResumeToken(t *testing.T) {
+	fake := &fakeSessionCreator{clientSecret: "secret"}
+	handler := newSessionHandler(fake.Create, "w", 1200, 10)
+	handler.allowedWorkflows = map[string]bool{"wf_support": true}
+	tokens, err := newResumeTokens(testResumeTokenKey, time.Hour, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.resumeTokens = tokens
+	router, err := newRouter(handler, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	post := func(path, body string) (*httptest.ResponseRecorder, sessionResponse) {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		var resp sessionResponse
+		_ = json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec, resp
+	}
+
+	rec, created := post(defaultSessionPath, `{"user":"u","workflow_id":"wf_support"}`)
+	if rec.Code != http.StatusOK || created.ResumeToken == "" {
+		t.Fatalf("expected a resume token, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	fake.params.User, fake.params.Workflow.ID = "", ""
+	rec, refreshed := post(refreshPath, `{"resume_token":"`+created.ResumeToken+`"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if fake.params.User != "u" || fake.params.Workflow.ID != "wf_support" {
+		t.Fatalf("expected the token's user and workflow, got %q %q", fake.params.User, fake.params.Workflow.ID)
+	}
+	if refreshed.ClientSecret != "secret" || refreshed.ResumeToken == "" {
+		t.Fatalf("expected a new session and token, got %+v", refreshed)
+	}
+
+	if rec, _ := post(refreshPath, `{"resume_token":"rt_forged"}`); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected a forged token to be rejected, got %d", rec.Code)
+	}
+	if rec, _ := post(refreshPath, `{}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected a missing token to be rejected, got %d", rec.Code)
+	}
+}
+
+func TestHandleRefreshBindsTokensToIdentity(t *testing.T) {
+	fake := &fakeSessionCreator{clientSecret: "secret"}
+	handler := newSessionHandler(fake.Create, "w", 1200, 10)
+	tokens, err := newResumeTokens(testResumeTokenKey, time.Hour, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.resumeTokens = tokens
+	issuedAt := time.Now().Add(-time.Hour).Truncate(time.Second)
+	token, err := tokens.issue("alice", "w", "", "alice", issuedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refresh := func(subject string) (*httptest.ResponseRecorder, sessionResponse) {
+		req := httptest.NewRequest(http.MethodPost, refreshPath, strings.NewReader(`{"resume_token":"`+token+`"}`))
+		if subject != "" {
+			req = req.WithContext(ContextWithIdentity(req.Context(), Identity{Subject: subject}))
+		}
+		rec := httptest.NewRecorder()
+		handler.handleRefresh(rec, req)
+		var resp sessionResponse
+		_ = json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec, resp
+	}
+
+	for _, subject := range []string{"mallory", ""} {
+		if rec, _ := refresh(subject); rec.Code != http.StatusUnauthorized || fake.called {
+			t.Fatalf("expected the token to be rejected for %q, got %d", subject, rec.Code)
+		}
+	}
+	rec, resp := refresh("alice")
+	if rec.Code != http.StatusOK || fake.params.User != "alice" {
+		t.Fatalf("expected the token's identity to refresh, got %d: %s", rec.Code, rec.Body.String())
+	}
+	claims, err := tokens.open(resp.ResumeToken)
+	if err != nil || claims.Subject != "alice" || claims.IssuedAt != issuedAt.Unix() {
+		t.Fatalf("expected the new token to keep the identity and issue time, got %+v, %v", claims, err)
+	}
+}
+
+func TestHandleRefreshRevalidatesWorkflow(t *testing.T) {
+	fake := &fakeSessionCreator{clientSecret: "secret"}
+	handler := newSessionHandler(fake.Create, "w", 1200, 10)
+	handler.allowedWorkflows = map[string]bool{"wf_support": true}
+	tokens, err := newResumeTokens(testResumeTokenKey, time.Hour, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.resumeTokens = tokens
+
+	refresh := func(workflowID string) string {
+		token, err := tokens.issue("u", workflowID, "", "", time.Time{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest(http.MethodPost, refreshPath, strings.NewReader(`{"resume_token":"`+token+`"}`))
+		rec := httptest.NewRecorder()
+		handler.handleRefresh(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		return fake.params.Workflow.ID
+	}
+
+	if got := refresh("wf_support"); got != "wf_support" {
+		t.Fatalf("expected the allowed workflow to be resumed, got %q", got)
+	}
+	if got := refresh("wf_retired"); got != "w" {
+		t.Fatalf("expected a workflow that is no longer allowed to fall back to the default, got %q", got)
+	}
+}
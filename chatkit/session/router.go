@@ -0,0 +1,134 @@
+package session
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// middleware wraps a handler with additional behaviour.
+type middleware func(http.Handler) http.Handler
+
+// routerOptions customizes newRouter. A nil *routerOptions serves the
+// default paths with the default pipeline order.
+type routerOptions struct {
+	paths    routePaths
+	pipeline *pipeline
+	// validateRequests checks requests against the served OpenAPI
+	// document before they reach their handlers.
+	validateRequests bool
+}
+
+type route struct {
+	methods map[string]http.Handler
+	allow   string
+
+	// methodNotAllowed counts requests answered with 405.
+	methodNotAllowed atomic.Int64
+}
+
+// ServeHTTP dispatches on the request method, answering 405 with an Allow
+// header for methods the route does not handle.
+func (rt *route) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h, ok := rt.methods[r.Method]
+	if !ok {
+		rt.methodNotAllowed.Add(1)
+		w.Header().Set("Allow", rt.allow)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	h.ServeHTTP(w, r)
+}
+
+// routeRegistry collects method-specific routes and rejects conflicting
+// registrations when the router is built.
+type routeRegistry struct {
+	// remap moves every registered path, for example under a global prefix.
+	remap routePaths
+
+	routes map[string]*route
+	paths  []string
+	errs   []string
+}
+
+func newRouteRegistry() *routeRegistry {
+	return &routeRegistry{routes: make(map[string]*route)}
+}
+
+// handle registers h for method on path, wrapped in mws with the first
+// middleware outermost. Registering GET also serves HEAD unless HEAD is
+// registered explicitly.
+func (reg *routeRegistry) handle(method, path string, h http.HandlerFunc, mws ...middleware) {
+	if !strings.HasPrefix(path, "/") {
+		reg.errs = append(reg.errs, fmt.Sprintf("route %s %q: path must start with /", method, path))
+		return
+	}
+	path = reg.remap.resolve(path)
+
+	rt, ok := reg.routes[path]
+	if !ok {
+		rt = &route{methods: make(map[string]http.Handler)}
+		reg.routes[path] = rt
+		reg.paths = append(reg.paths, path)
+	}
+	if _, exists := rt.methods[method]; exists {
+		reg.errs = append(reg.errs, fmt.Sprintf("duplicate route %s %s", method, path))
+		return
+	}
+
+	var handler http.Handler = h
+	for i := len(mws) - 1; i >= 0; i-- {
+		handler = mws[i](handler)
+	}
+	rt.methods[method] = handler
+}
+
+// build returns the router, or an error listing every conflicting
+// registration.
+func (reg *routeRegistry) build() (http.Handler, error) {
+	if len(reg.errs) > 0 {
+		return nil, fmt.Errorf("invalid routes: %s", strings.Join(reg.errs, "; "))
+	}
+
+	mux := http.NewServeMux()
+	for _, path := range reg.paths {
+		rt := reg.routes[path]
+		if get, ok := rt.methods[http.MethodGet]; ok {
+			if _, ok := rt.methods[http.MethodHead]; !ok {
+				rt.methods[http.MethodHead] = get
+			}
+		}
+		methods := make([]string, 0, len(rt.methods))
+		for m := range rt.methods {
+			methods = append(methods, m)
+		}
+		sort.Strings(methods)
+		rt.allow = strings.Join(methods, ", ")
+		mux.Handle(path, rt)
+	}
+	return mux, nil
+}
+
+// routeStats is the admin view of one registered path.
+type routeStats struct {
+	Path             string   `json:"path"`
+	Methods          []string `json:"methods"`
+	MethodNotAllowed int64    `json:"method_not_allowed"`
+}
+
+// stats reports every registered path with its methods and 405 count, in
+// registration order. It is only meaningful after build.
+func (reg *routeRegistry) stats() []routeStats {
+	stats := make([]routeStats, 0, len(reg.paths))
+	for _, path := range reg.paths {
+		rt := reg.routes[path]
+		stats = append(stats, routeStats{
+			Path:             path,
+			Methods:          strings.Split(rt.allow, ", "),
+			MethodNotAllowed: rt.methodNotAllowed.Load(),
+		})
+	}
+	return stats
+}
@@ -0,0 +1,142 @@
+package session
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"openai-chatkit-backend/openapi"
+)
+
+func TestRouteRegistryMethodNotAllowed(t *testing.T) {
+	routes := newRouteRegistry()
+	routes.handle(http.MethodGet, "/things", func(w http.ResponseWriter, r *http.Request) {})
+	routes.handle(http.MethodPost, "/things", func(w http.ResponseWriter, r *http.Request) {})
+	router, err := routes.build()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodDelete, "/things", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected status 405, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("Allow"); got != "GET, HEAD, POST" {
+		t.Fatalf("unexpected Allow header: %q", got)
+	}
+}
+
+func TestRouteRegistryServesHeadForGet(t *testing.T) {
+	routes := newRouteRegistry()
+	routes.handle(http.MethodGet, "/healthz", healthHandler)
+	router, err := routes.build()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodHead, "/healthz", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rec.Code)
+	}
+}
+
+func TestRouteRegistryRejectsDuplicates(t *testing.T) {
+	routes := newRouteRegistry()
+	routes.handle(http.MethodGet, "/healthz", healthHandler)
+	routes.handle(http.MethodGet, "/healthz", healthHandler)
+	routes.handle(http.MethodGet, "healthz", healthHandler)
+
+	_, err := routes.build()
+	if err == nil {
+		t.Fatalf("expected duplicate route error")
+	}
+	if !strings.Contains(err.Error(), "duplicate route GET /healthz") || !strings.Contains(err.Error(), "must start with /") {
+		t.Fatalf("expected every conflict to be reported, got %v", err)
+	}
+}
+
+func TestRouteRegistryMiddlewareOrder(t *testing.T) {
+	var order []string
+	tag := func(name string) middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	routes := newRouteRegistry()
+	routes.handle(http.MethodGet, "/x", func(w http.ResponseWriter, r *http.Request) {
+		order = append(order, "handler")
+	}, tag("outer"), tag("inner"))
+	router, err := routes.build()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/x", nil))
+
+	if strings.Join(order, ",") != "outer,inner,handler" {
+		t.Fatalf("unexpected middleware order: %v", order)
+	}
+}
+
+func TestRouteRegistryCountsMethodNotAllowed(t *testing.T) {
+	routes := newRouteRegistry()
+	routes.handle(http.MethodPost, "/things", func(w http.ResponseWriter, r *http.Request) {})
+	routes.handle(http.MethodGet, "/healthz", healthHandler)
+	router, err := routes.build()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, method := range []string{http.MethodGet, http.MethodPut, http.MethodPost} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, "/things", nil))
+	}
+
+	stats := routes.stats()
+	if len(stats) != 2 {
+		t.Fatalf("expected 2 routes, got %+v", stats)
+	}
+	if stats[0].Path != "/things" || stats[0].MethodNotAllowed != 2 || strings.Join(stats[0].Methods, ",") != "POST" {
+		t.Fatalf("unexpected stats for /things: %+v", stats[0])
+	}
+	if stats[1].MethodNotAllowed != 0 || strings.Join(stats[1].Methods, ",") != "GET,HEAD" {
+		t.Fatalf("unexpected stats for /healthz: %+v", stats[1])
+	}
+}
+
+func TestOpenAPIDocumentMatchesRoutes(t *testing.T) {
+	var spec struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(openapi.Document, &spec); err != nil {
+		t.Fatalf("embedded OpenAPI document is invalid: %v", err)
+	}
+	handler := newSessionHandler(nil, "w", 1200, 10)
+	// Optional documented routes are served only when enabled.
+	handler.prefs, _ = newLocalPrefsStore("")
+	handler.resumeTokens, _ = newResumeTokens(strings.Repeat("k", minResumeTokenKeyLen), defaultResumeTokenTTL, defaultResumeTokenMaxLifetime)
+	router, err := newRouter(handler, nil, nil, newReadinessProbe(), nil)
+	if err != nil {
+		t.Fatalf("unexpected router error: %v", err)
+	}
+	for path, methods := range spec.Paths {
+		for method := range methods {
+			req := httptest.NewRequest(http.MethodOptions, path, nil)
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			if !strings.Contains(rec.Header().Get("Allow"), strings.ToUpper(method)) {
+				t.Fatalf("documented route %s %s is not served (Allow: %q)", strings.ToUpper(method), path, rec.Header().Get("Allow"))
+			}
+		}
+	}
+}
@@ -0,0 +1,1098 @@
+package session
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/openai/openai-go/v3"
+	"github.com/openai/openai-go/v3/shared/constant"
+
+	"openai-chatkit-backend/chatkit/auth"
+	"openai-chatkit-backend/chatkit/config"
+	"openai-chatkit-backend/chatkit/cors"
+	"openai-chatkit-backend/chatkit/jobs"
+	"openai-chatkit-backend/chatkit/redis"
+	"openai-chatkit-backend/chatkit/server"
+	"openai-chatkit-backend/chatkit/webhooks"
+)
+
+const (
+	defaultAddr          = ":8080"
+	openaiRequestTimeout = 15 * time.Second
+	// serverShutdownTimeout lets an OpenAI call that started just before
+	// shutdown finish.
+	serverShutdownTimeout = openaiRequestTimeout + time.Second
+	maxRequestBodyBytes   = 4096
+	readTimeout           = 10 * time.Second
+	readHeaderTimeout     = 5 * time.Second
+	writeTimeout          = 15 * time.Second
+	idleTimeout           = 60 * time.Second
+	contentTypeJSON       = "application/json"
+	deviceIDHeader        = "X-Device-ID"
+)
+
+// Scheduled jobs.
+const (
+	jobCleanup         = "cleanup"
+	jobRetention       = "retention"
+	jobUsageExport     = "usage_export"
+	jobUsageCheckpoint = "usage_checkpoint"
+	jobKeyValidation   = "key_validation"
+)
+
+// Serve runs the full server, configured from the environment and the
+// config file loaded with config.LoadProfile, until ctx is done, and then
+// shuts it down gracefully. stages adds named middleware to the session
+// route pipeline. It returns an error when the configuration is invalid,
+// the server fails to start or stops serving, or shutdown fails. Settings
+// the config helpers cannot parse still end the process, as documented by
+// package config.
+func Serve(ctx context.Context, stages ...Middleware) error {
+	defaultLevel := "info"
+	if config.Bool("DEBUG") {
+		defaultLevel = "debug"
+	}
+	logger, err := newLogger(os.Stderr, config.Get("LOG_FORMAT", logFormatText), config.Get("LOG_LEVEL", defaultLevel))
+	if err != nil {
+		return err
+	}
+	region, err := parseRegion(config.Get("REGION", ""))
+	if err != nil {
+		return err
+	}
+	if region != "" {
+		logger = logger.With("region", region)
+	}
+	slog.SetDefault(logger)
+
+	addr := config.Get("ADDR", defaultAddr)
+
+	apiKey, err := config.Require("OPENAI_API_KEY")
+	if err != nil {
+		return err
+	}
+
+	httpClient, err := openAIHTTPClientFromEnv(func(key string) string { return config.Get(key, "") })
+	if err != nil {
+		return err
+	}
+	gatewaySigner, err := gatewaySignerFromEnv(func(key string) string { return config.Get(key, "") })
+	if err != nil {
+		return err
+	}
+	if gatewaySigner != nil {
+		if config.Get("OPENAI_BASE_URL", "") == "" {
+			return errors.New("OPENAI_GATEWAY_SIGNING requires OPENAI_BASE_URL to point at the gateway")
+		}
+		httpClient = gatewaySigningClient(httpClient, gatewaySigner, SystemClock)
+	}
+
+	workflowID, err := config.Require("CHATKIT_WORKFLOW_ID")
+	if err != nil {
+		return err
+	}
+	expiresAfterSeconds, err := config.RequireInt64("CHATKIT_EXPIRES_AFTER_SECONDS")
+	if err != nil {
+		return err
+	}
+	if expiresAfterSeconds < 0 {
+		return errors.New("CHATKIT_EXPIRES_AFTER_SECONDS must be non-negative")
+	}
+	rateLimitPerMinute, err := config.RequireInt64("CHATKIT_RATE_LIMIT_PER_MINUTE")
+	if err != nil {
+		return err
+	}
+	if rateLimitPerMinute < 0 {
+		return errors.New("CHATKIT_RATE_LIMIT_PER_MINUTE must be non-negative")
+	}
+
+	upstreamQuotaWarnPercent, err := config.Int64("CHATKIT_UPSTREAM_QUOTA_WARN_PERCENT", defaultUpstreamQuotaWarnPercent)
+	if err != nil {
+		return err
+	}
+	upstreamQuota := newUpstreamQuotaMonitor(upstreamQuotaWarnPercent)
+	upstreamHTTPClient := upstreamQuota.client(httpClient)
+
+	readyOpenAICheck := config.Get("CHATKIT_READY_OPENAI_CHECK", readyOpenAIRecent)
+	var credentials *credentialMonitor
+	switch readyOpenAICheck {
+	case readyOpenAIPing, readyOpenAIOff:
+	case readyOpenAIRecent:
+		window, err := config.Int64("CHATKIT_READY_RECENT_CALLS", defaultReadyRecentCalls)
+		if err != nil {
+			return err
+		}
+		if window < 1 {
+			return errors.New("CHATKIT_READY_RECENT_CALLS must be positive")
+		}
+		credentials = newCredentialMonitor(int(window))
+		upstreamHTTPClient = credentials.client(upstreamHTTPClient)
+	default:
+		return fmt.Errorf("invalid CHATKIT_READY_OPENAI_CHECK %q: expected ping, recent, or off", readyOpenAICheck)
+	}
+
+	client := NewOpenAIClient(OpenAIClientConfig{
+		APIKey:     apiKey,
+		BaseURL:    config.Get("OPENAI_BASE_URL", ""),
+		HTTPClient: upstreamHTTPClient,
+	})
+
+	chatKitVersion := config.Get("CHATKIT_UPSTREAM_API_VERSION", nativeChatKitAPIVersion)
+	chatKit, err := newChatKitAPI(client, chatKitVersion)
+	if err != nil {
+		return fmt.Errorf("invalid CHATKIT_UPSTREAM_API_VERSION: %w", err)
+	}
+	if chatKitVersion != nativeChatKitAPIVersion {
+		slog.Warn("using ChatKit beta API version through the compatibility shim", "version", chatKitVersion, "sdk_version", nativeChatKitAPIVersion)
+	}
+
+	workflowCacheSeconds, err := config.Int64("CHATKIT_WORKFLOW_CACHE_SECONDS", int64(defaultWorkflowCacheTTL/time.Second))
+	if err != nil {
+		return err
+	}
+	workflowLookups := newWorkflowCache(func(ctx context.Context, ref workflowRef) error {
+		return probeWorkflow(ctx, chatKit, ref)
+	}, time.Duration(workflowCacheSeconds)*time.Second)
+
+	sessionHandler := newSessionHandler(
+		chatKit.CreateSession,
+		workflowID,
+		expiresAfterSeconds,
+		rateLimitPerMinute,
+	)
+
+	identity, err := auth.NewMapper(config.Get("CHATKIT_USER_TEMPLATE", ""), config.Get("CHATKIT_STATE_TEMPLATES", ""))
+	if err != nil {
+		return fmt.Errorf("invalid identity mapping: %w", err)
+	}
+	sessionHandler.identity = identity
+
+	jwksURL := config.Get("CHATKIT_JWT_JWKS_URL", "")
+	introspectionURL := config.Get("CHATKIT_TOKEN_INTROSPECTION_URL", "")
+	if jwksURL != "" || introspectionURL != "" {
+		sessionHandler.auth = auth.NewVerifier(jwksURL, config.Get("CHATKIT_JWT_ISSUER", ""), config.Get("CHATKIT_JWT_AUDIENCE", ""))
+	}
+	if introspectionURL != "" {
+		cacheSeconds, err := config.Int64("CHATKIT_TOKEN_INTROSPECTION_CACHE_SECONDS", int64(auth.DefaultIntrospectionCacheTTL/time.Second))
+		if err != nil {
+			return err
+		}
+		if cacheSeconds < 0 {
+			return errors.New("CHATKIT_TOKEN_INTROSPECTION_CACHE_SECONDS must not be negative")
+		}
+		sessionHandler.auth.Introspection = auth.NewIntrospector(introspectionURL, config.Get("CHATKIT_TOKEN_INTROSPECTION_CLIENT_ID", ""), config.Get("CHATKIT_TOKEN_INTROSPECTION_CLIENT_SECRET", ""), time.Duration(cacheSeconds)*time.Second)
+	}
+
+	attribution, err := newAttributionPolicy(config.Get("CHATKIT_ATTRIBUTION_HEADERS", ""))
+	if err != nil {
+		return fmt.Errorf("invalid CHATKIT_ATTRIBUTION_HEADERS: %w", err)
+	}
+	sessionHandler.attribution = attribution
+
+	var redisClient *redis.Client
+	if redisURL := config.Get("REDIS_URL", ""); redisURL != "" {
+		redisClient, err = redis.NewClient(redisURL, config.Get("CHATKIT_REDIS_KEY_PREFIX", redis.DefaultKeyPrefix))
+		if err != nil {
+			return fmt.Errorf("invalid REDIS_URL: %w", err)
+		}
+	}
+
+	quotaLimit, err := config.Int64("CHATKIT_USER_SESSION_QUOTA", 0)
+	if err != nil {
+		return err
+	}
+	if quotaLimit > 0 {
+		warnPercent, err := config.Int64("CHATKIT_QUOTA_WARN_PERCENT", defaultQuotaWarnPercent)
+		if err != nil {
+			return err
+		}
+		if warnPercent < 0 || warnPercent > 100 {
+			return errors.New("CHATKIT_QUOTA_WARN_PERCENT must be between 0 and 100")
+		}
+		sessionHandler.quota = newQuotaTracker(quotaLimit, warnPercent, defaultQuotaWindow)
+	} else if quotaLimit < 0 {
+		return errors.New("CHATKIT_USER_SESSION_QUOTA must be non-negative")
+	}
+	activeLimit, err := config.Int64("CHATKIT_MAX_ACTIVE_SESSIONS_PER_USER", 0)
+	if err != nil {
+		return err
+	}
+	if activeLimit < 0 {
+		return errors.New("CHATKIT_MAX_ACTIVE_SESSIONS_PER_USER must be non-negative")
+	} else {
+		sessionHandler.activeSessions = newActiveSessionCap(activeLimit)
+		if sessionHandler.activeSessions != nil {
+			sessionHandler.activeSessions.shared = redisClient
+		}
+	}
+
+	rateLimits := make(map[string]int64)
+	for _, key := range []string{"CHATKIT_IP_RATE_LIMIT_PER_MINUTE", "CHATKIT_IP_RATE_LIMIT_BURST", "CHATKIT_USER_RATE_LIMIT_PER_MINUTE", "CHATKIT_USER_RATE_LIMIT_BURST"} {
+		v, err := config.Int64(key, 0)
+		if err != nil {
+			return err
+		}
+		if v < 0 {
+			return fmt.Errorf("%s must be non-negative", key)
+		}
+		rateLimits[key] = v
+	}
+	sessionHandler.ipLimit = newRateLimiter(rateLimits["CHATKIT_IP_RATE_LIMIT_PER_MINUTE"], rateLimits["CHATKIT_IP_RATE_LIMIT_BURST"])
+	sessionHandler.userLimit = newRateLimiter(rateLimits["CHATKIT_USER_RATE_LIMIT_PER_MINUTE"], rateLimits["CHATKIT_USER_RATE_LIMIT_BURST"])
+	for name, limiter := range map[string]*rateLimiter{"ip": sessionHandler.ipLimit, "user": sessionHandler.userLimit} {
+		if limiter != nil {
+			limiter.shared, limiter.name = redisClient, name
+		}
+	}
+
+	idempotencySeconds, err := config.Int64("CHATKIT_IDEMPOTENCY_TTL_SECONDS", int64(defaultIdempotencyTTL/time.Second))
+	if err != nil {
+		return err
+	}
+	if idempotencySeconds > 0 {
+		sessionHandler.idempotencyTTL = time.Duration(idempotencySeconds) * time.Second
+		if redisClient != nil {
+			sessionHandler.idempotency = newRedisIdempotencyStore(redisClient)
+		} else {
+			sessionHandler.idempotency = newLocalIdempotencyStore()
+		}
+	} else if idempotencySeconds < 0 {
+		return errors.New("CHATKIT_IDEMPOTENCY_TTL_SECONDS must be non-negative")
+	}
+
+	if config.Bool("CHATKIT_USER_PREFS") {
+		if redisClient != nil {
+			sessionHandler.prefs = &redisPrefsStore{client: redisClient}
+		} else {
+			prefs, err := newLocalPrefsStore(config.Get("CHATKIT_USER_PREFS_FILE", ""))
+			if err != nil {
+				return fmt.Errorf("failed to load user preferences: %w", err)
+			}
+			sessionHandler.prefs = prefs
+		}
+	}
+
+	if config.Bool("CHATKIT_SERIALIZE_USER_SESSIONS") {
+		sessionHandler.userLocks = newStripedUserLocker(defaultUserLockStripes)
+	}
+
+	serviceHours, err := newServiceHoursPolicy(config.Get("CHATKIT_SERVICE_HOURS", ""), config.Get("CHATKIT_TENANT_SERVICE_HOURS", ""), config.Get("CHATKIT_SERVICE_TIMEZONE", ""))
+	if err != nil {
+		return fmt.Errorf("invalid service hours: %w", err)
+	}
+	sessionHandler.serviceHours = serviceHours
+
+	platformLimits, err := parsePlatformLimits(config.Get("CHATKIT_PLATFORM_RATE_LIMITS", ""))
+	if err != nil {
+		return fmt.Errorf("invalid CHATKIT_PLATFORM_RATE_LIMITS: %w", err)
+	}
+	sessionHandler.platformLimits = platformLimits
+
+	testers, err := newTesterPolicy(config.Get("CHATKIT_TESTER_API_KEYS", ""), config.Get("CHATKIT_TESTER_CLAIM", ""))
+	if err != nil {
+		return fmt.Errorf("invalid CHATKIT_TESTER_CLAIM: %w", err)
+	}
+	sessionHandler.testers = testers
+
+	if secret := config.Get("CHATKIT_REQUEST_SIGNING_SECRET", ""); secret != "" {
+		window, err := config.Int64("CHATKIT_REQUEST_SIGNING_WINDOW_SECONDS", int64(defaultRequestSignatureWindow/time.Second))
+		if err != nil {
+			return err
+		}
+		if window <= 0 {
+			return errors.New("CHATKIT_REQUEST_SIGNING_WINDOW_SECONDS must be positive")
+		}
+		sessionHandler.signer = newRequestSigner(secret, time.Duration(window)*time.Second)
+	}
+
+	if config.Bool("CHATKIT_FAILURE_INJECTION") {
+		failures, err := newFailureInjector(config.Get("CHATKIT_ENVIRONMENT", ""), config.Get("CHATKIT_FAILURE_INJECTION_ORIGINS", ""), testers)
+		if err != nil {
+			return fmt.Errorf("invalid CHATKIT_FAILURE_INJECTION: %w", err)
+		}
+		sessionHandler.failures = failures
+	}
+
+	secretCookie, err := newSecretCookie(
+		config.Get("CHATKIT_CLIENT_SECRET_DELIVERY", ""),
+		config.Get("CHATKIT_CLIENT_SECRET_COOKIE_NAME", ""),
+		config.Get("CHATKIT_CLIENT_SECRET_COOKIE_DOMAIN", ""),
+		config.Get("CHATKIT_CLIENT_SECRET_COOKIE_PATH", ""),
+	)
+	if err != nil {
+		return fmt.Errorf("invalid client secret delivery: %w", err)
+	}
+	sessionHandler.secretCookie = secretCookie
+
+	if key := config.Get("CHATKIT_RESUME_TOKEN_KEY", ""); key != "" {
+		ttl, err := config.Int64("CHATKIT_RESUME_TOKEN_TTL_SECONDS", int64(defaultResumeTokenTTL/time.Second))
+		if err != nil {
+			return err
+		}
+		maxLifetime, err := config.Int64("CHATKIT_RESUME_TOKEN_MAX_LIFETIME_SECONDS", int64(defaultResumeTokenMaxLifetime/time.Second))
+		if err != nil {
+			return err
+		}
+		resumeTokens, err := newResumeTokens(key, time.Duration(ttl)*time.Second, time.Duration(maxLifetime)*time.Second)
+		if err != nil {
+			return fmt.Errorf("invalid resume token settings: %w", err)
+		}
+		sessionHandler.resumeTokens = resumeTokens
+	}
+
+	redact := newRedactor(config.Get("CHATKIT_REDACT_FIELDS", ""))
+	sessionHandler.redact = redact
+
+	var journal *requestJournal
+	journalSize, err := config.Int64("CHATKIT_JOURNAL_SIZE", 0)
+	if err != nil {
+		return err
+	}
+	if journalFile := config.Get("CHATKIT_JOURNAL_FILE", ""); journalSize > 0 || journalFile != "" {
+		if journalSize <= 0 {
+			journalSize = defaultJournalSize
+		}
+		journal, err = newRequestJournal(int(journalSize), journalFile, redact)
+		if err != nil {
+			return fmt.Errorf("failed to open request journal: %w", err)
+		}
+	}
+
+	sessionHandler.maintenance = newMaintenanceMode(config.Bool("CHATKIT_MAINTENANCE_MODE"), config.Get("CHATKIT_MAINTENANCE_MESSAGE", ""))
+	sessionHandler.statusPageURL = config.Get("CHATKIT_STATUS_PAGE_URL", "")
+	sessionHandler.region = region
+	sessionHandler.failover, err = newRegionFailover(config.Get("CHATKIT_FAILOVER_URL", ""), config.Get("CHATKIT_FAILOVER_REGION", ""))
+	if err != nil {
+		return err
+	}
+	threshold, err := config.Int64("CHATKIT_CIRCUIT_BREAKER_THRESHOLD", defaultBreakerThreshold)
+	if err != nil {
+		return err
+	}
+	if threshold > 0 {
+		cooldownSeconds, err := config.Int64("CHATKIT_CIRCUIT_BREAKER_COOLDOWN_SECONDS", int64(defaultBreakerCooldown/time.Second))
+		if err != nil {
+			return err
+		}
+		sessionHandler.breaker = newCircuitBreaker(int(threshold), time.Duration(cooldownSeconds)*time.Second)
+	}
+	sloTarget, err := config.Float("CHATKIT_SLO_TARGET", defaultSLOTarget)
+	if err != nil {
+		return err
+	}
+	sloLatencyMS, err := config.Int64("CHATKIT_SLO_LATENCY_MS", defaultSLOLatency.Milliseconds())
+	if err != nil {
+		return err
+	}
+	sloBurnAlert, err := config.Float("CHATKIT_SLO_BURN_RATE_ALERT", defaultSLOBurnAlert)
+	if err != nil {
+		return err
+	}
+	sessionHandler.slo = newLatencySLO(sloTarget, time.Duration(sloLatencyMS)*time.Millisecond, sloBurnAlert)
+	if target := sessionHandler.slo.target; target <= 0 || target >= 1 {
+		return errors.New("CHATKIT_SLO_TARGET must be between 0 and 1")
+	}
+	if config.Bool("CHATKIT_ANOMALY_DETECTION") {
+		minSessions, err := config.Int64("CHATKIT_ANOMALY_MIN_SESSIONS", defaultAnomalyMinSessions)
+		if err != nil {
+			return err
+		}
+		if minSessions < 1 {
+			return errors.New("CHATKIT_ANOMALY_MIN_SESSIONS must be positive")
+		}
+		sessionHandler.anomalies = newAnomalyDetector(minSessions)
+		perMinute, err := config.Int64("CHATKIT_ANOMALY_THROTTLE_PER_MINUTE", 0)
+		if err != nil {
+			return err
+		}
+		if perMinute > 0 {
+			sessionHandler.anomalies.throttle = newRateLimiter(perMinute, 0)
+			sessionHandler.anomalies.throttleFor = defaultAnomalyThrottleFor
+			if v := config.Get("CHATKIT_ANOMALY_THROTTLE_DURATION", ""); v != "" {
+				d, err := time.ParseDuration(v)
+				if err != nil || d <= 0 {
+					return fmt.Errorf("invalid CHATKIT_ANOMALY_THROTTLE_DURATION %q: expected a positive Go duration such as 15m", v)
+				}
+				sessionHandler.anomalies.throttleFor = d
+			}
+		}
+	}
+	concurrency, err := config.Int64("CHATKIT_ADMISSION_CONCURRENCY", 0)
+	if err != nil {
+		return err
+	}
+	if concurrency > 0 {
+		maxWaitMS, err := config.Int64("CHATKIT_ADMISSION_MAX_WAIT_MS", defaultAdmissionMaxWait.Milliseconds())
+		if err != nil {
+			return err
+		}
+		queueSize, err := config.Int64("CHATKIT_ADMISSION_QUEUE_SIZE", concurrency*4)
+		if err != nil {
+			return err
+		}
+		sessionHandler.admission = newAdmissionQueue(int(concurrency), int(queueSize), time.Duration(maxWaitMS)*time.Millisecond)
+		weights, err := parseTenantWeights(config.Get("CHATKIT_ADMISSION_TENANT_WEIGHTS", ""))
+		if err != nil {
+			return fmt.Errorf("invalid CHATKIT_ADMISSION_TENANT_WEIGHTS: %w", err)
+		}
+		sessionHandler.admission.weights = weights
+	}
+
+	bans, err := newBanList(config.Get("CHATKIT_BANLIST_FILE", ""))
+	if err != nil {
+		return fmt.Errorf("failed to load ban list: %w", err)
+	}
+	sessionHandler.bans = bans
+
+	if sandboxKeys := config.Get("CHATKIT_SANDBOX_API_KEYS", ""); sandboxKeys != "" {
+		create := sessionHandler.createSession
+		if config.Bool("CHATKIT_SANDBOX_MOCK") {
+			create = mockSessionCreator
+		}
+		sandboxExpiresAfter, err := config.Int64("CHATKIT_SANDBOX_EXPIRES_AFTER_SECONDS", defaultSandboxExpiresAfterSeconds)
+		if err != nil {
+			return err
+		}
+		sandboxRateLimit, err := config.Int64("CHATKIT_SANDBOX_RATE_LIMIT_PER_MINUTE", defaultSandboxRateLimitPerMinute)
+		if err != nil {
+			return err
+		}
+		sessionHandler.sandbox = newSandboxProfile(
+			sandboxKeys,
+			config.Get("CHATKIT_SANDBOX_WORKFLOW_ID", ""),
+			sandboxExpiresAfter,
+			sandboxRateLimit,
+			create,
+		)
+	}
+
+	sessionHandler.tenants, err = loadTenantRegistry(config.Get("CHATKIT_TENANTS_FILE", ""), config.Get("CHATKIT_TENANTS_JSON", ""), func(tenantAPIKey string) (sessionCreator, error) {
+		if tenantAPIKey == "" || tenantAPIKey == apiKey {
+			return sessionHandler.createSession, nil
+		}
+		tenantChatKit, err := newChatKitAPI(NewOpenAIClient(OpenAIClientConfig{
+			APIKey:     tenantAPIKey,
+			BaseURL:    config.Get("OPENAI_BASE_URL", ""),
+			HTTPClient: upstreamHTTPClient,
+		}), chatKitVersion)
+		if err != nil {
+			return nil, err
+		}
+		return tenantChatKit.CreateSession, nil
+	})
+	if err != nil {
+		return fmt.Errorf("invalid tenants: %w", err)
+	}
+	// Without credentials, anyone could read or replace any user's
+	// preferences, which steer that user's sessions.
+	if sessionHandler.prefs != nil && sessionHandler.auth == nil && sessionHandler.tenants == nil {
+		return errors.New("CHATKIT_USER_PREFS requires CHATKIT_JWT_JWKS_URL, CHATKIT_TOKEN_INTROSPECTION_URL, or tenants")
+	}
+	if sessionHandler.tenants != nil {
+		for _, t := range sessionHandler.tenants.tenants {
+			if sessionHandler.quota != nil {
+				t.quota = sessionHandler.quota.clone()
+			}
+			if sessionHandler.activeSessions != nil {
+				t.activeSessions = sessionHandler.activeSessions.clone(t.name)
+			}
+		}
+	}
+
+	sessionHandler.projects, err = loadProjectRouter(
+		config.Get("CHATKIT_OPENAI_PROJECTS_FILE", ""),
+		config.Get("CHATKIT_OPENAI_PROJECTS_JSON", ""),
+		config.Get("CHATKIT_ENVIRONMENT", ""),
+		config.Get("CHATKIT_OPENAI_PROJECT_TIER_CLAIM", defaultProjectTierClaim),
+		func(project openAIProjectConfig) (sessionCreator, error) {
+			projectKey := project.APIKey
+			if projectKey == "" {
+				projectKey = apiKey
+			}
+			projectChatKit, err := newChatKitAPI(NewOpenAIClient(OpenAIClientConfig{
+				APIKey:       projectKey,
+				BaseURL:      config.Get("OPENAI_BASE_URL", ""),
+				Project:      project.Project,
+				Organization: project.Organization,
+				HTTPClient:   upstreamHTTPClient,
+			}), chatKitVersion)
+			if err != nil {
+				return nil, err
+			}
+			return projectChatKit.CreateSession, nil
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("invalid OpenAI projects: %w", err)
+	}
+
+	if shadowURL := config.Get("CHATKIT_SHADOW_BASE_URL", ""); shadowURL != "" {
+		percent, err := config.Int64("CHATKIT_SHADOW_PERCENT", 0)
+		if err != nil {
+			return err
+		}
+		if percent < 0 || percent > 100 {
+			return errors.New("CHATKIT_SHADOW_PERCENT must be between 0 and 100")
+		}
+		create := mockSessionCreator
+		if shadowURL != "mock" {
+			shadowClient := NewOpenAIClient(OpenAIClientConfig{
+				APIKey:     config.Get("CHATKIT_SHADOW_API_KEY", apiKey),
+				BaseURL:    shadowURL,
+				HTTPClient: httpClient,
+			})
+			shadowChatKit, err := newChatKitAPI(shadowClient, chatKitVersion)
+			if err != nil {
+				return fmt.Errorf("invalid CHATKIT_UPSTREAM_API_VERSION: %w", err)
+			}
+			create = shadowChatKit.CreateSession
+		}
+		sessionHandler.shadow = newShadowMirror(create, float64(percent), config.Get("CHATKIT_SHADOW_WORKFLOW_ID", ""), defaultShadowMaxInFlight)
+		sessionHandler.shadow.redact = redact
+		slog.Info("mirroring session requests", "percent", percent, "target", shadowURL)
+	}
+
+	workflowAliases, err := parseWorkflowAliases(config.Get("CHATKIT_WORKFLOW_ALIASES", ""))
+	if err != nil {
+		return fmt.Errorf("invalid CHATKIT_WORKFLOW_ALIASES: %w", err)
+	}
+	sessionHandler.workflowAliases = workflowAliases
+	workflows := map[string]workflowRef{}
+	for alias, ref := range workflowAliases {
+		workflows[alias] = ref
+	}
+	sessionHandler.allowedWorkflows = parseAllowedWorkflowIDs(config.Get("CHATKIT_ALLOWED_WORKFLOW_IDS", ""))
+	for id := range sessionHandler.allowedWorkflows {
+		workflows[id] = workflowRef{ID: id}
+	}
+	workflows["default"] = workflowRef{ID: workflowID}
+	if sessionHandler.sandbox != nil && sessionHandler.sandbox.workflowID != "" {
+		workflows["sandbox"] = workflowRef{ID: sessionHandler.sandbox.workflowID}
+	}
+	sessionHandler.suspensions, err = newSuspensionList(config.Get("CHATKIT_SUSPENSIONS_FILE", ""), workflows)
+	if err != nil {
+		return fmt.Errorf("failed to load suspensions: %w", err)
+	}
+	sessionHandler.schemas, err = loadWorkflowSchemas(config.Get("CHATKIT_WORKFLOW_SCHEMA_FILE", ""), workflows)
+	if err != nil {
+		return fmt.Errorf("invalid CHATKIT_WORKFLOW_SCHEMA_FILE: %w", err)
+	}
+	sessionHandler.originPolicy, err = loadOriginPolicy(config.Get("CHATKIT_ORIGIN_POLICY_FILE", ""), workflows)
+	if err != nil {
+		return fmt.Errorf("invalid CHATKIT_ORIGIN_POLICY_FILE: %w", err)
+	}
+
+	var dispatcher *webhooks.Dispatcher
+	if webhookURL := config.Get("CHATKIT_WEBHOOK_URL", ""); webhookURL != "" {
+		webhookSecret, err := config.Require("CHATKIT_WEBHOOK_SECRET")
+		if err != nil {
+			return err
+		}
+		dispatcher, err = webhooks.NewDispatcher(webhookURL, webhookSecret, config.Get("CHATKIT_WEBHOOK_OUTBOX_FILE", ""))
+		if err != nil {
+			return fmt.Errorf("failed to load webhook outbox: %w", err)
+		}
+		dispatcher.Redact = redact.payload
+		batchSize, err := config.Int64("CHATKIT_WEBHOOK_BATCH_SIZE", 1)
+		if err != nil {
+			return err
+		}
+		if batchSize < 1 || batchSize > webhooks.MaxBatchSize {
+			return fmt.Errorf("CHATKIT_WEBHOOK_BATCH_SIZE must be between 1 and %d", webhooks.MaxBatchSize)
+		}
+		dispatcher.BatchSize = int(batchSize)
+		sessionHandler.webhooks = dispatcher
+		sessionHandler.onQuotaWarning = func(user string, status quotaStatus) {
+			dispatcher.Enqueue("quota.warning", map[string]any{
+				"user":     user,
+				"used":     status.used,
+				"limit":    status.limit,
+				"reset_at": status.resetAt.UTC(),
+			})
+		}
+		sessionHandler.slo.onAlert = func(status sloStatus) {
+			dispatcher.Enqueue("slo.burn_rate_alert", status)
+		}
+		if sessionHandler.anomalies != nil {
+			sessionHandler.anomalies.onAlert = func(alert anomalyAlert) {
+				dispatcher.Enqueue("session.anomaly", alert)
+			}
+		}
+		upstreamQuota.onLow = func(kind string, bucket rateLimitBucket) {
+			dispatcher.Enqueue("upstream_quota.low", map[string]any{
+				"limit_kind":        kind,
+				"limit":             bucket.Limit,
+				"remaining":         bucket.Remaining,
+				"remaining_percent": bucket.RemainingPercent,
+				"reset_at":          bucket.ResetAt,
+			})
+		}
+	}
+
+	deadLetterRetention := webhooks.DefaultDeadLetterRetention
+	if v := config.Get("CHATKIT_WEBHOOK_DEAD_LETTER_RETENTION", ""); v != "" {
+		if deadLetterRetention, err = time.ParseDuration(v); err != nil || deadLetterRetention <= 0 {
+			return fmt.Errorf("invalid CHATKIT_WEBHOOK_DEAD_LETTER_RETENTION %q: expected a positive duration such as 168h", v)
+		}
+	}
+	var usageCheckpoints *usageCheckpointer
+	if path := config.Get("CHATKIT_USAGE_CHECKPOINT_FILE", ""); path != "" {
+		usageCheckpoints = &usageCheckpointer{
+			path:    path,
+			usage:   sessionHandler.usage,
+			quota:   sessionHandler.quota,
+			tenants: sessionHandler.tenants,
+			clock:   SystemClock,
+		}
+		if err := usageCheckpoints.restore(); err != nil {
+			return fmt.Errorf("failed to restore usage checkpoint: %w", err)
+		}
+	}
+
+	scheduler := jobs.NewScheduler()
+	if redisClient != nil {
+		scheduler.Locker = jobs.NewRedisLocker(redisClient)
+	}
+	jobDefs := []jobs.Definition{
+		{Name: jobCleanup, Schedule: "*/5 * * * *", Enabled: true, Run: func(context.Context) error {
+			sessionHandler.ipLimit.prune()
+			sessionHandler.userLimit.prune()
+			sessionHandler.quota.prune()
+			sessionHandler.activeSessions.prune()
+			if sessionHandler.tenants != nil {
+				for _, t := range sessionHandler.tenants.tenants {
+					t.quota.prune()
+					t.activeSessions.prune()
+				}
+			}
+			return nil
+		}},
+		{Name: jobRetention, Schedule: "17 * * * *", Enabled: true, Requires: "CHATKIT_WEBHOOK_URL", Leader: true},
+		{Name: jobUsageExport, Schedule: "0 * * * *", Requires: "CHATKIT_WEBHOOK_URL and CHATKIT_USER_SESSION_QUOTA", Leader: true},
+		{Name: jobUsageCheckpoint, Schedule: "* * * * *", Enabled: true, Requires: "CHATKIT_USAGE_CHECKPOINT_FILE"},
+		{Name: jobKeyValidation, Schedule: "*/15 * * * *", Leader: true, Run: func(ctx context.Context) error {
+			ctx, cancel := context.WithTimeout(ctx, openaiRequestTimeout)
+			defer cancel()
+			_, err := client.Models.List(ctx)
+			return err
+		}},
+	}
+	if dispatcher != nil {
+		jobDefs[1].Run = func(context.Context) error {
+			dropped, err := dispatcher.PruneDeadLetters(deadLetterRetention)
+			if dropped > 0 {
+				slog.Info("dropped expired webhook dead letters", "count", dropped)
+			}
+			return err
+		}
+		if sessionHandler.quota != nil {
+			jobDefs[2].Run = func(context.Context) error {
+				report := map[string]any{"users": sessionHandler.quota.report()}
+				if sessionHandler.tenants != nil {
+					tenants := make(map[string][]quotaUsageReport, len(sessionHandler.tenants.tenants))
+					for _, t := range sessionHandler.tenants.tenants {
+						tenants[t.name] = t.quota.report()
+					}
+					report["tenants"] = tenants
+				}
+				dispatcher.Enqueue("usage.report", report)
+				return nil
+			}
+		}
+	}
+	if usageCheckpoints != nil {
+		jobDefs[3].Run = usageCheckpoints.save
+	}
+	if err := scheduler.Configure(jobDefs, func(key string) string { return config.Get(key, "") }); err != nil {
+		return fmt.Errorf("invalid job configuration: %w", err)
+	}
+
+	goroutineWarn, err := config.Int64("CHATKIT_GOROUTINE_WARN", defaultGoroutineWarn)
+	if err != nil {
+		return err
+	}
+	fdWarnPercent, err := config.Int64("CHATKIT_FD_WARN_PERCENT", defaultFDWarnPercent)
+	if err != nil {
+		return err
+	}
+	runtimeMonitor := newRuntimeMonitor(int(goroutineWarn), int(fdWarnPercent))
+
+	configDrift := newConfigDriftDetector(append(config.FileEnviron(), os.Environ()...), config.Get("CHATKIT_CONFIG_DRIFT_FILE", ""))
+	var events *lifecycleLogger
+	if !config.Bool("CHATKIT_DISABLE_LIFECYCLE_EVENTS") {
+		events = newLifecycleLogger(os.Stdout, addr, configDrift.fingerprint)
+		events.region = region
+		configDrift.events = events
+	}
+
+	// Every endpoint that answers for a named user goes through the lookup
+	// guard, which budgets each caller per route.
+	lookupRateLimit, err := config.Int64("CHATKIT_LOOKUP_RATE_LIMIT_PER_MINUTE", defaultLookupRateLimitPerMinute)
+	if err != nil {
+		return err
+	}
+	lookupMinLatencyMS, err := config.Int64("CHATKIT_LOOKUP_MIN_LATENCY_MS", int64(defaultLookupMinLatency/time.Millisecond))
+	if err != nil {
+		return err
+	}
+	sessionHandler.lookups = newLookupGuard(lookupRateLimit, time.Duration(lookupMinLatencyMS)*time.Millisecond)
+
+	var admin *adminHandler
+	if adminToken := config.Get("ADMIN_TOKEN", ""); adminToken != "" {
+		admin = newAdminHandler(adminToken, bans, configDrift)
+		admin.webhooks = dispatcher
+		admin.runtime = runtimeMonitor
+		admin.platforms = sessionHandler.platforms
+		admin.maintenance = sessionHandler.maintenance
+		admin.suspensions = sessionHandler.suspensions
+		admin.sessions = sessionHandler
+		admin.transactions = newConfigTransactions(sessionHandler)
+		admin.admission = sessionHandler.admission
+		admin.upstreamQuota = upstreamQuota
+		admin.slo = sessionHandler.slo
+		admin.anomalies = sessionHandler.anomalies
+		admin.journal = journal
+		admin.jobs = scheduler
+		admin.projects = sessionHandler.projects
+		admin.lookups = sessionHandler.lookups
+
+		admin.workflows = newWorkflowHealth(workflows, workflowLookups.check)
+	}
+
+	sessionHandler.exposeUpstreamErrors = config.Bool("CHATKIT_EXPOSE_UPSTREAM_ERRORS")
+	if sessionHandler.exposeUpstreamErrors {
+		slog.Warn("CHATKIT_EXPOSE_UPSTREAM_ERRORS is enabled; upstream error details will be returned to clients")
+	}
+
+	var warmupChecks []warmupCheck
+	if config.Bool("CHATKIT_STARTUP_PING") {
+		warmupChecks = append(warmupChecks, warmupCheck{name: "openai", run: func(ctx context.Context) error {
+			_, err := client.Models.List(ctx)
+			return err
+		}})
+	}
+	warmup := newWarmupGate(warmupChecks...)
+
+	readinessTimeouts, err := parseReadinessTimeouts(config.Get("CHATKIT_READY_TIMEOUTS", ""))
+	if err != nil {
+		return fmt.Errorf("invalid CHATKIT_READY_TIMEOUTS: %w", err)
+	}
+	readinessChecks := []readinessCheck{
+		{name: "warmup", run: func(context.Context) error {
+			if !warmup.ready.Load() {
+				return errors.New("warm-up checks have not passed yet")
+			}
+			return nil
+		}},
+	}
+	switch {
+	case credentials != nil:
+		readinessChecks = append(readinessChecks, readinessCheck{name: "openai", run: credentials.check})
+	case readyOpenAICheck == readyOpenAIPing:
+		readinessChecks = append(readinessChecks, readinessCheck{name: "openai", run: func(ctx context.Context) error {
+			_, err := client.Models.List(ctx)
+			return err
+		}})
+	}
+	if redisClient != nil {
+		readinessChecks = append(readinessChecks, readinessCheck{name: "redis", run: redisClient.Ping})
+	}
+	for i := range readinessChecks {
+		readinessChecks[i].timeout = readinessTimeouts[readinessChecks[i].name]
+	}
+	readiness := newReadinessProbe(readinessChecks...)
+	readiness.breaker = sessionHandler.breaker
+	warnDays, err := config.Int64("CHATKIT_CREDENTIAL_EXPIRY_WARN_DAYS", defaultCredentialWarnDays)
+	if err != nil {
+		return err
+	}
+	if warnDays < 0 {
+		return errors.New("CHATKIT_CREDENTIAL_EXPIRY_WARN_DAYS must not be negative")
+	}
+	credentialHealth := newCredentialHealth(time.Duration(warnDays) * 24 * time.Hour)
+	if jwksURL != "" {
+		credentialHealth.watchAge("jwks", sessionHandler.auth.FetchedAt, jwksStaleAfter)
+	}
+	readiness.credentials = credentialHealth
+
+	instanceID := config.Get("CHATKIT_INSTANCE_ID", "")
+	if instanceID == "hostname" {
+		instanceID = defaultInstanceID()
+	} else {
+		instanceID = dnsSafeLabel(instanceID)
+	}
+	banner, err := newResponseBanner(config.Get("CHATKIT_ENVIRONMENT", ""), instanceID, config.Get("CHATKIT_RESPONSE_HEADERS", ""))
+	if err != nil {
+		return fmt.Errorf("invalid CHATKIT_RESPONSE_HEADERS: %w", err)
+	}
+	if config.Bool("CHATKIT_REGION_HEADER") {
+		banner = banner.withRegion(region)
+	}
+
+	corsRequestHeaders := append(attribution.headerNames(), requestIDHeader)
+	if sessionHandler.failures != nil {
+		corsRequestHeaders = append(corsRequestHeaders, debugFailHeader)
+	}
+	if sessionHandler.signer != nil {
+		corsRequestHeaders = append(corsRequestHeaders, requestSignatureHeader)
+	}
+	if sessionHandler.tenants != nil {
+		corsRequestHeaders = append(corsRequestHeaders, tenantKeyHeader)
+	}
+	if sessionHandler.idempotency != nil {
+		corsRequestHeaders = append(corsRequestHeaders, idempotencyKeyHeader, idempotentReplayedHeader)
+	}
+	tracer, err := newTracerFromEnv(func(key string) string { return config.Get(key, "") })
+	if err != nil {
+		return fmt.Errorf("invalid tracing configuration: %w", err)
+	}
+	if tracer != nil {
+		tracer.region = region
+		corsRequestHeaders = append(corsRequestHeaders, traceparentHeader)
+	}
+	corsMaxAge, err := config.Int64("CORS_MAX_AGE_SECONDS", cors.DefaultMaxAge)
+	if err != nil {
+		return err
+	}
+	if corsMaxAge < 0 {
+		return errors.New("CORS_MAX_AGE_SECONDS must be non-negative")
+	}
+	allowedOrigins, err := config.Require("CORS_ALLOWED_ORIGINS")
+	if err != nil {
+		return err
+	}
+	if err := cors.CheckOrigins(allowedOrigins); err != nil {
+		return fmt.Errorf("invalid CORS_ALLOWED_ORIGINS: %w", err)
+	}
+	corsPolicy := newCORSPolicy(allowedOrigins).
+		WithMaxAge(corsMaxAge).
+		WithHeaders(corsRequestHeaders...).
+		WithExposedHeaders(banner.headerNames()...)
+	if secretCookie != nil {
+		corsPolicy = corsPolicy.WithCredentials()
+	}
+	if config.Bool("CORS_FETCH_METADATA_CHECK") {
+		corsPolicy = corsPolicy.WithFetchMetadataCheck()
+	}
+	if admin != nil {
+		admin.cors = &corsPolicy
+	}
+
+	customStages, err := customStageNames(stages)
+	if err != nil {
+		return fmt.Errorf("invalid middleware: %w", err)
+	}
+	custom := make(map[string]middleware, len(stages))
+	for i, mw := range stages {
+		custom[customStages[i]] = mw.Wrap
+	}
+	pipelineOrder, err := parsePipelineOrder(map[string]string{
+		routeGroupSession: config.Get("CHATKIT_PIPELINE_SESSION", ""),
+		routeGroupAdmin:   config.Get("CHATKIT_PIPELINE_ADMIN", ""),
+		routeGroupProbes:  config.Get("CHATKIT_PIPELINE_PROBES", ""),
+	}, customStages...)
+	if err != nil {
+		return fmt.Errorf("invalid middleware pipeline: %w", err)
+	}
+	paths, err := newRoutePaths(config.Get("CHATKIT_PATH_PREFIX", ""), config.Get("CHATKIT_SESSION_PATH", ""))
+	if err != nil {
+		return fmt.Errorf("invalid endpoint paths: %w", err)
+	}
+	if journal != nil {
+		journal.paths = paths
+	}
+	mux, err := newRouter(sessionHandler, admin, warmup, readiness, &routerOptions{
+		paths:            paths,
+		validateRequests: config.Bool("CHATKIT_OPENAPI_VALIDATION"),
+		pipeline: &pipeline{
+			order:   pipelineOrder,
+			cors:    func(next http.Handler) http.Handler { return corsPolicy.Handler(next) },
+			audit:   func(next http.Handler) http.Handler { return withJournal(journal, next) },
+			metrics: withAccessLog,
+			custom:  custom,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	proxies, err := parseTrustedProxies(config.Get("CHATKIT_TRUSTED_PROXIES", ""))
+	if err != nil {
+		return fmt.Errorf("invalid CHATKIT_TRUSTED_PROXIES: %w", err)
+	}
+
+	httpServer := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadTimeout:       readTimeout,
+		ReadHeaderTimeout: readHeaderTimeout,
+		WriteTimeout:      writeTimeout,
+		IdleTimeout:       idleTimeout,
+		ConnState:         runtimeMonitor.trackConn,
+	}
+
+	shutdownTimeouts, err := parseShutdownTimeouts(config.Get("CHATKIT_SHUTDOWN_TIMEOUTS", ""))
+	if err != nil {
+		return fmt.Errorf("invalid CHATKIT_SHUTDOWN_TIMEOUTS: %w", err)
+	}
+
+	if admin != nil {
+		httpServer.RegisterOnShutdown(admin.streams.close)
+	}
+
+	srv := server.New(httpServer)
+	srv.Use(
+		withRequestID,
+		func(next http.Handler) http.Handler { return withPanicRecovery(&runtimeMonitor.panics, next) },
+		withClientCertificate,
+		func(next http.Handler) http.Handler { return withTrustedProxies(proxies, next) },
+		func(next http.Handler) http.Handler { return withTracing(tracer, next) },
+		func(next http.Handler) http.Handler { return withResponseBanner(banner, next) },
+		func(next http.Handler) http.Handler { return withRequestDeadline(writeTimeout, next) },
+	)
+	if mode := config.Get("UNIX_SOCKET_MODE", ""); mode != "" {
+		if !server.IsUnixSocketAddr(addr) {
+			return errors.New("UNIX_SOCKET_MODE requires ADDR to be a Unix socket path")
+		}
+		perm, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil || perm > 0o777 {
+			return fmt.Errorf("invalid UNIX_SOCKET_MODE %q: expected octal permissions such as 0660", mode)
+		}
+		srv.SocketMode = os.FileMode(perm)
+	}
+	if config.Bool("CHATKIT_PROXY_PROTOCOL") {
+		srv.WrapListener = func(ln net.Listener) net.Listener { return server.NewProxyProtoListener(ln, proxies.trustFunc()) }
+	}
+	srv.Timeouts = shutdownTimeouts
+	certFile, keyFile := config.Get("TLS_CERT_FILE", ""), config.Get("TLS_KEY_FILE", "")
+	acmeDomains := config.Get("ACME_DOMAINS", "")
+	switch {
+	case acmeDomains != "" && (certFile != "" || keyFile != ""):
+		return errors.New("ACME_DOMAINS cannot be combined with TLS_CERT_FILE and TLS_KEY_FILE")
+	case certFile != "" || keyFile != "":
+		certs, err := server.NewCertReloader(certFile, keyFile)
+		if err != nil {
+			return fmt.Errorf("invalid TLS certificate: %w", err)
+		}
+		httpServer.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12, GetCertificate: certs.GetCertificate}
+		srv.OnStart(certs.Start)
+		srv.OnShutdown(certs.Stop)
+		credentialHealth.watchExpiry("tls_certificate", certs.ExpiresAt)
+	case acmeDomains != "":
+		acmeManager, err := server.NewACMEManager(acmeDomains, config.Get("ACME_CACHE_DIR", ""), config.Get("ACME_EMAIL", ""), config.Get("ACME_DIRECTORY_URL", ""))
+		if err != nil {
+			return fmt.Errorf("invalid ACME configuration: %w", err)
+		}
+		httpServer.TLSConfig = acmeManager.TLSConfig()
+		httpServer.TLSConfig.MinVersion = tls.VersionTLS12
+		challenges := server.NewACMEHTTPServer(config.Get("ACME_HTTP_ADDR", server.DefaultACMEHTTPAddr), acmeManager)
+		srv.OnStart(challenges.Start)
+		srv.OnShutdownStage(server.StageDrain, challenges.Stop)
+	}
+	if caFile := config.Get("TLS_CLIENT_CA_FILE", ""); caFile != "" {
+		if httpServer.TLSConfig == nil {
+			return errors.New("TLS_CLIENT_CA_FILE requires TLS_CERT_FILE and TLS_KEY_FILE or ACME_DOMAINS")
+		}
+		caExpiresAt, err := server.ConfigureClientAuth(httpServer.TLSConfig, caFile, config.Get("TLS_CLIENT_AUTH", "require"))
+		if err != nil {
+			return fmt.Errorf("invalid client certificate configuration: %w", err)
+		}
+		credentialHealth.watchExpiry("tls_client_ca", func() time.Time { return caExpiresAt })
+	}
+	srv.OnStart(configDrift.start)
+	srv.OnShutdown(configDrift.stop)
+	srv.OnStart(warmup.start)
+	srv.OnShutdown(warmup.stop)
+	srv.OnStart(runtimeMonitor.start)
+	srv.OnShutdown(runtimeMonitor.stop)
+	srv.OnStart(credentialHealth.start)
+	srv.OnShutdown(credentialHealth.stop)
+	srv.OnStart(scheduler.Start)
+	srv.OnShutdown(scheduler.Stop)
+	if tracer != nil {
+		srv.OnStart(tracer.start)
+		srv.OnShutdownStage(server.StageMetrics, tracer.stop)
+	}
+	if sessionHandler.shadow != nil {
+		srv.OnShutdown(sessionHandler.shadow.wait)
+	}
+	if journal != nil {
+		srv.OnShutdownStage(server.StageOutboxes, func(context.Context) error { return journal.close() })
+	}
+	if dispatcher != nil {
+		srv.OnStart(dispatcher.Start)
+		srv.OnShutdownStage(server.StageOutboxes, dispatcher.Stop)
+	}
+	if redisClient != nil {
+		srv.OnShutdownStage(server.StageStores, redisClient.Close)
+	}
+	if usageCheckpoints != nil {
+		srv.OnShutdownStage(server.StageMetrics, usageCheckpoints.save)
+	}
+	slog.Info("effective configuration", "config", rawJSON(config.Dump(configDrift.fingerprint)))
+
+	// Start hooks may tie background work to their context, which must
+	// outlive ctx until the shutdown stages have run. A failed Start has
+	// already run the shutdown stages, stopping whatever had started.
+	if err := srv.Start(context.WithoutCancel(ctx)); err != nil {
+		events.emit(lifecycleEvent{Event: lifecycleEventStartFailed, Error: err.Error()})
+		return fmt.Errorf("startup failed: %w", err)
+	}
+	events.emit(lifecycleEvent{Event: lifecycleEventStarted})
+
+	var serveErr error
+	select {
+	case <-ctx.Done():
+		events.emit(lifecycleEvent{Event: lifecycleEventStopping, Signal: stopReason(ctx)})
+	case serveErr = <-srv.Err():
+		slog.Error("server error", "error", serveErr)
+		events.emit(lifecycleEvent{Event: lifecycleEventStopping, Error: serveErr.Error()})
+	}
+
+	if err := srv.Close(); err != nil {
+		events.emit(lifecycleEvent{Event: lifecycleEventStopped, Error: err.Error()})
+		return errors.Join(serveErr, fmt.Errorf("graceful shutdown failed: %w", err))
+	}
+	events.emit(lifecycleEvent{Event: lifecycleEventStopped})
+	slog.Info("server stopped")
+	return serveErr
+}
+
+// stopReason names what ended ctx for the server.stopping event, such as
+// the signal when ctx comes from signal.NotifyContext.
+func stopReason(ctx context.Context) string {
+	return strings.TrimSuffix(context.Cause(ctx).Error(), " signal received")
+}
+
+// probeWorkflow checks that ref resolves by minting a short-lived session for
+// a synthetic user and cancelling it straight away.
+func probeWorkflow(ctx context.Context, chatKit chatKitAPI, ref workflowRef) error {
+	params := openai.BetaChatKitSessionNewParams{
+		User:     workflowHealthUser,
+		Workflow: openai.ChatSessionWorkflowParam{ID: ref.ID},
+		ExpiresAfter: openai.ChatSessionExpiresAfterParam{
+			Seconds: 60,
+			Anchor:  constant.CreatedAt("").Default(),
+		},
+	}
+	if ref.Version != "" {
+		params.Workflow.Version = openai.String(ref.Version)
+	}
+	session, err := chatKit.CreateSession(ctx, params)
+	if err != nil {
+		return err
+	}
+	if err := chatKit.CancelSession(ctx, session.ID); err != nil {
+		slog.Warn("failed to cancel workflow probe session", "session_id", session.ID, "error", err)
+	}
+	return nil
+}
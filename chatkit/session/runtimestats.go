@@ -0,0 +1,158 @@
+package session
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"runtime"
+	"sync"
+	"time"
+
+	"sync/atomic"
+)
+
+const (
+	runtimeSampleInterval       = 30 * time.Second
+	defaultGoroutineWarn        = 10000
+	defaultFDWarnPercent        = 80
+	runtimeStatsUnavailableOpen = -1
+)
+
+// connStats counts server-side connections by state.
+type connStats struct {
+	Accepted int64 `json:"accepted"`
+	Open     int64 `json:"open"`
+	Active   int64 `json:"active"`
+	Idle     int64 `json:"idle"`
+}
+
+type runtimeStats struct {
+	Goroutines  int       `json:"goroutines"`
+	OpenFDs     int       `json:"open_fds"`
+	FDLimit     uint64    `json:"fd_limit,omitempty"`
+	Connections connStats `json:"connections"`
+	// Panics counts handler panics recovered since startup.
+	Panics int64 `json:"panics"`
+}
+
+// runtimeMonitor tracks goroutines, file descriptors, and server
+// connections, logging a warning whenever a threshold is crossed.
+type runtimeMonitor struct {
+	goroutineWarn int
+	fdWarnPercent int
+
+	// panics counts handler panics withPanicRecovery turned into 500s.
+	panics atomic.Int64
+
+	mu       sync.Mutex
+	conns    map[net.Conn]http.ConnState
+	accepted int64
+	warned   map[string]bool
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func newRuntimeMonitor(goroutineWarn, fdWarnPercent int) *runtimeMonitor {
+	return &runtimeMonitor{
+		goroutineWarn: goroutineWarn,
+		fdWarnPercent: fdWarnPercent,
+		conns:         make(map[net.Conn]http.ConnState),
+		warned:        make(map[string]bool),
+	}
+}
+
+// trackConn is installed as http.Server.ConnState.
+func (m *runtimeMonitor) trackConn(conn net.Conn, state http.ConnState) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch state {
+	case http.StateNew:
+		m.accepted++
+		m.conns[conn] = state
+	case http.StateHijacked, http.StateClosed:
+		delete(m.conns, conn)
+	default:
+		m.conns[conn] = state
+	}
+}
+
+func (m *runtimeMonitor) snapshot() runtimeStats {
+	stats := runtimeStats{Goroutines: runtime.NumGoroutine(), OpenFDs: runtimeStatsUnavailableOpen, Panics: m.panics.Load()}
+	if open, limit, err := openFileDescriptors(); err == nil {
+		stats.OpenFDs = open
+		stats.FDLimit = limit
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats.Connections.Accepted = m.accepted
+	stats.Connections.Open = int64(len(m.conns))
+	for _, state := range m.conns {
+		switch state {
+		case http.StateActive:
+			stats.Connections.Active++
+		case http.StateIdle:
+			stats.Connections.Idle++
+		}
+	}
+	return stats
+}
+
+// sample takes a snapshot and logs threshold crossings. Each warning is
+// logged once until the value drops back below its threshold.
+func (m *runtimeMonitor) sample() runtimeStats {
+	stats := m.snapshot()
+	m.warnIf("goroutines", m.goroutineWarn > 0 && stats.Goroutines >= m.goroutineWarn,
+		"goroutine count reached warning threshold", "goroutines", stats.Goroutines, "threshold", m.goroutineWarn)
+	if stats.FDLimit > 0 && m.fdWarnPercent > 0 {
+		used := uint64(stats.OpenFDs) * 100 / stats.FDLimit
+		m.warnIf("fds", used >= uint64(m.fdWarnPercent),
+			"open file descriptors are near the limit", "open_fds", stats.OpenFDs, "used_percent", used, "limit", stats.FDLimit)
+	}
+	return stats
+}
+
+func (m *runtimeMonitor) warnIf(key string, exceeded bool, msg string, args ...any) {
+	m.mu.Lock()
+	was := m.warned[key]
+	m.warned[key] = exceeded
+	m.mu.Unlock()
+	if exceeded && !was {
+		slog.Warn(msg, args...)
+	}
+}
+
+func (m *runtimeMonitor) start(context.Context) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+	m.done = make(chan struct{})
+	go func() {
+		defer close(m.done)
+		ticker := time.NewTicker(runtimeSampleInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				m.sample()
+			}
+		}
+	}()
+	return nil
+}
+
+func (m *runtimeMonitor) stop(ctx context.Context) error {
+	if m.cancel == nil {
+		return nil
+	}
+	m.cancel()
+	select {
+	case <-m.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,86 @@
+package session
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRuntimeMonitorTracksConnections(t *testing.T) {
+	m := newRuntimeMonitor(0, 0)
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	m.trackConn(a, http.StateNew)
+	m.trackConn(b, http.StateNew)
+	m.trackConn(a, http.StateActive)
+	m.trackConn(b, http.StateActive)
+	m.trackConn(b, http.StateIdle)
+
+	got := m.snapshot().Connections
+	if got != (connStats{Accepted: 2, Open: 2, Active: 1, Idle: 1}) {
+		t.Fatalf("unexpected stats: %+v", got)
+	}
+
+	m.trackConn(a, http.StateClosed)
+	m.trackConn(b, http.StateHijacked)
+	got = m.snapshot().Connections
+	if got != (connStats{Accepted: 2}) {
+		t.Fatalf("unexpected stats after close: %+v", got)
+	}
+}
+
+func TestRuntimeMonitorWarnsOncePerCrossing(t *testing.T) {
+	var logs bytes.Buffer
+	prev := log.Writer()
+	log.SetOutput(&logs)
+	defer log.SetOutput(prev)
+
+	m := newRuntimeMonitor(1, 0)
+	if stats := m.sample(); stats.Goroutines < 1 {
+		t.Fatalf("expected goroutines to be counted, got %d", stats.Goroutines)
+	}
+	m.sample()
+	if n := strings.Count(logs.String(), "goroutine count"); n != 1 {
+		t.Fatalf("expected one warning, got %d:\n%s", n, logs.String())
+	}
+
+	m.goroutineWarn = 1 << 30
+	m.sample()
+	m.goroutineWarn = 1
+	m.sample()
+	if n := strings.Count(logs.String(), "goroutine count"); n != 2 {
+		t.Fatalf("expected a second warning after recovery, got %d", n)
+	}
+}
+
+func TestAdminRuntimeStats(t *testing.T) {
+	bans, _ := newBanList("")
+	admin := newAdminHandler("s3cret", bans, newConfigDriftDetector(nil, ""))
+	admin.runtime = newRuntimeMonitor(0, 0)
+	router, err := newRouter(newSessionHandler(nil, "w", 1200, 10), admin, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/runtime", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	var stats runtimeStats
+	if err := json.Unmarshal(rr.Body.Bytes(), &stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Goroutines == 0 || stats.OpenFDs == 0 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
@@ -0,0 +1,88 @@
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/openai/openai-go/v3"
+)
+
+const (
+	apiKeyHeader = "X-API-Key"
+
+	defaultSandboxExpiresAfterSeconds = 300
+	defaultSandboxRateLimitPerMinute  = 2
+)
+
+// sandboxProfile is the relaxed configuration applied to requests that
+// present a sandbox API key. Sandbox sessions bypass the per-user quota so
+// integration testing never eats into real users' allowances.
+type sandboxProfile struct {
+	keys                [][]byte
+	workflowID          string
+	expiresAfterSeconds int64
+	rateLimitPerMinute  int64
+	createSession       sessionCreator
+}
+
+// newSandboxProfile returns nil when no sandbox keys are configured. An empty
+// workflowID keeps the server's default workflow.
+func newSandboxProfile(keys string, workflowID string, expiresAfterSeconds, rateLimitPerMinute int64, create sessionCreator) *sandboxProfile {
+	p := &sandboxProfile{
+		workflowID:          workflowID,
+		expiresAfterSeconds: expiresAfterSeconds,
+		rateLimitPerMinute:  rateLimitPerMinute,
+		createSession:       create,
+	}
+	for _, key := range strings.Split(keys, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			p.keys = append(p.keys, []byte(key))
+		}
+	}
+	if len(p.keys) == 0 {
+		return nil
+	}
+	return p
+}
+
+// matches reports whether key is one of the sandbox API keys.
+func (p *sandboxProfile) matches(key string) bool {
+	if key == "" {
+		return false
+	}
+	matched := 0
+	for _, k := range p.keys {
+		matched |= subtle.ConstantTimeCompare([]byte(key), k)
+	}
+	return matched == 1
+}
+
+// mockSessionCreator returns a session with a random client secret without
+// calling OpenAI.
+func mockSessionCreator(ctx context.Context, params openai.BetaChatKitSessionNewParams) (*openai.ChatSession, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	return &openai.ChatSession{
+		ID:                    "cksess_sandbox_" + hex.EncodeToString(buf[:8]),
+		ClientSecret:          "sandbox_" + hex.EncodeToString(buf),
+		ExpiresAt:             now.Unix() + params.ExpiresAfter.Seconds,
+		MaxRequestsPer1Minute: params.RateLimits.MaxRequestsPer1Minute.Value,
+		Status:                openai.ChatSessionStatusActive,
+		User:                  params.User,
+	}, nil
+}
+
+// NewMockHandler returns the server's routes with default settings, minting
+// mock sessions for workflowID that never reach OpenAI, so that journaled
+// requests can be replayed offline.
+func NewMockHandler(workflowID string) (http.Handler, error) {
+	return newRouter(newSessionHandler(mockSessionCreator, workflowID, 600, 10), nil, nil, nil, nil)
+}
@@ -0,0 +1,56 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	openai "github.com/openai/openai-go/v3"
+)
+
+func TestHandleSessionSandboxProfile(t *testing.T) {
+	handler := newSessionHandler(func(ctx context.Context, params openai.BetaChatKitSessionNewParams) (*openai.ChatSession, error) {
+		t.Fatal("real upstream should not be called for sandbox keys")
+		return nil, nil
+	}, "w", 1200, 10)
+	handler.quota = newQuotaTracker(1, 80, time.Hour)
+
+	var captured openai.BetaChatKitSessionNewParams
+	handler.sandbox = newSandboxProfile("dev-key-1, dev-key-2", "sandbox-workflow", 60, 1, func(ctx context.Context, params openai.BetaChatKitSessionNewParams) (*openai.ChatSession, error) {
+		captured = params
+		return mockSessionCreator(ctx, params)
+	})
+
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/api/chatkit/session", strings.NewReader(`{"user":"u"}`))
+		req.Header.Set(apiKeyHeader, "dev-key-2")
+		rec := httptest.NewRecorder()
+
+		handler.handleSession(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("request %d: expected status 200, got %d", i, rec.Code)
+		}
+		var resp sessionResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if !strings.HasPrefix(resp.ClientSecret, "sandbox_") {
+			t.Fatalf("expected mock client secret, got %q", resp.ClientSecret)
+		}
+	}
+
+	if captured.Workflow.ID != "sandbox-workflow" || captured.ExpiresAfter.Seconds != 60 || captured.RateLimits.MaxRequestsPer1Minute.Value != 1 {
+		t.Fatalf("expected sandbox parameters, got workflow=%s expires=%d rate=%d", captured.Workflow.ID, captured.ExpiresAfter.Seconds, captured.RateLimits.MaxRequestsPer1Minute.Value)
+	}
+}
+
+func TestNewSandboxProfileDisabledWithoutKeys(t *testing.T) {
+	if p := newSandboxProfile(" , ", "", 60, 1, mockSessionCreator); p != nil {
+		t.Fatalf("expected nil profile without keys")
+	}
+}
@@ -0,0 +1,69 @@
+package session
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	clientSecretDeliveryBody   = "body"
+	clientSecretDeliveryCookie = "cookie"
+
+	defaultClientSecretCookieName = "chatkit_client_secret"
+)
+
+// secretCookie delivers the client secret as a short-lived HttpOnly cookie
+// instead of in the JSON body, so that script injected into the page cannot
+// read it. It only helps when the requests that need the secret go through
+// a same-site endpoint that reads the cookie server side.
+type secretCookie struct {
+	name   string
+	domain string
+	path   string
+}
+
+// newSecretCookie returns nil for body delivery, the default.
+func newSecretCookie(delivery, name, domain, path string) (*secretCookie, error) {
+	switch strings.ToLower(strings.TrimSpace(delivery)) {
+	case "", clientSecretDeliveryBody:
+		return nil, nil
+	case clientSecretDeliveryCookie:
+	default:
+		return nil, fmt.Errorf("unknown client secret delivery %q: expected %s or %s", delivery, clientSecretDeliveryBody, clientSecretDeliveryCookie)
+	}
+	if name == "" {
+		name = defaultClientSecretCookieName
+	}
+	if path == "" {
+		path = "/"
+	}
+	c := &secretCookie{name: name, domain: domain, path: path}
+	if err := (&http.Cookie{Name: c.name, Value: "x", Domain: c.domain, Path: c.path}).Valid(); err != nil {
+		return nil, fmt.Errorf("invalid client secret cookie: %w", err)
+	}
+	return c, nil
+}
+
+// set writes the cookie for secret, expiring with the session. A zero
+// expiresAt falls back to fallbackSeconds from now.
+func (c *secretCookie) set(w http.ResponseWriter, now time.Time, secret string, expiresAt, fallbackSeconds int64) {
+	maxAge := fallbackSeconds
+	if expiresAt > 0 {
+		maxAge = expiresAt - now.Unix()
+	}
+	if maxAge < 1 {
+		maxAge = 1
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     c.name,
+		Value:    secret,
+		Domain:   c.domain,
+		Path:     c.path,
+		MaxAge:   int(maxAge),
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
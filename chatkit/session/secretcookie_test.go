@@ -0,0 +1,82 @@
+package session
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNewSecretCookie(t *testing.T) {
+	if c, err := newSecretCookie("", "", "", ""); err != nil || c != nil {
+		t.Fatalf("expected body delivery by default, got %+v, %v", c, err)
+	}
+	if _, err := newSecretCookie("header", "", "", ""); err == nil {
+		t.Fatalf("expected error for unknown delivery")
+	}
+	if _, err := newSecretCookie("cookie", "bad name", "", ""); err == nil {
+		t.Fatalf("expected error for invalid cookie name")
+	}
+	c, err := newSecretCookie("Cookie", "", "chat.example.com", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.name != defaultClientSecretCookieName || c.path != "/" || c.domain != "chat.example.com" {
+		t.Fatalf("unexpected cookie config: %+v", c)
+	}
+}
+
+func TestHandleSessionDeliversSecretAsCookie(t *testing.T) {
+	fake := &fakeSessionCreator{clientSecret: "secret"}
+	handler := newSessionHandler(fake.Create, "w", 1200, 10)
+	handler.secretCookie, _ = newSecretCookie("cookie", "", "", "/chatkit")
+
+	req := httptest.NewRequest(http.MethodPost, "/api/chatkit/session", strings.NewReader(`{"user":"u"}`))
+	rec := httptest.NewRecorder()
+	handler.handleSession(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	var resp sessionResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.ClientSecret != "" || resp.ClientSecretDelivery != clientSecretDeliveryCookie {
+		t.Fatalf("expected secret to be left out of the body, got %+v", resp)
+	}
+
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("expected one cookie, got %d", len(cookies))
+	}
+	c := cookies[0]
+	if c.Name != defaultClientSecretCookieName || c.Value != "secret" || c.Path != "/chatkit" || c.MaxAge != 1200 {
+		t.Fatalf("unexpected cookie: %+v", c)
+	}
+	if !c.HttpOnly || !c.Secure || c.SameSite != http.SameSiteStrictMode {
+		t.Fatalf("expected a Secure, HttpOnly, SameSite=Strict cookie, got %+v", c)
+	}
+}
+
+func TestCORSCredentialsForListedOrigins(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	for _, tc := range []struct {
+		origins string
+		want    string
+	}{
+		{"https://app.example.com", "true"},
+		{"*", ""},
+	} {
+		handler := newCORSPolicy(tc.origins).WithCredentials().Handler(next)
+		req := httptest.NewRequest(http.MethodPost, "/api/chatkit/session", nil)
+		req.Header.Set("Origin", "https://app.example.com")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if got := rec.Header().Get("Access-Control-Allow-Credentials"); got != tc.want {
+			t.Fatalf("origins %q: expected Allow-Credentials %q, got %q", tc.origins, tc.want, got)
+		}
+	}
+}
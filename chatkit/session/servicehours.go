@@ -0,0 +1,236 @@
+package session
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	// Embed the timezone database: the scratch image ships without one.
+	_ "time/tzdata"
+)
+
+const minutesPerDay = 24 * 60
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// serviceWindow is a daily opening window, in minutes since local midnight,
+// on a set of weekdays.
+type serviceWindow struct {
+	days  [7]bool
+	start int
+	end   int
+}
+
+// serviceHours is a set of weekly opening windows in a fixed location.
+type serviceHours struct {
+	windows []serviceWindow
+	loc     *time.Location
+}
+
+// parseServiceHours parses a comma-separated list of windows such as
+// "Mon-Fri 09:00-17:00, Sat 10:00-14:00". The day part is optional and
+// defaults to every day.
+func parseServiceHours(spec string, loc *time.Location) (*serviceHours, error) {
+	hours := &serviceHours{loc: loc}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		window, err := parseServiceWindow(entry)
+		if err != nil {
+			return nil, err
+		}
+		hours.windows = append(hours.windows, window)
+	}
+	if len(hours.windows) == 0 {
+		return nil, fmt.Errorf("no service windows in %q", spec)
+	}
+	return hours, nil
+}
+
+func parseServiceWindow(entry string) (serviceWindow, error) {
+	var w serviceWindow
+	fields := strings.Fields(entry)
+	var dayPart, timePart string
+	switch len(fields) {
+	case 1:
+		timePart = fields[0]
+		for i := range w.days {
+			w.days[i] = true
+		}
+	case 2:
+		dayPart, timePart = fields[0], fields[1]
+	default:
+		return w, fmt.Errorf("invalid service window %q", entry)
+	}
+
+	if dayPart != "" {
+		from, to, isRange := strings.Cut(dayPart, "-")
+		first, ok := weekdayNames[strings.ToLower(from)]
+		if !ok {
+			return w, fmt.Errorf("invalid weekday %q in %q", from, entry)
+		}
+		last := first
+		if isRange {
+			if last, ok = weekdayNames[strings.ToLower(to)]; !ok {
+				return w, fmt.Errorf("invalid weekday %q in %q", to, entry)
+			}
+		}
+		for d := first; ; d = (d + 1) % 7 {
+			w.days[d] = true
+			if d == last {
+				break
+			}
+		}
+	}
+
+	startText, endText, ok := strings.Cut(timePart, "-")
+	if !ok {
+		return w, fmt.Errorf("invalid time range %q in %q", timePart, entry)
+	}
+	var err error
+	if w.start, err = parseClockMinutes(startText); err != nil {
+		return w, fmt.Errorf("invalid start in %q: %w", entry, err)
+	}
+	if w.end, err = parseClockMinutes(endText); err != nil {
+		return w, fmt.Errorf("invalid end in %q: %w", entry, err)
+	}
+	if w.end <= w.start {
+		return w, fmt.Errorf("window %q must end after it starts", entry)
+	}
+	return w, nil
+}
+
+func parseClockMinutes(s string) (int, error) {
+	hh, mm, ok := strings.Cut(s, ":")
+	if !ok {
+		return 0, fmt.Errorf("expected HH:MM, got %q", s)
+	}
+	h, err := strconv.Atoi(hh)
+	if err != nil {
+		return 0, fmt.Errorf("expected HH:MM, got %q", s)
+	}
+	m, err := strconv.Atoi(mm)
+	if err != nil {
+		return 0, fmt.Errorf("expected HH:MM, got %q", s)
+	}
+	total := h*60 + m
+	if h < 0 || m < 0 || m >= 60 || total > minutesPerDay {
+		return 0, fmt.Errorf("time %q out of range", s)
+	}
+	return total, nil
+}
+
+// open reports whether t falls inside any window.
+func (s *serviceHours) open(t time.Time) bool {
+	t = t.In(s.loc)
+	minute := t.Hour()*60 + t.Minute()
+	for _, w := range s.windows {
+		if w.days[t.Weekday()] && minute >= w.start && minute < w.end {
+			return true
+		}
+	}
+	return false
+}
+
+// nextOpening returns the start of the first window after t.
+func (s *serviceHours) nextOpening(t time.Time) time.Time {
+	t = t.In(s.loc)
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, s.loc)
+	var next time.Time
+	for offset := 0; offset <= 7; offset++ {
+		day := midnight.AddDate(0, 0, offset)
+		for _, w := range s.windows {
+			if !w.days[day.Weekday()] {
+				continue
+			}
+			start := day.Add(time.Duration(w.start) * time.Minute)
+			if start.After(t) && (next.IsZero() || start.Before(next)) {
+				next = start
+			}
+		}
+		if !next.IsZero() {
+			return next
+		}
+	}
+	return next
+}
+
+// serviceHoursPolicy applies global and per-tenant service hours. Tenants
+// without their own entry fall back to the global hours; a nil global means
+// always open.
+type serviceHoursPolicy struct {
+	global  *serviceHours
+	tenants map[string]*serviceHours
+	clock   Clock
+}
+
+// newServiceHoursPolicy builds a policy from the global spec and a
+// semicolon-separated list of tenant=spec entries. It returns nil when both
+// are empty.
+func newServiceHoursPolicy(globalSpec, tenantSpec, timezone string) (*serviceHoursPolicy, error) {
+	if globalSpec == "" && tenantSpec == "" {
+		return nil, nil
+	}
+
+	loc := time.UTC
+	if timezone != "" {
+		var err error
+		if loc, err = time.LoadLocation(timezone); err != nil {
+			return nil, fmt.Errorf("invalid timezone %q: %w", timezone, err)
+		}
+	}
+
+	policy := &serviceHoursPolicy{tenants: make(map[string]*serviceHours), clock: SystemClock}
+	if globalSpec != "" {
+		hours, err := parseServiceHours(globalSpec, loc)
+		if err != nil {
+			return nil, err
+		}
+		policy.global = hours
+	}
+	for _, entry := range strings.Split(tenantSpec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		tenant, spec, ok := strings.Cut(entry, "=")
+		tenant = strings.TrimSpace(tenant)
+		if !ok || tenant == "" {
+			return nil, fmt.Errorf("invalid tenant service hours %q: expected tenant=windows", entry)
+		}
+		hours, err := parseServiceHours(spec, loc)
+		if err != nil {
+			return nil, fmt.Errorf("tenant %s: %w", tenant, err)
+		}
+		policy.tenants[tenant] = hours
+	}
+	return policy, nil
+}
+
+// check reports whether tenant is currently within service hours and, if
+// not, when service next opens.
+func (p *serviceHoursPolicy) check(tenant string) (bool, time.Time) {
+	hours := p.global
+	if h, ok := p.tenants[tenant]; ok && tenant != "" {
+		hours = h
+	}
+	if hours == nil {
+		return true, time.Time{}
+	}
+	now := p.clock.Now()
+	if hours.open(now) {
+		return true, time.Time{}
+	}
+	return false, hours.nextOpening(now)
+}
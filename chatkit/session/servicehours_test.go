@@ -0,0 +1,90 @@
+package session
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServiceHoursOpenAndNextOpening(t *testing.T) {
+	hours, err := parseServiceHours("Mon-Fri 09:00-17:00, Sat 10:00-14:00", time.UTC)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		at       time.Time
+		wantOpen bool
+		wantNext time.Time
+	}{
+		{"weekday open", time.Date(2024, 6, 5, 12, 0, 0, 0, time.UTC), true, time.Time{}},
+		{"weekday after close", time.Date(2024, 6, 5, 17, 0, 0, 0, time.UTC), false, time.Date(2024, 6, 6, 9, 0, 0, 0, time.UTC)},
+		{"friday evening", time.Date(2024, 6, 7, 20, 0, 0, 0, time.UTC), false, time.Date(2024, 6, 8, 10, 0, 0, 0, time.UTC)},
+		{"sunday", time.Date(2024, 6, 9, 12, 0, 0, 0, time.UTC), false, time.Date(2024, 6, 10, 9, 0, 0, 0, time.UTC)},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := hours.open(tc.at); got != tc.wantOpen {
+				t.Fatalf("expected open=%v, got %v", tc.wantOpen, got)
+			}
+			if !tc.wantOpen {
+				if got := hours.nextOpening(tc.at); !got.Equal(tc.wantNext) {
+					t.Fatalf("expected next opening %s, got %s", tc.wantNext, got)
+				}
+			}
+		})
+	}
+}
+
+func TestParseServiceHoursErrors(t *testing.T) {
+	for _, spec := range []string{"", "Funday 09:00-17:00", "Mon 17:00-09:00", "Mon 9-17", "Mon 09:00-25:00"} {
+		if _, err := parseServiceHours(spec, time.UTC); err == nil {
+			t.Fatalf("expected error for %q", spec)
+		}
+	}
+}
+
+func TestServiceHoursPolicyTenantOverride(t *testing.T) {
+	policy, err := newServiceHoursPolicy("Mon-Fri 09:00-17:00", "acme=00:00-24:00", "UTC")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	policy.clock = ClockFunc(func() time.Time { return time.Date(2024, 6, 9, 12, 0, 0, 0, time.UTC) })
+
+	if open, _ := policy.check(""); open {
+		t.Fatalf("expected global hours to be closed on Sunday")
+	}
+	if open, _ := policy.check("acme"); !open {
+		t.Fatalf("expected acme to be open around the clock")
+	}
+}
+
+func TestHandleSessionOutsideServiceHours(t *testing.T) {
+	handler := newSessionHandler(nil, "w", 1200, 10)
+	policy, err := newServiceHoursPolicy("Mon-Fri 09:00-17:00", "", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	policy.clock = ClockFunc(func() time.Time { return time.Date(2024, 6, 9, 12, 0, 0, 0, time.UTC) })
+	handler.serviceHours = policy
+
+	req := httptest.NewRequest(http.MethodPost, "/api/chatkit/session", strings.NewReader(`{"user":"u"}`))
+	rec := httptest.NewRecorder()
+
+	handler.handleSession(rec, req)
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected status 503, got %d", rec.Code)
+	}
+	var resp serviceHoursError
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if resp.Error != "outside_service_hours" || resp.NextOpenAt != "2024-06-10T09:00:00Z" {
+		t.Fatalf("unexpected error response: %+v", resp)
+	}
+}
@@ -0,0 +1,87 @@
+package session
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/openai/openai-go/v3"
+)
+
+const defaultShadowMaxInFlight = 32
+
+// shadowMirror replays a sample of session requests against a secondary
+// upstream, such as a new OpenAI project or a staging workflow, and discards
+// the results. Mirrored calls never affect the primary response.
+type shadowMirror struct {
+	create     sessionCreator
+	percent    float64
+	workflowID string
+	rand       Rand
+	redact     *redactor
+
+	inFlight chan struct{}
+	wg       sync.WaitGroup
+}
+
+// newShadowMirror mirrors percent (0-100) of requests. A non-empty
+// workflowID replaces the workflow in mirrored requests.
+func newShadowMirror(create sessionCreator, percent float64, workflowID string, maxInFlight int) *shadowMirror {
+	return &shadowMirror{
+		create:     create,
+		percent:    percent,
+		workflowID: workflowID,
+		rand:       SystemRand,
+		inFlight:   make(chan struct{}, maxInFlight),
+	}
+}
+
+// mirror sends params to the shadow upstream in the background when the
+// request is sampled. Requests are dropped rather than queued once
+// maxInFlight mirrored calls are outstanding.
+func (m *shadowMirror) mirror(params openai.BetaChatKitSessionNewParams) {
+	if m.rand.Float64()*100 >= m.percent {
+		return
+	}
+	select {
+	case m.inFlight <- struct{}{}:
+	default:
+		slog.Debug("shadow request dropped", "in_flight", cap(m.inFlight))
+		return
+	}
+
+	if m.workflowID != "" {
+		params.Workflow.ID = m.workflowID
+	}
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		defer func() { <-m.inFlight }()
+
+		ctx, cancel := context.WithTimeout(context.Background(), openaiRequestTimeout)
+		defer cancel()
+		start := time.Now()
+		_, err := m.create(ctx, params)
+		if err != nil {
+			slog.Warn("shadow request failed", "user", m.redact.value("user", params.User), "workflow_id", params.Workflow.ID, "elapsed_ms", time.Since(start).Milliseconds(), "error", err)
+			return
+		}
+		slog.Debug("shadow request succeeded", "user", m.redact.value("user", params.User), "workflow_id", params.Workflow.ID, "elapsed_ms", time.Since(start).Milliseconds())
+	}()
+}
+
+// wait blocks until in-flight mirrored requests finish or ctx is done.
+func (m *shadowMirror) wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,55 @@
+package session
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	openai "github.com/openai/openai-go/v3"
+)
+
+func TestHandleSessionMirrorsSampledRequests(t *testing.T) {
+	var mu sync.Mutex
+	var mirrored []openai.BetaChatKitSessionNewParams
+	shadow := newShadowMirror(func(ctx context.Context, params openai.BetaChatKitSessionNewParams) (*openai.ChatSession, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		mirrored = append(mirrored, params)
+		return &openai.ChatSession{}, nil
+	}, 50, "staging-workflow", 4)
+	samples := []float64{0.1, 0.9}
+	shadow.rand = RandFunc(func() float64 {
+		v := samples[0]
+		samples = samples[1:]
+		return v
+	})
+
+	fake := &fakeSessionCreator{clientSecret: "secret"}
+	handler := newSessionHandler(fake.Create, "w", 1200, 10)
+	handler.shadow = shadow
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/api/chatkit/session", strings.NewReader(`{"user":"u"}`))
+		rec := httptest.NewRecorder()
+		handler.handleSession(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", rec.Code)
+		}
+	}
+	if err := shadow.wait(context.Background()); err != nil {
+		t.Fatalf("unexpected wait error: %v", err)
+	}
+
+	if len(mirrored) != 1 {
+		t.Fatalf("expected exactly one mirrored request, got %d", len(mirrored))
+	}
+	if mirrored[0].Workflow.ID != "staging-workflow" || mirrored[0].User != "u" {
+		t.Fatalf("unexpected mirrored params: workflow=%s user=%s", mirrored[0].Workflow.ID, mirrored[0].User)
+	}
+	if fake.params.Workflow.ID != "w" {
+		t.Fatalf("primary request should keep its workflow, got %s", fake.params.Workflow.ID)
+	}
+}
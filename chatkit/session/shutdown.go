@@ -0,0 +1,40 @@
+package session
+
+import (
+	"fmt"
+	"time"
+
+	"openai-chatkit-backend/chatkit/server"
+)
+
+// defaultShutdownTimeouts extends server.DefaultTimeouts so that an OpenAI
+// call that started just before shutdown can finish draining.
+var defaultShutdownTimeouts = func() map[server.Stage]time.Duration {
+	timeouts := make(map[server.Stage]time.Duration, len(server.DefaultTimeouts))
+	for stage, d := range server.DefaultTimeouts {
+		timeouts[stage] = d
+	}
+	timeouts[server.StageDrain] = serverShutdownTimeout
+	return timeouts
+}()
+
+// parseShutdownTimeouts parses comma-separated stage=duration entries, e.g.
+// "drain=20s,outboxes=10s", on top of defaultShutdownTimeouts.
+func parseShutdownTimeouts(spec string) (map[server.Stage]time.Duration, error) {
+	named, err := parseNamedDurations(spec, "shutdown timeout")
+	if err != nil {
+		return nil, err
+	}
+	timeouts := make(map[server.Stage]time.Duration, len(defaultShutdownTimeouts))
+	for stage, d := range defaultShutdownTimeouts {
+		timeouts[stage] = d
+	}
+	for name, d := range named {
+		stage, ok := server.ParseStage(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown shutdown stage %q", name)
+		}
+		timeouts[stage] = d
+	}
+	return timeouts, nil
+}
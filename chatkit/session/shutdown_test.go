@@ -0,0 +1,23 @@
+package session
+
+import (
+	"testing"
+	"time"
+
+	"openai-chatkit-backend/chatkit/server"
+)
+
+func TestParseShutdownTimeouts(t *testing.T) {
+	timeouts, err := parseShutdownTimeouts("drain=20s, outboxes=750ms")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if timeouts[server.StageDrain] != 20*time.Second || timeouts[server.StageOutboxes] != 750*time.Millisecond || timeouts[server.StageStores] != defaultShutdownTimeouts[server.StageStores] {
+		t.Fatalf("unexpected timeouts: %v", timeouts)
+	}
+	for _, spec := range []string{"flush=1s", "drain", "drain=-1s"} {
+		if _, err := parseShutdownTimeouts(spec); err == nil {
+			t.Fatalf("expected error for %q", spec)
+		}
+	}
+}
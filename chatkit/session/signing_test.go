@@ -0,0 +1,58 @@
+package session
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"openai-chatkit-backend/chatkit/webhooks"
+)
+
+func TestSessionRequiresValidRequestSignature(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	fake := &fakeSessionCreator{clientSecret: "secret"}
+	handler := newSessionHandler(fake.Create, "w", 1200, 10)
+	handler.signer = newRequestSigner("sigsecret", 5*time.Minute)
+	handler.signer.clock = ClockFunc(func() time.Time { return now })
+	router, err := newRouter(handler, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected router error: %v", err)
+	}
+
+	body := `{"user":"u"}`
+	send := func(signature, payload string) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/chatkit/session", strings.NewReader(payload))
+		if signature != "" {
+			req.Header.Set(requestSignatureHeader, signature)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	valid := webhooks.Sign([]byte("sigsecret"), now.Add(-time.Minute), []byte(body))
+	if code := send(valid, body); code != http.StatusOK {
+		t.Fatalf("expected a valid signature to be accepted, got %d", code)
+	}
+	if fake.params.User != "u" {
+		t.Fatalf("expected the handler to see the signed body, got user %q", fake.params.User)
+	}
+	prefix, digest, _ := strings.Cut(valid, "v1=")
+	if code := send(prefix+"v1="+strings.ToUpper(digest), body); code != http.StatusUnauthorized {
+		t.Fatalf("expected a re-encoded replay to be rejected, got %d", code)
+	}
+
+	for name, tc := range map[string]struct{ signature, body string }{
+		"missing":      {"", body},
+		"replayed":     {valid, body},
+		"wrong secret": {webhooks.Sign([]byte("other"), now, []byte(body)), body},
+		"altered body": {webhooks.Sign([]byte("sigsecret"), now, []byte(body)), `{"user":"admin"}`},
+		"expired":      {webhooks.Sign([]byte("sigsecret"), now.Add(-6*time.Minute), []byte(body)), body},
+	} {
+		if code := send(tc.signature, tc.body); code != http.StatusUnauthorized {
+			t.Fatalf("%s: expected 401, got %d", name, code)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package session
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSLOLatency   = 2 * time.Second
+	defaultSLOTarget    = 0.99
+	defaultSLOBurnAlert = 14.4
+	sloBucketWidth      = time.Minute
+	sloBuckets          = 60
+	sloShortWindow      = 5 * time.Minute
+	sloLongWindow       = time.Hour
+	sloMinAlertSamples  = 20
+)
+
+// sloWindow is SLO compliance over one trailing window.
+type sloWindow struct {
+	Window     string  `json:"window"`
+	Total      int64   `json:"total"`
+	Good       int64   `json:"good"`
+	Compliance float64 `json:"compliance"`
+	// BurnRate is the error rate divided by the error budget: 1 spends the
+	// budget exactly over the SLO period, 14.4 spends a 30-day budget in
+	// about two days.
+	BurnRate float64 `json:"burn_rate"`
+}
+
+type sloStatus struct {
+	Target     float64     `json:"target"`
+	LatencyMS  int64       `json:"latency_ms"`
+	AlertBurn  float64     `json:"alert_burn_rate"`
+	Alerting   bool        `json:"alerting"`
+	Windows    []sloWindow `json:"windows"`
+	TotalSince sloWindow   `json:"since_start"`
+}
+
+type sloBucket struct {
+	minute int64
+	total  int64
+	good   int64
+}
+
+// latencySLO tracks how many session mints finish successfully within
+// latency, in one-minute buckets covering the last hour. It alerts when both
+// the 5-minute and 1-hour burn rates reach alertBurn, the multi-window rule
+// that ignores short blips but catches sustained budget burn quickly.
+type latencySLO struct {
+	target    float64
+	latency   time.Duration
+	alertBurn float64
+	onAlert   func(status sloStatus)
+	clock     Clock
+
+	mu       sync.Mutex
+	buckets  [sloBuckets]sloBucket
+	total    int64
+	good     int64
+	alerting bool
+}
+
+func newLatencySLO(target float64, latency time.Duration, alertBurn float64) *latencySLO {
+	return &latencySLO{target: target, latency: latency, alertBurn: alertBurn, clock: SystemClock}
+}
+
+// record counts one session mint. Failed mints count against the SLO
+// whatever their latency.
+func (s *latencySLO) record(elapsed time.Duration, failed bool) {
+	good := !failed && elapsed <= s.latency
+	minute := s.clock.Now().Unix() / int64(sloBucketWidth/time.Second)
+
+	s.mu.Lock()
+	b := &s.buckets[minute%sloBuckets]
+	if b.minute != minute {
+		*b = sloBucket{minute: minute}
+	}
+	b.total++
+	s.total++
+	if good {
+		b.good++
+		s.good++
+	}
+	status := s.statusLocked(minute)
+	fire := false
+	switch {
+	case !s.alerting && s.shouldAlert(status):
+		s.alerting, fire = true, true
+	case s.alerting && !s.shouldAlert(status):
+		s.alerting = false
+		slog.Info("session mint SLO burn rate recovered", "burn_rate_5m", status.Windows[0].BurnRate, "burn_rate_1h", status.Windows[1].BurnRate)
+	}
+	status.Alerting = s.alerting
+	s.mu.Unlock()
+
+	if fire {
+		slog.Warn("session mint SLO burning error budget", "burn_rate_5m", status.Windows[0].BurnRate, "burn_rate_1h", status.Windows[1].BurnRate, "target", s.target, "latency_ms", s.latency.Milliseconds())
+		if s.onAlert != nil {
+			s.onAlert(status)
+		}
+	}
+}
+
+func (s *latencySLO) shouldAlert(status sloStatus) bool {
+	if s.alertBurn <= 0 {
+		return false
+	}
+	short, long := status.Windows[0], status.Windows[1]
+	return short.Total >= sloMinAlertSamples && short.BurnRate >= s.alertBurn && long.BurnRate >= s.alertBurn
+}
+
+func (s *latencySLO) snapshot() sloStatus {
+	minute := s.clock.Now().Unix() / int64(sloBucketWidth/time.Second)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := s.statusLocked(minute)
+	status.Alerting = s.alerting
+	return status
+}
+
+func (s *latencySLO) statusLocked(minute int64) sloStatus {
+	return sloStatus{
+		Target:    s.target,
+		LatencyMS: s.latency.Milliseconds(),
+		AlertBurn: s.alertBurn,
+		Windows: []sloWindow{
+			s.windowLocked("5m", minute, sloShortWindow),
+			s.windowLocked("1h", minute, sloLongWindow),
+		},
+		TotalSince: s.summarize("since_start", s.total, s.good),
+	}
+}
+
+func (s *latencySLO) windowLocked(name string, minute int64, width time.Duration) sloWindow {
+	minutes := int64(width / sloBucketWidth)
+	var total, good int64
+	for _, b := range s.buckets {
+		if b.minute > minute-minutes && b.minute <= minute {
+			total += b.total
+			good += b.good
+		}
+	}
+	return s.summarize(name, total, good)
+}
+
+func (s *latencySLO) summarize(name string, total, good int64) sloWindow {
+	w := sloWindow{Window: name, Total: total, Good: good, Compliance: 1}
+	if total > 0 {
+		w.Compliance = float64(good) / float64(total)
+		if budget := 1 - s.target; budget > 0 {
+			w.BurnRate = (1 - w.Compliance) / budget
+		}
+	}
+	return w
+}
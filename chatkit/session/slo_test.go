@@ -0,0 +1,96 @@
+package session
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openai/openai-go/v3"
+)
+
+func TestLatencySLOWindowsAndBurnRate(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	s := newLatencySLO(0.99, 2*time.Second, 0)
+	s.clock = ClockFunc(func() time.Time { return now })
+
+	for i := 0; i < 98; i++ {
+		s.record(100*time.Millisecond, false)
+	}
+	s.record(3*time.Second, false)
+	s.record(10*time.Millisecond, true)
+
+	now = now.Add(10 * time.Minute)
+	for i := 0; i < 10; i++ {
+		s.record(100*time.Millisecond, false)
+	}
+
+	status := s.snapshot()
+	short, long := status.Windows[0], status.Windows[1]
+	if short.Total != 10 || short.Good != 10 || short.BurnRate != 0 {
+		t.Fatalf("unexpected 5m window: %+v", short)
+	}
+	if long.Total != 110 || long.Good != 108 {
+		t.Fatalf("unexpected 1h window: %+v", long)
+	}
+	if want := (2.0 / 110) / 0.01; math.Abs(long.BurnRate-want) > 1e-9 {
+		t.Fatalf("expected 1h burn rate %v, got %v", want, long.BurnRate)
+	}
+
+	now = now.Add(2 * time.Hour)
+	if status := s.snapshot(); status.Windows[1].Total != 0 || status.TotalSince.Total != 110 {
+		t.Fatalf("expected hour window to expire but lifetime totals to remain, got %+v", status)
+	}
+}
+
+func TestLatencySLOAlertsOncePerBurn(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	s := newLatencySLO(0.99, 2*time.Second, 10)
+	s.clock = ClockFunc(func() time.Time { return now })
+	var alerts []sloStatus
+	s.onAlert = func(status sloStatus) { alerts = append(alerts, status) }
+
+	for i := 0; i < sloMinAlertSamples-1; i++ {
+		s.record(5*time.Second, false)
+	}
+	if len(alerts) != 0 {
+		t.Fatalf("expected no alert below the minimum sample count")
+	}
+	for i := 0; i < 5; i++ {
+		s.record(5*time.Second, false)
+	}
+	if len(alerts) != 1 || !alerts[0].Alerting {
+		t.Fatalf("expected exactly one alert, got %d", len(alerts))
+	}
+
+	now = now.Add(6 * time.Minute)
+	for i := 0; i < 2000; i++ {
+		s.record(time.Millisecond, false)
+	}
+	if s.snapshot().Alerting {
+		t.Fatalf("expected alert to clear once the burn rate dropped")
+	}
+}
+
+func TestHandleSessionRecordsSLO(t *testing.T) {
+	fake := &fakeSessionCreator{clientSecret: "secret"}
+	handler := newSessionHandler(fake.Create, "w", 1200, 10)
+	handler.slo = newLatencySLO(0.99, time.Second, 0)
+
+	post := func() {
+		req := httptest.NewRequest(http.MethodPost, "/api/chatkit/session", strings.NewReader(`{"user":"u"}`))
+		handler.handleSession(httptest.NewRecorder(), req)
+	}
+	post()
+	handler.createSession = func(ctx context.Context, params openai.BetaChatKitSessionNewParams) (*openai.ChatSession, error) {
+		return nil, upstreamStatusError(http.StatusBadGateway)
+	}
+	post()
+
+	if status := handler.slo.snapshot(); status.TotalSince.Total != 2 || status.TotalSince.Good != 1 {
+		t.Fatalf("expected one good and one bad mint, got %+v", status.TotalSince)
+	}
+}
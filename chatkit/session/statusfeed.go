@@ -0,0 +1,116 @@
+package session
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"openai-chatkit-backend/chatkit/webhooks"
+)
+
+const (
+	defaultStatusStreamInterval = 5 * time.Second
+	minStatusStreamInterval     = time.Second
+	maxStatusStreamInterval     = time.Minute
+)
+
+// statusSnapshot is one event of the admin status stream. Sections for
+// components that are not configured are omitted.
+type statusSnapshot struct {
+	Time         time.Time            `json:"time"`
+	SessionMints []sloWindow          `json:"session_mints,omitempty"`
+	Breaker      *breakerStatus       `json:"circuit_breaker,omitempty"`
+	Admission    *admissionStats      `json:"admission,omitempty"`
+	Webhooks     *webhooks.SinkStatus `json:"webhooks,omitempty"`
+	Maintenance  bool                 `json:"maintenance"`
+}
+
+// statusStreams ends open status streams when the server shuts down, so
+// that they do not hold up draining.
+type statusStreams struct {
+	once sync.Once
+	done chan struct{}
+}
+
+func newStatusStreams() *statusStreams {
+	return &statusStreams{done: make(chan struct{})}
+}
+
+// close ends every open stream. It is registered with
+// http.Server.RegisterOnShutdown.
+func (s *statusStreams) close() {
+	s.once.Do(func() { close(s.done) })
+}
+
+func (a *adminHandler) statusSnapshot(now time.Time) statusSnapshot {
+	snap := statusSnapshot{Time: now.UTC()}
+	if a.slo != nil {
+		snap.SessionMints = a.slo.snapshot().Windows
+	}
+	if a.sessions != nil && a.sessions.breaker != nil {
+		b := a.sessions.breaker.snapshot()
+		snap.Breaker = &b
+	}
+	if a.admission != nil {
+		q := a.admission.snapshot()
+		snap.Admission = &q
+	}
+	if a.webhooks != nil {
+		s := a.webhooks.Status()
+		snap.Webhooks = &s
+	}
+	if a.maintenance != nil {
+		snap.Maintenance = a.maintenance.snapshot().Enabled
+	}
+	return snap
+}
+
+// streamStatus sends a status snapshot as a server-sent event right away
+// and then every interval seconds (default 5), until the client goes away
+// or the server shuts down.
+func (a *adminHandler) streamStatus(w http.ResponseWriter, r *http.Request) {
+	interval := defaultStatusStreamInterval
+	if v := r.URL.Query().Get("interval"); v != "" {
+		seconds, err := strconv.Atoi(v)
+		if err != nil || seconds < int(minStatusStreamInterval/time.Second) || seconds > int(maxStatusStreamInterval/time.Second) {
+			http.Error(w, "interval must be between 1 and 60 seconds", http.StatusBadRequest)
+			return
+		}
+		interval = time.Duration(seconds) * time.Second
+	}
+
+	// The stream outlives the server's write timeout.
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", interval.Milliseconds())
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		data, err := json.Marshal(a.statusSnapshot(a.now()))
+		if err != nil {
+			return
+		}
+		if _, err := fmt.Fprintf(w, "event: status\ndata: %s\n\n", data); err != nil {
+			return
+		}
+		// A failed flush means the client is gone. The request context
+		// cannot tell us that here because the request deadline cancels it.
+		if err := rc.Flush(); err != nil {
+			return
+		}
+		select {
+		case <-ticker.C:
+		case <-a.streams.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	suspensionKindTenant   = "tenant"
+	suspensionKindWorkflow = "workflow"
+
+	defaultSuspensionMessage = "Chat is currently unavailable for your organization. Please contact your administrator."
+)
+
+// suspension turns session creation off for one tenant or workflow.
+type suspension struct {
+	Kind    string     `json:"kind"`
+	Value   string     `json:"value"`
+	Message string     `json:"message,omitempty"`
+	Until   *time.Time `json:"until,omitempty"`
+	Since   time.Time  `json:"since"`
+}
+
+// suspensionList disables session creation for single tenants or workflows,
+// so that one customer's incident does not need global maintenance mode.
+// Like the ban list, it is optionally persisted to a JSON file.
+type suspensionList struct {
+	path string
+	// workflows resolves workflow aliases, so a suspension can name either
+	// an alias or a workflow ID.
+	workflows map[string]workflowRef
+
+	mu      sync.RWMutex
+	entries map[[2]string]suspension
+}
+
+// newSuspensionList loads suspensions from path when it exists. An empty
+// path keeps them in memory only.
+func newSuspensionList(path string, workflows map[string]workflowRef) (*suspensionList, error) {
+	l := &suspensionList{path: path, workflows: workflows, entries: make(map[[2]string]suspension)}
+	if path == "" {
+		return l, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var saved []suspension
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	for _, s := range saved {
+		l.entries[[2]string{s.Kind, s.Value}] = s
+	}
+	return l, nil
+}
+
+func validSuspensionKind(kind string) bool {
+	return kind == suspensionKindTenant || kind == suspensionKindWorkflow
+}
+
+func (l *suspensionList) set(s suspension) error {
+	return l.update(func() { l.entries[[2]string{s.Kind, s.Value}] = s })
+}
+
+func (l *suspensionList) remove(kind, value string) error {
+	return l.update(func() { delete(l.entries, [2]string{kind, value}) })
+}
+
+func (l *suspensionList) update(change func()) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	change()
+	if l.path == "" {
+		return nil
+	}
+	return writeJSONFile(l.path, l.snapshotLocked())
+}
+
+func (l *suspensionList) snapshot() []suspension {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.snapshotLocked()
+}
+
+func (l *suspensionList) snapshotLocked() []suspension {
+	out := make([]suspension, 0, len(l.entries))
+	for _, s := range l.entries {
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Kind != out[j].Kind {
+			return out[i].Kind < out[j].Kind
+		}
+		return out[i].Value < out[j].Value
+	})
+	return out
+}
+
+// check returns the suspension in force at now for a session of tenant on
+// workflowID, or nil. Tenant suspensions take precedence. Suspensions whose
+// until has passed are ignored.
+func (l *suspensionList) check(tenant, workflowID string, now time.Time) *suspension {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	active := func(s suspension, ok bool) bool {
+		return ok && (s.Until == nil || s.Until.After(now))
+	}
+	if tenant != "" {
+		if s, ok := l.entries[[2]string{suspensionKindTenant, tenant}]; active(s, ok) {
+			return &s
+		}
+	}
+	for key, s := range l.entries {
+		if key[0] != suspensionKindWorkflow || !active(s, true) {
+			continue
+		}
+		if key[1] == workflowID || (l.workflows[key[1]].ID != "" && l.workflows[key[1]].ID == workflowID) {
+			return &s
+		}
+	}
+	return nil
+}
+
+// message returns the text to show users of a suspended tenant or
+// workflow.
+func (s *suspension) message() string {
+	if s.Message == "" {
+		return defaultSuspensionMessage
+	}
+	return s.Message
+}
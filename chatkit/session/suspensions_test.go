@@ -0,0 +1,107 @@
+package session
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"openai-chatkit-backend/chatkit/auth"
+)
+
+func TestSuspensionListCheck(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "suspensions.json")
+	l, err := newSuspensionList(path, map[string]workflowRef{"support": {ID: "wf_support"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	past := now.Add(-time.Minute)
+	for _, s := range []suspension{
+		{Kind: suspensionKindTenant, Value: "acme", Message: "Billing hold"},
+		{Kind: suspensionKindWorkflow, Value: "support"},
+		{Kind: suspensionKindTenant, Value: "globex", Until: &past},
+	} {
+		if err := l.set(s); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if s := l.check("acme", "wf_other", now); s == nil || s.message() != "Billing hold" {
+		t.Fatalf("expected acme to be suspended, got %+v", s)
+	}
+	if s := l.check("", "wf_support", now); s == nil || s.Value != "support" || s.message() != defaultSuspensionMessage {
+		t.Fatalf("expected the support alias to be suspended, got %+v", s)
+	}
+	if s := l.check("globex", "wf_other", now); s != nil {
+		t.Fatalf("expected an expired suspension to be ignored, got %+v", s)
+	}
+
+	reloaded, err := newSuspensionList(path, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := reloaded.snapshot(); len(got) != 3 || got[0].Value != "acme" || got[2].Kind != suspensionKindWorkflow {
+		t.Fatalf("unexpected persisted suspensions: %+v", got)
+	}
+	if err := reloaded.remove(suspensionKindTenant, "acme"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s := reloaded.check("acme", "wf_other", now); s != nil {
+		t.Fatalf("expected the suspension to be lifted, got %+v", s)
+	}
+}
+
+func TestAdminSuspendsTenant(t *testing.T) {
+	bans, _ := newBanList("")
+	sessions := newSessionHandler(mockSessionCreator, "w", 1200, 10)
+	sessions.suspensions, _ = newSuspensionList("", nil)
+	admin := newAdminHandler("s3cret", bans, newConfigDriftDetector(nil, ""))
+	admin.sessions = sessions
+	admin.suspensions = sessions.suspensions
+	router, err := newRouter(sessions, admin, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected router error: %v", err)
+	}
+
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer s3cret")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	session := func(tenant string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/chatkit/session", strings.NewReader(`{"user":"u"}`))
+		req = req.WithContext(auth.ContextWithClaims(req.Context(), auth.Claims{"sub": "u", "tenant": tenant}))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do(http.MethodPost, "/admin/suspensions", `{"kind":"account","value":"acme"}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an invalid kind, got %d", rec.Code)
+	}
+	if rec := do(http.MethodPost, "/admin/suspensions", `{"kind":"tenant","value":"acme","message":"Billing hold"}`); rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+
+	rec := session("acme")
+	var resp degradedResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != http.StatusServiceUnavailable || resp.Reason != degradedReasonSuspended || resp.Message != "Billing hold" {
+		t.Fatalf("expected a suspended response, got %d %s", rec.Code, rec.Body)
+	}
+	if rec := session("globex"); rec.Code != http.StatusOK {
+		t.Fatalf("expected other tenants to be served, got %d", rec.Code)
+	}
+
+	if rec := do(http.MethodDelete, "/admin/suspensions?kind=tenant&value=acme", ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", rec.Code)
+	}
+	if rec := session("acme"); rec.Code != http.StatusOK {
+		t.Fatalf("expected the tenant to be served again, got %d", rec.Code)
+	}
+}
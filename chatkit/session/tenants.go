@@ -0,0 +1,159 @@
+package session
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+const tenantKeyHeader = "X-Tenant-Key"
+
+// tenantConfig is one tenant in the tenant registry. Zero values keep the
+// server's defaults.
+type tenantConfig struct {
+	Key                 string `json:"key"`
+	WorkflowID          string `json:"workflow_id"`
+	OpenAIAPIKey        string `json:"openai_api_key"`
+	ExpiresAfterSeconds int64  `json:"expires_after_seconds"`
+	RateLimitPerMinute  int64  `json:"rate_limit_per_minute"`
+}
+
+// tenant is a customer served by a shared deployment, with its own
+// workflow, OpenAI key, and session limits.
+type tenant struct {
+	name                string
+	key                 []byte
+	workflowID          string
+	expiresAfterSeconds int64
+	rateLimitPerMinute  int64
+	createSession       sessionCreator
+	// ownKey is set when the tenant has its own OpenAI key, which takes
+	// precedence over OpenAI project routing.
+	ownKey         bool
+	quota          *quotaTracker
+	activeSessions *activeSessionCap
+}
+
+// tenantRegistry maps tenant keys to tenants. When it is configured, every
+// session request must present a tenant key in X-Tenant-Key.
+type tenantRegistry struct {
+	tenants []*tenant
+}
+
+// loadTenantRegistry reads tenants keyed by name from the JSON file at path,
+// or from inline JSON when path is empty. It returns nil when neither is
+// set. newCreator returns the session creator for a tenant's OpenAI key; it
+// is called with "" for tenants that use the server's key.
+func loadTenantRegistry(path, inline string, newCreator func(apiKey string) (sessionCreator, error)) (*tenantRegistry, error) {
+	source, data := path, []byte(inline)
+	switch {
+	case path != "" && inline != "":
+		return nil, fmt.Errorf("set either a tenants file or inline tenants, not both")
+	case path != "":
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, err
+		}
+	case inline != "":
+		source = "inline tenants"
+	default:
+		return nil, nil
+	}
+
+	var configs map[string]tenantConfig
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", source, err)
+	}
+	if len(configs) == 0 {
+		return nil, fmt.Errorf("%s: no tenants", source)
+	}
+	names := make([]string, 0, len(configs))
+	for name := range configs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	reg := &tenantRegistry{}
+	owners := make(map[string]string, len(configs))
+	for _, name := range names {
+		c := configs[name]
+		key := strings.TrimSpace(c.Key)
+		switch {
+		case key == "":
+			return nil, fmt.Errorf("%s: tenant %q has no key", source, name)
+		case c.WorkflowID == "":
+			return nil, fmt.Errorf("%s: tenant %q has no workflow_id", source, name)
+		case c.ExpiresAfterSeconds < 0 || c.RateLimitPerMinute < 0:
+			return nil, fmt.Errorf("%s: tenant %q has a negative limit", source, name)
+		}
+		if owner, dup := owners[key]; dup {
+			return nil, fmt.Errorf("%s: tenants %q and %q share a key", source, owner, name)
+		}
+		owners[key] = name
+		create, err := newCreator(c.OpenAIAPIKey)
+		if err != nil {
+			return nil, fmt.Errorf("%s: tenant %q: %w", source, name, err)
+		}
+		reg.tenants = append(reg.tenants, &tenant{
+			name:                name,
+			key:                 []byte(key),
+			workflowID:          c.WorkflowID,
+			expiresAfterSeconds: c.ExpiresAfterSeconds,
+			rateLimitPerMinute:  c.RateLimitPerMinute,
+			createSession:       create,
+			ownKey:              c.OpenAIAPIKey != "",
+		})
+	}
+	return reg, nil
+}
+
+// lookup returns the tenant whose key is key, or nil. Every key is compared
+// in constant time.
+func (reg *tenantRegistry) lookup(key string) *tenant {
+	if key == "" {
+		return nil
+	}
+	var found *tenant
+	for _, t := range reg.tenants {
+		if subtle.ConstantTimeCompare([]byte(key), t.key) == 1 {
+			found = t
+		}
+	}
+	return found
+}
+
+// require rejects session requests without a valid tenant key and stores
+// the tenant in the request context.
+func (reg *tenantRegistry) require(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t := reg.lookup(r.Header.Get(tenantKeyHeader))
+		if t == nil {
+			http.Error(w, "missing or invalid tenant key", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tenantContextKey{}, t)))
+	})
+}
+
+type tenantContextKey struct{}
+
+// tenantFromContext returns the tenant resolved by tenantRegistry.require,
+// or nil.
+func tenantFromContext(ctx context.Context) *tenant {
+	t, _ := ctx.Value(tenantContextKey{}).(*tenant)
+	return t
+}
+
+// requestTenant names the tenant a request belongs to: the X-Tenant-Key
+// tenant, or else the tenant of the caller's identity.
+func requestTenant(ctx context.Context) string {
+	if t := tenantFromContext(ctx); t != nil {
+		return t.name
+	}
+	return TenantFromContext(ctx)
+}
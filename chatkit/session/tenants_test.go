@@ -0,0 +1,98 @@
+package session
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadTenantRegistry(t *testing.T) {
+	defaults := &fakeSessionCreator{}
+	acme := &fakeSessionCreator{}
+	newCreator := func(apiKey string) (sessionCreator, error) {
+		if apiKey == "sk-acme" {
+			return acme.Create, nil
+		}
+		return defaults.Create, nil
+	}
+
+	reg, err := loadTenantRegistry("", `{"acme": {"key": "tk_acme", "workflow_id": "wf_acme", "openai_api_key": "sk-acme"}, "globex": {"key": "tk_globex", "workflow_id": "wf_globex"}}`, newCreator)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := reg.lookup("tk_globex"); got == nil || got.name != "globex" || got.workflowID != "wf_globex" {
+		t.Fatalf("expected globex, got %+v", got)
+	}
+	if reg.lookup("tk_nobody") != nil || reg.lookup("") != nil {
+		t.Fatal("expected unknown keys not to match")
+	}
+
+	for name, inline := range map[string]string{
+		"no tenants":  `{}`,
+		"no key":      `{"acme": {"workflow_id": "wf_acme"}}`,
+		"no workflow": `{"acme": {"key": "tk_acme"}}`,
+		"shared key":  `{"acme": {"key": "tk", "workflow_id": "a"}, "globex": {"key": "tk", "workflow_id": "b"}}`,
+		"bad json":    `[]`,
+	} {
+		if _, err := loadTenantRegistry("", inline, newCreator); err == nil {
+			t.Fatalf("%s: expected an error", name)
+		}
+	}
+	if reg, err := loadTenantRegistry("", "", newCreator); reg != nil || err != nil {
+		t.Fatalf("expected no registry, got %v, %v", reg, err)
+	}
+}
+
+func TestHandleSessionRoutesByTenantKey(t *testing.T) {
+	defaults := &fakeSessionCreator{clientSecret: "default"}
+	acme := &fakeSessionCreator{clientSecret: "acme"}
+	handler := newSessionHandler(defaults.Create, "w", 1200, 10)
+	handler.quota = newQuotaTracker(1, 80, time.Hour)
+	var err error
+	handler.tenants, err = loadTenantRegistry("", `{"acme": {"key": "tk_acme", "workflow_id": "wf_acme", "openai_api_key": "sk-acme", "expires_after_seconds": 60}}`, func(apiKey string) (sessionCreator, error) {
+		return acme.Create, nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	handler.tenants.tenants[0].quota = handler.quota.clone()
+	router, err := newRouter(handler, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected router error: %v", err)
+	}
+	post := func(key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/chatkit/session", strings.NewReader(body))
+		if key != "" {
+			req.Header.Set(tenantKeyHeader, key)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := post("", `{"user":"u"}`); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without a tenant key, got %d", rec.Code)
+	}
+	if rec := post("tk_other", `{"user":"u"}`); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for an unknown tenant key, got %d", rec.Code)
+	}
+	if rec := post("tk_acme", `{"user":"u","workflow_id":"w"}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for another workflow, got %d", rec.Code)
+	}
+
+	rec := post("tk_acme", `{"user":"u"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if defaults.called || !acme.called {
+		t.Fatal("expected the tenant's session creator to be used")
+	}
+	if acme.params.Workflow.ID != "wf_acme" || acme.params.ExpiresAfter.Seconds != 60 || acme.params.RateLimits.MaxRequestsPer1Minute.Value != 10 {
+		t.Fatalf("unexpected params: workflow %q, expires %d, rate %d", acme.params.Workflow.ID, acme.params.ExpiresAfter.Seconds, acme.params.RateLimits.MaxRequestsPer1Minute.Value)
+	}
+	if _, ok := handler.quota.reserve("u"); !ok {
+		t.Fatal("expected tenant sessions not to count against the default quota")
+	}
+}
@@ -0,0 +1,18 @@
+package session
+
+import (
+	"log/slog"
+	"net/http"
+)
+
+// withClientCertificate adds the subject common name of a verified client
+// certificate to the access log line as client_cert_cn, for auditing which
+// service made each call.
+func withClientCertificate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+			addLogFields(r.Context(), slog.String("client_cert_cn", r.TLS.VerifiedChains[0][0].Subject.CommonName))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,107 @@
+package session
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"openai-chatkit-backend/chatkit/server"
+)
+
+// newTestCA returns a self-signed CA and a client certificate it issued for
+// cn.
+func newTestCA(t *testing.T, cn string) (*x509.Certificate, tls.Certificate) {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	clientDER, err := x509.CreateCertificate(rand.Reader, clientTemplate, ca, &clientKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ca, tls.Certificate{Certificate: [][]byte{clientDER}, PrivateKey: clientKey}
+}
+
+func TestClientCertificateAuthentication(t *testing.T) {
+	ca, clientCert := newTestCA(t, "billing-service")
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var cn string
+	upstream := httptest.NewUnstartedServer(withRequestID(withClientCertificate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, a := range requestLogFromContext(r.Context()).attrs {
+			if a.Key == "client_cert_cn" {
+				cn = a.Value.String()
+			}
+		}
+	}))))
+	upstream.TLS = &tls.Config{}
+	expiresAt, err := server.ConfigureClientAuth(upstream.TLS, caFile, "require")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !expiresAt.Equal(ca.NotAfter) {
+		t.Fatalf("expected the CA's expiry, got %v", expiresAt)
+	}
+	upstream.StartTLS()
+	defer upstream.Close()
+
+	client := upstream.Client()
+	resp, err := client.Get(upstream.URL)
+	if err == nil {
+		resp.Body.Close()
+		t.Fatal("expected a caller without a certificate to be refused")
+	}
+
+	client.Transport.(*http.Transport).TLSClientConfig.Certificates = []tls.Certificate{clientCert}
+	resp, err = client.Get(upstream.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	if cn != "billing-service" {
+		t.Fatalf("expected the client certificate CN to be logged, got %q", cn)
+	}
+}
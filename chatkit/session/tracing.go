@@ -0,0 +1,428 @@
+package session
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	traceparentHeader       = "traceparent"
+	defaultTraceServiceName = "openai-chatkit-backend"
+	traceScopeName          = "openai-chatkit-backend"
+
+	traceExportInterval = 5 * time.Second
+	traceExportTimeout  = 10 * time.Second
+	traceMaxBatch       = 512
+	// traceQueueSize bounds the finished spans waiting for export. Spans
+	// beyond it are dropped rather than slowing down requests.
+	traceQueueSize = 4096
+)
+
+// OTLP span kinds and status codes.
+const (
+	spanKindServer = 2
+	spanKindClient = 3
+
+	spanStatusError = 2
+)
+
+// traceContext identifies a span as carried by the W3C traceparent header.
+type traceContext struct {
+	traceID [16]byte
+	spanID  [8]byte
+	sampled bool
+}
+
+// parseTraceparent parses a version 00 traceparent header.
+func parseTraceparent(v string) (traceContext, bool) {
+	var tc traceContext
+	parts := strings.Split(strings.TrimSpace(v), "-")
+	if len(parts) != 4 || parts[0] != "00" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return tc, false
+	}
+	if _, err := hex.Decode(tc.traceID[:], []byte(parts[1])); err != nil || tc.traceID == [16]byte{} {
+		return tc, false
+	}
+	if _, err := hex.Decode(tc.spanID[:], []byte(parts[2])); err != nil || tc.spanID == [8]byte{} {
+		return tc, false
+	}
+	flags, err := strconv.ParseUint(parts[3], 16, 8)
+	if err != nil {
+		return tc, false
+	}
+	tc.sampled = flags&1 == 1
+	return tc, true
+}
+
+func (tc traceContext) traceparent() string {
+	flags := "00"
+	if tc.sampled {
+		flags = "01"
+	}
+	return "00-" + hex.EncodeToString(tc.traceID[:]) + "-" + hex.EncodeToString(tc.spanID[:]) + "-" + flags
+}
+
+// span is one timed operation. Unsampled spans still carry the trace
+// context to OpenAI but are never exported.
+type span struct {
+	tracer   *tracer
+	name     string
+	kind     int
+	ctx      traceContext
+	parentID [8]byte
+	start    time.Time
+
+	mu        sync.Mutex
+	end       time.Time
+	attrs     []otlpAttribute
+	errStatus string
+}
+
+type spanKey struct{}
+
+func spanFromContext(ctx context.Context) *span {
+	s, _ := ctx.Value(spanKey{}).(*span)
+	return s
+}
+
+// setAttr records an attribute. It is a no-op on a nil span, so callers
+// need not check whether tracing is enabled.
+func (s *span) setAttr(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attrs = append(s.attrs, newOTLPAttribute(key, value))
+}
+
+// setError marks the span as failed.
+func (s *span) setError(msg string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errStatus = msg
+}
+
+// finish ends the span and queues it for export.
+func (s *span) finish() {
+	s.mu.Lock()
+	s.end = s.tracer.clock.Now()
+	s.mu.Unlock()
+	if s.ctx.sampled {
+		s.tracer.enqueue(s)
+	}
+}
+
+// tracer records spans and exports them in batches to an OTLP/HTTP
+// collector using the JSON encoding, such as the OpenTelemetry Collector,
+// Jaeger, or Tempo.
+type tracer struct {
+	endpoint    string
+	headers     map[string]string
+	serviceName string
+	// region is exported as the cloud.region resource attribute when set.
+	region string
+	// ratio is the fraction of new traces sampled. Incoming traceparent
+	// headers keep their own sampling decision.
+	ratio  float64
+	client *http.Client
+	clock  Clock
+	rand   Rand
+
+	queue   chan *span
+	dropped atomic.Int64
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+// newTracerFromEnv configures a tracer from the standard OTEL_* variables.
+// It returns nil when no OTLP endpoint is set.
+func newTracerFromEnv(getenv func(string) string) (*tracer, error) {
+	endpoint := getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	if endpoint == "" {
+		if base := getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); base != "" {
+			endpoint = strings.TrimSuffix(base, "/") + "/v1/traces"
+		}
+	}
+	if endpoint == "" {
+		return nil, nil
+	}
+	if p := getenv("OTEL_EXPORTER_OTLP_PROTOCOL"); p != "" && p != "http/json" {
+		return nil, fmt.Errorf("unsupported OTEL_EXPORTER_OTLP_PROTOCOL %q: only http/json is supported", p)
+	}
+
+	t := &tracer{
+		endpoint:    endpoint,
+		headers:     make(map[string]string),
+		serviceName: getenv("OTEL_SERVICE_NAME"),
+		ratio:       1,
+		client:      &http.Client{Timeout: traceExportTimeout},
+		clock:       SystemClock,
+		rand:        SystemRand,
+		queue:       make(chan *span, traceQueueSize),
+	}
+	if t.serviceName == "" {
+		t.serviceName = defaultTraceServiceName
+	}
+	for _, pair := range strings.Split(getenv("OTEL_EXPORTER_OTLP_HEADERS"), ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			return nil, fmt.Errorf("invalid OTEL_EXPORTER_OTLP_HEADERS entry %q: expected key=value", pair)
+		}
+		t.headers[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	switch sampler := getenv("OTEL_TRACES_SAMPLER"); sampler {
+	case "", "parentbased_always_on":
+	case "parentbased_traceidratio":
+		arg := getenv("OTEL_TRACES_SAMPLER_ARG")
+		ratio, err := strconv.ParseFloat(arg, 64)
+		if err != nil || ratio < 0 || ratio > 1 {
+			return nil, fmt.Errorf("invalid OTEL_TRACES_SAMPLER_ARG %q: expected a ratio between 0 and 1", arg)
+		}
+		t.ratio = ratio
+	default:
+		return nil, fmt.Errorf("unsupported OTEL_TRACES_SAMPLER %q: expected parentbased_always_on or parentbased_traceidratio", sampler)
+	}
+	return t, nil
+}
+
+// startSpan starts a span as a child of parent, or as the root of a new
+// trace when parent is nil.
+func (t *tracer) startSpan(name string, kind int, parent *traceContext) *span {
+	s := &span{tracer: t, name: name, kind: kind, start: t.clock.Now()}
+	if parent != nil {
+		s.ctx.traceID = parent.traceID
+		s.ctx.sampled = parent.sampled
+		s.parentID = parent.spanID
+	} else {
+		_, _ = rand.Read(s.ctx.traceID[:])
+		s.ctx.sampled = t.rand.Float64() < t.ratio
+	}
+	_, _ = rand.Read(s.ctx.spanID[:])
+	return s
+}
+
+func (t *tracer) enqueue(s *span) {
+	select {
+	case t.queue <- s:
+	default:
+		t.dropped.Add(1)
+	}
+}
+
+// withTracing starts a server span for every request, continuing the trace
+// of an incoming traceparent header, and adds the trace ID to the access
+// log line.
+func withTracing(t *tracer, next http.Handler) http.Handler {
+	if t == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var parent *traceContext
+		if tc, ok := parseTraceparent(r.Header.Get(traceparentHeader)); ok {
+			parent = &tc
+		}
+		s := t.startSpan(r.Method, spanKindServer, parent)
+		s.setAttr("http.request.method", r.Method)
+		s.setAttr("url.path", r.URL.Path)
+		addLogFields(r.Context(), slog.String("trace_id", hex.EncodeToString(s.ctx.traceID[:])))
+
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), spanKey{}, s)))
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+		s.setAttr("http.response.status_code", sw.status)
+		if sw.status >= http.StatusInternalServerError {
+			s.setError(http.StatusText(sw.status))
+		}
+		s.finish()
+	})
+}
+
+func (t *tracer) start(context.Context) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.cancel = cancel
+	t.done = make(chan struct{})
+	go func() {
+		defer close(t.done)
+		ticker := time.NewTicker(traceExportInterval)
+		defer ticker.Stop()
+		var batch []*span
+		for {
+			select {
+			case <-ctx.Done():
+				for drained := false; !drained; {
+					select {
+					case s := <-t.queue:
+						batch = append(batch, s)
+					default:
+						drained = true
+					}
+				}
+				t.export(batch)
+				return
+			case s := <-t.queue:
+				if batch = append(batch, s); len(batch) >= traceMaxBatch {
+					t.export(batch)
+					batch = nil
+				}
+			case <-ticker.C:
+				t.export(batch)
+				batch = nil
+			}
+		}
+	}()
+	return nil
+}
+
+// stop exports the spans still queued.
+func (t *tracer) stop(ctx context.Context) error {
+	if t.cancel == nil {
+		return nil
+	}
+	t.cancel()
+	select {
+	case <-t.done:
+		if n := t.dropped.Load(); n > 0 {
+			slog.Warn("trace spans were dropped because the export queue was full", "dropped", n)
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// export sends spans to the collector. Failed batches are dropped: traces
+// are diagnostic and must not build up memory while the collector is down.
+func (t *tracer) export(spans []*span) {
+	if len(spans) == 0 {
+		return
+	}
+	body, err := json.Marshal(t.payload(spans))
+	if err != nil {
+		slog.Error("failed to encode trace spans", "error", err)
+		return
+	}
+	req, err := http.NewRequest(http.MethodPost, t.endpoint, bytes.NewReader(body))
+	if err != nil {
+		slog.Error("failed to export trace spans", "error", err)
+		return
+	}
+	req.Header.Set("Content-Type", contentTypeJSON)
+	for k, v := range t.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		slog.Warn("failed to export trace spans", "spans", len(spans), "error", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		slog.Warn("trace collector rejected spans", "spans", len(spans), "status", resp.StatusCode)
+	}
+}
+
+// OTLP/JSON payload types. IDs are hex encoded and 64-bit integers are
+// strings, as the OTLP JSON encoding requires.
+type (
+	otlpTraces struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           string          `json:"traceId"`
+		SpanID            string          `json:"spanId"`
+		ParentSpanID      string          `json:"parentSpanId,omitempty"`
+		Name              string          `json:"name"`
+		Kind              int             `json:"kind"`
+		StartTimeUnixNano string          `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+		Attributes        []otlpAttribute `json:"attributes,omitempty"`
+		Status            *otlpStatus     `json:"status,omitempty"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	}
+	otlpAttribute struct {
+		Key   string         `json:"key"`
+		Value map[string]any `json:"value"`
+	}
+)
+
+func newOTLPAttribute(key string, value any) otlpAttribute {
+	switch v := value.(type) {
+	case int:
+		return otlpAttribute{Key: key, Value: map[string]any{"intValue": strconv.Itoa(v)}}
+	case int64:
+		return otlpAttribute{Key: key, Value: map[string]any{"intValue": strconv.FormatInt(v, 10)}}
+	case bool:
+		return otlpAttribute{Key: key, Value: map[string]any{"boolValue": v}}
+	default:
+		return otlpAttribute{Key: key, Value: map[string]any{"stringValue": fmt.Sprint(v)}}
+	}
+}
+
+func (t *tracer) payload(spans []*span) otlpTraces {
+	out := make([]otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		s.mu.Lock()
+		o := otlpSpan{
+			TraceID:           hex.EncodeToString(s.ctx.traceID[:]),
+			SpanID:            hex.EncodeToString(s.ctx.spanID[:]),
+			Name:              s.name,
+			Kind:              s.kind,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+			Attributes:        s.attrs,
+		}
+		if s.parentID != [8]byte{} {
+			o.ParentSpanID = hex.EncodeToString(s.parentID[:])
+		}
+		if s.errStatus != "" {
+			o.Status = &otlpStatus{Code: spanStatusError, Message: s.errStatus}
+		}
+		s.mu.Unlock()
+		out = append(out, o)
+	}
+	resource := []otlpAttribute{newOTLPAttribute("service.name", t.serviceName)}
+	if t.region != "" {
+		resource = append(resource, newOTLPAttribute("cloud.region", t.region))
+	}
+	return otlpTraces{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: resource},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: traceScopeName}, Spans: out}},
+	}}}
+}
@@ -0,0 +1,104 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	const header = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	tc, ok := parseTraceparent(header)
+	if !ok || !tc.sampled || tc.traceparent() != header {
+		t.Fatalf("expected %s to round-trip, got %q", header, tc.traceparent())
+	}
+	for _, bad := range []string{
+		"",
+		"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473g-00f067aa0ba902b7-01",
+	} {
+		if _, ok := parseTraceparent(bad); ok {
+			t.Fatalf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestTracingExportsServerAndOpenAISpans(t *testing.T) {
+	var exported otlpTraces
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Authorization") != "Bearer collector" {
+			t.Errorf("unexpected export to %s", r.URL.Path)
+		}
+		_ = json.NewDecoder(r.Body).Decode(&exported)
+	}))
+	defer collector.Close()
+
+	var upstreamTraceparent string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamTraceparent = r.Header.Get(traceparentHeader)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer upstream.Close()
+
+	tr, err := newTracerFromEnv(func(key string) string {
+		return map[string]string{
+			"OTEL_EXPORTER_OTLP_ENDPOINT": collector.URL + "/",
+			"OTEL_EXPORTER_OTLP_HEADERS":  "Authorization=Bearer collector",
+			"OTEL_SERVICE_NAME":           "chatkit-test",
+		}[key]
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := tr.start(context.Background()); err != nil {
+		t.Fatalf("unexpected start error: %v", err)
+	}
+
+	client := NewOpenAIClient(OpenAIClientConfig{APIKey: "sk-test", BaseURL: upstream.URL})
+	handler := withTracing(tr, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		spanFromContext(r.Context()).setAttr("chatkit.workflow_id", "wf")
+		_, _ = client.Models.List(r.Context())
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	req := httptest.NewRequest(http.MethodPost, "/api/chatkit/session", strings.NewReader(`{}`))
+	req.Header.Set(traceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if err := tr.stop(context.Background()); err != nil {
+		t.Fatalf("unexpected stop error: %v", err)
+	}
+
+	upstreamCtx, ok := parseTraceparent(upstreamTraceparent)
+	if !ok || upstreamCtx.traceparent()[3:35] != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("expected the trace to be forwarded to OpenAI, got %q", upstreamTraceparent)
+	}
+	if len(exported.ResourceSpans) != 1 {
+		t.Fatalf("expected one resource, got %+v", exported)
+	}
+	rs := exported.ResourceSpans[0]
+	if rs.Resource.Attributes[0].Value["stringValue"] != "chatkit-test" {
+		t.Fatalf("unexpected resource: %+v", rs.Resource)
+	}
+	spans := map[int]otlpSpan{}
+	for _, s := range rs.ScopeSpans[0].Spans {
+		if s.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Fatalf("expected every span in the incoming trace, got %s", s.TraceID)
+		}
+		spans[s.Kind] = s
+	}
+	server, clientSpan := spans[spanKindServer], spans[spanKindClient]
+	if server.ParentSpanID != "00f067aa0ba902b7" || server.Status == nil || server.Status.Code != spanStatusError {
+		t.Fatalf("unexpected server span: %+v", server)
+	}
+	if clientSpan.ParentSpanID != server.SpanID || clientSpan.SpanID != hexSpanID(upstreamCtx) || clientSpan.Status == nil {
+		t.Fatalf("unexpected client span: %+v", clientSpan)
+	}
+}
+
+func hexSpanID(tc traceContext) string {
+	return tc.traceparent()[36:52]
+}
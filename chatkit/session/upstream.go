@@ -0,0 +1,122 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+
+	"github.com/openai/openai-go/v3"
+	"github.com/openai/openai-go/v3/option"
+)
+
+const (
+	chatKitBetaHeader = "OpenAI-Beta"
+	// nativeChatKitAPIVersion is the ChatKit beta version spoken by the
+	// bundled SDK.
+	nativeChatKitAPIVersion = "v1"
+)
+
+var chatKitAPIVersionPattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// chatKitAPI is the upstream ChatKit session surface. Everything that talks
+// to OpenAI goes through it so that a change in the shape of the beta API
+// only touches the implementations below.
+type chatKitAPI interface {
+	// CreateSession mints a session and returns it in the SDK's shape.
+	CreateSession(ctx context.Context, params openai.BetaChatKitSessionNewParams) (*openai.ChatSession, error)
+	// CancelSession cancels a session by ID.
+	CancelSession(ctx context.Context, id string) error
+}
+
+// newChatKitAPI returns the implementation for the given beta API version.
+// The native version uses the SDK directly; any other version is sent
+// through compatChatKitAPI so that old and new versions can run side by
+// side during a migration.
+func newChatKitAPI(client openai.Client, version string) (chatKitAPI, error) {
+	if version == "" || version == nativeChatKitAPIVersion {
+		return sdkChatKitAPI{client: client}, nil
+	}
+	if !chatKitAPIVersionPattern.MatchString(version) {
+		return nil, fmt.Errorf("invalid ChatKit API version %q", version)
+	}
+	return compatChatKitAPI{client: client, version: version}, nil
+}
+
+type sdkChatKitAPI struct {
+	client openai.Client
+}
+
+func (a sdkChatKitAPI) CreateSession(ctx context.Context, params openai.BetaChatKitSessionNewParams) (*openai.ChatSession, error) {
+	return a.client.Beta.ChatKit.Sessions.New(ctx, params)
+}
+
+func (a sdkChatKitAPI) CancelSession(ctx context.Context, id string) error {
+	_, err := a.client.Beta.ChatKit.Sessions.Cancel(ctx, id)
+	return err
+}
+
+// compatChatKitAPI speaks a ChatKit beta version that the SDK does not know
+// about. It sends the SDK's request body with the requested beta header and
+// normalizes the response with decodeChatSession.
+type compatChatKitAPI struct {
+	client  openai.Client
+	version string
+}
+
+func (a compatChatKitAPI) betaHeader() option.RequestOption {
+	return option.WithHeader(chatKitBetaHeader, "chatkit_beta="+a.version)
+}
+
+func (a compatChatKitAPI) CreateSession(ctx context.Context, params openai.BetaChatKitSessionNewParams) (*openai.ChatSession, error) {
+	var body []byte
+	if err := a.client.Post(ctx, "chatkit/sessions", params, &body, a.betaHeader()); err != nil {
+		return nil, err
+	}
+	return decodeChatSession(body)
+}
+
+func (a compatChatKitAPI) CancelSession(ctx context.Context, id string) error {
+	if id == "" {
+		return fmt.Errorf("missing session ID")
+	}
+	return a.client.Post(ctx, "chatkit/sessions/"+url.PathEscape(id)+"/cancel", nil, nil, a.betaHeader())
+}
+
+// decodeChatSession parses a session response into the SDK's shape. Besides
+// the v1 shape it accepts client_secret as an object with a value field and
+// the session ID under session_id.
+func decodeChatSession(body []byte) (*openai.ChatSession, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, fmt.Errorf("decode ChatKit session: %w", err)
+	}
+
+	if raw, ok := fields["client_secret"]; ok {
+		var secret struct {
+			Value string `json:"value"`
+		}
+		if json.Unmarshal(raw, &secret) == nil && secret.Value != "" {
+			fields["client_secret"], _ = json.Marshal(secret.Value)
+		}
+	}
+	if _, ok := fields["id"]; !ok {
+		if raw, ok := fields["session_id"]; ok {
+			fields["id"] = raw
+		}
+	}
+
+	normalized, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	var session openai.ChatSession
+	if err := json.Unmarshal(normalized, &session); err != nil {
+		return nil, fmt.Errorf("decode ChatKit session: %w", err)
+	}
+	if session.ClientSecret == "" {
+		return nil, fmt.Errorf("decode ChatKit session: response has no client secret")
+	}
+	return &session, nil
+}
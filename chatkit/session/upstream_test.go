@@ -0,0 +1,88 @@
+package session
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/openai/openai-go/v3"
+	"github.com/openai/openai-go/v3/option"
+)
+
+func TestDecodeChatSessionShapes(t *testing.T) {
+	for name, body := range map[string]string{
+		"v1":            `{"id":"cksess_1","client_secret":"ek_1"}`,
+		"secret object": `{"id":"cksess_1","client_secret":{"value":"ek_1","expires_at":123}}`,
+		"session_id":    `{"session_id":"cksess_1","client_secret":"ek_1"}`,
+	} {
+		session, err := decodeChatSession([]byte(body))
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+		if session.ID != "cksess_1" || session.ClientSecret != "ek_1" {
+			t.Fatalf("%s: unexpected session: %+v", name, session)
+		}
+	}
+
+	if _, err := decodeChatSession([]byte(`{"id":"cksess_1"}`)); err == nil {
+		t.Fatalf("expected error for response without client secret")
+	}
+}
+
+func TestNewChatKitAPIVersions(t *testing.T) {
+	client := openai.NewClient(option.WithAPIKey("k"))
+	if api, err := newChatKitAPI(client, nativeChatKitAPIVersion); err != nil {
+		t.Fatal(err)
+	} else if _, ok := api.(sdkChatKitAPI); !ok {
+		t.Fatalf("expected the SDK implementation for %s, got %T", nativeChatKitAPIVersion, api)
+	}
+	if api, err := newChatKitAPI(client, "v2"); err != nil {
+		t.Fatal(err)
+	} else if _, ok := api.(compatChatKitAPI); !ok {
+		t.Fatalf("expected the compatibility implementation for v2, got %T", api)
+	}
+	if _, err := newChatKitAPI(client, "v2, v3"); err == nil {
+		t.Fatalf("expected error for malformed version")
+	}
+}
+
+func TestCompatChatKitAPISendsVersionHeader(t *testing.T) {
+	var betaHeaders []string
+	var paths []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		betaHeaders = append(betaHeaders, r.Header.Get(chatKitBetaHeader))
+		paths = append(paths, r.URL.Path)
+		w.Header().Set("Content-Type", contentTypeJSON)
+		_, _ = w.Write([]byte(`{"session_id":"cksess_1","client_secret":{"value":"ek_1"}}`))
+	}))
+	defer upstream.Close()
+
+	client := openai.NewClient(option.WithAPIKey("k"), option.WithBaseURL(upstream.URL), option.WithMaxRetries(0))
+	api, err := newChatKitAPI(client, "v2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err := api.CreateSession(context.Background(), openai.BetaChatKitSessionNewParams{
+		User:     "u",
+		Workflow: openai.ChatSessionWorkflowParam{ID: "wf"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if session.ClientSecret != "ek_1" {
+		t.Fatalf("unexpected session: %+v", session)
+	}
+	if err := api.CancelSession(context.Background(), session.ID); err != nil {
+		t.Fatalf("unexpected cancel error: %v", err)
+	}
+
+	if len(paths) != 2 || paths[0] != "/chatkit/sessions" || paths[1] != "/chatkit/sessions/cksess_1/cancel" {
+		t.Fatalf("unexpected paths: %v", paths)
+	}
+	for _, h := range betaHeaders {
+		if h != "chatkit_beta=v2" {
+			t.Fatalf("expected chatkit_beta=v2 header, got %q", h)
+		}
+	}
+}
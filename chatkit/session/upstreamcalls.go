@@ -0,0 +1,115 @@
+package session
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/openai/openai-go/v3/option"
+
+	"context"
+
+	"sync"
+)
+
+const (
+	// openAIRetryCountHeader is set by the SDK on every attempt of a call.
+	openAIRetryCountHeader = "X-Stainless-Retry-Count"
+	// openAIResponseIDHeader carries OpenAI's ID for a request, which its
+	// support needs to investigate a failure.
+	openAIResponseIDHeader   = "X-Request-Id"
+	openAIProcessingMSHeader = "Openai-Processing-Ms"
+)
+
+// openAICallRecord keeps the OpenAI request ID of the latest attempt of the
+// calls made with its context, so that the caller can store it for billing
+// reconciliation.
+type openAICallRecord struct {
+	mu sync.Mutex
+	id string
+}
+
+type openAICallRecordKey struct{}
+
+// withOpenAICallRecord returns ctx with a new record for the OpenAI calls
+// made with it.
+func withOpenAICallRecord(ctx context.Context) (context.Context, *openAICallRecord) {
+	rec := &openAICallRecord{}
+	return context.WithValue(ctx, openAICallRecordKey{}, rec), rec
+}
+
+// requestID returns the OpenAI request ID of the latest attempt, or "".
+func (c *openAICallRecord) requestID() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.id
+}
+
+// openAIInstrumentation is the middleware every OpenAI client is built
+// with, so that each upstream call is traced and logged the same way
+// without instrumenting call sites.
+var openAIInstrumentation = option.WithMiddleware(forwardRequestID, instrumentOpenAICall)
+
+// instrumentOpenAICall runs for every attempt of an OpenAI call, retries
+// included. It records the attempt as a client span of the request that
+// made it, propagates the trace to OpenAI, logs the attempt's timing,
+// retry count, and OpenAI request ID, and adds the latest attempt's to the
+// access log line and to the context's openAICallRecord.
+func instrumentOpenAICall(req *http.Request, next option.MiddlewareNext) (*http.Response, error) {
+	ctx := req.Context()
+	retries, _ := strconv.Atoi(req.Header.Get(openAIRetryCountHeader))
+
+	var s *span
+	if parent := spanFromContext(ctx); parent != nil {
+		s = parent.tracer.startSpan("OpenAI "+req.Method+" "+req.URL.Path, spanKindClient, &parent.ctx)
+		s.setAttr("http.request.method", req.Method)
+		s.setAttr("server.address", req.URL.Hostname())
+		s.setAttr("url.path", req.URL.Path)
+		if retries > 0 {
+			s.setAttr("http.request.resend_count", retries)
+		}
+		req.Header.Set(traceparentHeader, s.ctx.traceparent())
+	}
+
+	start := time.Now()
+	resp, err := next(req)
+	elapsed := time.Since(start)
+
+	attrs := []any{"method", req.Method, "path", req.URL.Path, "retries", retries, "duration_ms", elapsed.Milliseconds()}
+	fields := []slog.Attr{slog.Int("openai_retries", retries), slog.Int64("openai_duration_ms", elapsed.Milliseconds())}
+	if err != nil {
+		s.setError(err.Error())
+		slog.WarnContext(ctx, "OpenAI call failed", append(attrs, "error", err)...)
+	} else {
+		id := resp.Header.Get(openAIResponseIDHeader)
+		s.setAttr("http.response.status_code", resp.StatusCode)
+		attrs = append(attrs, "status", resp.StatusCode)
+		fields = append(fields, slog.Int("openai_status", resp.StatusCode))
+		if id != "" {
+			s.setAttr("openai.request_id", id)
+			attrs = append(attrs, "openai_request_id", id)
+			fields = append(fields, slog.String("openai_request_id", id))
+			if rec, _ := ctx.Value(openAICallRecordKey{}).(*openAICallRecord); rec != nil {
+				rec.mu.Lock()
+				rec.id = id
+				rec.mu.Unlock()
+			}
+		}
+		if ms, err := strconv.ParseInt(resp.Header.Get(openAIProcessingMSHeader), 10, 64); err == nil {
+			s.setAttr("openai.processing_ms", ms)
+			attrs = append(attrs, "processing_ms", ms)
+		}
+		if resp.StatusCode >= http.StatusBadRequest {
+			s.setError(http.StatusText(resp.StatusCode))
+			slog.WarnContext(ctx, "OpenAI call failed", attrs...)
+		} else {
+			slog.DebugContext(ctx, "OpenAI call", attrs...)
+		}
+	}
+	setLogFields(ctx, fields...)
+	if s != nil {
+		s.finish()
+	}
+	return resp, err
+}
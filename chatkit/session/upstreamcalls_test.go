@@ -0,0 +1,51 @@
+package session
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/openai/openai-go/v3"
+)
+
+func TestOpenAIClientInstrumentsEveryAttempt(t *testing.T) {
+	var attempts atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) == 1 {
+			w.Header().Set("Retry-After-Ms", "1")
+			http.Error(w, `{"error":{"message":"try again"}}`, http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set(openAIResponseIDHeader, "req_upstream")
+		w.Header().Set(openAIProcessingMSHeader, "42")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"cksess_1","client_secret":"secret"}`))
+	}))
+	defer upstream.Close()
+
+	client := NewOpenAIClient(OpenAIClientConfig{APIKey: "sk-test", BaseURL: upstream.URL})
+	log := &requestLog{id: "req_abc"}
+	ctx := context.WithValue(context.Background(), requestLogKey{}, log)
+	if _, err := client.Beta.ChatKit.Sessions.New(ctx, openai.BetaChatKitSessionNewParams{User: "u"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if attempts.Load() != 2 {
+		t.Fatalf("expected one retry, got %d attempts", attempts.Load())
+	}
+
+	fields := map[string]string{}
+	for _, a := range log.attrs {
+		if _, dup := fields[a.Key]; dup {
+			t.Fatalf("expected %s to be logged once, got %v", a.Key, log.attrs)
+		}
+		fields[a.Key] = a.Value.String()
+	}
+	if fields["openai_request_id"] != "req_upstream" || fields["openai_retries"] != "1" || fields["openai_status"] != "200" {
+		t.Fatalf("expected the final attempt in the access log fields, got %v", fields)
+	}
+	if _, ok := fields["openai_duration_ms"]; !ok {
+		t.Fatalf("expected the attempt's duration, got %v", fields)
+	}
+}
@@ -0,0 +1,59 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"regexp"
+
+	"github.com/openai/openai-go/v3"
+)
+
+const maxUpstreamErrorMessageLen = 512
+
+// secretPattern matches API keys and client secrets that upstream messages
+// sometimes echo back.
+var secretPattern = regexp.MustCompile(`\b(sk|ek|sess|cs)[-_][A-Za-z0-9_\-]{8,}`)
+
+// upstreamErrorDetail is the sanitized view of an OpenAI failure included in
+// error responses when upstream error exposure is enabled.
+type upstreamErrorDetail struct {
+	Status  int    `json:"status,omitempty"`
+	Type    string `json:"type,omitempty"`
+	Code    string `json:"code,omitempty"`
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type sessionErrorResponse struct {
+	Error     string               `json:"error"`
+	Upstream  *upstreamErrorDetail `json:"upstream,omitempty"`
+	RequestID string               `json:"request_id,omitempty"`
+}
+
+func describeUpstreamError(err error) *upstreamErrorDetail {
+	var apiErr *openai.Error
+	switch {
+	case errors.As(err, &apiErr):
+		return &upstreamErrorDetail{
+			Status:  apiErr.StatusCode,
+			Type:    apiErr.Type,
+			Code:    apiErr.Code,
+			Param:   apiErr.Param,
+			Message: sanitizeUpstreamMessage(apiErr.Message),
+		}
+	case errors.Is(err, context.DeadlineExceeded):
+		return &upstreamErrorDetail{Type: "timeout", Message: "upstream request timed out"}
+	case errors.Is(err, context.Canceled):
+		return &upstreamErrorDetail{Type: "canceled", Message: "request canceled"}
+	default:
+		return &upstreamErrorDetail{Type: "transport", Message: sanitizeUpstreamMessage(err.Error())}
+	}
+}
+
+func sanitizeUpstreamMessage(msg string) string {
+	msg = secretPattern.ReplaceAllString(msg, "[redacted]")
+	if len(msg) > maxUpstreamErrorMessageLen {
+		msg = msg[:maxUpstreamErrorMessageLen] + "..."
+	}
+	return msg
+}
@@ -0,0 +1,63 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	openai "github.com/openai/openai-go/v3"
+)
+
+func TestHandleSessionExposesSanitizedUpstreamError(t *testing.T) {
+	apiErr := &openai.Error{
+		StatusCode: http.StatusBadRequest,
+		Type:       "invalid_request_error",
+		Code:       "workflow_not_found",
+		Param:      "workflow.id",
+		Message:    "No workflow w for key sk-proj-abcdefghijklmnop",
+		Request:    httptest.NewRequest(http.MethodPost, "https://api.openai.com/v1/chatkit/sessions", nil),
+		Response:   &http.Response{StatusCode: http.StatusBadRequest},
+	}
+	handler := newSessionHandler(func(ctx context.Context, params openai.BetaChatKitSessionNewParams) (*openai.ChatSession, error) {
+		return nil, apiErr
+	}, "w", 1200, 10)
+	handler.exposeUpstreamErrors = true
+
+	req := httptest.NewRequest(http.MethodPost, "/api/chatkit/session", strings.NewReader(`{"user":"u"}`))
+	rec := httptest.NewRecorder()
+	handler.handleSession(rec, req)
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("expected status 500, got %d", rec.Code)
+	}
+	var resp sessionErrorResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if resp.Upstream == nil {
+		t.Fatalf("expected upstream error details")
+	}
+	if resp.Upstream.Status != http.StatusBadRequest || resp.Upstream.Code != "workflow_not_found" || resp.Upstream.Param != "workflow.id" {
+		t.Fatalf("unexpected upstream details: %+v", resp.Upstream)
+	}
+	if strings.Contains(resp.Upstream.Message, "sk-proj") {
+		t.Fatalf("expected API key to be redacted, got %q", resp.Upstream.Message)
+	}
+}
+
+func TestHandleSessionHidesUpstreamErrorByDefault(t *testing.T) {
+	handler := newSessionHandler(func(ctx context.Context, params openai.BetaChatKitSessionNewParams) (*openai.ChatSession, error) {
+		return nil, context.DeadlineExceeded
+	}, "w", 1200, 10)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/chatkit/session", strings.NewReader(`{"user":"u"}`))
+	rec := httptest.NewRecorder()
+	handler.handleSession(rec, req)
+
+	if strings.TrimSpace(rec.Body.String()) != "failed to create session" {
+		t.Fatalf("expected generic error body, got %q", rec.Body.String())
+	}
+}
@@ -0,0 +1,142 @@
+package session
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const defaultUpstreamQuotaWarnPercent = 10
+
+// OpenAI reports the project's rate-limit headroom on every response. See
+// https://platform.openai.com/docs/guides/rate-limits.
+var upstreamRateLimitKinds = []string{"requests", "tokens"}
+
+// rateLimitBucket is the headroom OpenAI last reported for one limit.
+type rateLimitBucket struct {
+	Limit            int64      `json:"limit"`
+	Remaining        int64      `json:"remaining"`
+	RemainingPercent int64      `json:"remaining_percent"`
+	ResetAt          *time.Time `json:"reset_at,omitempty"`
+}
+
+type upstreamQuotaStatus struct {
+	Limits        map[string]rateLimitBucket `json:"limits"`
+	ObservedAt    *time.Time                 `json:"observed_at,omitempty"`
+	RateLimited   int64                      `json:"rate_limited_responses"`
+	LastLimitedAt *time.Time                 `json:"last_rate_limited_at,omitempty"`
+}
+
+// upstreamQuotaMonitor infers the remaining OpenAI rate-limit headroom from
+// the x-ratelimit-* headers of every upstream response and warns once
+// whenever a limit drops below warnPercent.
+type upstreamQuotaMonitor struct {
+	warnPercent int64
+	onLow       func(kind string, bucket rateLimitBucket)
+	clock       Clock
+
+	mu     sync.Mutex
+	status upstreamQuotaStatus
+	warned map[string]bool
+}
+
+func newUpstreamQuotaMonitor(warnPercent int64) *upstreamQuotaMonitor {
+	return &upstreamQuotaMonitor{
+		warnPercent: warnPercent,
+		clock:       SystemClock,
+		status:      upstreamQuotaStatus{Limits: make(map[string]rateLimitBucket)},
+		warned:      make(map[string]bool),
+	}
+}
+
+// client returns a copy of base, or of a default client when base is nil,
+// whose responses are observed by the monitor.
+func (m *upstreamQuotaMonitor) client(base *http.Client) *http.Client {
+	c := &http.Client{}
+	if base != nil {
+		*c = *base
+	}
+	next := c.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	c.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		resp, err := next.RoundTrip(req)
+		if err == nil {
+			m.observe(resp.StatusCode, resp.Header)
+		}
+		return resp, err
+	})
+	return c
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// observe records the rate-limit headers of one upstream response.
+func (m *upstreamQuotaMonitor) observe(status int, h http.Header) {
+	now := m.clock.Now()
+	low := make(map[string]rateLimitBucket)
+
+	m.mu.Lock()
+	if status == http.StatusTooManyRequests {
+		m.status.RateLimited++
+		m.status.LastLimitedAt = &now
+	}
+	for _, kind := range upstreamRateLimitKinds {
+		bucket, ok := parseRateLimitBucket(h, kind, now)
+		if !ok {
+			continue
+		}
+		m.status.Limits[kind] = bucket
+		m.status.ObservedAt = &now
+		isLow := m.warnPercent > 0 && bucket.RemainingPercent < m.warnPercent
+		if isLow && !m.warned[kind] {
+			low[kind] = bucket
+		}
+		m.warned[kind] = isLow
+	}
+	m.mu.Unlock()
+
+	for kind, bucket := range low {
+		slog.Warn("OpenAI rate limit headroom is low", "limit_kind", kind, "remaining_percent", bucket.RemainingPercent, "remaining", bucket.Remaining, "limit", bucket.Limit)
+		if m.onLow != nil {
+			m.onLow(kind, bucket)
+		}
+	}
+}
+
+func (m *upstreamQuotaMonitor) snapshot() upstreamQuotaStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	status := m.status
+	status.Limits = make(map[string]rateLimitBucket, len(m.status.Limits))
+	for k, v := range m.status.Limits {
+		status.Limits[k] = v
+	}
+	return status
+}
+
+// parseRateLimitBucket reads x-ratelimit-{limit,remaining,reset}-<kind>.
+// The reset header is a duration such as "6m0s" or "20ms".
+func parseRateLimitBucket(h http.Header, kind string, now time.Time) (rateLimitBucket, bool) {
+	limit, err := strconv.ParseInt(h.Get("X-Ratelimit-Limit-"+kind), 10, 64)
+	if err != nil || limit <= 0 {
+		return rateLimitBucket{}, false
+	}
+	remaining, err := strconv.ParseInt(h.Get("X-Ratelimit-Remaining-"+kind), 10, 64)
+	if err != nil || remaining < 0 {
+		return rateLimitBucket{}, false
+	}
+	bucket := rateLimitBucket{Limit: limit, Remaining: remaining, RemainingPercent: remaining * 100 / limit}
+	if reset, err := time.ParseDuration(h.Get("X-Ratelimit-Reset-" + kind)); err == nil {
+		at := now.Add(reset).UTC()
+		bucket.ResetAt = &at
+	}
+	return bucket, true
+}
@@ -0,0 +1,72 @@
+package session
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func rateLimitHeaders(limit, remaining, reset string) http.Header {
+	h := http.Header{}
+	h.Set("x-ratelimit-limit-requests", limit)
+	h.Set("x-ratelimit-remaining-requests", remaining)
+	h.Set("x-ratelimit-reset-requests", reset)
+	return h
+}
+
+func TestUpstreamQuotaMonitorWarnsOncePerCrossing(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	m := newUpstreamQuotaMonitor(10)
+	m.clock = ClockFunc(func() time.Time { return now })
+	var warned []rateLimitBucket
+	m.onLow = func(kind string, bucket rateLimitBucket) {
+		if kind != "requests" {
+			t.Fatalf("unexpected limit kind %q", kind)
+		}
+		warned = append(warned, bucket)
+	}
+
+	m.observe(http.StatusOK, rateLimitHeaders("1000", "500", "6m0s"))
+	m.observe(http.StatusOK, rateLimitHeaders("1000", "90", "1s"))
+	m.observe(http.StatusOK, rateLimitHeaders("1000", "80", "1s"))
+	if len(warned) != 1 || warned[0].Remaining != 90 || warned[0].RemainingPercent != 9 {
+		t.Fatalf("expected a single warning at 9%%, got %+v", warned)
+	}
+	m.observe(http.StatusOK, rateLimitHeaders("1000", "900", "1s"))
+	m.observe(http.StatusTooManyRequests, rateLimitHeaders("1000", "0", "20ms"))
+	if len(warned) != 2 {
+		t.Fatalf("expected a second warning after recovering, got %d", len(warned))
+	}
+
+	status := m.snapshot()
+	requests, ok := status.Limits["requests"]
+	if !ok || requests.Remaining != 0 || requests.ResetAt == nil || !requests.ResetAt.Equal(now.Add(20*time.Millisecond)) {
+		t.Fatalf("unexpected requests bucket: %+v", requests)
+	}
+	if _, ok := status.Limits["tokens"]; ok {
+		t.Fatalf("expected no tokens bucket without token headers")
+	}
+	if status.RateLimited != 1 || status.LastLimitedAt == nil {
+		t.Fatalf("expected one rate-limited response, got %+v", status)
+	}
+}
+
+func TestUpstreamQuotaMonitorClientObservesResponses(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("x-ratelimit-limit-tokens", "200")
+		w.Header().Set("x-ratelimit-remaining-tokens", "150")
+	}))
+	defer upstream.Close()
+
+	m := newUpstreamQuotaMonitor(10)
+	resp, err := m.client(nil).Get(upstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if tokens := m.snapshot().Limits["tokens"]; tokens.Limit != 200 || tokens.RemainingPercent != 75 {
+		t.Fatalf("unexpected tokens bucket: %+v", tokens)
+	}
+}
@@ -0,0 +1,249 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// usageRetentionDays is how many UTC days of session counts are kept.
+	usageRetentionDays = 35
+	usageDayLayout     = "2006-01-02"
+	// usageLedgerMaxPerDay caps the sessions listed per day in the ledger;
+	// the counts stay exact beyond it.
+	usageLedgerMaxPerDay = 50000
+)
+
+// usageSession is a ledger entry: one minted session with the identifiers
+// OpenAI reports for it, so that finance can match internal metering
+// against the OpenAI dashboard.
+type usageSession struct {
+	SessionID       string    `json:"session_id"`
+	OpenAIRequestID string    `json:"openai_request_id,omitempty"`
+	OpenAIProject   string    `json:"openai_project,omitempty"`
+	Tenant          string    `json:"tenant"`
+	WorkflowID      string    `json:"workflow_id"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// usageCounter counts sessions minted per tenant and UTC day, for usage
+// accounting, and keeps a ledger of the sessions. The default tenant is
+// counted under "".
+type usageCounter struct {
+	clock Clock
+
+	mu        sync.Mutex
+	days      map[string]map[string]int64
+	ledger    map[string][]usageSession
+	truncated map[string]int64
+}
+
+func newUsageCounter() *usageCounter {
+	return &usageCounter{
+		clock:     SystemClock,
+		days:      make(map[string]map[string]int64),
+		ledger:    make(map[string][]usageSession),
+		truncated: make(map[string]int64),
+	}
+}
+
+// recordSession counts s for its tenant and, when OpenAI returned a session
+// ID, adds it to the ledger. It is a no-op on a nil counter.
+func (u *usageCounter) recordSession(s usageSession) {
+	if u == nil {
+		return
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	now := u.clock.Now().UTC()
+	day := now.Format(usageDayLayout)
+	if u.days[day] == nil {
+		u.days[day] = make(map[string]int64)
+		u.pruneLocked()
+	}
+	u.days[day][s.Tenant]++
+	if s.SessionID != "" {
+		if s.CreatedAt.IsZero() {
+			s.CreatedAt = now
+		}
+		u.appendLedgerLocked(day, s)
+	}
+}
+
+func (u *usageCounter) appendLedgerLocked(day string, s usageSession) {
+	if len(u.ledger[day]) >= usageLedgerMaxPerDay {
+		u.truncated[day]++
+		return
+	}
+	u.ledger[day] = append(u.ledger[day], s)
+}
+
+// sessions returns the ledger of day and how many sessions it left out.
+func (u *usageCounter) sessions(day string) ([]usageSession, int64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return append([]usageSession{}, u.ledger[day]...), u.truncated[day]
+}
+
+// ledgerSnapshot returns a copy of the ledger by day.
+func (u *usageCounter) ledgerSnapshot() map[string][]usageSession {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	out := make(map[string][]usageSession, len(u.ledger))
+	for day, sessions := range u.ledger {
+		out[day] = append([]usageSession(nil), sessions...)
+	}
+	return out
+}
+
+// restoreLedger adds saved ledger entries to the current ones.
+func (u *usageCounter) restoreLedger(ledger map[string][]usageSession) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for day, sessions := range ledger {
+		for _, s := range sessions {
+			u.appendLedgerLocked(day, s)
+		}
+	}
+	u.pruneLocked()
+}
+
+// snapshot returns a copy of the counts by day and tenant.
+func (u *usageCounter) snapshot() map[string]map[string]int64 {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	out := make(map[string]map[string]int64, len(u.days))
+	for day, tenants := range u.days {
+		out[day] = make(map[string]int64, len(tenants))
+		for tenant, n := range tenants {
+			out[day][tenant] = n
+		}
+	}
+	return out
+}
+
+// restore adds saved counts to the current ones.
+func (u *usageCounter) restore(days map[string]map[string]int64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for day, tenants := range days {
+		if u.days[day] == nil {
+			u.days[day] = make(map[string]int64)
+		}
+		for tenant, n := range tenants {
+			u.days[day][tenant] += n
+		}
+	}
+	u.pruneLocked()
+}
+
+func (u *usageCounter) pruneLocked() {
+	oldest := u.clock.Now().UTC().AddDate(0, 0, -usageRetentionDays+1).Format(usageDayLayout)
+	for day := range u.days {
+		if day < oldest {
+			delete(u.days, day)
+		}
+	}
+	for day := range u.ledger {
+		if day < oldest {
+			delete(u.ledger, day)
+			delete(u.truncated, day)
+		}
+	}
+}
+
+// usageCheckpoint is the file written by usageCheckpointer.
+type usageCheckpoint struct {
+	SavedAt        time.Time                     `json:"saved_at"`
+	SessionsMinted map[string]map[string]int64   `json:"sessions_minted"`
+	Quota          []quotaUsageReport            `json:"quota,omitempty"`
+	TenantQuota    map[string][]quotaUsageReport `json:"tenant_quota,omitempty"`
+	Sessions       map[string][]usageSession     `json:"sessions,omitempty"`
+}
+
+// usageCheckpointer periodically writes session counts and quota usage to
+// a JSON file and restores them at startup, so that a restart or crash does
+// not reset usage accounting or hand every user a fresh quota.
+type usageCheckpointer struct {
+	path    string
+	usage   *usageCounter
+	quota   *quotaTracker
+	tenants *tenantRegistry
+	clock   Clock
+
+	// mu serializes writes, so the job and the shutdown hook cannot
+	// interleave.
+	mu sync.Mutex
+}
+
+// save writes the current usage to the checkpoint file.
+func (c *usageCheckpointer) save(context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cp := usageCheckpoint{SavedAt: c.clock.Now().UTC(), SessionsMinted: c.usage.snapshot(), Sessions: c.usage.ledgerSnapshot()}
+	if c.quota != nil {
+		cp.Quota = c.quota.report()
+	}
+	if c.tenants != nil {
+		cp.TenantQuota = make(map[string][]quotaUsageReport)
+		for _, t := range c.tenants.tenants {
+			if t.quota != nil {
+				cp.TenantQuota[t.name] = t.quota.report()
+			}
+		}
+	}
+	return writeJSONFile(c.path, cp)
+}
+
+// restore loads the checkpoint file when it exists. Quota for tenants that
+// are no longer configured is dropped.
+func (c *usageCheckpointer) restore() error {
+	data, err := os.ReadFile(c.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var cp usageCheckpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return fmt.Errorf("parse %s: %w", c.path, err)
+	}
+	c.usage.restore(cp.SessionsMinted)
+	c.usage.restoreLedger(cp.Sessions)
+	if c.quota != nil {
+		c.quota.restore(cp.Quota)
+	}
+	if c.tenants != nil {
+		for _, t := range c.tenants.tenants {
+			if t.quota != nil {
+				t.quota.restore(cp.TenantQuota[t.name])
+			}
+		}
+	}
+	return nil
+}
+
+func (a *adminHandler) usageStats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"sessions_minted": a.sessions.usage.snapshot()})
+}
+
+// usageSessions exports the session ledger of one UTC day, today by
+// default, for billing reconciliation.
+func (a *adminHandler) usageSessions(w http.ResponseWriter, r *http.Request) {
+	day := r.URL.Query().Get("day")
+	if day == "" {
+		day = a.sessions.usage.clock.Now().UTC().Format(usageDayLayout)
+	} else if _, err := time.Parse(usageDayLayout, day); err != nil {
+		http.Error(w, "day must be a date such as 2025-03-01", http.StatusBadRequest)
+		return
+	}
+	sessions, truncated := a.sessions.usage.sessions(day)
+	writeJSON(w, http.StatusOK, map[string]any{"day": day, "sessions": sessions, "truncated": truncated})
+}
@@ -0,0 +1,123 @@
+package session
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"encoding/json"
+
+	"net/http"
+
+	"net/http/httptest"
+
+	"strings"
+)
+
+func TestUsageCounterKeepsRecentDays(t *testing.T) {
+	now := time.Date(2025, 3, 1, 23, 0, 0, 0, time.UTC)
+	u := newUsageCounter()
+	u.clock = ClockFunc(func() time.Time { return now })
+	u.recordSession(usageSession{Tenant: ""})
+	u.recordSession(usageSession{Tenant: "acme"})
+	now = now.Add(2 * time.Hour)
+	u.recordSession(usageSession{Tenant: "acme"})
+
+	got := u.snapshot()
+	if got["2025-03-01"][""] != 1 || got["2025-03-01"]["acme"] != 1 || got["2025-03-02"]["acme"] != 1 {
+		t.Fatalf("unexpected counts: %v", got)
+	}
+
+	now = now.AddDate(0, 0, usageRetentionDays)
+	u.recordSession(usageSession{Tenant: "acme"})
+	if got := u.snapshot(); len(got) != 1 {
+		t.Fatalf("expected old days to be dropped, got %v", got)
+	}
+}
+
+func TestUsageCheckpointRoundTrip(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	clock := ClockFunc(func() time.Time { return now })
+	path := filepath.Join(t.TempDir(), "usage.json")
+	newCheckpointer := func() *usageCheckpointer {
+		c := &usageCheckpointer{path: path, usage: newUsageCounter(), quota: newQuotaTracker(5, 80, time.Hour), clock: clock}
+		c.usage.clock, c.quota.clock = clock, clock
+		acme := &tenant{name: "acme", quota: c.quota.clone()}
+		c.tenants = &tenantRegistry{tenants: []*tenant{acme}}
+		return c
+	}
+
+	before := newCheckpointer()
+	if err := before.restore(); err != nil {
+		t.Fatalf("expected a missing checkpoint to be ignored, got %v", err)
+	}
+	before.usage.recordSession(usageSession{Tenant: "acme", SessionID: "cksess_1", OpenAIRequestID: "req_1"})
+	before.quota.reserve("u")
+	before.tenants.tenants[0].quota.reserve("v")
+	before.tenants.tenants[0].quota.reserve("v")
+	if err := before.save(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	after := newCheckpointer()
+	if err := after.restore(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := after.usage.snapshot()["2025-03-01"]["acme"]; got != 1 {
+		t.Fatalf("expected the session count to survive, got %d", got)
+	}
+	if sessions, _ := after.usage.sessions("2025-03-01"); len(sessions) != 1 || sessions[0].OpenAIRequestID != "req_1" || !sessions[0].CreatedAt.Equal(now) {
+		t.Fatalf("expected the session ledger to survive, got %+v", sessions)
+	}
+	if got := after.quota.peek("u").used; got != 1 {
+		t.Fatalf("expected quota usage to survive, got %d", got)
+	}
+	if got := after.tenants.tenants[0].quota.peek("v").used; got != 2 {
+		t.Fatalf("expected tenant quota usage to survive, got %d", got)
+	}
+}
+
+func TestAdminUsageSessionsExportsLedger(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	sessions := newSessionHandler((&fakeSessionCreator{clientSecret: "secret"}).Create, "w", 1200, 10)
+	sessions.usage = newUsageCounter()
+	sessions.usage.clock = ClockFunc(func() time.Time { return now })
+	sessions.usage.recordSession(usageSession{SessionID: "cksess_1", OpenAIRequestID: "req_1", Tenant: "acme", WorkflowID: "w"})
+	sessions.usage.recordSession(usageSession{Tenant: "acme"})
+	admin := newAdminHandler("s3cret", nil, newConfigDriftDetector(nil, ""))
+	admin.sessions = sessions
+	admin.lookups = newLookupGuard(3, 0)
+	router, err := newRouter(sessions, admin, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected router error: %v", err)
+	}
+	get := func(target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set("Authorization", "Bearer s3cret")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get("/admin/usage/sessions")
+	var resp struct {
+		Day      string         `json:"day"`
+		Sessions []usageSession `json:"sessions"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("unexpected response %d %s", rec.Code, rec.Body)
+	}
+	if resp.Day != "2025-03-01" || len(resp.Sessions) != 1 || resp.Sessions[0].SessionID != "cksess_1" || resp.Sessions[0].OpenAIRequestID != "req_1" {
+		t.Fatalf("unexpected ledger: %+v", resp)
+	}
+	if rec := get("/admin/usage/sessions?day=2025-02-28"); !strings.Contains(rec.Body.String(), `"sessions":[]`) {
+		t.Fatalf("expected an empty ledger for another day, got %s", rec.Body)
+	}
+	if rec := get("/admin/usage/sessions?day=yesterday"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected an invalid day to be rejected, got %d", rec.Code)
+	}
+	if rec := get("/admin/usage/sessions"); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected exports to spend the lookup budget, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,44 @@
+package session
+
+import (
+	"context"
+	"hash/fnv"
+)
+
+const defaultUserLockStripes = 256
+
+// userLocker serializes session operations per user, so that concurrent
+// requests for one user cannot interleave quota reservations, upstream
+// calls, and refunds. Like jobs.Locker, a multi-replica deployment should back
+// it with shared infrastructure such as Redis.
+type userLocker interface {
+	// Lock blocks until the caller holds user's lock or ctx is done.
+	Lock(ctx context.Context, user string) (release func(), err error)
+}
+
+// stripedUserLocker is the single-replica userLocker. Users hash onto a
+// fixed set of stripes, so memory stays bounded however many users there are;
+// two users sharing a stripe merely wait for each other.
+type stripedUserLocker struct {
+	stripes []chan struct{}
+}
+
+func newStripedUserLocker(stripes int) *stripedUserLocker {
+	l := &stripedUserLocker{stripes: make([]chan struct{}, stripes)}
+	for i := range l.stripes {
+		l.stripes[i] = make(chan struct{}, 1)
+	}
+	return l
+}
+
+func (l *stripedUserLocker) Lock(ctx context.Context, user string) (func(), error) {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(user))
+	stripe := l.stripes[h.Sum32()%uint32(len(l.stripes))]
+	select {
+	case stripe <- struct{}{}:
+		return func() { <-stripe }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,37 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStripedUserLockerSerializesUser(t *testing.T) {
+	l := newStripedUserLocker(8)
+	release, err := l.Lock(context.Background(), "alice")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := l.Lock(ctx, "alice"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected a second lock for the same user to wait, got %v", err)
+	}
+
+	acquired := make(chan struct{})
+	go func() {
+		release, err := l.Lock(context.Background(), "alice")
+		if err == nil {
+			release()
+		}
+		close(acquired)
+	}()
+	release()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("expected the waiting request to acquire the lock after release")
+	}
+}
@@ -0,0 +1,130 @@
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+const (
+	validationCodeInvalidJSON  = "invalid_json"
+	validationCodeUnknownField = "unknown_field"
+	validationCodeInvalidType  = "invalid_type"
+	validationCodeRequired     = "required"
+	validationCodeInvalidValue = "invalid_value"
+)
+
+// fieldError describes one problem with a request payload. Field is empty for
+// problems with the payload as a whole.
+type fieldError struct {
+	Field string `json:"field,omitempty"`
+	// Pointer is the JSON pointer to the offending body value, set by
+	// OpenAPI request validation.
+	Pointer string `json:"pointer,omitempty"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type validationErrorResponse struct {
+	Error     string       `json:"error"`
+	Fields    []fieldError `json:"fields"`
+	RequestID string       `json:"request_id,omitempty"`
+}
+
+func writeValidationErrors(w http.ResponseWriter, problems []fieldError) {
+	writeJSON(w, http.StatusBadRequest, validationErrorResponse{Error: "invalid_request", Fields: problems, RequestID: responseRequestID(w)})
+}
+
+func hasFieldError(problems []fieldError, field string) bool {
+	for _, p := range problems {
+		if p.Field == field {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeJSONObject decodes a JSON object from r into the struct pointed to by
+// dst. Rather than stopping at the first problem it reports every unknown
+// field and every field with the wrong type. A non-nil error means the body
+// could not be read at all (for example it exceeded the size limit).
+func decodeJSONObject(r io.Reader, dst any) ([]fieldError, error) {
+	var raw map[string]json.RawMessage
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return nil, err
+		}
+		return []fieldError{{Code: validationCodeInvalidJSON, Message: "request body must be valid JSON"}}, nil
+	}
+	if raw == nil {
+		return []fieldError{{Code: validationCodeInvalidJSON, Message: "request body must be a JSON object"}}, nil
+	}
+
+	v := reflect.ValueOf(dst).Elem()
+	fields := jsonFieldIndex(v.Type())
+
+	keys := make([]string, 0, len(raw))
+	for k := range raw {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var problems []fieldError
+	for _, key := range keys {
+		idx, ok := fields[key]
+		if !ok {
+			problems = append(problems, fieldError{Field: key, Code: validationCodeUnknownField, Message: fmt.Sprintf("unknown field %q", key)})
+			continue
+		}
+		field := v.Field(idx)
+		if err := json.Unmarshal(raw[key], field.Addr().Interface()); err != nil {
+			problems = append(problems, fieldError{Field: key, Code: validationCodeInvalidType, Message: fmt.Sprintf("%s must be %s", key, jsonTypeName(field.Type()))})
+		}
+	}
+	return problems, nil
+}
+
+// jsonFieldIndex maps JSON field names to struct field indexes.
+func jsonFieldIndex(t reflect.Type) map[string]int {
+	fields := make(map[string]int, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[name] = i
+	}
+	return fields
+}
+
+func jsonTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Pointer:
+		return jsonTypeName(t.Elem())
+	default:
+		return "an object"
+	}
+}
@@ -0,0 +1,80 @@
+package session
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHandleSessionReportsEveryFieldProblem(t *testing.T) {
+	handler := newSessionHandler(nil, "w", 1200, 10)
+
+	tests := []struct {
+		name string
+		body string
+		want []fieldError
+	}{
+		{
+			name: "unknown fields and missing user",
+			body: `{"foo":1,"bar":true}`,
+			want: []fieldError{
+				{Field: "bar", Code: validationCodeUnknownField, Message: `unknown field "bar"`},
+				{Field: "foo", Code: validationCodeUnknownField, Message: `unknown field "foo"`},
+				{Field: "user", Code: validationCodeRequired, Message: "user is required"},
+			},
+		},
+		{
+			name: "wrong type",
+			body: `{"user":42}`,
+			want: []fieldError{
+				{Field: "user", Code: validationCodeInvalidType, Message: "user must be a string"},
+			},
+		},
+		{
+			name: "malformed JSON",
+			body: `{"user":`,
+			want: []fieldError{
+				{Code: validationCodeInvalidJSON, Message: "request body must be valid JSON"},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/chatkit/session", strings.NewReader(tc.body))
+			rec := httptest.NewRecorder()
+
+			handler.handleSession(rec, req)
+
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("expected status 400, got %d", rec.Code)
+			}
+			var resp validationErrorResponse
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if resp.Error != "invalid_request" {
+				t.Fatalf("unexpected error code: %s", resp.Error)
+			}
+			if !reflect.DeepEqual(resp.Fields, tc.want) {
+				t.Fatalf("unexpected field errors:\n got %+v\nwant %+v", resp.Fields, tc.want)
+			}
+		})
+	}
+}
+
+func TestHandleSessionRejectsOversizedBody(t *testing.T) {
+	handler := newSessionHandler(nil, "w", 1200, 10)
+	body := `{"user":"` + strings.Repeat("a", maxRequestBodyBytes) + `"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/chatkit/session", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+
+	handler.handleSession(rec, req)
+
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected status 413, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,117 @@
+package session
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	warmupInitialBackoff = 500 * time.Millisecond
+	warmupMaxBackoff     = 30 * time.Second
+	warmupRetryAfter     = "5"
+)
+
+type warmupCheck struct {
+	name string
+	run  func(context.Context) error
+}
+
+// warmupGate holds back traffic on gated routes until every dependency check
+// has succeeded once, so a fresh deploy does not turn into a burst of
+// failures.
+type warmupGate struct {
+	checks []warmupCheck
+	ready  atomic.Bool
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// newWarmupGate returns a gate that is open immediately when there are no
+// checks.
+func newWarmupGate(checks ...warmupCheck) *warmupGate {
+	g := &warmupGate{checks: checks}
+	if len(checks) == 0 {
+		g.ready.Store(true)
+	}
+	return g
+}
+
+// start runs the checks in the background, retrying failures with
+// exponential backoff until they all pass.
+func (g *warmupGate) start(context.Context) error {
+	if g.ready.Load() {
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	g.cancel = cancel
+	g.done = make(chan struct{})
+	go func() {
+		defer close(g.done)
+		g.run(ctx)
+	}()
+	return nil
+}
+
+func (g *warmupGate) run(ctx context.Context) {
+	pending := g.checks
+	backoff := warmupInitialBackoff
+	for {
+		var failed []warmupCheck
+		for _, c := range pending {
+			checkCtx, cancel := context.WithTimeout(ctx, openaiRequestTimeout)
+			err := c.run(checkCtx)
+			cancel()
+			if err != nil {
+				slog.Warn("warm-up check failed", "check", c.name, "error", err)
+				failed = append(failed, c)
+				continue
+			}
+			slog.Debug("warm-up check passed", "check", c.name)
+		}
+		if len(failed) == 0 {
+			g.ready.Store(true)
+			slog.Info("warm-up complete; accepting session traffic")
+			return
+		}
+		pending = failed
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > warmupMaxBackoff {
+			backoff = warmupMaxBackoff
+		}
+	}
+}
+
+func (g *warmupGate) stop(ctx context.Context) error {
+	if g.cancel == nil {
+		return nil
+	}
+	g.cancel()
+	select {
+	case <-g.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// require answers 503 until warm-up has completed.
+func (g *warmupGate) require(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !g.ready.Load() {
+			w.Header().Set("Retry-After", warmupRetryAfter)
+			http.Error(w, "service warming up", http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
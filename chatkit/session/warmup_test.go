@@ -0,0 +1,56 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWarmupGateBlocksSessionsUntilChecksPass(t *testing.T) {
+	var attempts atomic.Int32
+	gate := newWarmupGate(warmupCheck{name: "openai", run: func(ctx context.Context) error {
+		if attempts.Add(1) < 2 {
+			return errors.New("not yet")
+		}
+		return nil
+	}})
+	fake := &fakeSessionCreator{clientSecret: "secret"}
+	router, err := newRouter(newSessionHandler(fake.Create, "w", 1200, 10), nil, gate, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected router error: %v", err)
+	}
+
+	send := func(method, target, body string) int {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return rec.Code
+	}
+
+	if code := send(http.MethodPost, "/api/chatkit/session", `{"user":"u"}`); code != http.StatusServiceUnavailable {
+		t.Fatalf("expected status 503 before warm-up, got %d", code)
+	}
+	if code := send(http.MethodGet, "/livez", ""); code != http.StatusOK {
+		t.Fatalf("expected livez 200 during warm-up, got %d", code)
+	}
+
+	if err := gate.start(context.Background()); err != nil {
+		t.Fatalf("unexpected start error: %v", err)
+	}
+	defer gate.stop(context.Background())
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !gate.ready.Load() {
+		if time.Now().After(deadline) {
+			t.Fatalf("warm-up did not complete")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if code := send(http.MethodPost, "/api/chatkit/session", `{"user":"u"}`); code != http.StatusOK {
+		t.Fatalf("expected status 200 after warm-up, got %d", code)
+	}
+}
@@ -0,0 +1,177 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const workflowHealthUser = "chatkit-backend-healthcheck"
+
+// workflowRef identifies a ChatKit workflow and, optionally, a pinned
+// version.
+type workflowRef struct {
+	ID      string `json:"workflow_id"`
+	Version string `json:"version,omitempty"`
+}
+
+// parseWorkflowAliases parses a comma-separated list of alias=workflow_id
+// entries, each optionally pinned with @version, e.g.
+// "support=wf_abc@3,sales=wf_def".
+func parseWorkflowAliases(spec string) (map[string]workflowRef, error) {
+	aliases := make(map[string]workflowRef)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		alias, target, ok := strings.Cut(entry, "=")
+		alias = strings.TrimSpace(alias)
+		target = strings.TrimSpace(target)
+		if !ok || alias == "" || target == "" {
+			return nil, fmt.Errorf("invalid workflow alias %q: expected alias=workflow_id[@version]", entry)
+		}
+		if alias == "default" || alias == "sandbox" {
+			return nil, fmt.Errorf("workflow alias %q is reserved", alias)
+		}
+		if _, dup := aliases[alias]; dup {
+			return nil, fmt.Errorf("duplicate workflow alias %q", alias)
+		}
+		id, version, _ := strings.Cut(target, "@")
+		aliases[alias] = workflowRef{ID: id, Version: version}
+	}
+	return aliases, nil
+}
+
+// parseAllowedWorkflowIDs parses a comma-separated list of workflow IDs that
+// clients may select per request.
+func parseAllowedWorkflowIDs(spec string) map[string]bool {
+	allowed := make(map[string]bool)
+	for _, id := range strings.Split(spec, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			allowed[id] = true
+		}
+	}
+	return allowed
+}
+
+// workflowProbe checks that a workflow can be resolved upstream.
+type workflowProbe func(context.Context, workflowRef) error
+
+type workflowHealthEntry struct {
+	Alias string `json:"alias"`
+	workflowRef
+	Resolvable bool   `json:"resolvable"`
+	Error      string `json:"error,omitempty"`
+}
+
+type workflowHealthReport struct {
+	Healthy   bool                  `json:"healthy"`
+	Workflows []workflowHealthEntry `json:"workflows"`
+}
+
+// workflowHealth reports whether every configured workflow is still
+// resolvable, catching deleted or unpublished workflows before users do.
+type workflowHealth struct {
+	workflows map[string]workflowRef
+	probe     workflowProbe
+}
+
+func newWorkflowHealth(workflows map[string]workflowRef, probe workflowProbe) *workflowHealth {
+	return &workflowHealth{workflows: workflows, probe: probe}
+}
+
+// check probes every workflow concurrently, each bounded by
+// openaiRequestTimeout.
+func (h *workflowHealth) check(ctx context.Context) workflowHealthReport {
+	aliases := make([]string, 0, len(h.workflows))
+	for alias := range h.workflows {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+
+	entries := make([]workflowHealthEntry, len(aliases))
+	var wg sync.WaitGroup
+	for i, alias := range aliases {
+		wg.Add(1)
+		go func(i int, alias string) {
+			defer wg.Done()
+			ref := h.workflows[alias]
+			probeCtx, cancel := context.WithTimeout(ctx, openaiRequestTimeout)
+			defer cancel()
+			entry := workflowHealthEntry{Alias: alias, workflowRef: ref, Resolvable: true}
+			if err := h.probe(probeCtx, ref); err != nil {
+				entry.Resolvable = false
+				entry.Error = describeUpstreamError(err).Message
+			}
+			entries[i] = entry
+		}(i, alias)
+	}
+	wg.Wait()
+
+	report := workflowHealthReport{Healthy: true, Workflows: entries}
+	for _, e := range entries {
+		if !e.Resolvable {
+			report.Healthy = false
+		}
+	}
+	return report
+}
+
+// defaultWorkflowCacheTTL is how long a successful workflow lookup is
+// remembered.
+const defaultWorkflowCacheTTL = 5 * time.Minute
+
+// negativeWorkflowCacheTTL caps how long a definitive "not resolvable"
+// result is cached, so that a fixed workflow is picked up quickly.
+const negativeWorkflowCacheTTL = time.Minute
+
+type workflowCacheEntry struct {
+	err     error
+	expires time.Time
+}
+
+// workflowCache remembers workflow lookups so that repeated validation of
+// the same workflow does not hit OpenAI every time. Only definitive answers
+// are cached: successes for ttl and client errors (such as an unknown
+// workflow) for at most negativeWorkflowCacheTTL. Transient failures are
+// never cached.
+type workflowCache struct {
+	probe workflowProbe
+	ttl   time.Duration
+	clock Clock
+
+	mu      sync.Mutex
+	entries map[workflowRef]workflowCacheEntry
+}
+
+func newWorkflowCache(probe workflowProbe, ttl time.Duration) *workflowCache {
+	return &workflowCache{probe: probe, ttl: ttl, clock: SystemClock, entries: make(map[workflowRef]workflowCacheEntry)}
+}
+
+// check has the signature of a workflowProbe and can be used in its place.
+func (c *workflowCache) check(ctx context.Context, ref workflowRef) error {
+	c.mu.Lock()
+	entry, ok := c.entries[ref]
+	c.mu.Unlock()
+	if ok && c.clock.Now().Before(entry.expires) {
+		return entry.err
+	}
+
+	err := c.probe(ctx, ref)
+	ttl := c.ttl
+	switch {
+	case err == nil:
+	case isUpstreamFailure(err), ctx.Err() != nil:
+		return err
+	default:
+		ttl = min(ttl, negativeWorkflowCacheTTL)
+	}
+	c.mu.Lock()
+	c.entries[ref] = workflowCacheEntry{err: err, expires: c.clock.Now().Add(ttl)}
+	c.mu.Unlock()
+	return err
+}
@@ -0,0 +1,106 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseWorkflowAliases(t *testing.T) {
+	aliases, err := parseWorkflowAliases("support=wf_abc@3, sales=wf_def")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if aliases["support"] != (workflowRef{ID: "wf_abc", Version: "3"}) || aliases["sales"] != (workflowRef{ID: "wf_def"}) {
+		t.Fatalf("unexpected aliases: %+v", aliases)
+	}
+
+	for _, spec := range []string{"support", "a=wf,a=wf2", "default=wf", "=wf"} {
+		if _, err := parseWorkflowAliases(spec); err == nil {
+			t.Fatalf("expected error for %q", spec)
+		}
+	}
+}
+
+func TestAdminWorkflowHealthReport(t *testing.T) {
+	bans, _ := newBanList("")
+	admin := newAdminHandler("s3cret", bans, newConfigDriftDetector(nil, ""))
+	admin.workflows = newWorkflowHealth(map[string]workflowRef{
+		"default": {ID: "wf_ok"},
+		"legacy":  {ID: "wf_deleted", Version: "2"},
+	}, func(ctx context.Context, ref workflowRef) error {
+		if ref.ID == "wf_deleted" {
+			return errors.New("workflow not found")
+		}
+		return nil
+	})
+	router, err := newRouter(newSessionHandler(nil, "w", 1200, 10), admin, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected router error: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/workflows/health", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected status 503, got %d", rec.Code)
+	}
+	var report workflowHealthReport
+	if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+		t.Fatalf("failed to decode report: %v", err)
+	}
+	if report.Healthy || len(report.Workflows) != 2 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	if report.Workflows[0].Alias != "default" || !report.Workflows[0].Resolvable {
+		t.Fatalf("expected default workflow to be resolvable: %+v", report.Workflows[0])
+	}
+	if legacy := report.Workflows[1]; legacy.Resolvable || legacy.Version != "2" || legacy.Error == "" {
+		t.Fatalf("expected legacy workflow failure: %+v", legacy)
+	}
+}
+
+func TestWorkflowCache(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	calls := map[string]int{}
+	results := map[string]error{
+		"wf_ok":      nil,
+		"wf_missing": upstreamStatusError(http.StatusNotFound),
+		"wf_flaky":   upstreamStatusError(http.StatusBadGateway),
+	}
+	cache := newWorkflowCache(func(ctx context.Context, ref workflowRef) error {
+		calls[ref.ID]++
+		return results[ref.ID]
+	}, 5*time.Minute)
+	cache.clock = ClockFunc(func() time.Time { return now })
+
+	for i := 0; i < 3; i++ {
+		for id := range results {
+			_ = cache.check(context.Background(), workflowRef{ID: id})
+		}
+	}
+	if calls["wf_ok"] != 1 || calls["wf_missing"] != 1 || calls["wf_flaky"] != 3 {
+		t.Fatalf("unexpected probe calls: %v", calls)
+	}
+	if err := cache.check(context.Background(), workflowRef{ID: "wf_missing"}); err == nil {
+		t.Fatalf("expected cached error for missing workflow")
+	}
+
+	now = now.Add(2 * time.Minute)
+	_ = cache.check(context.Background(), workflowRef{ID: "wf_ok"})
+	_ = cache.check(context.Background(), workflowRef{ID: "wf_missing"})
+	if calls["wf_ok"] != 1 || calls["wf_missing"] != 2 {
+		t.Fatalf("expected negative entry to expire first: %v", calls)
+	}
+
+	_ = cache.check(context.Background(), workflowRef{ID: "wf_ok", Version: "2"})
+	if calls["wf_ok"] != 2 {
+		t.Fatalf("expected versions to be cached separately: %v", calls)
+	}
+}
@@ -0,0 +1,188 @@
+package session
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+)
+
+// stateSchema is the subset of JSON Schema used to describe the state
+// variables a workflow accepts. The top-level schema is an object whose
+// properties are strings, since every state variable is sent as a string:
+//
+//	{
+//	  "type": "object",
+//	  "required": ["plan"],
+//	  "additionalProperties": false,
+//	  "properties": {
+//	    "plan": {"type": "string", "enum": ["free", "pro"]},
+//	    "locale": {"type": "string", "pattern": "^[a-z]{2}(-[A-Z]{2})?$"}
+//	  }
+//	}
+//
+// Unsupported keywords are rejected when the schema is loaded rather than
+// silently ignored.
+type stateSchema struct {
+	Schema      string `json:"$schema,omitempty"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	Type        string `json:"type,omitempty"`
+
+	Properties           map[string]*stateSchema `json:"properties,omitempty"`
+	Required             []string                `json:"required,omitempty"`
+	AdditionalProperties *bool                   `json:"additionalProperties,omitempty"`
+
+	Enum      []string `json:"enum,omitempty"`
+	Pattern   string   `json:"pattern,omitempty"`
+	MinLength *int     `json:"minLength,omitempty"`
+	MaxLength *int     `json:"maxLength,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+// compile checks that s is a supported object schema and compiles its
+// property patterns.
+func (s *stateSchema) compile() error {
+	if s.Type != "" && s.Type != "object" {
+		return fmt.Errorf(`type must be "object", got %q`, s.Type)
+	}
+	if s.Enum != nil || s.Pattern != "" || s.MinLength != nil || s.MaxLength != nil {
+		return errors.New("string keywords are only supported on properties")
+	}
+	for name, prop := range s.Properties {
+		if prop == nil {
+			return fmt.Errorf("property %q: schema must be an object", name)
+		}
+		if prop.Type != "" && prop.Type != "string" {
+			return fmt.Errorf(`property %q: type must be "string", got %q`, name, prop.Type)
+		}
+		if prop.Properties != nil || prop.Required != nil || prop.AdditionalProperties != nil {
+			return fmt.Errorf("property %q: object keywords are not supported on state variables", name)
+		}
+		if prop.Pattern != "" {
+			re, err := regexp.Compile(prop.Pattern)
+			if err != nil {
+				return fmt.Errorf("property %q: invalid pattern: %w", name, err)
+			}
+			prop.pattern = re
+		}
+	}
+	return nil
+}
+
+// validate reports every way state fails the schema. Problems are reported
+// against the field state.<name>.
+func (s *stateSchema) validate(state map[string]string) []fieldError {
+	var problems []fieldError
+	for _, name := range s.Required {
+		if _, ok := state[name]; !ok {
+			problems = append(problems, fieldError{Field: "state." + name, Code: validationCodeRequired, Message: fmt.Sprintf("state variable %q is required", name)})
+		}
+	}
+
+	names := make([]string, 0, len(state))
+	for name := range state {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		field := "state." + name
+		prop, ok := s.Properties[name]
+		if !ok {
+			if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+				problems = append(problems, fieldError{Field: field, Code: validationCodeUnknownField, Message: fmt.Sprintf("state variable %q is not accepted by this workflow", name)})
+			}
+			continue
+		}
+		if msg := prop.check(state[name]); msg != "" {
+			problems = append(problems, fieldError{Field: field, Code: validationCodeInvalidValue, Message: fmt.Sprintf("state variable %q %s", name, msg)})
+		}
+	}
+	return problems
+}
+
+// check returns why value fails the property schema, or "" when it passes.
+func (s *stateSchema) check(value string) string {
+	length := len([]rune(value))
+	switch {
+	case s.MinLength != nil && length < *s.MinLength:
+		return fmt.Sprintf("must be at least %d characters", *s.MinLength)
+	case s.MaxLength != nil && length > *s.MaxLength:
+		return fmt.Sprintf("must be at most %d characters", *s.MaxLength)
+	case s.pattern != nil && !s.pattern.MatchString(value):
+		return fmt.Sprintf("must match %s", s.Pattern)
+	}
+	if s.Enum != nil {
+		for _, allowed := range s.Enum {
+			if value == allowed {
+				return ""
+			}
+		}
+		return fmt.Sprintf("must be one of %q", s.Enum)
+	}
+	return ""
+}
+
+// workflowSchemas maps workflow IDs to the schema their state variables must
+// satisfy. Workflows without a schema accept any state.
+type workflowSchemas map[string]*stateSchema
+
+// loadWorkflowSchemas reads a JSON object mapping workflow aliases to state
+// schemas. The aliases "default" and "sandbox" name the default and sandbox
+// workflows. An empty path disables validation.
+func loadWorkflowSchemas(path string, workflows map[string]workflowRef) (workflowSchemas, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	aliases := make([]string, 0, len(raw))
+	for alias := range raw {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+
+	schemas := make(workflowSchemas, len(raw))
+	owners := make(map[string]string, len(raw))
+	for _, alias := range aliases {
+		ref, ok := workflows[alias]
+		if !ok {
+			return nil, fmt.Errorf("%s: unknown workflow alias %q", path, alias)
+		}
+		if owner, dup := owners[ref.ID]; dup {
+			return nil, fmt.Errorf("%s: aliases %q and %q both set a schema for workflow %s", path, owner, alias, ref.ID)
+		}
+		dec := json.NewDecoder(bytes.NewReader(raw[alias]))
+		dec.DisallowUnknownFields()
+		schema := &stateSchema{}
+		if err := dec.Decode(schema); err != nil {
+			return nil, fmt.Errorf("%s: workflow %q: %w", path, alias, err)
+		}
+		if err := schema.compile(); err != nil {
+			return nil, fmt.Errorf("%s: workflow %q: %w", path, alias, err)
+		}
+		schemas[ref.ID] = schema
+		owners[ref.ID] = alias
+	}
+	return schemas, nil
+}
+
+// validate checks state against the schema for workflowID, if it has one.
+func (s workflowSchemas) validate(workflowID string, state map[string]string) []fieldError {
+	schema, ok := s[workflowID]
+	if !ok {
+		return nil
+	}
+	return schema.validate(state)
+}
@@ -0,0 +1,119 @@
+package session
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testPlanSchema = `{
+	"support": {
+		"type": "object",
+		"required": ["plan"],
+		"additionalProperties": false,
+		"properties": {
+			"plan": {"type": "string", "enum": ["free", "pro"]},
+			"locale": {"type": "string", "pattern": "^[a-z]{2}$", "maxLength": 2}
+		}
+	}
+}`
+
+func writeSchemaFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "schemas.json")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write schema file: %v", err)
+	}
+	return path
+}
+
+func TestLoadWorkflowSchemasRejectsBadConfig(t *testing.T) {
+	workflows := map[string]workflowRef{"support": {ID: "wf_support"}, "default": {ID: "wf_default"}}
+	for name, content := range map[string]string{
+		"unknown alias":       `{"sales": {"type": "object"}}`,
+		"unsupported keyword": `{"support": {"type": "object", "minProperties": 1}}`,
+		"non-string property": `{"support": {"properties": {"age": {"type": "integer"}}}}`,
+		"bad pattern":         `{"support": {"properties": {"plan": {"pattern": "("}}}}`,
+		"not an object":       `{"support": {"type": "array"}}`,
+	} {
+		if _, err := loadWorkflowSchemas(writeSchemaFile(t, content), workflows); err == nil {
+			t.Fatalf("%s: expected error", name)
+		}
+	}
+
+	schemas, err := loadWorkflowSchemas("", workflows)
+	if err != nil || schemas != nil {
+		t.Fatalf("expected no schemas without a file, got %v, %v", schemas, err)
+	}
+}
+
+func TestWorkflowSchemasValidate(t *testing.T) {
+	schemas, err := loadWorkflowSchemas(writeSchemaFile(t, testPlanSchema), map[string]workflowRef{"support": {ID: "wf_support"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if problems := schemas.validate("wf_support", map[string]string{"plan": "pro", "locale": "en"}); len(problems) != 0 {
+		t.Fatalf("expected valid state, got %+v", problems)
+	}
+	if problems := schemas.validate("wf_other", map[string]string{"anything": "goes"}); len(problems) != 0 {
+		t.Fatalf("expected workflows without a schema to accept any state, got %+v", problems)
+	}
+
+	problems := schemas.validate("wf_support", map[string]string{"locale": "EN", "extra": "x"})
+	want := map[string]string{
+		"state.plan":   validationCodeRequired,
+		"state.locale": validationCodeInvalidValue,
+		"state.extra":  validationCodeUnknownField,
+	}
+	if len(problems) != len(want) {
+		t.Fatalf("expected %d problems, got %+v", len(want), problems)
+	}
+	for _, p := range problems {
+		if want[p.Field] != p.Code {
+			t.Fatalf("unexpected problem %+v", p)
+		}
+	}
+}
+
+func TestHandleSessionRejectsStateFailingSchema(t *testing.T) {
+	fake := &fakeSessionCreator{clientSecret: "secret"}
+	handler := newSessionHandler(fake.Create, "wf_support", 1200, 10)
+	handler.attribution, _ = newAttributionPolicy("X-Attribution-Plan=plan")
+	schemas, err := loadWorkflowSchemas(writeSchemaFile(t, testPlanSchema), map[string]workflowRef{"support": {ID: "wf_support"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	handler.schemas = schemas
+
+	req := httptest.NewRequest(http.MethodPost, "/api/chatkit/session", strings.NewReader(`{"user":"u"}`))
+	req.Header.Set("X-Attribution-Plan", "enterprise")
+	rec := httptest.NewRecorder()
+	handler.handleSession(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d", rec.Code)
+	}
+	if fake.called {
+		t.Fatalf("expected OpenAI not to be called")
+	}
+	var resp validationErrorResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(resp.Fields) != 1 || resp.Fields[0].Field != "state.plan" || resp.Fields[0].Code != validationCodeInvalidValue {
+		t.Fatalf("unexpected problems: %+v", resp.Fields)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/api/chatkit/session", strings.NewReader(`{"user":"u"}`))
+	req.Header.Set("X-Attribution-Plan", "pro")
+	rec = httptest.NewRecorder()
+	handler.handleSession(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,493 @@
+// Package webhooks delivers signed event notifications to a receiver, with
+// retries, batching, and an optional outbox on disk.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"openai-chatkit-backend/chatkit/clock"
+)
+
+const (
+	// SignatureHeader carries the signature of each delivery, and IDHeader
+	// the event ID of unbatched ones.
+	SignatureHeader = "X-Webhook-Signature"
+	IDHeader        = "X-Webhook-ID"
+
+	maxAttempts     = 8
+	initialBackoff  = time.Second
+	maxBackoff      = 5 * time.Minute
+	deliveryTimeout = 10 * time.Second
+	maxDeadLetters  = 1000
+	// MaxBatchSize is the largest BatchSize a Dispatcher accepts.
+	MaxBatchSize = 100
+	// maxConcurrentDeliveries bounds the requests in flight to the
+	// receiver, so a slow or dead receiver costs one delivery timeout per
+	// round rather than one per due batch.
+	maxConcurrentDeliveries = 4
+
+	// DefaultDeadLetterRetention is how long dead letters are kept unless
+	// configured otherwise.
+	DefaultDeadLetterRetention = 7 * 24 * time.Hour
+
+	contentTypeJSON = "application/json"
+)
+
+// Verification documents, inside every payload, how receivers should
+// verify the signature header.
+type Verification struct {
+	Header        string `json:"header"`
+	Format        string `json:"format"`
+	Algorithm     string `json:"algorithm"`
+	SignedContent string `json:"signed_content"`
+}
+
+var verificationDoc = Verification{
+	Header:        SignatureHeader,
+	Format:        "t=<unix seconds>,v1=<hex digest>",
+	Algorithm:     "HMAC-SHA256 keyed with the shared webhook secret",
+	SignedContent: "<t> + \".\" + <raw request body>; reject deliveries whose t is too old to prevent replays",
+}
+
+// Event is one notification, as the receiver sees it.
+type Event struct {
+	ID           string          `json:"id"`
+	Type         string          `json:"type"`
+	CreatedAt    time.Time       `json:"created_at"`
+	Data         json.RawMessage `json:"data"`
+	Verification Verification    `json:"verification"`
+}
+
+// Delivery is an event and the state of its delivery.
+type Delivery struct {
+	Event       Event     `json:"event"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"next_attempt"`
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+// Batch is the payload of a batched delivery.
+type Batch struct {
+	Events []Event `json:"events"`
+}
+
+type outboxState struct {
+	Pending     []*Delivery `json:"pending"`
+	DeadLetters []*Delivery `json:"dead_letters"`
+}
+
+// Dispatcher delivers signed events with at-least-once semantics.
+// Events wait in an outbox, optionally persisted to an append-only log on
+// disk, until the receiver acknowledges them with a 2xx; deliveries that
+// keep failing are moved to a dead-letter list after maxAttempts.
+// Enqueueing never touches the disk or the network: the delivery worker
+// logs new events and sends due ones, up to BatchSize per request and up to
+// maxConcurrentDeliveries requests at a time.
+type Dispatcher struct {
+	// BatchSize is the most events sent in one request, at most
+	// MaxBatchSize. With the default of 1 each event is sent on its own.
+	BatchSize int
+	// Redact, when set, rewrites the JSON data of each event before it is
+	// queued, so nothing it removes is stored or sent.
+	Redact func([]byte) ([]byte, error)
+
+	url    string
+	secret []byte
+	path   string
+	client *http.Client
+	clock  clock.Clock
+	rand   clock.Rand
+
+	mu     sync.Mutex
+	outbox outboxState
+	log    *outboxLog
+	// unsaved holds enqueued deliveries the log does not have yet.
+	unsaved []*Delivery
+	// sink tracks how recent deliveries went, for /admin/webhooks.
+	sink   SinkStatus
+	wake   chan struct{}
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewDispatcher loads any persisted outbox from path. An empty path
+// keeps the outbox in memory only.
+func NewDispatcher(url, secret, path string) (*Dispatcher, error) {
+	d := &Dispatcher{
+		BatchSize: 1,
+		url:       url,
+		secret:    []byte(secret),
+		path:      path,
+		client:    &http.Client{Timeout: deliveryTimeout},
+		clock:     clock.System,
+		rand:      clock.SystemRand,
+		wake:      make(chan struct{}, 1),
+	}
+	if path == "" {
+		return d, nil
+	}
+	d.log = &outboxLog{path: path}
+	outbox, err := d.log.load()
+	if err != nil {
+		return nil, err
+	}
+	d.outbox = outbox
+	return d, nil
+}
+
+// Enqueue adds an event to the outbox and wakes the delivery worker, which
+// logs it before the first delivery attempt.
+func (d *Dispatcher) Enqueue(eventType string, data any) {
+	payload, err := json.Marshal(data)
+	if err == nil && d.Redact != nil {
+		payload, err = d.Redact(payload)
+	}
+	if err != nil {
+		slog.Error("failed to encode webhook", "event", eventType, "error", err)
+		return
+	}
+	id, err := eventID()
+	if err != nil {
+		slog.Error("failed to generate webhook id", "error", err)
+		return
+	}
+	now := d.clock.Now()
+	delivery := &Delivery{
+		Event: Event{
+			ID:           id,
+			Type:         eventType,
+			CreatedAt:    now.UTC(),
+			Data:         payload,
+			Verification: verificationDoc,
+		},
+		NextAttempt: now,
+	}
+
+	d.mu.Lock()
+	d.outbox.Pending = append(d.outbox.Pending, delivery)
+	if d.log != nil {
+		d.unsaved = append(d.unsaved, delivery)
+	}
+	d.mu.Unlock()
+
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// SinkStatus reports the health of the webhook receiver, as seen by
+// recent deliveries. It is exposed to operators only: a sink outage must
+// never take instances out of rotation, since sessions do not depend on it.
+type SinkStatus struct {
+	Pending             int    `json:"pending"`
+	DeadLetters         int    `json:"dead_letters"`
+	ConsecutiveFailures int    `json:"consecutive_failures"`
+	LastDeliveredAt     string `json:"last_delivered_at,omitempty"`
+	LastFailedAt        string `json:"last_failed_at,omitempty"`
+	LastError           string `json:"last_error,omitempty"`
+}
+
+// Status returns the outbox depth and the outcome of recent deliveries.
+func (d *Dispatcher) Status() SinkStatus {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s := d.sink
+	s.Pending, s.DeadLetters = len(d.outbox.Pending), len(d.outbox.DeadLetters)
+	return s
+}
+
+// DeadLetters returns the deliveries that were given up on.
+func (d *Dispatcher) DeadLetters() []Delivery {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	out := make([]Delivery, 0, len(d.outbox.DeadLetters))
+	for _, dl := range d.outbox.DeadLetters {
+		out = append(out, *dl)
+	}
+	return out
+}
+
+// PruneDeadLetters drops dead letters for events created more than maxAge
+// ago and returns how many it dropped.
+func (d *Dispatcher) PruneDeadLetters(maxAge time.Duration) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	cutoff := d.clock.Now().Add(-maxAge)
+	kept := d.outbox.DeadLetters[:0]
+	for _, dl := range d.outbox.DeadLetters {
+		if dl.Event.CreatedAt.After(cutoff) {
+			kept = append(kept, dl)
+		} else {
+			d.logLocked(stateDone, dl)
+		}
+	}
+	dropped := len(d.outbox.DeadLetters) - len(kept)
+	d.outbox.DeadLetters = kept
+	if dropped == 0 {
+		return 0, nil
+	}
+	return dropped, d.flushLocked()
+}
+
+// Start runs the delivery worker until Stop.
+func (d *Dispatcher) Start(context.Context) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	d.cancel = cancel
+	d.done = make(chan struct{})
+	go func() {
+		defer close(d.done)
+		d.run(ctx)
+	}()
+	return nil
+}
+
+// Stop ends delivery. Undelivered events stay in the outbox and, when it is
+// logged, are retried after the next start.
+func (d *Dispatcher) Stop(ctx context.Context) error {
+	if d.cancel != nil {
+		d.cancel()
+		select {
+		case <-d.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	err := d.flushLocked()
+	if d.log != nil {
+		if cerr := d.log.close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+func (d *Dispatcher) run(ctx context.Context) {
+	for {
+		wait := d.deliverDue(ctx)
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-d.wake:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// deliverDue attempts every delivery whose retry time has come, in batches
+// of up to BatchSize, and returns how long to wait before the next one is
+// due.
+func (d *Dispatcher) deliverDue(ctx context.Context) time.Duration {
+	d.mu.Lock()
+	d.flushLocked()
+	now := d.clock.Now()
+	var due []*Delivery
+	for _, p := range d.outbox.Pending {
+		if !p.NextAttempt.After(now) {
+			due = append(due, p)
+		}
+	}
+	d.mu.Unlock()
+
+	size := max(d.BatchSize, 1)
+	sem := make(chan struct{}, maxConcurrentDeliveries)
+	var wg sync.WaitGroup
+	for start := 0; start < len(due) && ctx.Err() == nil; start += size {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(batch []*Delivery) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			d.deliverBatch(ctx, batch)
+		}(due[start:min(start+size, len(due))])
+	}
+	wg.Wait()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	wait := maxBackoff
+	now = d.clock.Now()
+	for _, p := range d.outbox.Pending {
+		if until := p.NextAttempt.Sub(now); until < wait {
+			wait = until
+		}
+	}
+	if wait < 0 {
+		wait = 0
+	}
+	return wait
+}
+
+// deliverBatch sends batch in one request and logs the outcome of each
+// delivery.
+func (d *Dispatcher) deliverBatch(ctx context.Context, batch []*Delivery) {
+	events := make([]Event, len(batch))
+	for i, p := range batch {
+		events[i] = p.Event
+	}
+	err := d.deliver(ctx, events)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err == nil {
+		d.sink.ConsecutiveFailures = 0
+		d.sink.LastDeliveredAt = d.clock.Now().UTC().Format(time.RFC3339)
+	} else {
+		d.sink.ConsecutiveFailures++
+		d.sink.LastFailedAt = d.clock.Now().UTC().Format(time.RFC3339)
+		d.sink.LastError = err.Error()
+	}
+	for _, p := range batch {
+		d.recordLocked(p, err)
+	}
+	d.flushLocked()
+}
+
+func (d *Dispatcher) recordLocked(p *Delivery, err error) {
+	p.Attempts++
+	if err == nil {
+		d.removePendingLocked(p)
+		d.logLocked(stateDone, p)
+		slog.Debug("webhook delivered", "event", p.Event.Type, "id", p.Event.ID, "attempts", p.Attempts)
+	} else {
+		p.LastError = err.Error()
+		if p.Attempts >= maxAttempts {
+			d.removePendingLocked(p)
+			d.outbox.DeadLetters = append(d.outbox.DeadLetters, p)
+			d.logLocked(stateDead, p)
+			if n := len(d.outbox.DeadLetters); n > maxDeadLetters {
+				for _, evicted := range d.outbox.DeadLetters[:n-maxDeadLetters] {
+					d.logLocked(stateDone, evicted)
+				}
+				d.outbox.DeadLetters = d.outbox.DeadLetters[n-maxDeadLetters:]
+			}
+			slog.Error("webhook dead-lettered", "event", p.Event.Type, "id", p.Event.ID, "attempts", p.Attempts, "error", err)
+		} else {
+			p.NextAttempt = d.clock.Now().Add(clock.Jitter(backoff(p.Attempts), d.rand))
+			d.logLocked(statePending, p)
+			slog.Warn("webhook delivery failed", "event", p.Event.Type, "id", p.Event.ID, "attempt", p.Attempts, "error", err)
+		}
+	}
+}
+
+func (d *Dispatcher) removePendingLocked(p *Delivery) {
+	for i, q := range d.outbox.Pending {
+		if q == p {
+			d.outbox.Pending = append(d.outbox.Pending[:i], d.outbox.Pending[i+1:]...)
+			return
+		}
+	}
+}
+
+// logLocked records the state of p in the log, if there is one. The entry
+// is written by the next flushLocked.
+func (d *Dispatcher) logLocked(state string, p *Delivery) {
+	if d.log != nil {
+		d.log.add(state, p)
+	}
+}
+
+// flushLocked writes deliveries enqueued since the last flush and the
+// logged state changes, compacting the log once it is mostly stale.
+func (d *Dispatcher) flushLocked() error {
+	if d.log == nil {
+		return nil
+	}
+	for _, p := range d.unsaved {
+		d.log.add(statePending, p)
+	}
+	d.unsaved = nil
+	err := d.log.flush()
+	if err == nil && d.log.stale(len(d.outbox.Pending)+len(d.outbox.DeadLetters)) {
+		err = d.log.compact(d.outbox)
+	}
+	if err != nil {
+		slog.Error("failed to persist webhook outbox", "error", err)
+	}
+	return err
+}
+
+// deliver sends events in one request. With a batch size of 1 the body is
+// the event itself; otherwise it is a Batch, and receivers
+// deduplicate by the ID of each event.
+func (d *Dispatcher) deliver(ctx context.Context, events []Event) error {
+	var body []byte
+	var err error
+	if d.BatchSize <= 1 {
+		body, err = json.Marshal(events[0])
+	} else {
+		body, err = json.Marshal(Batch{Events: events})
+	}
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentTypeJSON)
+	if d.BatchSize <= 1 {
+		req.Header.Set(IDHeader, events[0].ID)
+	}
+	req.Header.Set(SignatureHeader, Sign(d.secret, d.clock.Now(), body))
+
+	res, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 4096))
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("receiver answered %d", res.StatusCode)
+	}
+	return nil
+}
+
+// Sign returns the signature header value for body sent at t.
+func Sign(secret []byte, t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "t=" + ts + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func backoff(attempts int) time.Duration {
+	backoff := initialBackoff
+	for i := 1; i < attempts; i++ {
+		backoff *= 2
+		if backoff >= maxBackoff {
+			return maxBackoff
+		}
+	}
+	return backoff
+}
+
+func eventID() (string, error) {
+	buf := make([]byte, 12)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return "evt_" + hex.EncodeToString(buf), nil
+}
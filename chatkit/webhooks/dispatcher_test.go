@@ -0,0 +1,179 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"openai-chatkit-backend/chatkit/clock"
+)
+
+func TestDispatcherSignsDeliveries(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	var gotSignature, gotID string
+	var gotEvent Event
+	var body []byte
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotSignature = r.Header.Get(SignatureHeader)
+		gotID = r.Header.Get(IDHeader)
+		body, _ = io.ReadAll(r.Body)
+		_ = json.Unmarshal(body, &gotEvent)
+	}))
+	defer receiver.Close()
+
+	d, err := NewDispatcher(receiver.URL, "whsec", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	d.clock = clock.Func(func() time.Time { return now })
+
+	d.Enqueue("session.created", map[string]string{"session_id": "cksess_1", "user": "u"})
+	d.deliverDue(context.Background())
+
+	if gotEvent.Type != "session.created" || gotID != gotEvent.ID {
+		t.Fatalf("unexpected delivery id=%s event=%+v", gotID, gotEvent)
+	}
+	if gotEvent.Verification.Header != SignatureHeader {
+		t.Fatalf("expected verification docs in payload")
+	}
+	if want := Sign([]byte("whsec"), now, body); gotSignature != want {
+		t.Fatalf("unexpected signature %q, want %q", gotSignature, want)
+	}
+	if !strings.HasPrefix(gotSignature, "t=1700000000,v1=") {
+		t.Fatalf("unexpected signature format %q", gotSignature)
+	}
+	if len(d.outbox.Pending) != 0 {
+		t.Fatalf("expected outbox to be drained")
+	}
+}
+
+func TestDispatcherRetriesThenDeadLetters(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	var calls int
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer receiver.Close()
+
+	path := filepath.Join(t.TempDir(), "outbox.json")
+	d, err := NewDispatcher(receiver.URL, "whsec", path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	d.clock = clock.Func(func() time.Time { return now })
+	d.Enqueue("session.created", map[string]string{"session_id": "cksess_1"})
+
+	for i := 0; i < maxAttempts; i++ {
+		wait := d.deliverDue(context.Background())
+		now = now.Add(wait)
+	}
+	if calls != maxAttempts {
+		t.Fatalf("expected %d attempts, got %d", maxAttempts, calls)
+	}
+	dead := d.DeadLetters()
+	if len(dead) != 1 || dead[0].LastError == "" {
+		t.Fatalf("expected one dead letter with error, got %+v", dead)
+	}
+	if status := d.Status(); status.Pending != 0 || status.DeadLetters != 1 || status.ConsecutiveFailures != maxAttempts || status.LastError == "" || status.LastDeliveredAt != "" {
+		t.Fatalf("unexpected sink status: %+v", status)
+	}
+
+	reloaded, err := NewDispatcher(receiver.URL, "whsec", path)
+	if err != nil {
+		t.Fatalf("unexpected error reloading: %v", err)
+	}
+	if len(reloaded.DeadLetters()) != 1 {
+		t.Fatalf("expected dead letters to be persisted")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	if backoff(1) != time.Second || backoff(3) != 4*time.Second || backoff(20) != maxBackoff {
+		t.Fatalf("unexpected backoff schedule")
+	}
+}
+
+func TestDispatcherBatchesDueEvents(t *testing.T) {
+	var mu sync.Mutex
+	var sizes []int
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch Batch
+		_ = json.NewDecoder(r.Body).Decode(&batch)
+		mu.Lock()
+		sizes = append(sizes, len(batch.Events))
+		mu.Unlock()
+	}))
+	defer receiver.Close()
+
+	path := filepath.Join(t.TempDir(), "outbox.json")
+	d, err := NewDispatcher(receiver.URL, "whsec", path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	d.BatchSize = 2
+	for i := 0; i < 3; i++ {
+		d.Enqueue("session.created", map[string]string{"session_id": "cksess_1"})
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected enqueue to leave the outbox file to the worker, got %v", err)
+	}
+
+	d.deliverDue(context.Background())
+	// Batches go out concurrently, so they may arrive in either order.
+	sort.Ints(sizes)
+	if len(sizes) != 2 || sizes[0] != 1 || sizes[1] != 2 {
+		t.Fatalf("expected batches of 2 and 1 events, got sizes %v", sizes)
+	}
+	reloaded, err := NewDispatcher(receiver.URL, "whsec", path)
+	if err != nil {
+		t.Fatalf("unexpected error reloading: %v", err)
+	}
+	if len(reloaded.outbox.Pending) != 0 {
+		t.Fatalf("expected the persisted outbox to be drained, got %d pending", len(reloaded.outbox.Pending))
+	}
+}
+
+func TestDispatcherRetryScheduleIsDeterministic(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer receiver.Close()
+
+	d, err := NewDispatcher(receiver.URL, "whsec", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.clock = clock.Func(func() time.Time { return now })
+	d.rand = clock.RandFunc(func() float64 { return 0 })
+	d.Enqueue("session.created", map[string]string{"session_id": "cksess_1"})
+
+	if wait := d.deliverDue(context.Background()); wait != backoff(1)/2 {
+		t.Fatalf("expected retry after %s, got %s", backoff(1)/2, wait)
+	}
+}
+
+func TestDispatcherRedactsPayloads(t *testing.T) {
+	d, err := NewDispatcher("http://example.invalid", "whsec", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Redact = func(data []byte) ([]byte, error) {
+		return bytes.ReplaceAll(data, []byte("alice"), []byte("[redacted]")), nil
+	}
+	d.Enqueue("session.created", map[string]string{"user": "alice"})
+	if data := string(d.outbox.Pending[0].Event.Data); data != `{"user":"[redacted]"}` {
+		t.Fatalf("unexpected event data: %s", data)
+	}
+}
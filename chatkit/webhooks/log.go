@@ -0,0 +1,181 @@
+package webhooks
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+)
+
+// Delivery states in the webhook outbox log.
+const (
+	statePending = "pending"
+	stateDead    = "dead"
+	// stateDone marks a delivery that left the outbox: delivered, or
+	// a dead letter that was pruned.
+	stateDone = "done"
+)
+
+// logSlack is how many stale entries the log may hold beyond the
+// live ones before it is compacted.
+const logSlack = 1000
+
+// logEntry is one line of the outbox log: the latest state of one
+// delivery.
+type logEntry struct {
+	State    string    `json:"state"`
+	ID       string    `json:"id"`
+	Delivery *Delivery `json:"delivery,omitempty"`
+}
+
+// outboxLog persists the webhook outbox as an append-only file of JSON
+// lines, so each delivery attempt writes only the entries it changed rather
+// than the whole outbox. Replaying the file keeps the last state of each
+// delivery; once most lines are stale the file is rewritten with the live
+// deliveries only.
+type outboxLog struct {
+	path  string
+	file  *os.File
+	lines int
+	buf   bytes.Buffer
+}
+
+// load replays the log. It also reads the single JSON document that older
+// versions wrote, and rewrites either in the current form.
+func (l *outboxLog) load() (outboxState, error) {
+	var outbox outboxState
+	f, err := os.Open(l.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return outbox, nil
+	}
+	if err != nil {
+		return outbox, err
+	}
+	defer f.Close()
+
+	var order []string
+	latest := make(map[string]logEntry)
+	set := func(e logEntry) {
+		if _, seen := latest[e.ID]; !seen {
+			order = append(order, e.ID)
+		}
+		latest[e.ID] = e
+	}
+	dec := json.NewDecoder(f)
+	for {
+		var line struct {
+			logEntry
+			outboxState
+		}
+		err := dec.Decode(&line)
+		if err == io.EOF {
+			break
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			// The process stopped in the middle of a write; the entries
+			// before it are intact.
+			slog.Warn("ignoring truncated webhook outbox entry", "path", l.path)
+			break
+		}
+		if err != nil {
+			return outbox, fmt.Errorf("parse %s: %w", l.path, err)
+		}
+		for _, p := range line.Pending {
+			set(logEntry{State: statePending, ID: p.Event.ID, Delivery: p})
+		}
+		for _, p := range line.DeadLetters {
+			set(logEntry{State: stateDead, ID: p.Event.ID, Delivery: p})
+		}
+		if line.State != "" {
+			set(line.logEntry)
+		}
+	}
+	for _, id := range order {
+		e := latest[id]
+		switch {
+		case e.Delivery == nil:
+		case e.State == statePending:
+			outbox.Pending = append(outbox.Pending, e.Delivery)
+		case e.State == stateDead:
+			outbox.DeadLetters = append(outbox.DeadLetters, e.Delivery)
+		}
+	}
+	if n := len(outbox.DeadLetters); n > maxDeadLetters {
+		outbox.DeadLetters = outbox.DeadLetters[n-maxDeadLetters:]
+	}
+	return outbox, l.compact(outbox)
+}
+
+// add buffers an entry for the next flush.
+func (l *outboxLog) add(state string, p *Delivery) {
+	e := logEntry{State: state, ID: p.Event.ID}
+	if state != stateDone {
+		e.Delivery = p
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		slog.Error("failed to encode webhook outbox entry", "id", p.Event.ID, "error", err)
+		return
+	}
+	l.buf.Write(data)
+	l.buf.WriteByte('\n')
+	l.lines++
+}
+
+// flush appends the buffered entries to the file, creating it on first use.
+func (l *outboxLog) flush() error {
+	if l.buf.Len() == 0 {
+		return nil
+	}
+	if l.file == nil {
+		f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+		if err != nil {
+			return err
+		}
+		l.file = f
+	}
+	_, err := l.file.Write(l.buf.Bytes())
+	l.buf.Reset()
+	return err
+}
+
+// stale reports whether the file holds many more entries than the live
+// deliveries it describes.
+func (l *outboxLog) stale(live int) bool {
+	return l.lines > 2*live+logSlack
+}
+
+// compact replaces the file with one entry per delivery in outbox. It must
+// follow a flush, since buffered entries are discarded.
+func (l *outboxLog) compact(outbox outboxState) error {
+	l.buf.Reset()
+	l.lines = 0
+	for _, p := range outbox.Pending {
+		l.add(statePending, p)
+	}
+	for _, p := range outbox.DeadLetters {
+		l.add(stateDead, p)
+	}
+	data := bytes.Clone(l.buf.Bytes())
+	l.buf.Reset()
+	if err := l.close(); err != nil {
+		return err
+	}
+	tmp := l.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, l.path)
+}
+
+func (l *outboxLog) close() error {
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
@@ -0,0 +1,114 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLogAppendsOnlyChangedDeliveries(t *testing.T) {
+	fail := map[string]bool{}
+	var mu sync.Mutex
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if fail[r.Header.Get(IDHeader)] {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer receiver.Close()
+
+	path := filepath.Join(t.TempDir(), "outbox.jsonl")
+	d, err := NewDispatcher(receiver.URL, "whsec", path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		d.Enqueue("session.created", map[string]string{"session_id": "cksess_1"})
+	}
+	mu.Lock()
+	fail[d.outbox.Pending[0].Event.ID] = true
+	mu.Unlock()
+	d.deliverDue(context.Background())
+
+	// Three enqueues, one retry, and two deliveries.
+	data, _ := os.ReadFile(path)
+	if lines := bytes.Count(data, []byte("\n")); lines != 6 {
+		t.Fatalf("expected 6 log entries, got %d:\n%s", lines, data)
+	}
+	d.Enqueue("session.created", map[string]string{"session_id": "cksess_2"})
+	if err := d.Stop(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	reloaded, err := NewDispatcher(receiver.URL, "whsec", path)
+	if err != nil {
+		t.Fatalf("unexpected error reloading: %v", err)
+	}
+	if len(reloaded.outbox.Pending) != 2 || reloaded.outbox.Pending[0].Attempts != 1 || reloaded.outbox.Pending[1].Attempts != 0 {
+		t.Fatalf("expected the failed and the unsent event to be pending, got %+v", reloaded.outbox.Pending)
+	}
+	// Loading compacts the log to the live deliveries.
+	data, _ = os.ReadFile(path)
+	if lines := bytes.Count(data, []byte("\n")); lines != 2 {
+		t.Fatalf("expected a compacted log of 2 entries, got %d", lines)
+	}
+}
+
+func TestLogReadsSnapshotOutbox(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.json")
+	data, _ := json.Marshal(outboxState{
+		Pending:     []*Delivery{{Event: Event{ID: "evt_1"}}},
+		DeadLetters: []*Delivery{{Event: Event{ID: "evt_2"}, Attempts: maxAttempts}},
+	})
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	d, err := NewDispatcher("http://example.invalid", "whsec", path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(d.outbox.Pending) != 1 || d.outbox.Pending[0].Event.ID != "evt_1" || len(d.outbox.DeadLetters) != 1 {
+		t.Fatalf("unexpected outbox: %+v", d.outbox)
+	}
+}
+
+func TestDispatcherDeliversConcurrently(t *testing.T) {
+	var mu sync.Mutex
+	inFlight, peak := 0, 0
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		peak = max(peak, inFlight)
+		mu.Unlock()
+		time.Sleep(50 * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer receiver.Close()
+
+	d, err := NewDispatcher(receiver.URL, "whsec", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i := 0; i < 3*maxConcurrentDeliveries; i++ {
+		d.Enqueue("session.created", map[string]string{"session_id": "cksess_1"})
+	}
+	d.deliverDue(context.Background())
+
+	if peak != maxConcurrentDeliveries {
+		t.Fatalf("expected %d deliveries in flight at once, peaked at %d", maxConcurrentDeliveries, peak)
+	}
+	if status := d.Status(); status.Pending != 3*maxConcurrentDeliveries || status.ConsecutiveFailures != 3*maxConcurrentDeliveries {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+}
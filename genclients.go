@@ -0,0 +1,286 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"unicode"
+
+	"openai-chatkit-backend/openapi"
+)
+
+// clientTargets maps each supported language to its template and output
+// file.
+var clientTargets = map[string]struct {
+	template string
+	output   string
+}{
+	"typescript": {template: "typescript.tmpl", output: "typescript/client.ts"},
+	"python":     {template: "python.tmpl", output: "python/client.py"},
+}
+
+type apiSchema struct {
+	Ref         string               `json:"$ref"`
+	Type        string               `json:"type"`
+	Description string               `json:"description"`
+	Required    []string             `json:"required"`
+	Properties  map[string]apiSchema `json:"properties"`
+	Items       *apiSchema           `json:"items"`
+}
+
+type apiMediaTypes map[string]struct {
+	Schema apiSchema `json:"schema"`
+}
+
+type apiOperation struct {
+	OperationID string `json:"operationId"`
+	Summary     string `json:"summary"`
+	RequestBody *struct {
+		Content apiMediaTypes `json:"content"`
+	} `json:"requestBody"`
+	Responses map[string]struct {
+		Content apiMediaTypes `json:"content"`
+	} `json:"responses"`
+}
+
+type apiSpec struct {
+	Info struct {
+		Title   string `json:"title"`
+		Version string `json:"version"`
+	} `json:"info"`
+	Paths      map[string]map[string]apiOperation `json:"paths"`
+	Components struct {
+		Schemas map[string]apiSchema `json:"schemas"`
+	} `json:"components"`
+}
+
+type clientOperation struct {
+	Name         string
+	Method       string
+	Path         string
+	Summary      string
+	RequestType  string
+	ResponseType string
+}
+
+type clientField struct {
+	Name        string
+	Description string
+	Required    bool
+	Schema      apiSchema
+}
+
+type clientType struct {
+	Name   string
+	Fields []clientField
+}
+
+type clientModel struct {
+	Title      string
+	Version    string
+	Types      []clientType
+	Operations []clientOperation
+}
+
+// runGenClients implements the gen-clients subcommand and returns the
+// process exit code.
+func runGenClients(args []string, stderr io.Writer) int {
+	fs := flag.NewFlagSet("gen-clients", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	spec := fs.String("spec", "", "OpenAPI document to read: a file path or an http(s) URL of a running server's /openapi.json (default: the embedded document)")
+	out := fs.String("out", "clients", "output directory")
+	langs := fs.String("lang", "typescript,python", "comma-separated languages to generate")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	doc, err := loadOpenAPIDocument(*spec)
+	if err != nil {
+		fmt.Fprintf(stderr, "gen-clients: %v\n", err)
+		return 1
+	}
+	for _, lang := range strings.Split(*langs, ",") {
+		lang = strings.TrimSpace(lang)
+		if lang == "" {
+			continue
+		}
+		path, err := generateClient(doc, lang, *out)
+		if err != nil {
+			fmt.Fprintf(stderr, "gen-clients: %v\n", err)
+			return 1
+		}
+		fmt.Fprintf(stderr, "wrote %s\n", path)
+	}
+	return 0
+}
+
+func loadOpenAPIDocument(source string) ([]byte, error) {
+	switch {
+	case source == "":
+		return openapi.Document, nil
+	case strings.HasPrefix(source, "http://"), strings.HasPrefix(source, "https://"):
+		res, err := http.Get(source)
+		if err != nil {
+			return nil, err
+		}
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("fetch %s: status %d", source, res.StatusCode)
+		}
+		return io.ReadAll(res.Body)
+	default:
+		return os.ReadFile(source)
+	}
+}
+
+// generateClient renders the client for lang into outDir and returns the
+// path it wrote.
+func generateClient(doc []byte, lang, outDir string) (string, error) {
+	target, ok := clientTargets[lang]
+	if !ok {
+		return "", fmt.Errorf("unsupported language %q", lang)
+	}
+
+	var spec apiSpec
+	if err := json.Unmarshal(doc, &spec); err != nil {
+		return "", fmt.Errorf("parse OpenAPI document: %w", err)
+	}
+	tmpl, err := template.New(target.template).Funcs(template.FuncMap{
+		"tsType": tsType,
+		"pyType": pyType,
+		"snake":  snakeCase,
+	}).ParseFS(openapi.Templates, "templates/"+target.template)
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(outDir, target.output)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	if err := tmpl.Execute(f, buildClientModel(spec)); err != nil {
+		f.Close()
+		return "", fmt.Errorf("render %s client: %w", lang, err)
+	}
+	return path, f.Close()
+}
+
+func buildClientModel(spec apiSpec) clientModel {
+	model := clientModel{Title: spec.Info.Title, Version: spec.Info.Version}
+
+	names := make([]string, 0, len(spec.Components.Schemas))
+	for name := range spec.Components.Schemas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		schema := spec.Components.Schemas[name]
+		required := make(map[string]bool, len(schema.Required))
+		for _, r := range schema.Required {
+			required[r] = true
+		}
+		props := make([]string, 0, len(schema.Properties))
+		for p := range schema.Properties {
+			props = append(props, p)
+		}
+		sort.Strings(props)
+
+		t := clientType{Name: name}
+		for _, p := range props {
+			prop := schema.Properties[p]
+			t.Fields = append(t.Fields, clientField{Name: p, Description: prop.Description, Required: required[p], Schema: prop})
+		}
+		model.Types = append(model.Types, t)
+	}
+
+	paths := make([]string, 0, len(spec.Paths))
+	for path := range spec.Paths {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		methods := make([]string, 0, len(spec.Paths[path]))
+		for method := range spec.Paths[path] {
+			methods = append(methods, method)
+		}
+		sort.Strings(methods)
+		for _, method := range methods {
+			op := spec.Paths[path][method]
+			co := clientOperation{Name: op.OperationID, Method: strings.ToUpper(method), Path: path, Summary: op.Summary}
+			if op.RequestBody != nil {
+				co.RequestType = refName(op.RequestBody.Content[contentTypeJSON].Schema.Ref)
+			}
+			if res, ok := op.Responses["200"]; ok {
+				co.ResponseType = refName(res.Content[contentTypeJSON].Schema.Ref)
+			}
+			model.Operations = append(model.Operations, co)
+		}
+	}
+	return model
+}
+
+func refName(ref string) string {
+	return ref[strings.LastIndex(ref, "/")+1:]
+}
+
+func tsType(s apiSchema) string {
+	switch {
+	case s.Ref != "":
+		return refName(s.Ref)
+	case s.Type == "string":
+		return "string"
+	case s.Type == "integer", s.Type == "number":
+		return "number"
+	case s.Type == "boolean":
+		return "boolean"
+	case s.Type == "array" && s.Items != nil:
+		return tsType(*s.Items) + "[]"
+	default:
+		return "Record<string, unknown>"
+	}
+}
+
+func pyType(s apiSchema) string {
+	switch {
+	case s.Ref != "":
+		return refName(s.Ref)
+	case s.Type == "string":
+		return "str"
+	case s.Type == "integer":
+		return "int"
+	case s.Type == "number":
+		return "float"
+	case s.Type == "boolean":
+		return "bool"
+	case s.Type == "array" && s.Items != nil:
+		return "List[" + pyType(*s.Items) + "]"
+	default:
+		return "Dict[str, Any]"
+	}
+}
+
+// snakeCase converts a camelCase operation ID to snake_case.
+func snakeCase(s string) string {
+	var b strings.Builder
+	for i, r := range s {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunGenClientsWritesClients(t *testing.T) {
+	out := t.TempDir()
+	var stderr bytes.Buffer
+	if code := runGenClients([]string{"-out", out}, &stderr); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr.String())
+	}
+
+	ts, err := os.ReadFile(filepath.Join(out, "typescript", "client.ts"))
+	if err != nil {
+		t.Fatalf("missing TypeScript client: %v", err)
+	}
+	if !strings.Contains(string(ts), "createSession(body: SessionRequest): Promise<SessionResponse>") {
+		t.Fatalf("TypeScript client missing createSession:\n%s", ts)
+	}
+
+	py, err := os.ReadFile(filepath.Join(out, "python", "client.py"))
+	if err != nil {
+		t.Fatalf("missing Python client: %v", err)
+	}
+	if !strings.Contains(string(py), "def create_session(self, body: SessionRequest) -> SessionResponse:") {
+		t.Fatalf("Python client missing create_session:\n%s", py)
+	}
+}
+
+func TestRunGenClientsRejectsUnknownLanguage(t *testing.T) {
+	var stderr bytes.Buffer
+	if code := runGenClients([]string{"-out", t.TempDir(), "-lang", "cobol"}, &stderr); code != 1 {
+		t.Fatalf("expected exit code 1, got %d", code)
+	}
+}
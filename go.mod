@@ -4,11 +4,16 @@ go 1.22
 
 toolchain go1.22.12
 
-require github.com/openai/openai-go/v3 v3.10.0
+require (
+	github.com/openai/openai-go/v3 v3.10.0
+	golang.org/x/crypto v0.33.0
+	golang.org/x/net v0.34.0
+)
 
 require (
 	github.com/tidwall/gjson v1.18.0 // indirect
 	github.com/tidwall/match v1.1.1 // indirect
 	github.com/tidwall/pretty v1.2.1 // indirect
 	github.com/tidwall/sjson v1.2.5 // indirect
+	golang.org/x/text v0.22.0 // indirect
 )
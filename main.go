@@ -1,127 +1,50 @@
+// Command openai-chatkit-backend mints ChatKit sessions for browser clients.
+// Services that want the handler on their own router can import the
+// chatkit/session package instead.
 package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"log"
-	"net/http"
 	"os"
 	"os/signal"
-	"strconv"
-	"strings"
 	"syscall"
 	"time"
 
-	"github.com/openai/openai-go/v3"
-	"github.com/openai/openai-go/v3/option"
+	"openai-chatkit-backend/chatkit/config"
+	"openai-chatkit-backend/chatkit/session"
 )
 
 const (
-	defaultAddr           = ":8080"
-	openaiRequestTimeout  = 15 * time.Second
-	serverShutdownTimeout = 5 * time.Second
-	maxRequestBodyBytes   = 4096
-	readTimeout           = 10 * time.Second
-	readHeaderTimeout     = 5 * time.Second
-	writeTimeout          = 15 * time.Second
-	idleTimeout           = 60 * time.Second
-	contentTypeJSON       = "application/json"
+	contentTypeJSON = "application/json"
+	// clientTimeout bounds each request the replay and selftest subcommands
+	// send.
+	clientTimeout = 15 * time.Second
 )
 
-var debugEnabled = func() bool {
-	v := strings.ToLower(os.Getenv("DEBUG"))
-	return v == "1" || v == "true" || v == "yes"
-}()
-
 func main() {
-	addr := getEnv("ADDR", defaultAddr)
-
-	apiKey := requireEnv("OPENAI_API_KEY")
-
-	opts := []option.RequestOption{option.WithAPIKey(apiKey)}
-	if baseURL := os.Getenv("OPENAI_BASE_URL"); baseURL != "" {
-		opts = append(opts, option.WithBaseURL(baseURL))
-	}
-
-	workflowID := requireEnv("CHATKIT_WORKFLOW_ID")
-	expiresAfterSeconds := requireEnvInt64("CHATKIT_EXPIRES_AFTER_SECONDS")
-	if expiresAfterSeconds < 0 {
-		log.Fatal("CHATKIT_EXPIRES_AFTER_SECONDS must be non-negative")
-	}
-	rateLimitPerMinute := requireEnvInt64("CHATKIT_RATE_LIMIT_PER_MINUTE")
-	if rateLimitPerMinute < 0 {
-		log.Fatal("CHATKIT_RATE_LIMIT_PER_MINUTE must be non-negative")
-	}
-
-	client := openai.NewClient(opts...)
-
-	sessionHandler := newSessionHandler(
-		func(ctx context.Context, params openai.BetaChatKitSessionNewParams) (*openai.ChatSession, error) {
-			return client.Beta.ChatKit.Sessions.New(ctx, params)
-		},
-		workflowID,
-		expiresAfterSeconds,
-		rateLimitPerMinute,
-	)
-
-	mux := newRouter(sessionHandler)
-
-	corsPolicy := newCORSPolicy(requireEnv("CORS_ALLOWED_ORIGINS"))
-
-	httpServer := &http.Server{
-		Addr:              addr,
-		Handler:           withCORS(corsPolicy, mux),
-		ReadTimeout:       readTimeout,
-		ReadHeaderTimeout: readHeaderTimeout,
-		WriteTimeout:      writeTimeout,
-		IdleTimeout:       idleTimeout,
-	}
-
-	go func() {
-		log.Printf("listening on %s", addr)
-		if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("server error: %v", err)
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "gen-clients":
+			os.Exit(runGenClients(os.Args[2:], os.Stderr))
+		case "replay":
+			os.Exit(runReplay(os.Args[2:], os.Stdout, os.Stderr))
+		case "selftest":
+			os.Exit(runSelftest(os.Args[2:], os.Stdout, os.Stderr))
 		}
-	}()
-
-	shutdown := make(chan os.Signal, 1)
-	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
-	<-shutdown
-
-	ctx, cancel := context.WithTimeout(context.Background(), serverShutdownTimeout)
-	defer cancel()
-	if err := httpServer.Shutdown(ctx); err != nil {
-		log.Printf("graceful shutdown failed: %v", err)
-	} else {
-		log.Println("server stopped")
 	}
-}
-
-func getEnv(key, fallback string) string {
-	if v := os.Getenv(key); v != "" {
-		return v
-	}
-	return fallback
-}
 
-func requireEnv(key string) string {
-	if v := os.Getenv(key); v != "" {
-		return v
-	}
-	log.Fatalf("%s is required", key)
-	return ""
-}
-
-func requireEnvInt64(key string) int64 {
-	v := requireEnv(key)
-	n, err := strconv.ParseInt(v, 10, 64)
-	if err != nil {
-		log.Fatalf("%s must be an integer: %v", key, err)
+	if err := config.LoadProfile(os.Args[1:], os.Stderr); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+		log.Fatalf("invalid configuration: %v", err)
 	}
-	return n
-}
-
-func debugf(format string, args ...any) {
-	if debugEnabled {
-		log.Printf("[debug] "+format, args...)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := session.Serve(ctx); err != nil {
+		log.Fatal(err)
 	}
 }
@@ -0,0 +1,18 @@
+// Package openapi embeds the server's OpenAPI document and the templates
+// the gen-clients command renders client libraries from.
+package openapi
+
+import (
+	"embed"
+	_ "embed"
+)
+
+// Document is the OpenAPI document for the default route paths.
+//
+//go:embed openapi.json
+var Document []byte
+
+// Templates holds the client library templates under templates/.
+//
+//go:embed templates/*.tmpl
+var Templates embed.FS
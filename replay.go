@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"time"
+
+	"openai-chatkit-backend/chatkit/session"
+)
+
+const replayMockTarget = "mock"
+
+// runReplay resends journaled requests to a target server and prints the
+// original and replayed status of each.
+func runReplay(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	journalPath := fs.String("journal", "", "journal to read: a CHATKIT_JOURNAL_FILE or a saved /admin/journal response")
+	target := fs.String("target", replayMockTarget, `base URL to replay against, or "mock" for an in-process server with mock sessions`)
+	ids := fs.String("id", "", "comma-separated entry IDs to replay (default: all)")
+	since := fs.String("since", "", "only replay entries at or after this RFC 3339 time")
+	until := fs.String("until", "", "only replay entries before this RFC 3339 time")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *journalPath == "" {
+		fmt.Fprintln(stderr, "replay: -journal is required")
+		return 2
+	}
+
+	filter, err := newReplayFilter(*ids, *since, *until)
+	if err != nil {
+		fmt.Fprintf(stderr, "replay: %v\n", err)
+		return 2
+	}
+	data, err := os.ReadFile(*journalPath)
+	if err != nil {
+		fmt.Fprintf(stderr, "replay: %v\n", err)
+		return 1
+	}
+	entries, err := parseJournal(data)
+	if err != nil {
+		fmt.Fprintf(stderr, "replay: %s: %v\n", *journalPath, err)
+		return 1
+	}
+
+	send, err := replaySender(*target)
+	if err != nil {
+		fmt.Fprintf(stderr, "replay: %v\n", err)
+		return 1
+	}
+	replayed := 0
+	for _, e := range entries {
+		if !filter.match(e) {
+			continue
+		}
+		replayed++
+		status, err := send(e)
+		if err != nil {
+			fmt.Fprintf(stdout, "%s %s %s %s: original %d, replay failed: %v\n", e.ID, e.Time.Format(time.RFC3339), e.Method, e.Path, e.Status, err)
+			continue
+		}
+		fmt.Fprintf(stdout, "%s %s %s %s: original %d, replayed %d\n", e.ID, e.Time.Format(time.RFC3339), e.Method, e.Path, e.Status, status)
+	}
+	fmt.Fprintf(stderr, "replayed %d of %d entries\n", replayed, len(entries))
+	return 0
+}
+
+type replayFilter struct {
+	ids          map[string]bool
+	since, until time.Time
+}
+
+func newReplayFilter(ids, since, until string) (replayFilter, error) {
+	var f replayFilter
+	for _, id := range strings.Split(ids, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			if f.ids == nil {
+				f.ids = make(map[string]bool)
+			}
+			f.ids[id] = true
+		}
+	}
+	var err error
+	if since != "" {
+		if f.since, err = time.Parse(time.RFC3339, since); err != nil {
+			return f, fmt.Errorf("invalid -since: %w", err)
+		}
+	}
+	if until != "" {
+		if f.until, err = time.Parse(time.RFC3339, until); err != nil {
+			return f, fmt.Errorf("invalid -until: %w", err)
+		}
+	}
+	return f, nil
+}
+
+func (f replayFilter) match(e session.JournalEntry) bool {
+	if f.ids != nil && !f.ids[e.ID] {
+		return false
+	}
+	if !f.since.IsZero() && e.Time.Before(f.since) {
+		return false
+	}
+	if !f.until.IsZero() && !e.Time.Before(f.until) {
+		return false
+	}
+	return true
+}
+
+// parseJournal reads JSON-lines journal files as well as a saved
+// /admin/journal response.
+func parseJournal(data []byte) ([]session.JournalEntry, error) {
+	var dump struct {
+		Entries []session.JournalEntry `json:"entries"`
+	}
+	if err := json.Unmarshal(data, &dump); err == nil && dump.Entries != nil {
+		return dump.Entries, nil
+	}
+
+	var entries []session.JournalEntry
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var e session.JournalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+// replayRequest rebuilds the journaled request. Redacted headers are left
+// out rather than sent as placeholders.
+func replayRequest(e session.JournalEntry, baseURL string) (*http.Request, error) {
+	url := strings.TrimRight(baseURL, "/") + e.Path
+	if e.Query != "" {
+		url += "?" + e.Query
+	}
+	req, err := http.NewRequest(e.Method, url, bytes.NewReader(e.Body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range e.Header {
+		for _, v := range values {
+			if v != session.RedactedValue {
+				req.Header.Add(name, v)
+			}
+		}
+	}
+	return req, nil
+}
+
+// replaySender returns a function that sends one entry to target.
+func replaySender(target string) (func(session.JournalEntry) (int, error), error) {
+	if target == replayMockTarget {
+		router, err := session.NewMockHandler("wf_replay")
+		if err != nil {
+			return nil, err
+		}
+		return func(e session.JournalEntry) (int, error) {
+			req, err := replayRequest(e, "http://replay.invalid")
+			if err != nil {
+				return 0, err
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			return rec.Code, nil
+		}, nil
+	}
+
+	if !strings.HasPrefix(target, "http://") && !strings.HasPrefix(target, "https://") {
+		return nil, fmt.Errorf("invalid -target %q: expected an http(s) URL or %q", target, replayMockTarget)
+	}
+	client := &http.Client{Timeout: clientTimeout}
+	return func(e session.JournalEntry) (int, error) {
+		req, err := replayRequest(e, target)
+		if err != nil {
+			return 0, err
+		}
+		res, err := client.Do(req)
+		if err != nil {
+			return 0, err
+		}
+		defer res.Body.Close()
+		_, _ = io.Copy(io.Discard, res.Body)
+		return res.StatusCode, nil
+	}, nil
+}
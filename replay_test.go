@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"openai-chatkit-backend/chatkit/session"
+)
+
+func TestRunReplayAgainstMock(t *testing.T) {
+	at := time.Date(2025, 3, 1, 14, 2, 0, 0, time.UTC)
+	dump, _ := json.Marshal(map[string]any{"entries": []session.JournalEntry{
+		{ID: "req_1", Time: at, Method: http.MethodPost, Path: "/api/chatkit/session", Body: json.RawMessage(`{"user":"u"}`), Status: http.StatusInternalServerError},
+		{ID: "req_2", Time: at.Add(time.Hour), Method: http.MethodPost, Path: "/api/chatkit/session", Body: json.RawMessage(`{}`), Status: http.StatusBadRequest},
+	}})
+	path := filepath.Join(t.TempDir(), "journal.json")
+	if err := os.WriteFile(path, dump, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := runReplay([]string{"-journal", path, "-until", "2025-03-01T15:00:00Z"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "req_1 2025-03-01T14:02:00Z POST /api/chatkit/session: original 500, replayed 200") {
+		t.Fatalf("unexpected replay output: %s", stdout.String())
+	}
+	if strings.Contains(stdout.String(), "req_2") {
+		t.Fatalf("entry outside the time filter was replayed: %s", stdout.String())
+	}
+}
+
+func TestRunReplayRequiresJournal(t *testing.T) {
+	if code := runReplay(nil, io.Discard, io.Discard); code != 2 {
+		t.Fatalf("expected exit code 2, got %d", code)
+	}
+}
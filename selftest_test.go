@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"openai-chatkit-backend/openapi"
+)
+
+// newSelftestTarget stands in for a deployment without a readiness probe
+// that allows https://app.example.com and the sandbox key sk_sandbox.
+func newSelftestTarget() *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status":"ok"}`))
+	})
+	mux.HandleFunc("OPTIONS "+sessionPath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Origin") == "https://app.example.com" {
+			w.Header().Set("Access-Control-Allow-Origin", "https://app.example.com")
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("POST "+sessionPath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(sandboxKeyHeader) != "sk_sandbox" {
+			http.Error(w, `{"error":"upstream down"}`, http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte(`{"client_secret":"sandbox_secret"}`))
+	})
+	mux.HandleFunc("GET /admin/config", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+	})
+	mux.HandleFunc("GET /openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(openapi.Document)
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestRunSelftest(t *testing.T) {
+	ts := newSelftestTarget()
+	defer ts.Close()
+
+	var stdout, stderr bytes.Buffer
+	code := runSelftest([]string{"--base-url", ts.URL, "-origin", "https://app.example.com", "-sandbox-key", "sk_sandbox"}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("expected success, got %d:\n%s%s", code, stdout.String(), stderr.String())
+	}
+	for _, want := range []string{"PASS health", "SKIP readiness", "PASS cors_preflight", "PASS auth_failure", "PASS session_mint", "PASS openapi"} {
+		if !strings.Contains(stdout.String(), want) {
+			t.Fatalf("expected %q in output:\n%s", want, stdout.String())
+		}
+	}
+
+	stdout.Reset()
+	if code := runSelftest([]string{"-base-url", ts.URL, "-sandbox-key", "wrong"}, &stdout, &stderr); code != 1 {
+		t.Fatalf("expected a failing mint to fail the suite, got %d:\n%s", code, stdout.String())
+	}
+	if code := runSelftest(nil, &stdout, &stderr); code != 2 {
+		t.Fatalf("expected a usage error without -base-url, got %d", code)
+	}
+}